@@ -0,0 +1,34 @@
+// Command ink-dap is a debug adapter for ink, speaking the Debug Adapter
+// Protocol over its standard input and output.
+//
+// Usage:
+//
+//	ink-dap
+//
+// Editors start it themselves, and launch it with the .ink or .ink.json
+// file to play as the program. It stops at breakpoints on lines of ink
+// or on entering knots, steps through the story a line at a time, and
+// stops at each choice for one to be taken from the debug console. The
+// call stack shows the story's tunnels, functions and threads, and its
+// global and temporary variables and evaluation stack can be inspected.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/SirMetathyst/go-ink/ink/dap"
+)
+
+func main() {
+
+	if len(os.Args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: ink-dap")
+		os.Exit(2)
+	}
+
+	if err := dap.NewServer().Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "ink-dap:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,31 @@
+// Command ink-lsp is a language server for ink, speaking the Language
+// Server Protocol over its standard input and output.
+//
+// Usage:
+//
+//	ink-lsp
+//
+// Editors start it themselves. It reports the compiler's errors and
+// warnings as diagnostics, and provides go-to-definition, references,
+// hover, document symbols and the completion of divert targets.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/SirMetathyst/go-ink/ink/lsp"
+)
+
+func main() {
+
+	if len(os.Args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: ink-lsp")
+		os.Exit(2)
+	}
+
+	if err := lsp.NewServer().Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "ink-lsp:", err)
+		os.Exit(1)
+	}
+}
@@ -8,26 +8,37 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 )
 
 func main() {
 
-	jsonBytes, err := os.ReadFile("TheIntercept.json")
+	storyFile, err := os.Open("TheIntercept.json")
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	story := runtime.NewStory(string(jsonBytes))
+	story, err := runtime.LoadStory(storyFile)
+	storyFile.Close()
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	story.OnError = new(runtime.ErrorHandlerEvent)
 	story.OnError.Register(func(message string, typ runtime.ErrorType) {
 		fmt.Printf("Error[%v]: %s\n", typ, message)
 	})
 
+	reader := bufio.NewReader(os.Stdin)
+
 	for {
 
 		for story.CanContinue() {
-			fmt.Println(story.ContinueMaximally())
+			text, err := story.ContinueMaximally()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			fmt.Println(text)
 		}
 
 		choices := story.CurrentChoices()
@@ -39,18 +50,20 @@ func main() {
 			fmt.Printf("%d: %s\n", i, choice.Text)
 		}
 
-		reader := bufio.NewReader(os.Stdin)
 		fmt.Print(">")
 		v, err := reader.ReadString('\n')
 		if err == io.EOF {
 			return
 		}
 
-		choiceIndex, err := strconv.Atoi(v[:len(v)-1])
-		if err != nil || choiceIndex < 0 || choiceIndex > len(choices) {
+		choiceIndex, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
 			fmt.Println(err)
+			continue
 		}
 
-		story.ChooseChoiceIndex(choiceIndex)
+		if err := story.ChooseChoiceIndex(choiceIndex); err != nil {
+			fmt.Println(err)
+		}
 	}
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// context
+// How many unchanged lines are shown around each change.
+const context = 3
+
+// edit
+// One line of a diff: ' ' for a line in both, '-' for a line removed and
+// '+' for a line added.
+type edit struct {
+	op   byte
+	text string
+}
+
+// lines
+// Splits source into lines, keeping the newline at the end of each.
+func lines(src []byte) []string {
+
+	l := strings.SplitAfter(string(src), "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+
+	return l
+}
+
+// diffLines
+// The shortest edit script from a to b, found with Myers' algorithm.
+func diffLines(a []string, b []string) []edit {
+
+	n, m := len(a), len(b)
+	max := n + m
+
+	// v[k] is the furthest x reached on diagonal k, and trace keeps v as
+	// it was before each round, from diagonal -d-1 to d+1
+	v := make([]int, 2*max+3)
+	offset := max + 1
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+
+	return nil
+}
+
+// backtrack
+// Follows the trace of diffLines back from the end of both inputs.
+func backtrack(a []string, b []string, trace [][]int) []edit {
+
+	var edits []edit
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{' ', a[x-1]})
+			x--
+			y--
+		}
+
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, edit{'+', b[y-1]})
+			} else {
+				edits = append(edits, edit{'-', a[x-1]})
+			}
+		}
+
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
+
+// writeDiff
+// Writes the differences between a and b in the unified format.
+func writeDiff(w io.Writer, nameA string, nameB string, a []string, b []string) {
+
+	edits := diffLines(a, b)
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", nameA, nameB)
+
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// A hunk runs from the context before a change to the context
+		// after the last change that's close enough to join it
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].op != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		end += context
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		// The line numbers the hunk starts at in a and b
+		lineA, lineB := 1, 1
+		for _, e := range edits[:start] {
+			if e.op != '+' {
+				lineA++
+			}
+			if e.op != '-' {
+				lineB++
+			}
+		}
+
+		countA, countB := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				countA++
+			}
+			if e.op != '-' {
+				countB++
+			}
+		}
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(lineA, countA), hunkRange(lineB, countB))
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(w, "%c%s", e.op, e.text)
+			if !strings.HasSuffix(e.text, "\n") {
+				fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = end
+	}
+}
+
+func hunkRange(line int, count int) string {
+
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line-1)
+	case 1:
+		return fmt.Sprint(line)
+	}
+
+	return fmt.Sprintf("%d,%d", line, count)
+}
@@ -0,0 +1,182 @@
+// Command inkfmt formats ink source in the canonical style.
+//
+// Usage:
+//
+//	inkfmt [flags] [path ...]
+//
+// Without a path, inkfmt formats its standard input. Directories are
+// searched for .ink files. By default the formatted source is printed; the
+// flags are:
+//
+//	-d  print a diff of the changes instead
+//	-l  list the files whose formatting differs instead
+//	-w  write the formatted source back to each file
+//
+// As with gofmt, a CI check can fail on any output from inkfmt -l.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SirMetathyst/go-ink/ink/ast"
+	"github.com/SirMetathyst/go-ink/ink/format"
+)
+
+const usage = `usage: inkfmt [flags] [path ...]
+
+flags:
+  -d  print a diff of the changes instead of the formatted source
+  -l  list the files whose formatting differs instead
+  -w  write the formatted source back to each file
+`
+
+var errUsage = errors.New("usage")
+
+// errFailed
+// Some files couldn't be formatted, and the errors have been printed.
+var errFailed = errors.New("failed")
+
+type options struct {
+	diff  bool
+	list  bool
+	write bool
+}
+
+func main() {
+
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		switch err {
+		case errUsage:
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		case errFailed:
+		default:
+			fmt.Fprintln(os.Stderr, "inkfmt:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run
+// Runs inkfmt with args. Errors with individual files are written to
+// stderr as they're found, and run returns errFailed once it's done.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+
+	var opts options
+
+	flags := flag.NewFlagSet("inkfmt", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.BoolVar(&opts.diff, "d", false, "")
+	flags.BoolVar(&opts.list, "l", false, "")
+	flags.BoolVar(&opts.write, "w", false, "")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if flags.NArg() == 0 {
+		if opts.write {
+			return errors.New("can't use -w on standard input")
+		}
+
+		src, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+
+		return processFile("<standard input>", src, opts, stdout)
+	}
+
+	failed := false
+	for _, root := range flags.Args() {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Files named on the command line are formatted whatever
+			// they're called
+			if d.IsDir() || (path != root && !strings.HasSuffix(path, ".ink")) {
+				return nil
+			}
+
+			src, err := os.ReadFile(path)
+			if err == nil {
+				err = processFile(path, src, opts, stdout)
+			}
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				failed = true
+			}
+
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return errFailed
+	}
+
+	return nil
+}
+
+// processFile
+// Formats the source of one file, and prints, lists or writes it
+// depending on the options.
+func processFile(name string, src []byte, opts options, stdout io.Writer) error {
+
+	out, err := format.Source(src)
+	if err != nil {
+		var list ast.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				e.FileName = name
+			}
+			return list
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if bytes.Equal(src, out) {
+		if !opts.list && !opts.diff && !opts.write {
+			_, err = stdout.Write(out)
+		}
+		return err
+	}
+
+	if opts.list {
+		fmt.Fprintln(stdout, name)
+	}
+
+	if opts.write {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, out, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	if opts.diff {
+		fmt.Fprintf(stdout, "diff -u %s.orig %s\n", name, name)
+		writeDiff(stdout, name+".orig", name, lines(src), lines(out))
+	}
+
+	if !opts.list && !opts.diff && !opts.write {
+		_, err = stdout.Write(out)
+	}
+
+	return err
+}
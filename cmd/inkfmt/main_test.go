@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	unformatted = "== knot ==\nHello\n*   [A]  ->  END\n"
+	formatted   = "== knot ==\nHello\n* [A] -> END\n"
+	invalid     = "== knot ==\n{\n"
+)
+
+// runInkfmt
+// Runs inkfmt with args and stdin, returning what it printed to stdout
+// and stderr.
+func runInkfmt(t *testing.T, stdin string, args ...string) (string, string, error) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout, &stderr)
+
+	return stdout.String(), stderr.String(), err
+}
+
+// writeFiles
+// Writes each file in a temporary directory, returning the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, src := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0644))
+	}
+
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	src, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return string(src)
+}
+
+func TestRunUsage(t *testing.T) {
+
+	_, _, err := runInkfmt(t, "", "-x")
+	assert.Equal(t, errUsage, err)
+
+	_, _, err = runInkfmt(t, unformatted, "-w")
+	assert.EqualError(t, err, "can't use -w on standard input")
+}
+
+func TestRunStdin(t *testing.T) {
+
+	for _, src := range []string{unformatted, formatted} {
+		stdout, stderr, err := runInkfmt(t, src)
+		require.NoError(t, err)
+		assert.Equal(t, formatted, stdout)
+		assert.Empty(t, stderr)
+	}
+
+	stdout, _, err := runInkfmt(t, unformatted, "-l")
+	require.NoError(t, err)
+	assert.Equal(t, "<standard input>\n", stdout)
+
+	stdout, _, err = runInkfmt(t, formatted, "-l")
+	require.NoError(t, err)
+	assert.Empty(t, stdout)
+
+	stdout, _, err = runInkfmt(t, invalid)
+	assert.ErrorContains(t, err, "<standard input>:2:")
+	assert.Empty(t, stdout)
+}
+
+func TestRunList(t *testing.T) {
+
+	dir := writeFiles(t, map[string]string{
+		"a.ink":     unformatted,
+		"b.ink":     formatted,
+		"notes.txt": unformatted,
+	})
+
+	stdout, stderr, err := runInkfmt(t, "", "-l", dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "a.ink")+"\n", stdout)
+	assert.Empty(t, stderr)
+
+	// Files named on the command line are formatted whatever they're called
+	stdout, _, err = runInkfmt(t, "", "-l", filepath.Join(dir, "notes.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "notes.txt")+"\n", stdout)
+
+	// Nothing is written
+	assert.Equal(t, unformatted, readFile(t, filepath.Join(dir, "a.ink")))
+}
+
+func TestRunDiff(t *testing.T) {
+
+	dir := writeFiles(t, map[string]string{"a.ink": unformatted, "b.ink": formatted})
+	path := filepath.Join(dir, "a.ink")
+
+	expected := "diff -u " + path + ".orig " + path + "\n" +
+		"--- " + path + ".orig\n" +
+		"+++ " + path + "\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		" == knot ==\n" +
+		" Hello\n" +
+		"-*   [A]  ->  END\n" +
+		"+* [A] -> END\n"
+
+	stdout, stderr, err := runInkfmt(t, "", "-d", dir)
+	require.NoError(t, err)
+	assert.Equal(t, expected, stdout)
+	assert.Empty(t, stderr)
+
+	assert.Equal(t, unformatted, readFile(t, path))
+}
+
+func TestRunWrite(t *testing.T) {
+
+	dir := writeFiles(t, map[string]string{"a.ink": unformatted, "b.ink": formatted})
+
+	stdout, stderr, err := runInkfmt(t, "", "-w", "-l", dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "a.ink")+"\n", stdout)
+	assert.Empty(t, stderr)
+
+	assert.Equal(t, formatted, readFile(t, filepath.Join(dir, "a.ink")))
+	assert.Equal(t, formatted, readFile(t, filepath.Join(dir, "b.ink")))
+
+	// Once written, there's nothing left to change
+	stdout, _, err = runInkfmt(t, "", "-l", dir)
+	require.NoError(t, err)
+	assert.Empty(t, stdout)
+}
+
+func TestRunInvalid(t *testing.T) {
+
+	dir := writeFiles(t, map[string]string{"a.ink": unformatted, "bad.ink": invalid})
+
+	// The other files are still formatted
+	stdout, stderr, err := runInkfmt(t, "", "-w", dir)
+	assert.Equal(t, errFailed, err)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, filepath.Join(dir, "bad.ink")+":2:")
+
+	assert.Equal(t, formatted, readFile(t, filepath.Join(dir, "a.ink")))
+	assert.Equal(t, invalid, readFile(t, filepath.Join(dir, "bad.ink")))
+
+	_, stderr, err = runInkfmt(t, "", filepath.Join(dir, "missing.ink"))
+	assert.Equal(t, errFailed, err)
+	assert.Contains(t, stderr, "missing.ink")
+}
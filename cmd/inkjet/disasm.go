@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+	"github.com/SirMetathyst/go-ink/runtime/inkjet"
+	"github.com/SirMetathyst/go-ink/runtime/inkjet/vm"
+)
+
+func disasm(args []string, stdout io.Writer) error {
+
+	story, err := loadStory(args[0])
+	if err != nil {
+		return err
+	}
+
+	program, err := vm.Compile(story)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(stdout)
+	disassemble(w, program)
+
+	return w.Flush()
+}
+
+// disassemble
+// Lists the program's instructions, one per line, with a label at the
+// start of each container that isn't inline in its parent.
+func disassemble(w io.Writer, program *vm.Program) {
+
+	for pc, instruction := range program.Instructions {
+
+		if instruction.Op == inkjet.OpCodeVIS && instruction.Index == -1 {
+			fmt.Fprintf(w, "\n%s:\n", containerLabel(program, instruction.Operand))
+		}
+
+		line := fmt.Sprintf("%6d  %-3s  %s", pc, inkjet.EnumNamesOpCode[instruction.Op], describeOperand(program, instruction))
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
+
+func containerLabel(program *vm.Program, container int) string {
+
+	if path := program.Containers[container].Path; path != "" {
+		return path
+	}
+
+	return "(root)"
+}
+
+func describeTarget(program *vm.Program, target int) string {
+
+	if target == -1 {
+		return "-> ?"
+	}
+
+	return "-> " + strconv.Itoa(program.TargetPc(target))
+}
+
+// describeOperand
+// What the instruction works on, in a readable form.
+func describeOperand(program *vm.Program, instruction vm.Instruction) string {
+
+	switch instruction.Op {
+
+	case inkjet.OpCodeVIS, inkjet.OpCodeCNT:
+		return fmt.Sprintf("#%d %s", instruction.Operand, containerLabel(program, instruction.Operand))
+
+	case inkjet.OpCodeJMP, inkjet.OpCodeJIF, inkjet.OpCodeTUN, inkjet.OpCodeCAL:
+		divert := instruction.Object.(*runtime.Divert)
+		return describeTarget(program, instruction.Operand) + "  ; " + divert.TargetPathString()
+
+	case inkjet.OpCodeJPV:
+		return "-> $" + instruction.Object.(*runtime.Divert).VariableDivertName
+
+	case inkjet.OpCodeEXT:
+		divert := instruction.Object.(*runtime.Divert)
+		return fmt.Sprintf("%s/%d", divert.TargetPathString(), divert.ExternalArgs)
+
+	case inkjet.OpCodeCHO:
+		choicePoint := instruction.Object.(*runtime.ChoicePoint)
+		return describeTarget(program, instruction.Operand) + "  ; " + choicePoint.PathOnChoice().String()
+
+	case inkjet.OpCodeTXT:
+		return strconv.Quote(instruction.Object.(*runtime.StringValue).Value())
+
+	case inkjet.OpCodeGET:
+		return instruction.Object.(*runtime.VariableReference).Name
+
+	case inkjet.OpCodeSET:
+		return instruction.Object.(*runtime.VariableAssignment).VariableName()
+
+	case inkjet.OpCodeNAT, inkjet.OpCodeVAL, inkjet.OpCodeTAG:
+		return fmt.Sprint(instruction.Object)
+	}
+
+	return ""
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+func dump(args []string, stdout io.Writer) error {
+
+	story, err := loadStory(args[0])
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(stdout)
+	dumpContainer(w, story.MainContentContainer(), 0)
+
+	return w.Flush()
+}
+
+// dumpContainer
+// Prints the container's path and count flags, then its child containers,
+// indented, in content order and then the named-only ones by name.
+func dumpContainer(w io.Writer, container *runtime.Container, depth int) {
+
+	path := container.Path(container).String()
+	if path == "" {
+		path = "(root)"
+	}
+
+	fmt.Fprintf(w, "%s%s%s  (%d items)\n", strings.Repeat("  ", depth), path, countFlags(container), len(container.Content()))
+
+	for _, obj := range container.Content() {
+		if child, ok := obj.(*runtime.Container); ok {
+			dumpContainer(w, child, depth+1)
+		}
+	}
+
+	namedOnlyContent := container.NamedOnlyContent()
+	names := make([]string, 0, len(namedOnlyContent))
+	for name := range namedOnlyContent {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if child, ok := namedOnlyContent[name].(*runtime.Container); ok {
+			dumpContainer(w, child, depth+1)
+		}
+	}
+}
+
+func countFlags(container *runtime.Container) string {
+
+	var flags []string
+	if container.VisitsShouldBeCounted {
+		flags = append(flags, "visits")
+	}
+	if container.TurnIndexShouldBeCounted {
+		flags = append(flags, "turns")
+	}
+	if container.CountingAtStartOnly {
+		flags = append(flags, "start-only")
+	}
+
+	if len(flags) == 0 {
+		return ""
+	}
+
+	return " [" + strings.Join(flags, " ") + "]"
+}
@@ -1,131 +1,109 @@
+// Command inkjet converts, inspects and disassembles compiled ink stories.
+//
+// Usage:
+//
+//	inkjet convert story.ink.json story.inkb
+//	inkjet dump story
+//	inkjet disasm story
+//	inkjet verify story.ink.json [story.inkb]
+//
+// Stories can be given in either the JSON or the binary format, except
+// where noted.
 package main
 
 import (
+	"errors"
 	"fmt"
-	flatbuffers "github.com/google/flatbuffers/go"
+	"io"
+	"os"
+
+	"github.com/SirMetathyst/go-ink/runtime"
 )
 
+const usage = `usage: inkjet <command> [arguments]
+
+commands:
+  convert story.ink.json story.inkb   convert a story to the binary format
+  dump story                          print the container hierarchy
+  disasm story                        list the story's compiled instructions
+  verify story.ink.json [story.inkb]  check that the binary form has the same
+                                      structure as the JSON, converting it
+                                      if no binary file is given
+`
+
+type command struct {
+	run     func(args []string, stdout io.Writer) error
+	minArgs int
+	maxArgs int
+}
+
+var commands = map[string]command{
+	"convert": {run: convert, minArgs: 2, maxArgs: 2},
+	"dump":    {run: dump, minArgs: 1, maxArgs: 1},
+	"disasm":  {run: disasm, minArgs: 1, maxArgs: 1},
+	"verify":  {run: verify, minArgs: 1, maxArgs: 2},
+}
+
+var errUsage = errors.New("usage")
+
 func main() {
 
-	// WRITE
-
-	builder := flatbuffers.NewBuilder(0)
-
-	// Start Instruction 1
-	builder.StartObject(3)
-	builder.PrependInt8Slot(0, int8(10), 0) // OpCode: 10
-	builder.PrependInt32Slot(1, 20, 0)      // Operand 1: 20
-	builder.PrependInt32Slot(2, 40, 0)      // Operand 2: 40
-	element1 := builder.EndObject()
-	// End Instruction 1
-
-	// Start Instruction 2
-	builder.StartObject(4)
-	builder.PrependInt8Slot(0, int8(30), 0)   // OpCode: 30
-	builder.PrependFloat32Slot(1, 128.555, 0) // Operand 1: 128.555
-	builder.PrependFloat32Slot(2, 572.892, 0) // Operand 2: 572.892
-	builder.PrependFloat32Slot(3, 0.892, 0)   // Operand 2: 572.892
-	element2 := builder.EndObject()
-	// End Instruction 2
-
-	// Start Instruction 3
-	builder.StartObject(3)
-	builder.PrependInt8Slot(0, int8(10), 0) // OpCode: 10
-	builder.PrependInt32Slot(1, 100, 0)     // Operand 1: 100
-	builder.PrependInt32Slot(2, 200, 0)     // Operand 2: 200
-	element3 := builder.EndObject()
-	// End Instruction 3
-
-	// Start Instruction Vector
-	builder.StartVector(4, 3, 4)         // Length of 3 Instruction
-	builder.PrependUOffsetT(element3)    // Prepend Element 3
-	builder.PrependUOffsetT(element2)    // Prepend Element 2
-	builder.PrependUOffsetT(element1)    // Prepend Element 1
-	instructions := builder.EndVector(3) // Length of 3 Instruction
-	// End Instruction Vector
-
-	// Start Program
-	builder.StartObject(2)
-
-	// Prepend Version
-	builder.PrependInt32Slot(0, 1, 0)
-
-	// Prepend Instruction Vector
-	builder.PrependUOffsetTSlot(1, flatbuffers.UOffsetT(instructions), 0)
-
-	// End Program
-	program := builder.EndObject()
-
-	// Complete flatbuffer
-	builder.Finish(program)
-	buf := builder.FinishedBytes()
-
-	fmt.Println(buf)
-
-	// READ
-	n := flatbuffers.GetUOffsetT(buf[0:])
-	tab := new(flatbuffers.Table)
-	tab.Pos = n
-	tab.Bytes = buf
-
-	// Version
-	if o := flatbuffers.UOffsetT(tab.Offset(4)); o != 0 {
-		fmt.Println("Version: ", tab.GetInt32(o+tab.Pos))
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if err == errUsage {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "inkjet:", err)
+		os.Exit(1)
 	}
+}
 
-	// Instruction Length
-	if o := flatbuffers.UOffsetT(tab.Offset(6)); o != 0 {
-		fmt.Println("Instruction Length: ", tab.VectorLen(o))
-
-		lastPos := tab.Pos // Remember original position
-		// j: index of instruction
-		for j := 0; j < tab.VectorLen(o); j++ {
-
-			if o := flatbuffers.UOffsetT(tab.Offset(6)); o != 0 {
-
-				x := tab.Vector(o)
-				x += flatbuffers.UOffsetT(j) * 4
-				x = tab.Indirect(x)
-				tab.Pos = x // Goto offset
-
-				// OpCode (If It Exists)
-				if o := flatbuffers.UOffsetT(tab.Offset(4)); o != 0 {
-					fmt.Println("OpCode: ", tab.GetInt8(o+tab.Pos))
-
-					switch tab.GetInt8(o + tab.Pos) {
-
-					case 10:
-						// Operand 1 (If It Exists)
-						if o := flatbuffers.UOffsetT(tab.Offset(6)); o != 0 {
-							fmt.Println("Operand 1: ", tab.GetInt32(o+tab.Pos))
-						}
-
-						// Operand 2 (If It Exists)
-						if o := flatbuffers.UOffsetT(tab.Offset(8)); o != 0 {
-							fmt.Println("Operand 2: ", tab.GetInt32(o+tab.Pos))
-						}
-
-					case 30:
-						// Operand 1 (If It Exists)
-						if o := flatbuffers.UOffsetT(tab.Offset(6)); o != 0 {
-							fmt.Println("Operand 1: ", tab.GetFloat32(o+tab.Pos))
-						}
-
-						// Operand 2 (If It Exists)
-						if o := flatbuffers.UOffsetT(tab.Offset(8)); o != 0 {
-							fmt.Println("Operand 2: ", tab.GetFloat32(o+tab.Pos))
-						}
-
-						// Operand 3 (If It Exists)
-						if o := flatbuffers.UOffsetT(tab.Offset(10)); o != 0 {
-							fmt.Println("Operand 3: ", tab.GetFloat32(o+tab.Pos))
-						}
-					}
-				}
-			}
-
-			// Reset position
-			tab.Pos = lastPos
-		}
+func run(args []string, stdout io.Writer) error {
+
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok || len(args)-1 < cmd.minArgs || len(args)-1 > cmd.maxArgs {
+		return errUsage
 	}
+
+	return cmd.run(args[1:], stdout)
+}
+
+// loadStory
+// Loads a story from either the JSON or the binary format.
+func loadStory(path string) (*runtime.Story, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if runtime.IsBinaryStory(data) {
+		return runtime.NewStoryFromBinary(data)
+	}
+
+	return runtime.NewStory(string(data))
+}
+
+func convert(args []string, stdout io.Writer) error {
+
+	story, err := loadStory(args[0])
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+
+	if err := story.WriteBinary(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	gluePath    = "../../runtime/testdata/conformance/glue.ink.json"
+	tunnelsPath = "../../runtime/testdata/conformance/tunnels.ink.json"
+)
+
+// runInkjet
+// Runs inkjet with args, returning what it printed.
+func runInkjet(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	var stdout bytes.Buffer
+	err := run(args, &stdout)
+
+	return stdout.String(), err
+}
+
+// convertStory
+// Converts the story at path into a binary file in a temporary directory.
+func convertStory(t *testing.T, path string) string {
+	t.Helper()
+
+	binaryPath := filepath.Join(t.TempDir(), "story.inkb")
+	out, err := runInkjet(t, "convert", path, binaryPath)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	return binaryPath
+}
+
+func TestRunUsage(t *testing.T) {
+
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"dump"},
+		{"dump", "a", "b"},
+		{"convert", "a"},
+		{"verify", "a", "b", "c"},
+	} {
+		_, err := runInkjet(t, args...)
+		assert.Equal(t, errUsage, err, args)
+	}
+}
+
+func TestConvert(t *testing.T) {
+
+	binaryPath := convertStory(t, gluePath)
+
+	data, err := os.ReadFile(binaryPath)
+	require.NoError(t, err)
+	require.True(t, runtime.IsBinaryStory(data))
+
+	story, err := runtime.NewStoryFromBinary(data)
+	require.NoError(t, err)
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Some content\nwith glue.\nA line joined by glue.\nGlue works across diverts.\n", text)
+
+	_, err = runInkjet(t, "convert", filepath.Join(t.TempDir(), "missing.ink.json"), binaryPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestDump(t *testing.T) {
+
+	expected := `(root) [visits]  (2 items)
+  0  (9 items)
+    0.g-0  (1 items)
+  elsewhere [visits]  (3 items)
+  knot [visits]  (9 items)
+`
+
+	// Either format gives the same hierarchy
+	for _, path := range []string{gluePath, convertStory(t, gluePath)} {
+		out, err := runInkjet(t, "dump", path)
+		require.NoError(t, err, path)
+		assert.Equal(t, expected, out, path)
+	}
+}
+
+func TestDisasm(t *testing.T) {
+
+	out, err := runInkjet(t, "disasm", gluePath)
+	require.NoError(t, err)
+
+	assert.Contains(t, out, "\n(root):\n     0  VIS  #0 (root)\n")
+	assert.Contains(t, out, "\n     9  JMP  -> 20  ; knot\n")
+	assert.Contains(t, out, "\nknot:\n    19  VIS  #4 knot\n")
+	assert.Contains(t, out, "\n    28  JMP  -> 15  ; elsewhere\n")
+	assert.Contains(t, out, "\n    17  END\n")
+}
+
+func TestVerify(t *testing.T) {
+
+	// Converted as convert would
+	out, err := runInkjet(t, "verify", gluePath)
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", out)
+
+	out, err = runInkjet(t, "verify", gluePath, convertStory(t, gluePath))
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", out)
+
+	out, err = runInkjet(t, "verify", gluePath, convertStory(t, tunnelsPath))
+	assert.ErrorContains(t, err, "binary and JSON stories differ at root")
+	assert.Empty(t, out)
+
+	binaryPath := convertStory(t, gluePath)
+	_, err = runInkjet(t, "verify", binaryPath)
+	assert.EqualError(t, err, binaryPath+" is in the binary format, not JSON")
+}
+
+func TestFirstDifference(t *testing.T) {
+
+	expected := map[string]interface{}{"a": []interface{}{1, "x"}, "b": 2.5}
+
+	_, ok := firstDifference("", expected, map[string]interface{}{"a": []interface{}{1, "x"}, "b": 2.5})
+	assert.True(t, ok)
+
+	for actual, path := range map[string]string{
+		`{"a":[1,"y"],"b":2.5}`:          `a[1] (x != y)`,
+		`{"a":[1],"b":2.5}`:              `a`,
+		`{"a":[1,"x"]}`:                  `b (2.5 != <nil>)`,
+		`{"a":[1,"x"],"b":2.5,"c":true}`: `c (<nil> != true)`,
+	} {
+		parsed, err := runtime.TextToDictionary(actual)
+		require.NoError(t, err)
+
+		diff, ok := firstDifference("", expected, parsed)
+		assert.False(t, ok, actual)
+		assert.Equal(t, path, diff, actual)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+func verify(args []string, stdout io.Writer) error {
+
+	jsonData, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if runtime.IsBinaryStory(jsonData) {
+		return fmt.Errorf("%s is in the binary format, not JSON", args[0])
+	}
+
+	jsonStory, err := runtime.NewStory(string(jsonData))
+	if err != nil {
+		return err
+	}
+
+	// Without a binary file, check the conversion that convert would do
+	var binaryData []byte
+	if len(args) > 1 {
+		if binaryData, err = os.ReadFile(args[1]); err != nil {
+			return err
+		}
+	} else {
+		var buf bytes.Buffer
+		if err := jsonStory.WriteBinary(&buf); err != nil {
+			return err
+		}
+		binaryData = buf.Bytes()
+	}
+
+	binaryStory, err := runtime.NewStoryFromBinary(binaryData)
+	if err != nil {
+		return err
+	}
+
+	expected, err := runtime.TextToDictionary(jsonStory.ToJson())
+	if err != nil {
+		return err
+	}
+	actual, err := runtime.TextToDictionary(binaryStory.ToJson())
+	if err != nil {
+		return err
+	}
+
+	if path, ok := firstDifference("", expected, actual); !ok {
+		return fmt.Errorf("binary and JSON stories differ at %s", path)
+	}
+
+	fmt.Fprintln(stdout, "ok")
+	return nil
+}
+
+// firstDifference
+// Compares two parsed JSON values, returning the path to the first place
+// where they differ, if they do.
+func firstDifference(path string, expected interface{}, actual interface{}) (string, bool) {
+
+	switch expected := expected.(type) {
+
+	case map[string]interface{}:
+		actual, ok := actual.(map[string]interface{})
+		if !ok {
+			return path, false
+		}
+
+		keys := make([]string, 0, len(expected)+len(actual))
+		for key := range expected {
+			keys = append(keys, key)
+		}
+		for key := range actual {
+			if _, ok := expected[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			if diff, ok := firstDifference(keyPath, expected[key], actual[key]); !ok {
+				return diff, false
+			}
+		}
+
+	case []interface{}:
+		actual, ok := actual.([]interface{})
+		if !ok || len(actual) != len(expected) {
+			return path, false
+		}
+
+		for i := range expected {
+			if diff, ok := firstDifference(path+"["+strconv.Itoa(i)+"]", expected[i], actual[i]); !ok {
+				return diff, false
+			}
+		}
+
+	default:
+		if !reflect.DeepEqual(expected, actual) {
+			return fmt.Sprintf("%s (%v != %v)", path, expected, actual), false
+		}
+	}
+
+	return path, true
+}
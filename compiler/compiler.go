@@ -0,0 +1,155 @@
+// Package compiler compiles ink source into the runtime's object graph, as
+// inklecate does, so that a story can be built in-process without going
+// through .ink.json.
+//
+//	story, err := compiler.Compile(source, nil)
+//
+// The compiled story is the same tree of Containers, Diverts, ChoicePoints
+// and ControlCommands that runtime.NewStory builds from inklecate's JSON,
+// and every object carries the DebugMetadata of the source it came from.
+package compiler
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/SirMetathyst/go-ink/ink/ast"
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// Options
+// Controls how a story is compiled. A nil *Options is the same as the
+// zero value.
+type Options struct {
+
+	// FileName
+	// The name of the root source file, used for DebugMetadata and in
+	// error messages.
+	FileName string
+
+	// FS
+	// Where INCLUDE files are read from. Their names are relative to the
+	// root of FS. Without an FS, INCLUDE is an error.
+	FS fs.FS
+
+	// CountAllVisits
+	// Also counts visits to gathers and choices that are never read by
+	// name, as inklecate's -c flag does. Knots and stitches are always
+	// counted.
+	CountAllVisits bool
+
+	// Warn
+	// Called with each warning, such as for a loose end where the flow
+	// runs out. Warnings don't stop the story compiling.
+	Warn func(err *Error)
+}
+
+// Error
+// A problem found in the ink source, at the position it was found.
+type Error = ast.Error
+
+// ErrorList
+// Every error found while compiling, in source order. Compile returns
+// an ErrorList when there is at least one.
+type ErrorList = ast.ErrorList
+
+// Compile
+// Compiles the ink source of a story and returns it ready to play.
+func Compile(source string, options *Options) (*runtime.Story, error) {
+
+	if options == nil {
+		options = new(Options)
+	}
+
+	c := newCompiler(options)
+
+	file, err := ast.Parse(options.FileName, source, &ast.Options{FS: options.FS, Warn: options.Warn})
+	if errs, ok := err.(ast.ErrorList); ok {
+		c.errors = append(c.errors, errs...)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return c.compile(c.newStory(file))
+}
+
+// CompileFile
+// Compiles the story whose root file is name in fsys. INCLUDE files are
+// read from fsys as well.
+func CompileFile(fsys fs.FS, name string, options *Options) (*runtime.Story, error) {
+
+	source, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	opts.FS = fsys
+	if opts.FileName == "" {
+		opts.FileName = name
+	}
+
+	return Compile(string(source), &opts)
+}
+
+// compiler
+// The state for one compilation.
+type compiler struct {
+	options *Options
+	errors  ErrorList
+}
+
+func newCompiler(options *Options) *compiler {
+	return &compiler{options: options}
+}
+
+func (s *compiler) error(at ast.Span, format string, args ...interface{}) {
+
+	s.errors = append(s.errors, &Error{
+		FileName: at.FileName,
+		Line:     at.StartLineNumber,
+		Column:   at.StartCharacterNumber,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (s *compiler) warning(at ast.Span, format string, args ...interface{}) {
+
+	if s.options.Warn == nil {
+		return
+	}
+
+	s.options.Warn(&Error{
+		FileName: at.FileName,
+		Line:     at.StartLineNumber,
+		Column:   at.StartCharacterNumber,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// compile
+// Generates the runtime content for a parsed story.
+func (s *compiler) compile(story *flow) (*runtime.Story, error) {
+
+	var root *runtime.Container
+	var lists []*runtime.ListDefinition
+
+	if len(s.errors) == 0 {
+		root, lists = newGenerator(s, story).generate()
+	}
+
+	if len(s.errors) > 0 {
+		s.errors.Sort()
+		return nil, s.errors
+	}
+
+	runtimeStory := runtime.NewStoryFrom(root, lists)
+	if err := runtimeStory.ResetState(); err != nil {
+		return nil, err
+	}
+
+	return runtimeStory, nil
+}
@@ -0,0 +1,437 @@
+package compiler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/SirMetathyst/go-ink/internal/conformance"
+	"github.com/SirMetathyst/go-ink/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const conformanceDir = "../runtime/testdata/conformance"
+
+// conformanceNames
+// The runtime conformance stories that compile.
+func conformanceNames(t *testing.T) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(conformanceDir, "*.ink"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	var names []string
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".ink")
+
+		// Hand-written to check runtime errors, so it doesn't compile
+		if name != "errors" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// TestCompileConformance
+// Compiles the source of each runtime conformance story and checks it
+// plays the same as the story inklecate compiled.
+func TestCompileConformance(t *testing.T) {
+
+	for _, name := range conformanceNames(t) {
+		t.Run(name, func(t *testing.T) {
+
+			golden, err := os.ReadFile(filepath.Join(conformanceDir, name+".golden"))
+			require.NoError(t, err)
+
+			commands, err := conformance.ReadScript(filepath.Join(conformanceDir, name+".script"))
+			require.NoError(t, err)
+
+			run := &compiledStory{t: t, name: name}
+			run.story = run.newStory()
+
+			transcript := &conformance.Transcript{Story: run}
+			transcript.Play()
+			for _, command := range commands {
+				require.NoError(t, transcript.Exec(command))
+			}
+
+			assert.Equal(t, string(golden), transcript.String())
+		})
+	}
+}
+
+// compiledStory
+// Plays a conformance story compiled from its source for a
+// conformance.Transcript, with the same game-side setup as the runtime's
+// conformance tests.
+type compiledStory struct {
+	t     *testing.T
+	name  string
+	story *runtime.Story
+}
+
+func (s *compiledStory) newStory() *runtime.Story {
+
+	story, err := CompileFile(os.DirFS(conformanceDir), s.name+".ink", nil)
+	require.NoError(s.t, err)
+
+	story.State().StorySeed = 0
+	story.AllowExternalFunctionFallbacks = true
+
+	require.NoError(s.t, runtime.BindExternalFunction2(story, "multiply", func(a, b int) int { return a * b }, true))
+	require.NoError(s.t, runtime.BindExternalFunction0(story, "greeting", func() string { return "Hello from Go" }, true))
+
+	return story
+}
+
+func (s *compiledStory) CanContinue() bool {
+	return s.story.CanContinue()
+}
+
+func (s *compiledStory) Continue() (string, error) {
+	return s.story.Continue()
+}
+
+func (s *compiledStory) CurrentTags() []string {
+	return s.story.CurrentTags()
+}
+
+func (s *compiledStory) CurrentChoices() []conformance.Choice {
+
+	var choices []conformance.Choice
+	for _, choice := range s.story.CurrentChoices() {
+		choices = append(choices, conformance.Choice{Index: choice.Index, Text: choice.Text, Tags: choice.Tags})
+	}
+
+	return choices
+}
+
+func (s *compiledStory) ChooseChoiceIndex(index int) error {
+	return s.story.ChooseChoiceIndex(index)
+}
+
+func (s *compiledStory) ChoosePathString(path string) error {
+	return s.story.ChoosePathString(path, true)
+}
+
+func (s *compiledStory) SwitchFlow(flowName string) error {
+	return s.story.SwitchFlow(flowName)
+}
+
+func (s *compiledStory) SwitchToDefaultFlow() error {
+	return s.story.SwitchToDefaultFlow()
+}
+
+func (s *compiledStory) RemoveFlow(flowName string) error {
+	return s.story.RemoveFlow(flowName)
+}
+
+func (s *compiledStory) Reload() error {
+
+	saved, err := s.story.State().ToJson()
+	if err != nil {
+		return err
+	}
+
+	s.story = s.newStory()
+
+	return s.story.State().LoadJson(saved)
+}
+
+// playAll
+// Compiles the source and plays it, choosing the choices in turn.
+func playAll(t *testing.T, source string, choices ...int) string {
+
+	story, err := Compile(source, nil)
+	require.NoError(t, err)
+
+	var out strings.Builder
+	for {
+		text, err := story.ContinueMaximally()
+		require.NoError(t, err)
+		out.WriteString(text)
+
+		if len(choices) == 0 || len(story.CurrentChoices()) == 0 {
+			return out.String()
+		}
+
+		require.NoError(t, story.ChooseChoiceIndex(choices[0]))
+		choices = choices[1:]
+	}
+}
+
+func TestCompileWeave(t *testing.T) {
+
+	source := `
+Start.
+* One
+  ** Inner one
+  ** Inner two
+  -- Inner gather.
+* [Two] Chose two.
+- Gathered.
+* (again) Again
+  -> after
+- -> END
+
+== after ==
+{again: Read {again} time.}
+-> END
+`
+
+	assert.Equal(t, "Start.\nOne\nInner two\nInner gather.\nGathered.\nAgain\nRead 1 time.\n", playAll(t, source, 0, 1, 0))
+	assert.Equal(t, "Start.\nChose two.\nGathered.\nAgain\nRead 1 time.\n", playAll(t, source, 1, 0))
+}
+
+func TestCompileChoiceContent(t *testing.T) {
+
+	source := `
+VAR n = 2
+* Hello [there] world {n}
+* {n > 5} Hidden
+- -> END
+`
+
+	story, err := Compile(source, nil)
+	require.NoError(t, err)
+
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+
+	require.Len(t, story.CurrentChoices(), 1)
+	assert.Equal(t, "Hello there", story.CurrentChoices()[0].Text)
+
+	require.NoError(t, story.ChooseChoiceIndex(0))
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello world 2\n", text)
+}
+
+func TestCompileFallbackChoice(t *testing.T) {
+
+	source := `
+- (top)
+* [Once] Once.
+  -> top
+* ->
+  Fallback.
+- Done.
+-> END
+`
+
+	assert.Equal(t, "Once.\nFallback.\nDone.\n", playAll(t, source, 0))
+}
+
+func TestCompileLogic(t *testing.T) {
+
+	source := `
+CONST limit = 3
+VAR total = 0
+~ temp i = 0
+- (loop)
+~ i++
+~ total += add(i, limit)
+{i < limit: -> loop}
+{total}
+{
+  - total > 100: Big.
+  - else: Small.
+}
+{total:
+  - 15: Fifteen.
+  - 18: Eighteen.
+  - else: Other.
+}
+{stopping: -> knot(-> ending)}
+
+== knot(-> target) ==
+Diverting.
+-> target
+
+== ending ==
+{&a|b} {!once} {~only}
+-> END
+
+== function add(a, b) ==
+~ return a + b
+`
+
+	assert.Equal(t, "15\nSmall.\nFifteen.\nDiverting.\na once only\n", playAll(t, source))
+}
+
+func TestCompileSequences(t *testing.T) {
+
+	source := `
+- (top)
+{stopping:
+  - First.
+  - Second.
+}
+{!A|B} {&X|Y|Z}
+{top < 3: -> top}
+-> END
+`
+
+	assert.Equal(t, "First.\nA X\nSecond.\nB Y\nSecond.\nZ\n", playAll(t, source))
+}
+
+func TestCompileTunnelsAndThreads(t *testing.T) {
+
+	source := `
+-> tunnel(1) ->
+<- options
+* [Main] Main.
+  -> END
+
+== tunnel(x) ==
+Tunnel {x}.
+->->
+
+== options ==
+* [Threaded] Threaded.
+  -> END
+`
+
+	story, err := Compile(source, nil)
+	require.NoError(t, err)
+
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Tunnel 1.\n", text)
+
+	require.Len(t, story.CurrentChoices(), 2)
+	assert.Equal(t, "Threaded", story.CurrentChoices()[0].Text)
+	assert.Equal(t, "Main", story.CurrentChoices()[1].Text)
+}
+
+func TestCompileInclude(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"main.ink":         {Data: []byte("INCLUDE chapters/one.ink\nMain.\n-> one\n")},
+		"chapters/one.ink": {Data: []byte("== one ==\nChapter one.\n-> END\n")},
+	}
+
+	story, err := CompileFile(fsys, "main.ink", nil)
+	require.NoError(t, err)
+
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Main.\nChapter one.\n", text)
+
+	_, err = Compile("INCLUDE one.ink\n", nil)
+	assert.Error(t, err)
+}
+
+func TestCompileDebugMetadata(t *testing.T) {
+
+	source := "Hello.\n-> knot\n\n== knot ==\nIn the knot.\n-> END\n"
+
+	story, err := Compile(source, &Options{FileName: "story.ink"})
+	require.NoError(t, err)
+
+	knot := story.KnotContainerWithName("knot")
+	require.NotNil(t, knot)
+
+	dm := knot.DebugMetadata()
+	require.NotNil(t, dm)
+	assert.Equal(t, "story.ink", dm.FileName)
+	assert.Equal(t, 4, dm.StartLineNumber)
+
+	text := knot.Content()[0]
+	require.NotNil(t, text.DebugMetadata())
+	assert.Equal(t, 5, text.DebugMetadata().StartLineNumber)
+
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+}
+
+func TestCompileErrors(t *testing.T) {
+
+	tests := []struct {
+		source  string
+		line    int
+		message string
+	}{
+		{"Hello.\n{missing}\n", 2, "unresolved variable: 'missing'"},
+		{"-> nowhere\n", 1, "divert target not found: 'nowhere'"},
+		{"~ x = 1\n", 1, "variable not found: 'x'"},
+		{"{f()}\n", 1, "unresolved function: 'f'"},
+		{"== a ==\n-> END\n== a ==\n-> END\n", 3, "a knot called 'a' has already been declared on line 1"},
+		{"{x\n", 1, "expected '}' to close the '{' on line 1"},
+	}
+
+	for _, test := range tests {
+		_, err := Compile(test.source, &Options{FileName: "test.ink"})
+		require.Error(t, err, test.source)
+
+		var list ErrorList
+		require.True(t, errors.As(err, &list))
+		assert.Equal(t, "test.ink", list[0].FileName)
+		assert.Equal(t, test.line, list[0].Line, test.source)
+		assert.Equal(t, test.message, list[0].Message, test.source)
+	}
+}
+
+func TestCompileWarnings(t *testing.T) {
+
+	var warnings []*Error
+	options := &Options{Warn: func(err *Error) { warnings = append(warnings, err) }}
+
+	_, err := Compile("-> knot\n== knot ==\nRuns out.\n", options)
+	require.NoError(t, err)
+
+	require.Len(t, warnings, 1)
+	assert.Equal(t, 2, warnings[0].Line)
+}
+
+func TestCompileToJson(t *testing.T) {
+
+	source := `
+LIST colours = red, (green), blue
+VAR c = green
+* [Pick] {c} {LIST_VALUE(c)}
+  -> END
+`
+
+	story, err := Compile(source, nil)
+	require.NoError(t, err)
+
+	loaded, err := runtime.NewStory(story.ToJson())
+	require.NoError(t, err)
+
+	_, err = loaded.ContinueMaximally()
+	require.NoError(t, err)
+	require.NoError(t, loaded.ChooseChoiceIndex(0))
+
+	text, err := loaded.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "green 2\n", text)
+}
+
+// TestCompileMatchesInklecate
+// Compiles the source of each runtime conformance story and checks it
+// gives the same JSON as inklecate did.
+func TestCompileMatchesInklecate(t *testing.T) {
+
+	for _, name := range conformanceNames(t) {
+		t.Run(name, func(t *testing.T) {
+
+			compiled, err := CompileFile(os.DirFS(conformanceDir), name+".ink", nil)
+			require.NoError(t, err)
+
+			source, err := os.ReadFile(filepath.Join(conformanceDir, name+".ink.json"))
+			require.NoError(t, err)
+			story, err := runtime.NewStory(string(source))
+			require.NoError(t, err)
+
+			// ToJson is canonical, so the same story gives the same JSON
+			assert.Equal(t, story.ToJson(), compiled.ToJson())
+		})
+	}
+}
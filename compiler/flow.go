@@ -0,0 +1,117 @@
+package compiler
+
+import "github.com/SirMetathyst/go-ink/ink/ast"
+
+// Flows
+//
+// The generator works on flows: the story itself, knots, stitches and
+// functions. A flow has the statements of its body, with declarations
+// taken out, and the top level of the story has the content of its
+// included files in place of the INCLUDEs.
+
+type flowKind int
+
+const (
+	flowStory flowKind = iota
+	flowKnot
+	flowStitch
+	flowFunction
+)
+
+// flow
+// The story itself, a knot, a stitch or a function.
+type flow struct {
+	ast.Span
+	kind     flowKind
+	name     string
+	params   []*ast.Param
+	body     []ast.Node
+	stitches []*flow
+	parent   *flow
+
+	// Only on the story: knots, functions and declarations
+	knots     []*flow
+	globals   []*ast.VarDecl
+	lists     []*ast.ListDecl
+	externals []*ast.External
+}
+
+// newStory
+// The flows of a parsed file and the files it includes.
+func (s *compiler) newStory(file *ast.File) *flow {
+
+	story := &flow{Span: file.Span, kind: flowStory}
+	s.addFile(story, file)
+
+	// Declarations can be anywhere, even in included files
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.VarDecl:
+			story.globals = append(story.globals, n)
+		case *ast.ListDecl:
+			story.lists = append(story.lists, n)
+		case *ast.External:
+			story.externals = append(story.externals, n)
+		}
+		return true
+	})
+
+	return story
+}
+
+// addFile
+// Adds the top-level content and knots of a file to the story.
+func (s *compiler) addFile(story *flow, file *ast.File) {
+
+	for _, n := range file.Body {
+		switch n := n.(type) {
+		case *ast.Include:
+			if n.File != nil {
+				s.addFile(story, n.File)
+			} else if s.options.FS == nil {
+				s.error(n.Span, "can't include '%s' without a file system to read it from", n.Name)
+			}
+
+		case *ast.Knot:
+			knot := &flow{Span: n.Span, kind: flowKnot, name: n.Name, params: n.Params, body: statements(n.Body), parent: story}
+			for _, st := range n.Stitches {
+				stitch := &flow{Span: st.Span, kind: flowStitch, name: st.Name, params: st.Params, body: statements(st.Body), parent: knot}
+				knot.stitches = append(knot.stitches, stitch)
+			}
+			story.knots = append(story.knots, knot)
+
+		case *ast.Function:
+			function := &flow{Span: n.Span, kind: flowFunction, name: n.Name, params: n.Params, body: statements(n.Body), parent: story}
+			story.knots = append(story.knots, function)
+
+		default:
+			if isStatement(n) {
+				story.body = append(story.body, n)
+			}
+		}
+	}
+}
+
+// statements
+// The nodes of a body that generate content.
+func statements(nodes []ast.Node) []ast.Node {
+
+	var body []ast.Node
+	for _, n := range nodes {
+		if isStatement(n) {
+			body = append(body, n)
+		}
+	}
+
+	return body
+}
+
+func isStatement(n ast.Node) bool {
+
+	switch n.(type) {
+	case *ast.VarDecl, *ast.ListDecl, *ast.External, *ast.Todo, *ast.Include:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,452 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/SirMetathyst/go-ink/ink/ast"
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+func (s *generator) generateNodes(c *container, nodes []ast.Node) {
+
+	for _, n := range nodes {
+		s.generateNode(c, n)
+	}
+}
+
+// generateNode
+// Generates anything other than a choice or gather into c.
+func (s *generator) generateNode(c *container, n ast.Node) {
+
+	switch n := n.(type) {
+	case *ast.Text:
+		s.emit(c, n, runtime.NewStringValueFromString(n.Value))
+
+	case *ast.Glue:
+		s.emit(c, n, runtime.NewGlue())
+
+	case *ast.Tag:
+		s.emit(c, n, runtime.NewBeginTagCommand())
+		s.generateNodes(c, n.Content)
+		s.emit(c, n, runtime.NewEndTagCommand())
+
+	case *ast.Divert:
+		s.generateDivert(c, n)
+
+	case *ast.Output:
+		s.emit(c, n, runtime.NewEvalStartCommand())
+		s.expression(c, n.Value)
+		s.emit(c, n, runtime.NewEvalOutputCommand(), runtime.NewEvalEndCommand())
+
+	case *ast.Conditional:
+		c.add(s.generateConditional(n))
+
+	case *ast.Sequence:
+		c.add(s.generateSequence(n))
+
+	case *ast.Assignment:
+		s.generateAssignment(c, n)
+
+	case *ast.Return:
+		if s.flow.kind != flowFunction {
+			s.c.error(n.Span, "return can only be used in a function")
+		}
+
+		s.emit(c, n, runtime.NewEvalStartCommand())
+		if n.Value != nil {
+			s.expression(c, n.Value)
+		} else {
+			s.emit(c, n, runtime.NewVoid())
+		}
+		s.emit(c, n, runtime.NewEvalEndCommand(), runtime.NewPopFunctionCommand())
+
+	case *ast.ExprStatement:
+		s.emit(c, n, runtime.NewEvalStartCommand())
+		s.expression(c, n.Value)
+		s.emit(c, n, runtime.NewPopEvaluatedValueCommand(), runtime.NewEvalEndCommand())
+
+	case *ast.Choice, *ast.Gather:
+		s.c.error(n.Pos(), "choices and gathers can't be used here")
+	}
+}
+
+// generateBlock
+// The content of a branch of a conditional or sequence. A multiline block
+// is a weave of its own.
+func (s *generator) generateBlock(nodes []ast.Node, multiline bool) *container {
+
+	if multiline {
+		c, _ := s.generateWeave(nodes, 0)
+		return c
+	}
+
+	c := &container{}
+	s.generateNodes(c, nodes)
+
+	return c
+}
+
+// Diverts
+
+func (s *generator) generateDivert(c *container, d *ast.Divert) {
+
+	if d.Kind == ast.DivertTunnelReturn {
+		s.generateTunnelReturn(c, d)
+		return
+	}
+
+	if len(d.Target) == 1 && (d.Target[0] == "END" || d.Target[0] == "DONE") {
+		if d.Kind != ast.DivertNormal || d.Args != nil {
+			s.c.error(d.Span, "can't use %s as a tunnel or thread, or pass it arguments", d.Target[0])
+			return
+		}
+
+		if d.Target[0] == "END" {
+			s.emit(c, d, runtime.NewEndCommand())
+		} else {
+			s.emit(c, d, runtime.NewDoneCommand())
+		}
+		return
+	}
+
+	rd := runtime.NewDivert()
+
+	var params []*ast.Param
+	if len(d.Target) == 1 && s.isVariable(d.Target[0]) {
+		rd.VariableDivertName = d.Target[0]
+	} else {
+		target := s.resolve(d.Target)
+		if target == nil {
+			s.c.error(d.Span, "divert target not found: '%s'", joinPath(d.Target))
+			return
+		}
+
+		if fl, ok := target.(*flow); ok {
+			params = fl.params
+			if d.Kind != ast.DivertTunnel && fl.kind == flowFunction {
+				s.c.error(d.Span, "'%s' is a function, so it can't be diverted to", fl.name)
+			}
+			if len(d.Args) != len(params) {
+				s.c.error(d.Span, "'%s' takes %d arguments, but %d were given", fl.name, len(params), len(d.Args))
+				return
+			}
+		} else if len(d.Args) > 0 {
+			s.c.error(d.Span, "'%s' can't be passed arguments", joinPath(d.Target))
+			return
+		}
+
+		s.refer(target, rd.SetTargetPath)
+	}
+
+	if len(d.Args) > 0 {
+		s.emit(c, d, runtime.NewEvalStartCommand())
+		s.arguments(c, params, d.Args)
+		s.emit(c, d, runtime.NewEvalEndCommand())
+	}
+
+	switch d.Kind {
+	case ast.DivertThread:
+		s.emit(c, d, runtime.NewStartThreadCommand())
+	case ast.DivertTunnel:
+		rd.PushesToStack = true
+		rd.StackPushType = runtime.Tunnel
+	}
+
+	s.emit(c, d, rd)
+}
+
+// generateTunnelReturn
+// ->-> returns from a tunnel, to where it was called or to the target
+// given instead.
+func (s *generator) generateTunnelReturn(c *container, d *ast.Divert) {
+
+	s.emit(c, d, runtime.NewEvalStartCommand())
+
+	switch {
+	case d.Target == nil:
+		s.emit(c, d, runtime.NewVoid())
+
+	case len(d.Args) > 0:
+		s.c.error(d.Span, "can't pass arguments when returning from a tunnel")
+
+	default:
+		s.divertTargetValue(c, d, d.Target)
+	}
+
+	s.emit(c, d, runtime.NewEvalEndCommand(), runtime.NewPopTunnelCommand())
+}
+
+// arguments
+// Generates the arguments of a divert or function call. A ref parameter
+// is passed a pointer to the variable, and a divert parameter can be
+// passed the name of a knot without the ->.
+func (s *generator) arguments(c *container, params []*ast.Param, args []ast.Expr) {
+
+	for i, arg := range args {
+
+		var p *ast.Param
+		if i < len(params) {
+			p = params[i]
+		}
+
+		v, isVariable := arg.(*ast.Variable)
+
+		if p != nil && p.IsRef {
+			if !isVariable || len(v.Path) != 1 || !s.isVariable(v.Path[0]) {
+				s.c.error(arg.Pos(), "'%s' is a ref parameter, so it has to be passed a variable", p.Name)
+				continue
+			}
+			s.emit(c, arg, runtime.NewVariablePointerValueFromValue(v.Path[0], -1))
+			continue
+		}
+
+		if p != nil && p.IsDivert && isVariable && !(len(v.Path) == 1 && s.isVariable(v.Path[0])) {
+			s.divertTargetValue(c, arg, v.Path)
+			continue
+		}
+
+		s.expression(c, arg)
+	}
+}
+
+// divertTargetValue
+// -> target as a value, or the value of a variable that holds one.
+func (s *generator) divertTargetValue(c *container, at ast.Node, path []string) *fixup {
+
+	if len(path) == 1 && s.isVariable(path[0]) {
+		s.emit(c, at, runtime.NewVariableReferenceFromName(path[0]))
+		return &fixup{}
+	}
+
+	target := s.resolve(path)
+	if target == nil {
+		s.c.error(at.Pos(), "divert target not found: '%s'", joinPath(path))
+		return &fixup{}
+	}
+
+	value := runtime.NewDivertTargetValueFromPath(nil)
+	s.emit(c, at, value)
+
+	return s.refer(target, value.SetTargetPath)
+}
+
+// Conditionals and sequences
+
+// generateConditional
+// Each branch has a divert into its content, which is conditional for
+// all but an else branch, and the content diverts back to the no-op at
+// the end.
+//
+// With an initial value and branches with values of their own, it's a
+// switch: each branch compares a duplicate of the initial value with its
+// own, and whichever branch is taken pops the original.
+func (s *generator) generateConditional(n *ast.Conditional) *container {
+
+	c := &container{meta: s.debugMetadata(n)}
+
+	if n.Initial != nil {
+		s.emit(c, n, runtime.NewEvalStartCommand())
+		s.expression(c, n.Initial)
+		s.emit(c, n, runtime.NewEvalEndCommand())
+	}
+
+	isSwitch := n.Initial != nil && len(n.Branches) > 0 && !n.Branches[0].IsTrue
+	rejoin := runtime.NewNoOpCommand()
+
+	for _, b := range n.Branches {
+
+		bc := &container{meta: s.debugMetadata(b)}
+
+		duplicates := isSwitch && !b.IsElse
+		if duplicates {
+			s.emit(bc, b, runtime.NewDuplicateCommand())
+		}
+
+		if !b.IsTrue && !b.IsElse {
+			s.emit(bc, b, runtime.NewEvalStartCommand())
+			s.expression(bc, b.Condition)
+			if isSwitch {
+				s.emit(bc, b, runtime.NewNativeFunctionCallFromName("=="))
+			}
+			s.emit(bc, b, runtime.NewEvalEndCommand())
+		}
+
+		d := runtime.NewDivert()
+		d.IsConditional = !b.IsElse
+		s.emit(bc, b, d)
+
+		content := s.generateBlock(b.Content, n.Multiline)
+		content.name = "b"
+		content.meta = s.debugMetadata(b)
+
+		// A multiline branch starts on a new line, as the condition might
+		// have been false
+		var prefix []interface{}
+		if duplicates || (b.IsElse && isSwitch) {
+			prefix = append(prefix, s.emitted(b, runtime.NewPopEvaluatedValueCommand()))
+		}
+		if n.Multiline {
+			prefix = append(prefix, s.emitted(b, runtime.NewStringValueFromString("\n")))
+		}
+		content.content = append(prefix, content.content...)
+
+		back := runtime.NewDivert()
+		s.emit(content, b, back)
+		s.refer(rejoin, back.SetTargetPath)
+
+		bc.addNamed(content)
+		s.refer(content, d.SetTargetPath)
+
+		c.add(bc)
+	}
+
+	// Nothing matched, so the switch value is still on the stack
+	if isSwitch && !n.Branches[len(n.Branches)-1].IsElse {
+		s.emit(c, n, runtime.NewPopEvaluatedValueCommand())
+	}
+
+	s.emit(c, n, rejoin)
+	return c
+}
+
+// emitted
+// obj, with the debug metadata of the source at.
+func (s *generator) emitted(at ast.Node, obj runtime.Object) runtime.Object {
+
+	obj.SetDebugMetadata(s.debugMetadata(at))
+	return obj
+}
+
+// generateSequence
+// The sequence's container counts its visits, and the visit index picks
+// the element to divert to. Once-only sequences have an extra empty
+// element for when they've run out, and shuffles pick the element at
+// random.
+func (s *generator) generateSequence(n *ast.Sequence) *container {
+
+	c := &container{visits: true, startOnly: true, meta: s.debugMetadata(n)}
+
+	once := n.Kind&ast.SequenceOnce != 0
+	cycle := n.Kind&ast.SequenceCycle != 0
+	stopping := n.Kind&ast.SequenceStopping != 0
+	shuffle := n.Kind&ast.SequenceShuffle != 0
+
+	count := len(n.Elements)
+	branches := count
+	if once {
+		branches++
+	}
+
+	s.emit(c, n, runtime.NewEvalStartCommand(), runtime.NewVisitIndexCommand())
+
+	if stopping || once {
+		s.emit(c, n, runtime.NewIntValueFromInt(branches-1), runtime.NewNativeFunctionCallFromName("MIN"))
+	} else if cycle {
+		s.emit(c, n, runtime.NewIntValueFromInt(count), runtime.NewNativeFunctionCallFromName("%"))
+	}
+
+	if shuffle {
+		postShuffle := runtime.NewNoOpCommand()
+
+		// The last element isn't shuffled once it's been reached
+		if once || stopping {
+			last := count
+			if stopping {
+				last = count - 1
+			}
+
+			skip := runtime.NewDivert()
+			skip.IsConditional = true
+
+			s.emit(c, n, runtime.NewDuplicateCommand(), runtime.NewIntValueFromInt(last), runtime.NewNativeFunctionCallFromName("=="), skip)
+			s.refer(postShuffle, skip.SetTargetPath)
+		}
+
+		shuffled := count
+		if stopping {
+			shuffled--
+		}
+		s.emit(c, n, runtime.NewIntValueFromInt(shuffled), runtime.NewSequenceShuffleIndexCommand())
+
+		if once || stopping {
+			s.emit(c, n, postShuffle)
+		}
+	}
+
+	s.emit(c, n, runtime.NewEvalEndCommand())
+
+	end := runtime.NewNoOpCommand()
+
+	for i := 0; i < branches; i++ {
+
+		d := runtime.NewDivert()
+		d.IsConditional = true
+		s.emit(c, n, runtime.NewEvalStartCommand(), runtime.NewDuplicateCommand(), runtime.NewIntValueFromInt(i), runtime.NewNativeFunctionCallFromName("=="), runtime.NewEvalEndCommand(), d)
+
+		var element *container
+		if i < count {
+			element = s.generateBlock(n.Elements[i], n.Multiline)
+		} else {
+			element = &container{}
+		}
+		element.name = fmt.Sprintf("s%d", i)
+		element.content = append([]interface{}{s.emitted(n, runtime.NewPopEvaluatedValueCommand())}, element.content...)
+
+		back := runtime.NewDivert()
+		s.emit(element, n, back)
+
+		c.addNamed(element)
+		s.refer(element, d.SetTargetPath)
+		s.refer(end, back.SetTargetPath)
+	}
+
+	s.emit(c, n, end)
+	return c
+}
+
+// Logic
+
+// generateAssignment
+//
+//	~ temp x = value
+//	~ x = value
+//	~ x += value
+//	~ x++
+func (s *generator) generateAssignment(c *container, a *ast.Assignment) {
+
+	isNew := a.IsTemp
+	isGlobal := false
+
+	if !a.IsTemp {
+		if decl, ok := s.globals[a.Name]; ok && decl.IsConst && !s.isLocal(a.Name) {
+			s.c.error(a.Span, "can't assign to the constant '%s'", a.Name)
+			return
+		}
+
+		isGlobal = !s.isLocal(a.Name) && s.isGlobal(a.Name)
+		if !isGlobal && !s.isLocal(a.Name) {
+			s.c.error(a.Span, "variable not found: '%s'", a.Name)
+			return
+		}
+	}
+
+	s.emit(c, a, runtime.NewEvalStartCommand())
+
+	switch a.Op {
+	case "=":
+		s.expression(c, a.Value)
+
+	case "+=", "-=":
+		s.emit(c, a, runtime.NewVariableReferenceFromName(a.Name))
+		s.expression(c, a.Value)
+		s.emit(c, a, runtime.NewNativeFunctionCallFromName(a.Op[:1]))
+
+	case "++", "--":
+		s.emit(c, a, runtime.NewVariableReferenceFromName(a.Name), runtime.NewIntValueFromInt(1), runtime.NewNativeFunctionCallFromName(a.Op[:1]))
+	}
+
+	assignment := runtime.NewVariableAssignment(a.Name, isNew)
+	assignment.IsGlobal = isGlobal
+
+	s.emit(c, a, runtime.NewEvalEndCommand(), assignment)
+}
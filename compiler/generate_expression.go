@@ -0,0 +1,285 @@
+package compiler
+
+import (
+	"strings"
+
+	"github.com/SirMetathyst/go-ink/ink/ast"
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// builtinCommands
+// The built-in functions that are control commands, by the number of
+// arguments they take.
+var builtinCommands = map[string]struct {
+	args    int
+	command func() *runtime.ControlCommand
+}{
+	"CHOICE_COUNT": {0, runtime.NewChoiceCountCommand},
+	"TURNS":        {0, runtime.NewTurnsCommand},
+	"TURNS_SINCE":  {1, runtime.NewTurnsSinceCommand},
+	"READ_COUNT":   {1, runtime.NewReadCountCommand},
+	"RANDOM":       {2, runtime.NewRandomCommand},
+	"SEED_RANDOM":  {1, runtime.NewSeedRandomCommand},
+	"LIST_RANGE":   {3, runtime.NewListRangeCommand},
+	"LIST_RANDOM":  {1, runtime.NewListRandomCommand},
+}
+
+// builtinFunctions
+// The built-in functions that are native function calls, by the number
+// of arguments they take.
+var builtinFunctions = map[string]int{
+	"FLOOR":       1,
+	"CEILING":     1,
+	"INT":         1,
+	"FLOAT":       1,
+	"POW":         2,
+	"MIN":         2,
+	"MAX":         2,
+	"LIST_VALUE":  1,
+	"LIST_COUNT":  1,
+	"LIST_MIN":    1,
+	"LIST_MAX":    1,
+	"LIST_ALL":    1,
+	"LIST_INVERT": 1,
+}
+
+func joinPath(path []string) string {
+	return strings.Join(path, ".")
+}
+
+// expression
+// Generates code that leaves the value of e on the evaluation stack. The
+// caller is responsible for starting evaluation.
+func (s *generator) expression(c *container, e ast.Expr) {
+
+	switch e := e.(type) {
+	case *ast.Number:
+		switch v := e.Value.(type) {
+		case int:
+			s.emit(c, e, runtime.NewIntValueFromInt(v))
+		case float64:
+			s.emit(c, e, runtime.NewFloatValueFromFloat(v))
+		}
+
+	case *ast.Bool:
+		s.emit(c, e, runtime.NewBoolValueFromBool(e.Value))
+
+	case *ast.String:
+		s.emit(c, e, runtime.NewBeginStringCommand())
+		s.generateNodes(c, e.Content)
+		s.emit(c, e, runtime.NewEndStringCommand())
+
+	case *ast.DivertTarget:
+		s.divertTargetValue(c, e, e.Target)
+
+	case *ast.Variable:
+		s.variable(c, e)
+
+	case *ast.Call:
+		s.call(c, e)
+
+	case *ast.List:
+		s.list(c, e)
+
+	case *ast.Unary:
+		s.expression(c, e.Value)
+		s.emit(c, e, runtime.NewNativeFunctionCallFromName(e.Op))
+
+	case *ast.Binary:
+		s.expression(c, e.Left)
+		s.expression(c, e.Right)
+		s.emit(c, e, runtime.NewNativeFunctionCallFromName(e.Op))
+	}
+}
+
+// variable
+// A name in an expression is, in order of precedence, a constant, a
+// temporary variable or parameter, a list item, the read count of a knot,
+// stitch or label, or a global variable.
+func (s *generator) variable(c *container, v *ast.Variable) {
+
+	name := v.Path[0]
+
+	if len(v.Path) == 1 && !s.isLocal(name) {
+		if decl, ok := s.globals[name]; ok && decl.IsConst {
+			s.constant(c, v, decl)
+			return
+		}
+	}
+
+	if len(v.Path) == 1 && s.isLocal(name) {
+		s.emit(c, v, runtime.NewVariableReferenceFromName(name))
+		return
+	}
+
+	if len(v.Path) <= 2 {
+		listName, itemName := "", v.Path[0]
+		if len(v.Path) == 2 {
+			listName, itemName = v.Path[0], v.Path[1]
+		}
+
+		if decl, item := s.listItem(v, listName, itemName); item != nil {
+			s.emit(c, v, runtime.NewListValueFromInkListItem(runtime.NewInkListItem(decl.Name, item.Name), item.Value))
+			return
+		}
+	}
+
+	if target := s.resolve(v.Path); target != nil {
+		ref := runtime.NewVariableReference()
+		s.emit(c, v, ref)
+		s.refer(target, func(path *runtime.Path) { ref.PathForCount = path }).visits = true
+		return
+	}
+
+	if len(v.Path) == 1 && s.isGlobal(name) {
+		s.emit(c, v, runtime.NewVariableReferenceFromName(name))
+		return
+	}
+
+	if len(v.Path) > 1 {
+		s.c.error(v.Span, "could not find target for read count: '%s'", joinPath(v.Path))
+		return
+	}
+
+	s.c.error(v.Span, "unresolved variable: '%s'", name)
+}
+
+// constant
+// Constants have no runtime variable, so their value is generated in
+// place.
+func (s *generator) constant(c *container, at ast.Node, decl *ast.VarDecl) {
+
+	if s.expanding[decl.Name] {
+		s.c.error(at.Pos(), "the constant '%s' is defined in terms of itself", decl.Name)
+		return
+	}
+
+	s.expanding[decl.Name] = true
+	s.expression(c, decl.Value)
+	delete(s.expanding, decl.Name)
+}
+
+// list
+// A list literal such as (a, b).
+func (s *generator) list(c *container, e *ast.List) {
+
+	list := runtime.NewInkList()
+
+	for _, path := range e.Items {
+
+		listName, itemName := "", path[0]
+		if len(path) == 2 {
+			listName, itemName = path[0], path[1]
+		}
+
+		decl, item := s.listItem(e, listName, itemName)
+		if item == nil {
+			s.c.error(e.Span, "could not find a list that has the item '%s'", joinPath(path))
+			continue
+		}
+
+		key := runtime.NewInkListItem(decl.Name, item.Name)
+		if list.ContainsKey(key) {
+			s.c.warning(e.Span, "duplicate of item '%s' in list", joinPath(path))
+			continue
+		}
+		list.Set(key, item.Value)
+	}
+
+	s.emit(c, e, runtime.NewListValueFromList(list))
+}
+
+// call
+// A call to a built-in function, an external function, a function knot,
+// or a list name used to turn a number into an item of the list.
+func (s *generator) call(c *container, e *ast.Call) {
+
+	if builtin, ok := builtinCommands[e.Name]; ok {
+		if !s.checkArgs(e, len(e.Args), builtin.args) {
+			return
+		}
+
+		for _, arg := range e.Args {
+
+			// The target of TURNS_SINCE and READ_COUNT has to be counted
+			if target, ok := arg.(*ast.DivertTarget); ok && (e.Name == "TURNS_SINCE" || e.Name == "READ_COUNT") {
+				f := s.divertTargetValue(c, target, target.Target)
+				f.turns = e.Name == "TURNS_SINCE"
+				f.visits = e.Name == "READ_COUNT"
+				continue
+			}
+
+			s.expression(c, arg)
+		}
+
+		s.emit(c, e, builtin.command())
+		return
+	}
+
+	if args, ok := builtinFunctions[e.Name]; ok {
+		if !s.checkArgs(e, len(e.Args), args) {
+			return
+		}
+
+		for _, arg := range e.Args {
+			s.expression(c, arg)
+		}
+
+		s.emit(c, e, runtime.NewNativeFunctionCallFromName(e.Name))
+		return
+	}
+
+	if ext, ok := s.externals[e.Name]; ok {
+		if !s.checkArgs(e, len(e.Args), len(ext.Params)) {
+			return
+		}
+
+		s.arguments(c, ext.Params, e.Args)
+
+		d := runtime.NewDivert()
+		d.IsExternal = true
+		d.ExternalArgs = len(e.Args)
+		d.SetTargetPathString(e.Name)
+		s.emit(c, e, d)
+		return
+	}
+
+	if _, ok := s.lists[e.Name]; ok {
+		if !s.checkArgs(e, len(e.Args), 1) {
+			return
+		}
+
+		s.emit(c, e, runtime.NewStringValueFromString(e.Name))
+		s.expression(c, e.Args[0])
+		s.emit(c, e, runtime.NewListFromIntCommand())
+		return
+	}
+
+	knot, ok := s.knots[e.Name]
+	if !ok {
+		s.c.error(e.Span, "unresolved function: '%s'", e.Name)
+		return
+	}
+
+	if !s.checkArgs(e, len(e.Args), len(knot.params)) {
+		return
+	}
+
+	s.arguments(c, knot.params, e.Args)
+
+	d := runtime.NewDivert()
+	d.PushesToStack = true
+	d.StackPushType = runtime.Function
+	s.emit(c, e, d)
+	s.refer(knot, d.SetTargetPath)
+}
+
+func (s *generator) checkArgs(e *ast.Call, given int, expected int) bool {
+
+	if given != expected {
+		s.c.error(e.Span, "'%s' takes %d arguments, but %d were given", e.Name, expected, given)
+		return false
+	}
+
+	return true
+}
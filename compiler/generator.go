@@ -0,0 +1,693 @@
+package compiler
+
+import (
+	"github.com/SirMetathyst/go-ink/ink/ast"
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// Code generation
+//
+// The parse tree is turned into runtime content in the same way as
+// inklecate: each construct is generated into a container of its own, and
+// once the whole story has been generated, the unnamed containers that only
+// group content are flattened into their parents. References between
+// content are resolved last of all, when the runtime containers exist and
+// their paths are known.
+
+// container
+// A container as it's generated, before it becomes a runtime.Container.
+type container struct {
+	name string
+
+	// runtime.Objects and *containers, in order
+	content []interface{}
+
+	// Containers that can only be reached by name
+	named []*container
+
+	visits    bool
+	turns     bool
+	startOnly bool
+	meta      *runtime.DebugMetadata
+
+	// The runtime container, once it's been built
+	runtime *runtime.Container
+}
+
+func (s *container) add(content ...interface{}) {
+	s.content = append(s.content, content...)
+}
+
+func (s *container) addNamed(named *container) {
+	s.named = append(s.named, named)
+}
+
+// inline
+// Moves the content and named content of inner into the container.
+func (s *container) inline(inner *container) {
+	s.content = append(s.content, inner.content...)
+	s.named = append(s.named, inner.named...)
+}
+
+// keepsShape
+// Whether the container has to stay a container of its own, because it
+// can be reached by name or it's counted.
+func (s *container) keepsShape() bool {
+
+	if s.name != "" || len(s.named) > 0 || s.visits || s.turns {
+		return true
+	}
+
+	for _, item := range s.content {
+		if inner, ok := item.(*container); ok && inner.name != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flatten
+// Inlines the unnamed containers in the container into it. Content that
+// has no debug metadata of its own takes the metadata of the container it
+// came from.
+func (s *container) flatten() {
+
+	content := make([]interface{}, 0, len(s.content))
+
+	for _, item := range s.content {
+
+		inner, ok := item.(*container)
+		if !ok {
+			content = append(content, item)
+			continue
+		}
+
+		inner.flatten()
+		if inner.keepsShape() {
+			content = append(content, inner)
+			continue
+		}
+
+		for _, innerItem := range inner.content {
+			if obj, ok := innerItem.(runtime.Object); ok && inner.meta != nil && obj.OwnDebugMetadata() == nil {
+				obj.SetDebugMetadata(inner.meta)
+			}
+			if innerContainer, ok := innerItem.(*container); ok && inner.meta != nil && innerContainer.meta == nil {
+				innerContainer.meta = inner.meta
+			}
+			content = append(content, innerItem)
+		}
+	}
+
+	s.content = content
+
+	for _, named := range s.named {
+		named.flatten()
+	}
+}
+
+// build
+// Creates the runtime container and everything in it.
+func (s *container) build() *runtime.Container {
+
+	c := runtime.NewContainer()
+	c.SetName(s.name)
+	c.VisitsShouldBeCounted = s.visits
+	c.TurnIndexShouldBeCounted = s.turns
+	c.CountingAtStartOnly = s.startOnly
+
+	if s.meta != nil {
+		c.SetDebugMetadata(s.meta)
+	}
+
+	for _, item := range s.content {
+		switch item := item.(type) {
+		case *container:
+			c.AddContent(item.build())
+		case runtime.Object:
+			c.AddContent(item)
+		}
+	}
+
+	for _, named := range s.named {
+		c.AddToNamedContentOnly(named.build())
+	}
+
+	s.runtime = c
+	return c
+}
+
+// fixup
+// A reference to content whose path isn't known until the runtime
+// containers have been built. The target is a flow or weave point, a
+// *container or a runtime.Object.
+type fixup struct {
+	target interface{}
+
+	// Whether the target's visits or turns need to be counted, for read
+	// counts and TURNS_SINCE
+	visits bool
+	turns  bool
+
+	set func(path *runtime.Path)
+}
+
+// generator
+// Generates the runtime content of a parsed story.
+type generator struct {
+	c     *compiler
+	story *flow
+
+	knots     map[string]*flow
+	globals   map[string]*ast.VarDecl
+	lists     map[string]*ast.ListDecl
+	listItems map[string][]*ast.ListDecl
+	externals map[string]*ast.External
+
+	// The labelled choices and gathers of each flow, and the names of its
+	// parameters and temporary variables
+	labels map[*flow]map[string]ast.Node
+	locals map[*flow]map[string]bool
+
+	// The flow being generated
+	flow *flow
+
+	// The weaves being generated, innermost last
+	weaves []*weave
+
+	// The containers generated for flows and weave points
+	containers map[ast.Node]*container
+
+	fixups []*fixup
+
+	// Constants being generated, to catch ones that refer to themselves
+	expanding map[string]bool
+
+	metadata map[ast.Span]*runtime.DebugMetadata
+
+	// The gather inklecate adds at the end of the story's top level, which
+	// ends the story with "done" and isn't counted
+	end *ast.Gather
+}
+
+func newGenerator(c *compiler, story *flow) *generator {
+
+	return &generator{
+		c:          c,
+		story:      story,
+		knots:      map[string]*flow{},
+		globals:    map[string]*ast.VarDecl{},
+		lists:      map[string]*ast.ListDecl{},
+		listItems:  map[string][]*ast.ListDecl{},
+		externals:  map[string]*ast.External{},
+		labels:     map[*flow]map[string]ast.Node{},
+		locals:     map[*flow]map[string]bool{},
+		containers: map[ast.Node]*container{},
+		expanding:  map[string]bool{},
+		metadata:   map[ast.Span]*runtime.DebugMetadata{},
+	}
+}
+
+// generate
+// Generates the root container of the story, and its list definitions.
+func (s *generator) generate() (*runtime.Container, []*runtime.ListDefinition) {
+
+	s.declare()
+
+	root := s.generateFlow(s.story)
+
+	for _, knot := range s.story.knots {
+		root.addNamed(s.generateFlow(knot))
+	}
+
+	if decl := s.generateGlobals(); decl != nil {
+		root.addNamed(decl)
+	}
+
+	if len(s.c.errors) > 0 {
+		return nil, nil
+	}
+
+	for _, f := range s.fixups {
+		if target, ok := f.target.(ast.Node); ok && (f.visits || f.turns) {
+			if c := s.containers[target]; c != nil {
+				c.visits = c.visits || f.visits
+				c.turns = c.turns || f.turns
+			}
+		}
+	}
+
+	root.flatten()
+	runtimeRoot := root.build()
+
+	for _, f := range s.fixups {
+
+		var obj runtime.Object
+		switch target := f.target.(type) {
+		case *container:
+			obj = target.runtime
+		case runtime.Object:
+			obj = target
+		case ast.Node:
+			obj = s.containers[target].runtime
+		}
+
+		f.set(obj.Path(obj))
+	}
+
+	return runtimeRoot, s.listDefinitions()
+}
+
+// debugMetadata
+// The debug metadata for the source of n, shared by everything generated
+// from the same span.
+func (s *generator) debugMetadata(n ast.Node) *runtime.DebugMetadata {
+
+	at := n.Pos()
+	if dm, ok := s.metadata[at]; ok {
+		return dm
+	}
+
+	dm := at.DebugMetadata()
+	s.metadata[at] = dm
+
+	return dm
+}
+
+// emit
+// Adds objects to c, with the debug metadata of the source at.
+func (s *generator) emit(c *container, at ast.Node, objs ...runtime.Object) {
+
+	dm := s.debugMetadata(at)
+	for _, obj := range objs {
+		obj.SetDebugMetadata(dm)
+		c.content = append(c.content, obj)
+	}
+}
+
+// refer
+// Sets the path of a reference once target has a runtime path.
+func (s *generator) refer(target interface{}, set func(path *runtime.Path)) *fixup {
+
+	f := &fixup{target: target, set: set}
+	s.fixups = append(s.fixups, f)
+
+	return f
+}
+
+// Declarations
+
+// declare
+// Records everything that can be referred to by name: knots, stitches,
+// labels, variables, lists and external functions.
+func (s *generator) declare() {
+
+	for _, decl := range s.story.globals {
+		if s.isGlobalName(decl.Name) {
+			s.c.error(decl.Span, "'%s' has already been declared", decl.Name)
+			continue
+		}
+		s.globals[decl.Name] = decl
+	}
+
+	for _, decl := range s.story.lists {
+		if s.isGlobalName(decl.Name) {
+			s.c.error(decl.Span, "'%s' has already been declared", decl.Name)
+			continue
+		}
+		s.lists[decl.Name] = decl
+
+		seen := map[string]bool{}
+		for _, item := range decl.Items {
+			if seen[item.Name] {
+				s.c.error(item.Span, "the list '%s' already has an item called '%s'", decl.Name, item.Name)
+				continue
+			}
+			seen[item.Name] = true
+			s.listItems[item.Name] = append(s.listItems[item.Name], decl)
+		}
+	}
+
+	for _, ext := range s.story.externals {
+		s.externals[ext.Name] = ext
+	}
+
+	s.declareFlow(s.story)
+
+	for _, knot := range s.story.knots {
+
+		if other, ok := s.knots[knot.name]; ok {
+			s.c.error(knot.Span, "a knot called '%s' has already been declared on line %d", knot.name, other.StartLineNumber)
+			continue
+		}
+		if s.isGlobalName(knot.name) {
+			s.c.error(knot.Span, "the knot '%s' has the same name as a variable", knot.name)
+		}
+		s.knots[knot.name] = knot
+
+		s.declareFlow(knot)
+	}
+}
+
+func (s *generator) isGlobalName(name string) bool {
+
+	_, isVar := s.globals[name]
+	_, isList := s.lists[name]
+
+	return isVar || isList
+}
+
+// declareFlow
+// Records the labels and local variables of a flow and its stitches.
+func (s *generator) declareFlow(fl *flow) {
+
+	s.labels[fl] = map[string]ast.Node{}
+	s.locals[fl] = map[string]bool{}
+
+	for _, p := range fl.params {
+		if s.locals[fl][p.Name] {
+			s.c.error(p.Span, "there's already a parameter called '%s'", p.Name)
+		}
+		s.locals[fl][p.Name] = true
+	}
+
+	s.declareNodes(fl, fl.body)
+
+	stitches := map[string]bool{}
+	for _, stitch := range fl.stitches {
+		if stitches[stitch.name] {
+			s.c.error(stitch.Span, "a stitch called '%s' has already been declared in '%s'", stitch.name, fl.name)
+		}
+		stitches[stitch.name] = true
+
+		s.declareFlow(stitch)
+	}
+}
+
+func (s *generator) declareNodes(fl *flow, nodes []ast.Node) {
+
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *ast.Choice:
+			s.declareLabel(fl, n.Label, n)
+
+		case *ast.Gather:
+			s.declareLabel(fl, n.Label, n)
+
+		case *ast.Assignment:
+			if n.IsTemp {
+				s.locals[fl][n.Name] = true
+			}
+
+		case *ast.Conditional:
+			for _, b := range n.Branches {
+				s.declareNodes(fl, b.Content)
+			}
+
+		case *ast.Sequence:
+			for _, element := range n.Elements {
+				s.declareNodes(fl, element)
+			}
+		}
+	}
+}
+
+func (s *generator) declareLabel(fl *flow, label string, n ast.Node) {
+
+	if label == "" {
+		return
+	}
+
+	if other, ok := s.labels[fl][label]; ok {
+		s.c.error(n.Pos(), "the label '%s' has already been used on line %d", label, other.Pos().StartLineNumber)
+		return
+	}
+
+	s.labels[fl][label] = n
+}
+
+// Name resolution
+
+// resolve
+// Finds the flow, or labelled choice or gather, that path names. The
+// first name is looked for in the current flow, then in the flows around
+// it.
+func (s *generator) resolve(path []string) ast.Node {
+
+	for scope := s.flow; scope != nil; scope = scope.parent {
+
+		target := s.child(scope, path[0])
+		if target == nil {
+			continue
+		}
+
+		for _, name := range path[1:] {
+			fl, ok := target.(*flow)
+			if !ok {
+				return nil
+			}
+			if target = s.child(fl, name); target == nil {
+				return nil
+			}
+		}
+
+		return target
+	}
+
+	return nil
+}
+
+// child
+// The knot, stitch or label called name directly in fl.
+func (s *generator) child(fl *flow, name string) ast.Node {
+
+	if fl.kind == flowStory {
+		if knot, ok := s.knots[name]; ok {
+			return knot
+		}
+	}
+
+	for _, stitch := range fl.stitches {
+		if stitch.name == name {
+			return stitch
+		}
+	}
+
+	if label, ok := s.labels[fl][name]; ok {
+		return label
+	}
+
+	return nil
+}
+
+func (s *generator) isLocal(name string) bool {
+	return s.locals[s.flow][name]
+}
+
+// isGlobal
+// Whether name is a global variable, which includes the variables that
+// lists declare, but not constants.
+func (s *generator) isGlobal(name string) bool {
+
+	if decl, ok := s.globals[name]; ok {
+		return !decl.IsConst
+	}
+
+	_, ok := s.lists[name]
+	return ok
+}
+
+func (s *generator) isVariable(name string) bool {
+	return s.isLocal(name) || s.isGlobal(name)
+}
+
+// listItem
+// Finds the list that has the item, which can be qualified by the name of
+// its list.
+func (s *generator) listItem(at ast.Node, listName string, itemName string) (*ast.ListDecl, *ast.ListItemDecl) {
+
+	var candidates []*ast.ListDecl
+	if listName != "" {
+		if decl, ok := s.lists[listName]; ok {
+			candidates = []*ast.ListDecl{decl}
+		}
+	} else {
+		candidates = s.listItems[itemName]
+	}
+
+	if listName == "" && len(candidates) > 1 {
+		s.c.error(at.Pos(), "'%s' is in more than one list, so it needs to be written as %s.%s", itemName, candidates[0].Name, itemName)
+		return nil, nil
+	}
+
+	for _, decl := range candidates {
+		for _, item := range decl.Items {
+			if item.Name == itemName {
+				return decl, item
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// Flows
+
+// generateFlow
+// Generates the container for the story, a knot or a stitch, with its
+// stitches as named content.
+func (s *generator) generateFlow(fl *flow) *container {
+
+	s.flow = fl
+
+	// Every flow is counted, the story itself included, as in inklecate
+	c := &container{name: fl.name, visits: true, meta: s.debugMetadata(fl)}
+	s.containers[fl] = c
+
+	// Arguments are on the evaluation stack, last first
+	for i := len(fl.params) - 1; i >= 0; i-- {
+		s.emit(c, fl.params[i], runtime.NewVariableAssignment(fl.params[i].Name, true))
+	}
+
+	body := fl.body
+	if fl.kind == flowStory {
+
+		// Loose ends at the top of the story gather at the end, which is
+		// where it's done
+		done := &ast.Divert{Span: s.story.Span, Target: []string{"DONE"}}
+		end := &ast.Gather{Span: s.story.Span, Depth: 1, Content: []ast.Node{done}}
+		s.end = end
+
+		body = append(body[:len(body):len(body)], end)
+	}
+
+	// A knot with no content of its own starts in its first stitch
+	if len(body) == 0 && len(fl.stitches) > 0 {
+		d := runtime.NewDivert()
+		s.emit(c, fl, d)
+		s.refer(fl.stitches[0], d.SetTargetPath)
+	} else {
+		weaveContainer, looseEnds := s.generateWeave(body, 0)
+
+		// The story's weave is a container of its own, ahead of the "done"
+		// that ends it, but a knot's or stitch's weave is its content, so
+		// that its choices and gathers are named in the knot or stitch
+		if fl.kind == flowStory {
+			c.add(weaveContainer)
+		} else {
+			c.inline(weaveContainer)
+		}
+		s.checkTermination(fl, looseEnds)
+	}
+
+	if fl.kind == flowStory {
+		s.emit(c, fl, runtime.NewDoneCommand())
+	}
+
+	for _, stitch := range fl.stitches {
+		c.addNamed(s.generateFlow(stitch))
+	}
+
+	return c
+}
+
+// checkTermination
+// Warns about the places a knot or stitch can run out of content.
+func (s *generator) checkTermination(fl *flow, looseEnds []ast.Node) {
+
+	if fl.kind != flowKnot && fl.kind != flowStitch {
+		return
+	}
+
+	const message = "apparent loose end exists where the flow runs out. Do you need a '-> DONE' statement, choice or divert?"
+
+	for _, looseEnd := range looseEnds {
+		s.c.warning(looseEnd.Pos(), message)
+	}
+
+	if len(looseEnds) > 0 || len(fl.body) == 0 {
+		return
+	}
+
+	for _, n := range fl.body {
+		switch n.(type) {
+		case *ast.Choice, *ast.Gather:
+			return
+		}
+	}
+
+	if !hasEndingDivert(fl.body) {
+		s.c.warning(fl.Span, message)
+	}
+}
+
+// generateGlobals
+// The "global decl" container, which the runtime runs to give global
+// variables their initial values.
+func (s *generator) generateGlobals() *container {
+
+	s.flow = s.story
+
+	c := &container{name: "global decl"}
+	s.emit(c, s.story, runtime.NewEvalStartCommand())
+
+	declared := false
+	for _, decl := range s.story.globals {
+		if decl.IsConst || s.globals[decl.Name] != decl {
+			continue
+		}
+
+		s.expression(c, decl.Value)
+
+		assignment := runtime.NewVariableAssignment(decl.Name, true)
+		assignment.IsGlobal = true
+		s.emit(c, decl, assignment)
+		declared = true
+	}
+
+	for _, decl := range s.story.lists {
+		if s.lists[decl.Name] != decl {
+			continue
+		}
+
+		list := runtime.NewInkList()
+		for _, item := range decl.Items {
+			if item.Selected {
+				list.Set(runtime.NewInkListItem(decl.Name, item.Name), item.Value)
+			}
+		}
+		list.SetInitialOriginName(decl.Name)
+
+		assignment := runtime.NewVariableAssignment(decl.Name, true)
+		assignment.IsGlobal = true
+		s.emit(c, decl, runtime.NewListValueFromList(list), assignment)
+		declared = true
+	}
+
+	if !declared {
+		return nil
+	}
+
+	s.emit(c, s.story, runtime.NewEvalEndCommand(), runtime.NewEndCommand())
+	return c
+}
+
+func (s *generator) listDefinitions() []*runtime.ListDefinition {
+
+	definitions := []*runtime.ListDefinition{}
+
+	for _, decl := range s.story.lists {
+		if s.lists[decl.Name] != decl {
+			continue
+		}
+
+		items := map[string]int{}
+		for _, item := range decl.Items {
+			items[item.Name] = item.Value
+		}
+		definitions = append(definitions, runtime.NewListDefinition(decl.Name, items))
+	}
+
+	return definitions
+}
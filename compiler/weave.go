@@ -0,0 +1,341 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/SirMetathyst/go-ink/ink/ast"
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// subWeave
+// The choices and gathers deeper than the weave they're in, with the
+// content that follows them.
+type subWeave struct {
+	ast.Span
+	depth int
+	nodes []ast.Node
+}
+
+// weave
+// One level of a weave as it's generated.
+type weave struct {
+	root    *container
+	current *container
+
+	// Weave points that the flow runs out of, which divert to the next
+	// gather
+	looseEnds []ast.Node
+
+	previous ast.Node
+
+	// Whether content goes into the previous choice rather than the
+	// current container, which is the case while the choice is a loose
+	// end
+	addToPrevious bool
+
+	// Whether there's been a choice since the last gather, in which case
+	// the next gather isn't run into
+	seenChoice bool
+
+	choices int
+	gathers int
+}
+
+func (s *weave) removeLooseEnd(n ast.Node) {
+
+	for i, looseEnd := range s.looseEnds {
+		if looseEnd == n {
+			s.looseEnds = append(s.looseEnds[:i], s.looseEnds[i+1:]...)
+			return
+		}
+	}
+}
+
+func weaveDepth(n ast.Node) int {
+
+	switch n := n.(type) {
+	case *ast.Choice:
+		return n.Depth
+	case *ast.Gather:
+		return n.Depth
+	}
+
+	return 0
+}
+
+// nest
+// Replaces each run of weave points deeper than depth, along with the
+// content that follows them, with a subWeave.
+func nest(nodes []ast.Node, depth int) []ast.Node {
+
+	var nested []ast.Node
+
+	for i := 0; i < len(nodes); {
+
+		if weaveDepth(nodes[i]) <= depth {
+			nested = append(nested, nodes[i])
+			i++
+			continue
+		}
+
+		start := i
+		for ; i < len(nodes); i++ {
+			if d := weaveDepth(nodes[i]); d > 0 && d <= depth {
+				break
+			}
+		}
+
+		sub := &subWeave{depth: weaveDepth(nodes[start]), nodes: nodes[start:i]}
+		sub.Span = nodes[start].Pos()
+		nested = append(nested, sub)
+	}
+
+	return nested
+}
+
+// hasEndingDivert
+// Whether the content diverts away without coming back.
+func hasEndingDivert(nodes []ast.Node) bool {
+
+	for _, n := range nodes {
+		if d, ok := n.(*ast.Divert); ok && d.IsEnding() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generateWeave
+// Generates content that can have choices and gathers in it. The weave's
+// depth is that of its first choice or gather when depth is 0.
+//
+// Loose ends that aren't gathered are passed to the weave this one is
+// inside. At the top of a flow, they're returned.
+func (s *generator) generateWeave(nodes []ast.Node, depth int) (*container, []ast.Node) {
+
+	if depth == 0 {
+		depth = 1
+		for _, n := range nodes {
+			if d := weaveDepth(n); d > 0 {
+				depth = d
+				break
+			}
+		}
+	}
+
+	w := &weave{root: &container{}}
+	w.current = w.root
+
+	s.weaves = append(s.weaves, w)
+	defer func() { s.weaves = s.weaves[:len(s.weaves)-1] }()
+
+	for _, n := range nest(nodes, depth) {
+
+		switch n := n.(type) {
+		case *ast.Gather:
+			s.addGather(w, n)
+
+		case *ast.Choice:
+			s.addChoice(w, n)
+
+		case *subWeave:
+			inner, _ := s.generateWeave(n.nodes, n.depth)
+			s.weaveTarget(w).add(inner)
+
+			// The previous weave point leads into the nested weave, so
+			// it isn't a loose end any more
+			if w.previous != nil {
+				w.removeLooseEnd(w.previous)
+				w.addToPrevious = false
+			}
+
+		default:
+			target := s.weaveTarget(w)
+			s.generateNode(target, n)
+
+			if w.previous != nil && target == s.containers[w.previous] && hasEndingDivert([]ast.Node{n}) {
+				w.removeLooseEnd(w.previous)
+			}
+		}
+	}
+
+	if len(s.weaves) > 1 {
+		outer := s.weaves[len(s.weaves)-2]
+		outer.looseEnds = append(outer.looseEnds, w.looseEnds...)
+		return w.root, nil
+	}
+
+	return w.root, w.looseEnds
+}
+
+// weaveTarget
+// Where content that isn't a weave point goes: into the previous choice
+// while it's a loose end, otherwise into the current gather or the root
+// of the weave.
+func (s *generator) weaveTarget(w *weave) *container {
+
+	if w.addToPrevious {
+		return s.containers[w.previous]
+	}
+
+	return w.current
+}
+
+func (s *generator) addGather(w *weave, g *ast.Gather) {
+
+	// A gather is run into unless there have been choices since the last
+	// one, in which case it's only reached by the choices' loose ends
+	autoEnter := !w.seenChoice
+	w.seenChoice = false
+
+	c := &container{name: g.Label, startOnly: true, meta: s.debugMetadata(g)}
+	if c.name == "" {
+		c.name = fmt.Sprintf("g-%d", w.gathers)
+		w.gathers++
+	}
+	c.visits = s.c.options.CountAllVisits && g != s.end
+	s.containers[g] = c
+
+	if autoEnter {
+		w.current.add(c)
+	} else {
+		w.root.addNamed(c)
+	}
+
+	for _, looseEnd := range w.looseEnds {
+
+		// An earlier gather at the same depth runs into this one
+		if previous, ok := looseEnd.(*ast.Gather); ok && previous.Depth == g.Depth {
+			continue
+		}
+
+		d := runtime.NewDivert()
+		s.emit(s.containers[looseEnd], looseEnd, d)
+		s.refer(g, d.SetTargetPath)
+	}
+	w.looseEnds = nil
+
+	w.current = c
+	s.generateNodes(c, g.Content)
+
+	w.addToPrevious = false
+	if !hasEndingDivert(g.Content) {
+		w.looseEnds = append(w.looseEnds, g)
+	}
+	w.previous = g
+}
+
+func (s *generator) addChoice(w *weave, ch *ast.Choice) {
+
+	// A gather with choices after it isn't a loose end
+	if _, ok := w.previous.(*ast.Gather); ok {
+		w.removeLooseEnd(w.previous)
+	}
+
+	outer, inner := s.generateChoice(ch)
+	w.current.add(outer)
+
+	inner.name = fmt.Sprintf("c-%d", w.choices)
+	w.choices++
+	w.current.addNamed(inner)
+
+	w.seenChoice = true
+
+	w.addToPrevious = false
+	if !hasEndingDivert(ch.Inner) {
+		w.looseEnds = append(w.looseEnds, ch)
+		w.addToPrevious = true
+	}
+	w.previous = ch
+}
+
+// generateChoice
+// Generates the choice point, in a container with the content that makes
+// up its text, and the container that's run when the choice is chosen.
+//
+// The start content is shown both in the choice and after it's chosen, so
+// it's generated once and diverted to from both places. The return point
+// is kept in the temporary variable $r, rather than calling it as a
+// function, so that temporary variables stay in scope.
+func (s *generator) generateChoice(ch *ast.Choice) (*container, *container) {
+
+	outer := &container{meta: s.debugMetadata(ch)}
+	inner := &container{visits: true, startOnly: true, meta: s.debugMetadata(ch)}
+	s.containers[ch] = inner
+
+	hasStart := len(ch.Start) > 0
+	hasChoiceOnly := len(ch.ChoiceOnly) > 0
+
+	point := runtime.NewChoicePointWith(!ch.Sticky)
+	point.IsInvisibleDefault = !hasStart && !hasChoiceOnly
+
+	hasEvaluation := hasStart || hasChoiceOnly || len(ch.Conditions) > 0
+	if hasEvaluation {
+		s.emit(outer, ch, runtime.NewEvalStartCommand())
+	}
+
+	var start *container
+	if hasStart {
+		start = &container{name: "s"}
+		s.generateNodes(start, ch.Start)
+
+		returnDivert := runtime.NewDivert()
+		returnDivert.VariableDivertName = "$r"
+		s.emit(start, ch, returnDivert)
+
+		returnTo := &container{name: "$r1"}
+		returnTarget := runtime.NewDivertTargetValueFromPath(nil)
+		startDivert := runtime.NewDivert()
+
+		s.emit(outer, ch, returnTarget, runtime.NewVariableAssignment("$r", true), runtime.NewBeginStringCommand(), startDivert)
+		outer.addNamed(start)
+		outer.add(returnTo)
+		s.emit(outer, ch, runtime.NewEndStringCommand())
+
+		s.refer(returnTo, returnTarget.SetTargetPath)
+		s.refer(start, startDivert.SetTargetPath)
+
+		point.HasStartContent = true
+	}
+
+	if hasChoiceOnly {
+		s.emit(outer, ch, runtime.NewBeginStringCommand())
+		s.generateNodes(outer, ch.ChoiceOnly)
+		s.emit(outer, ch, runtime.NewEndStringCommand())
+
+		point.HasChoiceOnlyContent = true
+	}
+
+	for i, condition := range ch.Conditions {
+		s.expression(outer, condition)
+		if i > 0 {
+			s.emit(outer, condition, runtime.NewNativeFunctionCallFromName("&&"))
+		}
+		point.HasCondition = true
+	}
+
+	if hasEvaluation {
+		s.emit(outer, ch, runtime.NewEvalEndCommand())
+	}
+
+	s.emit(outer, ch, point)
+	s.refer(inner, point.SetPathOnChoice)
+
+	// Once chosen, the start content is output again
+	if hasStart {
+		returnTo := &container{name: "$r2"}
+		returnTarget := runtime.NewDivertTargetValueFromPath(nil)
+		startDivert := runtime.NewDivert()
+
+		s.emit(inner, ch, runtime.NewEvalStartCommand(), returnTarget, runtime.NewEvalEndCommand(), runtime.NewVariableAssignment("$r", true), startDivert)
+		inner.add(returnTo)
+
+		s.refer(returnTo, returnTarget.SetTargetPath)
+		s.refer(start, startDivert.SetTargetPath)
+	}
+
+	s.generateNodes(inner, ch.Inner)
+
+	return outer, inner
+}
@@ -0,0 +1,438 @@
+// Package ast declares the types used to represent the syntax tree of ink
+// source, and parses .ink files into it.
+//
+//	file, err := ast.ParseFile(os.DirFS("story"), "main.ink", nil)
+//
+// The tree keeps the structure of the source: a file holds its top-level
+// content, declarations and knots in order, a knot holds its stitches, and
+// choices and gathers are flat statements with a depth, as they're
+// written. Every node has the Span of source it came from, in the shape of
+// runtime.DebugMetadata.
+package ast
+
+import "github.com/SirMetathyst/go-ink/runtime"
+
+// Span
+// A range of the source, with 1-based lines and columns counted in runes.
+// The end is just after the last character. The fields are those of
+// runtime.DebugMetadata.
+type Span struct {
+	StartLineNumber      int
+	EndLineNumber        int
+	StartCharacterNumber int
+	EndCharacterNumber   int
+	FileName             string
+}
+
+// Pos
+// The span itself, so that every node with an embedded Span is a Node.
+func (s Span) Pos() Span {
+	return s
+}
+
+// DebugMetadata
+// The span in the form the runtime keeps it.
+func (s Span) DebugMetadata() *runtime.DebugMetadata {
+
+	return &runtime.DebugMetadata{
+		StartLineNumber:      s.StartLineNumber,
+		EndLineNumber:        s.EndLineNumber,
+		StartCharacterNumber: s.StartCharacterNumber,
+		EndCharacterNumber:   s.EndCharacterNumber,
+		FileName:             s.FileName,
+		SourceName:           s.FileName,
+	}
+}
+
+// Contains
+// Whether the position at line and column is inside the span. The end of
+// the span is inclusive, so that a position just after the last character
+// still counts.
+func (s Span) Contains(line int, column int) bool {
+
+	if line < s.StartLineNumber || line > s.EndLineNumber {
+		return false
+	}
+
+	if line == s.StartLineNumber && column < s.StartCharacterNumber {
+		return false
+	}
+
+	if line == s.EndLineNumber && column > s.EndCharacterNumber {
+		return false
+	}
+
+	return true
+}
+
+// Node
+// Anything in the tree.
+type Node interface {
+	Pos() Span
+}
+
+// Expr
+// A node that has a value.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Files and flows
+
+// File
+// One source file. Its body has the content before the first knot, then
+// the knots and functions, with declarations, includes and TODOs in
+// place wherever they were written.
+type File struct {
+	Span
+	Name     string
+	Body     []Node
+	Comments []*Comment
+}
+
+// Comment
+// A // or /* */ comment, including the slashes.
+type Comment struct {
+	Span
+	Text string
+}
+
+// Include
+// INCLUDE name. File is the parsed file when it was found, and nil when
+// there was no file system to read it from, or when it had already been
+// included.
+type Include struct {
+	Span
+	Name string
+	File *File
+}
+
+// Todo
+// A TODO: line, which has no effect on the story.
+type Todo struct {
+	Span
+	Text string
+}
+
+// Knot
+//
+//	== name(params) ==
+type Knot struct {
+	Span
+	Name     string
+	Params   []*Param
+	Body     []Node
+	Stitches []*Stitch
+}
+
+// Stitch
+//
+//	= name(params)
+type Stitch struct {
+	Span
+	Name   string
+	Params []*Param
+	Body   []Node
+}
+
+// Function
+//
+//	== function name(params) ==
+type Function struct {
+	Span
+	Name   string
+	Params []*Param
+	Body   []Node
+}
+
+// Param
+// A parameter of a knot, stitch, function or external function. A ref
+// parameter is passed a pointer to the caller's variable, and a divert
+// parameter is passed a divert target.
+type Param struct {
+	Span
+	Name     string
+	IsRef    bool
+	IsDivert bool
+}
+
+// Weave
+
+// Choice
+// A line starting with * (once only) or + (sticky), followed by
+//
+//	(label) {condition} start content [choice only content] inner content
+//
+// The inner content ends with a newline, followed by any diverts.
+type Choice struct {
+	Span
+	Depth      int
+	Sticky     bool
+	Label      string
+	Conditions []Expr
+	Start      []Node
+	ChoiceOnly []Node
+	Inner      []Node
+}
+
+// Gather
+// A line starting with -, with optional content of its own.
+type Gather struct {
+	Span
+	Depth   int
+	Label   string
+	Content []Node
+}
+
+// Content
+
+// Text
+// A run of text, or "\n" for the end of a line of content.
+type Text struct {
+	Span
+	Value string
+}
+
+// Glue
+// <>
+type Glue struct {
+	Span
+}
+
+// Tag
+// A # tag, which is content of its own so it can hold inline logic.
+type Tag struct {
+	Span
+	Content []Node
+}
+
+// DivertKind
+// What sort of divert a Divert is.
+type DivertKind int
+
+const (
+	// DivertNormal is -> target
+	DivertNormal DivertKind = iota
+
+	// DivertTunnel is -> target ->
+	DivertTunnel
+
+	// DivertThread is <- target
+	DivertThread
+
+	// DivertTunnelReturn is ->->, with an optional target to divert to
+	// instead of returning
+	DivertTunnelReturn
+)
+
+// Divert
+// A divert to a knot, stitch or label. Args is nil when there are no
+// parentheses after the target.
+type Divert struct {
+	Span
+	Kind   DivertKind
+	Target []string
+	Args   []Expr
+}
+
+// IsEnding
+// Whether the flow doesn't come back after the divert.
+func (s *Divert) IsEnding() bool {
+	return s.Kind == DivertNormal || s.Kind == DivertTunnelReturn
+}
+
+// Output
+// An expression printed with {expression}.
+type Output struct {
+	Span
+	Value Expr
+}
+
+// Conditional
+// {condition: a|b}, or a multiline block with - branches. With an initial
+// condition and branches that each have a value, it's a switch that
+// compares the initial value with each branch's.
+type Conditional struct {
+	Span
+	Initial   Expr
+	Branches  []*Branch
+	Multiline bool
+}
+
+// Branch
+// A branch of a conditional. The content after {condition: is a branch
+// that IsTrue, and has no condition of its own.
+type Branch struct {
+	Span
+	Condition Expr
+	IsElse    bool
+	IsTrue    bool
+	Content   []Node
+}
+
+// SequenceKind
+// A combination of the kinds of sequence below.
+type SequenceKind int
+
+const (
+	SequenceStopping SequenceKind = 1 << iota
+	SequenceCycle
+	SequenceShuffle
+	SequenceOnce
+)
+
+// Sequence
+// {a|b|c} or a multiline {stopping: - a - b}.
+type Sequence struct {
+	Span
+	Kind      SequenceKind
+	Elements  [][]Node
+	Multiline bool
+}
+
+// Logic
+
+// Assignment
+// ~ x = 1, ~ temp x = 1, ~ x += 1, ~ x++ and so on. The compound forms
+// keep their operator, and ++ and -- have no value.
+type Assignment struct {
+	Span
+	Name   string
+	Op     string
+	Value  Expr
+	IsTemp bool
+}
+
+// Return
+// ~ return, with an optional value.
+type Return struct {
+	Span
+	Value Expr
+}
+
+// ExprStatement
+// ~ expression, usually a function call whose result is thrown away.
+type ExprStatement struct {
+	Span
+	Value Expr
+}
+
+// Declarations
+
+// VarDecl
+// VAR or CONST.
+type VarDecl struct {
+	Span
+	Name    string
+	Value   Expr
+	IsConst bool
+}
+
+// ListDecl
+//
+//	LIST name = a, (b), c = 5
+type ListDecl struct {
+	Span
+	Name  string
+	Items []*ListItemDecl
+}
+
+// ListItemDecl
+// An item of a list. Selected items are in the list's initial value, and
+// HasValue is whether the value was written rather than counted on from
+// the previous item.
+type ListItemDecl struct {
+	Span
+	Name     string
+	Value    int
+	Selected bool
+	HasValue bool
+}
+
+// External
+//
+//	EXTERNAL name(params)
+type External struct {
+	Span
+	Name   string
+	Params []*Param
+}
+
+// Expressions
+
+// Number
+// An int or float64 literal.
+type Number struct {
+	Span
+	Value interface{}
+}
+
+type Bool struct {
+	Span
+	Value bool
+}
+
+// String
+// A quoted string, which can hold inline logic.
+type String struct {
+	Span
+	Content []Node
+}
+
+// DivertTarget
+// -> target as a value.
+type DivertTarget struct {
+	Span
+	Target []string
+}
+
+// Variable
+// A name, which could be a variable, a constant, a list item or the read
+// count of a knot, stitch or label.
+type Variable struct {
+	Span
+	Path []string
+}
+
+type Call struct {
+	Span
+	Name string
+	Args []Expr
+}
+
+// List
+// (a, b) as a list value.
+type List struct {
+	Span
+	Items [][]string
+}
+
+// Unary
+// -x or !x, where Op is the name of the runtime's native function: "_"
+// or "!".
+type Unary struct {
+	Span
+	Op    string
+	Value Expr
+}
+
+// Binary
+// x op y, where Op is the name of the runtime's native function, so
+// "and" is "&&" and "mod" is "%".
+type Binary struct {
+	Span
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+func (*Number) exprNode()       {}
+func (*Bool) exprNode()         {}
+func (*String) exprNode()       {}
+func (*DivertTarget) exprNode() {}
+func (*Variable) exprNode()     {}
+func (*Call) exprNode()         {}
+func (*List) exprNode()         {}
+func (*Unary) exprNode()        {}
+func (*Binary) exprNode()       {}
@@ -0,0 +1,287 @@
+package ast
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSource = `// The start
+VAR score = 0
+LIST colours = red, (green), blue = 5
+EXTERNAL roll(sides)
+
+Hello <> world. # greeting
+* (first) {score > 0} Go [north] now -> north
+* + Sticky
+- (meet) Met.
+-> north
+
+== north(-> back) ==
+{score: Some|None}
+{~a|b}
+~ temp x = roll(6) /* rolled */
+= stitch
+TODO: write more
+-> back
+
+=== function double(ref n) ===
+~ n = n * 2
+~ return n
+`
+
+func parse(t *testing.T, source string) *File {
+
+	file, err := Parse("test.ink", source, nil)
+	require.NoError(t, err)
+
+	return file
+}
+
+func TestParse(t *testing.T) {
+
+	file := parse(t, testSource)
+
+	require.Len(t, file.Body, 14)
+
+	decl := file.Body[0].(*VarDecl)
+	assert.Equal(t, "score", decl.Name)
+	assert.Equal(t, 0, decl.Value.(*Number).Value)
+
+	list := file.Body[1].(*ListDecl)
+	require.Len(t, list.Items, 3)
+	assert.True(t, list.Items[1].Selected)
+	assert.False(t, list.Items[1].HasValue)
+	assert.Equal(t, 5, list.Items[2].Value)
+	assert.True(t, list.Items[2].HasValue)
+
+	ext := file.Body[2].(*External)
+	assert.Equal(t, "roll", ext.Name)
+	require.Len(t, ext.Params, 1)
+
+	assert.IsType(t, &Text{}, file.Body[3])
+	assert.IsType(t, &Glue{}, file.Body[4])
+
+	choice := file.Body[8].(*Choice)
+	assert.Equal(t, 1, choice.Depth)
+	assert.Equal(t, "first", choice.Label)
+	require.Len(t, choice.Conditions, 1)
+	assert.Equal(t, ">", choice.Conditions[0].(*Binary).Op)
+	assert.Equal(t, "Go ", choice.Start[0].(*Text).Value)
+	assert.Equal(t, "north", choice.ChoiceOnly[0].(*Text).Value)
+	assert.Equal(t, []string{"north"}, choice.Inner[len(choice.Inner)-1].(*Divert).Target)
+
+	knot := file.Body[len(file.Body)-2].(*Knot)
+	assert.Equal(t, "north", knot.Name)
+	assert.True(t, knot.Params[0].IsDivert)
+	require.Len(t, knot.Stitches, 1)
+	assert.Equal(t, "stitch", knot.Stitches[0].Name)
+	assert.IsType(t, &Todo{}, knot.Stitches[0].Body[0])
+
+	function := file.Body[len(file.Body)-1].(*Function)
+	assert.Equal(t, "double", function.Name)
+	assert.True(t, function.Params[0].IsRef)
+	assert.IsType(t, &Return{}, function.Body[1])
+}
+
+func TestParseWeave(t *testing.T) {
+
+	file := parse(t, "* One\n** Two\n+ Three\n-- Four\n- Five\n")
+
+	var depths []int
+	for _, n := range file.Body {
+		switch n := n.(type) {
+		case *Choice:
+			depths = append(depths, n.Depth)
+			if n.Depth == 1 {
+				assert.Equal(t, n.Start[0].(*Text).Value == "Three", n.Sticky)
+			}
+		case *Gather:
+			depths = append(depths, -n.Depth)
+		}
+	}
+
+	assert.Equal(t, []int{1, 2, 1, -2, -1}, depths)
+}
+
+func TestParseFallbackChoice(t *testing.T) {
+
+	file := parse(t, "* [Once] Once.\n* ->\n  Fallback.\n  -> END\n")
+
+	require.Len(t, file.Body, 5)
+
+	choice := file.Body[1].(*Choice)
+	assert.Empty(t, choice.Start)
+	assert.Empty(t, choice.ChoiceOnly)
+	assert.Empty(t, choice.Inner)
+
+	assert.Equal(t, "Fallback.", file.Body[2].(*Text).Value)
+	assert.Equal(t, []string{"END"}, file.Body[4].(*Divert).Target)
+}
+
+func TestParseLogic(t *testing.T) {
+
+	file := parse(t, "{x:\n- 1: One\n- else: Other\n}\n{stopping:\n- A\n- B\n}\n{f(1, \"s\") + 2 mod 3}\n")
+
+	cond := file.Body[0].(*Conditional)
+	assert.True(t, cond.Multiline)
+	require.Len(t, cond.Branches, 2)
+	assert.Equal(t, 1, cond.Branches[0].Condition.(*Number).Value)
+	assert.True(t, cond.Branches[1].IsElse)
+
+	seq := file.Body[1].(*Sequence)
+	assert.Equal(t, SequenceStopping, seq.Kind)
+	assert.Len(t, seq.Elements, 2)
+
+	sum := file.Body[2].(*Output).Value.(*Binary)
+	assert.Equal(t, "+", sum.Op)
+	assert.Equal(t, "f", sum.Left.(*Call).Name)
+	assert.Equal(t, "%", sum.Right.(*Binary).Op)
+}
+
+func TestParsePositions(t *testing.T) {
+
+	file := parse(t, testSource)
+
+	choice := file.Body[8].(*Choice)
+	assert.Equal(t, Span{FileName: "test.ink", StartLineNumber: 7, EndLineNumber: 7, StartCharacterNumber: 1, EndCharacterNumber: 46}, choice.Span)
+
+	divert := choice.Inner[len(choice.Inner)-1].(*Divert)
+	assert.Equal(t, 38, divert.StartCharacterNumber)
+
+	knot := file.Body[len(file.Body)-2].(*Knot)
+	assert.Equal(t, 12, knot.StartLineNumber)
+
+	dm := knot.DebugMetadata()
+	assert.Equal(t, "test.ink", dm.FileName)
+	assert.Equal(t, 12, dm.StartLineNumber)
+
+	assert.True(t, choice.Contains(7, 10))
+	assert.False(t, choice.Contains(8, 1))
+	assert.False(t, choice.Contains(7, 47))
+}
+
+func TestParseComments(t *testing.T) {
+
+	file := parse(t, testSource)
+
+	require.Len(t, file.Comments, 2)
+	assert.Equal(t, "// The start", file.Comments[0].Text)
+	assert.Equal(t, 1, file.Comments[0].StartLineNumber)
+	assert.Equal(t, "/* rolled */", file.Comments[1].Text)
+	assert.Equal(t, 15, file.Comments[1].StartLineNumber)
+	assert.Equal(t, 20, file.Comments[1].StartCharacterNumber)
+}
+
+func TestParseInclude(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"main.ink":       {Data: []byte("INCLUDE part.ink\nINCLUDE ./part.ink\n")},
+		"part.ink":       {Data: []byte("== part ==\n-> END\n")},
+		"unknown.ink":    {Data: []byte("INCLUDE missing.ink\n")},
+		"standalone.ink": {Data: []byte("INCLUDE part.ink\n")},
+	}
+
+	file, err := ParseFile(fsys, "main.ink", nil)
+	require.NoError(t, err)
+	require.Len(t, file.Body, 2)
+
+	include := file.Body[0].(*Include)
+	assert.Equal(t, "part.ink", include.Name)
+	require.NotNil(t, include.File)
+	assert.Equal(t, "part", include.File.Body[0].(*Knot).Name)
+
+	// Each file is only included once
+	assert.Nil(t, file.Body[1].(*Include).File)
+
+	_, err = ParseFile(fsys, "unknown.ink", nil)
+	assert.Error(t, err)
+
+	// Without a file system, includes aren't followed
+	file, err = Parse("standalone.ink", "INCLUDE part.ink\n", nil)
+	require.NoError(t, err)
+	assert.Nil(t, file.Body[0].(*Include).File)
+}
+
+func TestParseErrors(t *testing.T) {
+
+	var warnings []*Error
+	file, err := Parse("test.ink", "Hello\n{x\nTODO: fix\n~ temp = 1\n", &Options{Warn: func(err *Error) { warnings = append(warnings, err) }})
+	require.NotNil(t, file)
+
+	var list ErrorList
+	require.True(t, errors.As(err, &list))
+	require.Len(t, list, 2)
+	assert.Equal(t, 2, list[0].Line)
+	assert.Equal(t, "expected '}' to close the '{' on line 2", list[0].Message)
+	assert.Equal(t, 4, list[1].Line)
+
+	require.Len(t, warnings, 1)
+	assert.Equal(t, "TODO: fix", warnings[0].Message)
+}
+
+type counter map[string]int
+
+func (s counter) Visit(node Node) Visitor {
+
+	switch node.(type) {
+	case *Knot:
+		s["knot"]++
+	case *Stitch:
+		s["stitch"]++
+	case *Function:
+		s["function"]++
+	case *Choice:
+		s["choice"]++
+	case *Divert:
+		s["divert"]++
+	case *Variable:
+		s["variable"]++
+	case nil:
+		s["nil"]++
+	}
+
+	return s
+}
+
+func TestWalk(t *testing.T) {
+
+	file := parse(t, testSource)
+
+	count := counter{}
+	Walk(count, file)
+
+	assert.Equal(t, 1, count["knot"])
+	assert.Equal(t, 1, count["stitch"])
+	assert.Equal(t, 1, count["function"])
+	assert.Equal(t, 2, count["choice"])
+	assert.Equal(t, 3, count["divert"])
+	assert.Equal(t, 4, count["variable"])
+	assert.Greater(t, count["nil"], 0)
+}
+
+func TestInspect(t *testing.T) {
+
+	file := parse(t, testSource)
+
+	var names []string
+	Inspect(file, func(n Node) bool {
+		switch n := n.(type) {
+		case *Knot:
+			names = append(names, n.Name)
+
+			// Not into the knot
+			return false
+		case *Function:
+			names = append(names, n.Name)
+		case *Assignment:
+			names = append(names, n.Name)
+		}
+		return true
+	})
+
+	assert.Equal(t, []string{"north", "double", "n"}, names)
+}
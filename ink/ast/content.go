@@ -0,0 +1,486 @@
+package ast
+
+import (
+	"strings"
+)
+
+// contentMode
+// What ends a run of mixed content, besides the end of the line.
+type contentMode int
+
+const (
+	contentNormal contentMode = 0
+
+	// contentChoice ends at the [ ] of a choice, and at its diverts
+	contentChoice contentMode = 1 << iota
+
+	// contentInline ends at the | and } of inline logic
+	contentInline
+
+	// contentString ends at the closing quote, and has no tags
+	contentString
+
+	// contentTag ends at the next #
+	contentTag
+)
+
+// parseLine
+// A line of mixed content as a statement.
+func (s *parser) parseLine() []Node {
+	return s.parseLineContent()
+}
+
+// parseLineContent
+// Parses the rest of the line, and ends it with a newline unless it's all
+// diverts, tags or a multiline block.
+func (s *parser) parseLineContent() []Node {
+
+	s.skipSpaces()
+	nodes := trimEnd(s.parseMixed(contentNormal))
+
+	if needsNewline(nodes) {
+		nodes = append(nodes, s.newline())
+	} else if len(nodes) > 0 {
+
+		// Text followed by a divert keeps its newline after the divert,
+		// where inklecate puts it
+		if _, ok := nodes[len(nodes)-1].(*Divert); ok && hasOutput(nodes) {
+			nodes = append(nodes, s.newline())
+		}
+	}
+
+	s.expectLineEndOrBlock()
+	return nodes
+}
+
+// needsNewline
+// Whether a line of content needs a newline at its end.
+func needsNewline(nodes []Node) bool {
+
+	if len(nodes) == 0 {
+		return false
+	}
+
+	switch last := nodes[len(nodes)-1].(type) {
+	case *Divert:
+		return false
+	case *Conditional:
+		if last.Multiline {
+			return false
+		}
+	case *Sequence:
+		if last.Multiline {
+			return false
+		}
+	}
+
+	return hasOutput(nodes)
+}
+
+// hasOutput
+// Whether there's anything other than tags and diverts in the nodes.
+func hasOutput(nodes []Node) bool {
+
+	for _, n := range nodes {
+		switch n.(type) {
+		case *Tag, *Divert:
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+func (s *parser) newline() *Text {
+
+	t := &Text{Value: "\n"}
+	t.Span = s.spanFrom(s.pos)
+
+	return t
+}
+
+// trimEnd
+// Removes trailing whitespace from the text at the end of the nodes.
+func trimEnd(nodes []Node) []Node {
+
+	if len(nodes) == 0 {
+		return nodes
+	}
+
+	if t, ok := nodes[len(nodes)-1].(*Text); ok && t.Value != "\n" {
+		t.Value = strings.TrimRight(t.Value, " \t")
+		if t.Value == "" {
+			return nodes[:len(nodes)-1]
+		}
+	}
+
+	return nodes
+}
+
+// stops
+// Whether the mixed content in mode ends at the current position.
+func (s *parser) stops(mode contentMode) bool {
+
+	r := s.peek()
+	switch {
+	case s.eof() || r == '\n' || r == '}':
+		return true
+	case mode&contentChoice != 0 && (r == '[' || r == ']' || s.has("->")):
+		return true
+	case mode&contentInline != 0 && r == '|':
+		return true
+	case mode&contentString != 0 && r == '"':
+		return true
+	case mode&contentTag != 0 && r == '#':
+		return true
+	}
+
+	return false
+}
+
+// parseMixed
+// Parses text, glue, tags, diverts and inline logic until the end of the
+// content in mode.
+func (s *parser) parseMixed(mode contentMode) []Node {
+
+	var nodes []Node
+
+	var sb strings.Builder
+	textStart := s.pos
+
+	flush := func() {
+		if sb.Len() > 0 {
+			t := &Text{Value: sb.String()}
+			t.Span = s.spanFrom(textStart)
+			nodes = append(nodes, t)
+			sb.Reset()
+		}
+	}
+
+	for !s.stops(mode) {
+
+		if sb.Len() == 0 {
+			textStart = s.pos
+		}
+
+		switch r := s.peek(); {
+		case r == '\\':
+			s.pos++
+			if !s.eof() && s.peek() != '\n' {
+				sb.WriteRune(s.peek())
+				s.pos++
+			}
+
+		case s.has("<>"):
+			flush()
+			start := s.pos
+			s.pos += 2
+			g := &Glue{}
+			g.Span = s.spanFrom(start)
+			nodes = append(nodes, g)
+
+		case s.has("->"):
+			flush()
+			for _, d := range s.parseDiverts() {
+				nodes = append(nodes, d)
+			}
+			s.skipSpaces()
+
+		case r == '#' && mode&contentString == 0:
+			flush()
+			nodes = append(nodes, s.parseTag(mode))
+
+		case r == '{':
+			flush()
+			if n := s.parseInlineLogic(); n != nil {
+				nodes = append(nodes, n)
+			}
+
+		default:
+			sb.WriteRune(r)
+			s.pos++
+		}
+	}
+
+	flush()
+	return nodes
+}
+
+// parseTag
+//
+//	# tag content
+func (s *parser) parseTag(mode contentMode) *Tag {
+
+	start := s.pos
+	s.pos++
+	s.skipSpaces()
+
+	t := &Tag{Content: trimEnd(s.parseMixed(mode | contentTag))}
+	t.Span = s.spanFrom(start)
+
+	return t
+}
+
+// Inline logic
+
+// parseInlineLogic
+// Parses the logic between { and }: an expression to print, a conditional
+// or a sequence, in inline or multiline form.
+func (s *parser) parseInlineLogic() Node {
+
+	start := s.pos
+	s.pos++
+	s.blockDepth++
+	defer func() { s.blockDepth-- }()
+
+	s.skipSpaces()
+
+	var result Node
+	switch {
+	case s.peek() == '\n':
+		result = s.parseMultilineConditional(start, nil)
+
+	default:
+		if flags, ok := s.sequenceAnnotation(); ok {
+			result = s.parseSequence(start, flags)
+			break
+		}
+
+		save := s.pos
+		if e := s.parseExpression(); e != nil {
+			s.skipSpaces()
+
+			if s.consume(":") {
+				result = s.parseConditional(start, e)
+				break
+			}
+
+			if s.consume("}") {
+				o := &Output{Value: e}
+				o.Span = s.spanFrom(start)
+				return o
+			}
+		}
+
+		s.pos = save
+		result = s.parseSequence(start, SequenceStopping)
+	}
+
+	s.skipSpaces()
+	if !s.consume("}") {
+		s.errorAt(s.pos, "expected '}' to close the '{' on line %d", s.spanFrom(start).StartLineNumber)
+		s.recoverBlock()
+	}
+
+	setSpan(result, s.spanFrom(start))
+	return result
+}
+
+// recoverBlock
+// Skips to the end of a broken block, so one mistake doesn't cause errors
+// for the rest of the file.
+func (s *parser) recoverBlock() {
+
+	for !s.eof() && s.peek() != '}' && s.peek() != '\n' {
+		s.pos++
+	}
+
+	s.consume("}")
+}
+
+func setSpan(n Node, at Span) {
+
+	switch n := n.(type) {
+	case *Conditional:
+		n.Span = at
+	case *Sequence:
+		n.Span = at
+	}
+}
+
+// sequenceAnnotation
+// The type of a sequence given by symbols such as {&a|b} or {~a|b}, or by
+// words such as {shuffle once: a|b}.
+func (s *parser) sequenceAnnotation() (SequenceKind, bool) {
+
+	flags := SequenceKind(0)
+	for {
+		switch s.peek() {
+		case '!':
+			flags |= SequenceOnce
+		case '&':
+			flags |= SequenceCycle
+		case '~':
+			flags |= SequenceShuffle
+		case '$':
+			flags |= SequenceStopping
+		default:
+			if flags != 0 {
+				return flags, true
+			}
+			return s.sequenceWords()
+		}
+		s.pos++
+	}
+}
+
+func (s *parser) sequenceWords() (SequenceKind, bool) {
+
+	save := s.pos
+	flags := SequenceKind(0)
+
+	for {
+		s.skipSpaces()
+		switch {
+		case s.keyword("stopping"):
+			flags |= SequenceStopping
+		case s.keyword("cycle"):
+			flags |= SequenceCycle
+		case s.keyword("shuffle"):
+			flags |= SequenceShuffle
+		case s.keyword("once"):
+			flags |= SequenceOnce
+		default:
+			if flags != 0 && s.consume(":") {
+				return flags, true
+			}
+			s.pos = save
+			return 0, false
+		}
+	}
+}
+
+// parseSequence
+// The elements of a sequence, separated by | or in multiline form given
+// as - lines.
+func (s *parser) parseSequence(start int, flags SequenceKind) *Sequence {
+
+	seq := &Sequence{Kind: flags}
+
+	s.skipSpaces()
+	if s.peek() == '\n' {
+		seq.Multiline = true
+		for {
+			s.skipBlank()
+			if s.peek() != '-' || s.has("->") {
+				break
+			}
+			s.pos++
+			seq.Elements = append(seq.Elements, s.parseStatements(levelInner))
+		}
+
+		if len(seq.Elements) == 0 {
+			s.errorAt(start, "expected '-' before each element of a multiline sequence")
+		}
+
+		return seq
+	}
+
+	for {
+		seq.Elements = append(seq.Elements, trimEnd(s.parseMixed(contentInline)))
+		if !s.consume("|") {
+			break
+		}
+	}
+
+	return seq
+}
+
+// parseConditional
+// After {Condition: comes either inline content, or the lines of a
+// multiline conditional or switch.
+func (s *parser) parseConditional(start int, condition Expr) *Conditional {
+
+	s.skipSpaces()
+	if s.peek() == '\n' {
+		return s.parseMultilineConditional(start, condition)
+	}
+
+	cond := &Conditional{Initial: condition}
+
+	trueBranch := &Branch{IsTrue: true, Content: trimEnd(s.parseMixed(contentInline))}
+	trueBranch.Span = condition.Pos()
+	cond.Branches = append(cond.Branches, trueBranch)
+
+	if s.consume("|") {
+		elseStart := s.pos
+		elseBranch := &Branch{IsElse: true, Content: trimEnd(s.parseMixed(contentInline))}
+		elseBranch.Span = s.spanFrom(elseStart)
+		cond.Branches = append(cond.Branches, elseBranch)
+	}
+
+	return cond
+}
+
+// parseMultilineConditional
+// The branches of a multiline conditional. Without an initial condition
+// each branch has a condition of its own. With one, the branches either
+// each have a value to match it against, or the first lines are the
+// content for when it's true, followed by an optional - else: branch.
+func (s *parser) parseMultilineConditional(start int, initial Expr) *Conditional {
+
+	cond := &Conditional{Initial: initial, Multiline: true}
+
+	s.skipBlank()
+	if initial != nil && (s.peek() != '-' || s.has("->")) {
+		trueBranch := &Branch{IsTrue: true}
+		trueStart := s.pos
+		trueBranch.Content = s.parseStatements(levelInner)
+		trueBranch.Span = s.spanFrom(trueStart)
+		cond.Branches = append(cond.Branches, trueBranch)
+	}
+
+	for {
+		s.skipBlank()
+		if s.peek() != '-' || s.has("->") {
+			break
+		}
+
+		branchStart := s.pos
+		s.pos++
+		s.skipSpaces()
+
+		b := &Branch{}
+		if s.keyword("else") {
+			b.IsElse = true
+			s.skipSpaces()
+		} else {
+			b.Condition = s.parseExpression()
+			if b.Condition == nil {
+				s.errorAt(s.pos, "expected a condition for the branch")
+				s.skipLine()
+				continue
+			}
+			s.skipSpaces()
+		}
+
+		if !s.consume(":") {
+			s.errorAt(s.pos, "expected ':' after the condition of the branch")
+			s.skipLine()
+			continue
+		}
+
+		b.Span = s.spanFrom(branchStart)
+		b.Content = s.parseStatements(levelInner)
+		cond.Branches = append(cond.Branches, b)
+
+		if len(cond.Branches) > 1 && cond.Branches[len(cond.Branches)-2].IsElse {
+			s.errorAt(branchStart, "the else branch must be the last branch of a conditional")
+		}
+	}
+
+	if len(cond.Branches) == 0 {
+		s.errorAt(start, "expected '-' before each branch of a multiline conditional")
+	}
+
+	if cond.Branches != nil && cond.Branches[0].IsTrue && len(cond.Branches) > 1 {
+		for _, b := range cond.Branches[1:] {
+			if !b.IsElse {
+				s.c.error(b.Span, "only an else branch can follow the content of a conditional")
+			}
+		}
+	}
+
+	return cond
+}
@@ -0,0 +1,65 @@
+package ast
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Error
+// A problem found in the ink source, at the position it was found.
+type Error struct {
+	FileName string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func newError(at Span, format string, args ...interface{}) *Error {
+
+	return &Error{
+		FileName: at.FileName,
+		Line:     at.StartLineNumber,
+		Column:   at.StartCharacterNumber,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (s *Error) Error() string {
+
+	if s.FileName != "" {
+		return fmt.Sprintf("%s:%d:%d: %s", s.FileName, s.Line, s.Column, s.Message)
+	}
+
+	return fmt.Sprintf("line %d:%d: %s", s.Line, s.Column, s.Message)
+}
+
+// ErrorList
+// Every error found in the source, in source order once sorted.
+type ErrorList []*Error
+
+func (s ErrorList) Error() string {
+
+	switch len(s) {
+	case 0:
+		return "no errors"
+	case 1:
+		return s[0].Error()
+	}
+
+	return fmt.Sprintf("%s (and %d more errors)", s[0].Error(), len(s)-1)
+}
+
+// Sort
+// Sorts the errors by file, line and column.
+func (s ErrorList) Sort() {
+
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].FileName != s[j].FileName {
+			return s[i].FileName < s[j].FileName
+		}
+		if s[i].Line != s[j].Line {
+			return s[i].Line < s[j].Line
+		}
+		return s[i].Column < s[j].Column
+	})
+}
@@ -0,0 +1,366 @@
+package ast
+
+import (
+	"strconv"
+	"unicode"
+)
+
+// Binary operators by precedence, lowest first, as in inklecate. Each word
+// operator is the same as the symbol it's listed with.
+var binaryOperators = []struct {
+	symbol     string
+	native     string
+	precedence int
+	word       bool
+}{
+	{"&&", "&&", 1, false},
+	{"||", "||", 1, false},
+	{"and", "&&", 1, true},
+	{"or", "||", 1, true},
+	{"==", "==", 2, false},
+	{">=", ">=", 2, false},
+	{"<=", "<=", 2, false},
+	{"!=", "!=", 2, false},
+	{"<", "<", 2, false},
+	{">", ">", 2, false},
+	{"!?", "!?", 3, false},
+	{"?", "?", 3, false},
+	{"hasnt", "!?", 3, true},
+	{"has", "?", 3, true},
+	{"^", "^", 3, false},
+	{"+", "+", 4, false},
+	{"-", "-", 5, false},
+	{"*", "*", 6, false},
+	{"/", "/", 7, false},
+	{"%", "%", 8, false},
+	{"mod", "%", 8, true},
+}
+
+// expectExpression
+// Parses an expression, reporting an error if there isn't one.
+func (s *parser) expectExpression() Expr {
+
+	s.skipSpaces()
+	start := s.pos
+
+	e := s.parseExpression()
+	if e == nil {
+		s.errorAt(start, "expected an expression")
+	}
+
+	return e
+}
+
+// parseExpression
+// Parses an expression, or returns nil with nothing consumed if there
+// isn't one, so the caller can try something else.
+func (s *parser) parseExpression() Expr {
+
+	save := s.pos
+	e := s.parseBinary(0)
+	if e == nil {
+		s.pos = save
+	}
+
+	return e
+}
+
+func (s *parser) parseBinary(minPrecedence int) Expr {
+
+	s.skipSpaces()
+	start := s.pos
+
+	left := s.parseUnary()
+	if left == nil {
+		return nil
+	}
+
+	for {
+		save := s.pos
+		s.skipSpaces()
+
+		op, precedence := s.binaryOperator()
+		if op == "" || precedence < minPrecedence {
+			s.pos = save
+			break
+		}
+
+		right := s.parseBinary(precedence + 1)
+		if right == nil {
+			s.pos = save
+			break
+		}
+
+		b := &Binary{Op: op, Left: left, Right: right}
+		b.Span = s.spanFrom(start)
+		left = b
+	}
+
+	return left
+}
+
+// binaryOperator
+// Consumes the next binary operator, returning the name of its native
+// function and its precedence.
+func (s *parser) binaryOperator() (string, int) {
+
+	// Not the start of a divert
+	if s.has("->") {
+		return "", 0
+	}
+
+	for _, op := range binaryOperators {
+		if op.word {
+			if s.keyword(op.symbol) {
+				return op.native, op.precedence
+			}
+			continue
+		}
+		if s.consume(op.symbol) {
+			return op.native, op.precedence
+		}
+	}
+
+	return "", 0
+}
+
+func (s *parser) parseUnary() Expr {
+
+	s.skipSpaces()
+	start := s.pos
+
+	switch {
+	case s.consume("->"):
+		s.skipSpaces()
+		target, ok := s.dottedIdentifier()
+		if !ok {
+			s.pos = start
+			return nil
+		}
+		d := &DivertTarget{Target: target}
+		d.Span = s.spanFrom(start)
+		return d
+
+	case s.peek() == '-':
+		s.pos++
+		value := s.parseUnary()
+		if value == nil {
+			s.pos = start
+			return nil
+		}
+
+		// Negative numbers are literals
+		if n, ok := value.(*Number); ok {
+			switch v := n.Value.(type) {
+			case int:
+				n.Value = -v
+			case float64:
+				n.Value = -v
+			}
+			n.Span = s.spanFrom(start)
+			return n
+		}
+
+		u := &Unary{Op: "_", Value: value}
+		u.Span = s.spanFrom(start)
+		return u
+
+	case s.peek() == '!' && s.peekAt(1) != '=' && s.peekAt(1) != '?', s.keyword("not"):
+		if s.peek() == '!' {
+			s.pos++
+		}
+		value := s.parseUnary()
+		if value == nil {
+			s.pos = start
+			return nil
+		}
+		u := &Unary{Op: "!", Value: value}
+		u.Span = s.spanFrom(start)
+		return u
+	}
+
+	return s.parseTerm()
+}
+
+func (s *parser) parseTerm() Expr {
+
+	start := s.pos
+
+	switch r := s.peek(); {
+	case r == '(':
+		if list := s.parseList(); list != nil {
+			return list
+		}
+
+		s.pos++
+		inner := s.parseBinary(0)
+		s.skipSpaces()
+		if inner == nil || !s.consume(")") {
+			s.pos = start
+			return nil
+		}
+		return inner
+
+	case r == '"':
+		return s.parseString()
+
+	case unicode.IsDigit(r):
+		if n := s.parseNumber(); n != nil {
+			return n
+		}
+	}
+
+	if s.keyword("true") || s.keyword("false") {
+		b := &Bool{Value: s.src[start] == 't'}
+		b.Span = s.spanFrom(start)
+		return b
+	}
+
+	path, ok := s.dottedIdentifier()
+	if !ok {
+		return nil
+	}
+
+	if len(path) == 1 && s.peek() == '(' {
+		save := s.pos
+		args, ok := s.parseCallArgs()
+		if ok {
+			c := &Call{Name: path[0], Args: args}
+			c.Span = s.spanFrom(start)
+			return c
+		}
+		s.pos = save
+	}
+
+	v := &Variable{Path: path}
+	v.Span = s.spanFrom(start)
+	return v
+}
+
+// parseNumber
+// An int or float literal, unless the digits are the start of a name.
+func (s *parser) parseNumber() Expr {
+
+	start := s.pos
+	for unicode.IsDigit(s.peek()) {
+		s.pos++
+	}
+
+	isFloat := false
+	if s.peek() == '.' && unicode.IsDigit(s.peekAt(1)) {
+		isFloat = true
+		s.pos++
+		for unicode.IsDigit(s.peek()) {
+			s.pos++
+		}
+	}
+
+	if isIdentifierRune(s.peek()) {
+		s.pos = start
+		return nil
+	}
+
+	literal := string(s.src[start:s.pos])
+	n := &Number{}
+
+	if isFloat {
+		value, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			s.pos = start
+			return nil
+		}
+		n.Value = value
+	} else {
+		value, err := strconv.Atoi(literal)
+		if err != nil {
+			s.errorAt(start, "the number %s is too large", literal)
+		}
+		n.Value = value
+	}
+
+	n.Span = s.spanFrom(start)
+	return n
+}
+
+// parseString
+// A quoted string, which can hold inline logic but not tags.
+func (s *parser) parseString() Expr {
+
+	start := s.pos
+	s.pos++
+
+	content := s.parseMixed(contentString)
+	if !s.consume("\"") {
+		s.pos = start
+		return nil
+	}
+
+	str := &String{Content: content}
+	str.Span = s.spanFrom(start)
+	return str
+}
+
+// parseList
+// A list literal such as (), (a) or (a, list.b), or nil with nothing
+// consumed if it isn't one.
+func (s *parser) parseList() Expr {
+
+	start := s.pos
+	s.pos++
+
+	list := &List{Items: [][]string{}}
+	for {
+		s.skipSpaces()
+		if len(list.Items) == 0 && s.consume(")") {
+			break
+		}
+
+		item, ok := s.dottedIdentifier()
+		if !ok || len(item) > 2 {
+			s.pos = start
+			return nil
+		}
+		list.Items = append(list.Items, item)
+
+		s.skipSpaces()
+		if s.consume(")") {
+			break
+		}
+		if !s.consume(",") {
+			s.pos = start
+			return nil
+		}
+	}
+
+	list.Span = s.spanFrom(start)
+	return list
+}
+
+// parseCallArgs
+// The (args) of a function call.
+func (s *parser) parseCallArgs() ([]Expr, bool) {
+
+	s.pos++
+	args := []Expr{}
+
+	s.skipSpaces()
+	if s.consume(")") {
+		return args, true
+	}
+
+	for {
+		arg := s.parseExpression()
+		if arg == nil {
+			return nil, false
+		}
+		args = append(args, arg)
+
+		s.skipSpaces()
+		if s.consume(")") {
+			return args, true
+		}
+		if !s.consume(",") {
+			return nil, false
+		}
+	}
+}
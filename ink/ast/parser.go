@@ -0,0 +1,1233 @@
+package ast
+
+import (
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Options
+// Controls how source is parsed. A nil *Options is the same as the zero
+// value.
+type Options struct {
+
+	// FS
+	// Where INCLUDE files are read from. Their names are relative to the
+	// root of FS. Without an FS, includes aren't followed and their File
+	// is nil.
+	FS fs.FS
+
+	// Warn
+	// Called with each warning, such as for a TODO. Warnings don't stop
+	// the source parsing.
+	Warn func(err *Error)
+}
+
+// Parse
+// Parses the ink source of a file. The file is returned even when there
+// are errors, with whatever could be parsed, and the error is an
+// ErrorList.
+func Parse(fileName string, source string, options *Options) (*File, error) {
+
+	if options == nil {
+		options = new(Options)
+	}
+
+	c := &state{options: options, included: map[string]bool{}}
+	file := c.parseFile(fileName, source)
+
+	if len(c.errors) > 0 {
+		c.errors.Sort()
+		return file, c.errors
+	}
+
+	return file, nil
+}
+
+// ParseFile
+// Parses the file called name in fsys. INCLUDE files are read from fsys
+// as well.
+func ParseFile(fsys fs.FS, name string, options *Options) (*File, error) {
+
+	source, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	opts.FS = fsys
+
+	return Parse(name, string(source), &opts)
+}
+
+// level
+// The kind of block statements are being parsed in, which decides what
+// ends the block.
+type level int
+
+const (
+	// levelInner is the body of a multiline conditional or sequence,
+	// which ends at } or at the - of the next branch
+	levelInner level = iota
+
+	// levelStitch ends at the next stitch or knot
+	levelStitch
+
+	// levelKnot ends at the next knot
+	levelKnot
+
+	// levelTop is the top of a file
+	levelTop
+)
+
+// state
+// The state shared by the parsers of a file and the files it includes.
+type state struct {
+	options *Options
+	errors  ErrorList
+
+	// Files that have been included, so each is only included once
+	included map[string]bool
+}
+
+func (s *state) error(at Span, format string, args ...interface{}) {
+	s.errors = append(s.errors, newError(at, format, args...))
+}
+
+func (s *state) warning(at Span, format string, args ...interface{}) {
+
+	if s.options.Warn != nil {
+		s.options.Warn(newError(at, format, args...))
+	}
+}
+
+// parser
+// Parses one source file. Included files get a parser of their own.
+type parser struct {
+	c     *state
+	file  string
+	src   []rune
+	pos   int
+	lines []int
+
+	// Whether the statements being parsed are in a function, where there
+	// can't be stitches
+	inFunction bool
+
+	// How many blocks the parser is inside, so that a } can end a line
+	blockDepth int
+}
+
+// parseFile
+// Parses the content of a file, with its comments.
+func (s *state) parseFile(name string, source string) *File {
+
+	p := &parser{c: s, file: name}
+
+	original := []rune(strings.ReplaceAll(source, "\r\n", "\n"))
+
+	var comments [][2]int
+	p.src, comments = eliminateComments(original)
+
+	// A UTF-8 BOM at the start of the file isn't content
+	if len(p.src) > 0 && p.src[0] == '\uFEFF' {
+		p.src[0] = ' '
+	}
+
+	p.lines = []int{0}
+	for i, r := range p.src {
+		if r == '\n' {
+			p.lines = append(p.lines, i+1)
+		}
+	}
+
+	file := &File{Name: name}
+	file.Body = p.parseStatements(levelTop)
+
+	for _, comment := range comments {
+		p.pos = comment[1]
+		c := &Comment{Text: string(original[comment[0]:comment[1]])}
+		c.Span = p.spanFrom(comment[0])
+		file.Comments = append(file.Comments, c)
+	}
+
+	p.pos = len(p.src)
+	file.Span = p.spanFrom(0)
+
+	return file
+}
+
+// eliminateComments
+// Replaces // and /* */ comments with spaces, keeping the newlines so that
+// positions in the source are unchanged. The start and end of each
+// comment are returned as well.
+func eliminateComments(src []rune) ([]rune, [][2]int) {
+
+	out := make([]rune, len(src))
+	copy(out, src)
+
+	var comments [][2]int
+
+	for i := 0; i < len(out); i++ {
+
+		switch {
+		case out[i] == '\\':
+			i++
+
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			start := i
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+			comments = append(comments, [2]int{start, i})
+
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '*':
+			start := i
+			for ; i < len(out); i++ {
+				if out[i] == '*' && i+1 < len(out) && out[i+1] == '/' {
+					out[i], out[i+1] = ' ', ' '
+					i++
+					break
+				}
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			end := i + 1
+			if end > len(out) {
+				end = len(out)
+			}
+			comments = append(comments, [2]int{start, end})
+		}
+	}
+
+	return out, comments
+}
+
+// Positions
+
+func (s *parser) lineColumn(pos int) (int, int) {
+
+	line := sort.Search(len(s.lines), func(i int) bool { return s.lines[i] > pos }) - 1
+	return line + 1, pos - s.lines[line] + 1
+}
+
+// spanFrom
+// The span from start to the current position.
+func (s *parser) spanFrom(start int) Span {
+
+	end := s.pos
+	for end > start && (end > len(s.src) || s.src[end-1] == '\n') {
+		end--
+	}
+
+	startLine, startColumn := s.lineColumn(start)
+	endLine, endColumn := s.lineColumn(end)
+
+	return Span{FileName: s.file, StartLineNumber: startLine, StartCharacterNumber: startColumn, EndLineNumber: endLine, EndCharacterNumber: endColumn}
+}
+
+// spanAt
+// The empty span at pos.
+func (s *parser) spanAt(pos int) Span {
+
+	line, column := s.lineColumn(pos)
+	return Span{FileName: s.file, StartLineNumber: line, StartCharacterNumber: column, EndLineNumber: line, EndCharacterNumber: column}
+}
+
+func (s *parser) errorAt(pos int, format string, args ...interface{}) {
+	s.c.error(s.spanAt(pos), format, args...)
+}
+
+func (s *parser) warningAt(pos int, format string, args ...interface{}) {
+	s.c.warning(s.spanAt(pos), format, args...)
+}
+
+// Scanning
+
+func (s *parser) eof() bool {
+	return s.pos >= len(s.src)
+}
+
+func (s *parser) peek() rune {
+	return s.peekAt(0)
+}
+
+func (s *parser) peekAt(offset int) rune {
+
+	if s.pos+offset >= len(s.src) {
+		return 0
+	}
+
+	return s.src[s.pos+offset]
+}
+
+func (s *parser) has(str string) bool {
+
+	i := s.pos
+	for _, r := range str {
+		if i >= len(s.src) || s.src[i] != r {
+			return false
+		}
+		i++
+	}
+
+	return true
+}
+
+func (s *parser) consume(str string) bool {
+
+	if !s.has(str) {
+		return false
+	}
+
+	s.pos += len([]rune(str))
+	return true
+}
+
+// keyword
+// Consumes word if it's followed by something that can't be part of an
+// identifier.
+func (s *parser) keyword(word string) bool {
+
+	if !s.has(word) {
+		return false
+	}
+
+	next := s.pos + len([]rune(word))
+	if next < len(s.src) && isIdentifierRune(s.src[next]) {
+		return false
+	}
+
+	s.pos = next
+	return true
+}
+
+func (s *parser) skipSpaces() {
+
+	for !s.eof() && (s.peek() == ' ' || s.peek() == '\t') {
+		s.pos++
+	}
+}
+
+func (s *parser) skipBlank() {
+
+	for !s.eof() && unicode.IsSpace(s.peek()) {
+		s.pos++
+	}
+}
+
+// atLineEnd
+// Whether there's only whitespace left on the line.
+func (s *parser) atLineEnd() bool {
+
+	for i := s.pos; i < len(s.src); i++ {
+		switch s.src[i] {
+		case '\n':
+			return true
+		case ' ', '\t':
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+func (s *parser) skipLine() {
+
+	for !s.eof() && s.peek() != '\n' {
+		s.pos++
+	}
+
+	if !s.eof() {
+		s.pos++
+	}
+}
+
+// expectLineEnd
+// Reports anything left on the line, and moves on to the next one.
+func (s *parser) expectLineEnd() {
+
+	s.skipSpaces()
+	if !s.eof() && s.peek() != '\n' {
+		s.errorAt(s.pos, "unexpected '%s' at end of line", string(s.peek()))
+	}
+
+	s.skipLine()
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// identifier
+// Reads a name. Names can start with a digit, but can't be all digits.
+func (s *parser) identifier() (string, bool) {
+
+	start := s.pos
+	for !s.eof() && isIdentifierRune(s.peek()) {
+		s.pos++
+	}
+
+	name := string(s.src[start:s.pos])
+	if name == "" || strings.TrimFunc(name, unicode.IsDigit) == "" {
+		s.pos = start
+		return "", false
+	}
+
+	return name, true
+}
+
+// dottedIdentifier
+// Reads a path such as knot.stitch.Label.
+func (s *parser) dottedIdentifier() ([]string, bool) {
+
+	name, ok := s.identifier()
+	if !ok {
+		return nil, false
+	}
+
+	path := []string{name}
+	for s.peek() == '.' {
+		save := s.pos
+		s.pos++
+		name, ok := s.identifier()
+		if !ok {
+			s.pos = save
+			break
+		}
+		path = append(path, name)
+	}
+
+	return path, true
+}
+
+// Statements
+
+// parseStatements
+// Parses statements until the end of the block at level.
+func (s *parser) parseStatements(lvl level) []Node {
+
+	var nodes []Node
+
+	for {
+		s.skipBlank()
+		if s.eof() || s.breaks(lvl) {
+			break
+		}
+
+		start := s.pos
+		nodes = append(nodes, s.parseStatement(lvl)...)
+
+		if s.pos == start {
+			s.errorAt(s.pos, "unexpected '%s'", string(s.peek()))
+			s.skipLine()
+		}
+	}
+
+	return nodes
+}
+
+// breaks
+// Whether the next statement ends the block at level.
+func (s *parser) breaks(lvl level) bool {
+
+	if lvl < levelTop && s.has("==") {
+		return true
+	}
+
+	if lvl <= levelStitch && s.peek() == '=' {
+		return true
+	}
+
+	if lvl == levelInner {
+		return s.peek() == '}' || (s.peek() == '-' && s.peekAt(1) != '>')
+	}
+
+	return false
+}
+
+func (s *parser) parseStatement(lvl level) []Node {
+
+	start := s.pos
+
+	switch {
+	case s.has("=="):
+		return []Node{s.parseKnot()}
+
+	case s.peek() == '=':
+		if lvl != levelKnot || s.inFunction {
+			s.errorAt(start, "stitches can only be declared inside a knot")
+		}
+		return []Node{s.parseStitch()}
+
+	case s.peek() == '*' || s.peek() == '+':
+		return []Node{s.parseChoice()}
+
+	case s.peek() == '-' && s.peekAt(1) != '>':
+		return []Node{s.parseGather()}
+
+	case s.peek() == '~':
+		return s.parseLogicLine()
+
+	case s.has("<-"):
+		return s.parseThread()
+
+	case s.has("->"):
+		diverts := s.parseDiverts()
+		s.expectLineEndOrBlock()
+		return diverts
+
+	case s.keyword("INCLUDE"):
+		return s.parseInclude(start, lvl)
+
+	case s.keyword("VAR"):
+		return s.parseVarDecl(start, false)
+
+	case s.keyword("CONST"):
+		return s.parseVarDecl(start, true)
+
+	case s.keyword("LIST"):
+		return s.parseListDecl(start)
+
+	case s.keyword("EXTERNAL"):
+		return s.parseExternal(start)
+
+	case s.has("TODO:") || s.has("TODO "):
+		s.skipLine()
+		todo := &Todo{Text: strings.TrimSpace(string(s.src[start:s.pos]))}
+		todo.Span = s.spanFrom(start)
+		s.warningAt(start, "%s", todo.Text)
+		return []Node{todo}
+	}
+
+	return s.parseLine()
+}
+
+// expectLineEndOrBlock
+// Like expectLineEnd, but the line can also finish at the } of the block
+// it's in.
+func (s *parser) expectLineEndOrBlock() {
+
+	s.skipSpaces()
+	if s.blockDepth > 0 && s.peek() == '}' {
+		return
+	}
+
+	s.expectLineEnd()
+}
+
+// parseKnot
+//
+//	== knot(a, ref b) ==
+//	=== function f(x) ===
+func (s *parser) parseKnot() Node {
+
+	start := s.pos
+	for s.peek() == '=' {
+		s.pos++
+	}
+	s.skipSpaces()
+
+	isFunction := s.keyword("function")
+	s.skipSpaces()
+
+	name, ok := s.identifier()
+	if !ok {
+		s.errorAt(s.pos, "expected a knot name")
+	}
+
+	s.skipSpaces()
+	params := s.parseParams()
+
+	s.skipSpaces()
+	for s.peek() == '=' {
+		s.pos++
+	}
+	at := s.spanFrom(start)
+	s.expectLineEnd()
+
+	if isFunction {
+		s.inFunction = true
+		body := s.parseStatements(levelKnot)
+		s.inFunction = false
+
+		// Stitches have been reported, and are left out
+		f := &Function{Span: at, Name: name, Params: params}
+		for _, n := range body {
+			if _, ok := n.(*Stitch); !ok {
+				f.Body = append(f.Body, n)
+			}
+		}
+		return f
+	}
+
+	knot := &Knot{Span: at, Name: name, Params: params}
+	for _, n := range s.parseStatements(levelKnot) {
+		if stitch, ok := n.(*Stitch); ok {
+			knot.Stitches = append(knot.Stitches, stitch)
+			continue
+		}
+		knot.Body = append(knot.Body, n)
+	}
+
+	return knot
+}
+
+// parseStitch
+//
+//	= stitch(a)
+func (s *parser) parseStitch() *Stitch {
+
+	start := s.pos
+	s.pos++
+	s.skipSpaces()
+
+	stitch := &Stitch{}
+
+	name, ok := s.identifier()
+	if !ok {
+		s.errorAt(s.pos, "expected a stitch name")
+	}
+	stitch.Name = name
+
+	s.skipSpaces()
+	stitch.Params = s.parseParams()
+	stitch.Span = s.spanFrom(start)
+	s.expectLineEnd()
+
+	stitch.Body = s.parseStatements(levelStitch)
+
+	return stitch
+}
+
+// parseParams
+//
+//	(a, ref b, -> c)
+func (s *parser) parseParams() []*Param {
+
+	if !s.consume("(") {
+		return nil
+	}
+
+	var params []*Param
+	for {
+		s.skipSpaces()
+		if s.consume(")") {
+			break
+		}
+
+		start := s.pos
+		p := &Param{}
+		if s.keyword("ref") {
+			p.IsRef = true
+			s.skipSpaces()
+		}
+		if s.consume("->") {
+			p.IsDivert = true
+			s.skipSpaces()
+		}
+
+		name, ok := s.identifier()
+		if !ok {
+			s.errorAt(s.pos, "expected a parameter name")
+			for !s.eof() && s.peek() != ')' && s.peek() != '\n' {
+				s.pos++
+			}
+			s.consume(")")
+			break
+		}
+		p.Name = name
+		p.Span = s.spanFrom(start)
+		params = append(params, p)
+
+		s.skipSpaces()
+		if s.consume(")") {
+			break
+		}
+		if !s.consume(",") {
+			s.errorAt(s.pos, "expected ',' or ')' in parameters")
+			break
+		}
+	}
+
+	return params
+}
+
+// parseInclude
+//
+//	INCLUDE other.ink
+func (s *parser) parseInclude(start int, lvl level) []Node {
+
+	s.skipSpaces()
+	nameStart := s.pos
+	s.skipLine()
+	name := includeName(string(s.src[nameStart:s.pos]))
+
+	if lvl != levelTop {
+		s.errorAt(start, "INCLUDE can only be used at the top of a file")
+		return nil
+	}
+
+	if name == "" {
+		s.errorAt(nameStart, "expected the name of a file to include")
+		return nil
+	}
+
+	include := &Include{Name: name}
+	include.Span = s.spanFrom(start)
+
+	if s.c.options.FS == nil || s.c.included[name] {
+		return []Node{include}
+	}
+	s.c.included[name] = true
+
+	source, err := fs.ReadFile(s.c.options.FS, name)
+	if err != nil {
+		s.errorAt(start, "can't include '%s': %v", name, err)
+		return []Node{include}
+	}
+
+	include.File = s.c.parseFile(name, string(source))
+	return []Node{include}
+}
+
+// includeName
+// Cleans up the file name given to INCLUDE so it can be opened in an
+// fs.FS.
+func includeName(name string) string {
+
+	name = strings.TrimSpace(strings.ReplaceAll(name, "\\", "/"))
+	return strings.TrimPrefix(name, "./")
+}
+
+// parseVarDecl
+//
+//	VAR name = value
+//	CONST name = value
+func (s *parser) parseVarDecl(start int, isConst bool) []Node {
+
+	s.skipSpaces()
+	name, ok := s.identifier()
+	if !ok {
+		s.errorAt(s.pos, "expected a variable name")
+		s.skipLine()
+		return nil
+	}
+
+	s.skipSpaces()
+	if !s.consume("=") {
+		s.errorAt(s.pos, "expected '=' after the variable name")
+		s.skipLine()
+		return nil
+	}
+
+	s.skipSpaces()
+	valueStart := s.pos
+	value := s.parseExpression()
+	if value == nil {
+		s.errorAt(valueStart, "expected a value for '%s'", name)
+		s.skipLine()
+		return nil
+	}
+
+	decl := &VarDecl{Name: name, Value: value, IsConst: isConst}
+	decl.Span = s.spanFrom(start)
+
+	s.expectLineEnd()
+	return []Node{decl}
+}
+
+// parseListDecl
+//
+//	LIST name = a, (b), c = 5
+func (s *parser) parseListDecl(start int) []Node {
+
+	s.skipSpaces()
+	name, ok := s.identifier()
+	if !ok {
+		s.errorAt(s.pos, "expected a list name")
+		s.skipLine()
+		return nil
+	}
+
+	s.skipSpaces()
+	if !s.consume("=") {
+		s.errorAt(s.pos, "expected '=' after the list name")
+		s.skipLine()
+		return nil
+	}
+
+	decl := &ListDecl{Name: name}
+	value := 0
+
+	for {
+		s.skipSpaces()
+		itemStart := s.pos
+
+		selected := s.consume("(")
+		s.skipSpaces()
+
+		itemName, ok := s.identifier()
+		if !ok {
+			s.errorAt(s.pos, "expected a list item name")
+			s.skipLine()
+			return nil
+		}
+
+		value++
+		hasValue := false
+		s.skipSpaces()
+		if s.consume("=") {
+			s.skipSpaces()
+			numberStart := s.pos
+			negative := s.consume("-")
+			for unicode.IsDigit(s.peek()) {
+				s.pos++
+			}
+			n, err := strconv.Atoi(string(s.src[numberStart:s.pos]))
+			if err != nil || (negative && s.pos == numberStart+1) {
+				s.errorAt(numberStart, "expected a number for the value of list item '%s'", itemName)
+				s.skipLine()
+				return nil
+			}
+			value = n
+			hasValue = true
+			s.skipSpaces()
+		}
+
+		if selected {
+			if !s.consume(")") {
+				s.errorAt(s.pos, "expected ')' after list item '%s'", itemName)
+				s.skipLine()
+				return nil
+			}
+			s.skipSpaces()
+		}
+
+		item := &ListItemDecl{Name: itemName, Value: value, Selected: selected, HasValue: hasValue}
+		item.Span = s.spanFrom(itemStart)
+		decl.Items = append(decl.Items, item)
+
+		if !s.consume(",") {
+			break
+		}
+	}
+
+	decl.Span = s.spanFrom(start)
+
+	s.expectLineEnd()
+	return []Node{decl}
+}
+
+// parseExternal
+//
+//	EXTERNAL name(a, b)
+func (s *parser) parseExternal(start int) []Node {
+
+	s.skipSpaces()
+	name, ok := s.identifier()
+	if !ok {
+		s.errorAt(s.pos, "expected the name of the external function")
+		s.skipLine()
+		return nil
+	}
+
+	s.skipSpaces()
+	if s.peek() != '(' {
+		s.errorAt(s.pos, "expected '(' after the external function's name")
+		s.skipLine()
+		return nil
+	}
+
+	ext := &External{Name: name, Params: s.parseParams()}
+	ext.Span = s.spanFrom(start)
+
+	s.expectLineEnd()
+	return []Node{ext}
+}
+
+// Weave points
+
+// bullets
+// Counts the *, + or - marks at the start of a choice or gather, which can
+// have spaces between them.
+func (s *parser) bullets(marks string) int {
+
+	depth := 0
+	for {
+		s.skipSpaces()
+		r := s.peek()
+		if !strings.ContainsRune(marks, r) || (r == '-' && s.peekAt(1) == '>') {
+			break
+		}
+		s.pos++
+		depth++
+	}
+
+	return depth
+}
+
+// label
+// An optional (label) after the bullets of a choice or gather.
+func (s *parser) label() string {
+
+	s.skipSpaces()
+	if s.peek() != '(' {
+		return ""
+	}
+
+	save := s.pos
+	s.pos++
+	s.skipSpaces()
+
+	name, ok := s.identifier()
+	s.skipSpaces()
+	if !ok || !s.consume(")") {
+		s.pos = save
+		return ""
+	}
+
+	s.skipSpaces()
+	return name
+}
+
+// parseChoice
+// The bullets of a choice, followed by
+//
+//	(label) {condition} start content [choice only content] inner content -> divert
+func (s *parser) parseChoice() *Choice {
+
+	start := s.pos
+	ch := &Choice{Sticky: s.peek() == '+'}
+	ch.Depth = s.bullets("*+")
+	ch.Label = s.label()
+
+	// {condition} before the text, as long as the braces hold a whole
+	// expression
+	for s.peek() == '{' {
+		save := s.pos
+		s.pos++
+		condition := s.parseExpression()
+		s.skipSpaces()
+		if condition == nil || !s.consume("}") {
+			s.pos = save
+			break
+		}
+		ch.Conditions = append(ch.Conditions, condition)
+		s.skipSpaces()
+	}
+
+	// A space before the brackets is kept, since it separates the start
+	// content from the rest of the text
+	ch.Start = s.parseMixed(contentChoice)
+	if s.peek() != '[' {
+		ch.Start = trimEnd(ch.Start)
+	}
+
+	if s.consume("[") {
+		ch.ChoiceOnly = trimEnd(s.parseMixed(contentChoice))
+		if !s.consume("]") {
+			s.errorAt(s.pos, "expected ']' to close the choice only content")
+		}
+	}
+
+	// * -> on its own is a fallback choice, with its content on the lines
+	// below, so the divert arrow has no target
+	if len(ch.Start) == 0 && len(ch.ChoiceOnly) == 0 && s.has("->") && !s.has("->->") {
+		save := s.pos
+		s.pos += 2
+		if !s.atLineEnd() {
+			s.pos = save
+		}
+	}
+
+	inner := s.parseMixed(contentNormal)
+
+	// The inner content ends the line, then come any diverts
+	var diverts []Node
+	for len(inner) > 0 {
+		if _, ok := inner[len(inner)-1].(*Divert); !ok {
+			break
+		}
+		diverts = append([]Node{inner[len(inner)-1]}, diverts...)
+		inner = inner[:len(inner)-1]
+	}
+	inner = trimEnd(inner)
+
+	if len(ch.Start) > 0 || len(ch.ChoiceOnly) > 0 || len(inner) > 0 {
+		inner = append(inner, s.newline())
+	}
+	ch.Inner = append(inner, diverts...)
+
+	ch.Span = s.spanFrom(start)
+	s.expectLineEndOrBlock()
+
+	return ch
+}
+
+// parseGather
+// The bullets of a gather, followed by
+//
+//	(label) content
+func (s *parser) parseGather() *Gather {
+
+	start := s.pos
+	g := &Gather{}
+	g.Depth = s.bullets("-")
+	g.Label = s.label()
+
+	if !s.atLineEnd() {
+		g.Content = s.parseLineContent()
+	} else {
+		s.skipLine()
+	}
+
+	g.Span = s.spanFrom(start)
+	return g
+}
+
+// Logic
+
+// parseLogicLine
+//
+//	~ temp x = 1
+//	~ x += 1
+//	~ return x
+//	~ f(x)
+func (s *parser) parseLogicLine() []Node {
+
+	start := s.pos
+	s.pos++
+	s.skipSpaces()
+
+	var statement Node
+
+	switch {
+	case s.keyword("temp"):
+		s.skipSpaces()
+		name, ok := s.identifier()
+		if !ok {
+			s.errorAt(s.pos, "expected a name for the temporary variable")
+			s.skipLine()
+			return nil
+		}
+		s.skipSpaces()
+		if !s.consume("=") {
+			s.errorAt(s.pos, "expected '=' after 'temp %s'", name)
+			s.skipLine()
+			return nil
+		}
+		value := s.expectExpression()
+		if value == nil {
+			s.skipLine()
+			return nil
+		}
+		statement = &Assignment{Name: name, Op: "=", Value: value, IsTemp: true}
+
+	case s.keyword("return"):
+		ret := &Return{}
+		if !s.atLineEnd() && !(s.blockDepth > 0 && s.peekNonSpace() == '}') {
+			ret.Value = s.expectExpression()
+		}
+		statement = ret
+
+	default:
+		if a := s.parseAssignment(); a != nil {
+			statement = a
+			break
+		}
+		value := s.expectExpression()
+		if value == nil {
+			s.skipLine()
+			return nil
+		}
+		statement = &ExprStatement{Value: value}
+	}
+
+	switch st := statement.(type) {
+	case *Assignment:
+		st.Span = s.spanFrom(start)
+	case *Return:
+		st.Span = s.spanFrom(start)
+	case *ExprStatement:
+		st.Span = s.spanFrom(start)
+	}
+
+	s.expectLineEndOrBlock()
+	return []Node{statement}
+}
+
+func (s *parser) peekNonSpace() rune {
+
+	save := s.pos
+	s.skipSpaces()
+	r := s.peek()
+	s.pos = save
+
+	return r
+}
+
+// parseAssignment
+// Parses x = 1, x += 1, x -= 1, x++ or x--, or returns nil with nothing
+// consumed if it isn't an assignment.
+func (s *parser) parseAssignment() *Assignment {
+
+	save := s.pos
+	name, ok := s.identifier()
+	if !ok {
+		return nil
+	}
+	s.skipSpaces()
+
+	switch {
+	case s.consume("++"):
+		return &Assignment{Name: name, Op: "++"}
+	case s.consume("--"):
+		return &Assignment{Name: name, Op: "--"}
+	case s.has("+=") || s.has("-="):
+		op := string(s.src[s.pos : s.pos+2])
+		s.pos += 2
+		if value := s.expectExpression(); value != nil {
+			return &Assignment{Name: name, Op: op, Value: value}
+		}
+	case s.peek() == '=' && s.peekAt(1) != '=':
+		s.pos++
+		if value := s.expectExpression(); value != nil {
+			return &Assignment{Name: name, Op: "=", Value: value}
+		}
+	}
+
+	s.pos = save
+	return nil
+}
+
+// parseThread
+//
+//	<- knot(args)
+func (s *parser) parseThread() []Node {
+
+	start := s.pos
+	s.pos += 2
+	s.skipSpaces()
+
+	target, ok := s.dottedIdentifier()
+	if !ok {
+		s.errorAt(s.pos, "expected a knot or stitch to start a thread with")
+		s.skipLine()
+		return nil
+	}
+
+	d := &Divert{Kind: DivertThread, Target: target, Args: s.parseArgs()}
+	d.Span = s.spanFrom(start)
+	s.expectLineEndOrBlock()
+
+	return []Node{d}
+}
+
+// parseDiverts
+// Parses a chain of diverts such as -> a, -> a -> or -> a -> b ->, where
+// all but the last are tunnels, or a tunnel return ->-> with an optional
+// target.
+func (s *parser) parseDiverts() []Node {
+
+	var diverts []*Divert
+
+	for {
+		s.skipSpaces()
+		start := s.pos
+
+		if s.consume("->->") {
+			d := &Divert{Kind: DivertTunnelReturn}
+			s.skipSpaces()
+			if target, ok := s.dottedIdentifier(); ok {
+				d.Target = target
+				d.Args = s.parseArgs()
+			}
+			d.Span = s.spanFrom(start)
+			diverts = append(diverts, d)
+			break
+		}
+
+		if !s.consume("->") {
+			break
+		}
+		s.skipSpaces()
+
+		target, ok := s.dottedIdentifier()
+		if !ok {
+			// -> a -> makes a a tunnel
+			if len(diverts) > 0 && diverts[len(diverts)-1].Kind == DivertNormal {
+				diverts[len(diverts)-1].Kind = DivertTunnel
+			} else {
+				s.errorAt(s.pos, "expected a divert target after '->'")
+			}
+			break
+		}
+
+		d := &Divert{Target: target, Args: s.parseArgs()}
+		d.Span = s.spanFrom(start)
+		diverts = append(diverts, d)
+	}
+
+	nodes := make([]Node, len(diverts))
+	for i, d := range diverts {
+		if i < len(diverts)-1 && d.Kind == DivertNormal {
+			d.Kind = DivertTunnel
+		}
+		nodes[i] = d
+	}
+
+	return nodes
+}
+
+// parseArgs
+// Optional (args) after the target of a divert.
+func (s *parser) parseArgs() []Expr {
+
+	if s.peek() != '(' {
+		return nil
+	}
+
+	s.pos++
+	var args []Expr
+	for {
+		s.skipSpaces()
+		if s.consume(")") {
+			break
+		}
+
+		argStart := s.pos
+		arg := s.parseExpression()
+		if arg == nil {
+			s.errorAt(argStart, "expected an argument")
+			for !s.eof() && s.peek() != ')' && s.peek() != '\n' {
+				s.pos++
+			}
+			s.consume(")")
+			break
+		}
+		args = append(args, arg)
+
+		s.skipSpaces()
+		if s.consume(")") {
+			break
+		}
+		if !s.consume(",") {
+			s.errorAt(s.pos, "expected ',' or ')' in the arguments")
+			break
+		}
+	}
+
+	if args == nil {
+		args = []Expr{}
+	}
+
+	return args
+}
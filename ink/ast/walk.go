@@ -0,0 +1,159 @@
+package ast
+
+// Visitor
+// Visit is called for each node Walk reaches. If the visitor it returns
+// isn't nil, Walk visits the node's children with it, then calls
+// Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk
+// Traverses the tree depth-first in source order, starting with node.
+// The files of includes are walked where the INCLUDE is.
+func Walk(v Visitor, node Node) {
+
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *File:
+		walkList(v, n.Body)
+
+	case *Include:
+		if n.File != nil {
+			Walk(v, n.File)
+		}
+
+	case *Knot:
+		walkParams(v, n.Params)
+		walkList(v, n.Body)
+		for _, stitch := range n.Stitches {
+			Walk(v, stitch)
+		}
+
+	case *Stitch:
+		walkParams(v, n.Params)
+		walkList(v, n.Body)
+
+	case *Function:
+		walkParams(v, n.Params)
+		walkList(v, n.Body)
+
+	case *Choice:
+		walkExprs(v, n.Conditions)
+		walkList(v, n.Start)
+		walkList(v, n.ChoiceOnly)
+		walkList(v, n.Inner)
+
+	case *Gather:
+		walkList(v, n.Content)
+
+	case *Tag:
+		walkList(v, n.Content)
+
+	case *Divert:
+		walkExprs(v, n.Args)
+
+	case *Output:
+		Walk(v, n.Value)
+
+	case *Conditional:
+		if n.Initial != nil {
+			Walk(v, n.Initial)
+		}
+		for _, b := range n.Branches {
+			Walk(v, b)
+		}
+
+	case *Branch:
+		if n.Condition != nil {
+			Walk(v, n.Condition)
+		}
+		walkList(v, n.Content)
+
+	case *Sequence:
+		for _, element := range n.Elements {
+			walkList(v, element)
+		}
+
+	case *Assignment:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+
+	case *Return:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+
+	case *ExprStatement:
+		Walk(v, n.Value)
+
+	case *VarDecl:
+		Walk(v, n.Value)
+
+	case *ListDecl:
+		for _, item := range n.Items {
+			Walk(v, item)
+		}
+
+	case *External:
+		walkParams(v, n.Params)
+
+	case *String:
+		walkList(v, n.Content)
+
+	case *Call:
+		walkExprs(v, n.Args)
+
+	case *Unary:
+		Walk(v, n.Value)
+
+	case *Binary:
+		Walk(v, n.Left)
+		Walk(v, n.Right)
+	}
+
+	v.Visit(nil)
+}
+
+func walkList(v Visitor, nodes []Node) {
+
+	for _, n := range nodes {
+		Walk(v, n)
+	}
+}
+
+func walkExprs(v Visitor, exprs []Expr) {
+
+	for _, e := range exprs {
+		Walk(v, e)
+	}
+}
+
+func walkParams(v Visitor, params []*Param) {
+
+	for _, p := range params {
+		Walk(v, p)
+	}
+}
+
+type inspector func(Node) bool
+
+func (s inspector) Visit(node Node) Visitor {
+
+	if s(node) {
+		return s
+	}
+
+	return nil
+}
+
+// Inspect
+// Walks the tree calling f for each node, and with nil after a node's
+// children. The children aren't visited when f returns false.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
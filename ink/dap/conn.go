@@ -0,0 +1,103 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/SirMetathyst/go-ink/ink/internal/jsonrpc"
+)
+
+// conn
+// A connection to a debugger client, with each message sent after a
+// Content-Length header as the protocol describes.
+type conn struct {
+	r *bufio.Reader
+
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+// read
+// Reads the next message. io.EOF is returned when the input ends between
+// messages.
+func (s *conn) read() (*Message, error) {
+
+	data, err := jsonrpc.Read(s.r)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := new(Message)
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("decoding message: %w", err)
+	}
+
+	return msg, nil
+}
+
+// write
+// Sends a message, numbering it after the last one sent. It's safe to
+// call from more than one goroutine.
+func (s *conn) write(msg *Message) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	msg.Seq = s.seq
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return jsonrpc.Write(s.w, data)
+}
+
+// respond
+// Sends the response to req. It's unsuccessful if err isn't nil.
+func (s *conn) respond(req *Message, body interface{}, err error) error {
+
+	success := err == nil
+	msg := &Message{Type: "response", Command: req.Command, RequestSeq: req.Seq, Success: &success}
+
+	if err != nil {
+		msg.Message = err.Error()
+		return s.write(msg)
+	}
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		msg.Body = data
+	}
+
+	return s.write(msg)
+}
+
+// event
+// Sends an event.
+func (s *conn) event(name string, body interface{}) error {
+
+	msg := &Message{Type: "event", Event: name}
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		msg.Body = data
+	}
+
+	return s.write(msg)
+}
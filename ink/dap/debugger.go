@@ -0,0 +1,614 @@
+package dap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// stepMode
+// How far the story plays when it's resumed.
+type stepMode int
+
+const (
+	// stepContinue plays until a breakpoint or a choice.
+	stepContinue stepMode = iota
+
+	// stepOver plays to the next line, passing over the functions and
+	// tunnels that it calls.
+	stepOver
+
+	// stepIn plays to the next line, wherever it is.
+	stepIn
+
+	// stepOut plays until the current function or tunnel has returned.
+	stepOut
+)
+
+// location
+// A line of ink source, and how deep the call stack is there.
+type location struct {
+	file   string
+	line   int
+	column int
+	depth  int
+}
+
+// stop
+// Why the story stopped, as a reason the protocol knows.
+type stop struct {
+	reason      string
+	description string
+	breakpoints []int
+}
+
+// debugger
+// Plays a story a step at a time, stopping at breakpoints, at choices
+// and when a step is complete.
+type debugger struct {
+	story  *runtime.Story
+	output func(category string, text string)
+
+	// lines
+	// The lines of each file that have content to stop at.
+	lines map[string]map[int]bool
+
+	breakpoints map[string]map[int]int
+	knots       map[*runtime.Container]int
+	nextID      int
+	noDebug     bool
+
+	mode   stepMode
+	from   location
+	last   location
+	seen   runtime.Pointer
+	hit    *stop
+	cancel context.CancelFunc
+
+	// lookahead
+	// Where the story has been while looking past the end of a line for
+	// glue. If the line did end there, the story rewinds and evaluates
+	// the same content again as part of the next line, when it's in
+	// rewound and mustn't be stopped at twice.
+	lookahead map[runtime.Pointer]bool
+	rewound   map[runtime.Pointer]bool
+}
+
+func newDebugger(story *runtime.Story, output func(category string, text string)) *debugger {
+
+	s := &debugger{
+		story:       story,
+		output:      output,
+		lines:       map[string]map[int]bool{},
+		breakpoints: map[string]map[int]int{},
+		knots:       map[*runtime.Container]int{},
+		seen:        runtime.NullPointer,
+		lookahead:   map[runtime.Pointer]bool{},
+		rewound:     map[runtime.Pointer]bool{},
+	}
+
+	s.index(story.MainContentContainer())
+
+	story.OnDidStep = new(runtime.ActionEvent)
+	story.OnDidStep.Register(s.onStep)
+
+	story.OnError = new(runtime.ErrorHandlerEvent)
+	story.OnError.Register(func(message string, typ runtime.ErrorType) {
+		s.output("stderr", message+"\n")
+	})
+
+	return s
+}
+
+// index
+// Records the lines that content in c comes from.
+func (s *debugger) index(c *runtime.Container) {
+
+	visit := func(obj runtime.Object) {
+
+		if inner, ok := obj.(*runtime.Container); ok {
+			s.index(inner)
+			return
+		}
+
+		if dm := obj.DebugMetadata(); dm != nil {
+			if s.lines[dm.FileName] == nil {
+				s.lines[dm.FileName] = map[int]bool{}
+			}
+			s.lines[dm.FileName][dm.StartLineNumber] = true
+		}
+	}
+
+	for _, obj := range c.Content() {
+		visit(obj)
+	}
+	for _, obj := range c.NamedOnlyContent() {
+		visit(obj)
+	}
+}
+
+// setBreakpoints
+// Replaces the breakpoints in a file, which is named as it is in the
+// story's DebugMetadata.
+func (s *debugger) setBreakpoints(file string, lines []int) []Breakpoint {
+
+	s.breakpoints[file] = map[int]int{}
+
+	breakpoints := []Breakpoint{}
+	for _, line := range lines {
+		s.nextID++
+		bp := Breakpoint{ID: s.nextID, Line: line}
+
+		if s.lines[file][line] {
+			bp.Verified = true
+			s.breakpoints[file][line] = bp.ID
+		} else {
+			bp.Message = "there's no ink to stop at on this line"
+		}
+
+		breakpoints = append(breakpoints, bp)
+	}
+
+	return breakpoints
+}
+
+// setKnotBreakpoints
+// Replaces the breakpoints on entering knots, stitches and functions,
+// which are named by their paths, such as knot.stitch.
+func (s *debugger) setKnotBreakpoints(names []string) []Breakpoint {
+
+	s.knots = map[*runtime.Container]int{}
+
+	breakpoints := []Breakpoint{}
+	for _, name := range names {
+		s.nextID++
+		bp := Breakpoint{ID: s.nextID}
+
+		c, _ := s.story.ContentAtPath(runtime.NewPathFromString(name)).CorrectObj().(*runtime.Container)
+		if c != nil && name != "" {
+			bp.Verified = true
+			s.knots[c] = bp.ID
+			if dm := c.DebugMetadata(); dm != nil {
+				bp.Line = dm.StartLineNumber
+			}
+		} else {
+			bp.Message = fmt.Sprintf("there's no knot, stitch or function called '%s'", name)
+		}
+
+		breakpoints = append(breakpoints, bp)
+	}
+
+	return breakpoints
+}
+
+// resume
+// Sets how far the story will play when it's next run.
+func (s *debugger) resume(mode stepMode) {
+
+	s.mode = mode
+	s.from = s.here()
+}
+
+// settle
+// Treats where the story is now as somewhere it has already stopped, as
+// when it has just started or a choice has been taken.
+func (s *debugger) settle() {
+
+	s.seen = s.story.State().CurrentPointer()
+	s.last = s.here()
+}
+
+// atChoice
+// Whether the story is waiting for a choice to be taken.
+func (s *debugger) atChoice() bool {
+
+	return !s.story.CanContinue() && len(s.story.CurrentChoices()) > 0
+}
+
+// run
+// Plays the story until it should stop, which is returned, or until it
+// ends, when nil is returned. Cancelling ctx pauses it.
+func (s *debugger) run(ctx context.Context) *stop {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.cancel = cancel
+	s.hit = nil
+
+	for s.story.CanContinue() {
+
+		// Between lines, the next one can be stopped at before any of it
+		// has been evaluated
+		if s.story.AsyncContinueComplete() {
+			s.check(false)
+			if s.hit != nil {
+				return s.hit
+			}
+		}
+
+		fresh := s.story.AsyncContinueComplete()
+		text, err := s.story.ContinueContext(ctx)
+		if !s.story.AsyncContinueComplete() || err != nil && err == ctx.Err() {
+			if s.hit != nil {
+				return s.hit
+			}
+			return &stop{reason: "pause"}
+		}
+		if err != nil {
+			s.output("stderr", err.Error()+"\n")
+			break
+		}
+
+		s.rewound, s.lookahead = s.lookahead, map[runtime.Pointer]bool{}
+
+		// Text that runs into a divert has no newline of its own
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		s.output("stdout", text)
+		for _, tag := range s.story.CurrentTags() {
+			s.output("console", "# "+tag+"\n")
+		}
+
+		if s.hit != nil {
+			break
+		}
+
+		if ctx.Err() != nil {
+			s.hit = &stop{reason: "pause"}
+			break
+		}
+
+		// Without DebugMetadata, as for a story loaded from JSON, a step is
+		// a line of output. Finishing the line the story stopped partway
+		// through doesn't count.
+		if s.mode != stepContinue && s.from.line == 0 && fresh {
+			s.hit = &stop{reason: "step"}
+			break
+		}
+	}
+
+	if !s.story.CanContinue() {
+		choices := s.story.CurrentChoices()
+		if len(choices) == 0 {
+			return s.hit
+		}
+
+		for i, choice := range choices {
+			s.output("stdout", fmt.Sprintf("%d: %s\n", i, choice.Text))
+		}
+
+		if s.hit == nil {
+			s.hit = &stop{reason: "choice", description: "Paused at a choice"}
+		}
+	}
+
+	return s.hit
+}
+
+// onStep
+// Called after each step the story takes, to stop it partway through
+// a line.
+func (s *debugger) onStep() {
+
+	if s.hit != nil {
+		return
+	}
+
+	state := s.story.State()
+	s.check(state.OutputStreamEndsInNewline() && !state.InStringEvaluation())
+
+	if s.hit != nil {
+		s.cancel()
+	}
+}
+
+// check
+// Decides whether the story should stop before the content it's about
+// to evaluate, setting hit if it should.
+func (s *debugger) check(lookingAhead bool) {
+
+	if s.noDebug {
+		return
+	}
+
+	pointer := s.story.State().CurrentPointer()
+	if pointer.IsNull() || pointer == s.seen {
+		return
+	}
+	s.seen = pointer
+
+	if s.lookahead[pointer] || s.rewound[pointer] {
+		delete(s.rewound, pointer)
+		if at := s.here(); at.line != 0 {
+			s.last = at
+		}
+		return
+	}
+
+	if lookingAhead {
+		s.lookahead[pointer] = true
+	}
+
+	s.hit = s.hitAt(pointer)
+}
+
+func (s *debugger) hitAt(pointer runtime.Pointer) *stop {
+
+	if id, ok := s.entering(pointer); ok {
+		return &stop{reason: "function breakpoint", breakpoints: []int{id}}
+	}
+
+	at := s.here()
+	if at.line == 0 || at.file == s.last.file && at.line == s.last.line {
+		return nil
+	}
+	s.last = at
+
+	if id, ok := s.breakpoints[at.file][at.line]; ok {
+		return &stop{reason: "breakpoint", breakpoints: []int{id}}
+	}
+
+	// Stepping over a line passes the functions and tunnels it calls, and
+	// the rest of the line after they return
+	sameLine := at.file == s.from.file && at.line == s.from.line
+
+	switch {
+	case s.mode == stepIn,
+		s.mode == stepOver && (at.depth < s.from.depth || at.depth == s.from.depth && !sameLine),
+		s.mode == stepOut && at.depth < s.from.depth:
+		return &stop{reason: "step"}
+	}
+
+	return nil
+}
+
+// entering
+// The knot breakpoint on a container that the story is about to enter
+// at pointer, if there is one.
+func (s *debugger) entering(pointer runtime.Pointer) (int, bool) {
+
+	if pointer.Index == 0 {
+		if id, ok := s.knots[pointer.Container]; ok {
+			return id, true
+		}
+	}
+
+	obj := pointer.Resolve()
+	for {
+		c, ok := obj.(*runtime.Container)
+		if !ok {
+			return 0, false
+		}
+
+		if id, ok := s.knots[c]; ok {
+			return id, true
+		}
+
+		if len(c.Content()) == 0 {
+			return 0, false
+		}
+		obj = c.Content()[0]
+	}
+}
+
+// here
+// Where the story is now.
+func (s *debugger) here() location {
+
+	at := locate(s.story.State().CurrentPointer())
+	at.depth = len(s.story.State().CallStack().Elements())
+	return at
+}
+
+// locate
+// The source of the content at pointer. When that's a container, it's
+// the source of the first content inside it that will be evaluated.
+func locate(pointer runtime.Pointer) location {
+
+	if pointer.IsNull() {
+		return location{}
+	}
+
+	obj := pointer.Resolve()
+	for {
+		c, ok := obj.(*runtime.Container)
+		if !ok || len(c.Content()) == 0 {
+			break
+		}
+		obj = c.Content()[0]
+	}
+
+	if obj == nil {
+		return location{}
+	}
+
+	dm := obj.DebugMetadata()
+	if dm == nil {
+		return location{}
+	}
+
+	return location{file: dm.FileName, line: dm.StartLineNumber, column: dm.StartCharacterNumber}
+}
+
+// frame
+// An element of the call stack of one of the story's threads.
+type frame struct {
+	name    string
+	at      location
+	subtle  bool
+	element *runtime.Element
+}
+
+// frames
+// The call stack, innermost first, with its tunnels and functions, and
+// then the threads the current one was started from.
+func (s *debugger) frames() []frame {
+
+	threads := s.story.State().CallStack().Threads()
+
+	var frames []frame
+	for t := len(threads) - 1; t >= 0; t-- {
+		elements := threads[t].Elements()
+
+		for i := len(elements) - 1; i >= 0; i-- {
+			element := elements[i]
+
+			// At a choice or the end there's nothing left to evaluate, so
+			// show what was evaluated last
+			pointer := element.CurrentPointer
+			if pointer.IsNull() && i == len(elements)-1 {
+				pointer = threads[t].PreviousPointer
+			}
+
+			f := frame{name: pathName(pointer), at: locate(pointer), subtle: t != len(threads)-1, element: element}
+
+			switch {
+			case i == 0:
+			case element.PushPopType() == runtime.Tunnel:
+				f.name += " (tunnel)"
+			default:
+				f.name += " (function)"
+			}
+
+			if len(threads) > 1 {
+				f.name += fmt.Sprintf(" [thread %d]", threads[t].ThreadIndex)
+			}
+
+			frames = append(frames, f)
+		}
+	}
+
+	return frames
+}
+
+// pathName
+// The knot, stitch or function that pointer is in, such as knot.stitch.
+func pathName(pointer runtime.Pointer) string {
+
+	if pointer.IsNull() {
+		return "?"
+	}
+
+	path := pointer.Container.Path(pointer.Container)
+
+	var names []string
+	for i := 0; i < path.Length() && !path.Component(i).IsIndex(); i++ {
+		names = append(names, path.Component(i).Name())
+	}
+
+	if len(names) == 0 {
+		return "root"
+	}
+
+	return strings.Join(names, ".")
+}
+
+// temporaries
+// The temporary variables and parameters of a frame, by name.
+func (s *debugger) temporaries(f frame) []Variable {
+
+	names := make([]string, 0, len(f.element.TemporaryVariables))
+	for name := range f.element.TemporaryVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	variables := []Variable{}
+	for _, name := range names {
+		variables = append(variables, s.variable(name, f.element.TemporaryVariables[name]))
+	}
+
+	return variables
+}
+
+// globals
+// The global variables, by name.
+func (s *debugger) globals() []Variable {
+
+	vs := s.story.State().VariablesState()
+
+	variables := []Variable{}
+	for _, name := range vs.GlobalVariableNames() {
+		variables = append(variables, s.variable(name, vs.GetVariableWithName(name, 0)))
+	}
+
+	return variables
+}
+
+// evaluationStack
+// The values on the evaluation stack, from the bottom up.
+func (s *debugger) evaluationStack() []Variable {
+
+	variables := []Variable{}
+	for i, obj := range s.story.State().EvaluationStack() {
+		variables = append(variables, s.variable(strconv.Itoa(i), obj))
+	}
+
+	return variables
+}
+
+// lookup
+// The value of a temporary variable in f or, failing that, a global.
+func (s *debugger) lookup(name string, f *frame) (Variable, bool) {
+
+	if f != nil {
+		if obj, ok := f.element.TemporaryVariables[name]; ok {
+			return s.variable(name, obj), true
+		}
+	}
+
+	vs := s.story.State().VariablesState()
+	if vs.GlobalVariableExistsWithName(name) {
+		return s.variable(name, vs.GetVariableWithName(name, 0)), true
+	}
+
+	return Variable{}, false
+}
+
+// variable
+// Shows a runtime object as a variable. Variables passed by reference
+// show the value of the variable they refer to.
+func (s *debugger) variable(name string, obj runtime.Object) Variable {
+
+	if p, ok := obj.(*runtime.VariablePointerValue); ok {
+		v := s.variable(name, s.story.State().VariablesState().ValueAtVariablePointer(p))
+		v.Type = "ref " + v.Type
+		return v
+	}
+
+	v := Variable{Name: name}
+
+	switch obj := obj.(type) {
+	case nil:
+		v.Value = "null"
+
+	case *runtime.BoolValue:
+		v.Value, v.Type = obj.String(), "bool"
+
+	case *runtime.IntValue:
+		v.Value, v.Type = obj.String(), "int"
+
+	case *runtime.FloatValue:
+		v.Value, v.Type = obj.String(), "float"
+
+	case *runtime.StringValue:
+		v.Value, v.Type = strconv.Quote(obj.Value()), "string"
+
+	case *runtime.ListValue:
+		v.Value, v.Type = "("+obj.String()+")", "list"
+
+	case *runtime.DivertTargetValue:
+		v.Value, v.Type = "-> "+obj.TargetPath().ComponentsString(), "divert target"
+
+	default:
+		v.Value = strings.TrimPrefix(fmt.Sprintf("%T", obj), "*runtime.")
+	}
+
+	return v
+}
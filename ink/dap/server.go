@@ -0,0 +1,626 @@
+// Package dap is a Debug Adapter Protocol server for ink, as used by the
+// ink-dap command.
+//
+//	err := dap.NewServer().Serve(os.Stdin, os.Stdout)
+//
+// It plays a .ink story, compiled with the compiler package, or a story
+// compiled by inklecate to .ink.json. Breakpoints are set on lines of ink
+// source, matched through the DebugMetadata the compiler gives each
+// object, or on entering a knot, stitch or function by its path. The
+// story can be stepped a line at a time, in and out of the functions and
+// tunnels it calls, and it always stops when it reaches a choice: the
+// choice to take is typed into the debug console by its number. While
+// it's stopped the call stack shows its tunnels, functions and threads,
+// and the global variables, each frame's temporaries and the evaluation
+// stack can be inspected.
+//
+// The story's text is sent as output once each line is complete, which
+// the story only knows after looking ahead for glue, so it can stop on
+// a line before the text of the line above has been sent. Stories loaded
+// from JSON have no DebugMetadata, so only knot breakpoints work for
+// them, and a step is a line of output.
+package dap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SirMetathyst/go-ink/compiler"
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// threadID
+// The one thread the protocol sees. The story's own threads are shown in
+// its call stack.
+const threadID = 1
+
+// The variables references of the scopes. Each frame's temporaries are
+// at temporariesRef plus the frame's index.
+const (
+	globalsRef = iota + 1
+	evaluationStackRef
+	temporariesRef
+)
+
+// Server
+// A debug adapter for one client, playing one story.
+type Server struct {
+
+	// DirFS
+	// Opens the directory that a story's root file is in, to read it and
+	// the files it includes. It's os.DirFS when it isn't set.
+	DirFS func(dir string) fs.FS
+
+	conn         *conn
+	debugger     *debugger
+	dir          string
+	lineOffset   int
+	columnOffset int
+	stopOnEntry  bool
+	ended        bool
+	chose        bool
+
+	// mu
+	// Guards running and pause. While the story is running, only the
+	// goroutine playing it may touch the debugger.
+	mu      sync.Mutex
+	running bool
+	pause   context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Serve
+// Answers the requests that are read from r, writing to w, until the
+// client disconnects or r ends.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+
+	s.conn = newConn(r, w)
+
+	defer func() {
+		s.mu.Lock()
+		if s.pause != nil {
+			s.pause()
+		}
+		s.mu.Unlock()
+		s.wg.Wait()
+	}()
+
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Type != "request" {
+			continue
+		}
+
+		body, err := s.handle(msg)
+		if err := s.conn.respond(msg, body, err); err != nil {
+			return err
+		}
+		if err != nil {
+			continue
+		}
+
+		switch msg.Command {
+		case "initialize":
+			// The story isn't ready to be configured until it's launched
+		case "launch":
+			err = s.conn.event("initialized", nil)
+		case "configurationDone":
+			err = s.start()
+		case "evaluate":
+			// The client is told where a choice led, as if it had stepped
+			if s.chose {
+				s.chose = false
+				err = s.stopped(&stop{reason: "step", description: "Took a choice"})
+			}
+		case "terminate":
+			err = s.terminate()
+		case "disconnect":
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle
+// Dispatches a request to its command, returning the body of the
+// response.
+func (s *Server) handle(msg *Message) (interface{}, error) {
+
+	switch msg.Command {
+	case "initialize":
+		var args InitializeRequestArguments
+		if err := unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.initialize(args), nil
+
+	case "launch":
+		var args LaunchRequestArguments
+		if err := unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(args)
+
+	case "disconnect", "terminate":
+		return nil, nil
+	}
+
+	if s.debugger == nil {
+		return nil, errors.New("no story has been launched")
+	}
+
+	switch msg.Command {
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.setBreakpoints(args)
+
+	case "setFunctionBreakpoints":
+		var args SetFunctionBreakpointsArguments
+		if err := unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.setFunctionBreakpoints(args)
+
+	case "setExceptionBreakpoints":
+		return SetBreakpointsResponseBody{Breakpoints: []Breakpoint{}}, nil
+
+	case "configurationDone":
+		return nil, nil
+
+	case "threads":
+		return ThreadsResponseBody{Threads: []Thread{{ID: threadID, Name: "story"}}}, nil
+
+	case "pause":
+		s.mu.Lock()
+		if s.pause != nil {
+			s.pause()
+		}
+		s.mu.Unlock()
+		return nil, nil
+
+	case "continue":
+		return ContinueResponseBody{AllThreadsContinued: true}, s.resume(stepContinue)
+
+	case "next":
+		return nil, s.resume(stepOver)
+
+	case "stepIn":
+		return nil, s.resume(stepIn)
+
+	case "stepOut":
+		return nil, s.resume(stepOut)
+
+	case "stackTrace":
+		var args StackTraceArguments
+		if err := unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.stackTrace(args)
+
+	case "scopes":
+		var args ScopesArguments
+		if err := unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.scopes(args)
+
+	case "variables":
+		var args VariablesArguments
+		if err := unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.variables(args)
+
+	case "evaluate":
+		var args EvaluateArguments
+		if err := unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.evaluate(args)
+	}
+
+	return nil, fmt.Errorf("unknown command '%s'", msg.Command)
+}
+
+func unmarshal(arguments json.RawMessage, v interface{}) error {
+
+	if len(arguments) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(arguments, v); err != nil {
+		return fmt.Errorf("bad arguments: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) initialize(args InitializeRequestArguments) Capabilities {
+
+	if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+		s.lineOffset = 1
+	}
+	if args.ColumnsStartAt1 != nil && !*args.ColumnsStartAt1 {
+		s.columnOffset = 1
+	}
+
+	return Capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsFunctionBreakpoints:      true,
+		SupportsEvaluateForHovers:        true,
+		SupportsTerminateRequest:         true,
+	}
+}
+
+// launch
+// Loads the story, ready to be configured and then played.
+func (s *Server) launch(args LaunchRequestArguments) error {
+
+	if s.debugger != nil {
+		return errors.New("a story has already been launched")
+	}
+
+	if args.Program == "" {
+		return errors.New("no program to launch")
+	}
+
+	program, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+
+	dirFS := s.DirFS
+	if dirFS == nil {
+		dirFS = os.DirFS
+	}
+
+	s.dir = filepath.Dir(program)
+	fsys := dirFS(s.dir)
+	name := filepath.Base(program)
+
+	var story *runtime.Story
+	if strings.HasSuffix(name, ".ink") {
+		story, err = compiler.CompileFile(fsys, name, nil)
+	} else {
+		var f fs.File
+		if f, err = fsys.Open(name); err == nil {
+			story, err = runtime.LoadStory(f)
+			f.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	story.AllowExternalFunctionFallbacks = true
+
+	s.debugger = newDebugger(story, func(category string, text string) {
+		if text != "" {
+			_ = s.conn.event("output", OutputEventBody{Category: category, Output: text})
+		}
+	})
+	s.debugger.noDebug = args.NoDebug
+	s.stopOnEntry = args.StopOnEntry && !args.NoDebug
+
+	return nil
+}
+
+// start
+// Plays the story once the client has set its breakpoints, or stops it
+// before it starts.
+func (s *Server) start() error {
+
+	if s.stopOnEntry {
+		s.debugger.settle()
+		return s.stopped(&stop{reason: "entry"})
+	}
+
+	return s.resume(stepContinue)
+}
+
+// resume
+// Plays the story in the background until it stops or ends.
+func (s *Server) resume(mode stepMode) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.running:
+		return errors.New("the story is already running")
+	case s.ended:
+		return errors.New("the story has ended")
+	case s.debugger.atChoice():
+		return errors.New("the story is waiting for a choice: type its number in the debug console")
+	}
+
+	s.debugger.resume(mode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.running = true
+	s.pause = cancel
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		st := s.debugger.run(ctx)
+		cancel()
+
+		s.mu.Lock()
+		s.running = false
+		s.pause = nil
+		s.ended = st == nil
+		s.mu.Unlock()
+
+		if st == nil {
+			_ = s.conn.event("exited", ExitedEventBody{})
+			_ = s.conn.event("terminated", nil)
+			return
+		}
+		_ = s.stopped(st)
+	}()
+
+	return nil
+}
+
+func (s *Server) stopped(st *stop) error {
+
+	return s.conn.event("stopped", StoppedEventBody{
+		Reason:            st.reason,
+		Description:       st.description,
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+		HitBreakpointIDs:  st.breakpoints,
+	})
+}
+
+// terminate
+// Ends the story early, at the client's request.
+func (s *Server) terminate() error {
+
+	s.mu.Lock()
+	if s.pause != nil {
+		s.pause()
+	}
+	s.ended = true
+	s.mu.Unlock()
+
+	// Wait for the story to stop, so that terminated is the last event
+	s.wg.Wait()
+
+	return s.conn.event("terminated", nil)
+}
+
+// stoppedDebugger
+// The debugger, if the story isn't running, so that it can be inspected.
+func (s *Server) stoppedDebugger() (*debugger, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return nil, errors.New("the story is running")
+	}
+
+	return s.debugger, nil
+}
+
+// fileName
+// The name that DebugMetadata gives the source file at p: its path
+// relative to the root file's directory.
+func (s *Server) fileName(p string) string {
+
+	if rel, err := filepath.Rel(s.dir, p); err == nil {
+		return filepath.ToSlash(rel)
+	}
+
+	return filepath.ToSlash(p)
+}
+
+// source
+// The source file that DebugMetadata names.
+func (s *Server) source(name string) *Source {
+
+	if name == "" {
+		return nil
+	}
+
+	return &Source{Name: path.Base(name), Path: filepath.Join(s.dir, filepath.FromSlash(name))}
+}
+
+func (s *Server) setBreakpoints(args SetBreakpointsArguments) (interface{}, error) {
+
+	d, err := s.stoppedDebugger()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []int
+	for _, bp := range args.Breakpoints {
+		lines = append(lines, bp.Line+s.lineOffset)
+	}
+
+	breakpoints := d.setBreakpoints(s.fileName(args.Source.Path), lines)
+	for i := range breakpoints {
+		breakpoints[i].Line -= s.lineOffset
+		breakpoints[i].Source = &args.Source
+	}
+
+	return SetBreakpointsResponseBody{Breakpoints: breakpoints}, nil
+}
+
+func (s *Server) setFunctionBreakpoints(args SetFunctionBreakpointsArguments) (interface{}, error) {
+
+	d, err := s.stoppedDebugger()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, bp := range args.Breakpoints {
+		names = append(names, bp.Name)
+	}
+
+	breakpoints := d.setKnotBreakpoints(names)
+	for i := range breakpoints {
+		if breakpoints[i].Line != 0 {
+			breakpoints[i].Line -= s.lineOffset
+		}
+	}
+
+	return SetBreakpointsResponseBody{Breakpoints: breakpoints}, nil
+}
+
+func (s *Server) stackTrace(args StackTraceArguments) (interface{}, error) {
+
+	d, err := s.stoppedDebugger()
+	if err != nil {
+		return nil, err
+	}
+
+	frames := d.frames()
+
+	stackFrames := []StackFrame{}
+	for i, f := range frames {
+		if i < args.StartFrame || args.Levels > 0 && i >= args.StartFrame+args.Levels {
+			continue
+		}
+
+		sf := StackFrame{ID: i + 1, Name: f.name, Source: s.source(f.at.file)}
+		if f.at.line != 0 {
+			sf.Line = f.at.line - s.lineOffset
+			sf.Column = f.at.column - s.columnOffset
+		}
+		if f.subtle {
+			sf.PresentationHint = "subtle"
+		}
+
+		stackFrames = append(stackFrames, sf)
+	}
+
+	return StackTraceResponseBody{StackFrames: stackFrames, TotalFrames: len(frames)}, nil
+}
+
+// frame
+// The frame with the protocol's id, which counts from 1 at the top of
+// the stack.
+func (s *Server) frame(d *debugger, id int) (*frame, error) {
+
+	frames := d.frames()
+	if id < 1 || id > len(frames) {
+		return nil, fmt.Errorf("there's no frame %d", id)
+	}
+
+	return &frames[id-1], nil
+}
+
+func (s *Server) scopes(args ScopesArguments) (interface{}, error) {
+
+	d, err := s.stoppedDebugger()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.frame(d, args.FrameID); err != nil {
+		return nil, err
+	}
+
+	return ScopesResponseBody{Scopes: []Scope{
+		{Name: "Temporaries", PresentationHint: "locals", VariablesReference: temporariesRef + args.FrameID - 1},
+		{Name: "Globals", VariablesReference: globalsRef},
+		{Name: "Evaluation Stack", VariablesReference: evaluationStackRef},
+	}}, nil
+}
+
+func (s *Server) variables(args VariablesArguments) (interface{}, error) {
+
+	d, err := s.stoppedDebugger()
+	if err != nil {
+		return nil, err
+	}
+
+	switch args.VariablesReference {
+	case globalsRef:
+		return VariablesResponseBody{Variables: d.globals()}, nil
+
+	case evaluationStackRef:
+		return VariablesResponseBody{Variables: d.evaluationStack()}, nil
+	}
+
+	f, err := s.frame(d, args.VariablesReference-temporariesRef+1)
+	if err != nil {
+		return nil, err
+	}
+
+	return VariablesResponseBody{Variables: d.temporaries(*f)}, nil
+}
+
+// evaluate
+// Looks up a variable or, when the story is waiting for a choice, takes
+// the choice with the number given.
+func (s *Server) evaluate(args EvaluateArguments) (interface{}, error) {
+
+	d, err := s.stoppedDebugger()
+	if err != nil {
+		return nil, err
+	}
+
+	expression := strings.TrimSpace(args.Expression)
+
+	if n, err := strconv.Atoi(expression); err == nil && args.Context == "repl" && d.atChoice() {
+		choices := d.story.CurrentChoices()
+		if n < 0 || n >= len(choices) {
+			return nil, fmt.Errorf("there's no choice %d", n)
+		}
+
+		text := choices[n].Text
+		if err := d.story.ChooseChoiceIndex(n); err != nil {
+			return nil, err
+		}
+		d.settle()
+		s.chose = true
+
+		return EvaluateResponseBody{Result: "-> " + text}, nil
+	}
+
+	var f *frame
+	if args.FrameID != 0 {
+		if f, err = s.frame(d, args.FrameID); err != nil {
+			return nil, err
+		}
+	}
+
+	v, ok := d.lookup(expression, f)
+	if !ok {
+		return nil, fmt.Errorf("there's no variable called '%s'", expression)
+	}
+
+	return EvaluateResponseBody{Result: v.Value, Type: v.Type}, nil
+}
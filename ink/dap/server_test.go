@@ -0,0 +1,461 @@
+package dap
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mainSource = `INCLUDE part.ink
+VAR score = 0
+-> intro
+
+== intro ==
+Hello.
+~ temp bonus = 10 + add(1, 2)
+-> meet ->
+~ score = bonus
+
+* [Pick] -> picked
+* [Other] -> END
+
+== picked ==
+You picked. {score}
+-> choices
+
+== meet ==
+Meet. ->->
+
+== function add(a, b) ==
+~ return a + b
+`
+
+const partSource = `== choices ==
+<- options
+* [Own] -> END
+
+= options
+* [Threaded] -> END
+`
+
+// client
+// A scripted debugger client, talking to an adapter in-process.
+type client struct {
+	t        *testing.T
+	conn     *conn
+	messages chan *Message
+	served   chan error
+	output   strings.Builder
+}
+
+func newClient(t *testing.T, files fstest.MapFS) *client {
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	server := NewServer()
+	server.DirFS = func(dir string) fs.FS {
+		sub, err := fs.Sub(files, strings.TrimPrefix(dir, "/"))
+		require.NoError(t, err)
+		return sub
+	}
+
+	c := &client{
+		t:        t,
+		conn:     newConn(clientIn, clientOut),
+		messages: make(chan *Message, 256),
+		served:   make(chan error, 1),
+	}
+
+	go func() {
+		c.served <- server.Serve(serverIn, serverOut)
+		serverOut.Close()
+	}()
+
+	go func() {
+		defer close(c.messages)
+		for {
+			msg, err := c.conn.read()
+			if err != nil {
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+
+	t.Cleanup(func() { clientOut.Close() })
+
+	var capabilities Capabilities
+	require.NoError(t, c.call("initialize", InitializeRequestArguments{AdapterID: "ink"}, &capabilities))
+	assert.True(t, capabilities.SupportsConfigurationDoneRequest)
+
+	return c
+}
+
+// launch
+// Starts a client and launches program, but doesn't finish configuring
+// it.
+func launch(t *testing.T, program string, stopOnEntry bool) *client {
+
+	c := newClient(t, fstest.MapFS{
+		"story/main.ink": {Data: []byte(mainSource)},
+		"story/part.ink": {Data: []byte(partSource)},
+	})
+
+	require.NoError(t, c.call("launch", LaunchRequestArguments{Program: program, StopOnEntry: stopOnEntry}, nil))
+	c.wait("initialized")
+
+	return c
+}
+
+// next
+// The next message from the adapter. Output is collected as it arrives.
+func (s *client) next() *Message {
+
+	select {
+	case msg, ok := <-s.messages:
+		require.True(s.t, ok, "the adapter closed the connection")
+		if msg.Event == "output" {
+			var body OutputEventBody
+			require.NoError(s.t, json.Unmarshal(msg.Body, &body))
+			s.output.WriteString(body.Output)
+		}
+		return msg
+
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("timed out waiting for the adapter")
+		return nil
+	}
+}
+
+func (s *client) call(command string, arguments interface{}, body interface{}) error {
+
+	data, err := json.Marshal(arguments)
+	require.NoError(s.t, err)
+
+	req := &Message{Type: "request", Command: command, Arguments: data}
+	require.NoError(s.t, s.conn.write(req))
+
+	for {
+		msg := s.next()
+		if msg.Type != "response" || msg.RequestSeq != req.Seq {
+			continue
+		}
+		assert.Equal(s.t, command, msg.Command)
+		if !*msg.Success {
+			return &responseError{msg.Message}
+		}
+		if body != nil {
+			require.NoError(s.t, json.Unmarshal(msg.Body, body))
+		}
+		return nil
+	}
+}
+
+type responseError struct {
+	message string
+}
+
+func (s *responseError) Error() string {
+	return s.message
+}
+
+// wait
+// Waits for an event, returning its body.
+func (s *client) wait(event string) json.RawMessage {
+
+	for {
+		msg := s.next()
+		if msg.Type == "event" && msg.Event == event {
+			return msg.Body
+		}
+	}
+}
+
+// stopped
+// Waits for the story to stop.
+func (s *client) stopped() StoppedEventBody {
+
+	var body StoppedEventBody
+	require.NoError(s.t, json.Unmarshal(s.wait("stopped"), &body))
+	return body
+}
+
+func (s *client) step(command string) StoppedEventBody {
+
+	require.NoError(s.t, s.call(command, StepArguments{ThreadID: threadID}, nil))
+	return s.stopped()
+}
+
+func (s *client) stackTrace() []StackFrame {
+
+	var body StackTraceResponseBody
+	require.NoError(s.t, s.call("stackTrace", StackTraceArguments{ThreadID: threadID}, &body))
+	return body.StackFrames
+}
+
+// variables
+// The variables of a scope of the top frame, as name=value.
+func (s *client) variables(scope string) []string {
+
+	var scopes ScopesResponseBody
+	require.NoError(s.t, s.call("scopes", ScopesArguments{FrameID: 1}, &scopes))
+
+	for _, sc := range scopes.Scopes {
+		if sc.Name != scope {
+			continue
+		}
+
+		var body VariablesResponseBody
+		require.NoError(s.t, s.call("variables", VariablesArguments{VariablesReference: sc.VariablesReference}, &body))
+
+		variables := []string{}
+		for _, v := range body.Variables {
+			variables = append(variables, v.Name+"="+v.Value)
+		}
+		return variables
+	}
+
+	s.t.Fatalf("there's no scope %q", scope)
+	return nil
+}
+
+func (s *client) setBreakpoints(path string, lines ...int) []Breakpoint {
+
+	args := SetBreakpointsArguments{Source: Source{Path: path}}
+	for _, line := range lines {
+		args.Breakpoints = append(args.Breakpoints, SourceBreakpoint{Line: line})
+	}
+
+	var body SetBreakpointsResponseBody
+	require.NoError(s.t, s.call("setBreakpoints", args, &body))
+	return body.Breakpoints
+}
+
+// lines
+// The top frame's line, and the lines of the frames below it.
+func lines(frames []StackFrame) []int {
+
+	var lines []int
+	for _, f := range frames {
+		lines = append(lines, f.Line)
+	}
+	return lines
+}
+
+func names(frames []StackFrame) []string {
+
+	var names []string
+	for _, f := range frames {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestServerStepping(t *testing.T) {
+
+	c := launch(t, "/story/main.ink", true)
+	require.NoError(t, c.call("configurationDone", nil, nil))
+	assert.Equal(t, "entry", c.stopped().Reason)
+
+	frames := c.stackTrace()
+	require.Len(t, frames, 1)
+	assert.Equal(t, StackFrame{ID: 1, Name: "root", Source: &Source{Name: "main.ink", Path: "/story/main.ink"}, Line: 3, Column: 1}, frames[0])
+
+	assert.Equal(t, "step", c.step("next").Reason)
+	assert.Equal(t, []int{6}, lines(c.stackTrace()))
+
+	// Over the function called on line 7
+	c.step("next")
+	assert.Equal(t, []int{7}, lines(c.stackTrace()))
+	c.step("next")
+	assert.Equal(t, []int{8}, lines(c.stackTrace()))
+	assert.Equal(t, []string{"bonus=13"}, c.variables("Temporaries"))
+
+	// Into the tunnel, and out again
+	c.step("stepIn")
+	frames = c.stackTrace()
+	assert.Equal(t, []string{"meet (tunnel)", "intro"}, names(frames))
+	assert.Equal(t, []int{19, 8}, lines(frames))
+	assert.Empty(t, c.variables("Temporaries"))
+
+	c.step("stepOut")
+	assert.Equal(t, []int{9}, lines(c.stackTrace()))
+	assert.Equal(t, []string{"score=0"}, c.variables("Globals"))
+
+	c.step("next")
+	assert.Equal(t, []string{"score=13"}, c.variables("Globals"))
+
+	assert.Equal(t, "choice", c.step("continue").Reason)
+	assert.Equal(t, "Hello.\nMeet.\n0: Pick\n1: Other\n", c.output.String())
+}
+
+func TestServerFunction(t *testing.T) {
+
+	c := launch(t, "/story/main.ink", false)
+
+	breakpoints := c.setBreakpoints("/story/main.ink", 22)
+	require.Len(t, breakpoints, 1)
+	assert.True(t, breakpoints[0].Verified)
+	require.NoError(t, c.call("configurationDone", nil, nil))
+
+	stopped := c.stopped()
+	assert.Equal(t, "breakpoint", stopped.Reason)
+	assert.Equal(t, []int{breakpoints[0].ID}, stopped.HitBreakpointIDs)
+
+	frames := c.stackTrace()
+	assert.Equal(t, []string{"add (function)", "intro"}, names(frames))
+	assert.Equal(t, []int{22, 7}, lines(frames))
+	assert.Equal(t, []string{"a=1", "b=2"}, c.variables("Temporaries"))
+	assert.Equal(t, []string{"0=10"}, c.variables("Evaluation Stack"))
+
+	evaluate := func(expression string, frameID int) (EvaluateResponseBody, error) {
+		var body EvaluateResponseBody
+		err := c.call("evaluate", EvaluateArguments{Expression: expression, FrameID: frameID, Context: "hover"}, &body)
+		return body, err
+	}
+
+	result, err := evaluate("a", 1)
+	require.NoError(t, err)
+	assert.Equal(t, EvaluateResponseBody{Result: "1", Type: "int"}, result)
+
+	result, err = evaluate("score", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "0", result.Result)
+
+	_, err = evaluate("a", 2)
+	assert.EqualError(t, err, "there's no variable called 'a'")
+
+	c.step("stepOut")
+	assert.Equal(t, []int{7}, lines(c.stackTrace()))
+	assert.Equal(t, []string{"0=10", "1=3"}, c.variables("Evaluation Stack"))
+}
+
+func TestServerBreakpoints(t *testing.T) {
+
+	c := launch(t, "/story/main.ink", false)
+
+	breakpoints := c.setBreakpoints("/story/main.ink", 4, 9)
+	require.Len(t, breakpoints, 2)
+	assert.False(t, breakpoints[0].Verified)
+	assert.Equal(t, "there's no ink to stop at on this line", breakpoints[0].Message)
+	assert.True(t, breakpoints[1].Verified)
+
+	var body SetBreakpointsResponseBody
+	require.NoError(t, c.call("setFunctionBreakpoints", SetFunctionBreakpointsArguments{Breakpoints: []FunctionBreakpoint{{Name: "meet"}, {Name: "nowhere"}}}, &body))
+	require.Len(t, body.Breakpoints, 2)
+	assert.True(t, body.Breakpoints[0].Verified)
+	assert.Equal(t, 18, body.Breakpoints[0].Line)
+	assert.False(t, body.Breakpoints[1].Verified)
+
+	require.NoError(t, c.call("configurationDone", nil, nil))
+
+	stopped := c.stopped()
+	assert.Equal(t, "function breakpoint", stopped.Reason)
+	assert.Equal(t, []int{body.Breakpoints[0].ID}, stopped.HitBreakpointIDs)
+	assert.Equal(t, []string{"meet (tunnel)", "intro"}, names(c.stackTrace()))
+
+	stopped = c.step("continue")
+	assert.Equal(t, "breakpoint", stopped.Reason)
+	assert.Equal(t, []int{breakpoints[1].ID}, stopped.HitBreakpointIDs)
+	assert.Equal(t, []int{9}, lines(c.stackTrace()))
+
+	assert.Equal(t, "choice", c.step("continue").Reason)
+	assert.EqualError(t, c.call("continue", ContinueArguments{ThreadID: threadID}, nil), "the story is waiting for a choice: type its number in the debug console")
+
+	var result EvaluateResponseBody
+	assert.Error(t, c.call("evaluate", EvaluateArguments{Expression: "2", Context: "repl"}, &result))
+	require.NoError(t, c.call("evaluate", EvaluateArguments{Expression: "1", Context: "repl"}, &result))
+	assert.Equal(t, "-> Other", result.Result)
+	assert.Equal(t, "step", c.stopped().Reason)
+
+	require.NoError(t, c.call("continue", ContinueArguments{ThreadID: threadID}, nil))
+	c.wait("terminated")
+	assert.Equal(t, "Hello.\nMeet.\n0: Pick\n1: Other\n", c.output.String())
+
+	assert.EqualError(t, c.call("continue", ContinueArguments{ThreadID: threadID}, nil), "the story has ended")
+}
+
+// TestServerThreads
+// Checks that the call stack shows the thread that a choice is being
+// gathered from, above the one that started it.
+func TestServerThreads(t *testing.T) {
+
+	c := launch(t, "/story/main.ink", false)
+
+	var body SetBreakpointsResponseBody
+	require.NoError(t, c.call("setFunctionBreakpoints", SetFunctionBreakpointsArguments{Breakpoints: []FunctionBreakpoint{{Name: "choices.options"}}}, &body))
+	require.NoError(t, c.call("configurationDone", nil, nil))
+
+	assert.Equal(t, "choice", c.stopped().Reason)
+	require.NoError(t, c.call("evaluate", EvaluateArguments{Expression: "0", Context: "repl"}, nil))
+	c.stopped()
+
+	assert.Equal(t, "function breakpoint", c.step("continue").Reason)
+
+	frames := c.stackTrace()
+	assert.Equal(t, []string{"choices.options [thread 3]", "choices [thread 1]"}, names(frames))
+	assert.Equal(t, "part.ink", frames[0].Source.Name)
+	assert.Equal(t, []int{6, 2}, lines(frames))
+	assert.Equal(t, "subtle", frames[1].PresentationHint)
+}
+
+func TestServerPause(t *testing.T) {
+
+	c := newClient(t, fstest.MapFS{"story/loop.ink": {Data: []byte("VAR x = 0\n-> loop\n== loop ==\n~ x = x + 1\n-> loop\n")}})
+	require.NoError(t, c.call("launch", LaunchRequestArguments{Program: "/story/loop.ink"}, nil))
+	require.NoError(t, c.call("configurationDone", nil, nil))
+
+	assert.EqualError(t, c.call("stackTrace", StackTraceArguments{ThreadID: threadID}, nil), "the story is running")
+
+	require.NoError(t, c.call("pause", PauseArguments{ThreadID: threadID}, nil))
+	assert.Equal(t, "pause", c.stopped().Reason)
+	assert.Equal(t, []string{"loop"}, names(c.stackTrace()))
+	assert.Len(t, c.variables("Globals"), 1)
+
+	require.NoError(t, c.call("disconnect", DisconnectArguments{}, nil))
+	assert.NoError(t, <-c.served)
+}
+
+// TestServerJSON
+// Checks that a story compiled by inklecate can be debugged, without
+// the DebugMetadata for line breakpoints.
+func TestServerJSON(t *testing.T) {
+
+	data, err := os.ReadFile("../../runtime/testdata/conformance/tunnels.ink.json")
+	require.NoError(t, err)
+
+	c := newClient(t, fstest.MapFS{"story/tunnels.ink.json": {Data: data}})
+	require.NoError(t, c.call("launch", LaunchRequestArguments{Program: "/story/tunnels.ink.json"}, nil))
+
+	require.NoError(t, c.call("setFunctionBreakpoints", SetFunctionBreakpointsArguments{Breakpoints: []FunctionBreakpoint{{Name: "inner"}}}, nil))
+	require.NoError(t, c.call("configurationDone", nil, nil))
+	assert.Equal(t, "function breakpoint", c.stopped().Reason)
+
+	frames := c.stackTrace()
+	assert.Equal(t, []string{"inner (tunnel)", "second (tunnel)", "root"}, names(frames))
+	assert.Nil(t, frames[0].Source)
+	assert.Equal(t, 0, frames[0].Line)
+
+	c.step("next")
+	assert.Equal(t, "Start.\nInside tunnel.\nAfter tunnel.\nIn second.\nInner.\n", c.output.String())
+}
+
+func TestServerLaunch(t *testing.T) {
+
+	c := newClient(t, fstest.MapFS{"story/bad.ink": {Data: []byte("-> nowhere\n")}})
+
+	assert.EqualError(t, c.call("threads", nil, nil), "no story has been launched")
+	assert.ErrorContains(t, c.call("launch", LaunchRequestArguments{Program: "/story/bad.ink"}, nil), "divert target not found: 'nowhere'")
+	assert.ErrorContains(t, c.call("launch", LaunchRequestArguments{Program: "/story/missing.ink"}, nil), "missing.ink")
+	assert.EqualError(t, c.call("launch", LaunchRequestArguments{}, nil), "no program to launch")
+	assert.EqualError(t, c.call("unknown", nil, nil), "no story has been launched")
+}
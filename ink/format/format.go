@@ -0,0 +1,1018 @@
+// Package format formats ink source in the canonical style, as inkfmt
+// does.
+//
+//	formatted, err := format.Source(source)
+//
+// Choices and gathers are indented by their depth, with the content that
+// follows them one level further in, and the branches of multiline blocks
+// are indented inside their braces. Within a line, diverts, glue, tags,
+// choice brackets, the operators of logic lines and declarations, and
+// runs of whitespace in text are spaced the same way everywhere.
+// Comments are kept where they were written, and spacing that changes
+// what the story outputs is left alone (see formatContent), so that the
+// formatted source plays exactly as the original.
+package format
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/SirMetathyst/go-ink/ink/ast"
+)
+
+// indent
+// One level of indentation.
+const indent = "\t"
+
+// Source
+// Formats the ink source of one file. Source that doesn't parse isn't
+// formatted, and the parse errors are returned instead. INCLUDE files
+// aren't read, since each file is formatted on its own.
+func Source(src []byte) ([]byte, error) {
+
+	source := strings.ReplaceAll(string(src), "\r\n", "\n")
+
+	file, err := ast.Parse("", source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	f := newFormatter(source, file)
+	f.body(file.Body, 0)
+
+	return []byte(f.format()), nil
+}
+
+// lineKind
+// How a line is formatted.
+type lineKind int
+
+const (
+	// lineUnknown lines are left as they are, apart from trailing space
+	lineUnknown lineKind = iota
+	lineContent
+	lineWeave
+	lineLogic
+	lineHeader
+	lineDecl
+	lineBranch
+	lineTodo
+)
+
+// line
+// A line of the source, with what the tree says about it.
+type line struct {
+	text     []rune
+	kind     lineKind
+	level    int
+	set      bool
+	verbatim bool
+
+	// The node that starts the line, for weave points and headers
+	node ast.Node
+
+	// The columns of the comments on the line, from and to
+	comments [][2]int
+}
+
+type formatter struct {
+	lines []*line
+}
+
+func newFormatter(source string, file *ast.File) *formatter {
+
+	f := &formatter{}
+
+	text := strings.TrimSuffix(source, "\n")
+	for _, l := range strings.Split(text, "\n") {
+		f.lines = append(f.lines, &line{text: []rune(l)})
+	}
+
+	// Comments are cut out of the lines they're on, and the lines inside a
+	// comment are kept as they are
+	for _, c := range file.Comments {
+		first := f.line(c.StartLineNumber)
+		if first == nil {
+			continue
+		}
+
+		end := len(first.text) + 1
+		if c.EndLineNumber == c.StartLineNumber {
+			end = c.EndCharacterNumber
+		}
+		first.comments = append(first.comments, [2]int{c.StartCharacterNumber - 1, end - 1})
+
+		for n := c.StartLineNumber + 1; n <= c.EndLineNumber; n++ {
+			if l := f.line(n); l != nil {
+				l.verbatim = true
+			}
+		}
+	}
+
+	return f
+}
+
+// line
+// The line with the 1-based number n, or nil when there isn't one.
+func (s *formatter) line(n int) *line {
+
+	if n < 1 || n > len(s.lines) {
+		return nil
+	}
+
+	return s.lines[n-1]
+}
+
+// set
+// Records how the line numbered n is formatted. The first node on a line
+// decides.
+func (s *formatter) set(n int, level int, kind lineKind, node ast.Node) {
+
+	l := s.line(n)
+	if l == nil || l.set || l.verbatim {
+		return
+	}
+
+	l.set = true
+	l.level = level
+	l.kind = kind
+	l.node = node
+}
+
+// Indentation
+
+// body
+// Works out the indentation of a list of statements whose weave starts at
+// level base.
+func (s *formatter) body(nodes []ast.Node, base int) {
+
+	content := base
+
+	for _, n := range nodes {
+		at := n.Pos().StartLineNumber
+
+		switch n := n.(type) {
+		case *ast.Knot:
+			s.set(at, 0, lineHeader, n)
+			s.body(n.Body, 0)
+			for _, stitch := range n.Stitches {
+				s.set(stitch.StartLineNumber, 0, lineHeader, stitch)
+				s.body(stitch.Body, 0)
+			}
+
+		case *ast.Function:
+			s.set(at, 0, lineHeader, n)
+			s.body(n.Body, 0)
+
+		case *ast.Stitch:
+			s.set(at, 0, lineHeader, n)
+			s.body(n.Body, 0)
+
+		case *ast.Choice:
+			s.set(at, base+n.Depth-1, lineWeave, n)
+			content = base + n.Depth
+			s.blocks(n)
+
+		case *ast.Gather:
+			s.set(at, base+n.Depth-1, lineWeave, n)
+			content = base + n.Depth - 1
+			s.blocks(n)
+
+		case *ast.Include, *ast.VarDecl, *ast.ListDecl, *ast.External:
+			s.set(at, base, lineDecl, n)
+
+		case *ast.Todo:
+			s.set(at, content, lineTodo, n)
+
+		case *ast.Assignment, *ast.Return, *ast.ExprStatement:
+			s.set(at, content, lineLogic, n)
+
+		default:
+			s.set(at, content, lineContent, n)
+			s.blocks(n)
+		}
+	}
+}
+
+// blocks
+// Indents the multiline conditionals and sequences in a node, each inside
+// the line it starts on.
+func (s *formatter) blocks(n ast.Node) {
+
+	ast.Inspect(n, func(n ast.Node) bool {
+
+		switch n := n.(type) {
+		case *ast.Conditional:
+			if n.Multiline {
+				s.conditional(n, s.levelOf(n.StartLineNumber))
+				return false
+			}
+
+		case *ast.Sequence:
+			if n.Multiline {
+				s.sequence(n, s.levelOf(n.StartLineNumber))
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+func (s *formatter) levelOf(n int) int {
+
+	if l := s.line(n); l != nil {
+		return l.level
+	}
+
+	return 0
+}
+
+// conditional
+//
+//	{condition:
+//		- value: content
+//			more content
+//		- else:
+//			content
+//	}
+func (s *formatter) conditional(c *ast.Conditional, level int) {
+
+	for _, b := range c.Branches {
+		if b.IsTrue {
+			s.body(b.Content, level+1)
+			continue
+		}
+
+		s.set(b.StartLineNumber, level+1, lineBranch, b)
+		s.body(b.Content, level+2)
+	}
+
+	s.set(c.EndLineNumber, level, lineContent, c)
+}
+
+// sequence
+// The elements of a multiline sequence have no nodes for their - lines,
+// so each is found as the first one after the previous element.
+func (s *formatter) sequence(q *ast.Sequence, level int) {
+
+	next := q.StartLineNumber + 1
+
+	for _, element := range q.Elements {
+		for ; next < q.EndLineNumber; next++ {
+			l := s.line(next)
+			if l.verbatim {
+				continue
+			}
+			code := strings.TrimSpace(string(l.text))
+			if strings.HasPrefix(code, "-") && !strings.HasPrefix(code, "->") {
+				s.set(next, level+1, lineBranch, nil)
+				break
+			}
+		}
+		next++
+
+		s.body(element, level+2)
+		if len(element) > 0 {
+			if end := element[len(element)-1].Pos().EndLineNumber + 1; end > next {
+				next = end
+			}
+		}
+	}
+
+	s.set(q.EndLineNumber, level, lineContent, q)
+}
+
+// Output
+
+// format
+// Writes out the lines, with one blank line at most between them and a
+// newline at the end.
+func (s *formatter) format() string {
+
+	// A line holding only comments is indented like the code after it
+	next := 0
+	for i := len(s.lines) - 1; i >= 0; i-- {
+		l := s.lines[i]
+		if l.set {
+			next = l.level
+		} else if !l.verbatim && len(l.comments) > 0 {
+			l.level = next
+		}
+	}
+
+	var sb strings.Builder
+	blank := false
+
+	for _, l := range s.lines {
+		if l.verbatim {
+			if blank {
+				sb.WriteByte('\n')
+			}
+			sb.WriteString(string(l.text))
+			sb.WriteByte('\n')
+			blank = false
+			continue
+		}
+
+		text := s.formatLine(l)
+		if text == "" {
+			blank = sb.Len() > 0
+			continue
+		}
+
+		if blank {
+			sb.WriteByte('\n')
+			blank = false
+		}
+
+		sb.WriteString(strings.Repeat(indent, l.level))
+		sb.WriteString(text)
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
+
+// placeholder
+// Stands in for the k-th comment on a line while the code around it is
+// formatted. The runes are in a private use area, so won't be in the
+// source.
+func placeholder(k int) rune {
+	return rune(0xF0000 + k)
+}
+
+func isPlaceholder(r rune) bool {
+	return r >= 0xF0000 && r <= 0xFFFFD
+}
+
+// formatLine
+// The formatted line, without its indentation.
+func (s *formatter) formatLine(l *line) string {
+
+	// Comments are swapped for placeholders, so that they go wherever the
+	// code around them does
+	code := make([]rune, 0, len(l.text))
+	var comments []string
+	from := 0
+	for k, c := range l.comments {
+		code = append(code, l.text[from:c[0]]...)
+		code = append(code, placeholder(k))
+		comments = append(comments, string(l.text[c[0]:c[1]]))
+		from = c[1]
+	}
+	code = append(code, l.text[from:]...)
+	code = []rune(strings.TrimSpace(string(code)))
+
+	var text string
+	switch l.kind {
+	case lineUnknown:
+		if len(l.comments) > 0 {
+			text = collapse(code)
+		} else {
+			text = strings.TrimRight(string(l.text), " \t")
+			l.level = 0
+		}
+
+	case lineContent:
+		text = formatContent(code, false)
+
+	case lineWeave:
+		text = formatWeave(code, l.node)
+
+	case lineLogic:
+		text = "~"
+		if rest := formatLogic(code[1:]); rest != "" {
+			text += " " + rest
+		}
+
+	case lineHeader:
+		text = formatHeader(l.node)
+		for _, r := range code {
+			if isPlaceholder(r) {
+				text += " " + string(r)
+			}
+		}
+
+	case lineDecl:
+		// INCLUDE is followed by a file name rather than logic
+		if _, ok := l.node.(*ast.Include); ok {
+			text = collapse(code)
+		} else {
+			text = formatLogic(code)
+		}
+
+	case lineBranch:
+		text = formatBranch(code, l.node)
+
+	case lineTodo:
+		text = string(code)
+	}
+
+	for k, comment := range comments {
+		text = strings.Replace(text, string(placeholder(k)), comment, 1)
+	}
+
+	return text
+}
+
+// Headers
+
+// formatHeader
+//
+//	== knot(a, ref b, -> c) ==
+//	== function f(x) ==
+//	= stitch
+func formatHeader(n ast.Node) string {
+
+	switch n := n.(type) {
+	case *ast.Knot:
+		return "== " + n.Name + formatParams(n.Params) + " =="
+	case *ast.Function:
+		return "== function " + n.Name + formatParams(n.Params) + " =="
+	case *ast.Stitch:
+		return "= " + n.Name + formatParams(n.Params)
+	}
+
+	return ""
+}
+
+func formatParams(params []*ast.Param) string {
+
+	if len(params) == 0 {
+		return ""
+	}
+
+	names := make([]string, len(params))
+	for i, p := range params {
+		switch {
+		case p.IsRef:
+			names[i] = "ref " + p.Name
+		case p.IsDivert:
+			names[i] = "-> " + p.Name
+		default:
+			names[i] = p.Name
+		}
+	}
+
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// Weave points
+
+// formatWeave
+// The bullets of a choice or gather written together, then the label and
+// content each after a single space.
+//
+//	** (label) {condition} Start [choice only] inner -> divert
+func formatWeave(code []rune, n ast.Node) string {
+
+	var bullets, label string
+	switch n := n.(type) {
+	case *ast.Choice:
+		bullets = strings.Repeat("*", n.Depth)
+		if n.Sticky {
+			bullets = strings.Repeat("+", n.Depth)
+		}
+		label = n.Label
+	case *ast.Gather:
+		bullets = strings.Repeat("-", n.Depth)
+		label = n.Label
+	}
+
+	// Skip the bullets and label as they were written
+	i := 0
+	for depth := len(bullets); depth > 0 && i < len(code); i++ {
+		if strings.ContainsRune("*+-", code[i]) {
+			depth--
+		}
+	}
+	if label != "" {
+		for i < len(code) && code[i] != ')' {
+			i++
+		}
+		i++
+	}
+	if i > len(code) {
+		i = len(code)
+	}
+
+	text := bullets
+	if label != "" {
+		text += " (" + label + ")"
+	}
+
+	code = trimSpace(code[i:])
+
+	// The parser skips the space after each {condition}
+	choice, ok := n.(*ast.Choice)
+	if ok {
+		for range choice.Conditions {
+			if len(code) == 0 || code[0] != '{' {
+				break
+			}
+			end := closingBrace(code, 0)
+			text += " " + string(code[:end])
+			code = trimSpace(code[end:])
+		}
+	}
+
+	if rest := formatContent(code, ok); rest != "" {
+		text += " " + rest
+	}
+
+	return text
+}
+
+// formatBranch
+// The line of a branch of a multiline conditional or an element of a
+// multiline sequence, which is a - followed by
+//
+//	condition: content
+func formatBranch(code []rune, n ast.Node) string {
+
+	text := "-"
+	code = trimSpace(code[1:])
+
+	// The parser skips the space around the condition and after the colon
+	if _, ok := n.(*ast.Branch); ok {
+		colon := conditionEnd(code)
+		text += " " + formatLogic(code[:colon]) + ":"
+		code = trimSpace(code[colon+1:])
+	}
+
+	if rest := formatContent(code, false); rest != "" {
+		text += " " + rest
+	}
+
+	return text
+}
+
+// conditionEnd
+// The index of the : after the condition of a branch.
+func conditionEnd(code []rune) int {
+
+	inString := false
+	depth := 0
+
+	for i, r := range code {
+		switch {
+		case r == '"':
+			inString = !inString
+		case inString:
+		case r == '(' || r == '{':
+			depth++
+		case r == ')' || r == '}':
+			depth--
+		case r == ':' && depth == 0:
+			return i
+		}
+	}
+
+	return len(code)
+}
+
+// Content
+
+// writer
+// Builds a line of content, holding back the whitespace between tokens
+// until it knows how it should be written.
+type writer struct {
+	out   []rune
+	space []rune
+
+	// Whether the waiting whitespace is written as it is, rather than as a
+	// single space, unless tight
+	keep  bool
+	tight bool
+
+	// Whether the last thing written was part of a divert
+	divert bool
+}
+
+func (s *writer) flush() {
+
+	if len(s.space) > 0 && len(s.out) > 0 {
+		if s.keep && !s.tight {
+			s.out = append(s.out, s.space...)
+		} else {
+			s.out = append(s.out, ' ')
+		}
+	}
+
+	s.space = nil
+	s.tight = false
+	s.divert = false
+}
+
+func (s *writer) write(rs ...rune) {
+
+	s.flush()
+	s.out = append(s.out, rs...)
+}
+
+// drop
+// Forgets the waiting whitespace.
+func (s *writer) drop() {
+
+	s.space = nil
+	s.tight = false
+}
+
+// formatContent
+// Spaces the diverts, glue, tags and choice brackets in a line of content,
+// and collapses runs of whitespace in its text to a single space, since
+// that's how they're output. Some spacing changes the output, so is kept
+// as it was written:
+//
+//   - the whitespace in the text of a choice, up to its ] or divert, as
+//     it isn't collapsed when the choice is shown
+//   - whether there's a space between text and the glue or divert after
+//     it, or glue and the text after it, as the text can be glued to
+//     more text
+//
+// Inline logic is left as it is.
+func formatContent(code []rune, choice bool) string {
+
+	w := &writer{}
+
+	if hasAt(code, 0, "<-") {
+		w.write('<', '-')
+		code = trimSpace(code[2:])
+		if len(code) > 0 {
+			w.space = []rune{' '}
+		}
+	}
+
+	// Whether the whitespace is in the text of a choice, outside its tags
+	choiceText := choice
+	inTag := false
+
+	for i := 0; i < len(code); {
+
+		r := code[i]
+		switch {
+		case r == ' ' || r == '\t':
+			w.keep = choiceText && !inTag
+			w.space = append(w.space, r)
+			i++
+
+		case r == '\\':
+			end := i + 2
+			if end > len(code) {
+				end = len(code)
+			}
+			w.write(code[i:end]...)
+			i = end
+
+		case hasAt(code, i, "->"):
+			arrow := "->"
+			if hasAt(code, i, "->->") {
+				arrow = "->->"
+			}
+
+			// Text before a divert in a choice is trimmed, so the space
+			// can be added there, and after another divert
+			if (choice || w.divert) && len(w.space) == 0 {
+				w.space = []rune{' '}
+			}
+			choiceText = false
+			inTag = false
+
+			w.tight = true
+			w.write([]rune(arrow)...)
+			i = skipSpace(code, i+len(arrow))
+			if i < len(code) {
+				w.space = []rune{' '}
+			}
+
+			// The target, and its arguments as logic
+			start := i
+			for i < len(code) && (isIdentifierRune(code[i]) || code[i] == '.') {
+				i++
+			}
+			w.write(code[start:i]...)
+			if i > start && i < len(code) && code[i] == '(' {
+				end := closingParen(code, i)
+				w.write([]rune(formatLogic(code[i:end]))...)
+				i = end
+			}
+			w.divert = true
+
+		case hasAt(code, i, "<>"):
+			w.write('<', '>')
+			i += 2
+
+		case r == '#':
+			if !choiceText || inTag {
+				w.drop()
+				w.space = []rune{' '}
+			}
+			w.write('#')
+			inTag = true
+			i = skipSpace(code, i+1)
+			if i < len(code) {
+				w.space = []rune{' '}
+			}
+
+		case choice && r == '[':
+			inTag = false
+			w.write('[')
+			i++
+
+		case choice && r == ']':
+			inTag = false
+			w.write(']')
+			choiceText = false
+			i++
+
+		case r == '{':
+			end := closingBrace(code, i)
+			w.write(code[i:end]...)
+			i = end
+
+		case isPlaceholder(r):
+			w.tight = true
+			w.write(r)
+			i++
+
+		default:
+			w.write(r)
+			i++
+		}
+	}
+
+	return string(w.out)
+}
+
+// closingBrace
+// The index after the } that closes the { at i, or the end of the line
+// when it isn't closed on the line.
+func closingBrace(code []rune, i int) int {
+
+	depth := 0
+	for ; i < len(code); i++ {
+		switch code[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return len(code)
+}
+
+// closingParen
+// The index after the ) that closes the ( at i, or the end of the line.
+func closingParen(code []rune, i int) int {
+
+	depth := 0
+	inString := false
+	for ; i < len(code); i++ {
+		switch r := code[i]; {
+		case r == '"':
+			inString = !inString
+		case inString:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return len(code)
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// collapse
+// Collapses runs of whitespace outside strings to a single space, for
+// logic and declarations.
+func collapse(code []rune) string {
+
+	var out []rune
+	space := false
+	inString := false
+
+	for i := 0; i < len(code); i++ {
+		r := code[i]
+
+		if !inString && (r == ' ' || r == '\t') {
+			space = true
+			continue
+		}
+
+		if space && len(out) > 0 {
+			out = append(out, ' ')
+		}
+		space = false
+
+		switch {
+		case r == '\\' && i+1 < len(code):
+			out = append(out, r, code[i+1])
+			i++
+			continue
+		case r == '"':
+			inString = !inString
+		}
+
+		out = append(out, r)
+	}
+
+	return string(out)
+}
+
+// Logic
+
+// token
+// A piece of a logic line or declaration: a word (a name, keyword or
+// number), a string, an operator or punctuation, or a comment's
+// placeholder.
+type token struct {
+	text  string
+	kind  tokenKind
+	space bool // whether whitespace came before it
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOperator
+	tokenPlaceholder
+)
+
+// operators
+// The operators of ink's logic, longest first so that each is matched
+// whole.
+var operators = []string{
+	"->", "==", "!=", "<=", ">=", "&&", "||", "+=", "-=", "++", "--", "!?",
+	"+", "-", "*", "/", "%", "=", "<", ">", "!", "?", "^", "(", ")", ",",
+}
+
+// prefixWords
+// The words after which a - or ! is a prefix rather than an operator.
+var prefixWords = map[string]bool{
+	"return": true, "and": true, "or": true, "not": true, "mod": true, "has": true, "hasnt": true,
+}
+
+// tokenize
+// Splits logic into tokens, or reports false when it holds something that
+// isn't logic, such as inline content.
+func tokenize(code []rune) ([]token, bool) {
+
+	var tokens []token
+	space := false
+
+	for i := 0; i < len(code); {
+		r := code[i]
+
+		switch {
+		case r == ' ' || r == '\t':
+			space = true
+			i++
+			continue
+
+		case r == '"':
+			end := i + 1
+			for end < len(code) && code[end] != '"' {
+				if code[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(code) {
+				return nil, false
+			}
+			tokens = append(tokens, token{string(code[i : end+1]), tokenString, space})
+			i = end + 1
+
+		case isIdentifierRune(r):
+			end := i
+			for end < len(code) && (isIdentifierRune(code[end]) || code[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{string(code[i:end]), tokenWord, space})
+			i = end
+
+		case isPlaceholder(r):
+			tokens = append(tokens, token{string(r), tokenPlaceholder, space})
+			i++
+
+		default:
+			op := ""
+			for _, o := range operators {
+				if hasAt(code, i, o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, false
+			}
+			tokens = append(tokens, token{op, tokenOperator, space})
+			i += len([]rune(op))
+		}
+
+		space = false
+	}
+
+	return tokens, true
+}
+
+// formatLogic
+// Spaces a logic line or declaration: a single space around operators
+// and after commas, and none inside brackets, before the brackets of a
+// call, or after a prefix - or !. Comments keep the spacing they were
+// written with, and anything that isn't logic only has its whitespace
+// collapsed.
+//
+//	temp x = f(a, -b) + 1
+func formatLogic(code []rune) string {
+
+	tokens, ok := tokenize(code)
+	if !ok {
+		return collapse(code)
+	}
+
+	var sb strings.Builder
+	var prev *token
+	prefix := false
+
+	for i := range tokens {
+		t := &tokens[i]
+
+		space := prev != nil
+		if prev != nil {
+			switch {
+			case t.kind == tokenPlaceholder || prev.kind == tokenPlaceholder:
+				space = t.space || prev.text == ","
+			case prefix:
+				space = false
+			case prev.text == "(":
+				space = false
+			case t.text == ")" || t.text == ",":
+				space = false
+			case t.text == "(" && (prev.kind == tokenWord || prev.text == ")"):
+				space = false
+			case (t.text == "++" || t.text == "--") && (prev.kind == tokenWord || prev.text == ")"):
+				space = false
+			}
+		}
+
+		// A - after an operator, or at the start, is a prefix, as is !
+		prefix = false
+		if t.kind == tokenOperator && (t.text == "-" || t.text == "!") {
+			prefix = t.text == "!" || prev == nil ||
+				(prev.kind == tokenOperator && prev.text != ")" && prev.text != "++" && prev.text != "--") ||
+				(prev.kind == tokenWord && prefixWords[prev.text])
+		}
+
+		if space {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(t.text)
+
+		prev = t
+	}
+
+	return sb.String()
+}
+
+func hasAt(code []rune, i int, s string) bool {
+
+	for _, r := range s {
+		if i >= len(code) || code[i] != r {
+			return false
+		}
+		i++
+	}
+
+	return true
+}
+
+func skipSpace(code []rune, i int) int {
+
+	for i < len(code) && (code[i] == ' ' || code[i] == '\t') {
+		i++
+	}
+
+	return i
+}
+
+func trimSpace(code []rune) []rune {
+
+	return []rune(strings.TrimSpace(string(code)))
+}
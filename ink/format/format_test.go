@@ -0,0 +1,266 @@
+package format
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SirMetathyst/go-ink/compiler"
+	"github.com/SirMetathyst/go-ink/ink/ast"
+	"github.com/SirMetathyst/go-ink/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const messySource = `// The start
+
+
+VAR   score  =  1
+LIST colours = red,   (green)
+Hello   <>   world.#greeting
+->    start(1,  score)
+
+===   start   (x, ref y)  ===
+  *    (first)  {score > 0}   Go [ north ]   now  ->   north   // go
+      Went.
+   * *   Deeper
+   ---   (g)   Gathered
+	- Back.
+~temp   z  =  "a  b"
+{ score:
+- 1:   One  ->  north
+        - else:
+   Other
+   * inner choice
+}
+{stopping:
+  -   A
+  -
+  B
+}
+/* multi
+   line */ Text
+-> north
+
+=== north ===
+<-    thread
+North. # a   # b
+-> END
+= thread
+Threaded.
+-> DONE
+
+== function  f(-> t) ==
+~  return   1
+`
+
+const formattedSource = `// The start
+
+VAR score = 1
+LIST colours = red, (green)
+Hello <> world. # greeting
+-> start(1, score)
+
+== start(x, ref y) ==
+* (first) {score > 0} Go [ north ] now -> north // go
+	Went.
+	** Deeper
+		--- (g) Gathered
+- Back.
+~ temp z = "a  b"
+{ score:
+	- 1: One -> north
+	- else:
+		Other
+		* inner choice
+}
+{stopping:
+	- A
+	-
+		B
+}
+/* multi
+   line */ Text
+-> north
+
+== north ==
+<- thread
+North. # a # b
+-> END
+= thread
+Threaded.
+-> DONE
+
+== function f(-> t) ==
+~ return 1
+`
+
+func TestSource(t *testing.T) {
+
+	out, err := Source([]byte(messySource))
+	require.NoError(t, err)
+	assert.Equal(t, formattedSource, string(out))
+}
+
+func TestSourceIsStable(t *testing.T) {
+
+	out, err := Source([]byte(formattedSource))
+	require.NoError(t, err)
+	assert.Equal(t, formattedSource, string(out))
+}
+
+func TestSourceListDecl(t *testing.T) {
+
+	tests := []struct {
+		source, formatted string
+	}{
+		{"LIST c = red,(green),  blue\n", "LIST c = red, (green), blue\n"},
+		{"LIST c = a,(b),c\n", "LIST c = a, (b), c\n"},
+		{"LIST c = a , b ,c = 5\n", "LIST c = a, b, c = 5\n"},
+		{"LIST c = a,/* first, */b // a, b\n", "LIST c = a, /* first, */b // a, b\n"},
+		{"LIST c = a, (b), c\n", "LIST c = a, (b), c\n"},
+	}
+
+	for _, test := range tests {
+		out, err := Source([]byte(test.source))
+		require.NoError(t, err, test.source)
+		assert.Equal(t, test.formatted, string(out), test.source)
+	}
+}
+
+// TestSourceSpacing
+// One case for each spacing rule, each of which must leave the story
+// playing as it did.
+func TestSourceSpacing(t *testing.T) {
+
+	tests := []struct {
+		name, source, formatted string
+	}{
+		{"divert",
+			"->start\n== start ==\nA->b->  b\n= b\n-> END\n",
+			"-> start\n== start ==\nA-> b -> b\n= b\n-> END\n"},
+		{"divert in a choice",
+			"*   [choice]text->start\n== start ==\n-> END\n",
+			"* [choice]text -> start\n== start ==\n-> END\n"},
+		{"glue",
+			"Hello   <>   world<>\n!\nA<>\n<>   B\n",
+			"Hello <> world<>\n!\nA<>\n<> B\n"},
+		{"logic",
+			"VAR x = 1\n~x=x+1\n~  temp y=f( x ,-2 )*(3-x)\n~x++\nx={x} y={y}\n== function f(a, b) ==\n~return  -a-b\n",
+			"VAR x = 1\n~ x = x + 1\n~ temp y = f(x, -2) * (3 - x)\n~ x++\nx={x} y={y}\n== function f(a, b) ==\n~ return -a - b\n"},
+		{"declarations",
+			"VAR x=1\nCONST y =-2\nVAR t=->start\nLIST l=a,(b),c=5\n-> start\n== start ==\n{x} {y} {l}\n-> END\n",
+			"VAR x = 1\nCONST y = -2\nVAR t = -> start\nLIST l = a, (b), c = 5\n-> start\n== start ==\n{x} {y} {l}\n-> END\n"},
+		{"choice brackets",
+			"*  Hello   [there  ]   world\n*Go[]   on\n- -> END\n",
+			"* Hello   [there  ] world\n* Go[] on\n- -> END\n"},
+		{"tags",
+			"Hello#a  b   #c\n*  Pick  #  d   [x]\n- -> END\n",
+			"Hello # a b # c\n* Pick  # d [x]\n- -> END\n"},
+		{"text",
+			"Hello   world   {1 +  2}   times.\n",
+			"Hello world {1 +  2} times.\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			out, err := Source([]byte(test.source))
+			require.NoError(t, err)
+			assert.Equal(t, test.formatted, string(out))
+
+			original, err := compiler.Compile(test.source, nil)
+			require.NoError(t, err)
+
+			formatted, err := compiler.Compile(string(out), nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, play(t, original), play(t, formatted))
+		})
+	}
+}
+
+// TestSourceKeepsStory
+// Checks that formatting doesn't change how a story plays, for the
+// conformance stories and a messy one.
+func TestSourceKeepsStory(t *testing.T) {
+
+	paths, err := filepath.Glob("../../runtime/testdata/conformance/*.ink")
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	sources := map[string]string{"messy": messySource}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		sources[filepath.Base(path)] = string(data)
+	}
+
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+
+			out, err := Source([]byte(source))
+			require.NoError(t, err)
+
+			again, err := Source(out)
+			require.NoError(t, err)
+			assert.Equal(t, string(out), string(again))
+
+			// Hand-written to check runtime errors, so it doesn't compile
+			if name == "errors.ink" {
+				return
+			}
+
+			original, err := compiler.Compile(source, nil)
+			require.NoError(t, err)
+
+			formatted, err := compiler.Compile(string(out), nil)
+			require.NoError(t, err)
+			// Needs its externals bound to play, so is compared as compiled
+			if name == "externals.ink" {
+				assert.Equal(t, original.ToJson(), formatted.ToJson())
+				return
+			}
+
+			assert.Equal(t, play(t, original), play(t, formatted))
+		})
+	}
+}
+
+// play
+// Plays a story through, always taking the first choice, and returns
+// everything it output.
+func play(t *testing.T, story *runtime.Story) []string {
+
+	// Fixed so that shuffles and RANDOM are repeatable
+	story.State().StorySeed = 0
+
+	var transcript []string
+
+	for turn := 0; turn < 20; turn++ {
+		text, err := story.ContinueMaximally()
+		require.NoError(t, err)
+		transcript = append(transcript, text)
+
+		choices := story.CurrentChoices()
+		if len(choices) == 0 {
+			break
+		}
+		for _, choice := range choices {
+			transcript = append(transcript, "* "+choice.Text)
+		}
+		require.NoError(t, story.ChooseChoiceIndex(0))
+	}
+
+	return transcript
+}
+
+func TestSourceErrors(t *testing.T) {
+
+	_, err := Source([]byte("Hello\n{x\n"))
+
+	var list ast.ErrorList
+	require.True(t, errors.As(err, &list))
+	assert.Equal(t, 2, list[0].Line)
+}
@@ -0,0 +1,56 @@
+// Package jsonrpc reads and writes messages framed with a Content-Length
+// header, as the language server and debug adapter protocols both send
+// them.
+package jsonrpc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// MaxMessageSize
+// The largest message that will be read, so a bad header can't make the
+// reader allocate without limit.
+const MaxMessageSize = 64 << 20
+
+// Read
+// Reads the content of the next message. io.EOF is returned when the
+// input ends between messages.
+func Read(r *bufio.Reader) ([]byte, error) {
+
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 || length > MaxMessageSize {
+		return nil, fmt.Errorf("bad Content-Length %q", header.Get("Content-Length"))
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("reading message: %w", err)
+	}
+
+	return data, nil
+}
+
+// Write
+// Sends data as one message. Callers writing from more than one goroutine
+// must hold a lock around it.
+func Write(w io.Writer, data []byte) error {
+
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+
+	_, err := w.Write(data)
+	return err
+}
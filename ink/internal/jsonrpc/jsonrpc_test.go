@@ -0,0 +1,51 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWrite(t *testing.T) {
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, []byte(`{"a":1}`)))
+	require.NoError(t, Write(&buf, []byte(`{}`)))
+	assert.Equal(t, "Content-Length: 7\r\n\r\n{\"a\":1}Content-Length: 2\r\n\r\n{}", buf.String())
+
+	r := bufio.NewReader(&buf)
+
+	data, err := Read(r)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, string(data))
+
+	data, err = Read(r)
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(data))
+
+	_, err = Read(r)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadInvalid(t *testing.T) {
+
+	tests := []string{
+		"Content-Length: x\r\n\r\n{}",
+		"Content-Length: -1\r\n\r\n{}",
+		"Content-Length: 1000000000\r\n\r\n{}",
+		"Content-Type: text\r\n\r\n{}",
+		"Content-Length: 10\r\n\r\n{}",
+		"Content-Length: 2\r\n",
+	}
+
+	for _, test := range tests {
+		_, err := Read(bufio.NewReader(strings.NewReader(test)))
+		assert.Error(t, err, test)
+		assert.NotEqual(t, io.EOF, err, test)
+	}
+}
@@ -0,0 +1,811 @@
+package lsp
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/SirMetathyst/go-ink/compiler"
+	"github.com/SirMetathyst/go-ink/ink/ast"
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// Analysis
+//
+// A story is analysed from its root file, with the files it includes. The
+// compiler finds the errors, and the syntax tree is indexed for the names
+// it declares and every reference to them, resolved the same way the
+// compiler resolves them.
+
+type symbolKind int
+
+const (
+	symbolKnot symbolKind = iota
+	symbolStitch
+	symbolFunction
+	symbolLabel
+	symbolVariable
+	symbolConstant
+	symbolList
+	symbolListItem
+	symbolExternal
+	symbolParam
+	symbolTemp
+)
+
+// symbol
+// Something declared with a name.
+type symbol struct {
+	kind symbolKind
+	name string
+
+	// The full path of a knot, stitch or label, or list.item for a list
+	// item
+	path *runtime.Path
+
+	// Where the name is written in the declaration
+	at   ast.Span
+	node ast.Node
+
+	// The flow it's declared in, and for knots, stitches and functions
+	// the flow it is
+	scope *flow
+	inner *flow
+}
+
+// flow
+// The story itself, a knot, a stitch or a function: a scope for labels,
+// parameters and temporary variables.
+type flow struct {
+	symbol *symbol
+	parent *flow
+	body   []ast.Node
+	params []*ast.Param
+
+	// Stitches, labels, and on the story its knots and functions, in
+	// order and by name
+	children []*symbol
+	byName   map[string]*symbol
+
+	locals map[string]*symbol
+
+	// The lines of its file it covers
+	fileName  string
+	startLine int
+	endLine   int
+}
+
+func newFlow(sym *symbol, parent *flow, fileName string) *flow {
+
+	fl := &flow{symbol: sym, parent: parent, byName: map[string]*symbol{}, locals: map[string]*symbol{}, fileName: fileName}
+	if sym != nil {
+		sym.inner = fl
+		fl.startLine = sym.node.Pos().StartLineNumber
+	}
+
+	return fl
+}
+
+func (s *flow) addChild(sym *symbol) {
+
+	if _, ok := s.byName[sym.name]; ok {
+		return
+	}
+
+	s.children = append(s.children, sym)
+	s.byName[sym.name] = sym
+}
+
+// reference
+// A name written somewhere that refers to a symbol. A dotted path has a
+// reference for each of its names.
+type reference struct {
+	at     ast.Span
+	target *symbol
+}
+
+// diagnostic
+// A problem found in a file of the story.
+type diagnostic struct {
+	at          ast.Span
+	severity    DiagnosticSeverity
+	message     string
+	unnecessary bool
+}
+
+type analysis struct {
+	rootName string
+	story    *flow
+	flows    []*flow
+	symbols  []*symbol
+	refs     []*reference
+
+	// Global variables, constants, lists and externals
+	globals   map[string]*symbol
+	listItems map[string][]*symbol
+
+	// The lines of each file, by name
+	files map[string][][]rune
+
+	diagnostics map[string][]*diagnostic
+}
+
+// analyse
+// Compiles and indexes the story whose root file is called rootName,
+// with INCLUDE files read from fsys.
+func analyse(rootName string, source string, fsys fs.FS) *analysis {
+
+	a := &analysis{
+		rootName:    rootName,
+		globals:     map[string]*symbol{},
+		listItems:   map[string][]*symbol{},
+		files:       map[string][][]rune{},
+		diagnostics: map[string][]*diagnostic{},
+	}
+	a.addSource(rootName, source)
+
+	// Errors in the syntax come from the compiler as well
+	file, _ := ast.Parse(rootName, source, &ast.Options{FS: fsys})
+
+	a.story = newFlow(nil, nil, rootName)
+	a.flows = append(a.flows, a.story)
+	a.addFile(file, fsys)
+	a.declare(file)
+
+	for _, fl := range a.flows {
+		a.declareFlow(fl)
+	}
+	for _, fl := range a.flows {
+		a.refer(fl)
+	}
+
+	a.compile(source, fsys)
+	a.check()
+
+	return a
+}
+
+func (s *analysis) addSource(fileName string, source string) {
+
+	var lines [][]rune
+	for _, l := range strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n") {
+		lines = append(lines, []rune(l))
+	}
+
+	s.files[fileName] = lines
+}
+
+// addFile
+// Adds the knots and functions of a file and the files it includes.
+func (s *analysis) addFile(file *ast.File, fsys fs.FS) {
+
+	var flows []*flow
+
+	for _, n := range file.Body {
+		switch n := n.(type) {
+		case *ast.Include:
+			if n.File == nil {
+				continue
+			}
+			if source, err := fs.ReadFile(fsys, n.Name); err == nil {
+				s.addSource(n.Name, string(source))
+			}
+			s.addFile(n.File, fsys)
+
+		case *ast.Knot:
+			knot := s.declareFlowSymbol(s.story, symbolKnot, n.Name, n, n.Params, n.Body)
+			flows = append(flows, knot)
+			for _, st := range n.Stitches {
+				stitch := s.declareFlowSymbol(knot, symbolStitch, st.Name, st, st.Params, st.Body)
+				flows = append(flows, stitch)
+			}
+
+		case *ast.Function:
+			flows = append(flows, s.declareFlowSymbol(s.story, symbolFunction, n.Name, n, n.Params, n.Body))
+
+		default:
+			s.story.body = append(s.story.body, n)
+		}
+	}
+
+	// Each flow runs to the line before the next one starts, and a knot
+	// to the line before the next knot
+	last := len(s.files[file.Name])
+	for i, fl := range flows {
+		fl.endLine = last
+		for _, next := range flows[i+1:] {
+			if fl.symbol.kind == symbolStitch || next.symbol.kind != symbolStitch {
+				fl.endLine = next.startLine - 1
+				break
+			}
+		}
+	}
+}
+
+func (s *analysis) declareFlowSymbol(scope *flow, kind symbolKind, name string, n ast.Node, params []*ast.Param, body []ast.Node) *flow {
+
+	sym := s.newSymbol(scope, kind, name, n)
+	scope.addChild(sym)
+
+	fl := newFlow(sym, scope, n.Pos().FileName)
+	fl.params = params
+	fl.body = body
+	s.flows = append(s.flows, fl)
+
+	return fl
+}
+
+// newSymbol
+// A symbol declared in scope by node n, which has the name in it.
+func (s *analysis) newSymbol(scope *flow, kind symbolKind, name string, n ast.Node) *symbol {
+
+	sym := &symbol{kind: kind, name: name, at: s.nameSpan(n.Pos(), name), node: n, scope: scope}
+
+	switch kind {
+	case symbolKnot, symbolStitch, symbolFunction, symbolLabel:
+		sym.path = runtime.NewPathFromComponents([]*runtime.PathComponent{runtime.NewPathComponentFromName(name)}, false)
+		if scope != nil && scope.symbol != nil {
+			sym.path = scope.symbol.path.PathByAppendingComponent(runtime.NewPathComponentFromName(name))
+		}
+	default:
+		sym.path = runtime.NewPathFromString(name)
+	}
+
+	s.symbols = append(s.symbols, sym)
+	return sym
+}
+
+// declare
+// Declares the global variables, constants, lists and externals, which
+// can be anywhere in any file.
+func (s *analysis) declare(file *ast.File) {
+
+	ast.Inspect(file, func(n ast.Node) bool {
+
+		switch n := n.(type) {
+		case *ast.VarDecl:
+			kind := symbolVariable
+			if n.IsConst {
+				kind = symbolConstant
+			}
+			s.declareGlobal(s.newSymbol(nil, kind, n.Name, n))
+
+		case *ast.ListDecl:
+			list := s.newSymbol(nil, symbolList, n.Name, n)
+			s.declareGlobal(list)
+			for _, item := range n.Items {
+				sym := s.newSymbol(nil, symbolListItem, item.Name, item)
+				sym.path = runtime.NewPathFromString(n.Name + "." + item.Name)
+				s.listItems[item.Name] = append(s.listItems[item.Name], sym)
+			}
+
+		case *ast.External:
+			s.declareGlobal(s.newSymbol(nil, symbolExternal, n.Name, n))
+		}
+
+		return true
+	})
+}
+
+func (s *analysis) declareGlobal(sym *symbol) {
+
+	if _, ok := s.globals[sym.name]; !ok {
+		s.globals[sym.name] = sym
+	}
+}
+
+// declareFlow
+// Declares the parameters, temporary variables and labels of a flow.
+func (s *analysis) declareFlow(fl *flow) {
+
+	for _, p := range fl.params {
+		if _, ok := fl.locals[p.Name]; !ok {
+			fl.locals[p.Name] = s.newSymbol(fl, symbolParam, p.Name, p)
+		}
+	}
+
+	for _, n := range fl.body {
+		ast.Inspect(n, func(n ast.Node) bool {
+
+			switch n := n.(type) {
+			case *ast.Choice:
+				if n.Label != "" {
+					fl.addChild(s.newSymbol(fl, symbolLabel, n.Label, n))
+				}
+
+			case *ast.Gather:
+				if n.Label != "" {
+					fl.addChild(s.newSymbol(fl, symbolLabel, n.Label, n))
+				}
+
+			case *ast.Assignment:
+				if _, ok := fl.locals[n.Name]; n.IsTemp && !ok {
+					fl.locals[n.Name] = s.newSymbol(fl, symbolTemp, n.Name, n)
+				}
+			}
+
+			return true
+		})
+	}
+}
+
+// Name resolution
+
+// resolve
+// Finds the knot, stitch or label that path names. The first name is
+// looked for in the flow, then in the flows around it.
+func (s *analysis) resolve(fl *flow, path []string) *symbol {
+
+	for scope := fl; scope != nil; scope = scope.parent {
+
+		target := scope.byName[path[0]]
+		if target == nil {
+			continue
+		}
+
+		for _, name := range path[1:] {
+			if target.inner == nil {
+				return nil
+			}
+			if target = target.inner.byName[name]; target == nil {
+				return nil
+			}
+		}
+
+		return target
+	}
+
+	return nil
+}
+
+// listItem
+// The item called itemName, in the list called listName if it's given.
+func (s *analysis) listItem(listName string, itemName string) *symbol {
+
+	for _, item := range s.listItems[itemName] {
+		if listName == "" || item.path.Component(0).Name() == listName {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// References
+
+// refer
+// Records the references in the body of a flow.
+func (s *analysis) refer(fl *flow) {
+
+	for _, n := range fl.body {
+		ast.Inspect(n, func(n ast.Node) bool {
+
+			switch n := n.(type) {
+			case *ast.Divert:
+				s.referPath(fl, n.Span, n.Target)
+
+			case *ast.DivertTarget:
+				s.referPath(fl, n.Span, n.Target)
+
+			case *ast.Variable:
+				s.referVariable(fl, n)
+
+			case *ast.Assignment:
+				target := fl.locals[n.Name]
+				if target == nil {
+					target = s.globals[n.Name]
+				}
+				s.addRef(s.nameSpan(n.Span, n.Name), target)
+
+			case *ast.Call:
+				target := s.story.byName[n.Name]
+				if target == nil || target.kind != symbolFunction {
+					target = s.globals[n.Name]
+				}
+				s.addRef(s.nameSpan(n.Span, n.Name), target)
+			}
+
+			return true
+		})
+	}
+}
+
+func (s *analysis) addRef(at ast.Span, target *symbol) {
+
+	if target != nil {
+		s.refs = append(s.refs, &reference{at: at, target: target})
+	}
+}
+
+// referPath
+// Adds a reference for each name of a path to a knot, stitch or label.
+func (s *analysis) referPath(fl *flow, at ast.Span, path []string) {
+
+	spans := s.nameSpans(at, path)
+	for i := range path {
+		s.addRef(spans[i], s.resolve(fl, path[:i+1]))
+	}
+}
+
+// referVariable
+// A name in an expression is, in the same order as the compiler looks,
+// a constant, a temporary variable or parameter, a list item, the read
+// count of a knot, stitch or label, or a global variable.
+func (s *analysis) referVariable(fl *flow, v *ast.Variable) {
+
+	name := v.Path[0]
+	local := fl.locals[name]
+
+	if len(v.Path) == 1 {
+		if global := s.globals[name]; local == nil && global != nil && global.kind == symbolConstant {
+			s.addRef(v.Span, global)
+			return
+		}
+
+		if local != nil {
+			s.addRef(v.Span, local)
+			return
+		}
+
+		if item := s.listItem("", name); item != nil {
+			s.addRef(v.Span, item)
+			return
+		}
+	}
+
+	if len(v.Path) == 2 {
+		if item := s.listItem(name, v.Path[1]); item != nil {
+			spans := s.nameSpans(v.Span, v.Path)
+			s.addRef(spans[0], s.globals[name])
+			s.addRef(spans[1], item)
+			return
+		}
+	}
+
+	if s.resolve(fl, v.Path) != nil {
+		s.referPath(fl, v.Span, v.Path)
+		return
+	}
+
+	if len(v.Path) == 1 {
+		s.addRef(v.Span, s.globals[name])
+	}
+}
+
+// Positions
+
+// nameSpan
+// The span of the first place name is written as a whole word on the line
+// the span starts on, or the span itself when it isn't there.
+func (s *analysis) nameSpan(at ast.Span, name string) ast.Span {
+
+	return s.nameSpans(at, []string{name})[0]
+}
+
+// nameSpans
+// The spans of the names of a path, each found after the one before.
+func (s *analysis) nameSpans(at ast.Span, names []string) []ast.Span {
+
+	spans := make([]ast.Span, len(names))
+
+	var line []rune
+	if lines := s.files[at.FileName]; at.StartLineNumber >= 1 && at.StartLineNumber <= len(lines) {
+		line = lines[at.StartLineNumber-1]
+	}
+
+	from := at.StartCharacterNumber - 1
+	for i, name := range names {
+		spans[i] = at
+
+		col := findWord(line, from, []rune(name))
+		if col < 0 {
+			continue
+		}
+
+		spans[i] = ast.Span{
+			FileName:             at.FileName,
+			StartLineNumber:      at.StartLineNumber,
+			EndLineNumber:        at.StartLineNumber,
+			StartCharacterNumber: col + 1,
+			EndCharacterNumber:   col + len([]rune(name)) + 1,
+		}
+		from = col + len([]rune(name))
+	}
+
+	return spans
+}
+
+// findWord
+// The index of the first whole-word occurrence of word in line at or
+// after from, or -1.
+func findWord(line []rune, from int, word []rune) int {
+
+	isWord := func(i int) bool {
+		return i >= 0 && i < len(line) && isIdentifierRune(line[i])
+	}
+
+	if from < 0 {
+		from = 0
+	}
+
+	for i := from; i+len(word) <= len(line); i++ {
+		if string(line[i:i+len(word)]) == string(word) && !isWord(i-1) && !isWord(i+len(word)) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// position
+// The protocol position of the 1-based line and column in a file.
+func (s *analysis) position(fileName string, line int, column int) Position {
+
+	pos := Position{Line: line - 1}
+
+	lines := s.files[fileName]
+	if line < 1 || line > len(lines) {
+		return pos
+	}
+
+	text := lines[line-1]
+	if column-1 > len(text) {
+		column = len(text) + 1
+	}
+	if column > 1 {
+		pos.Character = len(utf16.Encode(text[:column-1]))
+	}
+
+	return pos
+}
+
+// lineColumn
+// The 1-based line and column of a protocol position in a file.
+func (s *analysis) lineColumn(fileName string, pos Position) (int, int) {
+
+	lines := s.files[fileName]
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return pos.Line + 1, pos.Character + 1
+	}
+
+	units := 0
+	for i, r := range lines[pos.Line] {
+		if units >= pos.Character {
+			return pos.Line + 1, i + 1
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+
+	return pos.Line + 1, len(lines[pos.Line]) + 1
+}
+
+func (s *analysis) rangeOf(at ast.Span) Range {
+
+	return Range{
+		Start: s.position(at.FileName, at.StartLineNumber, at.StartCharacterNumber),
+		End:   s.position(at.FileName, at.EndLineNumber, at.EndCharacterNumber),
+	}
+}
+
+// text
+// The source that a span covers.
+func (s *analysis) text(at ast.Span) string {
+
+	lines := s.files[at.FileName]
+	if at.StartLineNumber < 1 || at.EndLineNumber > len(lines) {
+		return ""
+	}
+
+	var sb strings.Builder
+	for n := at.StartLineNumber; n <= at.EndLineNumber; n++ {
+		line := lines[n-1]
+
+		from, to := 0, len(line)
+		if n == at.StartLineNumber {
+			from = clamp(at.StartCharacterNumber-1, 0, len(line))
+		}
+		if n == at.EndLineNumber {
+			to = clamp(at.EndCharacterNumber-1, from, len(line))
+		}
+
+		if n > at.StartLineNumber {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(string(line[from:to]))
+	}
+
+	return strings.TrimSpace(sb.String())
+}
+
+func clamp(v int, lo int, hi int) int {
+
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+
+	return v
+}
+
+// Lookup
+
+// symbolAt
+// The symbol declared or referred to at the line and column of a file.
+func (s *analysis) symbolAt(fileName string, line int, column int) *symbol {
+
+	for _, ref := range s.refs {
+		if ref.at.FileName == fileName && ref.at.Contains(line, column) {
+			return ref.target
+		}
+	}
+
+	for _, sym := range s.symbols {
+		if sym.at.FileName == fileName && sym.at.Contains(line, column) {
+			return sym
+		}
+	}
+
+	return nil
+}
+
+// flowAt
+// The innermost flow that the line of a file is in.
+func (s *analysis) flowAt(fileName string, line int) *flow {
+
+	at := s.story
+	for _, fl := range s.flows {
+		if fl.symbol != nil && fl.fileName == fileName && fl.startLine <= line && line <= fl.endLine && fl.startLine >= at.startLine {
+			at = fl
+		}
+	}
+
+	return at
+}
+
+func (s *analysis) references(target *symbol) []*reference {
+
+	var refs []*reference
+	for _, ref := range s.refs {
+		if ref.target == target {
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs
+}
+
+// Diagnostics
+
+func (s *analysis) report(at ast.Span, severity DiagnosticSeverity, unnecessary bool, format string, args ...interface{}) {
+
+	s.diagnostics[at.FileName] = append(s.diagnostics[at.FileName], &diagnostic{
+		at:          at,
+		severity:    severity,
+		message:     fmt.Sprintf(format, args...),
+		unnecessary: unnecessary,
+	})
+}
+
+// compile
+// Reports the compiler's errors and warnings.
+func (s *analysis) compile(source string, fsys fs.FS) {
+
+	warn := func(err *compiler.Error) {
+		s.report(s.errorSpan(err), SeverityWarning, false, "%s", err.Message)
+	}
+
+	_, err := compiler.Compile(source, &compiler.Options{FileName: s.rootName, FS: fsys, Warn: warn})
+
+	if errs, ok := err.(compiler.ErrorList); ok {
+		for _, e := range errs {
+			s.report(s.errorSpan(e), SeverityError, false, "%s", e.Message)
+		}
+	} else if err != nil {
+		s.report(ast.Span{FileName: s.rootName, StartLineNumber: 1, EndLineNumber: 1, StartCharacterNumber: 1, EndCharacterNumber: 1}, SeverityError, false, "%v", err)
+	}
+}
+
+// errorSpan
+// The word that an error is at, or the rest of the line when it's at
+// something else, such as the -> of a divert.
+func (s *analysis) errorSpan(err *compiler.Error) ast.Span {
+
+	at := ast.Span{FileName: err.FileName, StartLineNumber: err.Line, EndLineNumber: err.Line, StartCharacterNumber: err.Column, EndCharacterNumber: err.Column + 1}
+
+	if lines := s.files[err.FileName]; err.Line >= 1 && err.Line <= len(lines) {
+		line := lines[err.Line-1]
+		end := err.Column - 1
+		for end < len(line) && isIdentifierRune(line[end]) {
+			end++
+		}
+		if end == err.Column-1 {
+			end = len([]rune(strings.TrimRightFunc(string(line), unicode.IsSpace)))
+		}
+		if end > err.Column-1 {
+			at.EndCharacterNumber = end + 1
+		}
+	}
+
+	return at
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// check
+// Static analysis beyond what the compiler reports: knots, stitches,
+// functions and variables that are never used.
+func (s *analysis) check() {
+
+	used := map[*symbol]bool{}
+	for _, ref := range s.refs {
+		used[ref.target] = true
+	}
+
+	for _, sym := range s.symbols {
+		if used[sym] {
+			continue
+		}
+
+		switch sym.kind {
+		case symbolKnot:
+			s.report(sym.at, SeverityHint, true, "'%s' is never diverted to", sym.name)
+
+		case symbolStitch:
+			// A knot with no content of its own starts in its first stitch
+			if knot := sym.scope; len(statements(knot.body)) == 0 && firstStitch(knot) == sym {
+				continue
+			}
+			s.report(sym.at, SeverityHint, true, "'%s' is never diverted to", sym.path.ComponentsString())
+
+		case symbolFunction:
+			s.report(sym.at, SeverityHint, true, "'%s' is never called", sym.name)
+
+		case symbolVariable, symbolConstant, symbolTemp, symbolParam:
+			s.report(sym.at, SeverityHint, true, "'%s' is never used", sym.name)
+		}
+	}
+
+	for _, diagnostics := range s.diagnostics {
+		sort.SliceStable(diagnostics, func(i, j int) bool {
+			a, b := diagnostics[i].at, diagnostics[j].at
+			if a.StartLineNumber != b.StartLineNumber {
+				return a.StartLineNumber < b.StartLineNumber
+			}
+			return a.StartCharacterNumber < b.StartCharacterNumber
+		})
+	}
+}
+
+func firstStitch(fl *flow) *symbol {
+
+	for _, child := range fl.children {
+		if child.kind == symbolStitch {
+			return child
+		}
+	}
+
+	return nil
+}
+
+// statements
+// The nodes of a body that are content rather than declarations.
+func statements(nodes []ast.Node) []ast.Node {
+
+	var body []ast.Node
+	for _, n := range nodes {
+		switch n.(type) {
+		case *ast.VarDecl, *ast.ListDecl, *ast.External, *ast.Todo, *ast.Include:
+		default:
+			body = append(body, n)
+		}
+	}
+
+	return body
+}
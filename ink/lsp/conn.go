@@ -0,0 +1,95 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/SirMetathyst/go-ink/ink/internal/jsonrpc"
+)
+
+// conn
+// A JSON-RPC connection, with each message sent after a Content-Length
+// header as the protocol describes.
+type conn struct {
+	r *bufio.Reader
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+// read
+// Reads the next message. io.EOF is returned when the input ends between
+// messages.
+func (s *conn) read() (*Message, error) {
+
+	data, err := jsonrpc.Read(s.r)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := new(Message)
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, &ResponseError{Code: CodeParseError, Message: err.Error()}
+	}
+
+	return msg, nil
+}
+
+// write
+// Sends a message. It's safe to call from more than one goroutine.
+func (s *conn) write(msg *Message) error {
+
+	msg.JSONRPC = "2.0"
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return jsonrpc.Write(s.w, data)
+}
+
+// notify
+// Sends a notification.
+func (s *conn) notify(method string, params interface{}) error {
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	return s.write(&Message{Method: method, Params: data})
+}
+
+// reply
+// Sends the response to the request with id.
+func (s *conn) reply(id *json.RawMessage, result interface{}, err error) error {
+
+	msg := &Message{ID: id}
+
+	if err != nil {
+		rerr, ok := err.(*ResponseError)
+		if !ok {
+			rerr = &ResponseError{Code: CodeInvalidRequest, Message: err.Error()}
+		}
+		msg.Error = rerr
+		return s.write(msg)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	msg.Result = data
+
+	return s.write(msg)
+}
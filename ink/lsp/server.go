@@ -0,0 +1,546 @@
+// Package lsp is a Language Server Protocol server for ink, as used by
+// the ink-lsp command.
+//
+//	err := lsp.NewServer().Serve(os.Stdin, os.Stdout)
+//
+// Each open document is compiled as the root of a story, unless another
+// open document includes it, and the diagnostics come from the compiler
+// along with some static analysis of its own. Names are resolved the way
+// the compiler resolves them, so go-to-definition, references, hover,
+// document symbols and the completion of divert targets all agree with
+// what the story does when it runs.
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/SirMetathyst/go-ink/ink/ast"
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// ErrExitWithoutShutdown
+// Returned by Serve when the client asks the server to exit without
+// shutting it down first.
+var ErrExitWithoutShutdown = errors.New("exit without shutdown")
+
+// Server
+// A language server for the documents one client has open.
+type Server struct {
+
+	// DirFS
+	// Opens the directory that a story's root file is in, to read the
+	// files it includes that aren't open. It's os.DirFS when it isn't set.
+	DirFS func(dir string) fs.FS
+
+	conn        *conn
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+// document
+// An open document and the analysis of the story it's the root of.
+type document struct {
+	uri      string
+	dir      string
+	name     string
+	version  int
+	text     string
+	analysis *analysis
+}
+
+func NewServer() *Server {
+	return &Server{docs: map[string]*document{}}
+}
+
+// Serve
+// Answers the requests that are read from r, writing to w, until the
+// client exits or r ends.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+
+	s.conn = newConn(r, w)
+
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+
+		var rerr *ResponseError
+		if errors.As(err, &rerr) {
+			null := json.RawMessage("null")
+			if err := s.conn.reply(&null, nil, rerr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+
+		result, err := s.handle(msg)
+
+		// Notifications are never answered
+		if msg.ID == nil {
+			continue
+		}
+		if err := s.conn.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// handle
+// Dispatches a request or notification to its method.
+func (s *Server) handle(msg *Message) (interface{}, error) {
+
+	if !s.initialized && msg.Method != "initialize" {
+		return nil, &ResponseError{Code: CodeServerNotInitialized, Message: "the server hasn't been initialized"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return s.initialize()
+
+	case "initialized":
+		return nil, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didOpen(params)
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didChange(params)
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didClose(params)
+
+	case "textDocument/didSave":
+		return nil, nil
+
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params), nil
+
+	case "textDocument/references":
+		var params ReferenceParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.references(params), nil
+
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params), nil
+
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.documentSymbols(params), nil
+
+	case "textDocument/completion":
+		var params CompletionParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params), nil
+	}
+
+	// Notifications the server doesn't know about are ignored
+	if msg.ID == nil {
+		return nil, nil
+	}
+
+	return nil, &ResponseError{Code: CodeMethodNotFound, Message: fmt.Sprintf("unknown method '%s'", msg.Method)}
+}
+
+func unmarshal(params json.RawMessage, v interface{}) error {
+
+	if err := json.Unmarshal(params, v); err != nil {
+		return &ResponseError{Code: CodeInvalidParams, Message: err.Error()}
+	}
+
+	return nil
+}
+
+func (s *Server) initialize() (interface{}, error) {
+
+	s.initialized = true
+
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:       TextDocumentSyncFull,
+			DefinitionProvider:     true,
+			ReferencesProvider:     true,
+			HoverProvider:          true,
+			DocumentSymbolProvider: true,
+			CompletionProvider:     &CompletionOptions{TriggerCharacters: []string{">", "."}},
+		},
+		ServerInfo: ServerInfo{Name: "ink-lsp"},
+	}, nil
+}
+
+// Documents
+
+func (s *Server) didOpen(params DidOpenTextDocumentParams) error {
+
+	p, err := uriPath(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+
+	s.docs[params.TextDocument.URI] = &document{
+		uri:     params.TextDocument.URI,
+		dir:     path.Dir(p),
+		name:    path.Base(p),
+		version: params.TextDocument.Version,
+		text:    params.TextDocument.Text,
+	}
+
+	return s.update(nil)
+}
+
+func (s *Server) didChange(params DidChangeTextDocumentParams) error {
+
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return fmt.Errorf("'%s' isn't open", params.TextDocument.URI)
+	}
+
+	if n := len(params.ContentChanges); n > 0 {
+		doc.text = params.ContentChanges[n-1].Text
+	}
+	doc.version = params.TextDocument.Version
+
+	return s.update(nil)
+}
+
+func (s *Server) didClose(params DidCloseTextDocumentParams) error {
+
+	delete(s.docs, params.TextDocument.URI)
+
+	// Its diagnostics go with it
+	return s.update([]string{params.TextDocument.URI})
+}
+
+// update
+// Analyses every open document again, since a change to one can change
+// the stories others are part of, and publishes their diagnostics.
+func (s *Server) update(closed []string) error {
+
+	for _, doc := range s.docs {
+		fsys := s.DirFS
+		if fsys == nil {
+			fsys = os.DirFS
+		}
+		doc.analysis = analyse(doc.name, doc.text, &overlay{base: fsys(doc.dir), dir: doc.dir, docs: s.docs})
+	}
+
+	for _, uri := range closed {
+		if err := s.conn.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{URI: uri, Diagnostics: []Diagnostic{}}); err != nil {
+			return err
+		}
+	}
+
+	for _, uri := range s.uris() {
+		root, fileName := s.rootOf(uri)
+
+		diagnostics := []Diagnostic{}
+		for _, d := range root.analysis.diagnostics[fileName] {
+			diagnostic := Diagnostic{Range: root.analysis.rangeOf(d.at), Severity: d.severity, Source: "ink", Message: d.message}
+			if d.unnecessary {
+				diagnostic.Tags = []DiagnosticTag{DiagnosticUnnecessary}
+			}
+			diagnostics = append(diagnostics, diagnostic)
+		}
+
+		if err := s.conn.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uris
+// The open documents in order.
+func (s *Server) uris() []string {
+
+	uris := make([]string, 0, len(s.docs))
+	for uri := range s.docs {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	return uris
+}
+
+// rootOf
+// The open document whose story the document at uri is part of, and the
+// name the story knows the file by. That's the document itself unless
+// another open document includes it.
+func (s *Server) rootOf(uri string) (*document, string) {
+
+	for _, other := range s.uris() {
+		root := s.docs[other]
+		if other == uri {
+			continue
+		}
+		for fileName := range root.analysis.files {
+			if fileName != root.name && fileURI(path.Join(root.dir, fileName)) == uri {
+				return root, fileName
+			}
+		}
+	}
+
+	doc := s.docs[uri]
+	return doc, doc.name
+}
+
+// at
+// The analysis for a position in an open document, and the line and
+// column it's at.
+func (s *Server) at(params TextDocumentPositionParams) (*document, string, int, int) {
+
+	if _, ok := s.docs[params.TextDocument.URI]; !ok {
+		return nil, "", 0, 0
+	}
+
+	root, fileName := s.rootOf(params.TextDocument.URI)
+	line, column := root.analysis.lineColumn(fileName, params.Position)
+
+	return root, fileName, line, column
+}
+
+func (s *Server) location(root *document, at ast.Span) Location {
+
+	return Location{
+		URI:   fileURI(path.Join(root.dir, at.FileName)),
+		Range: root.analysis.rangeOf(at),
+	}
+}
+
+// Language features
+
+func (s *Server) definition(params TextDocumentPositionParams) []Location {
+
+	root, fileName, line, column := s.at(params)
+	if root == nil {
+		return nil
+	}
+
+	sym := root.analysis.symbolAt(fileName, line, column)
+	if sym == nil {
+		return []Location{}
+	}
+
+	return []Location{s.location(root, sym.at)}
+}
+
+func (s *Server) references(params ReferenceParams) []Location {
+
+	root, fileName, line, column := s.at(params.TextDocumentPositionParams)
+	if root == nil {
+		return nil
+	}
+
+	locations := []Location{}
+
+	sym := root.analysis.symbolAt(fileName, line, column)
+	if sym == nil {
+		return locations
+	}
+
+	if params.Context.IncludeDeclaration {
+		locations = append(locations, s.location(root, sym.at))
+	}
+
+	for _, ref := range root.analysis.references(sym) {
+		locations = append(locations, s.location(root, ref.at))
+	}
+
+	return locations
+}
+
+func (s *Server) hover(params TextDocumentPositionParams) *Hover {
+
+	root, fileName, line, column := s.at(params)
+	if root == nil {
+		return nil
+	}
+
+	a := root.analysis
+	sym := a.symbolAt(fileName, line, column)
+	if sym == nil {
+		return nil
+	}
+
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: a.describe(sym)}}
+}
+
+func (s *Server) documentSymbols(params DocumentSymbolParams) []DocumentSymbol {
+
+	if _, ok := s.docs[params.TextDocument.URI]; !ok {
+		return nil
+	}
+
+	root, fileName := s.rootOf(params.TextDocument.URI)
+	return root.analysis.documentSymbols(fileName)
+}
+
+// divertPrefix
+// A divert target being typed at the end of a line: after ->, <- or
+// ->->, a path with any number of names.
+var divertPrefix = regexp.MustCompile(`(?:->|<-)\s*((?:[\pL\pN_]+\.)*)[\pL\pN_]*$`)
+
+func (s *Server) completion(params CompletionParams) *CompletionList {
+
+	list := &CompletionList{Items: []CompletionItem{}}
+
+	root, fileName, line, column := s.at(params.TextDocumentPositionParams)
+	if root == nil {
+		return list
+	}
+
+	a := root.analysis
+	lines := a.files[fileName]
+	if line < 1 || line > len(lines) {
+		return list
+	}
+
+	match := divertPrefix.FindStringSubmatch(string(lines[line-1][:clamp(column-1, 0, len(lines[line-1]))]))
+	if match == nil {
+		return list
+	}
+
+	fl := a.flowAt(fileName, line)
+
+	var candidates []*symbol
+	if match[1] == "" {
+		list.Items = append(list.Items,
+			CompletionItem{Label: "END", Kind: CompletionItemKindKeyword, Detail: "ends the story"},
+			CompletionItem{Label: "DONE", Kind: CompletionItemKindKeyword, Detail: "ends the current thread"})
+
+		// Every name in scope, the innermost first
+		seen := map[string]bool{}
+		for scope := fl; scope != nil; scope = scope.parent {
+			for _, child := range scope.children {
+				if !seen[child.name] {
+					seen[child.name] = true
+					candidates = append(candidates, child)
+				}
+			}
+		}
+	} else {
+		prefix := runtime.NewPathFromString(strings.TrimSuffix(match[1], "."))
+
+		names := make([]string, prefix.Length())
+		for i := range names {
+			names[i] = prefix.Component(i).Name()
+		}
+
+		if target := a.resolve(fl, names); target != nil && target.inner != nil {
+			candidates = target.inner.children
+		}
+	}
+
+	for _, sym := range candidates {
+		item := CompletionItem{Label: sym.name, Detail: sym.path.ComponentsString()}
+		switch sym.kind {
+		case symbolFunction:
+			continue
+		case symbolKnot:
+			item.Kind = CompletionItemKindModule
+		case symbolStitch:
+			item.Kind = CompletionItemKindMethod
+		default:
+			item.Kind = CompletionItemKindField
+		}
+		list.Items = append(list.Items, item)
+	}
+
+	return list
+}
+
+// Files
+
+// overlay
+// The files in a story's directory, with the open documents in place of
+// what's on disk.
+type overlay struct {
+	base fs.FS
+	dir  string
+	docs map[string]*document
+}
+
+func (s *overlay) Open(name string) (fs.File, error) {
+	return s.base.Open(name)
+}
+
+func (s *overlay) ReadFile(name string) ([]byte, error) {
+
+	if doc, ok := s.docs[fileURI(path.Join(s.dir, name))]; ok {
+		return []byte(doc.text), nil
+	}
+
+	return fs.ReadFile(s.base, name)
+}
+
+// uriPath
+// The path of a file: URI.
+func uriPath(uri string) (string, error) {
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("'%s' isn't a file", uri)
+	}
+
+	return u.Path, nil
+}
+
+func fileURI(p string) string {
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
@@ -0,0 +1,391 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mainURI = "file:///story/main.ink"
+const partURI = "file:///story/part.ink"
+
+const mainSource = `INCLUDE part.ink
+VAR score = 0
+LIST colours = red, (green), blue
+-> intro
+
+== intro ==
+Hello. {score}
+* (pick) [Pick] -> intro.after
+* [Other] -> part
+- (gathered) -> END
+= after
+~ temp bonus = 2.5
+{colours ? green: Green {bonus}}
+-> part
+
+== unused ==
+-> END
+`
+
+const partSource = `== part ==
+Part. -> END
+`
+
+// client
+// A scripted LSP client, talking to a server in-process.
+type client struct {
+	t        *testing.T
+	conn     *conn
+	messages chan *Message
+	served   chan error
+	nextID   int
+}
+
+func newClient(t *testing.T) *client {
+
+	files := fstest.MapFS{"story/part.ink": {Data: []byte(partSource)}}
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	server := NewServer()
+	server.DirFS = func(dir string) fs.FS {
+		sub, err := fs.Sub(files, strings.TrimPrefix(dir, "/"))
+		require.NoError(t, err)
+		return sub
+	}
+
+	c := &client{
+		t:        t,
+		conn:     newConn(clientIn, clientOut),
+		messages: make(chan *Message, 64),
+		served:   make(chan error, 1),
+	}
+
+	go func() {
+		c.served <- server.Serve(serverIn, serverOut)
+		serverOut.Close()
+	}()
+
+	go func() {
+		defer close(c.messages)
+		for {
+			msg, err := c.conn.read()
+			if err != nil {
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+
+	t.Cleanup(func() { clientOut.Close() })
+
+	var result InitializeResult
+	require.NoError(t, c.call("initialize", InitializeParams{RootURI: "file:///story"}, &result))
+	assert.True(t, result.Capabilities.DefinitionProvider)
+	c.notify("initialized", struct{}{})
+
+	return c
+}
+
+// next
+// The next message from the server.
+func (s *client) next() *Message {
+
+	select {
+	case msg, ok := <-s.messages:
+		require.True(s.t, ok, "the server closed the connection")
+		return msg
+
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("timed out waiting for the server")
+		return nil
+	}
+}
+
+func (s *client) call(method string, params interface{}, result interface{}) error {
+
+	s.nextID++
+	id := json.RawMessage(fmt.Sprint(s.nextID))
+
+	data, err := json.Marshal(params)
+	require.NoError(s.t, err)
+	require.NoError(s.t, s.conn.write(&Message{ID: &id, Method: method, Params: data}))
+
+	for {
+		msg := s.next()
+		if msg.ID == nil || string(*msg.ID) != string(id) {
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			require.NoError(s.t, json.Unmarshal(msg.Result, result))
+		}
+		return nil
+	}
+}
+
+func (s *client) notify(method string, params interface{}) {
+	require.NoError(s.t, s.conn.notify(method, params))
+}
+
+// open
+// Opens a document and waits for its diagnostics.
+func (s *client) open(uri string, text string) []Diagnostic {
+
+	s.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: uri, LanguageID: "ink", Version: 1, Text: text}})
+	return s.waitDiagnostics(uri)
+}
+
+func (s *client) change(uri string, version int, text string) []Diagnostic {
+
+	s.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: version},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: text}},
+	})
+	return s.waitDiagnostics(uri)
+}
+
+func (s *client) waitDiagnostics(uri string) []Diagnostic {
+
+	for {
+		msg := s.next()
+		if msg.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var params PublishDiagnosticsParams
+		require.NoError(s.t, json.Unmarshal(msg.Params, &params))
+		if params.URI == uri {
+			return params.Diagnostics
+		}
+	}
+}
+
+// position
+// The position of the first occurrence of word in text, on the line
+// containing marker, plus offset characters.
+func position(text string, marker string, word string, offset int) Position {
+
+	for i, line := range strings.Split(text, "\n") {
+		if strings.Contains(line, marker) {
+			at := strings.Index(line[strings.Index(line, marker):], word) + strings.Index(line, marker)
+			return Position{Line: i, Character: at + offset}
+		}
+	}
+
+	return Position{}
+}
+
+func at(uri string, pos Position) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: pos}
+}
+
+func TestServerDiagnostics(t *testing.T) {
+
+	c := newClient(t)
+
+	diagnostics := c.open(mainURI, strings.Replace(mainSource, "-> intro\n", "-> nowhere\n", 1))
+	require.NotEmpty(t, diagnostics)
+
+	var messages []string
+	for _, d := range diagnostics {
+		messages = append(messages, d.Message)
+	}
+	assert.Contains(t, messages, "divert target not found: 'nowhere'")
+	assert.Contains(t, messages, "'unused' is never diverted to")
+
+	diagnostics = c.change(mainURI, 2, mainSource)
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, "'unused' is never diverted to", diagnostics[0].Message)
+	assert.Equal(t, SeverityHint, diagnostics[0].Severity)
+	assert.Equal(t, []DiagnosticTag{DiagnosticUnnecessary}, diagnostics[0].Tags)
+	assert.Equal(t, Range{Start: Position{Line: 15, Character: 3}, End: Position{Line: 15, Character: 9}}, diagnostics[0].Range)
+}
+
+// TestServerIncludedDocument
+// Checks that a document included by another open document is analysed
+// as part of its story.
+func TestServerIncludedDocument(t *testing.T) {
+
+	c := newClient(t)
+
+	diagnostics := c.open(partURI, partSource)
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, "'part' is never diverted to", diagnostics[0].Message)
+
+	c.open(mainURI, mainSource)
+	assert.Empty(t, c.waitDiagnostics(partURI))
+
+	var locations []Location
+	require.NoError(t, c.call("textDocument/references", ReferenceParams{TextDocumentPositionParams: at(partURI, Position{Line: 0, Character: 4})}, &locations))
+	require.Len(t, locations, 2)
+	assert.Equal(t, mainURI, locations[0].URI)
+}
+
+func TestServerDefinition(t *testing.T) {
+
+	c := newClient(t)
+	c.open(mainURI, mainSource)
+
+	definition := func(pos Position) []Location {
+		var locations []Location
+		require.NoError(t, c.call("textDocument/definition", at(mainURI, pos), &locations))
+		return locations
+	}
+
+	// A knot
+	locations := definition(Position{Line: 3, Character: 4})
+	require.Len(t, locations, 1)
+	assert.Equal(t, Location{URI: mainURI, Range: Range{Start: Position{Line: 5, Character: 3}, End: Position{Line: 5, Character: 8}}}, locations[0])
+
+	// Each name of a path
+	locations = definition(position(mainSource, "[Pick]", "intro", 1))
+	require.Len(t, locations, 1)
+	assert.Equal(t, 5, locations[0].Range.Start.Line)
+
+	locations = definition(position(mainSource, "[Pick]", "after", 1))
+	require.Len(t, locations, 1)
+	assert.Equal(t, 10, locations[0].Range.Start.Line)
+
+	// A knot in an included file
+	locations = definition(position(mainSource, "[Other]", "part", 0))
+	require.Len(t, locations, 1)
+	assert.Equal(t, partURI, locations[0].URI)
+
+	// A variable, a list item and a temporary
+	locations = definition(position(mainSource, "Hello.", "score", 0))
+	require.Len(t, locations, 1)
+	assert.Equal(t, Range{Start: Position{Line: 1, Character: 4}, End: Position{Line: 1, Character: 9}}, locations[0].Range)
+
+	locations = definition(position(mainSource, "{colours ?", "green", 0))
+	require.Len(t, locations, 1)
+	assert.Equal(t, 2, locations[0].Range.Start.Line)
+
+	locations = definition(position(mainSource, "{colours ?", "bonus", 0))
+	require.Len(t, locations, 1)
+	assert.Equal(t, 11, locations[0].Range.Start.Line)
+
+	// Nothing
+	assert.Empty(t, definition(Position{Line: 6, Character: 1}))
+}
+
+func TestServerReferences(t *testing.T) {
+
+	c := newClient(t)
+	c.open(mainURI, mainSource)
+
+	var locations []Location
+	params := ReferenceParams{TextDocumentPositionParams: at(mainURI, Position{Line: 5, Character: 4})}
+	params.Context.IncludeDeclaration = true
+	require.NoError(t, c.call("textDocument/references", params, &locations))
+
+	var lines []int
+	for _, l := range locations {
+		lines = append(lines, l.Range.Start.Line)
+	}
+	assert.Equal(t, []int{5, 3, 7}, lines)
+}
+
+func TestServerHover(t *testing.T) {
+
+	c := newClient(t)
+	c.open(mainURI, mainSource)
+
+	hover := func(pos Position) string {
+		var h *Hover
+		require.NoError(t, c.call("textDocument/hover", at(mainURI, pos), &h))
+		if h == nil {
+			return ""
+		}
+		return h.Contents.Value
+	}
+
+	assert.Equal(t, "```ink\nVAR score = 0\n```\n\nvariable `score` of type `int`", hover(position(mainSource, "Hello.", "score", 2)))
+	assert.Equal(t, "list item `colours.green` = 2, in the list's initial value", hover(position(mainSource, "{colours ?", "green", 0)))
+	assert.Equal(t, "```ink\n~ temp bonus = 2.5\n```\n\ntemporary variable `bonus` of type `float`", hover(position(mainSource, "{colours ?", "bonus", 0)))
+	assert.Equal(t, "```ink\n= after\n```\n\nstitch `intro.after`", hover(position(mainSource, "[Pick]", "after", 0)))
+	assert.Equal(t, "label of a gather `intro.gathered`", hover(position(mainSource, "(gathered)", "gathered", 0)))
+	assert.Equal(t, "", hover(Position{Line: 6, Character: 1}))
+}
+
+func TestServerDocumentSymbols(t *testing.T) {
+
+	c := newClient(t)
+	c.open(mainURI, mainSource)
+
+	var symbols []DocumentSymbol
+	require.NoError(t, c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: mainURI}}, &symbols))
+
+	var names []string
+	for _, s := range symbols {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"intro", "unused", "score", "colours"}, names)
+
+	intro := symbols[0]
+	assert.Equal(t, SymbolKindNamespace, intro.Kind)
+	assert.Equal(t, "== intro ==", intro.Detail)
+	assert.Equal(t, Range{Start: Position{Line: 5, Character: 0}, End: Position{Line: 14, Character: 0}}, intro.Range)
+
+	var children []string
+	for _, s := range intro.Children {
+		children = append(children, s.Name)
+	}
+	assert.Equal(t, []string{"after", "pick", "gathered"}, children)
+
+	require.Len(t, symbols[3].Children, 3)
+	assert.Equal(t, SymbolKindEnumMember, symbols[3].Children[1].Kind)
+}
+
+func TestServerCompletion(t *testing.T) {
+
+	c := newClient(t)
+
+	source := mainSource + "== last ==\n-> \n-> intro.\n"
+	c.open(mainURI, source)
+
+	complete := func(pos Position) []string {
+		var list CompletionList
+		require.NoError(t, c.call("textDocument/completion", CompletionParams{TextDocumentPositionParams: at(mainURI, pos)}, &list))
+		var labels []string
+		for _, item := range list.Items {
+			labels = append(labels, item.Label)
+		}
+		return labels
+	}
+
+	assert.Equal(t, []string{"END", "DONE", "part", "intro", "unused", "last"}, complete(Position{Line: 18, Character: 3}))
+	assert.Equal(t, []string{"after", "pick", "gathered"}, complete(Position{Line: 19, Character: 9}))
+	assert.Empty(t, complete(Position{Line: 6, Character: 3}))
+}
+
+func TestServerLifecycle(t *testing.T) {
+
+	c := newClient(t)
+
+	err := c.call("textDocument/unknown", struct{}{}, nil)
+	var rerr *ResponseError
+	require.ErrorAs(t, err, &rerr)
+	assert.Equal(t, CodeMethodNotFound, rerr.Code)
+
+	require.NoError(t, c.call("shutdown", nil, nil))
+	c.notify("exit", nil)
+
+	select {
+	case err := <-c.served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server didn't exit")
+	}
+}
@@ -0,0 +1,230 @@
+package lsp
+
+import (
+	"fmt"
+
+	"github.com/SirMetathyst/go-ink/ink/ast"
+)
+
+// describe
+// The markdown shown when hovering over a symbol: its declaration, and
+// what sort of thing it is.
+func (s *analysis) describe(sym *symbol) string {
+
+	code := func(text string) string {
+		return "```ink\n" + text + "\n```"
+	}
+
+	switch sym.kind {
+	case symbolKnot, symbolStitch, symbolFunction:
+		return code(s.text(sym.node.Pos())) + fmt.Sprintf("\n\n%s `%s`", kindName(sym.kind), sym.path.ComponentsString())
+
+	case symbolLabel:
+		what := "choice"
+		if _, ok := sym.node.(*ast.Gather); ok {
+			what = "gather"
+		}
+		return fmt.Sprintf("label of a %s `%s`", what, sym.path.ComponentsString())
+
+	case symbolVariable, symbolConstant:
+		decl := sym.node.(*ast.VarDecl)
+		text := code(s.text(decl.Span))
+		if t := s.typeOf(decl.Value); t != "" {
+			text += fmt.Sprintf("\n\n%s `%s` of type `%s`", kindName(sym.kind), sym.name, t)
+		}
+		return text
+
+	case symbolList:
+		return code(s.text(sym.node.Pos())) + "\n\nlist `" + sym.name + "`"
+
+	case symbolListItem:
+		item := sym.node.(*ast.ListItemDecl)
+		text := fmt.Sprintf("list item `%s` = %d", sym.path.ComponentsString(), item.Value)
+		if item.Selected {
+			text += ", in the list's initial value"
+		}
+		return text
+
+	case symbolExternal:
+		return code(s.text(sym.node.Pos())) + "\n\nexternal function `" + sym.name + "`"
+
+	case symbolParam:
+		p := sym.node.(*ast.Param)
+		text := fmt.Sprintf("parameter `%s` of `%s`", sym.name, sym.scope.symbol.path.ComponentsString())
+		switch {
+		case p.IsRef:
+			text += ", passed by reference"
+		case p.IsDivert:
+			text += ", a divert target"
+		}
+		return text
+
+	case symbolTemp:
+		a := sym.node.(*ast.Assignment)
+		text := code(s.text(a.Span))
+		if t := s.typeOf(a.Value); t != "" {
+			text += fmt.Sprintf("\n\ntemporary variable `%s` of type `%s`", sym.name, t)
+		}
+		return text
+	}
+
+	return sym.name
+}
+
+func kindName(kind symbolKind) string {
+
+	switch kind {
+	case symbolKnot:
+		return "knot"
+	case symbolStitch:
+		return "stitch"
+	case symbolFunction:
+		return "function"
+	case symbolConstant:
+		return "constant"
+	}
+
+	return "variable"
+}
+
+// typeOf
+// The type of the value an expression has, as far as it can be told
+// without running it.
+func (s *analysis) typeOf(e ast.Expr) string {
+
+	switch e := e.(type) {
+	case *ast.Number:
+		if _, ok := e.Value.(float64); ok {
+			return "float"
+		}
+		return "int"
+
+	case *ast.Bool:
+		return "bool"
+
+	case *ast.String:
+		return "string"
+
+	case *ast.DivertTarget:
+		return "divert target"
+
+	case *ast.List:
+		return "list"
+
+	case *ast.Variable:
+		if len(e.Path) == 2 && s.listItem(e.Path[0], e.Path[1]) != nil {
+			return "list"
+		}
+		if len(e.Path) == 1 && s.listItem("", e.Path[0]) != nil {
+			return "list"
+		}
+
+	case *ast.Unary:
+		if e.Op == "!" {
+			return "bool"
+		}
+		return s.typeOf(e.Value)
+
+	case *ast.Binary:
+		switch e.Op {
+		case "==", "!=", "<", ">", "<=", ">=", "&&", "||", "?", "!?":
+			return "bool"
+		}
+		left, right := s.typeOf(e.Left), s.typeOf(e.Right)
+		if left == "float" || right == "float" {
+			return "float"
+		}
+		if left == right {
+			return left
+		}
+	}
+
+	return ""
+}
+
+// documentSymbols
+// The outline of a file: its knots with their stitches and labels, its
+// functions, and its declarations.
+func (s *analysis) documentSymbols(fileName string) []DocumentSymbol {
+
+	symbols := []DocumentSymbol{}
+
+	for _, sym := range s.symbols {
+		if sym.at.FileName != fileName {
+			continue
+		}
+
+		switch {
+		case sym.kind == symbolKnot || sym.kind == symbolFunction:
+			symbols = append(symbols, s.documentSymbol(sym))
+
+		case sym.kind == symbolLabel && sym.scope == s.story:
+			symbols = append(symbols, s.documentSymbol(sym))
+
+		case sym.kind == symbolVariable || sym.kind == symbolConstant || sym.kind == symbolList || sym.kind == symbolExternal:
+			symbols = append(symbols, s.documentSymbol(sym))
+		}
+	}
+
+	return symbols
+}
+
+func (s *analysis) documentSymbol(sym *symbol) DocumentSymbol {
+
+	ds := DocumentSymbol{
+		Name:           sym.name,
+		Range:          s.rangeOf(sym.node.Pos()),
+		SelectionRange: s.rangeOf(sym.at),
+	}
+
+	switch sym.kind {
+	case symbolKnot:
+		ds.Kind = SymbolKindNamespace
+	case symbolStitch:
+		ds.Kind = SymbolKindMethod
+	case symbolFunction, symbolExternal:
+		ds.Kind = SymbolKindFunction
+	case symbolLabel:
+		ds.Kind = SymbolKindKey
+	case symbolVariable:
+		ds.Kind = SymbolKindVariable
+	case symbolConstant:
+		ds.Kind = SymbolKindConstant
+	case symbolList:
+		ds.Kind = SymbolKindEnum
+	case symbolListItem:
+		ds.Kind = SymbolKindEnumMember
+	}
+
+	// Flows cover the lines up to the next one, with their stitches and
+	// labels inside
+	if fl := sym.inner; fl != nil {
+		ds.Detail = s.text(sym.node.Pos())
+		end := s.position(fl.fileName, fl.endLine, len(s.lineOf(fl.fileName, fl.endLine))+1)
+		ds.Range = Range{Start: ds.Range.Start, End: end}
+
+		for _, child := range fl.children {
+			ds.Children = append(ds.Children, s.documentSymbol(child))
+		}
+	}
+
+	if decl, ok := sym.node.(*ast.ListDecl); ok {
+		for _, item := range s.symbols {
+			if item.kind == symbolListItem && item.path.Component(0).Name() == decl.Name && item.at.FileName == sym.at.FileName {
+				ds.Children = append(ds.Children, s.documentSymbol(item))
+			}
+		}
+	}
+
+	return ds
+}
+
+func (s *analysis) lineOf(fileName string, line int) []rune {
+
+	lines := s.files[fileName]
+	if line < 1 || line > len(lines) {
+		return nil
+	}
+
+	return lines[line-1]
+}
@@ -0,0 +1,186 @@
+// Package conformance plays stories for the conformance tests, writing
+// transcripts in the form of the golden files in runtime/testdata/conformance.
+// It's shared by the runtime, the compiler and the inkjet VM, so it only
+// talks to a story through the Story interface; the runtime's own tests
+// can't import a package that imports the runtime.
+package conformance
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Story
+// What a Transcript needs from the story it plays. A story that can't run
+// a script command returns an error from it.
+type Story interface {
+	CanContinue() bool
+	Continue() (string, error)
+	CurrentTags() []string
+	CurrentChoices() []Choice
+
+	ChooseChoiceIndex(index int) error
+	ChoosePathString(path string) error
+	SwitchFlow(flowName string) error
+	SwitchToDefaultFlow() error
+	RemoveFlow(flowName string) error
+
+	// Reload saves the state, then carries on with a new story that the
+	// save has been loaded into.
+	Reload() error
+}
+
+// Choice
+// A choice as it's written in a transcript.
+type Choice struct {
+	Index int
+	Text  string
+	Tags  []string
+}
+
+// ErrUnsupported
+// Returned by a Story for script commands it doesn't have.
+var ErrUnsupported = errors.New("unsupported script command")
+
+// Transcript
+// Records a story being played.
+type Transcript struct {
+	Story Story
+
+	// Errors, if set, returns the ink errors and warnings reported since
+	// it was last called. They're reported during Continue, but are
+	// written after the line that caused them.
+	Errors func() []string
+
+	out strings.Builder
+}
+
+func (s *Transcript) printf(format string, args ...interface{}) {
+
+	s.out.WriteString(fmt.Sprintf(format, args...))
+	s.out.WriteString("\n")
+}
+
+// String
+// The transcript so far.
+func (s *Transcript) String() string {
+	return s.out.String()
+}
+
+// Play
+// Continues as far as possible, recording each line with its tags, then
+// records the choices that are available.
+func (s *Transcript) Play() {
+
+	for s.Story.CanContinue() {
+		text, err := s.Story.Continue()
+		if err != nil {
+			s.printf("error: %v", err)
+			return
+		}
+
+		s.printf("text: %s", strconv.Quote(text))
+		for _, tag := range s.Story.CurrentTags() {
+			s.printf("tag: %s", tag)
+		}
+
+		if s.Errors != nil {
+			for _, err := range s.Errors() {
+				s.printf("%s", err)
+			}
+		}
+	}
+
+	choices := s.Story.CurrentChoices()
+	for _, choice := range choices {
+		s.printf("choice %d: %s", choice.Index, choice.Text)
+		for _, tag := range choice.Tags {
+			s.printf("  tag: %s", tag)
+		}
+	}
+
+	if !s.Story.CanContinue() && len(choices) == 0 {
+		s.printf("end")
+	}
+}
+
+// Exec
+// Records and runs a script command, one of:
+//
+//	choose <index>   choose one of the current choices
+//	goto <path>      ChoosePathString
+//	switch <flow>    SwitchFlow
+//	default          SwitchToDefaultFlow
+//	remove <flow>    RemoveFlow
+//	reload           save the state, then load it into a new story
+//
+// then plays on. An error from the story is recorded; the error returned
+// is for a command that isn't known.
+func (s *Transcript) Exec(command string) error {
+
+	s.printf("> %s", command)
+
+	name, arg, _ := strings.Cut(command, " ")
+
+	var err error
+	switch name {
+	case "choose":
+		var index int
+		if index, err = strconv.Atoi(arg); err == nil {
+			err = s.Story.ChooseChoiceIndex(index)
+		}
+	case "goto":
+		err = s.Story.ChoosePathString(arg)
+	case "switch":
+		err = s.Story.SwitchFlow(arg)
+	case "default":
+		err = s.Story.SwitchToDefaultFlow()
+	case "remove":
+		err = s.Story.RemoveFlow(arg)
+	case "reload":
+		err = s.Story.Reload()
+	default:
+		return fmt.Errorf("unknown script command '%s'", command)
+	}
+
+	if errors.Is(err, ErrUnsupported) {
+		return fmt.Errorf("%w '%s'", err, command)
+	}
+
+	if err != nil {
+		s.printf("error: %v", err)
+		return nil
+	}
+
+	s.Play()
+	return nil
+}
+
+// ReadScript
+// Reads the commands in a .script file, one per line. A story without
+// a script has no commands.
+func ReadScript(path string) ([]string, error) {
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var commands []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			commands = append(commands, line)
+		}
+	}
+
+	return commands, scanner.Err()
+}
@@ -0,0 +1,778 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Binary saves
+//
+// A binary save holds the same state as the JSON written by
+// StoryState.WriteJson, but packed as tightly as possible since it may be
+// written every turn. After the "INKS" identifier come the save version
+// and ink format version, then a table of every string in the save, then
+// the state itself. Integers are varints, and strings are indices into
+// the table, as the same paths tend to appear many times over. State
+// deltas use the same layout under an "INKD" identifier.
+
+// binarySaveIdentifier
+// The first four bytes of every binary save.
+const binarySaveIdentifier = "INKS"
+
+// kMinCompatibleBinaryLoadVersion
+// The binary format doesn't have a version of its own, but is written with
+// the JSON save version, KInkSaveStateVersion. It can only be read back at
+// the save version that was current when it was added.
+const kMinCompatibleBinaryLoadVersion = 10
+
+// The kinds of runtime object that can be found in a save
+const (
+	binaryObjectBool byte = iota
+	binaryObjectInt
+	binaryObjectFloat
+	binaryObjectString
+	binaryObjectDivertTarget
+	binaryObjectVariablePointer
+	binaryObjectList
+	binaryObjectControlCommand
+	binaryObjectGlue
+	binaryObjectVoid
+	binaryObjectTag
+)
+
+var errTruncatedBinarySave = errors.New("unexpected end of data")
+
+// IsBinarySave
+// Reports whether data starts like a save written by
+// StoryState.WriteBinary.
+func IsBinarySave(data []byte) bool {
+	return len(data) >= 4 && string(data[:4]) == binarySaveIdentifier
+}
+
+// binarySaveVersion
+// Reads the save version from the header of a binary save or delta.
+func binarySaveVersion(data []byte) (int, error) {
+
+	version, n := binary.Uvarint(data[4:])
+	if n <= 0 || version > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: binary save version is missing", ErrSaveFormatIncompatible)
+	}
+
+	return int(version), nil
+}
+
+type binarySaveWriter struct {
+	body    []byte
+	strings []string
+	indices map[string]int
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func newBinarySaveWriter() *binarySaveWriter {
+
+	w := new(binarySaveWriter)
+	w.indices = make(map[string]int)
+
+	return w
+}
+
+// Bytes
+// The complete save: the header and string table followed by the body.
+func (w *binarySaveWriter) Bytes(identifier string) []byte {
+
+	header := &binarySaveWriter{}
+	header.body = append(header.body, identifier...)
+	header.uvarint(KInkSaveStateVersion)
+	header.uvarint(InkVersionCurrent)
+
+	header.uvarint(len(w.strings))
+	for _, str := range w.strings {
+		header.uvarint(len(str))
+		header.body = append(header.body, str...)
+	}
+
+	return append(header.body, w.body...)
+}
+
+func (w *binarySaveWriter) uvarint(v int) {
+	n := binary.PutUvarint(w.scratch[:], uint64(v))
+	w.body = append(w.body, w.scratch[:n]...)
+}
+
+func (w *binarySaveWriter) varint(v int) {
+	n := binary.PutVarint(w.scratch[:], int64(v))
+	w.body = append(w.body, w.scratch[:n]...)
+}
+
+func (w *binarySaveWriter) bool(v bool) {
+	if v {
+		w.body = append(w.body, 1)
+	} else {
+		w.body = append(w.body, 0)
+	}
+}
+
+func (w *binarySaveWriter) string(str string) {
+	w.uvarint(w.stringIndex(str))
+}
+
+// optionalString
+// Writes 0 for a missing string, otherwise its index plus one.
+func (w *binarySaveWriter) optionalString(str string, ok bool) {
+
+	if !ok {
+		w.uvarint(0)
+		return
+	}
+
+	w.uvarint(w.stringIndex(str) + 1)
+}
+
+func (w *binarySaveWriter) stringIndex(str string) int {
+
+	index, ok := w.indices[str]
+	if !ok {
+		index = len(w.strings)
+		w.strings = append(w.strings, str)
+		w.indices[str] = index
+	}
+
+	return index
+}
+
+// WriteStoryState
+// The binary equivalent of StoryState.WriteJson.
+func (w *binarySaveWriter) WriteStoryState(s *StoryState) {
+
+	// Flows, sorted by name so that a state always encodes the same way
+	if s._namedFlows != nil {
+		names := make([]string, 0, len(s._namedFlows))
+		for name := range s._namedFlows {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.uvarint(len(names))
+		for _, name := range names {
+			w.WriteFlow(s._namedFlows[name])
+		}
+	} else {
+		w.uvarint(1)
+		w.WriteFlow(s._currentFlow)
+	}
+
+	w.string(s._currentFlow.Name)
+
+	// Globals, skipping those that still have their default values
+	globals := make(map[string]Object, len(s._variablesState._globalVariables))
+	for name, val := range s._variablesState._globalVariables {
+		if DontSaveDefaultValues {
+			if defaultVal, ok := s._variablesState._defaultGlobalVariables[name]; ok {
+				if s._variablesState.RuntimeObjectsEqual(val, defaultVal) {
+					continue
+				}
+			}
+		}
+		globals[name] = val
+	}
+	w.WriteVariables(globals)
+
+	w.WriteObjects(s._evaluationStack)
+
+	if s.DivertedPointer.IsNull() {
+		w.optionalString("", false)
+	} else {
+		w.optionalString(s.DivertedPointer.Path().ComponentsString(), true)
+	}
+
+	w.WriteCounts(s._visitCounts)
+	w.WriteCounts(s._turnIndices)
+
+	w.varint(s._currentTurnIndex)
+	w.varint(s.StorySeed)
+	w.varint(s.PreviousRandom)
+}
+
+// WriteFlow
+// The binary equivalent of Flow.WriteJson.
+func (w *binarySaveWriter) WriteFlow(flow *Flow) {
+
+	w.string(flow.Name)
+
+	w.uvarint(len(flow.CallStack._threads))
+	for _, thread := range flow.CallStack._threads {
+		w.WriteThread(thread)
+	}
+	w.varint(flow.CallStack._threadCounter)
+
+	w.WriteObjects(flow.OutputStream)
+
+	// As in the JSON, the originalThreadIndex of each choice has to be set
+	// before the choices themselves are written out
+	var choiceThreads []*Thread
+	for _, c := range flow.CurrentChoices {
+		c.OriginalTheadIndex = c.ThreadAtGeneration.ThreadIndex
+		if flow.CallStack.ThreadWithIndex(c.OriginalTheadIndex) == nil {
+			choiceThreads = append(choiceThreads, c.ThreadAtGeneration)
+		}
+	}
+
+	w.uvarint(len(choiceThreads))
+	for _, thread := range choiceThreads {
+		w.WriteThread(thread)
+	}
+
+	w.uvarint(len(flow.CurrentChoices))
+	for _, c := range flow.CurrentChoices {
+		w.WriteChoice(c)
+	}
+}
+
+// WriteChoice
+// The binary equivalent of WriteChoice. As in the JSON, the thread the
+// choice was generated on isn't part of it.
+func (w *binarySaveWriter) WriteChoice(c *Choice) {
+	w.string(c.Text)
+	w.varint(c.Index)
+	w.string(c.SourcePath)
+	w.varint(c.OriginalTheadIndex)
+	w.string(c.PathStringOnChoice())
+}
+
+// WriteThread
+// The binary equivalent of Thread.WriteJson.
+func (w *binarySaveWriter) WriteThread(thread *Thread) {
+
+	w.uvarint(len(thread._elements))
+	for _, el := range thread._elements {
+
+		if el.CurrentPointer.IsNull() {
+			w.optionalString("", false)
+		} else {
+			w.optionalString(el.CurrentPointer.Container.Path(el.CurrentPointer.Container).ComponentsString(), true)
+			w.varint(el.CurrentPointer.Index)
+		}
+
+		w.bool(el.InExpressionEvaluation)
+		w.uvarint(int(el.PushPopType()))
+		w.WriteVariables(el.TemporaryVariables)
+	}
+
+	w.varint(thread.ThreadIndex)
+
+	if thread.PreviousPointer.IsNull() {
+		w.optionalString("", false)
+	} else {
+		resolvedPointer := thread.PreviousPointer.Resolve()
+		w.optionalString(resolvedPointer.Path(resolvedPointer).String(), true)
+	}
+}
+
+// WriteVariables
+// Writes the variables sorted by name.
+func (w *binarySaveWriter) WriteVariables(variables map[string]Object) {
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.uvarint(len(names))
+	for _, name := range names {
+		w.string(name)
+		w.WriteObject(variables[name])
+	}
+}
+
+func (w *binarySaveWriter) WriteObjects(objs []Object) {
+
+	w.uvarint(len(objs))
+	for _, obj := range objs {
+		w.WriteObject(obj)
+	}
+}
+
+// WriteObject
+// The binary equivalent of WriteRuntimeObject, for the objects that can
+// be part of a story's state.
+func (w *binarySaveWriter) WriteObject(obj Object) {
+
+	switch obj := obj.(type) {
+
+	case *BoolValue:
+		w.body = append(w.body, binaryObjectBool)
+		w.bool(obj.Value())
+
+	case *IntValue:
+		w.body = append(w.body, binaryObjectInt)
+		w.varint(obj.Value())
+
+	case *FloatValue:
+		w.body = append(w.body, binaryObjectFloat)
+		binary.LittleEndian.PutUint64(w.scratch[:], math.Float64bits(obj.Value()))
+		w.body = append(w.body, w.scratch[:8]...)
+
+	case *StringValue:
+		w.body = append(w.body, binaryObjectString)
+		w.string(obj.Value())
+
+	case *DivertTargetValue:
+		w.body = append(w.body, binaryObjectDivertTarget)
+		w.string(obj.Value().ComponentsString())
+
+	case *VariablePointerValue:
+		w.body = append(w.body, binaryObjectVariablePointer)
+		w.string(obj.Value())
+		w.varint(obj.ContextIndex())
+
+	case *ListValue:
+		w.body = append(w.body, binaryObjectList)
+
+		rawList := obj.Value()
+
+		orderedItems := rawList.OrderedItems()
+		w.uvarint(len(orderedItems))
+		for _, item := range orderedItems {
+
+			// As in WriteInkList
+			originName := item.Key.OriginName()
+			if originName == "" {
+				originName = "?"
+			}
+
+			w.string(originName)
+			w.string(item.Key.ItemName())
+			w.varint(item.Value)
+		}
+
+		// Origins are only needed for empty lists, as in JSON
+		var originNames []string
+		if rawList.Count() == 0 {
+			originNames = rawList.OriginNames()
+		}
+		w.uvarint(len(originNames))
+		for _, name := range originNames {
+			w.string(name)
+		}
+
+	case *ControlCommand:
+		w.body = append(w.body, binaryObjectControlCommand)
+		w.varint(int(obj.CommandType))
+
+	case *Glue:
+		w.body = append(w.body, binaryObjectGlue)
+
+	case *Void:
+		w.body = append(w.body, binaryObjectVoid)
+
+	case *Tag:
+		w.body = append(w.body, binaryObjectTag)
+		w.string(obj.Text())
+
+	default:
+		panic(fmt.Sprintf("Failed to write runtime object to binary save: %v", obj))
+	}
+}
+
+func (w *binarySaveWriter) WriteCounts(counts map[string]int) {
+
+	paths := make([]string, 0, len(counts))
+	for path := range counts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	w.uvarint(len(paths))
+	for _, path := range paths {
+		w.string(path)
+		w.varint(counts[path])
+	}
+}
+
+// binarySaveReader
+// Reads a binary save, panicking with an error if the data is malformed.
+type binarySaveReader struct {
+	data    []byte
+	pos     int
+	strings []string
+}
+
+// newBinarySaveReader
+// Reads the header and string table, leaving the reader at the start of
+// the body.
+func newBinarySaveReader(data []byte) *binarySaveReader {
+
+	r := &binarySaveReader{data: data, pos: 4}
+
+	r.uvarint() // Save version, already checked
+	r.uvarint() // Ink format version, not used right now
+
+	r.strings = make([]string, r.length())
+	for i := range r.strings {
+		n := r.length()
+		r.strings[i] = string(r.data[r.pos : r.pos+n])
+		r.pos += n
+	}
+
+	return r
+}
+
+func (r *binarySaveReader) uvarint() int {
+
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 || v > math.MaxInt32 {
+		panic(errTruncatedBinarySave)
+	}
+	r.pos += n
+
+	return int(v)
+}
+
+func (r *binarySaveReader) varint() int {
+
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 || v > math.MaxInt32 || v < math.MinInt32 {
+		panic(errTruncatedBinarySave)
+	}
+	r.pos += n
+
+	return int(v)
+}
+
+// length
+// Reads the length of something that follows, which can't be longer
+// than the rest of the data.
+func (r *binarySaveReader) length() int {
+
+	n := r.uvarint()
+	if n > len(r.data)-r.pos {
+		panic(errTruncatedBinarySave)
+	}
+
+	return n
+}
+
+func (r *binarySaveReader) byte() byte {
+
+	if r.pos >= len(r.data) {
+		panic(errTruncatedBinarySave)
+	}
+	b := r.data[r.pos]
+	r.pos++
+
+	return b
+}
+
+func (r *binarySaveReader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *binarySaveReader) string() string {
+
+	index := r.uvarint()
+	if index >= len(r.strings) {
+		panic(fmt.Errorf("string %d is out of range", index))
+	}
+
+	return r.strings[index]
+}
+
+func (r *binarySaveReader) optionalString() (string, bool) {
+
+	index := r.uvarint()
+	if index == 0 {
+		return "", false
+	}
+	if index > len(r.strings) {
+		panic(fmt.Errorf("string %d is out of range", index-1))
+	}
+
+	return r.strings[index-1], true
+}
+
+// ReadStoryState
+// The binary equivalent of StoryState.LoadJsonObj. The whole save is read
+// before any of it is set on s, so that a save that's truncated or
+// corrupt part way through leaves s as it was.
+func (r *binarySaveReader) ReadStoryState(s *StoryState) {
+
+	flowCount := r.length()
+	if flowCount == 0 {
+		panic(errors.New("binary save has no flows"))
+	}
+
+	flows := make([]*Flow, flowCount)
+	for i := range flows {
+		flows[i] = r.ReadFlow(s._story)
+	}
+
+	// Single default flow
+	var namedFlows map[string]*Flow
+	currentFlow := flows[0]
+
+	currentFlowName := r.string()
+	if flowCount > 1 {
+		namedFlows = make(map[string]*Flow, flowCount)
+		for _, flow := range flows {
+			namedFlows[flow.Name] = flow
+		}
+
+		var ok bool
+		if currentFlow, ok = namedFlows[currentFlowName]; !ok {
+			panic(fmt.Errorf("binary save has no flow named '%s'", currentFlowName))
+		}
+	}
+
+	loadedGlobals := r.ReadVariables()
+	evaluationStack := r.ReadObjects()
+
+	divertedPointer := NullPointer
+	if currentDivertTarget, ok := r.optionalString(); ok {
+		divertedPointer = s._story.PointerAtPath(NewPathFromString(currentDivertTarget))
+	}
+
+	visitCounts := r.ReadCounts()
+	turnIndices := r.ReadCounts()
+
+	currentTurnIndex := r.varint()
+	storySeed := r.varint()
+	previousRandom := r.varint()
+
+	r.end()
+
+	s._namedFlows = namedFlows
+	s._currentFlow = currentFlow
+
+	s.OutputStreamDirty()
+	s._aliveFlowNamesDirty = true
+
+	s._variablesState.setLoadedGlobals(loadedGlobals)
+	s._variablesState.SetCallStack(s._currentFlow.CallStack)
+
+	s._evaluationStack = evaluationStack
+	s.DivertedPointer = divertedPointer
+
+	s._visitCounts = visitCounts
+	s._turnIndices = turnIndices
+
+	s._currentTurnIndex = currentTurnIndex
+	s.StorySeed = storySeed
+	s.PreviousRandom = previousRandom
+}
+
+// end
+// Checks that everything has been read.
+func (r *binarySaveReader) end() {
+
+	if r.pos != len(r.data) {
+		panic(fmt.Errorf("%d unexpected bytes at the end", len(r.data)-r.pos))
+	}
+}
+
+// ReadFlow
+// The binary equivalent of NewFlowFromJObject.
+func (r *binarySaveReader) ReadFlow(story *Story) *Flow {
+
+	newFlow := new(Flow)
+	newFlow.Name = r.string()
+	newFlow.CallStack = NewCallStack(story)
+
+	threads := make([]*Thread, r.length())
+	if len(threads) == 0 {
+		panic(fmt.Errorf("flow '%s' has no threads", newFlow.Name))
+	}
+	for i := range threads {
+		threads[i] = r.ReadThread(story)
+	}
+	newFlow.CallStack._threads = threads
+	newFlow.CallStack._threadCounter = r.varint()
+
+	newFlow.OutputStream = r.ReadObjects()
+
+	choiceThreadCount := r.length()
+	choiceThreads := make(map[int]*Thread, choiceThreadCount)
+	for i := 0; i < choiceThreadCount; i++ {
+		thread := r.ReadThread(story)
+		choiceThreads[thread.ThreadIndex] = thread
+	}
+
+	newFlow.CurrentChoices = make([]*Choice, r.length())
+	for i := range newFlow.CurrentChoices {
+
+		choice := r.ReadChoice()
+
+		// As in Flow.LoadFlowChoiceThreads
+		if foundActiveThread := newFlow.CallStack.ThreadWithIndex(choice.OriginalTheadIndex); foundActiveThread != nil {
+			choice.ThreadAtGeneration = foundActiveThread.Copy()
+		} else if savedChoiceThread, ok := choiceThreads[choice.OriginalTheadIndex]; ok {
+			choice.ThreadAtGeneration = savedChoiceThread
+		} else {
+			panic(fmt.Errorf("choice '%s' has no thread", choice.Text))
+		}
+
+		newFlow.CurrentChoices[i] = choice
+	}
+
+	return newFlow
+}
+
+// ReadChoice
+// The binary equivalent of JObjectToChoice.
+func (r *binarySaveReader) ReadChoice() *Choice {
+
+	choice := NewChoice()
+	choice.Text = r.string()
+	choice.Index = r.varint()
+	choice.SourcePath = r.string()
+	choice.OriginalTheadIndex = r.varint()
+	choice.SetPathStringOnChoice(r.string())
+
+	return choice
+}
+
+// ReadThread
+// The binary equivalent of NewThreadFromJObject.
+func (r *binarySaveReader) ReadThread(storyContext *Story) *Thread {
+
+	newThread := NewThread()
+
+	elementCount := r.length()
+	for i := 0; i < elementCount; i++ {
+
+		pointer := NullPointer
+		if currentContainerPathStr, ok := r.optionalString(); ok {
+			pointer = loadedElementPointer(storyContext, currentContainerPathStr, r.varint())
+		}
+
+		inExpressionEvaluation := r.bool()
+		pushPopType := PushPopType(r.uvarint())
+		if pushPopType > FunctionEvaluationFromGame {
+			panic(fmt.Errorf("unknown push/pop type %d", pushPopType))
+		}
+
+		el := NewElement(pushPopType, pointer, inExpressionEvaluation)
+		el.TemporaryVariables = r.ReadVariables()
+
+		newThread.Add(el)
+	}
+
+	newThread.ThreadIndex = r.varint()
+
+	if prevContentObjPath, ok := r.optionalString(); ok {
+		newThread.PreviousPointer = storyContext.PointerAtPath(NewPathFromString(prevContentObjPath))
+	}
+
+	return newThread
+}
+
+func (r *binarySaveReader) ReadVariables() map[string]Object {
+
+	count := r.length()
+
+	variables := make(map[string]Object, count)
+	for i := 0; i < count; i++ {
+		name := r.string()
+		variables[name] = r.ReadObject()
+	}
+
+	return variables
+}
+
+func (r *binarySaveReader) ReadObjects() []Object {
+
+	objs := make([]Object, r.length())
+	for i := range objs {
+		objs[i] = r.ReadObject()
+	}
+
+	return objs
+}
+
+// ReadObject
+// The binary equivalent of JTokenToRuntimeObject.
+func (r *binarySaveReader) ReadObject() Object {
+
+	switch kind := r.byte(); kind {
+
+	case binaryObjectBool:
+		return CreateValue(r.bool())
+
+	case binaryObjectInt:
+		return CreateValue(r.varint())
+
+	case binaryObjectFloat:
+		if len(r.data)-r.pos < 8 {
+			panic(errTruncatedBinarySave)
+		}
+		bits := binary.LittleEndian.Uint64(r.data[r.pos:])
+		r.pos += 8
+		return CreateValue(math.Float64frombits(bits))
+
+	case binaryObjectString:
+		return NewStringValueFromString(r.string())
+
+	case binaryObjectDivertTarget:
+		return NewDivertTargetValueFromPath(NewPathFromString(r.string()))
+
+	case binaryObjectVariablePointer:
+		name := r.string()
+		return NewVariablePointerValueFromValue(name, r.varint())
+
+	case binaryObjectList:
+
+		rawList := NewInkList()
+
+		itemCount := r.length()
+		for i := 0; i < itemCount; i++ {
+			originName := r.string()
+			itemName := r.string()
+			rawList.Add(NewInkListItem(originName, itemName), r.varint())
+		}
+
+		if originCount := r.length(); originCount > 0 {
+			originNames := make([]string, originCount)
+			for i := range originNames {
+				originNames[i] = r.string()
+			}
+			rawList.SetInitialOriginNames(originNames)
+		}
+
+		return NewListValueFromList(rawList)
+
+	case binaryObjectControlCommand:
+		commandType := CommandType(r.varint())
+		if _, ok := controlCommandNames[commandType]; !ok {
+			panic(fmt.Errorf("unknown command type %d", commandType))
+		}
+		return NewControlCommand(commandType)
+
+	case binaryObjectGlue:
+		return NewGlue()
+
+	case binaryObjectVoid:
+		return NewVoid()
+
+	case binaryObjectTag:
+		return NewTag(r.string())
+
+	default:
+		panic(fmt.Errorf("unknown object kind %d", kind))
+	}
+}
+
+func (r *binarySaveReader) ReadCounts() map[string]int {
+
+	count := r.length()
+
+	counts := make(map[string]int, count)
+	for i := 0; i < count; i++ {
+		path := r.string()
+		counts[path] = r.varint()
+	}
+
+	return counts
+}
@@ -0,0 +1,553 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/SirMetathyst/go-ink/runtime/inkjet"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// binaryStoryIdentifier
+// The file_identifier from inkjet/story.fbs, stored at bytes 4-8 of
+// every binary story.
+const binaryStoryIdentifier = "INKB"
+
+// IsBinaryStory
+// Reports whether data starts like a story written by Story.WriteBinary.
+func IsBinaryStory(data []byte) bool {
+	return len(data) >= 8 && string(data[4:8]) == binaryStoryIdentifier
+}
+
+// WriteBinaryStory
+// Writes the whole story into builder, returning the offset of the root
+// inkjet.Story table.
+func WriteBinaryStory(builder *flatbuffers.Builder, story *Story) flatbuffers.UOffsetT {
+
+	root := WriteBinaryRuntimeContainer(builder, story._mainContentContainer, false)
+
+	var listDefs flatbuffers.UOffsetT
+	hasListDefs := story._listDefinitions != nil
+	if hasListDefs {
+		listDefs = WriteBinaryListDefinitions(builder, story._listDefinitions)
+	}
+
+	inkjet.StoryStart(builder)
+	inkjet.StoryAddInkVersion(builder, InkVersionCurrent)
+	inkjet.StoryAddRoot(builder, root)
+	if hasListDefs {
+		inkjet.StoryAddListDefinitions(builder, listDefs)
+	}
+
+	return inkjet.StoryEnd(builder)
+}
+
+// WriteBinaryRuntimeContainer
+// The binary equivalent of WriteRuntimeContainer. Named content is
+// sorted by name so that the output doesn't depend on map order.
+func WriteBinaryRuntimeContainer(builder *flatbuffers.Builder, container *Container, withoutName bool) flatbuffers.UOffsetT {
+
+	content := container.Content()
+	contentOffsets := make([]flatbuffers.UOffsetT, len(content))
+	for i, c := range content {
+		contentOffsets[i] = WriteBinaryRuntimeObject(builder, c)
+	}
+
+	namedOnlyContent := container.NamedOnlyContent()
+	names := make([]string, 0, len(namedOnlyContent))
+	for name := range namedOnlyContent {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	namedOffsets := make([]flatbuffers.UOffsetT, len(names))
+	for i, name := range names {
+
+		var object flatbuffers.UOffsetT
+		if namedContainer, _ := namedOnlyContent[name].(*Container); namedContainer != nil {
+			object = writeBinaryObjectTable(builder, inkjet.ObjectTypeContainer, WriteBinaryRuntimeContainer(builder, namedContainer, true))
+		} else {
+			object = WriteBinaryRuntimeObject(builder, namedOnlyContent[name])
+		}
+
+		nameOffset := builder.CreateString(name)
+
+		inkjet.NamedContentStart(builder)
+		inkjet.NamedContentAddName(builder, nameOffset)
+		inkjet.NamedContentAddObject(builder, object)
+		namedOffsets[i] = inkjet.NamedContentEnd(builder)
+	}
+
+	inkjet.ContainerStartContentVector(builder, len(contentOffsets))
+	contentVector := prependOffsets(builder, contentOffsets)
+
+	inkjet.ContainerStartNamedContentVector(builder, len(namedOffsets))
+	namedVector := prependOffsets(builder, namedOffsets)
+
+	var nameOffset flatbuffers.UOffsetT
+	hasName := container.Name() != "" && !withoutName
+	if hasName {
+		nameOffset = builder.CreateString(container.Name())
+	}
+
+	inkjet.ContainerStart(builder)
+	if hasName {
+		inkjet.ContainerAddName(builder, nameOffset)
+	}
+	inkjet.ContainerAddCountFlags(builder, int32(container.CountFlags()))
+	inkjet.ContainerAddContent(builder, contentVector)
+	inkjet.ContainerAddNamedContent(builder, namedVector)
+
+	return inkjet.ContainerEnd(builder)
+}
+
+// WriteBinaryRuntimeObject
+// The binary equivalent of WriteRuntimeObject.
+func WriteBinaryRuntimeObject(builder *flatbuffers.Builder, obj Object) flatbuffers.UOffsetT {
+
+	switch obj := obj.(type) {
+
+	case *Container:
+		return writeBinaryObjectTable(builder, inkjet.ObjectTypeContainer, WriteBinaryRuntimeContainer(builder, obj, false))
+
+	case *Divert:
+
+		divertType := inkjet.DivertTypeGoto
+		if obj.IsExternal {
+			divertType = inkjet.DivertTypeExternal
+		} else if obj.PushesToStack {
+			if obj.StackPushType == Function {
+				divertType = inkjet.DivertTypeFunction
+			} else if obj.StackPushType == Tunnel {
+				divertType = inkjet.DivertTypeTunnel
+			}
+		}
+
+		var flags inkjet.ObjectFlags
+		target := ""
+		if obj.HasVariableTarget() {
+			target = obj.VariableDivertName
+			flags |= inkjet.ObjectFlagsVariableTarget
+		} else {
+			target = obj.TargetPathString()
+		}
+
+		if obj.IsConditional {
+			flags |= inkjet.ObjectFlagsConditional
+		}
+
+		targetOffset := builder.CreateString(target)
+
+		inkjet.RuntimeObjectStart(builder)
+		inkjet.RuntimeObjectAddType(builder, inkjet.ObjectTypeDivert)
+		inkjet.RuntimeObjectAddStringValue(builder, targetOffset)
+		inkjet.RuntimeObjectAddDivertType(builder, divertType)
+		inkjet.RuntimeObjectAddFlags(builder, flags)
+		if obj.IsExternal {
+			inkjet.RuntimeObjectAddIntValue(builder, int32(obj.ExternalArgs))
+		}
+		return inkjet.RuntimeObjectEnd(builder)
+
+	case *ChoicePoint:
+		return writeBinaryStringObject(builder, inkjet.ObjectTypeChoicePoint, obj.PathStringOnChoice(), int32(obj.Flags()), 0)
+
+	case *BoolValue:
+		value := int32(0)
+		if obj.Value() {
+			value = 1
+		}
+		return writeBinaryIntObject(builder, inkjet.ObjectTypeBoolValue, value)
+
+	case *IntValue:
+		return writeBinaryIntObject(builder, inkjet.ObjectTypeIntValue, int32(obj.Value()))
+
+	case *FloatValue:
+		inkjet.RuntimeObjectStart(builder)
+		inkjet.RuntimeObjectAddType(builder, inkjet.ObjectTypeFloatValue)
+		inkjet.RuntimeObjectAddFloatValue(builder, obj.Value())
+		return inkjet.RuntimeObjectEnd(builder)
+
+	case *StringValue:
+		return writeBinaryStringObject(builder, inkjet.ObjectTypeStringValue, obj.Value(), 0, 0)
+
+	case *ListValue:
+		return writeBinaryListValue(builder, obj)
+
+	case *DivertTargetValue:
+		return writeBinaryStringObject(builder, inkjet.ObjectTypeDivertTargetValue, obj.Value().ComponentsString(), 0, 0)
+
+	case *VariablePointerValue:
+		return writeBinaryStringObject(builder, inkjet.ObjectTypeVariablePointerValue, obj.Value(), int32(obj.ContextIndex()), 0)
+
+	case *Glue:
+		return writeBinaryObjectTable(builder, inkjet.ObjectTypeGlue, 0)
+
+	case *ControlCommand:
+		return writeBinaryIntObject(builder, inkjet.ObjectTypeControlCommand, int32(obj.CommandType))
+
+	case *NativeFunctionCall:
+		return writeBinaryStringObject(builder, inkjet.ObjectTypeNativeFunctionCall, obj.Name(), 0, 0)
+
+	case *VariableReference:
+		if readCountPath, ok := obj.PathStringForCount(); ok {
+			return writeBinaryStringObject(builder, inkjet.ObjectTypeVariableReference, readCountPath, 0, inkjet.ObjectFlagsReadCount)
+		}
+		return writeBinaryStringObject(builder, inkjet.ObjectTypeVariableReference, obj.Name, 0, 0)
+
+	case *VariableAssignment:
+		var flags inkjet.ObjectFlags
+		if obj.IsGlobal {
+			flags |= inkjet.ObjectFlagsGlobal
+		}
+		if !obj.IsNewDeclaration() {
+			flags |= inkjet.ObjectFlagsReassignment
+		}
+		return writeBinaryStringObject(builder, inkjet.ObjectTypeVariableAssignment, obj.VariableName(), 0, flags)
+
+	case *Void:
+		return writeBinaryObjectTable(builder, inkjet.ObjectTypeVoid, 0)
+
+	case *Tag:
+		return writeBinaryStringObject(builder, inkjet.ObjectTypeTag, obj.Text(), 0, 0)
+	}
+
+	panic(fmt.Sprintf("Failed to write runtime object to binary: %v", obj))
+}
+
+// writeBinaryObjectTable
+// Writes a RuntimeObject with no values other than its type, and its
+// container if it has one.
+func writeBinaryObjectTable(builder *flatbuffers.Builder, objectType inkjet.ObjectType, container flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+
+	inkjet.RuntimeObjectStart(builder)
+	inkjet.RuntimeObjectAddType(builder, objectType)
+	if container != 0 {
+		inkjet.RuntimeObjectAddContainer(builder, container)
+	}
+
+	return inkjet.RuntimeObjectEnd(builder)
+}
+
+func writeBinaryIntObject(builder *flatbuffers.Builder, objectType inkjet.ObjectType, value int32) flatbuffers.UOffsetT {
+
+	inkjet.RuntimeObjectStart(builder)
+	inkjet.RuntimeObjectAddType(builder, objectType)
+	inkjet.RuntimeObjectAddIntValue(builder, value)
+
+	return inkjet.RuntimeObjectEnd(builder)
+}
+
+func writeBinaryStringObject(builder *flatbuffers.Builder, objectType inkjet.ObjectType, value string, intValue int32, flags inkjet.ObjectFlags) flatbuffers.UOffsetT {
+
+	valueOffset := builder.CreateString(value)
+
+	inkjet.RuntimeObjectStart(builder)
+	inkjet.RuntimeObjectAddType(builder, objectType)
+	inkjet.RuntimeObjectAddStringValue(builder, valueOffset)
+	inkjet.RuntimeObjectAddIntValue(builder, intValue)
+	inkjet.RuntimeObjectAddFlags(builder, flags)
+
+	return inkjet.RuntimeObjectEnd(builder)
+}
+
+func writeBinaryListValue(builder *flatbuffers.Builder, listVal *ListValue) flatbuffers.UOffsetT {
+
+	rawList := listVal.Value()
+
+	orderedItems := rawList.OrderedItems()
+	itemOffsets := make([]flatbuffers.UOffsetT, len(orderedItems))
+	for i, item := range orderedItems {
+
+		// As in WriteInkList
+		originName := item.Key.OriginName()
+		if originName == "" {
+			originName = "?"
+		}
+
+		itemOffsets[i] = writeBinaryListItem(builder, originName, item.Key.ItemName(), item.Value)
+	}
+
+	inkjet.RuntimeObjectStartListItemsVector(builder, len(itemOffsets))
+	itemsVector := prependOffsets(builder, itemOffsets)
+
+	var originsVector flatbuffers.UOffsetT
+	hasOrigins := rawList.Count() == 0 && len(rawList.OriginNames()) > 0
+	if hasOrigins {
+
+		originNames := rawList.OriginNames()
+		originOffsets := make([]flatbuffers.UOffsetT, len(originNames))
+		for i, name := range originNames {
+			originOffsets[i] = builder.CreateString(name)
+		}
+
+		inkjet.RuntimeObjectStartListOriginsVector(builder, len(originOffsets))
+		originsVector = prependOffsets(builder, originOffsets)
+	}
+
+	inkjet.RuntimeObjectStart(builder)
+	inkjet.RuntimeObjectAddType(builder, inkjet.ObjectTypeListValue)
+	inkjet.RuntimeObjectAddListItems(builder, itemsVector)
+	if hasOrigins {
+		inkjet.RuntimeObjectAddListOrigins(builder, originsVector)
+	}
+
+	return inkjet.RuntimeObjectEnd(builder)
+}
+
+func writeBinaryListItem(builder *flatbuffers.Builder, originName string, itemName string, value int) flatbuffers.UOffsetT {
+
+	var originOffset flatbuffers.UOffsetT
+	if originName != "" {
+		originOffset = builder.CreateString(originName)
+	}
+	nameOffset := builder.CreateString(itemName)
+
+	inkjet.ListItemStart(builder)
+	if originName != "" {
+		inkjet.ListItemAddOrigin(builder, originOffset)
+	}
+	inkjet.ListItemAddName(builder, nameOffset)
+	inkjet.ListItemAddValue(builder, int32(value))
+
+	return inkjet.ListItemEnd(builder)
+}
+
+// WriteBinaryListDefinitions
+// Writes the list definitions sorted by name, and their items by value.
+func WriteBinaryListDefinitions(builder *flatbuffers.Builder, origin *ListDefinitionsOrigin) flatbuffers.UOffsetT {
+
+	lists := origin.OrderedLists()
+
+	defOffsets := make([]flatbuffers.UOffsetT, len(lists))
+	for i, def := range lists {
+
+		items := def.OrderedItems()
+
+		itemOffsets := make([]flatbuffers.UOffsetT, len(items))
+		for j, item := range items {
+			itemOffsets[j] = writeBinaryListItem(builder, "", item.Key.ItemName(), item.Value)
+		}
+
+		inkjet.ListDefinitionStartItemsVector(builder, len(itemOffsets))
+		itemsVector := prependOffsets(builder, itemOffsets)
+
+		nameOffset := builder.CreateString(def.Name())
+
+		inkjet.ListDefinitionStart(builder)
+		inkjet.ListDefinitionAddName(builder, nameOffset)
+		inkjet.ListDefinitionAddItems(builder, itemsVector)
+		defOffsets[i] = inkjet.ListDefinitionEnd(builder)
+	}
+
+	inkjet.StoryStartListDefinitionsVector(builder, len(defOffsets))
+	return prependOffsets(builder, defOffsets)
+}
+
+// prependOffsets
+// Fills a vector that has just been started, and ends it.
+func prependOffsets(builder *flatbuffers.Builder, offsets []flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+
+	// Vectors are built back to front
+	for i := len(offsets) - 1; i >= 0; i-- {
+		builder.PrependUOffsetT(offsets[i])
+	}
+
+	return builder.EndVector(len(offsets))
+}
+
+// BinaryToRuntimeObject
+// The binary equivalent of JTokenToRuntimeObject. The flatbuffers accessors trust
+// the data, so it must have been checked as NewStoryFromBinary does.
+func BinaryToRuntimeObject(obj *inkjet.RuntimeObject) Object {
+
+	switch obj.Type() {
+
+	case inkjet.ObjectTypeContainer:
+		container := obj.Container(nil)
+		if container == nil {
+			panic("Container object has no container")
+		}
+		return BinaryToContainer(container)
+
+	case inkjet.ObjectTypeDivert:
+
+		divert := NewDivert()
+
+		switch obj.DivertType() {
+		case inkjet.DivertTypeFunction:
+			divert.PushesToStack = true
+			divert.StackPushType = Function
+		case inkjet.DivertTypeTunnel:
+			divert.PushesToStack = true
+			divert.StackPushType = Tunnel
+		case inkjet.DivertTypeExternal:
+			divert.IsExternal = true
+			divert.StackPushType = Function
+			divert.ExternalArgs = int(obj.IntValue())
+		}
+
+		target := string(obj.StringValue())
+		if obj.Flags()&inkjet.ObjectFlagsVariableTarget != 0 {
+			divert.VariableDivertName = target
+		} else {
+			divert.SetTargetPathString(target)
+		}
+
+		divert.IsConditional = obj.Flags()&inkjet.ObjectFlagsConditional != 0
+
+		return divert
+
+	case inkjet.ObjectTypeControlCommand:
+		commandType := CommandType(obj.IntValue())
+		if _, ok := controlCommandNames[commandType]; !ok {
+			panic(fmt.Sprintf("unknown command type %d", commandType))
+		}
+		return NewControlCommand(commandType)
+
+	case inkjet.ObjectTypeNativeFunctionCall:
+		name := string(obj.StringValue())
+		if !CallExistsWithName(name) {
+			panic("unknown native function " + name)
+		}
+		return NewNativeFunctionCallFromName(name)
+
+	case inkjet.ObjectTypeBoolValue:
+		return CreateValue(obj.IntValue() != 0)
+
+	case inkjet.ObjectTypeIntValue:
+		return CreateValue(int(obj.IntValue()))
+
+	case inkjet.ObjectTypeFloatValue:
+		return CreateValue(obj.FloatValue())
+
+	case inkjet.ObjectTypeStringValue:
+		return NewStringValueFromString(string(obj.StringValue()))
+
+	case inkjet.ObjectTypeDivertTargetValue:
+		return NewDivertTargetValueFromPath(NewPathFromString(string(obj.StringValue())))
+
+	case inkjet.ObjectTypeVariablePointerValue:
+		return NewVariablePointerValueFromValue(string(obj.StringValue()), int(obj.IntValue()))
+
+	case inkjet.ObjectTypeListValue:
+
+		rawList := NewInkList()
+
+		if originsLength := obj.ListOriginsLength(); originsLength > 0 {
+			originNames := make([]string, originsLength)
+			for i := range originNames {
+				originNames[i] = string(obj.ListOrigins(i))
+			}
+			rawList.SetInitialOriginNames(originNames)
+		}
+
+		item := new(inkjet.ListItem)
+		for i := 0; i < obj.ListItemsLength(); i++ {
+			obj.ListItems(item, i)
+			rawList.Add(NewInkListItem(string(item.Origin()), string(item.Name())), int(item.Value()))
+		}
+
+		return NewListValueFromList(rawList)
+
+	case inkjet.ObjectTypeChoicePoint:
+		choice := NewChoicePoint()
+		choice.SetPathStringOnChoice(string(obj.StringValue()))
+		choice.SetFlags(int(obj.IntValue()))
+		return choice
+
+	case inkjet.ObjectTypeTag:
+		return NewTag(string(obj.StringValue()))
+
+	case inkjet.ObjectTypeVariableAssignment:
+		varAss := NewVariableAssignment(string(obj.StringValue()), obj.Flags()&inkjet.ObjectFlagsReassignment == 0)
+		varAss.IsGlobal = obj.Flags()&inkjet.ObjectFlagsGlobal != 0
+		return varAss
+
+	case inkjet.ObjectTypeVariableReference:
+		if obj.Flags()&inkjet.ObjectFlagsReadCount != 0 {
+			readCountVarRef := NewVariableReference()
+			readCountVarRef.SetPathStringForCount(string(obj.StringValue()))
+			return readCountVarRef
+		}
+		return NewVariableReferenceFromName(string(obj.StringValue()))
+
+	case inkjet.ObjectTypeGlue:
+		return NewGlue()
+
+	case inkjet.ObjectTypeVoid:
+		return NewVoid()
+	}
+
+	panic(fmt.Sprintf("Failed to convert binary object to runtime object: %v", obj.Type()))
+}
+
+// BinaryToContainer
+// The binary equivalent of JArrayToContainer. The flatbuffers accessors trust
+// the data, so it must have been checked as NewStoryFromBinary does.
+func BinaryToContainer(binaryContainer *inkjet.Container) *Container {
+
+	container := NewContainer()
+
+	obj := new(inkjet.RuntimeObject)
+	for i := 0; i < binaryContainer.ContentLength(); i++ {
+		binaryContainer.Content(obj, i)
+		container.AddContent(BinaryToRuntimeObject(obj))
+	}
+
+	if namedLength := binaryContainer.NamedContentLength(); namedLength > 0 {
+
+		namedOnlyContent := make(map[string]Object, namedLength)
+
+		named := new(inkjet.NamedContent)
+		for i := 0; i < namedLength; i++ {
+			binaryContainer.NamedContent(named, i)
+
+			name := string(named.Name())
+			namedObj := named.Object(nil)
+			if namedObj == nil {
+				panic("Named content '" + name + "' has no object")
+			}
+
+			namedContentItem := BinaryToRuntimeObject(namedObj)
+			if namedSubContainer, _ := namedContentItem.(*Container); namedSubContainer != nil {
+				namedSubContainer.SetName(name)
+			}
+			namedOnlyContent[name] = namedContentItem
+		}
+
+		container.SetNamedOnlyContent(namedOnlyContent)
+	}
+
+	if countFlags := binaryContainer.CountFlags(); countFlags != 0 {
+		container.SetCountFlags(int(countFlags))
+	}
+
+	if name := binaryContainer.Name(); name != nil {
+		container.SetName(string(name))
+	}
+
+	return container
+}
+
+// BinaryToListDefinitions
+// The binary equivalent of JTokenToListDefinitions.
+func BinaryToListDefinitions(story *inkjet.Story) *ListDefinitionsOrigin {
+
+	allDefs := []*ListDefinition{}
+
+	def := new(inkjet.ListDefinition)
+	item := new(inkjet.ListItem)
+	for i := 0; i < story.ListDefinitionsLength(); i++ {
+		story.ListDefinitions(def, i)
+
+		items := make(map[string]int, def.ItemsLength())
+		for j := 0; j < def.ItemsLength(); j++ {
+			def.Items(item, j)
+			items[string(item.Name())] = int(item.Value())
+		}
+
+		allDefs = append(allDefs, NewListDefinition(string(def.Name()), items))
+	}
+
+	return NewListDefinitionsOrigin(allDefs)
+}
@@ -0,0 +1,212 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SirMetathyst/go-ink/runtime/inkjet"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// binaryRoundTrip
+// Writes story in the binary format and loads it back.
+func binaryRoundTrip(t testing.TB, story *Story) *Story {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, story.WriteBinary(&buf))
+
+	loaded, err := LoadStoryBinary(&buf)
+	require.NoError(t, err)
+
+	return loaded
+}
+
+// jsonRoundTrip
+// Writes story as JSON and loads it back. Relative divert paths can
+// change on the first round trip (".^" resolves to ".^.0", the first
+// element of the container), so that's what binaryRoundTrip is compared
+// against.
+func jsonRoundTrip(t testing.TB, story *Story) *Story {
+	t.Helper()
+
+	loaded, err := NewStory(story.ToJson())
+	require.NoError(t, err)
+
+	return loaded
+}
+
+// binaryTestStoryPaths
+// TheIntercept and the conformance stories, which between them
+// use every kind of runtime object.
+func binaryTestStoryPaths(t testing.TB) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(conformanceDir, "*.ink.json"))
+	require.NoError(t, err)
+
+	return append(paths, theInterceptPath)
+}
+
+func TestBinaryRoundTripMatchesJson(t *testing.T) {
+
+	for _, path := range binaryTestStoryPaths(t) {
+
+		story := newTestStoryFromFile(t, path)
+		loaded := binaryRoundTrip(t, story)
+
+		// JSON object key order isn't stable, so compare the parsed forms
+		expected, err := TextToDictionary(jsonRoundTrip(t, story).ToJson())
+		require.NoError(t, err, path)
+		actual, err := TextToDictionary(loaded.ToJson())
+		require.NoError(t, err, path)
+
+		assert.Equal(t, expected, actual, path)
+	}
+}
+
+func TestBinaryIsDeterministic(t *testing.T) {
+
+	for _, path := range binaryTestStoryPaths(t) {
+
+		var first, second, fromBinary, fromJson bytes.Buffer
+
+		story := newTestStoryFromFile(t, path)
+		require.NoError(t, story.WriteBinary(&first))
+		require.NoError(t, newTestStoryFromFile(t, path).WriteBinary(&second))
+
+		assert.True(t, IsBinaryStory(first.Bytes()), path)
+		assert.Equal(t, first.Bytes(), second.Bytes(), path)
+
+		require.NoError(t, binaryRoundTrip(t, story).WriteBinary(&fromBinary))
+		require.NoError(t, jsonRoundTrip(t, story).WriteBinary(&fromJson))
+		assert.Equal(t, fromJson.Bytes(), fromBinary.Bytes(), path)
+	}
+}
+
+func TestBinaryPlaysTheIntercept(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	loaded := binaryRoundTrip(t, story)
+
+	for turn := 0; turn < 10; turn++ {
+		expected, err := story.ContinueMaximally()
+		require.NoError(t, err)
+		text, err := loaded.ContinueMaximally()
+		require.NoError(t, err)
+		require.Equal(t, expected, text)
+
+		require.Equal(t, len(story.CurrentChoices()), len(loaded.CurrentChoices()))
+		if len(story.CurrentChoices()) == 0 {
+			break
+		}
+
+		// Vary the choices a little so that more of the story is covered
+		index := turn % len(story.CurrentChoices())
+		require.NoError(t, story.ChooseChoiceIndex(index))
+		require.NoError(t, loaded.ChooseChoiceIndex(index))
+	}
+}
+
+func TestLoadStoryBinaryInvalid(t *testing.T) {
+
+	var buf bytes.Buffer
+	require.NoError(t, newTestStory(t, taggedStoryJson).WriteBinary(&buf))
+	valid := buf.Bytes()
+
+	// patched
+	// A copy of valid with a little-endian uint32 written at pos.
+	patched := func(pos flatbuffers.UOffsetT, value uint32) []byte {
+		data := append([]byte(nil), valid...)
+		binary.LittleEndian.PutUint32(data[pos:], value)
+		return data
+	}
+
+	root := inkjet.GetRootAsStory(valid, 0).Root(nil).Table()
+	namedContent := root.Vector(flatbuffers.UOffsetT(root.Offset(10)))
+
+	tests := map[string][]byte{
+		"empty":     nil,
+		"json":      []byte(taggedStoryJson),
+		"truncated": valid[:len(valid)/2],
+
+		"root offset past end": patched(0, math.MaxUint32),
+		"huge vector length":   patched(namedContent-4, math.MaxUint32),
+		"vector past end":      patched(namedContent-4, uint32(len(valid))/4),
+		"element past end":     patched(namedContent, uint32(len(valid))),
+	}
+
+	for name, data := range tests {
+		_, err := NewStoryFromBinary(data)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadStoryBinaryNestedTooDeep(t *testing.T) {
+
+	var buf bytes.Buffer
+	require.NoError(t, newTestStory(t, taggedStoryJson).WriteBinary(&buf))
+
+	defer func(depth int) { MaxBinaryContainerDepth = depth }(MaxBinaryContainerDepth)
+
+	// The stitch's lines are in the stitch, in the knot, in the root
+	MaxBinaryContainerDepth = 4
+	_, err := NewStoryFromBinary(buf.Bytes())
+	require.NoError(t, err)
+
+	MaxBinaryContainerDepth = 3
+	_, err = NewStoryFromBinary(buf.Bytes())
+	assert.ErrorContains(t, err, "containers nested more than 3 deep")
+}
+
+// FuzzNewStoryFromBinary
+// As FuzzNewStory, for binary stories.
+//
+//	go test -run '^$' -fuzz FuzzNewStoryFromBinary ./runtime
+func FuzzNewStoryFromBinary(f *testing.F) {
+
+	for _, path := range []string{"testdata/conformance/glue.ink.json", "testdata/conformance/lists.ink.json", "testdata/conformance/tunnels.ink.json"} {
+		var buf bytes.Buffer
+		require.NoError(f, newTestStoryFromFile(f, path).WriteBinary(&buf))
+		f.Add(buf.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = NewStoryFromBinary(data)
+	})
+}
+
+// BenchmarkLoadStoryBinary
+// Compare with BenchmarkLoadStory, which loads the same story from JSON.
+func BenchmarkLoadStoryBinary(b *testing.B) {
+
+	var buf bytes.Buffer
+	if err := newTestStoryFromFile(b, theInterceptPath).WriteBinary(&buf); err != nil {
+		b.Fatal(err)
+	}
+
+	path := filepath.Join(b.TempDir(), "TheIntercept.inkb")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = LoadStoryBinary(f)
+		f.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
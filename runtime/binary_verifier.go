@@ -0,0 +1,252 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// MaxBinaryContainerDepth
+// How deeply containers can be nested in a binary story, so that a
+// malformed one can't exhaust the stack. As with MaxJsonDepth, compiled
+// stories come nowhere near it.
+var MaxBinaryContainerDepth = 500
+
+// binaryVerifier
+// Checks that every offset and length in a binary story stays within the
+// data before any of it is read. The flatbuffers accessors trust the
+// data, so without this a corrupt story could make the loader read out of
+// bounds, or allocate for a length the data doesn't hold.
+type binaryVerifier struct {
+	data []byte
+
+	// The tables and strings left to visit. Each takes at least 4 bytes,
+	// so a story can't hold more than len(data)/4, however many vectors
+	// point at the same ones.
+	remaining int
+}
+
+// binaryTable
+// A table whose vtable has been checked.
+type binaryTable struct {
+	pos        int
+	vtable     int
+	vtableSize int
+	size       int
+}
+
+// verifyBinaryStory
+// Checks data against the layout in inkjet/story.fbs, panicking on the
+// first problem found.
+func verifyBinaryStory(data []byte) {
+
+	s := &binaryVerifier{data: data, remaining: len(data)/flatbuffers.SizeUOffsetT + 1}
+
+	story := s.table(s.indirect(0))
+	s.scalar(story, 4, flatbuffers.SizeInt32)
+
+	if root, ok := s.subTable(story, 6); ok {
+		s.container(root, 1)
+	}
+
+	s.tables(story, 8, func(def binaryTable) {
+		s.string(def, 4)
+		s.tables(def, 6, s.listItem)
+	})
+}
+
+func (s *binaryVerifier) container(t binaryTable, depth int) {
+
+	if depth > MaxBinaryContainerDepth {
+		panic(fmt.Sprintf("containers nested more than %d deep", MaxBinaryContainerDepth))
+	}
+
+	s.string(t, 4)
+	s.scalar(t, 6, flatbuffers.SizeInt32)
+
+	s.tables(t, 8, func(obj binaryTable) {
+		s.runtimeObject(obj, depth)
+	})
+
+	s.tables(t, 10, func(named binaryTable) {
+		s.string(named, 4)
+		if obj, ok := s.subTable(named, 6); ok {
+			s.runtimeObject(obj, depth)
+		}
+	})
+}
+
+func (s *binaryVerifier) runtimeObject(t binaryTable, depth int) {
+
+	s.scalar(t, 4, flatbuffers.SizeByte)
+	s.scalar(t, 6, flatbuffers.SizeInt32)
+	s.scalar(t, 8, flatbuffers.SizeFloat64)
+	s.string(t, 10)
+	s.scalar(t, 12, flatbuffers.SizeByte)
+	s.scalar(t, 14, flatbuffers.SizeByte)
+	s.tables(t, 16, s.listItem)
+
+	if start, length, ok := s.vector(t, 18, flatbuffers.SizeUOffsetT); ok {
+		for i := 0; i < length; i++ {
+			s.stringAt(s.indirect(start + i*flatbuffers.SizeUOffsetT))
+		}
+	}
+
+	if container, ok := s.subTable(t, 20); ok {
+		s.container(container, depth+1)
+	}
+}
+
+func (s *binaryVerifier) listItem(t binaryTable) {
+
+	s.string(t, 4)
+	s.string(t, 6)
+	s.scalar(t, 8, flatbuffers.SizeInt32)
+}
+
+// check
+// Panics unless size bytes from pos are within the data.
+func (s *binaryVerifier) check(pos int, size int) {
+
+	if pos < 0 || size < 0 || pos > len(s.data) || size > len(s.data)-pos {
+		panic(fmt.Sprintf("%d bytes at offset %d are past the end of the data (%d bytes)", size, pos, len(s.data)))
+	}
+}
+
+// indirect
+// Follows the offset stored at pos.
+func (s *binaryVerifier) indirect(pos int) int {
+
+	s.check(pos, flatbuffers.SizeUOffsetT)
+
+	offset := binary.LittleEndian.Uint32(s.data[pos:])
+	if uint64(offset) > uint64(len(s.data)) {
+		panic(fmt.Sprintf("offset %d at %d is past the end of the data (%d bytes)", offset, pos, len(s.data)))
+	}
+
+	return pos + int(offset)
+}
+
+// visit
+// Counts off a table or string against the ones the data can hold.
+func (s *binaryVerifier) visit() {
+
+	s.remaining--
+	if s.remaining < 0 {
+		panic("more tables and strings than the data can hold")
+	}
+}
+
+func (s *binaryVerifier) table(pos int) binaryTable {
+
+	s.visit()
+	s.check(pos, flatbuffers.SizeSOffsetT)
+
+	t := binaryTable{pos: pos}
+	t.vtable = pos - int(int32(binary.LittleEndian.Uint32(s.data[pos:])))
+
+	s.check(t.vtable, 2*flatbuffers.SizeVOffsetT)
+	t.vtableSize = int(binary.LittleEndian.Uint16(s.data[t.vtable:]))
+	t.size = int(binary.LittleEndian.Uint16(s.data[t.vtable+flatbuffers.SizeVOffsetT:]))
+
+	if t.vtableSize < 2*flatbuffers.SizeVOffsetT || t.vtableSize%flatbuffers.SizeVOffsetT != 0 {
+		panic(fmt.Sprintf("invalid vtable size %d for table at %d", t.vtableSize, pos))
+	}
+	s.check(t.vtable, t.vtableSize)
+
+	if t.size < flatbuffers.SizeSOffsetT {
+		panic(fmt.Sprintf("invalid size %d for table at %d", t.size, pos))
+	}
+	s.check(pos, t.size)
+
+	return t
+}
+
+// field
+// Returns the position of the field in vtable slot, if it's present.
+func (s *binaryVerifier) field(t binaryTable, slot int, size int) (int, bool) {
+
+	if slot >= t.vtableSize {
+		return 0, false
+	}
+
+	offset := int(binary.LittleEndian.Uint16(s.data[t.vtable+slot:]))
+	if offset == 0 {
+		return 0, false
+	}
+
+	if offset > t.size-size {
+		panic(fmt.Sprintf("field %d of table at %d is past the end of the table", slot, t.pos))
+	}
+
+	return t.pos + offset, true
+}
+
+func (s *binaryVerifier) scalar(t binaryTable, slot int, size int) {
+	s.field(t, slot, size)
+}
+
+func (s *binaryVerifier) subTable(t binaryTable, slot int) (binaryTable, bool) {
+
+	pos, ok := s.field(t, slot, flatbuffers.SizeUOffsetT)
+	if !ok {
+		return binaryTable{}, false
+	}
+
+	return s.table(s.indirect(pos)), true
+}
+
+// vector
+// Returns where the elements of a vector field start and how many there
+// are, once they're known to be within the data.
+func (s *binaryVerifier) vector(t binaryTable, slot int, elementSize int) (start int, length int, ok bool) {
+
+	pos, ok := s.field(t, slot, flatbuffers.SizeUOffsetT)
+	if !ok {
+		return 0, 0, false
+	}
+
+	start, length = s.vectorAt(s.indirect(pos), elementSize)
+	return start, length, true
+}
+
+func (s *binaryVerifier) vectorAt(pos int, elementSize int) (start int, length int) {
+
+	s.check(pos, flatbuffers.SizeUOffsetT)
+	start = pos + flatbuffers.SizeUOffsetT
+
+	count := binary.LittleEndian.Uint32(s.data[pos:])
+	if uint64(count) > uint64((len(s.data)-start)/elementSize) {
+		panic(fmt.Sprintf("vector at %d has %d elements, more than the data holds", pos, count))
+	}
+
+	return start, int(count)
+}
+
+// tables
+// Verifies each table in a vector field with verify.
+func (s *binaryVerifier) tables(t binaryTable, slot int, verify func(t binaryTable)) {
+
+	start, length, ok := s.vector(t, slot, flatbuffers.SizeUOffsetT)
+	if !ok {
+		return
+	}
+
+	for i := 0; i < length; i++ {
+		verify(s.table(s.indirect(start + i*flatbuffers.SizeUOffsetT)))
+	}
+}
+
+func (s *binaryVerifier) string(t binaryTable, slot int) {
+
+	if pos, ok := s.field(t, slot, flatbuffers.SizeUOffsetT); ok {
+		s.stringAt(s.indirect(pos))
+	}
+}
+
+func (s *binaryVerifier) stringAt(pos int) {
+
+	s.visit()
+	s.vectorAt(pos, 1)
+}
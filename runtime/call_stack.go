@@ -30,6 +30,13 @@ func (s *CallStack) CurrentElement() *Element {
 	return cs[len(cs)-1]
 }
 
+// Threads
+// Every thread on the stack, oldest first. The last is the current thread.
+func (s *CallStack) Threads() []*Thread {
+
+	return s._threads
+}
+
 func (s *CallStack) CurrentThread() *Thread {
 
 	return s._threads[len(s._threads)-1]
@@ -95,7 +102,22 @@ func (s *CallStack) SetJsonToken(jObject map[string]interface{}, storyContext *S
 	s._startOfRoot = StartOfPointer(RootContentContainer(storyContext))
 }
 
-// TODO: CallStack.WriteJson
+func (s *CallStack) WriteJson(writer *Writer) {
+
+	writer.WriteObjectStart()
+
+	writer.WritePropertyStart("threads")
+	writer.WriteArrayStart()
+	for _, thread := range s._threads {
+		thread.WriteJson(writer)
+	}
+	writer.WriteArrayEnd()
+	writer.WritePropertyEnd()
+
+	writer.WriteIntProperty("threadCounter", s._threadCounter)
+
+	writer.WriteObjectEnd()
+}
 
 func (s *CallStack) PushThread() {
 
@@ -190,7 +212,7 @@ func (s *CallStack) SetTemporaryVariable(name string, value Object, declareNew b
 	contextElement := s.Elements()[contextIndex-1]
 
 	if _, ok := contextElement.TemporaryVariables[name]; ok == false && declareNew == false {
-		panic("Could not find temporary variable to set: " + name)
+		panic(NewStoryException("Could not find temporary variable to set: " + name))
 	}
 
 	if oldValue, ok := contextElement.TemporaryVariables[name]; ok {
@@ -0,0 +1,190 @@
+package runtime
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SirMetathyst/go-ink/internal/conformance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+const conformanceDir = "testdata/conformance"
+
+// conformanceSetups
+// Game-side setup for fixtures that need it, keyed by fixture name. It's
+// run again whenever the story is reloaded.
+var conformanceSetups = map[string]func(story *Story) error{
+	"externals": func(story *Story) error {
+		story.AllowExternalFunctionFallbacks = true
+
+		if err := BindExternalFunction2(story, "multiply", func(a, b int) int { return a * b }, true); err != nil {
+			return err
+		}
+
+		return story.BindExternals(&struct {
+			Greeting func() string
+		}{
+			Greeting: func() string { return "Hello from Go" },
+		})
+	},
+}
+
+// TestConformance
+// Plays each compiled story in testdata/conformance, following the commands
+// in its .script file if there is one (see conformance.Transcript.Exec),
+// and compares the transcript with its .golden file. Run with -update to
+// regenerate the golden files.
+//
+// Each story is played twice: once as loaded from JSON, and once after
+// converting it to the binary format.
+func TestConformance(t *testing.T) {
+
+	paths, err := filepath.Glob(filepath.Join(conformanceDir, "*.ink.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".ink.json")
+
+		t.Run(name, func(t *testing.T) {
+
+			b, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			commands, err := conformance.ReadScript(filepath.Join(conformanceDir, name+".script"))
+			require.NoError(t, err)
+
+			goldenPath := filepath.Join(conformanceDir, name+".golden")
+
+			// Stories loaded from the binary format must play identically
+			for _, binary := range []bool{false, true} {
+
+				run := &conformanceRun{t: t, storyJson: string(b), binary: binary, setup: conformanceSetups[name]}
+				run.story = run.newStory()
+
+				transcript := &conformance.Transcript{Story: run, Errors: run.errors}
+				transcript.Play()
+				for _, command := range commands {
+					require.NoError(t, transcript.Exec(command))
+				}
+
+				if *update && !binary {
+					require.NoError(t, os.WriteFile(goldenPath, []byte(transcript.String()), 0644))
+					continue
+				}
+
+				golden, err := os.ReadFile(goldenPath)
+				require.NoError(t, err, "run with -update to create the golden file")
+				assert.Equal(t, string(golden), transcript.String(), "binary: %v", binary)
+			}
+		})
+	}
+}
+
+// conformanceRun
+// Plays a conformance story for a conformance.Transcript.
+type conformanceRun struct {
+	t         *testing.T
+	storyJson string
+	binary    bool
+	setup     func(story *Story) error
+	story     *Story
+
+	// Ink errors are reported during Continue, but are written
+	// after the line that caused them
+	pendingErrors []string
+}
+
+func (s *conformanceRun) newStory() *Story {
+
+	story := newTestStory(s.t, s.storyJson)
+	if s.binary {
+		story = binaryRoundTrip(s.t, story)
+	}
+
+	// Fixed so that shuffles and RANDOM are repeatable
+	story.State().StorySeed = 0
+
+	story.OnError = new(ErrorHandlerEvent)
+	story.OnError.Register(func(message string, typ ErrorType) {
+		kind := "error"
+		if typ == ErrorTypeWarning {
+			kind = "warning"
+		}
+		s.pendingErrors = append(s.pendingErrors, kind+": "+message)
+	})
+
+	if s.setup != nil {
+		require.NoError(s.t, s.setup(story))
+	}
+
+	return story
+}
+
+func (s *conformanceRun) errors() []string {
+
+	errs := s.pendingErrors
+	s.pendingErrors = nil
+
+	return errs
+}
+
+func (s *conformanceRun) CanContinue() bool {
+	return s.story.CanContinue()
+}
+
+func (s *conformanceRun) Continue() (string, error) {
+	return s.story.Continue()
+}
+
+func (s *conformanceRun) CurrentTags() []string {
+	return s.story.CurrentTags()
+}
+
+func (s *conformanceRun) CurrentChoices() []conformance.Choice {
+
+	var choices []conformance.Choice
+	for _, choice := range s.story.CurrentChoices() {
+		choices = append(choices, conformance.Choice{Index: choice.Index, Text: choice.Text, Tags: choice.Tags})
+	}
+
+	return choices
+}
+
+func (s *conformanceRun) ChooseChoiceIndex(index int) error {
+	return s.story.ChooseChoiceIndex(index)
+}
+
+func (s *conformanceRun) ChoosePathString(path string) error {
+	return s.story.ChoosePathString(path, true)
+}
+
+func (s *conformanceRun) SwitchFlow(flowName string) error {
+	return s.story.SwitchFlow(flowName)
+}
+
+func (s *conformanceRun) SwitchToDefaultFlow() error {
+	return s.story.SwitchToDefaultFlow()
+}
+
+func (s *conformanceRun) RemoveFlow(flowName string) error {
+	return s.story.RemoveFlow(flowName)
+}
+
+func (s *conformanceRun) Reload() error {
+
+	saved, err := s.story.State().ToJson()
+	if err != nil {
+		return err
+	}
+
+	s.story = s.newStory()
+
+	return s.story.State().LoadJson(saved)
+}
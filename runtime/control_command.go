@@ -36,6 +36,48 @@ const (
 	CommandTypeTotalValues
 )
 
+var commandTypeNames = [...]string{
+	"EvalStart",
+	"EvalOutput",
+	"EvalEnd",
+	"Duplicate",
+	"PopEvaluatedValue",
+	"PopFunction",
+	"PopTunnel",
+	"BeginString",
+	"EndString",
+	"NoOp",
+	"ChoiceCount",
+	"Turns",
+	"TurnsSince",
+	"ReadCount",
+	"Random",
+	"SeedRandom",
+	"VisitIndex",
+	"SequenceShuffleIndex",
+	"StartThread",
+	"Done",
+	"End",
+	"ListFromInt",
+	"ListRange",
+	"ListRandom",
+	"BeginTag",
+	"EndTag",
+}
+
+func (s CommandType) String() string {
+
+	if s >= 0 && int(s) < len(commandTypeNames) {
+		return commandTypeNames[s]
+	}
+
+	if s == CommandTypeNotSet {
+		return "NotSet"
+	}
+
+	return fmt.Sprintf("CommandType(%d)", int(s))
+}
+
 type ControlCommand struct {
 	ObjectImpl
 
@@ -25,7 +25,17 @@ func (s *Divert) TargetPath() *Path {
 
 	if s._targetPath != nil && s._targetPath.IsRelative() {
 
-		targetObj := s.TargetPointer().Resolve()
+		// The reference engine resolves the pointer to a container to its
+		// first element, turning ".^.b" into ".^.b.0". Resolving to the
+		// container itself is the same target, and means the path is
+		// written out as it was compiled.
+		var targetObj Object
+		if targetPointer := s.TargetPointer(); s._targetPath.LastComponent().IsIndex() {
+			targetObj = targetPointer.Resolve()
+		} else if targetPointer.Container != nil {
+			targetObj = targetPointer.Container
+		}
+
 		if targetObj != nil {
 			s._targetPath = targetObj.Path(targetObj)
 		}
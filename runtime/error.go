@@ -1,5 +1,11 @@
 package runtime
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
 type ErrorHandler func(message string, typ ErrorType)
 
 type ErrorHandlerEvent struct {
@@ -19,3 +25,202 @@ const (
 	ErrorTypeWarning
 	ErrorTypeError
 )
+
+func (s ErrorType) String() string {
+	switch s {
+	case ErrorTypeAuthor:
+		return "AUTHOR"
+	case ErrorTypeWarning:
+		return "WARNING"
+	default:
+		return "ERROR"
+	}
+}
+
+var (
+	// ErrCannotContinue
+	// Returned by Continue when the story has no more content to evaluate.
+	// Check CanContinue before calling Continue.
+	ErrCannotContinue = errors.New("can't continue - should check CanContinue before calling Continue")
+
+	// ErrIncompatibleInkVersion
+	// Returned by NewStory when the story JSON was built with a version of
+	// ink that this engine can't load.
+	ErrIncompatibleInkVersion = errors.New("incompatible ink version")
+
+	// ErrSaveFormatIncompatible
+	// Returned by StoryState.LoadJson and LoadBinary when the save data is
+	// missing its version or was written by an engine that is too old, and
+	// there's no registered migration to upgrade it.
+	ErrSaveFormatIncompatible = errors.New("ink save format incompatible")
+
+	// ErrAsyncContinueActive
+	// Returned when an operation is attempted while a ContinueAsync call
+	// hasn't yet completed.
+	ErrAsyncContinueActive = errors.New("story is in the middle of a ContinueAsync()")
+
+	// ErrBackgroundSaveActive
+	// Returned when an operation is attempted while the story is in
+	// background saving mode (see CopyStateForBackgroundThreadSave).
+	ErrBackgroundSaveActive = errors.New("story is in background saving mode")
+
+	// ErrConcurrentContinue
+	// Returned when a Story is continued, has a choice or path chosen, a
+	// function evaluated, its flow switched, its state saved or loaded,
+	// its variables set, or its externals or observers changed while
+	// another of those calls on it hasn't returned yet, e.g. from another
+	// goroutine.
+	ErrConcurrentContinue = errors.New("story is already in use; a Story must not be used from multiple goroutines at once")
+)
+
+// StoryException
+// Exception that represents an error when running a Story at runtime.
+// An exception being raised of this type is typically when there's
+// a bug in your ink, rather than in the ink engine itself!
+type StoryException struct {
+	Message          string
+	UseEndLineNumber bool
+}
+
+func NewStoryException(message string) *StoryException {
+
+	return &StoryException{Message: message}
+}
+
+func (s *StoryException) Error() string {
+	return s.Message
+}
+
+// recoverStoryException
+// Internally the engine raises StoryExceptions with panic, in the same way
+// that the reference engine throws them. Public entry points defer this to
+// turn them back into an error; any other panic is left to propagate.
+func recoverStoryException(err *error) {
+
+	if r := recover(); r != nil {
+		if e, ok := r.(*StoryException); ok {
+			*err = e
+			return
+		}
+		panic(r)
+	}
+}
+
+// RuntimeError
+// An error or warning generated while evaluating ink, along with the
+// location in the source ink where it happened, when available.
+type RuntimeError struct {
+	Message          string
+	Type             ErrorType
+	DebugMetadata    *DebugMetadata
+	Path             string
+	UseEndLineNumber bool
+}
+
+// LineNumber
+// The line in the source ink that the error refers to, or 0 if the
+// story was compiled without debug metadata.
+func (s *RuntimeError) LineNumber() int {
+
+	if s.DebugMetadata == nil {
+		return 0
+	}
+
+	if s.UseEndLineNumber {
+		return s.DebugMetadata.EndLineNumber
+	}
+
+	return s.DebugMetadata.StartLineNumber
+}
+
+func (s *RuntimeError) Error() string {
+
+	if s.DebugMetadata != nil {
+		return fmt.Sprintf("RUNTIME %s: '%s' line %d: %s", s.Type, s.DebugMetadata.FileName, s.LineNumber(), s.Message)
+	}
+
+	if s.Path != "" {
+		return fmt.Sprintf("RUNTIME %s: (%s): %s", s.Type, s.Path, s.Message)
+	}
+
+	return "RUNTIME " + s.Type.String() + ": " + s.Message
+}
+
+// StoryErrors
+// Returned by Continue when ink reported errors or warnings during
+// evaluation and no OnError handler has been registered. Unwraps to the
+// first issue.
+type StoryErrors struct {
+	Errors   []*RuntimeError
+	Warnings []*RuntimeError
+}
+
+func (s *StoryErrors) Error() string {
+
+	var sb strings.Builder
+	sb.WriteString("Ink had ")
+
+	if len(s.Errors) > 0 {
+		sb.WriteString(fmt.Sprint(len(s.Errors)))
+		if len(s.Errors) == 1 {
+			sb.WriteString(" error")
+		} else {
+			sb.WriteString(" errors")
+		}
+		if len(s.Warnings) > 0 {
+			sb.WriteString(" and ")
+		}
+	}
+
+	if len(s.Warnings) > 0 {
+		sb.WriteString(fmt.Sprint(len(s.Warnings)))
+		if len(s.Warnings) == 1 {
+			sb.WriteString(" warning")
+		} else {
+			sb.WriteString(" warnings")
+		}
+	}
+
+	sb.WriteString(". It is strongly suggested that you assign an error handler to story.OnError. The first issue was: ")
+	if first := s.Unwrap(); first != nil {
+		sb.WriteString(first.Error())
+	}
+
+	return sb.String()
+}
+
+func (s *StoryErrors) Unwrap() error {
+
+	if len(s.Errors) > 0 {
+		return s.Errors[0]
+	}
+
+	if len(s.Warnings) > 0 {
+		return s.Warnings[0]
+	}
+
+	return nil
+}
+
+// UnboundExternalsError
+// Returned by ValidateExternalBindings (and so by the first Continue) when
+// the ink declares EXTERNAL functions that haven't been bound.
+type UnboundExternalsError struct {
+	Names                          []string
+	AllowExternalFunctionFallbacks bool
+}
+
+func (s *UnboundExternalsError) Error() string {
+
+	plural := ""
+	if len(s.Names) > 1 {
+		plural = "s"
+	}
+
+	reason := " (ink fallbacks disabled)"
+	if s.AllowExternalFunctionFallbacks {
+		reason = ", and no fallback ink function found."
+	}
+
+	return fmt.Sprintf("Missing function binding for external%s: '%s'%s", plural, strings.Join(s.Names, "', '"), reason)
+}
@@ -29,3 +29,13 @@ func (s *OnCompleteEvaluateFunctionEvent) Emit(functionName string, arguments []
 		fn(functionName, arguments, textOutput, result)
 	}
 }
+
+type OnFlowSwitchedEvent struct {
+	Event[OnFlowSwitched]
+}
+
+func (s *OnFlowSwitchedEvent) Emit(previousFlowName string, flowName string) {
+	for _, fn := range s.h {
+		fn(previousFlowName, flowName)
+	}
+}
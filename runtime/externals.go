@@ -0,0 +1,382 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// ExternalNameExact
+// Binds Go names to ink EXTERNAL functions unchanged: PlaySound -> PlaySound.
+func ExternalNameExact(goName string) string {
+	return goName
+}
+
+// ExternalNameLowerCamel
+// Binds Go names to lower camel case ink names: PlaySound -> playSound,
+// HTTPGet -> httpGet. This is the default convention for BindExternals.
+func ExternalNameLowerCamel(goName string) string {
+
+	runes := []rune(goName)
+	for i := 0; i < len(runes) && unicode.IsUpper(runes[i]); i++ {
+		// Keep the last capital of an acronym when it starts the next word
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+
+	return string(runes)
+}
+
+// ExternalNameSnakeCase
+// Binds Go names to snake case ink names: PlaySound -> play_sound,
+// HTTPGet -> http_get.
+func ExternalNameSnakeCase(goName string) string {
+
+	var sb strings.Builder
+
+	runes := []rune(goName)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(unicode.ToLower(r))
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+
+	return sb.String()
+}
+
+// BindExternalsOption
+// Configures how BindExternals maps a Go value onto ink EXTERNAL functions.
+type BindExternalsOption func(options *bindExternalsOptions)
+
+type bindExternalsOptions struct {
+	nameConvention func(goName string) string
+	lookaheadSafe  map[string]bool
+}
+
+// WithExternalNameConvention
+// Sets how Go method and field names are turned into ink function names,
+// e.g. ExternalNameExact, ExternalNameLowerCamel or ExternalNameSnakeCase.
+// A struct tag on a field always takes precedence.
+func WithExternalNameConvention(convention func(goName string) string) BindExternalsOption {
+	return func(options *bindExternalsOptions) {
+		options.nameConvention = convention
+	}
+}
+
+// WithLookaheadSafe
+// Marks the named ink functions as lookahead safe. Methods can't carry
+// struct tags, so this is how a method is marked; fields can also use
+// the lookahead-safe tag option. See BindExternalFunction0 for details.
+func WithLookaheadSafe(inkNames ...string) BindExternalsOption {
+	return func(options *bindExternalsOptions) {
+		for _, name := range inkNames {
+			options.lookaheadSafe[name] = true
+		}
+	}
+}
+
+type externalBinding struct {
+	inkName       string
+	goName        string
+	fn            reflect.Value
+	lookaheadSafe bool
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// BindExternals
+// Binds every exported method of obj as an ink EXTERNAL function. If obj is
+// a struct (or a pointer to one), its exported fields of func type are bound
+// too, and may be configured with an `ink` struct tag:
+//
+//	type Externals struct {
+//	    Roll func(sides int) int `ink:"roll_dice,lookahead-safe"`
+//	    Skip func()              `ink:"-"`
+//	}
+//
+// Names are mapped using ExternalNameLowerCamel unless another convention
+// is given. Arguments are converted with the same rules as TryCoerce, and
+// results are passed back to ink through CreateValue. A function may also
+// return an error as its last result, which is reported as an ink error.
+//
+// Nothing is bound if any of the functions can't be bound.
+func (s *Story) BindExternals(obj interface{}, opts ...BindExternalsOption) error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("bind external functions"); err != nil {
+		return err
+	}
+
+	if obj == nil {
+		return errors.New("can't bind externals of a nil value")
+	}
+
+	options := &bindExternalsOptions{
+		nameConvention: ExternalNameLowerCamel,
+		lookaheadSafe:  make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	objValue := reflect.ValueOf(obj)
+	objType := objValue.Type()
+
+	var bindings []*externalBinding
+
+	// Exported methods
+	for i := 0; i < objType.NumMethod(); i++ {
+		method := objType.Method(i)
+		inkName := options.nameConvention(method.Name)
+		bindings = append(bindings, &externalBinding{
+			inkName:       inkName,
+			goName:        method.Name,
+			fn:            objValue.Method(i),
+			lookaheadSafe: options.lookaheadSafe[inkName],
+		})
+	}
+
+	// Exported fields of func type
+	structValue := objValue
+	if structValue.Kind() == reflect.Ptr && !structValue.IsNil() {
+		structValue = structValue.Elem()
+	}
+
+	if structValue.Kind() == reflect.Struct {
+		structType := structValue.Type()
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			if field.PkgPath != "" || field.Type.Kind() != reflect.Func {
+				continue
+			}
+
+			inkName := options.nameConvention(field.Name)
+			lookaheadSafe := false
+
+			if tag, ok := field.Tag.Lookup("ink"); ok {
+				if tag == "-" {
+					continue
+				}
+
+				parts := strings.Split(tag, ",")
+				if parts[0] != "" {
+					inkName = parts[0]
+				}
+
+				for _, option := range parts[1:] {
+					switch option {
+					case "lookahead-safe":
+						lookaheadSafe = true
+					default:
+						return fmt.Errorf("field %s: unknown ink tag option '%s'", field.Name, option)
+					}
+				}
+			}
+
+			if structValue.Field(i).IsNil() {
+				continue
+			}
+
+			bindings = append(bindings, &externalBinding{
+				inkName:       inkName,
+				goName:        field.Name,
+				fn:            structValue.Field(i),
+				lookaheadSafe: lookaheadSafe || options.lookaheadSafe[inkName],
+			})
+		}
+	}
+
+	// Check everything before binding anything
+	seen := make(map[string]string, len(bindings))
+	for _, binding := range bindings {
+		if err := checkExternalSignature(binding.fn.Type()); err != nil {
+			return fmt.Errorf("%s: %w", binding.goName, err)
+		}
+
+		if other, ok := seen[binding.inkName]; ok {
+			return fmt.Errorf("%s and %s both bind to '%s'", other, binding.goName, binding.inkName)
+		}
+		seen[binding.inkName] = binding.goName
+
+		if _, ok := s._externals[binding.inkName]; ok {
+			return fmt.Errorf("%s: function '%s' has already been bound", binding.goName, binding.inkName)
+		}
+	}
+
+	for _, binding := range bindings {
+		if err := s.bindExternalFunction(binding.inkName, s.reflectExternal(binding.inkName, binding.fn), binding.lookaheadSafe); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkExternalSignature
+// Reports whether a Go function can be called from ink: every parameter
+// must be something an ink value can be converted to, and it may return
+// at most one value that can be converted back, optionally followed by
+// an error.
+func checkExternalSignature(fnType reflect.Type) error {
+
+	if fnType.IsVariadic() {
+		return errors.New("variadic functions can't be bound to ink")
+	}
+
+	for i := 0; i < fnType.NumIn(); i++ {
+		if !isExternalValueType(fnType.In(i)) {
+			return fmt.Errorf("argument %d has unsupported type %s", i+1, fnType.In(i))
+		}
+	}
+
+	numOut := fnType.NumOut()
+	if numOut > 0 && fnType.Out(numOut-1) == errorType {
+		numOut--
+	}
+
+	if numOut > 1 {
+		return errors.New("functions bound to ink may only return one value, optionally followed by an error")
+	}
+
+	if numOut == 1 && !isExternalValueType(fnType.Out(0)) {
+		return fmt.Errorf("result has unsupported type %s", fnType.Out(0))
+	}
+
+	return nil
+}
+
+// isExternalValueType
+// Reports whether values of type t can be passed between ink and Go,
+// both as arguments and as results (see CreateValue).
+func isExternalValueType(t reflect.Type) bool {
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return true
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	}
+
+	return t == reflect.TypeOf((*InkList)(nil)) || t == reflect.TypeOf((*Path)(nil))
+}
+
+// reflectExternal
+// Wraps a Go function so that it can be called through
+// BindExternalFunctionalGeneral.
+func (s *Story) reflectExternal(inkName string, fn reflect.Value) func(args []interface{}) interface{} {
+
+	fnType := fn.Type()
+	returnsError := fnType.NumOut() > 0 && fnType.Out(fnType.NumOut()-1) == errorType
+
+	return func(args []interface{}) interface{} {
+
+		checkExternalArgs(s, inkName, args, fnType.NumIn())
+
+		in := make([]reflect.Value, fnType.NumIn())
+		for i := range in {
+			v, err := coerceExternalValue(args[i], fnType.In(i))
+			if err != nil {
+				s.Error(fmt.Sprintf("EXTERNAL function '%s' argument %d: %s", inkName, i+1, err))
+			}
+			in[i] = v
+		}
+
+		out := fn.Call(in)
+
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				s.Error(fmt.Sprintf("EXTERNAL function '%s': %s", inkName, err))
+			}
+			out = out[:len(out)-1]
+		}
+
+		if len(out) == 0 {
+			return nil
+		}
+
+		return normaliseExternalResult(out[0])
+	}
+}
+
+// coerceExternalValue
+// The reflection equivalent of TryCoerce, which also converts to named
+// and sized types (e.g. int64, float32) of the same kind.
+func coerceExternalValue(value interface{}, t reflect.Type) (reflect.Value, error) {
+
+	var coerced interface{}
+	var err error
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		coerced, err = TryCoerce[int](value)
+	case reflect.Float32, reflect.Float64:
+		coerced, err = TryCoerce[float64](value)
+	case reflect.Bool:
+		coerced, err = TryCoerce[bool](value)
+	case reflect.String:
+		coerced, err = TryCoerce[string](value)
+	case reflect.Interface:
+		if value == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(value), nil
+	default:
+		if value == nil {
+			return reflect.Zero(t), nil
+		}
+		coerced = value
+		if reflect.TypeOf(value) != t {
+			err = fmt.Errorf("failed to cast %T to %s", value, t)
+		}
+	}
+
+	if err != nil {
+		return reflect.Zero(t), err
+	}
+
+	return reflect.ValueOf(coerced).Convert(t), nil
+}
+
+// normaliseExternalResult
+// Converts a result of a sized or named type back to one of the types
+// that CreateValue understands.
+func normaliseExternalResult(v reflect.Value) interface{} {
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+	}
+
+	return v.Interface()
+}
@@ -0,0 +1,306 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const externalsPath = "testdata/conformance/externals.ink.json"
+
+// testExternals
+// Binds the externals conformance story's functions, apart from missing,
+// which has an ink fallback.
+type testExternals struct {
+	Greet  func() string `ink:"greeting"`
+	Skip   func()        `ink:"-"`
+	Unset  func()
+	hidden func()
+}
+
+func (testExternals) Multiply(a, b int) int {
+	return a * b
+}
+
+func TestExternalNameConventions(t *testing.T) {
+
+	tests := []struct {
+		goName, exact, lowerCamel, snakeCase string
+	}{
+		{"PlaySound", "PlaySound", "playSound", "play_sound"},
+		{"HTTPGet", "HTTPGet", "httpGet", "http_get"},
+		{"ID", "ID", "id", "id"},
+		{"X", "X", "x", "x"},
+		{"playSound", "playSound", "playSound", "play_sound"},
+		{"Sound2D", "Sound2D", "sound2D", "sound2_d"},
+		{"GetURLPath", "GetURLPath", "getURLPath", "get_url_path"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.exact, ExternalNameExact(test.goName), test.goName)
+		assert.Equal(t, test.lowerCamel, ExternalNameLowerCamel(test.goName), test.goName)
+		assert.Equal(t, test.snakeCase, ExternalNameSnakeCase(test.goName), test.goName)
+	}
+}
+
+func TestBindExternals(t *testing.T) {
+
+	story := newTestStoryFromFile(t, externalsPath)
+	story.AllowExternalFunctionFallbacks = true
+
+	externals := &testExternals{
+		Greet:  func() string { return "Hello from Go" },
+		Skip:   func() {},
+		hidden: func() {},
+	}
+	require.NoError(t, story.BindExternals(externals))
+
+	// Skip is tagged out, Unset is nil and hidden isn't exported
+	assert.ElementsMatch(t, []string{"multiply", "greeting"}, SortedKeys(story._externals))
+
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Product is 42.\nHello from Go\nFallback gives fallback.\n", text)
+}
+
+func TestBindExternalsNames(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		obj           interface{}
+		opts          []BindExternalsOption
+		lookaheadSafe map[string]bool
+	}{
+		{
+			name:          "method",
+			obj:           testExternals{},
+			lookaheadSafe: map[string]bool{"multiply": false},
+		},
+		{
+			name:          "method with WithLookaheadSafe",
+			obj:           testExternals{},
+			opts:          []BindExternalsOption{WithLookaheadSafe("multiply")},
+			lookaheadSafe: map[string]bool{"multiply": true},
+		},
+		{
+			name:          "method with convention",
+			obj:           testExternals{},
+			opts:          []BindExternalsOption{WithExternalNameConvention(ExternalNameExact)},
+			lookaheadSafe: map[string]bool{"Multiply": false},
+		},
+		{
+			name: "tag name",
+			obj: struct {
+				Roll func(int) int `ink:"roll_dice"`
+			}{func(int) int { return 4 }},
+			lookaheadSafe: map[string]bool{"roll_dice": false},
+		},
+		{
+			name: "tag name and lookahead-safe",
+			obj: struct {
+				Roll func(int) int `ink:"roll_dice,lookahead-safe"`
+			}{func(int) int { return 4 }},
+			lookaheadSafe: map[string]bool{"roll_dice": true},
+		},
+		{
+			name: "tag lookahead-safe only",
+			obj: struct {
+				RollDice func(int) int `ink:",lookahead-safe"`
+			}{func(int) int { return 4 }},
+			opts:          []BindExternalsOption{WithExternalNameConvention(ExternalNameSnakeCase)},
+			lookaheadSafe: map[string]bool{"roll_dice": true},
+		},
+		{
+			name: "field with WithLookaheadSafe",
+			obj: struct {
+				RollDice func(int) int
+			}{func(int) int { return 4 }},
+			opts:          []BindExternalsOption{WithLookaheadSafe("rollDice")},
+			lookaheadSafe: map[string]bool{"rollDice": true},
+		},
+		{
+			name: "tag skipped",
+			obj: struct {
+				Roll func(int) int `ink:"-"`
+			}{func(int) int { return 4 }},
+			lookaheadSafe: map[string]bool{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			story := newTestStoryFromFile(t, externalsPath)
+			require.NoError(t, story.BindExternals(test.obj, test.opts...))
+
+			bound := make(map[string]bool)
+			for name, def := range story._externals {
+				bound[name] = def.lookaheadSafe
+			}
+			assert.Equal(t, test.lookaheadSafe, bound)
+		})
+	}
+}
+
+func TestBindExternalsInvalid(t *testing.T) {
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		err  string
+	}{
+		{"nil", nil, "nil value"},
+		{"unknown tag option", struct {
+			Roll func() int `ink:"roll,sometimes"`
+		}{func() int { return 4 }}, "unknown ink tag option 'sometimes'"},
+		{"map argument", struct {
+			Roll func(map[string]int) int
+		}{func(map[string]int) int { return 4 }}, "argument 1 has unsupported type map[string]int"},
+		{"struct argument", struct {
+			Roll func(int, struct{}) int
+		}{func(int, struct{}) int { return 4 }}, "argument 2 has unsupported type struct {}"},
+		{"interface argument with methods", struct {
+			Roll func(error) int
+		}{func(error) int { return 4 }}, "argument 1 has unsupported type error"},
+		{"slice result", struct {
+			Roll func() []int
+		}{func() []int { return nil }}, "result has unsupported type []int"},
+		{"map result and an error", struct {
+			Roll func() (map[string]int, error)
+		}{func() (map[string]int, error) { return nil, nil }}, "result has unsupported type map[string]int"},
+		{"struct result", struct {
+			Roll func() struct{}
+		}{func() struct{} { return struct{}{} }}, "result has unsupported type struct {}"},
+		{"chan result", struct {
+			Roll func() chan int
+		}{func() chan int { return nil }}, "result has unsupported type chan int"},
+		{"variadic", struct {
+			Roll func(...int) int
+		}{func(...int) int { return 4 }}, "variadic"},
+		{"two results", struct {
+			Roll func() (int, int)
+		}{func() (int, int) { return 4, 4 }}, "only return one value"},
+		{"two results and an error", struct {
+			Roll func() (int, string, error)
+		}{func() (int, string, error) { return 4, "", nil }}, "only return one value"},
+		{"same name twice", struct {
+			Roll  func() int `ink:"multiply"`
+			Other func() int `ink:"multiply"`
+		}{func() int { return 4 }, func() int { return 4 }}, "Roll and Other both bind to 'multiply'"},
+		{"field and method with the same name", struct {
+			testExternals
+			Mult func(a, b int) int `ink:"multiply"`
+		}{Mult: func(a, b int) int { return 0 }}, "Multiply and Mult both bind to 'multiply'"},
+		// One function that can't be bound stops the good one being bound
+		{"one bad function", struct {
+			Good func() int
+			Bad  func(chan int)
+		}{func() int { return 4 }, func(chan int) {}}, "Bad: argument 1 has unsupported type chan int"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			story := newTestStoryFromFile(t, externalsPath)
+
+			err := story.BindExternals(test.obj)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+			assert.Empty(t, story._externals)
+		})
+	}
+}
+
+func TestBindExternalsAlreadyBound(t *testing.T) {
+
+	story := newTestStoryFromFile(t, externalsPath)
+	require.NoError(t, BindExternalFunction0(story, "greeting", func() string { return "Hi" }, false))
+
+	err := story.BindExternals(&testExternals{Greet: func() string { return "Hello" }})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "function 'greeting' has already been bound")
+
+	// Multiply wasn't bound either
+	assert.Equal(t, []string{"greeting"}, SortedKeys(story._externals))
+}
+
+func TestBindExternalsUnbound(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		externals *testExternals
+		fallbacks bool
+		unbound   []string
+	}{
+		{"method only", &testExternals{}, false, []string{"greeting", "missing"}},
+		{"method only with fallbacks", &testExternals{}, true, []string{"greeting"}},
+		{"all but missing", &testExternals{Greet: func() string { return "Hi" }}, false, []string{"missing"}},
+		{"all but missing with fallbacks", &testExternals{Greet: func() string { return "Hi" }}, true, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			story := newTestStoryFromFile(t, externalsPath)
+			story.AllowExternalFunctionFallbacks = test.fallbacks
+			require.NoError(t, story.BindExternals(test.externals))
+
+			assert.Equal(t, test.unbound, story.UnboundExternals())
+
+			err := story.ValidateExternalBindings()
+			if len(test.unbound) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var unbound *UnboundExternalsError
+			require.True(t, errors.As(err, &unbound), err)
+			assert.Equal(t, test.unbound, unbound.Names)
+			assert.Equal(t, test.fallbacks, unbound.AllowExternalFunctionFallbacks)
+
+			// Without validating first, the first Continue reports the same
+			story = newTestStoryFromFile(t, externalsPath)
+			story.AllowExternalFunctionFallbacks = test.fallbacks
+			require.NoError(t, story.BindExternals(test.externals))
+
+			_, err = story.Continue()
+			require.True(t, errors.As(err, &unbound), err)
+			assert.Equal(t, test.unbound, unbound.Names)
+		})
+	}
+}
+
+func TestBindExternalsCallErrors(t *testing.T) {
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		err  string
+	}{
+		{"argument type", struct {
+			Multiply func(a int, b *InkList) int
+		}{func(int, *InkList) int { return 0 }}, "EXTERNAL function 'multiply' argument 2: failed to cast int to *runtime.InkList"},
+		{"argument count", struct {
+			Multiply func(a int) int
+		}{func(int) int { return 0 }}, "EXTERNAL function 'multiply' expected 1 argument(s) but got 2"},
+		{"returned error", struct {
+			Multiply func(a, b int) (int, error)
+		}{func(int, int) (int, error) { return 0, errors.New("overflow") }}, "EXTERNAL function 'multiply': overflow"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			story := newTestStoryFromFile(t, externalsPath)
+			story.AllowExternalFunctionFallbacks = true
+			require.NoError(t, BindExternalFunction0(story, "greeting", func() string { return "Hi" }, false))
+			require.NoError(t, story.BindExternals(test.obj))
+
+			_, err := story.ContinueMaximally()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
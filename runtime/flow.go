@@ -29,8 +29,31 @@ func NewFlowFromJObject(name string, story *Story, jObject map[string]interface{
 	newFlow.OutputStream = JArrayToRuntimeObjList[Object](jObject["outputStream"].([]interface{}), false)
 	newFlow.CurrentChoices = JArrayToRuntimeObjList[*Choice](jObject["currentChoices"].([]interface{}), false)
 
-	jChoiceThreadsObj, _ := jObject["choiceThreads"] // C# as
-	newFlow.LoadFlowChoiceThreads(jChoiceThreadsObj.(map[string]interface{}), story)
+	// choiceThreads is optional
+	jChoiceThreadsObj, _ := jObject["choiceThreads"].(map[string]interface{}) // C# as
+	newFlow.LoadFlowChoiceThreads(jChoiceThreadsObj, story)
+
+	return newFlow
+}
+
+// Copy
+// Copies the flow, including the threads its choices were generated in,
+// so that neither copy sees changes made to the other.
+func (s *Flow) Copy() *Flow {
+
+	newFlow := new(Flow)
+	newFlow.Name = s.Name
+	newFlow.CallStack = NewCallStackFromCallStack(s.CallStack)
+	newFlow.OutputStream = NewSliceFromSlice(s.OutputStream)
+	newFlow.CurrentChoices = make([]*Choice, len(s.CurrentChoices))
+
+	for i, c := range s.CurrentChoices {
+		choiceCopy := *c
+		if c.ThreadAtGeneration != nil {
+			choiceCopy.ThreadAtGeneration = c.ThreadAtGeneration.Copy()
+		}
+		newFlow.CurrentChoices[i] = &choiceCopy
+	}
 
 	return newFlow
 }
@@ -40,7 +63,7 @@ func (s *Flow) WriteJson(writer *Writer) {
 	writer.WriteObjectStart()
 
 	writer.WritePropertyStart("callstack")
-	//s.CallStack.WriteJson(writer)
+	s.CallStack.WriteJson(writer)
 	writer.WritePropertyEnd()
 
 	writer.WritePropertyStart("outputStream")
@@ -64,7 +87,7 @@ func (s *Flow) WriteJson(writer *Writer) {
 			}
 
 			writer.WritePropertyStart(c.OriginalTheadIndex)
-			//c.ThreadAtGeneration.WriteJson(writer)
+			c.ThreadAtGeneration.WriteJson(writer)
 			writer.WritePropertyEnd()
 		}
 	}
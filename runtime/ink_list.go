@@ -75,6 +75,13 @@ type InkList struct {
 
 	_items map[InkListItem]int
 
+	// The order that C#'s Dictionary enumerates the items in, which ink
+	// depends on to pick LIST_RANDOM's item: the order they were added,
+	// except that an item takes the place of the last one removed. Removed
+	// entries are left as null items, and _freeEntries holds their indices.
+	_entries     []InkListItem
+	_freeEntries []int
+
 	// Private
 	_originNames []string
 
@@ -87,11 +94,29 @@ func (s *InkList) Count() int {
 }
 
 func (s *InkList) Set(key InkListItem, value int) {
+
+	if _, ok := s._items[key]; !ok {
+		s.addEntry(key)
+	}
+
 	s._items[key] = value
 }
 
 func (s *InkList) Remove(key InkListItem) {
+
+	if _, ok := s._items[key]; !ok {
+		return
+	}
+
 	delete(s._items, key)
+
+	for i, entry := range s._entries {
+		if entry == key {
+			s._entries[i] = InkListItem{}
+			s._freeEntries = append(s._freeEntries, i)
+			break
+		}
+	}
 }
 
 func (s *InkList) ContainsKey(key InkListItem) bool {
@@ -101,15 +126,47 @@ func (s *InkList) ContainsKey(key InkListItem) bool {
 
 func (s *InkList) Add(key InkListItem, value int) {
 	if _, ok := s._items[key]; ok == false {
+		s.addEntry(key)
 		s._items[key] = value
 	} else {
 		panic("An item with the same key has already been added. Key: " + fmt.Sprint(key))
 	}
 }
 
+// addEntry
+// Records a new key where C#'s Dictionary would enumerate it: in the most
+// recently freed entry, or else after all the others.
+func (s *InkList) addEntry(key InkListItem) {
+
+	if n := len(s._freeEntries); n > 0 {
+		s._entries[s._freeEntries[n-1]] = key
+		s._freeEntries = s._freeEntries[:n-1]
+		return
+	}
+
+	s._entries = append(s._entries, key)
+}
+
+// Items
+// The items in the order that C#'s Dictionary enumerates them in, which
+// is how the reference runtime walks a list when it builds another, and
+// when it picks LIST_RANDOM's item. Use OrderedItems for value order.
+func (s *InkList) Items() []KeyValuePair[InkListItem, int] {
+
+	items := make([]KeyValuePair[InkListItem, int], 0, len(s._items))
+	for _, key := range s._entries {
+		if !key.IsNull() {
+			items = append(items, KeyValuePair[InkListItem, int]{key, s._items[key]})
+		}
+	}
+
+	return items
+}
+
 func NewInkList() *InkList {
 
 	newInkList := new(InkList)
+	newInkList._items = make(map[InkListItem]int)
 
 	return newInkList
 }
@@ -120,6 +177,11 @@ func NewInkListFromInkList(otherList *InkList) *InkList {
 
 	newInkList := NewInkList()
 
+	// As when C# copies a Dictionary, removed entries aren't kept
+	for _, item := range otherList.Items() {
+		newInkList.Add(item.Key, item.Value)
+	}
+
 	otherOriginNames := otherList.OriginNames()
 	if otherOriginNames != nil {
 		newInkList._originNames = NewSliceFromSlice(otherOriginNames)
@@ -184,16 +246,11 @@ func (s *InkList) OriginNames() []string {
 
 	if s.Count() > 0 {
 
-		if s._originNames == nil && s.Count() > 0 {
+		// Always a new slice, since other lists may share the old one
+		s._originNames = []string{}
 
-			s._originNames = []string{}
-		} else {
-
-			s._originNames = s._originNames[:0]
-		}
-
-		for item, _ := range s._items {
-			s._originNames = append(s._originNames, item.OriginName())
+		for _, item := range s.OrderedItems() {
+			s._originNames = append(s._originNames, item.Key.OriginName())
 		}
 	}
 
@@ -213,7 +270,7 @@ func (s *InkList) SetInitialOriginNames(initialOriginNames []string) {
 		return
 	}
 
-	s._originNames = initialOriginNames
+	s._originNames = NewSliceFromSlice(initialOriginNames)
 }
 
 // MaxItem
@@ -222,12 +279,12 @@ func (s *InkList) MaxItem() KeyValuePair[InkListItem, int] {
 
 	max := KeyValuePair[InkListItem, int]{}
 
-	for key, value := range s._items {
+	// Ordered, so that ties are settled the same way every time
+	for _, item := range s.OrderedItems() {
 
-		if max.Key.IsNull() || value > max.Value {
+		if max.Key.IsNull() || item.Value > max.Value {
 
-			max.Key = key
-			max.Value = value
+			max = item
 		}
 	}
 
@@ -240,12 +297,12 @@ func (s *InkList) MinItem() KeyValuePair[InkListItem, int] {
 
 	min := KeyValuePair[InkListItem, int]{}
 
-	for key, value := range s._items {
+	// Ordered, so that ties are settled the same way every time
+	for _, item := range s.OrderedItems() {
 
-		if min.Key.IsNull() || value < min.Value {
+		if min.Key.IsNull() || item.Value < min.Value {
 
-			min.Key = key
-			min.Value = value
+			min = item
 		}
 	}
 
@@ -262,10 +319,10 @@ func (s *InkList) Inverse() *InkList {
 
 		for _, origin := range s.Origins {
 
-			for key, value := range origin.Items() {
+			for _, item := range origin.OrderedItems() {
 
-				if s.ContainsKey(key) == false {
-					list.Add(key, value)
+				if s.ContainsKey(item.Key) == false {
+					list.Add(item.Key, item.Value)
 				}
 			}
 		}
@@ -284,9 +341,9 @@ func (s *InkList) All() *InkList {
 
 		for _, origin := range s.Origins {
 
-			for key, value := range origin.Items() {
+			for _, item := range origin.OrderedItems() {
 
-				list.Set(key, value)
+				list.Set(item.Key, item.Value)
 			}
 		}
 	}
@@ -300,9 +357,9 @@ func (s *InkList) Union(otherList *InkList) *InkList {
 
 	union := NewInkListFromInkList(s)
 
-	for key, value := range otherList._items {
+	for _, item := range otherList.Items() {
 
-		union.Set(key, value)
+		union.Set(item.Key, item.Value)
 	}
 
 	return union
@@ -315,11 +372,11 @@ func (s *InkList) Union(otherList *InkList) *InkList {
 func (s *InkList) Intersect(otherList *InkList) *InkList {
 
 	intersection := NewInkList()
-	for key, value := range s._items {
+	for _, item := range s.Items() {
 
-		if otherList.ContainsKey(key) {
+		if otherList.ContainsKey(item.Key) {
 
-			intersection.Add(key, value)
+			intersection.Add(item.Key, item.Value)
 		}
 	}
 
@@ -333,9 +390,9 @@ func (s *InkList) Without(listToRemove *InkList) *InkList {
 
 	result := NewInkListFromInkList(s)
 
-	for key, _ := range listToRemove._items {
+	for _, item := range listToRemove.Items() {
 
-		result.Remove(key)
+		result.Remove(item.Key)
 	}
 
 	return result
@@ -485,7 +542,7 @@ func (s *InkList) ListWithSubRange(minBound interface{}, maxBound interface{}) *
 		maxValue = v
 	} else {
 
-		if v, isInkList := minBound.(*InkList); isInkList && v.Count() > 0 {
+		if v, isInkList := maxBound.(*InkList); isInkList && v.Count() > 0 {
 			maxValue = v.MaxItem().Value
 		}
 	}
@@ -511,16 +568,24 @@ func (s *InkList) OrderedItems() []KeyValuePair[InkListItem, int] {
 		ordered = append(ordered, KeyValuePair[InkListItem, int]{key, value})
 	}
 
-	sort.Slice(ordered, func(i, j int) bool {
+	sortListItems(ordered)
+
+	return ordered
+}
 
-		if ordered[i].Key.OriginName() == ordered[j].Key.OriginName() {
-			return ordered[i].Key.OriginName() < ordered[j].Key.OriginName()
+// sortListItems
+// Sorts items by value, and then by origin name.
+func sortListItems(items []KeyValuePair[InkListItem, int]) {
+
+	sort.Slice(items, func(i, j int) bool {
+
+		// Ensure consistent ordering of mixed lists.
+		if items[i].Value == items[j].Value {
+			return items[i].Key.OriginName() < items[j].Key.OriginName()
 		}
 
-		return ordered[i].Value < ordered[j].Value
+		return items[i].Value < items[j].Value
 	})
-
-	return ordered
 }
 
 /*
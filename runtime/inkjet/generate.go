@@ -1,3 +1,4 @@
 //go:generate flatc --gen-onefile --go-namespace inkjet -g ./inkjet.fbs
+//go:generate flatc --gen-onefile --go-namespace inkjet -g ./story.fbs
 
 package inkjet
@@ -15,6 +15,45 @@ const (
 	OpCodeTXT OpCode = 1
 	OpCodeJMP OpCode = 2
 	OpCodeHLT OpCode = 3
+	OpCodeVIS OpCode = 4
+	OpCodeVAL OpCode = 5
+	OpCodeGLU OpCode = 6
+	OpCodeTAG OpCode = 7
+	OpCodeJIF OpCode = 8
+	OpCodeCAL OpCode = 9
+	OpCodeTUN OpCode = 10
+	OpCodeJPV OpCode = 11
+	OpCodeEXT OpCode = 12
+	OpCodeCHO OpCode = 13
+	OpCodeSET OpCode = 14
+	OpCodeGET OpCode = 15
+	OpCodeCNT OpCode = 16
+	OpCodeNAT OpCode = 17
+	OpCodeEVB OpCode = 18
+	OpCodeEVE OpCode = 19
+	OpCodeOUT OpCode = 20
+	OpCodeDUP OpCode = 21
+	OpCodePOP OpCode = 22
+	OpCodeRET OpCode = 23
+	OpCodeTRT OpCode = 24
+	OpCodeSTB OpCode = 25
+	OpCodeSTE OpCode = 26
+	OpCodeCHC OpCode = 27
+	OpCodeTRN OpCode = 28
+	OpCodeTRS OpCode = 29
+	OpCodeRDC OpCode = 30
+	OpCodeRND OpCode = 31
+	OpCodeSRN OpCode = 32
+	OpCodeVIX OpCode = 33
+	OpCodeSEQ OpCode = 34
+	OpCodeTHR OpCode = 35
+	OpCodeDON OpCode = 36
+	OpCodeEND OpCode = 37
+	OpCodeLFI OpCode = 38
+	OpCodeLRG OpCode = 39
+	OpCodeLRN OpCode = 40
+	OpCodeTGB OpCode = 41
+	OpCodeTGE OpCode = 42
 )
 
 var EnumNamesOpCode = map[OpCode]string{
@@ -22,6 +61,45 @@ var EnumNamesOpCode = map[OpCode]string{
 	OpCodeTXT: "TXT",
 	OpCodeJMP: "JMP",
 	OpCodeHLT: "HLT",
+	OpCodeVIS: "VIS",
+	OpCodeVAL: "VAL",
+	OpCodeGLU: "GLU",
+	OpCodeTAG: "TAG",
+	OpCodeJIF: "JIF",
+	OpCodeCAL: "CAL",
+	OpCodeTUN: "TUN",
+	OpCodeJPV: "JPV",
+	OpCodeEXT: "EXT",
+	OpCodeCHO: "CHO",
+	OpCodeSET: "SET",
+	OpCodeGET: "GET",
+	OpCodeCNT: "CNT",
+	OpCodeNAT: "NAT",
+	OpCodeEVB: "EVB",
+	OpCodeEVE: "EVE",
+	OpCodeOUT: "OUT",
+	OpCodeDUP: "DUP",
+	OpCodePOP: "POP",
+	OpCodeRET: "RET",
+	OpCodeTRT: "TRT",
+	OpCodeSTB: "STB",
+	OpCodeSTE: "STE",
+	OpCodeCHC: "CHC",
+	OpCodeTRN: "TRN",
+	OpCodeTRS: "TRS",
+	OpCodeRDC: "RDC",
+	OpCodeRND: "RND",
+	OpCodeSRN: "SRN",
+	OpCodeVIX: "VIX",
+	OpCodeSEQ: "SEQ",
+	OpCodeTHR: "THR",
+	OpCodeDON: "DON",
+	OpCodeEND: "END",
+	OpCodeLFI: "LFI",
+	OpCodeLRG: "LRG",
+	OpCodeLRN: "LRN",
+	OpCodeTGB: "TGB",
+	OpCodeTGE: "TGE",
 }
 
 var EnumValuesOpCode = map[string]OpCode{
@@ -29,6 +107,45 @@ var EnumValuesOpCode = map[string]OpCode{
 	"TXT": OpCodeTXT,
 	"JMP": OpCodeJMP,
 	"HLT": OpCodeHLT,
+	"VIS": OpCodeVIS,
+	"VAL": OpCodeVAL,
+	"GLU": OpCodeGLU,
+	"TAG": OpCodeTAG,
+	"JIF": OpCodeJIF,
+	"CAL": OpCodeCAL,
+	"TUN": OpCodeTUN,
+	"JPV": OpCodeJPV,
+	"EXT": OpCodeEXT,
+	"CHO": OpCodeCHO,
+	"SET": OpCodeSET,
+	"GET": OpCodeGET,
+	"CNT": OpCodeCNT,
+	"NAT": OpCodeNAT,
+	"EVB": OpCodeEVB,
+	"EVE": OpCodeEVE,
+	"OUT": OpCodeOUT,
+	"DUP": OpCodeDUP,
+	"POP": OpCodePOP,
+	"RET": OpCodeRET,
+	"TRT": OpCodeTRT,
+	"STB": OpCodeSTB,
+	"STE": OpCodeSTE,
+	"CHC": OpCodeCHC,
+	"TRN": OpCodeTRN,
+	"TRS": OpCodeTRS,
+	"RDC": OpCodeRDC,
+	"RND": OpCodeRND,
+	"SRN": OpCodeSRN,
+	"VIX": OpCodeVIX,
+	"SEQ": OpCodeSEQ,
+	"THR": OpCodeTHR,
+	"DON": OpCodeDON,
+	"END": OpCodeEND,
+	"LFI": OpCodeLFI,
+	"LRG": OpCodeLRG,
+	"LRN": OpCodeLRN,
+	"TGB": OpCodeTGB,
+	"TGE": OpCodeTGE,
 }
 
 func (v OpCode) String() string {
@@ -0,0 +1,439 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+	"github.com/SirMetathyst/go-ink/runtime/inkjet"
+)
+
+var controlCommandOpCodes = map[runtime.CommandType]inkjet.OpCode{
+	runtime.CommandTypeEvalStart:            inkjet.OpCodeEVB,
+	runtime.CommandTypeEvalOutput:           inkjet.OpCodeOUT,
+	runtime.CommandTypeEvalEnd:              inkjet.OpCodeEVE,
+	runtime.CommandTypeDuplicate:            inkjet.OpCodeDUP,
+	runtime.CommandTypePopEvaluatedValue:    inkjet.OpCodePOP,
+	runtime.CommandTypePopFunction:          inkjet.OpCodeRET,
+	runtime.CommandTypePopTunnel:            inkjet.OpCodeTRT,
+	runtime.CommandTypeBeginString:          inkjet.OpCodeSTB,
+	runtime.CommandTypeEndString:            inkjet.OpCodeSTE,
+	runtime.CommandTypeNoOp:                 inkjet.OpCodeNOP,
+	runtime.CommandTypeChoiceCount:          inkjet.OpCodeCHC,
+	runtime.CommandTypeTurns:                inkjet.OpCodeTRN,
+	runtime.CommandTypeTurnsSince:           inkjet.OpCodeTRS,
+	runtime.CommandTypeReadCount:            inkjet.OpCodeRDC,
+	runtime.CommandTypeRandom:               inkjet.OpCodeRND,
+	runtime.CommandTypeSeedRandom:           inkjet.OpCodeSRN,
+	runtime.CommandTypeVisitIndex:           inkjet.OpCodeVIX,
+	runtime.CommandTypeSequenceShuffleIndex: inkjet.OpCodeSEQ,
+	runtime.CommandTypeStartThread:          inkjet.OpCodeTHR,
+	runtime.CommandTypeDone:                 inkjet.OpCodeDON,
+	runtime.CommandTypeEnd:                  inkjet.OpCodeEND,
+	runtime.CommandTypeListFromInt:          inkjet.OpCodeLFI,
+	runtime.CommandTypeListRange:            inkjet.OpCodeLRG,
+	runtime.CommandTypeListRandom:           inkjet.OpCodeLRN,
+	runtime.CommandTypeBeginTag:             inkjet.OpCodeTGB,
+	runtime.CommandTypeEndTag:               inkjet.OpCodeTGE,
+}
+
+type compiler struct {
+	story   *runtime.Story
+	program *Program
+
+	containers map[*runtime.Container]int
+	positions  map[runtime.Object]int
+	targets    map[runtime.Pointer]int
+
+	// Containers that are only reachable by name, so they're laid out
+	// after the content they belong to rather than inline
+	pending []pendingContainer
+}
+
+type pendingContainer struct {
+	container *runtime.Container
+	parent    int
+}
+
+// Compile
+// Flattens the story's content into a Program. The story is only read,
+// and it can go on being played on its own.
+func Compile(story *runtime.Story) (*Program, error) {
+
+	s := &compiler{
+		story: story,
+		program: &Program{
+			pathTargets:     make(map[string]int),
+			pathContainers:  make(map[string]int),
+			knots:           make(map[string]int),
+			listDefinitions: story.ListDefinitions(),
+			globalDecl:      -1,
+		},
+		containers: make(map[*runtime.Container]int),
+		positions:  make(map[runtime.Object]int),
+		targets:    make(map[runtime.Pointer]int),
+	}
+
+	// Stories built without lists have no definitions at all
+	if s.program.listDefinitions == nil {
+		s.program.listDefinitions = runtime.NewListDefinitionsOrigin(nil)
+	}
+
+	root := story.MainContentContainer()
+
+	// Lay out the content, then resolve the references between
+	// instructions now that everything has a position
+	if err := s.layoutContainer(root, -1, -1); err != nil {
+		return nil, err
+	}
+	for len(s.pending) > 0 {
+		next := s.pending[0]
+		s.pending = s.pending[1:]
+		if err := s.layoutContainer(next.container, next.parent, -1); err != nil {
+			return nil, err
+		}
+	}
+
+	for pc := range s.program.Instructions {
+		if err := s.resolve(pc); err != nil {
+			return nil, err
+		}
+	}
+
+	s.program.start = s.program.Containers[0].Start + 1
+
+	for _, name := range sortedNames(root.NamedContent()) {
+		knot, _ := root.NamedContent()[name].(*runtime.Container)
+		if knot == nil {
+			continue
+		}
+
+		index, err := s.target(runtime.StartOfPointer(knot))
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "global decl" {
+			s.program.globalDecl = index
+		}
+		s.program.knots[name] = index
+	}
+
+	sort.Strings(s.program.externals)
+
+	return s.program, nil
+}
+
+func sortedNames(namedContent map[string]runtime.NamedContent) []string {
+
+	names := make([]string, 0, len(namedContent))
+	for name := range namedContent {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (s *compiler) emit(op inkjet.OpCode, object runtime.Object, container int, index int) {
+
+	s.program.Instructions = append(s.program.Instructions, Instruction{
+		Op:        op,
+		Object:    object,
+		Container: container,
+		Index:     index,
+	})
+}
+
+// layoutContainer
+// Lays out the container at the end of the program, starting with its VIS
+// instruction. Inline containers (those with an index in their parent)
+// are laid out in place, so stepping off the end of one carries on with
+// the parent's next piece of content, just as IncrementContentPointer
+// does. The rest end in HLT.
+func (s *compiler) layoutContainer(container *runtime.Container, parent int, index int) error {
+
+	id := len(s.program.Containers)
+	s.containers[container] = id
+	s.positions[container] = len(s.program.Instructions)
+
+	info := ContainerInfo{
+		Path:                     container.Path(container).String(),
+		Parent:                   parent,
+		VisitsShouldBeCounted:    container.VisitsShouldBeCounted,
+		TurnIndexShouldBeCounted: container.TurnIndexShouldBeCounted,
+		CountingAtStartOnly:      container.CountingAtStartOnly,
+		Start:                    len(s.program.Instructions),
+		name:                     container.Name(),
+	}
+	if dm := container.DebugMetadata(); dm != nil {
+		info.debugMetadata = dm.String()
+	}
+	s.program.Containers = append(s.program.Containers, info)
+
+	if index == -1 {
+		s.emit(inkjet.OpCodeVIS, nil, id, -1)
+	} else {
+		s.emit(inkjet.OpCodeVIS, nil, parent, index)
+	}
+	s.program.Instructions[len(s.program.Instructions)-1].Operand = id
+
+	// An empty container is stepped past as if it were content itself
+	if len(container.Content()) == 0 {
+		s.emit(inkjet.OpCodeNOP, nil, id, 0)
+	}
+
+	for i, obj := range container.Content() {
+
+		if child, isContainer := obj.(*runtime.Container); isContainer {
+			if err := s.layoutContainer(child, id, i); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.layoutObject(obj, id, i); err != nil {
+			return err
+		}
+	}
+
+	if index == -1 {
+		s.emit(inkjet.OpCodeHLT, nil, id, len(container.Content()))
+	}
+
+	namedContent := container.NamedContent()
+	for _, name := range sortedNames(namedContent) {
+		child, _ := namedContent[name].(*runtime.Container)
+		if child != nil && container.ContentIndexOf(child) == -1 {
+			s.pending = append(s.pending, pendingContainer{container: child, parent: id})
+		}
+	}
+
+	return nil
+}
+
+func (s *compiler) layoutObject(obj runtime.Object, container int, index int) error {
+
+	s.positions[obj] = len(s.program.Instructions)
+
+	var op inkjet.OpCode
+
+	switch obj := obj.(type) {
+	case *runtime.StringValue:
+		op = inkjet.OpCodeTXT
+	case *runtime.Glue:
+		op = inkjet.OpCodeGLU
+	case *runtime.Tag:
+		op = inkjet.OpCodeTAG
+	case *runtime.Divert:
+		switch {
+		case obj.IsExternal:
+			op = inkjet.OpCodeEXT
+		case obj.HasVariableTarget():
+			op = inkjet.OpCodeJPV
+		case obj.PushesToStack && obj.StackPushType == runtime.Function:
+			op = inkjet.OpCodeCAL
+		case obj.PushesToStack:
+			op = inkjet.OpCodeTUN
+		case obj.IsConditional:
+			op = inkjet.OpCodeJIF
+		default:
+			op = inkjet.OpCodeJMP
+		}
+	case *runtime.ControlCommand:
+		var ok bool
+		if op, ok = controlCommandOpCodes[obj.CommandType]; !ok {
+			return fmt.Errorf("unsupported control command %v at %s", obj, obj.Path(obj))
+		}
+	case *runtime.ChoicePoint:
+		op = inkjet.OpCodeCHO
+	case *runtime.VariableAssignment:
+		op = inkjet.OpCodeSET
+	case *runtime.VariableReference:
+		if obj.PathForCount != nil {
+			op = inkjet.OpCodeCNT
+		} else {
+			op = inkjet.OpCodeGET
+		}
+	case *runtime.NativeFunctionCall:
+		op = inkjet.OpCodeNAT
+	case runtime.Value, *runtime.Void:
+		op = inkjet.OpCodeVAL
+	default:
+		return fmt.Errorf("unsupported content %T at %s", obj, obj.Path(obj))
+	}
+
+	s.emit(op, obj, container, index)
+
+	return nil
+}
+
+// resolve
+// Fills in the operand of the instruction at pc, now that every
+// instruction and container has a position.
+func (s *compiler) resolve(pc int) (err error) {
+
+	instruction := &s.program.Instructions[pc]
+
+	switch obj := instruction.Object.(type) {
+
+	case *runtime.Divert:
+		if obj.IsExternal {
+			name := obj.TargetPathString()
+			if !containsString(s.program.externals, name) {
+				s.program.externals = append(s.program.externals, name)
+			}
+			return nil
+		}
+
+		if obj.HasVariableTarget() {
+			return nil
+		}
+
+		// A divert to missing content is reported when it's followed,
+		// as runtime.Story does
+		instruction.Operand = -1
+		if pointer, ok := s.divertTargetPointer(obj); ok {
+			instruction.Operand, err = s.target(pointer)
+		}
+
+	case *runtime.ChoicePoint:
+		pointer, ok := s.pointerAtPath(obj.PathOnChoice())
+		if !ok {
+			return fmt.Errorf("choice target %s not found", obj.PathOnChoice())
+		}
+
+		// As StoryState.SetChosenPath
+		if pointer.Index == -1 {
+			pointer.Index = 0
+		}
+		instruction.Operand, err = s.target(pointer)
+
+	case *runtime.VariableReference:
+		if obj.PathForCount != nil {
+			container := obj.ContainerForCount()
+			if container == nil {
+				return fmt.Errorf("read count target %s not found", obj.PathForCount)
+			}
+			instruction.Operand = s.containers[container]
+		}
+
+	case *runtime.DivertTargetValue:
+		path := obj.TargetPath().String()
+
+		// Anything that can't be resolved is reported if it's diverted to
+		if pointer, ok := s.pointerAtPath(obj.TargetPath()); ok {
+			if index, err := s.target(pointer); err == nil {
+				s.program.pathTargets[path] = index
+			}
+		}
+
+		result := s.story.ContentAtPath(obj.TargetPath())
+		if container, _ := result.CorrectObj().(*runtime.Container); container != nil {
+			s.program.pathContainers[path] = s.containers[container]
+		}
+	}
+
+	return err
+}
+
+func containsString(strings []string, str string) bool {
+
+	for _, s := range strings {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+// divertTargetPointer
+// Divert.TargetPointer, except that a target that can't be found gives
+// false rather than a panic.
+func (s *compiler) divertTargetPointer(divert *runtime.Divert) (pointer runtime.Pointer, ok bool) {
+
+	if divert.TargetPath() == nil || runtime.ResolvePath(divert, divert.TargetPath()).Obj == nil {
+		return runtime.NullPointer, false
+	}
+
+	pointer = divert.TargetPointer()
+	return pointer, !pointer.IsNull()
+}
+
+// pointerAtPath
+// Story.PointerAtPath, except that a path that can't be found exactly
+// gives false rather than an error or an approximation.
+func (s *compiler) pointerAtPath(path *runtime.Path) (runtime.Pointer, bool) {
+
+	if path.Length() == 0 {
+		return runtime.NullPointer, false
+	}
+
+	root := s.story.MainContentContainer()
+
+	pathLengthToUse := path.Length()
+	index := -1
+	if path.LastComponent().IsIndex() {
+		pathLengthToUse--
+		index = path.LastComponent().Index()
+	}
+
+	result := root.ContentAtPath(path, 0, pathLengthToUse)
+	if result.Obj == nil || result.Approximate || result.Container() == nil || (result.Obj == root && pathLengthToUse > 0) {
+		return runtime.NullPointer, false
+	}
+
+	return runtime.NewPointer(result.Container(), index), true
+}
+
+// target
+// Resolves a pointer to a target, working out which containers are
+// entered by going there in the same way that
+// Story.VisitChangedContainersDueToDivert does.
+func (s *compiler) target(pointer runtime.Pointer) (int, error) {
+
+	if index, ok := s.targets[pointer]; ok {
+		return index, nil
+	}
+
+	t := target{container: s.containers[pointer.Container]}
+
+	if pointer.Index < 0 {
+		// Pointing at the container itself, so it's entered by stepping
+		// into it, and nothing is counted for the divert
+		t.pc = s.positions[pointer.Container]
+	} else {
+		obj := pointer.Resolve()
+		pc, ok := s.positions[obj]
+		if obj == nil || !ok {
+			return -1, fmt.Errorf("target %s is past the end of its container", pointer)
+		}
+		t.pc = pc
+
+		child := obj
+		ancestor, _ := obj.Parent().(*runtime.Container)
+		allChildrenEnteredAtStart := true
+
+		for ancestor != nil {
+			enteringAtStart := len(ancestor.Content()) > 0 &&
+				ancestor.Content()[0] == child &&
+				allChildrenEnteredAtStart
+
+			if !enteringAtStart {
+				allChildrenEnteredAtStart = false
+			}
+
+			t.visits = append(t.visits, targetVisit{container: s.containers[ancestor], atStart: enteringAtStart})
+
+			child = ancestor
+			ancestor, _ = ancestor.Parent().(*runtime.Container)
+		}
+	}
+
+	index := len(s.program.targets)
+	s.program.targets = append(s.program.targets, t)
+	s.targets[pointer] = index
+
+	return index, nil
+}
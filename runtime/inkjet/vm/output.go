@@ -0,0 +1,432 @@
+package vm
+
+import (
+	"strings"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// The output stream works exactly as it does in runtime.StoryState, which
+// this follows closely; only the callstack it looks at is different.
+
+func (s *state) outputStreamDirty() {
+
+	s.outputStreamTextDirty = true
+	s.outputStreamTagsDirty = true
+}
+
+func (s *state) resetOutput() {
+
+	s.outputStream = s.outputStream[:0]
+	s.outputStreamDirty()
+}
+
+func (s *state) text() string {
+
+	if s.outputStreamTextDirty {
+
+		var sb strings.Builder
+
+		inTag := false
+		for _, outputObj := range s.outputStream {
+
+			textContent, _ := outputObj.(*runtime.StringValue)
+			if !inTag && textContent != nil {
+				sb.WriteString(textContent.Value())
+			} else if controlCommand, _ := outputObj.(*runtime.ControlCommand); controlCommand != nil {
+				if controlCommand.CommandType == runtime.CommandTypeBeginTag {
+					inTag = true
+				} else if controlCommand.CommandType == runtime.CommandTypeEndTag {
+					inTag = false
+				}
+			}
+		}
+
+		s.currentText = cleanOutputWhitespace(sb.String())
+		s.outputStreamTextDirty = false
+	}
+
+	return s.currentText
+}
+
+func (s *state) tags() []string {
+
+	if s.outputStreamTagsDirty {
+
+		s.currentTags = nil
+
+		inTag := false
+		var sb strings.Builder
+
+		for _, outputObj := range s.outputStream {
+
+			if controlCommand, _ := outputObj.(*runtime.ControlCommand); controlCommand != nil {
+				if controlCommand.CommandType == runtime.CommandTypeBeginTag {
+					if inTag && sb.Len() > 0 {
+						s.currentTags = append(s.currentTags, cleanOutputWhitespace(sb.String()))
+						sb.Reset()
+					}
+					inTag = true
+				} else if controlCommand.CommandType == runtime.CommandTypeEndTag {
+					if sb.Len() > 0 {
+						s.currentTags = append(s.currentTags, cleanOutputWhitespace(sb.String()))
+						sb.Reset()
+					}
+					inTag = false
+				}
+			} else if inTag {
+				if strVal, _ := outputObj.(*runtime.StringValue); strVal != nil {
+					sb.WriteString(strVal.Value())
+				}
+			} else if tag, _ := outputObj.(*runtime.Tag); tag != nil && len(tag.Text()) > 0 {
+				s.currentTags = append(s.currentTags, tag.Text())
+			}
+		}
+
+		if sb.Len() > 0 {
+			s.currentTags = append(s.currentTags, cleanOutputWhitespace(sb.String()))
+		}
+
+		s.outputStreamTagsDirty = false
+	}
+
+	return s.currentTags
+}
+
+// cleanOutputWhitespace
+// As StoryState.CleanOutputWhitespace.
+func cleanOutputWhitespace(str string) string {
+
+	var sb strings.Builder
+
+	currentWhitespaceStart := -1
+	startOfLine := 0
+
+	for i := 0; i < len(str); i++ {
+
+		c := str[i]
+		isInlineWhitespace := c == ' ' || c == '\t'
+
+		if isInlineWhitespace && currentWhitespaceStart == -1 {
+			currentWhitespaceStart = i
+		}
+
+		if !isInlineWhitespace {
+			if c != '\n' && currentWhitespaceStart > 0 && currentWhitespaceStart != startOfLine {
+				sb.WriteByte(' ')
+			}
+			currentWhitespaceStart = -1
+		}
+
+		if c == '\n' {
+			startOfLine = i + 1
+		}
+
+		if !isInlineWhitespace {
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String()
+}
+
+func (s *state) pushToOutputStream(obj runtime.Object) {
+
+	if text, _ := obj.(*runtime.StringValue); text != nil {
+		if listText := trySplittingHeadTailWhitespace(text); listText != nil {
+			for _, textObj := range listText {
+				s.pushToOutputStreamIndividual(textObj)
+			}
+			s.outputStreamDirty()
+			return
+		}
+	}
+
+	s.pushToOutputStreamIndividual(obj)
+	s.outputStreamDirty()
+}
+
+func (s *state) popFromOutputStream(count int) {
+
+	s.outputStream = s.outputStream[:len(s.outputStream)-count]
+	s.outputStreamDirty()
+}
+
+// trySplittingHeadTailWhitespace
+// As StoryState.TrySplittingHeadTailWhitespace.
+func trySplittingHeadTailWhitespace(single *runtime.StringValue) []*runtime.StringValue {
+
+	str := single.Value()
+
+	headFirstNewlineIdx := -1
+	headLastNewlineIdx := -1
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if c == '\n' {
+			if headFirstNewlineIdx == -1 {
+				headFirstNewlineIdx = i
+			}
+			headLastNewlineIdx = i
+		} else if c != ' ' && c != '\t' {
+			break
+		}
+	}
+
+	tailLastNewlineIdx := -1
+	tailFirstNewlineIdx := -1
+	for i := len(str) - 1; i >= 0; i-- {
+		c := str[i]
+		if c == '\n' {
+			if tailLastNewlineIdx == -1 {
+				tailLastNewlineIdx = i
+			}
+			tailFirstNewlineIdx = i
+		} else if c != ' ' && c != '\t' {
+			break
+		}
+	}
+
+	// No splitting to be done?
+	if headFirstNewlineIdx == -1 && tailLastNewlineIdx == -1 {
+		return nil
+	}
+
+	var listTexts []*runtime.StringValue
+	innerStrStart := 0
+	innerStrEnd := len(str)
+
+	if headFirstNewlineIdx != -1 {
+		if headFirstNewlineIdx > 0 {
+			listTexts = append(listTexts, runtime.NewStringValueFromString(str[0:headFirstNewlineIdx]))
+		}
+		listTexts = append(listTexts, runtime.NewStringValueFromString("\n"))
+		innerStrStart = headLastNewlineIdx + 1
+	}
+
+	if tailLastNewlineIdx != -1 {
+		innerStrEnd = tailFirstNewlineIdx
+	}
+
+	if innerStrEnd > innerStrStart {
+		listTexts = append(listTexts, runtime.NewStringValueFromString(str[innerStrStart:innerStrEnd]))
+	}
+
+	if tailLastNewlineIdx != -1 && tailFirstNewlineIdx > headLastNewlineIdx {
+		listTexts = append(listTexts, runtime.NewStringValueFromString("\n"))
+		if tailLastNewlineIdx < len(str)-1 {
+			listTexts = append(listTexts, runtime.NewStringValueFromString(str[tailLastNewlineIdx+1:]))
+		}
+	}
+
+	return listTexts
+}
+
+func (s *state) pushToOutputStreamIndividual(obj runtime.Object) {
+
+	glue, _ := obj.(*runtime.Glue)
+	text, _ := obj.(*runtime.StringValue)
+
+	includeInOutput := true
+
+	// New glue, so chomp away any whitespace from the end of the stream
+	if glue != nil {
+		s.trimNewlinesFromOutputStream()
+	} else if text != nil {
+
+		// Where does the current function call begin?
+		functionTrimIndex := -1
+		if currEl := s.currentElement(); currEl.pushPopType == runtime.Function {
+			functionTrimIndex = currEl.functionStartInOutputStream
+		}
+
+		// Find the latest glue, but don't function-trim past the
+		// start of a string evaluation section
+		glueTrimIndex := -1
+		for i := len(s.outputStream) - 1; i >= 0; i-- {
+			o := s.outputStream[i]
+			if _, isGlue := o.(*runtime.Glue); isGlue {
+				glueTrimIndex = i
+				break
+			} else if c, _ := o.(*runtime.ControlCommand); c != nil && c.CommandType == runtime.CommandTypeBeginString {
+				if i >= functionTrimIndex {
+					functionTrimIndex = -1
+				}
+				break
+			}
+		}
+
+		// Where is the most agressive (earliest) trim point?
+		trimIndex := functionTrimIndex
+		if glueTrimIndex != -1 && functionTrimIndex != -1 {
+			if glueTrimIndex < functionTrimIndex {
+				trimIndex = glueTrimIndex
+			}
+		} else if glueTrimIndex != -1 {
+			trimIndex = glueTrimIndex
+		}
+
+		if trimIndex != -1 {
+
+			// While trimming, we want to throw all newlines away,
+			// whether due to glue or the start of a function
+			if text.IsNewline() {
+				includeInOutput = false
+			} else if text.IsNonWhitespace() {
+
+				if glueTrimIndex > -1 {
+					s.removeExistingGlue()
+				}
+
+				// Tell all functions in callstack that we have seen proper text,
+				// so trimming whitespace at the start is done.
+				if functionTrimIndex > -1 {
+					callstack := s.callstack()
+					for i := len(callstack) - 1; i >= 0; i-- {
+						if callstack[i].pushPopType != runtime.Function {
+							break
+						}
+						callstack[i].functionStartInOutputStream = -1
+					}
+				}
+			}
+		} else if text.IsNewline() {
+			// De-duplicate newlines, and don't ever lead with a newline
+			if s.outputStreamEndsInNewline() || !s.outputStreamContainsContent() {
+				includeInOutput = false
+			}
+		}
+	}
+
+	if includeInOutput {
+		s.outputStream = append(s.outputStream, obj)
+		s.outputStreamDirty()
+	}
+}
+
+func (s *state) trimNewlinesFromOutputStream() {
+
+	removeWhitespaceFrom := -1
+
+	// Work back from the end to the first newline in the
+	// trailing run of whitespace
+	for i := len(s.outputStream) - 1; i >= 0; i-- {
+		obj := s.outputStream[i]
+		cmd, _ := obj.(*runtime.ControlCommand)
+		txt, _ := obj.(*runtime.StringValue)
+
+		if cmd != nil || (txt != nil && txt.IsNonWhitespace()) {
+			break
+		} else if txt != nil && txt.IsNewline() {
+			removeWhitespaceFrom = i
+		}
+	}
+
+	// Remove the whitespace
+	if removeWhitespaceFrom >= 0 {
+		i := removeWhitespaceFrom
+		for i < len(s.outputStream) {
+			if _, isText := s.outputStream[i].(*runtime.StringValue); isText {
+				s.outputStream = append(s.outputStream[:i], s.outputStream[i+1:]...)
+			} else {
+				i++
+			}
+		}
+	}
+
+	s.outputStreamDirty()
+}
+
+// removeExistingGlue
+// Only called when non-whitespace is appended
+func (s *state) removeExistingGlue() {
+
+	for i := len(s.outputStream) - 1; i >= 0; i-- {
+		c := s.outputStream[i]
+		if _, isGlue := c.(*runtime.Glue); isGlue {
+			s.outputStream = append(s.outputStream[:i], s.outputStream[i+1:]...)
+		} else if _, isControlCommand := c.(*runtime.ControlCommand); isControlCommand {
+			break
+		}
+	}
+
+	s.outputStreamDirty()
+}
+
+func (s *state) outputStreamEndsInNewline() bool {
+
+	for i := len(s.outputStream) - 1; i >= 0; i-- {
+		obj := s.outputStream[i]
+		if _, isControlCommand := obj.(*runtime.ControlCommand); isControlCommand {
+			break
+		}
+		if text, _ := obj.(*runtime.StringValue); text != nil {
+			if text.IsNewline() {
+				return true
+			} else if text.IsNonWhitespace() {
+				break
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *state) outputStreamContainsContent() bool {
+
+	for _, content := range s.outputStream {
+		if _, isStringValue := content.(*runtime.StringValue); isStringValue {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *state) inStringEvaluation() bool {
+
+	for i := len(s.outputStream) - 1; i >= 0; i-- {
+		if cmd, _ := s.outputStream[i].(*runtime.ControlCommand); cmd != nil && cmd.CommandType == runtime.CommandTypeBeginString {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trimWhitespaceFromFunctionEnd
+// We always trim the start and end of the text that a function produces.
+// The start whitespace is discarded as it is generated, and the end
+// whitespace is trimmed in one go here when we pop the function.
+func (s *state) trimWhitespaceFromFunctionEnd() {
+
+	functionStartPoint := s.currentElement().functionStartInOutputStream
+
+	// If the start point has become -1, it means that some non-whitespace
+	// text has been pushed, so it's safe to go as far back as we're able.
+	if functionStartPoint == -1 {
+		functionStartPoint = 0
+	}
+
+	for i := len(s.outputStream) - 1; i >= functionStartPoint; i-- {
+		txt, _ := s.outputStream[i].(*runtime.StringValue)
+		if txt == nil {
+			continue
+		}
+
+		if txt.IsNewline() || txt.IsInlineWhitespace() {
+			s.outputStream = append(s.outputStream[:i], s.outputStream[i+1:]...)
+			s.outputStreamDirty()
+		} else {
+			break
+		}
+	}
+}
+
+func (s *state) popCallstack() {
+
+	if s.currentElement().pushPopType == runtime.Function {
+		s.trimWhitespaceFromFunctionEnd()
+	}
+
+	s.pop()
+}
@@ -0,0 +1,145 @@
+// Package vm runs ink stories as a flat list of instructions.
+//
+// Compile flattens a runtime.Story's container tree into a Program: each
+// piece of content becomes one Instruction, inline containers are laid out
+// in place so that stepping off the end of one falls through to the content
+// after it, and every divert, choice and read count refers to its target by
+// instruction or container index instead of by Path. A VM then plays the
+// Program, producing the same text, tags and choices as runtime.Story.
+//
+// The VM covers single-flow play: Continue, choices, visit and turn counts,
+// functions, tunnels, threads, lists and external functions. It doesn't
+// support multiple flows, ChoosePathString, EvaluateFunction or saving and
+// loading state.
+package vm
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+	"github.com/SirMetathyst/go-ink/runtime/inkjet"
+)
+
+// Instruction
+// A single step of a Program. Operand holds whatever the instruction has
+// been resolved to: a target index for diverts and choices, or a container
+// index for visits and read counts. Object is the runtime object the
+// instruction was compiled from, which holds the instruction's value, or
+// the details of the divert, choice, variable or function.
+type Instruction struct {
+	Op      inkjet.OpCode
+	Operand int
+	Object  runtime.Object
+
+	// Where the instruction came from: the index of its container in
+	// Program.Containers, and its index within that container's content.
+	Container int
+	Index     int
+}
+
+func (s Instruction) String() string {
+
+	if s.Object == nil {
+		return fmt.Sprintf("%s %d", s.Op, s.Operand)
+	}
+
+	return fmt.Sprintf("%s %d (%v)", s.Op, s.Operand, s.Object)
+}
+
+// ContainerInfo
+// What the VM needs to know about a container from the story: its path,
+// for the shuffle seed and for error messages, its parent, and how its
+// visits are counted.
+type ContainerInfo struct {
+	Path                     string
+	Parent                   int
+	VisitsShouldBeCounted    bool
+	TurnIndexShouldBeCounted bool
+	CountingAtStartOnly      bool
+
+	// Start is the container's VIS instruction. Its content follows it.
+	Start int
+
+	name          string
+	debugMetadata string
+}
+
+// target
+// A resolved divert target. PC is the instruction to go to, and visits
+// the containers that are entered on the way, innermost first, which is
+// what VisitChangedContainersDueToDivert walks through in runtime.Story.
+// Container is the container the target points into.
+type target struct {
+	pc        int
+	container int
+	visits    []targetVisit
+}
+
+type targetVisit struct {
+	container int
+	atStart   bool
+}
+
+// Program
+// A compiled story, ready to be run by a VM. A Program isn't modified by
+// running it, so one Program can be shared by any number of VMs.
+type Program struct {
+	Instructions []Instruction
+	Containers   []ContainerInfo
+
+	targets []target
+
+	// Targets and containers that can be looked up at runtime from a
+	// divert target value, keyed by its path
+	pathTargets    map[string]int
+	pathContainers map[string]int
+
+	// Top level knots, for external function fallbacks
+	knots map[string]int
+
+	externals       []string
+	listDefinitions *runtime.ListDefinitionsOrigin
+	start           int
+	globalDecl      int
+}
+
+// InstructionPath
+// The path of the content that the instruction at pc was compiled from,
+// in the same form as a runtime.Pointer's path.
+func (s *Program) InstructionPath(pc int) string {
+
+	instruction := s.Instructions[pc]
+	containerPath := s.Containers[instruction.Container].Path
+
+	if instruction.Index < 0 {
+		return containerPath
+	}
+
+	if containerPath == "" {
+		return strconv.Itoa(instruction.Index)
+	}
+
+	return containerPath + "." + strconv.Itoa(instruction.Index)
+}
+
+// TargetPc
+// The instruction that a target goes to, given the target's index as held
+// in the Operand of a divert or choice instruction.
+func (s *Program) TargetPc(target int) int {
+
+	return s.targets[target].pc
+}
+
+// containerContains
+// Whether ancestor is container or one of its parents.
+func (s *Program) containerContains(ancestor int, container int) bool {
+
+	for c := container; c != -1; c = s.Containers[c].Parent {
+		if c == ancestor {
+			return true
+		}
+	}
+
+	return false
+}
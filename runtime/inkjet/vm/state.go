@@ -0,0 +1,320 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// noTurnIndex
+// The turn index of a container that hasn't been visited
+const noTurnIndex = math.MinInt32
+
+// element
+// A callstack element, as runtime.Element, with the pointer replaced by
+// an instruction index. A pc of -1 is the null pointer.
+type element struct {
+	pc                              int
+	pushPopType                     runtime.PushPopType
+	inExpressionEvaluation          bool
+	temporaryVariables              map[string]runtime.Object
+	evaluationStackHeightWhenPushed int
+	functionStartInOutputStream     int
+}
+
+func newElement(pushPopType runtime.PushPopType, pc int) *element {
+
+	return &element{
+		pc:                 pc,
+		pushPopType:        pushPopType,
+		temporaryVariables: make(map[string]runtime.Object),
+	}
+}
+
+func (s *element) copy() *element {
+
+	elementCopy := *s
+	elementCopy.temporaryVariables = runtime.NewMapFromMap(s.temporaryVariables)
+
+	return &elementCopy
+}
+
+type thread struct {
+	callstack   []*element
+	threadIndex int
+	previousPc  int
+}
+
+func (s *thread) copy() *thread {
+
+	threadCopy := &thread{
+		callstack:   make([]*element, len(s.callstack)),
+		threadIndex: s.threadIndex,
+		previousPc:  s.previousPc,
+	}
+
+	for i, e := range s.callstack {
+		threadCopy.callstack[i] = e.copy()
+	}
+
+	return threadCopy
+}
+
+// state
+// Everything that changes as the VM runs, in one place so that it can be
+// snapshotted at the end of a line and rewound to, as runtime.Story does.
+type state struct {
+	threads       []*thread
+	threadCounter int
+
+	outputStream    []runtime.Object
+	evaluationStack []runtime.Object
+	currentChoices  []*Choice
+	divertedTarget  int
+	didSafeExit     bool
+
+	// Shared with a snapshot until they're first changed
+	globals          map[string]runtime.Object
+	visitCounts      []int
+	turnIndices      []int
+	sharedGlobals    bool
+	sharedCounts     bool
+	currentTurnIndex int
+
+	storySeed      int
+	previousRandom int
+
+	currentErrors   []*runtime.RuntimeError
+	currentWarnings []*runtime.RuntimeError
+
+	outputStreamTextDirty bool
+	outputStreamTagsDirty bool
+	currentText           string
+	currentTags           []string
+}
+
+func newState(program *Program) *state {
+
+	s := &state{
+		threads:               []*thread{{callstack: []*element{newElement(runtime.Tunnel, program.start)}, previousPc: -1}},
+		divertedTarget:        -1,
+		globals:               make(map[string]runtime.Object),
+		visitCounts:           make([]int, len(program.Containers)),
+		turnIndices:           make([]int, len(program.Containers)),
+		currentTurnIndex:      -1,
+		outputStreamTextDirty: true,
+		outputStreamTagsDirty: true,
+	}
+
+	for i := range s.turnIndices {
+		s.turnIndices[i] = noTurnIndex
+	}
+
+	return s
+}
+
+// copy
+// A copy of the state to carry on with, leaving this one as a snapshot.
+func (s *state) copy() *state {
+
+	stateCopy := *s
+
+	stateCopy.threads = make([]*thread, len(s.threads))
+	for i, t := range s.threads {
+		stateCopy.threads[i] = t.copy()
+	}
+
+	stateCopy.outputStream = runtime.NewSliceFromSlice(s.outputStream)
+	stateCopy.evaluationStack = runtime.NewSliceFromSlice(s.evaluationStack)
+	stateCopy.currentChoices = runtime.NewSliceFromSlice(s.currentChoices)
+	stateCopy.currentErrors = runtime.NewSliceFromSlice(s.currentErrors)
+	stateCopy.currentWarnings = runtime.NewSliceFromSlice(s.currentWarnings)
+	stateCopy.sharedGlobals = true
+	stateCopy.sharedCounts = true
+
+	return &stateCopy
+}
+
+func (s *state) currentThread() *thread {
+
+	return s.threads[len(s.threads)-1]
+}
+
+func (s *state) callstack() []*element {
+
+	return s.currentThread().callstack
+}
+
+func (s *state) currentElement() *element {
+
+	callstack := s.callstack()
+	return callstack[len(callstack)-1]
+}
+
+func (s *state) currentElementIndex() int {
+
+	return len(s.callstack()) - 1
+}
+
+func (s *state) canPop() bool {
+
+	return len(s.callstack()) > 1
+}
+
+func (s *state) canPopWith(pushPopType runtime.PushPopType) bool {
+
+	return s.canPop() && s.currentElement().pushPopType == pushPopType
+}
+
+func (s *state) canPopThread() bool {
+
+	return len(s.threads) > 1
+}
+
+func (s *state) push(pushPopType runtime.PushPopType, externalEvaluationStackHeight int, outputStreamLengthWithPushed int) {
+
+	e := newElement(pushPopType, s.currentElement().pc)
+	e.evaluationStackHeightWhenPushed = externalEvaluationStackHeight
+	e.functionStartInOutputStream = outputStreamLengthWithPushed
+
+	t := s.currentThread()
+	t.callstack = append(t.callstack, e)
+}
+
+func (s *state) pop() {
+
+	t := s.currentThread()
+	t.callstack = t.callstack[:len(t.callstack)-1]
+}
+
+func (s *state) pushThread() {
+
+	newThread := s.currentThread().copy()
+	s.threadCounter++
+	newThread.threadIndex = s.threadCounter
+	s.threads = append(s.threads, newThread)
+}
+
+func (s *state) forkThread() *thread {
+
+	forkedThread := s.currentThread().copy()
+	s.threadCounter++
+	forkedThread.threadIndex = s.threadCounter
+	return forkedThread
+}
+
+func (s *state) popThread() {
+
+	s.threads = s.threads[:len(s.threads)-1]
+}
+
+func (s *state) setCurrentThread(t *thread) {
+
+	s.threads = []*thread{t}
+}
+
+func (s *state) currentPc() int {
+
+	return s.currentElement().pc
+}
+
+func (s *state) setCurrentPc(pc int) {
+
+	s.currentElement().pc = pc
+}
+
+func (s *state) inExpressionEvaluation() bool {
+
+	return s.currentElement().inExpressionEvaluation
+}
+
+func (s *state) setInExpressionEvaluation(value bool) {
+
+	s.currentElement().inExpressionEvaluation = value
+}
+
+// forceEnd
+// As StoryState.ForceEnd.
+func (s *state) forceEnd() {
+
+	s.threads = []*thread{{callstack: []*element{newElement(runtime.Tunnel, -1)}, previousPc: -1}}
+	s.currentChoices = s.currentChoices[:0]
+	s.didSafeExit = true
+}
+
+func (s *state) visitCount(container int) int {
+
+	return s.visitCounts[container]
+}
+
+func (s *state) incrementVisitCount(container int) {
+
+	s.unshareCounts()
+	s.visitCounts[container]++
+}
+
+func (s *state) recordTurnIndexVisit(container int) {
+
+	s.unshareCounts()
+	s.turnIndices[container] = s.currentTurnIndex
+}
+
+func (s *state) turnsSince(container int) int {
+
+	if index := s.turnIndices[container]; index != noTurnIndex {
+		return s.currentTurnIndex - index
+	}
+
+	return -1
+}
+
+func (s *state) unshareCounts() {
+
+	if s.sharedCounts {
+		s.visitCounts = runtime.NewSliceFromSlice(s.visitCounts)
+		s.turnIndices = runtime.NewSliceFromSlice(s.turnIndices)
+		s.sharedCounts = false
+	}
+}
+
+func (s *state) setGlobal(name string, value runtime.Object) {
+
+	if s.sharedGlobals {
+		s.globals = runtime.NewMapFromMap(s.globals)
+		s.sharedGlobals = false
+	}
+
+	runtime.RetainListOriginsForAssignment(s.globals[name], value)
+	s.globals[name] = value
+}
+
+func (s *state) globalVariableExistsWithName(name string) bool {
+
+	_, ok := s.globals[name]
+	return ok
+}
+
+func (s *state) addError(err *runtime.RuntimeError) {
+
+	if err.Type == runtime.ErrorTypeWarning {
+		s.currentWarnings = append(s.currentWarnings, err)
+	} else {
+		s.currentErrors = append(s.currentErrors, err)
+	}
+}
+
+func (s *state) hasError() bool {
+
+	return len(s.currentErrors) > 0
+}
+
+func (s *state) hasWarning() bool {
+
+	return len(s.currentWarnings) > 0
+}
+
+func (s *state) resetErrors() {
+
+	s.currentErrors = nil
+	s.currentWarnings = nil
+}
@@ -0,0 +1,942 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+	"github.com/SirMetathyst/go-ink/runtime/inkjet"
+)
+
+// step
+// As Story.Step: runs the instruction at the current pc and moves on.
+func (s *VM) step() {
+
+	pc := s.state.currentPc()
+	if pc == -1 {
+		return
+	}
+
+	// Step directly to the first element of content in a container (if necessary)
+	for s.program.Instructions[pc].Op == inkjet.OpCodeVIS {
+		s.visitContainer(s.program.Instructions[pc].Operand, true)
+		pc++
+	}
+
+	s.state.setCurrentPc(pc)
+
+	instruction := &s.program.Instructions[pc]
+	isLogicOrFlowControl := s.performLogicAndFlowControl(instruction)
+
+	// Has flow been forced to end by flow control above?
+	if s.state.currentPc() == -1 {
+		return
+	}
+
+	if instruction.Op == inkjet.OpCodeCHO {
+		if choice := s.processChoice(instruction); choice != nil {
+			s.state.currentChoices = append(s.state.currentChoices, choice)
+		}
+	} else if !isLogicOrFlowControl {
+
+		obj := instruction.Object
+
+		// Make variable pointers specific to the current context, without
+		// editing the program's own object
+		if varPointer, _ := obj.(*runtime.VariablePointerValue); varPointer != nil && varPointer.ContextIndex() == -1 {
+			contextIdx := s.contextForVariableNamed(varPointer.Value())
+			obj = runtime.NewVariablePointerValueFromValue(varPointer.Value(), contextIdx)
+		}
+
+		if s.state.inExpressionEvaluation() {
+			s.pushEvaluationStack(obj)
+		} else {
+			s.state.pushToOutputStream(obj)
+		}
+	}
+
+	s.nextContent()
+
+	// Starting a thread should be done after moving on, so that when
+	// returning from the thread, it returns to the content after this instruction.
+	if instruction.Op == inkjet.OpCodeTHR {
+		s.state.pushThread()
+	}
+}
+
+// nextContent
+// As Story.NextContent.
+func (s *VM) nextContent() {
+
+	s.state.currentThread().previousPc = s.state.currentPc()
+
+	// Divert step?
+	if s.state.divertedTarget != -1 {
+
+		target := s.state.divertedTarget
+		s.state.divertedTarget = -1
+
+		s.state.setCurrentPc(s.program.targets[target].pc)
+		s.visitChangedContainersDueToDivert(target)
+		return
+	}
+
+	if s.incrementPc() {
+		return
+	}
+
+	// Ran out of content? Try to auto-exit from a function,
+	// or finish evaluating the content of a thread
+	didPop := false
+
+	if s.state.canPopWith(runtime.Function) {
+
+		s.state.popCallstack()
+
+		// This pop was due to dropping off the end of a function that didn't return anything,
+		// so in this case, we make sure that the evaluator has something to chomp on if it needs it
+		if s.state.inExpressionEvaluation() {
+			s.pushEvaluationStack(runtime.NewVoid())
+		}
+
+		didPop = true
+	} else if s.state.canPopThread() {
+
+		s.state.popThread()
+
+		didPop = true
+	}
+
+	// Step past the point where we last called out
+	if didPop && s.state.currentPc() != -1 {
+		s.nextContent()
+	}
+}
+
+// incrementPc
+// Moves on to the next instruction. Running off the end of a container
+// that isn't inline in its parent reaches its HLT, which ends the flow.
+func (s *VM) incrementPc() bool {
+
+	pc := s.state.currentPc() + 1
+
+	if s.program.Instructions[pc].Op == inkjet.OpCodeHLT {
+		s.state.setCurrentPc(-1)
+		return false
+	}
+
+	s.state.setCurrentPc(pc)
+	return true
+}
+
+// pointerContainer
+// The container that the instruction at pc resolves to, or is in.
+func (s *Program) pointerContainer(pc int) int {
+
+	instruction := &s.Instructions[pc]
+	if instruction.Op == inkjet.OpCodeVIS {
+		return instruction.Operand
+	}
+
+	return instruction.Container
+}
+
+// visitContainer
+// As Story.VisitContainer.
+func (s *VM) visitContainer(container int, atStart bool) {
+
+	info := &s.program.Containers[container]
+
+	if !info.CountingAtStartOnly || atStart {
+		if info.VisitsShouldBeCounted {
+			s.state.incrementVisitCount(container)
+		}
+		if info.TurnIndexShouldBeCounted {
+			s.state.recordTurnIndexVisit(container)
+		}
+	}
+}
+
+// visitChangedContainersDueToDivert
+// As Story.VisitChangedContainersDueToDivert, walking the containers that
+// were worked out for the target when it was compiled.
+func (s *VM) visitChangedContainersDueToDivert(target int) {
+
+	visits := s.program.targets[target].visits
+	if len(visits) == 0 {
+		return
+	}
+
+	prevContainer := -1
+	if previousPc := s.state.currentThread().previousPc; previousPc != -1 {
+		prevContainer = s.program.pointerContainer(previousPc)
+	}
+
+	for _, visit := range visits {
+
+		if prevContainer != -1 &&
+			s.program.containerContains(visit.container, prevContainer) &&
+			!s.program.Containers[visit.container].CountingAtStartOnly {
+			break
+		}
+
+		s.visitContainer(visit.container, visit.atStart)
+	}
+}
+
+// choosePath
+// As Story.ChoosePath, going to a target.
+// (default) incrementingTurnIndex: true
+func (s *VM) choosePath(target int, incrementingTurnIndex bool) {
+
+	// Changing direction, assume we need to clear current set of choices
+	s.state.currentChoices = s.state.currentChoices[:0]
+
+	s.state.setCurrentPc(s.program.targets[target].pc)
+
+	if incrementingTurnIndex {
+		s.state.currentTurnIndex++
+	}
+
+	s.visitChangedContainersDueToDivert(target)
+}
+
+// targetAtPath
+// The target for a divert target value, as Story.PointerAtPath.
+func (s *VM) targetAtPath(path *runtime.Path) int {
+
+	target, ok := s.program.pathTargets[path.String()]
+	if !ok {
+		s.error("Failed to find content at path '" + path.String() + "', and no approximation of it was possible.")
+	}
+
+	return target
+}
+
+func (s *VM) tryFollowDefaultInvisibleChoice() bool {
+
+	allChoices := s.state.currentChoices
+
+	// Is a default invisible choice the ONLY choice?
+	var invisibleChoices []*Choice
+	for _, choice := range allChoices {
+		if choice.isInvisibleDefault {
+			invisibleChoices = append(invisibleChoices, choice)
+		}
+	}
+
+	if len(invisibleChoices) == 0 || len(allChoices) > len(invisibleChoices) {
+		return false
+	}
+
+	choice := invisibleChoices[0]
+
+	// Invisible choice may have been generated on a different thread,
+	// in which case we need to restore it before we continue
+	s.state.setCurrentThread(choice.thread)
+
+	// If there's a chance that this state will be rolled back to before
+	// the invisible choice then make sure that the choice thread is
+	// left intact, and it isn't re-entered in an old state.
+	if s.snapshot != nil {
+		s.state.setCurrentThread(s.state.forkThread())
+	}
+
+	s.choosePath(choice.target, false)
+
+	return true
+}
+
+func (s *VM) popChoiceStringAndTags(tags *[]string) string {
+
+	choiceOnlyStrVal := s.popEvaluationStack().(*runtime.StringValue)
+
+	for len(s.state.evaluationStack) > 0 {
+		tag, isTag := s.peekEvaluationStack().(*runtime.Tag)
+		if !isTag {
+			break
+		}
+		s.popEvaluationStack()
+		*tags = append([]string{tag.Text()}, *tags...)
+	}
+
+	return choiceOnlyStrVal.Value()
+}
+
+// processChoice
+// As Story.ProcessChoice.
+func (s *VM) processChoice(instruction *Instruction) *Choice {
+
+	choicePoint := instruction.Object.(*runtime.ChoicePoint)
+
+	showChoice := true
+
+	// Don't create choice if choice point doesn't pass conditional
+	if choicePoint.HasCondition {
+		if !s.isTruthy(s.popEvaluationStack()) {
+			showChoice = false
+		}
+	}
+
+	startText := ""
+	choiceOnlyText := ""
+	tags := []string{}
+
+	if choicePoint.HasChoiceOnlyContent {
+		choiceOnlyText = s.popChoiceStringAndTags(&tags)
+	}
+
+	if choicePoint.HasStartContent {
+		startText = s.popChoiceStringAndTags(&tags)
+	}
+
+	// Don't create choice if player has already read this content
+	if choicePoint.OnceOnly {
+		if s.visitCountForContainer(s.program.targets[instruction.Operand].container) > 0 {
+			showChoice = false
+		}
+	}
+
+	// The content is consumed above even if the choice isn't shown,
+	// since otherwise it'll be shown on the output stream.
+	if !showChoice {
+		return nil
+	}
+
+	return &Choice{
+		Text:               strings.Trim(startText+choiceOnlyText, " \t"),
+		Tags:               tags,
+		isInvisibleDefault: choicePoint.IsInvisibleDefault,
+		target:             instruction.Operand,
+
+		// Captured since after generating the choice we may pop out of a
+		// tunnel or thread, as in Story.ProcessChoice
+		thread: s.state.forkThread(),
+	}
+}
+
+// isTruthy
+// As Story.IsTruthy.
+func (s *VM) isTruthy(obj runtime.Object) bool {
+
+	if value, isValue := obj.(runtime.Value); isValue {
+		if divTarget, isDivertTargetValue := value.(*runtime.DivertTargetValue); isDivertTargetValue {
+			s.error("Shouldn't use a divert target (to " + divTarget.TargetPath().String() + ") as a conditional value. Did you intend a function call 'likeThis()' or a read count check 'likeThis'? (no arrows)")
+			return false
+		}
+
+		return value.IsTruthy()
+	}
+
+	return false
+}
+
+func (s *VM) visitCountForContainer(container int) int {
+
+	info := &s.program.Containers[container]
+	if !info.VisitsShouldBeCounted {
+		s.error("Read count for target (" + info.name + " - on " + info.debugMetadata + ") unknown.")
+		return 0
+	}
+
+	return s.state.visitCount(container)
+}
+
+func (s *VM) turnsSinceForContainer(container int) int {
+
+	info := &s.program.Containers[container]
+	if !info.TurnIndexShouldBeCounted {
+		s.error("TURNS_SINCE() for target (" + info.name + " - on " + info.debugMetadata + ") unknown.")
+	}
+
+	return s.state.turnsSince(container)
+}
+
+// performLogicAndFlowControl
+// As Story.PerformLogicAndFlowControl: runs the instruction if it's a
+// control or flow instruction rather than a piece of content, and returns
+// whether it was.
+func (s *VM) performLogicAndFlowControl(instruction *Instruction) bool {
+
+	switch instruction.Op {
+
+	case inkjet.OpCodeTXT, inkjet.OpCodeGLU, inkjet.OpCodeTAG, inkjet.OpCodeVAL, inkjet.OpCodeCHO:
+		return false
+
+	case inkjet.OpCodeJMP, inkjet.OpCodeJIF, inkjet.OpCodeTUN, inkjet.OpCodeCAL, inkjet.OpCodeJPV, inkjet.OpCodeEXT:
+		s.performDivert(instruction)
+
+	case inkjet.OpCodeSET:
+		assignedVal := s.popEvaluationStack()
+		s.assign(instruction.Object.(*runtime.VariableAssignment), assignedVal)
+
+	case inkjet.OpCodeCNT:
+		count := s.visitCountForContainer(instruction.Operand)
+		s.pushEvaluationStack(runtime.NewIntValueFromInt(count))
+
+	case inkjet.OpCodeGET:
+		varRef := instruction.Object.(*runtime.VariableReference)
+		foundValue := s.getVariableWithName(varRef.Name, -1)
+
+		if foundValue == nil {
+			s.warning("Variable not found: '" + varRef.Name + "'. Using default value of 0 (false). This can happen with temporary variables if the declaration hasn't yet been hit. Globals are always given a default value on load if a value doesn't exist in the save state.")
+			foundValue = runtime.NewIntValueFromInt(0)
+		}
+
+		s.pushEvaluationStack(foundValue)
+
+	case inkjet.OpCodeNAT:
+		nfunc := instruction.Object.(*runtime.NativeFunctionCall)
+		funcParams := s.popEvaluationStackEx(nfunc.NumberOfParameters())
+		s.pushEvaluationStack(nfunc.Call(funcParams))
+
+	case inkjet.OpCodeNOP, inkjet.OpCodeTHR:
+		// Starting a thread is handled in step
+
+	default:
+		s.performControlCommand(instruction)
+	}
+
+	return true
+}
+
+func (s *VM) performDivert(instruction *Instruction) {
+
+	currentDivert := instruction.Object.(*runtime.Divert)
+
+	if currentDivert.IsConditional {
+		// False conditional? Cancel divert
+		if !s.isTruthy(s.popEvaluationStack()) {
+			return
+		}
+	}
+
+	switch instruction.Op {
+
+	case inkjet.OpCodeJPV:
+		varName := currentDivert.VariableDivertName
+		varContents := s.getVariableWithName(varName, -1)
+
+		target, isDivertTargetValue := varContents.(*runtime.DivertTargetValue)
+		if varContents == nil {
+			s.error("Tried to divert using a target from a variable that could not be found (" + varName + ")")
+		} else if !isDivertTargetValue {
+
+			errorMessage := "Tried to divert to a target from a variable, but the variable (" + varName + ") didn't contain a divert target, it "
+			if intContent, _ := varContents.(*runtime.IntValue); intContent != nil && intContent.Value() == 0 {
+				errorMessage += "was empty/null (the value 0)."
+			} else {
+				errorMessage += "contained '" + fmt.Sprint(varContents) + "'."
+			}
+
+			s.error(errorMessage)
+		}
+
+		s.state.divertedTarget = s.targetAtPath(target.TargetPath())
+
+	case inkjet.OpCodeEXT:
+		s.callExternalFunction(currentDivert.TargetPathString(), currentDivert.ExternalArgs)
+		return
+
+	default:
+		s.state.divertedTarget = instruction.Operand
+	}
+
+	if currentDivert.PushesToStack {
+		s.state.push(currentDivert.StackPushType, 0, len(s.state.outputStream))
+	}
+
+	if s.state.divertedTarget == -1 {
+
+		// Human readable name available - runtime divert is part of a hard-written divert that to missing content
+		if dm := currentDivert.DebugMetadata(); dm != nil && dm.SourceName != "" {
+			s.error("Divert target doesn't exist: " + dm.SourceName)
+		} else {
+			s.error("Divert resolution failed: " + currentDivert.String())
+		}
+	}
+}
+
+func (s *VM) performControlCommand(instruction *Instruction) {
+
+	evalCommand := instruction.Object.(*runtime.ControlCommand)
+
+	switch instruction.Op {
+
+	case inkjet.OpCodeEVB:
+		s.state.setInExpressionEvaluation(true)
+
+	case inkjet.OpCodeEVE:
+		s.state.setInExpressionEvaluation(false)
+
+	case inkjet.OpCodeOUT:
+
+		// If the expression turned out to be empty, there may not be anything on the stack
+		if len(s.state.evaluationStack) > 0 {
+
+			output := s.popEvaluationStack()
+
+			// Functions may evaluate to Void, in which case we skip output
+			if _, isVoid := output.(*runtime.Void); !isVoid {
+				s.state.pushToOutputStream(runtime.NewStringValueFromString(output.(fmt.Stringer).String()))
+			}
+		}
+
+	case inkjet.OpCodeDUP:
+		s.pushEvaluationStack(s.peekEvaluationStack())
+
+	case inkjet.OpCodePOP:
+		s.popEvaluationStack()
+
+	case inkjet.OpCodeRET, inkjet.OpCodeTRT:
+		s.popFunctionOrTunnel(instruction.Op == inkjet.OpCodeRET)
+
+	case inkjet.OpCodeSTB:
+		s.state.pushToOutputStream(evalCommand)
+
+		if !s.state.inExpressionEvaluation() {
+			s.error("Expected to be in an expression when evaluating a string")
+		}
+
+		s.state.setInExpressionEvaluation(false)
+
+	case inkjet.OpCodeTGB:
+		s.state.pushToOutputStream(evalCommand)
+
+	case inkjet.OpCodeTGE:
+
+		// In string evaluation, the tag belongs to a choice, so it's
+		// pushed to the evaluation stack like the choice's text is
+		if s.state.inStringEvaluation() {
+			s.endChoiceTag()
+		} else {
+			s.state.pushToOutputStream(evalCommand)
+		}
+
+	case inkjet.OpCodeSTE:
+		s.endString()
+
+	case inkjet.OpCodeCHC:
+		s.pushEvaluationStack(runtime.NewIntValueFromInt(len(s.state.currentChoices)))
+
+	case inkjet.OpCodeTRN:
+		s.pushEvaluationStack(runtime.NewIntValueFromInt(s.state.currentTurnIndex + 1))
+
+	case inkjet.OpCodeTRS, inkjet.OpCodeRDC:
+		s.turnsSinceOrReadCount(evalCommand)
+
+	case inkjet.OpCodeRND:
+		s.random()
+
+	case inkjet.OpCodeSRN:
+		seed, _ := s.popEvaluationStack().(*runtime.IntValue)
+		if seed == nil {
+			s.error("Invalid value passed to SEED_RANDOM")
+		}
+
+		// Story seed affects both RANDOM and shuffle behaviour
+		s.state.storySeed = seed.Value()
+		s.state.previousRandom = 0
+
+		// SEED_RANDOM returns nothing.
+		s.pushEvaluationStack(runtime.NewVoid())
+
+	case inkjet.OpCodeVIX:
+		count := s.visitCountForContainer(instruction.Container) - 1 // index not count
+		s.pushEvaluationStack(runtime.NewIntValueFromInt(count))
+
+	case inkjet.OpCodeSEQ:
+		s.pushEvaluationStack(runtime.NewIntValueFromInt(s.nextSequenceShuffleIndex(instruction)))
+
+	case inkjet.OpCodeDON:
+
+		// We may exist in the context of the initial
+		// act of creating the thread, or in the context of
+		// evaluating the content.
+		if s.state.canPopThread() {
+			s.state.popThread()
+		} else {
+			// In normal flow - allow safe exit without warning
+			s.state.didSafeExit = true
+
+			// Stop flow in current thread
+			s.state.setCurrentPc(-1)
+		}
+
+	case inkjet.OpCodeEND:
+		s.state.forceEnd()
+
+	case inkjet.OpCodeLFI:
+		s.listFromInt()
+
+	case inkjet.OpCodeLRG:
+		max, _ := s.popEvaluationStack().(runtime.Value)
+		min, _ := s.popEvaluationStack().(runtime.Value)
+		targetList, _ := s.popEvaluationStack().(*runtime.ListValue)
+
+		if targetList == nil || min == nil || max == nil {
+			s.error("Expected list, minimum and maximum for LIST_RANGE")
+		}
+
+		result := targetList.Value().ListWithSubRange(min.ValueObject(), max.ValueObject())
+		s.pushEvaluationStack(runtime.NewListValueFromList(result))
+
+	case inkjet.OpCodeLRN:
+		s.listRandom()
+
+	default:
+		s.error("unhandled ControlCommand: " + fmt.Sprint(evalCommand))
+	}
+}
+
+func (s *VM) popFunctionOrTunnel(isFunction bool) {
+
+	popType := runtime.Tunnel
+	if isFunction {
+		popType = runtime.Function
+	}
+
+	// Tunnel onwards is allowed to specify an optional override
+	// divert to go to immediately after returning: ->-> target
+	var overrideTunnelReturnTarget *runtime.DivertTargetValue
+	if popType == runtime.Tunnel {
+		popped := s.popEvaluationStack()
+		overrideTunnelReturnTarget, _ = popped.(*runtime.DivertTargetValue)
+		if overrideTunnelReturnTarget == nil {
+			if _, isVoid := popped.(*runtime.Void); !isVoid {
+				s.error("Expected void if ->-> doesn't override target")
+			}
+		}
+	}
+
+	if s.state.currentElement().pushPopType != popType || !s.state.canPop() {
+
+		names := map[runtime.PushPopType]string{
+			runtime.Function: "function return statement (~ return)",
+			runtime.Tunnel:   "tunnel onwards statement (->->)",
+		}
+
+		expected := names[s.state.currentElement().pushPopType]
+		if !s.state.canPop() {
+			expected = "end of flow (-> END or choice)"
+		}
+
+		s.error(fmt.Sprintf("Found %s, when expected %s", names[popType], expected))
+		return
+	}
+
+	s.state.popCallstack()
+
+	// Does tunnel onwards override by diverting to a new ->-> target?
+	if overrideTunnelReturnTarget != nil {
+		s.state.divertedTarget = s.targetAtPath(overrideTunnelReturnTarget.TargetPath())
+	}
+}
+
+// endChoiceTag
+// Takes the text of a tag generated while evaluating a choice's text off
+// the output stream, and pushes it to the evaluation stack as a Tag.
+func (s *VM) endChoiceTag() {
+
+	var contentForTag []string
+	outputCountConsumed := 0
+
+	for i := len(s.state.outputStream) - 1; i >= 0; i-- {
+
+		obj := s.state.outputStream[i]
+
+		outputCountConsumed++
+		if command, ok := obj.(*runtime.ControlCommand); ok {
+			if command.CommandType != runtime.CommandTypeBeginTag {
+				s.error("Unexpected ControlCommand while extracting tag from choice")
+			}
+			break
+		}
+
+		if strVal, isStringValue := obj.(*runtime.StringValue); isStringValue {
+			contentForTag = append(contentForTag, strVal.Value())
+		}
+	}
+
+	// Consume the content that was produced for this string
+	s.state.popFromOutputStream(outputCountConsumed)
+
+	var sb strings.Builder
+	for i := len(contentForTag) - 1; i >= 0; i-- {
+		sb.WriteString(contentForTag[i])
+	}
+
+	s.pushEvaluationStack(runtime.NewTag(cleanOutputWhitespace(sb.String())))
+}
+
+// endString
+// Builds a string from the output produced since the matching BeginString
+// and pushes it to the evaluation stack.
+func (s *VM) endString() {
+
+	var contentForString []string
+	var contentToRetain []runtime.Object
+
+	outputCountConsumed := 0
+	for i := len(s.state.outputStream) - 1; i >= 0; i-- {
+
+		obj := s.state.outputStream[i]
+
+		outputCountConsumed++
+
+		if command, ok := obj.(*runtime.ControlCommand); ok && command.CommandType == runtime.CommandTypeBeginString {
+			break
+		}
+
+		if _, isTag := obj.(*runtime.Tag); isTag {
+			contentToRetain = append(contentToRetain, obj)
+		}
+
+		if strVal, isStringValue := obj.(*runtime.StringValue); isStringValue {
+			contentForString = append(contentForString, strVal.Value())
+		}
+	}
+
+	// Consume the content that was produced for this string
+	s.state.popFromOutputStream(outputCountConsumed)
+
+	// Rescue the tags that we want actually to keep on the output stack
+	for i := len(contentToRetain) - 1; i >= 0; i-- {
+		s.state.pushToOutputStream(contentToRetain[i])
+	}
+
+	var sb strings.Builder
+	for i := len(contentForString) - 1; i >= 0; i-- {
+		sb.WriteString(contentForString[i])
+	}
+
+	// Return to expression evaluation (from content mode)
+	s.state.setInExpressionEvaluation(true)
+	s.pushEvaluationStack(runtime.NewStringValueFromString(sb.String()))
+}
+
+func (s *VM) turnsSinceOrReadCount(evalCommand *runtime.ControlCommand) {
+
+	target := s.popEvaluationStack()
+	divertTarget, isDivertTargetValue := target.(*runtime.DivertTargetValue)
+	if !isDivertTargetValue {
+		extraNote := ""
+		if _, isIntValue := target.(*runtime.IntValue); isIntValue {
+			extraNote = ". Did you accidentally pass a read count ('knot_name') instead of a target ('-> knot_name')?"
+		}
+		s.error("TURNS_SINCE expected a divert target (knot, stitch, label name), but saw " + target.(fmt.Stringer).String() + extraNote)
+	}
+
+	isTurnsSince := evalCommand.CommandType == runtime.CommandTypeTurnsSince
+
+	eitherCount := 0
+	if container, ok := s.program.pathContainers[divertTarget.TargetPath().String()]; ok {
+		if isTurnsSince {
+			eitherCount = s.turnsSinceForContainer(container)
+		} else {
+			eitherCount = s.visitCountForContainer(container)
+		}
+	} else {
+		if isTurnsSince {
+			eitherCount = -1 // turn count, default to never/unknown
+		}
+		s.warning("Failed to find container for " + evalCommand.String() + " lookup at " + divertTarget.TargetPath().String())
+	}
+
+	s.pushEvaluationStack(runtime.NewIntValueFromInt(eitherCount))
+}
+
+func (s *VM) random() {
+
+	maxInt, _ := s.popEvaluationStack().(*runtime.IntValue)
+	minInt, _ := s.popEvaluationStack().(*runtime.IntValue)
+
+	if minInt == nil {
+		s.error("Invalid value for minimum parameter of RANDOM(min, max)")
+	}
+
+	if maxInt == nil {
+		s.error("Invalid value for maximum parameter of RANDOM(min, max)")
+	}
+
+	// +1 because it's inclusive of min and max, for e.g. RANDOM(1,6) for a dice roll.
+	randomRange := maxInt.Value() - minInt.Value() + 1
+	if randomRange <= 0 {
+		s.error("RANDOM was called with minimum as " + fmt.Sprint(minInt.Value()) + " and maximum as " + fmt.Sprint(maxInt.Value()) + ". The maximum must be larger")
+	}
+
+	s.randomSource.Seed(s.state.storySeed + s.state.previousRandom)
+
+	nextRandom := s.randomSource.Next()
+	s.pushEvaluationStack(runtime.NewIntValueFromInt(nextRandom%randomRange + minInt.Value()))
+
+	// Next random number (rather than keeping the Random object around)
+	s.state.previousRandom = nextRandom
+}
+
+// nextSequenceShuffleIndex
+// As Story.NextSequenceShuffleIndex.
+func (s *VM) nextSequenceShuffleIndex(instruction *Instruction) int {
+
+	numElementsIntVal, _ := s.popEvaluationStack().(*runtime.IntValue)
+	if numElementsIntVal == nil {
+		s.error("expected number of elements in sequence for shuffle index")
+		return 0
+	}
+
+	numElements := numElementsIntVal.Value()
+
+	seqCountVal, _ := s.popEvaluationStack().(*runtime.IntValue)
+	seqCount := seqCountVal.Value()
+	loopIndex := seqCount / numElements
+	iterationIndex := seqCount % numElements
+
+	// Generate the same shuffle based on:
+	//  - The hash of this container, to make sure it's consistent
+	//    each time the runtime returns to the sequence
+	//  - How many times the runtime has looped around this full shuffle
+	sequenceHash := 0
+	for _, c := range s.program.Containers[instruction.Container].Path {
+		sequenceHash += int(c)
+	}
+	s.randomSource.Seed(sequenceHash + loopIndex + s.state.storySeed)
+
+	unpickedIndices := make([]int, numElements)
+	for i := range unpickedIndices {
+		unpickedIndices[i] = i
+	}
+
+	for i := 0; i <= iterationIndex; i++ {
+		chosen := s.randomSource.Next() % len(unpickedIndices)
+		chosenIndex := unpickedIndices[chosen]
+		unpickedIndices = append(unpickedIndices[:chosen], unpickedIndices[chosen+1:]...)
+
+		if i == iterationIndex {
+			return chosenIndex
+		}
+	}
+
+	panic("Should never reach here")
+}
+
+func (s *VM) listFromInt() {
+
+	intVal, _ := s.popEvaluationStack().(*runtime.IntValue)
+	listNameVal, _ := s.popEvaluationStack().(*runtime.StringValue)
+
+	if intVal == nil {
+		s.error("Passed non-integer when creating a list element from a numerical value.")
+	}
+
+	if listNameVal == nil {
+		s.error("Expected the name of a list when creating a list element from a numerical value.")
+	}
+
+	var generatedListValue *runtime.ListValue
+
+	if foundListDef, ok := s.program.listDefinitions.TryListGetDefinition(listNameVal.Value()); ok {
+		if foundItem, ok := foundListDef.TryGetItemWithValue(intVal.Value()); ok {
+			generatedListValue = runtime.NewListValueFromInkListItem(foundItem, intVal.Value())
+		}
+	} else {
+		s.error("Failed to find LIST called " + listNameVal.Value())
+	}
+
+	if generatedListValue == nil {
+		generatedListValue = runtime.NewListValue()
+	}
+
+	s.pushEvaluationStack(generatedListValue)
+}
+
+func (s *VM) listRandom() {
+
+	listVal, _ := s.popEvaluationStack().(*runtime.ListValue)
+	if listVal == nil {
+		s.error("Expected list for LIST_RANDOM")
+	}
+
+	list := listVal.Value()
+	newList := runtime.NewInkList()
+
+	// Non-empty source list? Otherwise the result is an empty list
+	if list.Count() > 0 {
+
+		// Generate a random index for the element to take
+		s.randomSource.Seed(s.state.storySeed + s.state.previousRandom)
+
+		nextRandom := s.randomSource.Next()
+		listItemIndex := nextRandom % list.Count()
+
+		// The list's Dictionary order, as Story uses
+		randomItem := list.Items()[listItemIndex]
+
+		// Origin list is simply the origin of the one element, as
+		// NewInkListFromOriginStory
+		originName := randomItem.Key.OriginName()
+		def, ok := s.program.listDefinitions.TryListGetDefinition(originName)
+		if !ok {
+			panic("InkList origin could not be found in story when constructing new list: " + originName)
+		}
+		newList.SetInitialOriginName(originName)
+		newList.Origins = []*runtime.ListDefinition{def}
+		newList.Set(randomItem.Key, randomItem.Value)
+
+		s.state.previousRandom = nextRandom
+	}
+
+	s.pushEvaluationStack(runtime.NewListValueFromList(newList))
+}
+
+func (s *VM) callExternalFunction(funcName string, numberOfArguments int) {
+
+	funcDef, foundExternal := s.externals[funcName]
+
+	// Should this function break glue? Abort run if we've already seen a newline.
+	// Set a bool to tell it to restore the snapshot at the end of this instruction.
+	if foundExternal && !funcDef.lookaheadSafe && s.snapshot != nil {
+		s.sawLookaheadUnsafeFunctionAfterNewline = true
+		return
+	}
+
+	// Try to use fallback function?
+	if !foundExternal {
+		if !s.AllowExternalFunctionFallbacks {
+			s.error("Trying to call EXTERNAL function '" + funcName + "' which has not been bound (and ink fallbacks disabled).")
+		}
+
+		fallback, ok := s.program.knots[funcName]
+		if !ok {
+			s.error("Trying to call EXTERNAL function '" + funcName + "' which has not been bound, and fallback ink function could not be found.")
+		}
+
+		s.state.push(runtime.Function, 0, len(s.state.outputStream))
+		s.state.divertedTarget = fallback
+		return
+	}
+
+	// Pop arguments, then reverse them so they're the right way round again
+	arguments := make([]interface{}, numberOfArguments)
+	for i := numberOfArguments - 1; i >= 0; i-- {
+		poppedObj, _ := s.popEvaluationStack().(runtime.Value)
+		if poppedObj == nil {
+			s.error("Expected a value to pass as an argument to EXTERNAL function '" + funcName + "'")
+		}
+		arguments[i] = poppedObj.ValueObject()
+	}
+
+	// Run the function!
+	funcResult := funcDef.function(arguments)
+
+	// Convert return value (if any) to the a type that the ink engine can use
+	var returnObj runtime.Object = runtime.NewVoid()
+	if funcResult != nil {
+		value := runtime.CreateValue(funcResult)
+		if value == nil {
+			s.error(fmt.Sprintf("Could not create ink value from returned object of type %T", funcResult))
+		}
+		returnObj = value
+	}
+
+	s.pushEvaluationStack(returnObj)
+}
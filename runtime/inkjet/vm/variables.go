@@ -0,0 +1,228 @@
+package vm
+
+import (
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// pushEvaluationStack
+// As StoryState.PushEvaluationStack.
+func (s *VM) pushEvaluationStack(obj runtime.Object) {
+
+	// Include the origin lists of list values, so that lower level functions
+	// can make use of them to get related items, or make comparisons with the
+	// integer values etc.
+	if listValue, _ := obj.(*runtime.ListValue); listValue != nil {
+
+		rawList := listValue.Value()
+		if rawList.OriginNames() != nil {
+			rawList.Origins = nil
+
+			for _, n := range rawList.OriginNames() {
+				def, _ := s.program.listDefinitions.TryListGetDefinition(n)
+				if !containsListDefinition(rawList.Origins, def) {
+					rawList.Origins = append(rawList.Origins, def)
+				}
+			}
+		}
+	}
+
+	s.state.evaluationStack = append(s.state.evaluationStack, obj)
+}
+
+func containsListDefinition(definitions []*runtime.ListDefinition, definition *runtime.ListDefinition) bool {
+
+	for _, d := range definitions {
+		if d == definition {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *VM) popEvaluationStack() runtime.Object {
+
+	if len(s.state.evaluationStack) == 0 {
+		s.error("Tried to pop from an empty evaluation stack")
+	}
+
+	obj := s.state.evaluationStack[len(s.state.evaluationStack)-1]
+	s.state.evaluationStack = s.state.evaluationStack[:len(s.state.evaluationStack)-1]
+
+	return obj
+}
+
+func (s *VM) peekEvaluationStack() runtime.Object {
+
+	return s.state.evaluationStack[len(s.state.evaluationStack)-1]
+}
+
+func (s *VM) popEvaluationStackEx(numberOfObjects int) []runtime.Object {
+
+	if numberOfObjects > len(s.state.evaluationStack) {
+		s.error("Trying to pop too many objects from the evaluation stack")
+	}
+
+	popped := s.state.evaluationStack[len(s.state.evaluationStack)-numberOfObjects:]
+	s.state.evaluationStack = s.state.evaluationStack[:len(s.state.evaluationStack)-numberOfObjects]
+
+	return popped
+}
+
+// getVariableWithName
+// As VariablesState.GetVariableWithName.
+// (default) contextIndex: -1
+func (s *VM) getVariableWithName(name string, contextIndex int) runtime.Object {
+
+	varValue := s.getRawVariableWithName(name, contextIndex)
+
+	if varPointer, _ := varValue.(*runtime.VariablePointerValue); varPointer != nil {
+		varValue = s.getVariableWithName(varPointer.Value(), varPointer.ContextIndex())
+	}
+
+	return varValue
+}
+
+// getRawVariableWithName
+// As VariablesState.GetRawVariableWithName.
+func (s *VM) getRawVariableWithName(name string, contextIndex int) runtime.Object {
+
+	// 0 context = global
+	if contextIndex == 0 || contextIndex == -1 {
+
+		if varValue, ok := s.state.globals[name]; ok {
+			return varValue
+		}
+
+		if listItemValue := s.program.listDefinitions.FindSingleItemListWithName(name); listItemValue != nil {
+			return listItemValue
+		}
+	}
+
+	return s.getTemporaryVariableWithName(name, contextIndex)
+}
+
+// contextElement
+// The callstack element for a variable context index, as used by
+// CallStack: -1 is the current element, and 1 up are the elements from
+// the bottom of the callstack.
+func (s *VM) contextElement(contextIndex int) *element {
+
+	if contextIndex == -1 {
+		contextIndex = s.state.currentElementIndex() + 1
+	}
+
+	return s.state.callstack()[contextIndex-1]
+}
+
+func (s *VM) getTemporaryVariableWithName(name string, contextIndex int) runtime.Object {
+
+	if varValue, ok := s.contextElement(contextIndex).temporaryVariables[name]; ok {
+		return varValue
+	}
+
+	return nil
+}
+
+func (s *VM) setTemporaryVariable(name string, value runtime.Object, declareNew bool, contextIndex int) {
+
+	contextElement := s.contextElement(contextIndex)
+
+	oldValue, ok := contextElement.temporaryVariables[name]
+	if !ok && !declareNew {
+		s.error("Could not find temporary variable to set: " + name)
+	}
+
+	if ok {
+		runtime.RetainListOriginsForAssignment(oldValue, value)
+	}
+
+	contextElement.temporaryVariables[name] = value
+}
+
+// contextForVariableNamed
+// As CallStack.ContextForVariableNamed.
+func (s *VM) contextForVariableNamed(name string) int {
+
+	// Current temporary context?
+	// (Shouldn't attempt to access contexts higher in the callstack.)
+	if _, ok := s.state.currentElement().temporaryVariables[name]; ok {
+		return s.state.currentElementIndex() + 1
+	}
+
+	// Global
+	return 0
+}
+
+// assign
+// As VariablesState.Assign.
+func (s *VM) assign(varAss *runtime.VariableAssignment, value runtime.Object) {
+
+	name := varAss.VariableName()
+	contextIndex := -1
+
+	// Are we assigning to a global variable?
+	setGlobal := false
+	if varAss.IsNewDeclaration() {
+		setGlobal = varAss.IsGlobal
+	} else {
+		setGlobal = s.state.globalVariableExistsWithName(name)
+	}
+
+	if varAss.IsNewDeclaration() {
+		// Constructing new variable pointer reference
+		if varPointer, _ := value.(*runtime.VariablePointerValue); varPointer != nil {
+			value = s.resolveVariablePointer(varPointer)
+		}
+	} else {
+		// Assign to existing variable pointer?
+		// Then assign to the variable that the pointer is pointing to by name.
+		for {
+			existingPointer, _ := s.getRawVariableWithName(name, contextIndex).(*runtime.VariablePointerValue)
+			if existingPointer == nil {
+				break
+			}
+			name = existingPointer.Value()
+			contextIndex = existingPointer.ContextIndex()
+			setGlobal = contextIndex == 0
+		}
+	}
+
+	if setGlobal {
+		s.state.setGlobal(name, value)
+	} else {
+		s.setTemporaryVariable(name, value, varAss.IsNewDeclaration(), contextIndex)
+	}
+}
+
+// resolveVariablePointer
+// As VariablesState.ResolveVariablePointer.
+func (s *VM) resolveVariablePointer(varPointer *runtime.VariablePointerValue) *runtime.VariablePointerValue {
+
+	contextIndex := varPointer.ContextIndex()
+
+	if contextIndex == -1 {
+		contextIndex = s.contextIndexOfVariableNamed(varPointer.Value())
+	}
+
+	valueOfVariablePointedTo := s.getRawVariableWithName(varPointer.Value(), contextIndex)
+
+	// Extra layer of indirection: when accessing a pointer to a pointer,
+	// return the final target rather than creating a chain
+	if doubleRedirectionPointer, _ := valueOfVariablePointedTo.(*runtime.VariablePointerValue); doubleRedirectionPointer != nil {
+		return doubleRedirectionPointer
+	}
+
+	return runtime.NewVariablePointerValueFromValue(varPointer.Value(), contextIndex)
+}
+
+// contextIndexOfVariableNamed
+// As VariablesState.GetContextIndexOfVariableNamed.
+func (s *VM) contextIndexOfVariableNamed(varName string) int {
+
+	if s.state.globalVariableExistsWithName(varName) {
+		return 0
+	}
+
+	return s.state.currentElementIndex()
+}
@@ -0,0 +1,499 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/SirMetathyst/go-ink/runtime"
+)
+
+// Choice
+// A choice generated by the VM, as runtime.Choice.
+type Choice struct {
+	Text  string
+	Index int
+	Tags  []string
+
+	isInvisibleDefault bool
+	target             int
+	thread             *thread
+}
+
+type externalFunction struct {
+	function      func(args []interface{}) interface{}
+	lookaheadSafe bool
+}
+
+// VM
+// Plays a Program. It behaves as a runtime.Story playing the story that
+// the Program was compiled from: the same calls produce the same text, tags,
+// choices and errors. A VM isn't safe for concurrent use.
+type VM struct {
+	// OnError
+	// As Story.OnError. Without a handler, ink errors are returned from
+	// Continue as a *runtime.StoryErrors.
+	OnError *runtime.ErrorHandlerEvent
+
+	// AllowExternalFunctionFallbacks
+	// As Story.AllowExternalFunctionFallbacks.
+	AllowExternalFunctionFallbacks bool
+
+	program *Program
+	state   *state
+
+	// The state as it was at the last newline, kept while looking ahead
+	// for glue, as Story._stateSnapshotAtLastNewline
+	snapshot                               *state
+	sawLookaheadUnsafeFunctionAfterNewline bool
+
+	externals             map[string]*externalFunction
+	hasValidatedExternals bool
+	randomSource          runtime.RandomSource
+}
+
+// NewVM
+// A VM at the start of the program, with its global variables declared.
+func NewVM(program *Program) (*VM, error) {
+
+	s := &VM{
+		program:      program,
+		state:        newState(program),
+		externals:    make(map[string]*externalFunction),
+		randomSource: runtime.NewDotNetRandom(0),
+	}
+
+	// Seed the same way as StoryState
+	timeSeed := time.Now().UnixNano() / 1e6
+	s.state.storySeed = rand.New(rand.NewSource(timeSeed)).Intn(100)
+
+	// As Story.ResetGlobals
+	if program.globalDecl != -1 {
+		originalPc := s.state.currentPc()
+
+		s.choosePath(program.globalDecl, false)
+
+		if err := s.continueInternal(); err != nil {
+			return nil, err
+		}
+
+		s.state.setCurrentPc(originalPc)
+	}
+
+	return s, nil
+}
+
+// Program
+// The program that the VM is playing.
+func (s *VM) Program() *Program {
+
+	return s.program
+}
+
+// SetStorySeed
+// Sets the seed behind RANDOM, LIST_RANDOM and shuffle sequences, as
+// StoryState.StorySeed. A fixed seed makes them repeatable.
+func (s *VM) SetStorySeed(seed int) {
+
+	s.state.storySeed = seed
+}
+
+// SetRandomSource
+// As Story.SetRandomSource. Passing nil restores the default DotNetRandom.
+func (s *VM) SetRandomSource(source runtime.RandomSource) {
+
+	if source == nil {
+		source = runtime.NewDotNetRandom(0)
+	}
+
+	s.randomSource = source
+}
+
+// BindExternalFunction
+// As Story.BindExternalFunctionalGeneral. Arguments are passed, and the
+// result converted, in the same way.
+// (default) lookaheadSafe: true
+func (s *VM) BindExternalFunction(funcName string, function func(args []interface{}) interface{}, lookaheadSafe bool) error {
+
+	if _, ok := s.externals[funcName]; ok {
+		return errors.New("function '" + funcName + "' has already been bound")
+	}
+
+	s.externals[funcName] = &externalFunction{
+		function:      function,
+		lookaheadSafe: lookaheadSafe,
+	}
+
+	return nil
+}
+
+// ValidateExternalBindings
+// As Story.ValidateExternalBindings, which this is also called on the
+// first call to Continue.
+func (s *VM) ValidateExternalBindings() error {
+
+	s.hasValidatedExternals = true
+
+	var missingExternals []string
+	for _, name := range s.program.externals {
+		if _, ok := s.externals[name]; ok {
+			continue
+		}
+		if _, ok := s.program.knots[name]; ok && s.AllowExternalFunctionFallbacks {
+			continue
+		}
+		missingExternals = append(missingExternals, name)
+	}
+
+	if len(missingExternals) == 0 {
+		return nil
+	}
+
+	return &runtime.UnboundExternalsError{
+		Names:                          missingExternals,
+		AllowExternalFunctionFallbacks: s.AllowExternalFunctionFallbacks,
+	}
+}
+
+// CanContinue
+// As Story.CanContinue.
+func (s *VM) CanContinue() bool {
+
+	return s.state.currentPc() != -1 && !s.state.hasError()
+}
+
+// CurrentText
+// The latest line of text to be generated from a Continue() call.
+func (s *VM) CurrentText() string {
+
+	return s.state.text()
+}
+
+// CurrentTags
+// The tags seen during the latest Continue() call.
+func (s *VM) CurrentTags() []string {
+
+	return s.state.tags()
+}
+
+// CurrentChoices
+// The choices available at the current point in the story, not including
+// invisible default choices.
+func (s *VM) CurrentChoices() []*Choice {
+
+	choices := []*Choice{}
+	if s.CanContinue() {
+		return choices
+	}
+
+	for _, c := range s.state.currentChoices {
+		if !c.isInvisibleDefault {
+			c.Index = len(choices)
+			choices = append(choices, c)
+		}
+	}
+
+	return choices
+}
+
+// Continue
+// As Story.Continue.
+func (s *VM) Continue() (string, error) {
+
+	if !s.hasValidatedExternals {
+		if err := s.ValidateExternalBindings(); err != nil {
+			return "", err
+		}
+	}
+
+	if err := s.continueInternal(); err != nil {
+		return "", err
+	}
+
+	return s.CurrentText(), nil
+}
+
+// ContinueMaximally
+// As Story.ContinueMaximally.
+func (s *VM) ContinueMaximally() (string, error) {
+
+	var sb strings.Builder
+
+	for s.CanContinue() {
+		text, err := s.Continue()
+		sb.WriteString(text)
+		if err != nil {
+			return sb.String(), err
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// ChooseChoiceIndex
+// As Story.ChooseChoiceIndex.
+func (s *VM) ChooseChoiceIndex(choiceIdx int) (err error) {
+
+	defer recoverStoryException(&err)
+
+	choices := s.CurrentChoices()
+	if choiceIdx < 0 || choiceIdx >= len(choices) {
+		return fmt.Errorf("choice out of range: %d (%d choices available)", choiceIdx, len(choices))
+	}
+
+	choiceToChoose := choices[choiceIdx]
+	s.state.setCurrentThread(choiceToChoose.thread)
+
+	s.choosePath(choiceToChoose.target, true)
+	return nil
+}
+
+func (s *VM) continueInternal() error {
+
+	if !s.CanContinue() {
+		return runtime.ErrCannotContinue
+	}
+
+	s.state.didSafeExit = false
+	s.state.resetOutput()
+	s.sawLookaheadUnsafeFunctionAfterNewline = false
+
+	outputStreamEndsInNewline := false
+
+	for do := true; do; do = s.CanContinue() {
+
+		var err error
+		outputStreamEndsInNewline, err = s.tryContinueSingleStep()
+		if e, ok := err.(*runtime.StoryException); ok {
+			s.addError(e.Message, false, e.UseEndLineNumber)
+			break
+		}
+
+		if outputStreamEndsInNewline {
+			break
+		}
+	}
+
+	// Need to rewind, due to evaluating further than we should?
+	if s.snapshot != nil {
+		s.restoreStateSnapshot()
+	}
+
+	// Finished a section of content / reached a choice point?
+	if !s.CanContinue() {
+		if s.state.canPopThread() {
+			s.addError("Thread available to pop, threads should always be flat by the end of evaluation?", false, false)
+		}
+
+		if len(s.state.currentChoices) == 0 && !s.state.didSafeExit {
+			if s.state.canPopWith(runtime.Tunnel) {
+				s.addError("unexpectedly reached end of content. Do you need a '->->' to return from a tunnel?", false, false)
+			} else if s.state.canPopWith(runtime.Function) {
+				s.addError("unexpectedly reached end of content. Do you need a '~ return'?", false, false)
+			} else if !s.state.canPop() {
+				s.addError("ran out of content. Do you need a '-> DONE' or '-> END'?", false, false)
+			} else {
+				s.addError("unexpectedly reached end of content for unknown reason. Please debug compiler!", false, false)
+			}
+		}
+	}
+
+	s.state.didSafeExit = false
+	s.sawLookaheadUnsafeFunctionAfterNewline = false
+
+	if s.state.hasError() || s.state.hasWarning() {
+		if s.OnError != nil {
+			for _, err := range s.state.currentErrors {
+				s.OnError.Emit(err.Error(), err.Type)
+			}
+			for _, err := range s.state.currentWarnings {
+				s.OnError.Emit(err.Error(), err.Type)
+			}
+			s.state.resetErrors()
+		} else {
+			return &runtime.StoryErrors{
+				Errors:   runtime.NewSliceFromSlice(s.state.currentErrors),
+				Warnings: runtime.NewSliceFromSlice(s.state.currentWarnings),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *VM) tryContinueSingleStep() (outputStreamEndsInNewline bool, err error) {
+
+	defer recoverStoryException(&err)
+	return s.continueSingleStep(), nil
+}
+
+// continueSingleStep
+// As Story.ContinueSingleStep: steps once, then decides whether a newline
+// that was seen earlier really was the end of the line.
+func (s *VM) continueSingleStep() bool {
+
+	s.step()
+
+	// Run out of content and we have a default invisible choice that we can follow?
+	if !s.CanContinue() {
+		s.tryFollowDefaultInvisibleChoice()
+	}
+
+	// Don't save/rewind during string evaluation, which is e.g. used for choices
+	if s.state.inStringEvaluation() {
+		return false
+	}
+
+	// We previously found a newline, but were we just double checking that
+	// it wouldn't immediately be removed by glue?
+	if s.snapshot != nil {
+
+		change := newlineOutputStateChange(
+			s.snapshot.text(), s.state.text(),
+			len(s.snapshot.tags()), len(s.state.tags()))
+
+		if change == runtime.ExtendedBeyondNewline || s.sawLookaheadUnsafeFunctionAfterNewline {
+			s.restoreStateSnapshot()
+
+			// Hit a newline for sure, we're done
+			return true
+		}
+
+		if change == runtime.NewlineRemoved {
+			s.snapshot = nil
+		}
+	}
+
+	// Current content ends in a newline - approaching end of our evaluation
+	if s.state.outputStreamEndsInNewline() {
+		if s.CanContinue() {
+			if s.snapshot == nil {
+				s.stateSnapshot()
+			}
+		} else {
+			s.snapshot = nil
+		}
+	}
+
+	return false
+}
+
+// newlineOutputStateChange
+// As Story.CalculateNewlineOutputStateChange.
+func newlineOutputStateChange(prevText string, currText string, prevTagCount int, currTagCount int) runtime.OutputStateChange {
+
+	newlineStillExists := len(currText) >= len(prevText) && len(prevText) > 0 && currText[len(prevText)-1] == '\n'
+
+	if prevTagCount == currTagCount && len(prevText) == len(currText) && newlineStillExists {
+		return runtime.NoChange
+	}
+
+	if !newlineStillExists {
+		return runtime.NewlineRemoved
+	}
+
+	if currTagCount > prevTagCount {
+		return runtime.ExtendedBeyondNewline
+	}
+
+	for i := len(prevText); i < len(currText); i++ {
+		c := currText[i]
+		if c != ' ' && c != '\t' {
+			return runtime.ExtendedBeyondNewline
+		}
+	}
+
+	return runtime.NoChange
+}
+
+func (s *VM) stateSnapshot() {
+
+	s.snapshot = s.state
+	s.state = s.state.copy()
+}
+
+func (s *VM) restoreStateSnapshot() {
+
+	s.state = s.snapshot
+	s.snapshot = nil
+}
+
+// error
+// Raises a StoryException, which is recovered by the public entry point
+// that is currently running and reported as an ink error.
+func (s *VM) error(message string) {
+	panic(runtime.NewStoryException(message))
+}
+
+func (s *VM) warning(message string) {
+
+	s.addError(message, true, false)
+}
+
+// addError
+// As Story.AddError.
+func (s *VM) addError(message string, isWarning bool, useEndLineNumber bool) {
+
+	rtErr := &runtime.RuntimeError{
+		Message:          message,
+		Type:             runtime.ErrorTypeError,
+		DebugMetadata:    s.currentDebugMetadata(),
+		UseEndLineNumber: useEndLineNumber,
+	}
+
+	if isWarning {
+		rtErr.Type = runtime.ErrorTypeWarning
+	}
+
+	if pc := s.state.currentPc(); rtErr.DebugMetadata == nil && pc != -1 {
+		rtErr.Path = s.program.InstructionPath(pc)
+	}
+
+	s.state.addError(rtErr)
+
+	// In a broken state don't need to know about any other errors.
+	if !isWarning {
+		s.state.forceEnd()
+	}
+}
+
+// currentDebugMetadata
+// As Story.CurrentDebugMetadata.
+func (s *VM) currentDebugMetadata() *runtime.DebugMetadata {
+
+	callstack := s.state.callstack()
+
+	// The current element first, then up the callstack
+	for i := len(callstack) - 1; i >= 0; i-- {
+		if pc := callstack[i].pc; pc != -1 {
+			if obj := s.program.Instructions[pc].Object; obj != nil {
+				if dm := obj.DebugMetadata(); dm != nil {
+					return dm
+				}
+			}
+		}
+	}
+
+	for i := len(s.state.outputStream) - 1; i >= 0; i-- {
+		if dm := s.state.outputStream[i].DebugMetadata(); dm != nil {
+			return dm
+		}
+	}
+
+	return nil
+}
+
+// recoverStoryException
+// Recovers a StoryException raised by error, returning it as err.
+func recoverStoryException(err *error) {
+
+	if r := recover(); r != nil {
+		if e, ok := r.(*runtime.StoryException); ok {
+			*err = e
+			return
+		}
+		panic(r)
+	}
+}
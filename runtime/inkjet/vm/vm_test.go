@@ -0,0 +1,299 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SirMetathyst/go-ink/internal/conformance"
+	"github.com/SirMetathyst/go-ink/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	conformanceDir   = "../../testdata/conformance"
+	theInterceptPath = "../../../cmd/ink-player/TheIntercept.json"
+)
+
+// unsupportedFixtures
+// Conformance fixtures that use features the VM doesn't have.
+var unsupportedFixtures = map[string]string{
+	"multi_flow": "the VM has a single flow",
+	"save_load":  "the VM can't save and load state",
+}
+
+// conformanceSetups
+// The same game-side setup as the runtime's conformance tests.
+var conformanceSetups = map[string]func(vm *VM) error{
+	"externals": func(vm *VM) error {
+		vm.AllowExternalFunctionFallbacks = true
+
+		if err := vm.BindExternalFunction("multiply", func(args []interface{}) interface{} {
+			return args[0].(int) * args[1].(int)
+		}, true); err != nil {
+			return err
+		}
+
+		return vm.BindExternalFunction("greeting", func(args []interface{}) interface{} {
+			return "Hello from Go"
+		}, true)
+	},
+}
+
+func newTestStoryFromFile(t testing.TB, path string) *runtime.Story {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	story, err := runtime.LoadStory(f)
+	require.NoError(t, err)
+
+	return story
+}
+
+func newTestVM(t testing.TB, story *runtime.Story) *VM {
+	t.Helper()
+
+	program, err := Compile(story)
+	require.NoError(t, err)
+
+	vm, err := NewVM(program)
+	require.NoError(t, err)
+
+	return vm
+}
+
+// TestConformance
+// Plays the runtime's conformance fixtures on the VM, which must produce
+// the runtime's golden transcripts exactly.
+func TestConformance(t *testing.T) {
+
+	paths, err := filepath.Glob(filepath.Join(conformanceDir, "*.ink.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".ink.json")
+
+		t.Run(name, func(t *testing.T) {
+
+			if reason, ok := unsupportedFixtures[name]; ok {
+				t.Skip(reason)
+			}
+
+			commands, err := conformance.ReadScript(filepath.Join(conformanceDir, name+".script"))
+			require.NoError(t, err)
+
+			run := &conformanceRun{vm: newTestVM(t, newTestStoryFromFile(t, path))}
+			require.NoError(t, run.setup(conformanceSetups[name]))
+
+			transcript := &conformance.Transcript{Story: run, Errors: run.errors}
+			transcript.Play()
+			for _, command := range commands {
+				require.NoError(t, transcript.Exec(command))
+			}
+
+			golden, err := os.ReadFile(filepath.Join(conformanceDir, name+".golden"))
+			require.NoError(t, err)
+			assert.Equal(t, string(golden), transcript.String())
+		})
+	}
+}
+
+// conformanceRun
+// Plays a conformance story on the VM for a conformance.Transcript. The
+// VM has a single flow and can't save, so only choose is supported.
+type conformanceRun struct {
+	vm *VM
+
+	pendingErrors []string
+}
+
+func (s *conformanceRun) setup(setup func(vm *VM) error) error {
+
+	// Fixed so that shuffles and RANDOM are repeatable
+	s.vm.SetStorySeed(0)
+
+	s.vm.OnError = new(runtime.ErrorHandlerEvent)
+	s.vm.OnError.Register(func(message string, typ runtime.ErrorType) {
+		kind := "error"
+		if typ == runtime.ErrorTypeWarning {
+			kind = "warning"
+		}
+		s.pendingErrors = append(s.pendingErrors, kind+": "+message)
+	})
+
+	if setup != nil {
+		return setup(s.vm)
+	}
+
+	return nil
+}
+
+func (s *conformanceRun) errors() []string {
+
+	errs := s.pendingErrors
+	s.pendingErrors = nil
+
+	return errs
+}
+
+func (s *conformanceRun) CanContinue() bool {
+	return s.vm.CanContinue()
+}
+
+func (s *conformanceRun) Continue() (string, error) {
+	return s.vm.Continue()
+}
+
+func (s *conformanceRun) CurrentTags() []string {
+	return s.vm.CurrentTags()
+}
+
+func (s *conformanceRun) CurrentChoices() []conformance.Choice {
+
+	var choices []conformance.Choice
+	for _, choice := range s.vm.CurrentChoices() {
+		choices = append(choices, conformance.Choice{Index: choice.Index, Text: choice.Text, Tags: choice.Tags})
+	}
+
+	return choices
+}
+
+func (s *conformanceRun) ChooseChoiceIndex(index int) error {
+	return s.vm.ChooseChoiceIndex(index)
+}
+
+func (s *conformanceRun) ChoosePathString(path string) error {
+	return conformance.ErrUnsupported
+}
+
+func (s *conformanceRun) SwitchFlow(flowName string) error {
+	return conformance.ErrUnsupported
+}
+
+func (s *conformanceRun) SwitchToDefaultFlow() error {
+	return conformance.ErrUnsupported
+}
+
+func (s *conformanceRun) RemoveFlow(flowName string) error {
+	return conformance.ErrUnsupported
+}
+
+func (s *conformanceRun) Reload() error {
+	return conformance.ErrUnsupported
+}
+
+// TestPlaysTheIntercept
+// Plays TheIntercept side by side on the runtime and the VM, taking a
+// different route through the choices each time.
+func TestPlaysTheIntercept(t *testing.T) {
+
+	for route := 0; route < 3; route++ {
+
+		story := newTestStoryFromFile(t, theInterceptPath)
+		vm := newTestVM(t, newTestStoryFromFile(t, theInterceptPath))
+
+		story.State().StorySeed = route
+		vm.SetStorySeed(route)
+
+		for turn := 0; turn < 200; turn++ {
+
+			for story.CanContinue() {
+				expected, err := story.Continue()
+				require.NoError(t, err)
+				require.True(t, vm.CanContinue(), "route %d turn %d", route, turn)
+				text, err := vm.Continue()
+				require.NoError(t, err)
+				require.Equal(t, expected, text, "route %d turn %d", route, turn)
+				require.Equal(t, story.CurrentTags(), vm.CurrentTags(), "route %d turn %d", route, turn)
+			}
+			require.False(t, vm.CanContinue())
+
+			choices := story.CurrentChoices()
+			require.Len(t, vm.CurrentChoices(), len(choices), "route %d turn %d", route, turn)
+			if len(choices) == 0 {
+				break
+			}
+			for i, choice := range choices {
+				require.Equal(t, choice.Text, vm.CurrentChoices()[i].Text)
+			}
+
+			index := (turn * (route + 1)) % len(choices)
+			require.NoError(t, story.ChooseChoiceIndex(index))
+			require.NoError(t, vm.ChooseChoiceIndex(index))
+		}
+	}
+}
+
+func TestChooseChoiceIndexOutOfRange(t *testing.T) {
+
+	vm := newTestVM(t, newTestStoryFromFile(t, theInterceptPath))
+	_, err := vm.ContinueMaximally()
+	require.NoError(t, err)
+
+	assert.Error(t, vm.ChooseChoiceIndex(len(vm.CurrentChoices())))
+	assert.Error(t, vm.ChooseChoiceIndex(-1))
+}
+
+// BenchmarkPlayTheIntercept and BenchmarkPlayTheInterceptRuntime
+// Play the same route through TheIntercept on the VM and on the runtime.
+func BenchmarkPlayTheIntercept(b *testing.B) {
+
+	program, err := Compile(newTestStoryFromFile(b, theInterceptPath))
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm, err := NewVM(program)
+		if err != nil {
+			b.Fatal(err)
+		}
+		vm.SetStorySeed(0)
+
+		for turn := 0; turn < 50; turn++ {
+			if _, err := vm.ContinueMaximally(); err != nil {
+				b.Fatal(err)
+			}
+			choices := vm.CurrentChoices()
+			if len(choices) == 0 {
+				break
+			}
+			if err := vm.ChooseChoiceIndex(turn % len(choices)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkPlayTheInterceptRuntime(b *testing.B) {
+
+	story := newTestStoryFromFile(b, theInterceptPath)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := story.ResetState(); err != nil {
+			b.Fatal(err)
+		}
+		story.State().StorySeed = 0
+
+		for turn := 0; turn < 50; turn++ {
+			if _, err := story.ContinueMaximally(); err != nil {
+				b.Fatal(err)
+			}
+			choices := story.CurrentChoices()
+			if len(choices) == 0 {
+				break
+			}
+			if err := story.ChooseChoiceIndex(turn % len(choices)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -23,9 +23,9 @@ func JArrayToRuntimeObjList[T any](jArray []interface{}, skipLast bool) []T {
 func WriteDictionaryRuntimeObjs(writer *Writer, dictionary map[string]Object) {
 
 	writer.WriteObjectStart()
-	for k, v := range dictionary {
+	for _, k := range SortedKeys(dictionary) {
 		writer.WritePropertyStart(k)
-		WriteRuntimeObject(writer, v)
+		WriteRuntimeObject(writer, dictionary[k])
 		writer.WritePropertyEnd()
 	}
 	writer.WriteObjectEnd()
@@ -41,8 +41,8 @@ func WriteListRuntimeObjs(writer *Writer, list []Object) {
 
 func WriteIntDictionary(writer *Writer, dict map[string]int) {
 	writer.WriteObjectStart()
-	for k, v := range dict {
-		writer.WriteIntProperty(k, v)
+	for _, k := range SortedKeys(dict) {
+		writer.WriteIntProperty(k, dict[k])
 	}
 	writer.WriteObjectEnd()
 }
@@ -160,7 +160,7 @@ func WriteRuntimeObject(writer *Writer, obj Object) {
 		return
 	}
 
-	glue, _ := obj.(*VariablePointerValue)
+	glue, _ := obj.(*Glue)
 	if glue != nil {
 		writer.WriteString("<>", true)
 		return
@@ -325,7 +325,6 @@ func JTokenToRuntimeObject(token interface{}) Object {
 	_, isBool := token.(bool)
 
 	if isInt || isFloat || isBool {
-		fmt.Println("Create Value: ", token)
 		return CreateValue(token)
 	}
 
@@ -335,19 +334,16 @@ func JTokenToRuntimeObject(token interface{}) Object {
 		firstChar := str[0]
 
 		if firstChar == '^' {
-			fmt.Println("String value", str[1:])
 			return NewStringValueFromString(str[1:])
 		}
 
 		// String value (newline)
 		if firstChar == '\n' && len(str) == 1 {
-			fmt.Println("String value: \\n")
 			return NewStringValueFromString("\n")
 		}
 
 		// Glue
 		if str == "<>" {
-			fmt.Println("Glue")
 			return NewGlue()
 		}
 
@@ -355,7 +351,6 @@ func JTokenToRuntimeObject(token interface{}) Object {
 		for i := 0; i < len(controlCommandNames); i++ {
 			cmdName, isInMap := controlCommandNames[CommandType(i)]
 			if str == cmdName {
-				fmt.Println("CommandType: ", CommandType(i))
 				return NewControlCommand(CommandType(i))
 			}
 			if !isInMap {
@@ -371,24 +366,20 @@ func JTokenToRuntimeObject(token interface{}) Object {
 			str = "^"
 		}
 		if CallExistsWithName(str) {
-			fmt.Println("Native Function: ", str)
 			return NewNativeFunctionCallFromName(str)
 		}
 
 		// Pop
 		if str == "->->" {
-			fmt.Println("Pop: ->->")
-			return NewPopFunctionCommand()
+			return NewPopTunnelCommand()
 		}
 
 		if str == "~ret" {
-			fmt.Println("~ret", str)
 			return NewPopFunctionCommand()
 		}
 
 		// Void
 		if str == "void" {
-			fmt.Println("Void")
 			return NewVoid()
 		}
 	}
@@ -397,7 +388,6 @@ func JTokenToRuntimeObject(token interface{}) Object {
 
 		// Divert target value to path
 		if propValue, ok := obj["^->"]; ok {
-			fmt.Println("Divert Target", propValue.(string))
 			//path := NewPathFromString(propValue.(string))
 			//fmt.Println("Path Resolve: ", path.String())
 			return NewDivertTargetValueFromPath(NewPathFromString(propValue.(string)))
@@ -410,7 +400,6 @@ func JTokenToRuntimeObject(token interface{}) Object {
 			if propValue, ok = obj["ci"]; ok {
 				varPtr.SetContextIndex(propValue.(int))
 			}
-			fmt.Println("VariablePointerValue: ", varPtr.String())
 			return varPtr
 		}
 
@@ -476,19 +465,16 @@ func JTokenToRuntimeObject(token interface{}) Object {
 				choice.SetFlags(propValue.(int))
 			}
 
-			fmt.Println("Choice: ", choice.String())
 			return choice
 		}
 
 		// Variable reference
 		if propValue, ok = obj["VAR?"]; ok {
-			fmt.Println("Variable reference: ", propValue.(string))
 			return NewVariableReferenceFromName(propValue.(string))
 		} else if propValue, ok = obj["CNT?"]; ok {
 			readCountVarRef := NewVariableReference()
 			readCountVarRef.SetPathStringForCount(propValue.(string))
 
-			fmt.Println("Variable reference: ", readCountVarRef.String())
 			return readCountVarRef
 		}
 
@@ -509,13 +495,11 @@ func JTokenToRuntimeObject(token interface{}) Object {
 			varAss := NewVariableAssignment(varName, isNewDecl)
 			varAss.IsGlobal = isGlobalVar
 
-			fmt.Println("Variable Assignment: ", varAss.String())
 			return varAss
 		}
 
 		// Legacy Tag with text
 		if propValue, ok = obj["#"]; ok {
-			fmt.Println("Tag: ", propValue.(string))
 			return NewTag(propValue.(string))
 		}
 
@@ -531,14 +515,18 @@ func JTokenToRuntimeObject(token interface{}) Object {
 					nameAsStr = append(nameAsStr, v.(string))
 				}
 				rawList.SetInitialOriginNames(nameAsStr)
-				for k, v := range listContent {
-					item := NewInkListFromFullname(k)
-					val := v.(int)
-					rawList.Add(item, val)
-				}
-				fmt.Println("Ink List: ", rawList)
-				return NewListValueFromList(rawList)
 			}
+			// The order the items were written in is lost, so they're
+			// added in value order, as ToJson writes them
+			items := make([]KeyValuePair[InkListItem, int], 0, len(listContent))
+			for k, v := range listContent {
+				items = append(items, KeyValuePair[InkListItem, int]{NewInkListFromFullname(k), v.(int)})
+			}
+			sortListItems(items)
+			for _, item := range items {
+				rawList.Add(item.Key, item.Value)
+			}
+			return NewListValueFromList(rawList)
 		}
 
 		// Used when serialising save state only
@@ -551,12 +539,10 @@ func JTokenToRuntimeObject(token interface{}) Object {
 	// Array is always a Runtime.Container
 	if obj, ok := token.([]interface{}); ok {
 
-		fmt.Println("Container: ")
 		return JArrayToContainer(obj)
 	}
 
 	if token == nil {
-		fmt.Println("Nil Token")
 		return nil
 	}
 
@@ -587,8 +573,8 @@ func WriteRuntimeContainer(writer *Writer, container *Container, withoutName boo
 	}
 
 	if namedOnlyContent != nil {
-		for name, namedContainerInterface := range namedOnlyContent {
-			namedContainer, _ := namedContainerInterface.(*Container)
+		for _, name := range SortedKeys(namedOnlyContent) {
+			namedContainer, _ := namedOnlyContent[name].(*Container)
 			writer.WritePropertyStart(name)
 			WriteRuntimeContainer(writer, namedContainer, true)
 			writer.WritePropertyEnd()
@@ -652,13 +638,11 @@ func JArrayToContainer(jArray []interface{}) *Container {
 func JObjectToChoice(jObj map[string]interface{}) *Choice {
 
 	choice := NewChoice()
-	choice.Text = jObj["text"].(fmt.Stringer).String()
+	choice.Text = jObj["text"].(string)
 	choice.Index = jObj["index"].(int)
-	choice.SourcePath = jObj["originalChoicePath"].(fmt.Stringer).String()
+	choice.SourcePath = jObj["originalChoicePath"].(string)
 	choice.OriginalTheadIndex = jObj["originalThreadIndex"].(int)
-	choice.SetPathStringOnChoice(jObj["targetPath"].(fmt.Stringer).String())
-
-	fmt.Println("Choice: ", choice)
+	choice.SetPathStringOnChoice(jObj["targetPath"].(string))
 
 	return choice
 }
@@ -681,7 +665,9 @@ func WriteInkList(writer *Writer, listVal *ListValue) {
 	writer.WritePropertyStart("list")
 	writer.WriteObjectStart()
 
-	for item, itemVal := range rawList._items {
+	for _, orderedItem := range rawList.OrderedItems() {
+
+		item, itemVal := orderedItem.Key, orderedItem.Value
 
 		writer.WritePropertyNameStart()
 
@@ -0,0 +1,43 @@
+package runtime
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadingAndPlayingPrintNothing
+// The runtime is a library, so loading and playing a story mustn't
+// write anything to standard output.
+func TestLoadingAndPlayingPrintNothing(t *testing.T) {
+
+	b, err := os.ReadFile("../cmd/ink-player/TheIntercept.json")
+	require.NoError(t, err)
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	// Read as it's written, so the pipe can't fill up
+	printed := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		printed <- string(out)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	story, err := NewStory(string(b))
+	require.NoError(t, err)
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+
+	os.Stdout = stdout
+	require.NoError(t, w.Close())
+
+	assert.Empty(t, <-printed)
+}
@@ -1,5 +1,7 @@
 package runtime
 
+import "sort"
+
 type ListDefinition struct {
 
 	// Private
@@ -28,6 +30,30 @@ func (s *ListDefinition) Items() map[InkListItem]int {
 	return s._items
 }
 
+// OrderedItems
+// The items sorted by value, and then by name.
+func (s *ListDefinition) OrderedItems() []KeyValuePair[InkListItem, int] {
+
+	ordered := make([]KeyValuePair[InkListItem, int], 0, len(s.Items()))
+	for item, value := range s.Items() {
+		ordered = append(ordered, KeyValuePair[InkListItem, int]{item, value})
+	}
+
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].Value == ordered[j].Value {
+			return ordered[i].Key.ItemName() < ordered[j].Key.ItemName()
+		}
+		return ordered[i].Value < ordered[j].Value
+	})
+
+	return ordered
+}
+
+func (s *ListDefinition) ContainsItemWithName(itemName string) bool {
+	_, ok := s._itemNameToValues[itemName]
+	return ok
+}
+
 func (s *ListDefinition) TryGetItemWithValue(val int) (InkListItem, bool) {
 
 	for key, value := range s._itemNameToValues {
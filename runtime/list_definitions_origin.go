@@ -1,5 +1,7 @@
 package runtime
 
+import "sort"
+
 type ListDefinitionsOrigin struct {
 
 	// Private
@@ -18,6 +20,18 @@ func (s *ListDefinitionsOrigin) Lists() []*ListDefinition {
 	return listOfLists
 }
 
+// OrderedLists
+// The list definitions sorted by name.
+func (s *ListDefinitionsOrigin) OrderedLists() []*ListDefinition {
+
+	lists := s.Lists()
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].Name() < lists[j].Name()
+	})
+
+	return lists
+}
+
 func NewListDefinitionsOrigin(lists []*ListDefinition) *ListDefinitionsOrigin {
 
 	newListDefinitionsOrigin := new(ListDefinitionsOrigin)
@@ -0,0 +1,330 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Standard library marshaling
+//
+// StoryState, InkList, ListValue, Choice and Path implement json.Marshaler,
+// json.Unmarshaler, encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler, so that they can be stored as part of a
+// larger Go value. The JSON is the same as in an ink save, and the binary
+// form uses the same layout as a binary save, under an "INKV" identifier
+// for the standalone values.
+
+// binaryValueIdentifier
+// The first bytes of a single value written by MarshalBinary.
+const binaryValueIdentifier = "INKV"
+
+// errStateWithoutStory
+// A StoryState can only be loaded in the context of the story it's for,
+// so a zero StoryState allocated by encoding/json can't be unmarshaled.
+var errStateWithoutStory = errors.New("ink: can't unmarshal into a StoryState without a Story; unmarshal into story.State() instead")
+
+// MarshalJSON
+// Implements json.Marshaler with the same JSON as ToJson.
+func (s *StoryState) MarshalJSON() ([]byte, error) {
+
+	save, err := s.ToJson()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(save), nil
+}
+
+// UnmarshalJSON
+// Implements json.Unmarshaler by loading the save as LoadJson does. The
+// state must belong to a Story, so set the field to story.State() before
+// unmarshaling into it.
+func (s *StoryState) UnmarshalJSON(data []byte) error {
+
+	if s._story == nil {
+		return errStateWithoutStory
+	}
+
+	return s.LoadJson(string(data))
+}
+
+// MarshalBinary
+// Implements encoding.BinaryMarshaler with the same data as WriteBinary.
+func (s *StoryState) MarshalBinary() ([]byte, error) {
+
+	var buf bytes.Buffer
+	if err := s.WriteBinary(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary
+// Implements encoding.BinaryUnmarshaler by loading the save as LoadBinary
+// does. As with UnmarshalJSON, the state must belong to a Story.
+func (s *StoryState) UnmarshalBinary(data []byte) error {
+
+	if s._story == nil {
+		return errStateWithoutStory
+	}
+
+	return s.LoadBinary(data)
+}
+
+// MarshalJSON
+// Implements json.Marshaler, writing the list as it's written in a save.
+func (s *InkList) MarshalJSON() ([]byte, error) {
+	return NewListValueFromList(s).MarshalJSON()
+}
+
+// UnmarshalJSON
+// Implements json.Unmarshaler. Only the names of the list's origins are
+// saved, so Origins is left empty.
+func (s *InkList) UnmarshalJSON(data []byte) error {
+
+	list, err := unmarshalInkListJson(data)
+	if err != nil {
+		return err
+	}
+
+	*s = *list
+	return nil
+}
+
+// MarshalBinary
+// Implements encoding.BinaryMarshaler.
+func (s *InkList) MarshalBinary() ([]byte, error) {
+	return NewListValueFromList(s).MarshalBinary()
+}
+
+// UnmarshalBinary
+// Implements encoding.BinaryUnmarshaler.
+func (s *InkList) UnmarshalBinary(data []byte) error {
+
+	list, err := unmarshalInkListBinary(data)
+	if err != nil {
+		return err
+	}
+
+	*s = *list
+	return nil
+}
+
+// MarshalJSON
+// Implements json.Marshaler, writing the list as it's written in a save.
+func (s *ListValue) MarshalJSON() ([]byte, error) {
+
+	writer := NewWriter()
+	WriteInkList(writer, s)
+
+	return []byte(writer.String()), nil
+}
+
+// UnmarshalJSON
+// Implements json.Unmarshaler.
+func (s *ListValue) UnmarshalJSON(data []byte) error {
+
+	list, err := unmarshalInkListJson(data)
+	if err != nil {
+		return err
+	}
+
+	s._value = list
+	return nil
+}
+
+// MarshalBinary
+// Implements encoding.BinaryMarshaler.
+func (s *ListValue) MarshalBinary() ([]byte, error) {
+
+	writer := newBinarySaveWriter()
+	writer.WriteObject(s)
+
+	return writer.Bytes(binaryValueIdentifier), nil
+}
+
+// UnmarshalBinary
+// Implements encoding.BinaryUnmarshaler.
+func (s *ListValue) UnmarshalBinary(data []byte) error {
+
+	list, err := unmarshalInkListBinary(data)
+	if err != nil {
+		return err
+	}
+
+	s._value = list
+	return nil
+}
+
+// MarshalJSON
+// Implements json.Marshaler, writing the choice as it's written in a
+// save. As in a save, the thread the choice was generated on isn't
+// included.
+func (s *Choice) MarshalJSON() ([]byte, error) {
+
+	writer := NewWriter()
+	WriteChoice(writer, s)
+
+	return []byte(writer.String()), nil
+}
+
+// UnmarshalJSON
+// Implements json.Unmarshaler. ThreadAtGeneration is left as it is.
+func (s *Choice) UnmarshalJSON(data []byte) (err error) {
+
+	jObject, err := TextToDictionary(string(data))
+	if err != nil {
+		return err
+	}
+
+	defer recoverUnmarshal("choice", &err)
+
+	s.setSavedFields(JObjectToChoice(jObject))
+	return nil
+}
+
+// MarshalBinary
+// Implements encoding.BinaryMarshaler.
+func (s *Choice) MarshalBinary() ([]byte, error) {
+
+	writer := newBinarySaveWriter()
+	writer.WriteChoice(s)
+
+	return writer.Bytes(binaryValueIdentifier), nil
+}
+
+// UnmarshalBinary
+// Implements encoding.BinaryUnmarshaler. ThreadAtGeneration is left as it
+// is.
+func (s *Choice) UnmarshalBinary(data []byte) error {
+
+	return unmarshalBinaryValue(data, "choice", func(r *binarySaveReader) {
+		s.setSavedFields(r.ReadChoice())
+	})
+}
+
+// setSavedFields
+// Copies over the fields that a save holds for a choice.
+func (s *Choice) setSavedFields(choice *Choice) {
+
+	s.Text = choice.Text
+	s.Index = choice.Index
+	s.SourcePath = choice.SourcePath
+	s.OriginalTheadIndex = choice.OriginalTheadIndex
+	s.TargetPath = choice.TargetPath
+}
+
+// MarshalJSON
+// Implements json.Marshaler, writing the path as a string in the same
+// form as String.
+func (s *Path) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.ComponentsString())
+}
+
+// UnmarshalJSON
+// Implements json.Unmarshaler.
+func (s *Path) UnmarshalJSON(data []byte) error {
+
+	var componentsString string
+	if err := json.Unmarshal(data, &componentsString); err != nil {
+		return err
+	}
+
+	s.SetComponentsString(componentsString)
+	return nil
+}
+
+// MarshalBinary
+// Implements encoding.BinaryMarshaler.
+func (s *Path) MarshalBinary() ([]byte, error) {
+
+	writer := newBinarySaveWriter()
+	writer.string(s.ComponentsString())
+
+	return writer.Bytes(binaryValueIdentifier), nil
+}
+
+// UnmarshalBinary
+// Implements encoding.BinaryUnmarshaler.
+func (s *Path) UnmarshalBinary(data []byte) error {
+
+	return unmarshalBinaryValue(data, "path", func(r *binarySaveReader) {
+		s.SetComponentsString(r.string())
+	})
+}
+
+func unmarshalInkListJson(data []byte) (list *InkList, err error) {
+
+	jObject, err := TextToDictionary(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer recoverUnmarshal("list", &err)
+
+	listValue, ok := JTokenToRuntimeObject(jObject).(*ListValue)
+	if !ok {
+		return nil, errors.New("invalid list: not a list value")
+	}
+
+	return listValue.Value(), nil
+}
+
+func unmarshalInkListBinary(data []byte) (list *InkList, err error) {
+
+	err = unmarshalBinaryValue(data, "list", func(r *binarySaveReader) {
+
+		listValue, ok := r.ReadObject().(*ListValue)
+		if !ok {
+			panic("not a list value")
+		}
+
+		list = listValue.Value()
+	})
+
+	return list, err
+}
+
+// unmarshalBinaryValue
+// Checks the header of a single value written by MarshalBinary, then
+// reads the body with read.
+func unmarshalBinaryValue(data []byte, what string, read func(r *binarySaveReader)) (err error) {
+
+	if len(data) < 4 || string(data[:4]) != binaryValueIdentifier {
+		return fmt.Errorf("%w: binary %s identifier not found", ErrSaveFormatIncompatible, what)
+	}
+
+	saveVersion, err := binarySaveVersion(data)
+	if err != nil {
+		return err
+	}
+
+	if saveVersion < kMinCompatibleBinaryLoadVersion {
+		return fmt.Errorf("%w: saw '%d', but minimum is %d", ErrSaveFormatIncompatible, saveVersion, kMinCompatibleBinaryLoadVersion)
+	}
+
+	defer recoverUnmarshal(what, &err)
+
+	r := newBinarySaveReader(data)
+	read(r)
+	r.end()
+
+	return nil
+}
+
+// recoverUnmarshal
+// Malformed data panics part way through being read, as it does when
+// loading a save, so this turns it back into an error.
+func recoverUnmarshal(what string, err *error) {
+
+	if r := recover(); r != nil {
+		if e, ok := r.(*StoryException); ok {
+			*err = e
+			return
+		}
+		*err = fmt.Errorf("invalid %s: %v", what, r)
+	}
+}
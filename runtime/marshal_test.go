@@ -0,0 +1,202 @@
+package runtime
+
+import (
+	"encoding"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ json.Marshaler             = (*StoryState)(nil)
+	_ json.Unmarshaler           = (*StoryState)(nil)
+	_ encoding.BinaryMarshaler   = (*StoryState)(nil)
+	_ encoding.BinaryUnmarshaler = (*StoryState)(nil)
+	_ json.Marshaler             = (*InkList)(nil)
+	_ json.Unmarshaler           = (*InkList)(nil)
+	_ encoding.BinaryMarshaler   = (*InkList)(nil)
+	_ encoding.BinaryUnmarshaler = (*InkList)(nil)
+	_ json.Marshaler             = (*ListValue)(nil)
+	_ json.Unmarshaler           = (*ListValue)(nil)
+	_ encoding.BinaryMarshaler   = (*ListValue)(nil)
+	_ encoding.BinaryUnmarshaler = (*ListValue)(nil)
+	_ json.Marshaler             = (*Choice)(nil)
+	_ json.Unmarshaler           = (*Choice)(nil)
+	_ encoding.BinaryMarshaler   = (*Choice)(nil)
+	_ encoding.BinaryUnmarshaler = (*Choice)(nil)
+	_ json.Marshaler             = (*Path)(nil)
+	_ json.Unmarshaler           = (*Path)(nil)
+	_ encoding.BinaryMarshaler   = (*Path)(nil)
+	_ encoding.BinaryUnmarshaler = (*Path)(nil)
+)
+
+type testSaveGame struct {
+	Slot  int
+	State *StoryState
+}
+
+func TestStoryStateMarshalJSON(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 3)
+
+	data, err := json.Marshal(testSaveGame{Slot: 2, State: story.State()})
+	require.NoError(t, err)
+
+	replica := newTestStoryFromFile(t, theInterceptPath)
+	loaded := testSaveGame{State: replica.State()}
+	require.NoError(t, json.Unmarshal(data, &loaded))
+
+	assert.Equal(t, 2, loaded.Slot)
+	requireSameState(t, story.State(), replica.State())
+
+	// encoding/json can't make a StoryState for a story by itself
+	err = json.Unmarshal(data, &testSaveGame{})
+	assert.ErrorIs(t, err, errStateWithoutStory)
+}
+
+func TestStoryStateUnmarshalJSONInvalid(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 2)
+	before := stateJson(t, story.State())
+
+	// Valid JSON, but not a save that can be loaded
+	for _, data := range []string{
+		`{"Slot":1,"State":{"inkSaveVersion":10}}`,
+		`{"Slot":1,"State":{"inkSaveVersion":10,"flows":{"DEFAULT_FLOW":{}}}}`,
+		`{"Slot":1,"State":{"inkSaveVersion":10,"flows":{"DEFAULT_FLOW":{"callstack":[]}}}}`,
+	} {
+		loaded := testSaveGame{State: story.State()}
+		require.NotPanics(t, func() {
+			assert.Error(t, json.Unmarshal([]byte(data), &loaded), data)
+		}, data)
+		require.Equal(t, before, stateJson(t, story.State()), data)
+	}
+}
+
+func TestStoryStateMarshalBinary(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 3)
+
+	data, err := story.State().MarshalBinary()
+	require.NoError(t, err)
+
+	replica := newTestStoryFromFile(t, theInterceptPath)
+	require.NoError(t, replica.State().UnmarshalBinary(data))
+	requireSameState(t, story.State(), replica.State())
+
+	assert.ErrorIs(t, new(StoryState).UnmarshalBinary(data), errStateWithoutStory)
+}
+
+func TestInkListMarshal(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/lists.ink.json")
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+
+	colours := story.VariablesState().GetVariable("colours").(*InkList)
+	empty := NewInkList()
+	empty.SetInitialOriginNames([]string{"colours"})
+
+	for _, list := range []*InkList{colours, empty} {
+
+		data, err := json.Marshal(list)
+		require.NoError(t, err)
+		fromJson := new(InkList)
+		require.NoError(t, json.Unmarshal(data, fromJson))
+		assert.True(t, list.Equals(fromJson), "%s != %s", list, fromJson)
+		assert.Equal(t, list.OriginNames(), fromJson.OriginNames())
+
+		data, err = list.MarshalBinary()
+		require.NoError(t, err)
+		fromBinary := new(InkList)
+		require.NoError(t, fromBinary.UnmarshalBinary(data))
+		assert.True(t, list.Equals(fromBinary), "%s != %s", list, fromBinary)
+		assert.Equal(t, list.OriginNames(), fromBinary.OriginNames())
+	}
+
+	listValue := NewListValueFromList(colours)
+
+	data, err := json.Marshal(listValue)
+	require.NoError(t, err)
+	fromJson := new(ListValue)
+	require.NoError(t, json.Unmarshal(data, fromJson))
+	assert.True(t, colours.Equals(fromJson.Value()))
+
+	data, err = listValue.MarshalBinary()
+	require.NoError(t, err)
+	fromBinary := new(ListValue)
+	require.NoError(t, fromBinary.UnmarshalBinary(data))
+	assert.True(t, colours.Equals(fromBinary.Value()))
+}
+
+func TestChoiceMarshal(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	require.NotEmpty(t, story.CurrentChoices())
+
+	for _, choice := range story.CurrentChoices() {
+
+		data, err := json.Marshal(choice)
+		require.NoError(t, err)
+		fromJson := new(Choice)
+		require.NoError(t, json.Unmarshal(data, fromJson))
+
+		data, err = choice.MarshalBinary()
+		require.NoError(t, err)
+		fromBinary := new(Choice)
+		require.NoError(t, fromBinary.UnmarshalBinary(data))
+
+		for _, loaded := range []*Choice{fromJson, fromBinary} {
+			assert.Equal(t, choice.Text, loaded.Text)
+			assert.Equal(t, choice.Index, loaded.Index)
+			assert.Equal(t, choice.SourcePath, loaded.SourcePath)
+			assert.Equal(t, choice.OriginalTheadIndex, loaded.OriginalTheadIndex)
+			assert.True(t, choice.TargetPath.Equals(loaded.TargetPath))
+		}
+	}
+}
+
+func TestPathMarshal(t *testing.T) {
+
+	for _, componentsString := range []string{"", "knot.stitch.3", ".^.^.hello.5"} {
+
+		path := NewPathFromString(componentsString)
+
+		data, err := json.Marshal(path)
+		require.NoError(t, err)
+		assert.JSONEq(t, `"`+componentsString+`"`, string(data))
+		fromJson := new(Path)
+		require.NoError(t, json.Unmarshal(data, fromJson))
+		assert.True(t, path.Equals(fromJson), "%s != %s", path, fromJson)
+
+		data, err = path.MarshalBinary()
+		require.NoError(t, err)
+		fromBinary := new(Path)
+		require.NoError(t, fromBinary.UnmarshalBinary(data))
+		assert.True(t, path.Equals(fromBinary), "%s != %s", path, fromBinary)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+
+	assert.Error(t, json.Unmarshal([]byte(`{"list":[]}`), new(InkList)))
+	assert.Error(t, json.Unmarshal([]byte(`{"^->":"knot"}`), new(ListValue)))
+	assert.Error(t, json.Unmarshal([]byte(`{"text":"Hello"}`), new(Choice)))
+	assert.Error(t, json.Unmarshal([]byte(`3`), new(Path)))
+
+	data, err := NewPathFromString("knot").MarshalBinary()
+	require.NoError(t, err)
+
+	assert.Error(t, new(InkList).UnmarshalBinary(data))
+	assert.Error(t, new(Choice).UnmarshalBinary(data))
+	assert.Error(t, new(Path).UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, new(Path).UnmarshalBinary(append(data, 0)))
+	assert.ErrorIs(t, new(Path).UnmarshalBinary([]byte("INKS")), ErrSaveFormatIncompatible)
+}
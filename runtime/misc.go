@@ -1,6 +1,10 @@
 package runtime
 
-import "sync"
+import (
+	"io"
+	"sort"
+	"sync"
+)
 
 type Stack[T any] struct {
 	items []T
@@ -97,29 +101,68 @@ func ClearMap[TKey comparable, TValue any](v map[TKey]TValue) {
 	}
 }
 
-func Remove[T comparable](s *[]T, v interface{}) {
-	n := *s
-	c := len(*s)
-	for index, vv := range n {
+// Remove
+// Removes the first occurrence of v from s, reporting whether it was found.
+func Remove[T comparable](s *[]T, v T) bool {
+	for index, vv := range *s {
 		if vv == v {
-			n = append(n[:index], n[index+1:]...)
+			*s = append((*s)[:index], (*s)[index+1:]...)
+			return true
 		}
 	}
-	nc := len(*s)
-	if nc == c {
-		panic("should not happen")
-	}
+	return false
 }
 
+// AddToMap
+// Adds the key to the map if it isn't already present, so that
+// it can be used as a set (the equivalent of HashSet.Add).
 func AddToMap[TKey comparable, TValue any](s map[TKey]TValue, key TKey, value TValue) {
 	if _, ok := s[key]; !ok {
 		s[key] = value
-		return
 	}
-	panic("key already in map")
+}
+
+// SortedKeys
+// The keys of the map in order, so that it can be written out the same
+// way every time.
+func SortedKeys[TValue any](m map[string]TValue) []string {
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
 }
 
 type KeyValuePair[TKey any, TValue any] struct {
 	Key   TKey
 	Value TValue
 }
+
+// countingWriter
+// Counts the bytes written through it, for io.WriterTo implementations.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (s *countingWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.n += int64(n)
+	return n, err
+}
+
+// countingReader
+// Counts the bytes read through it, for io.ReaderFrom implementations.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (s *countingReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	return n, err
+}
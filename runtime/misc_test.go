@@ -0,0 +1,28 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemove(t *testing.T) {
+
+	s := []int{1, 2, 3, 2}
+
+	assert.True(t, Remove(&s, 2))
+	assert.Equal(t, []int{1, 3, 2}, s)
+
+	assert.False(t, Remove(&s, 4))
+	assert.Equal(t, []int{1, 3, 2}, s)
+}
+
+func TestAddToMap(t *testing.T) {
+
+	m := map[string]int{}
+
+	AddToMap(m, "a", 1)
+	AddToMap(m, "a", 2)
+
+	assert.Equal(t, map[string]int{"a": 1}, m)
+}
@@ -63,12 +63,8 @@ func CallExistsWithName(functionName string) bool {
 
 	GenerateNativeFunctionsIfNecessary()
 
-	fmt.Println("FUNCTION_NAME", functionName)
-
 	_, containsKey := _nativeFunctions[functionName]
 
-	fmt.Println(len(_nativeFunctions))
-
 	return containsKey
 }
 
@@ -108,14 +104,14 @@ func (s *NativeFunctionCall) Call(parameters []Object) Object {
 	}
 
 	if s.NumberOfParameters() != len(parameters) {
-		panic("Unexpected number of parameters")
+		panic(NewStoryException("Unexpected number of parameters"))
 	}
 
 	hasList := false
 	for _, p := range parameters {
 		switch p.(type) {
 		case *Void:
-			panic("Attempting to perform operation on a void value. Did you forget to 'return' a value from a function you called here?")
+			panic(NewStoryException("Attempting to perform operation on a void value. Did you forget to 'return' a value from a function you called here?"))
 		case *ListValue:
 			hasList = true
 		}
@@ -157,9 +153,7 @@ func Call[T any](nativeFunctionCall *NativeFunctionCall, parametersOfSingleType
 
 		opForTypeObj, ok := nativeFunctionCall._operationFuncs[valType]
 		if !ok {
-			// TODO: don't panic
-			// Story Exception
-			panic("Cannot perform operation '" + nativeFunctionCall.Name() + "' on " + fmt.Sprint(valType))
+			panic(NewStoryException("Cannot perform operation '" + nativeFunctionCall.Name() + "' on " + fmt.Sprint(valType)))
 		}
 
 		// Binary
@@ -177,7 +171,7 @@ func Call[T any](nativeFunctionCall *NativeFunctionCall, parametersOfSingleType
 		}
 	}
 
-	panic("Unexpected number of parameters to NativeFunctionCall: " + fmt.Sprint(len(parametersOfSingleType)))
+	panic(NewStoryException("Unexpected number of parameters to NativeFunctionCall: " + fmt.Sprint(len(parametersOfSingleType))))
 }
 
 func (s *NativeFunctionCall) CallBinaryListOperation(parameters []Object) Value {
@@ -185,7 +179,7 @@ func (s *NativeFunctionCall) CallBinaryListOperation(parameters []Object) Value
 	if s.Name() == "+" || s.Name() == "-" {
 		if _, isListValue := parameters[0].(*ListValue); isListValue {
 			if _, isIntValue := parameters[1].(*IntValue); isIntValue {
-				s.CallListIncrementOperation(parameters)
+				return s.CallListIncrementOperation(parameters)
 			}
 		}
 	}
@@ -216,9 +210,7 @@ func (s *NativeFunctionCall) CallBinaryListOperation(parameters []Object) Value
 		return Call[*InkList](s, []Value{v1, v2})
 	}
 
-	// TODO: don't panic
-	// Story Exception
-	panic("Can not call use '" + s.Name() + "' operation on " + fmt.Sprint(v1.ValueType()) + " and " + fmt.Sprint(v2.ValueType()))
+	panic(NewStoryException("Can not call use '" + s.Name() + "' operation on " + fmt.Sprint(v1.ValueType()) + " and " + fmt.Sprint(v2.ValueType())))
 }
 
 func (s *NativeFunctionCall) CallListIncrementOperation(listIntParams []Object) Value {
@@ -228,7 +220,9 @@ func (s *NativeFunctionCall) CallListIncrementOperation(listIntParams []Object)
 
 	resultRawList := NewInkList()
 
-	for listItem, listItemValue := range listVal.Value()._items {
+	for _, item := range listVal.Value().Items() {
+
+		listItem, listItemValue := item.Key, item.Value
 
 		// Find + or - operation
 		intOp := s._operationFuncs[ValueTypeInt].(BinaryOp[int])
@@ -297,14 +291,10 @@ func (s *NativeFunctionCall) CoerceValuesToSingleType(parametersIn []Object) []V
 					castedValue := NewListValueFromInkListItem(item, intVal)
 					parametersOut = append(parametersOut, castedValue)
 				} else {
-					// TODO: don't panic
-					// Story Exception
-					panic("Could not find List item with the value " + fmt.Sprint(intVal) + " in " + list.Name())
+					panic(NewStoryException("Could not find List item with the value " + fmt.Sprint(intVal) + " in " + list.Name()))
 				}
 			} else {
-				// TODO: don't panic
-				// Story Exception
-				panic("Cannot mix Lists and " + fmt.Sprint(val.ValueType()) + " values in this operation")
+				panic(NewStoryException("Cannot mix Lists and " + fmt.Sprint(val.ValueType()) + " values in this operation"))
 			}
 		}
 	} else {
@@ -375,7 +365,7 @@ func GenerateNativeFunctionsIfNecessary() {
 		AddIntBinaryOp(NotEquals, func(left int, right int) interface{} { return left != right })
 		AddIntUnaryOp(Not, func(val int) interface{} { return val == 0 })
 
-		AddIntBinaryOp(Add, func(left int, right int) interface{} { return left != 0 && right != 0 })
+		AddIntBinaryOp(And, func(left int, right int) interface{} { return left != 0 && right != 0 })
 		AddIntBinaryOp(Or, func(left int, right int) interface{} { return left != 0 || right != 0 })
 
 		AddIntBinaryOp(Max, func(left int, right int) interface{} { return int(math.Max(float64(left), float64(right))) })
@@ -0,0 +1,23 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// VAR n = 1
+// {n + 2} {n and 0} {n and 2}
+const intOperationsJson = `{"inkVersion":21,"root":[["ev",{"VAR?":"n"},2,"+","out","/ev","^ ","ev",{"VAR?":"n"},0,"&&","out","/ev","^ ","ev",{"VAR?":"n"},2,"&&","out","/ev","\n","end",["done",{"#n":"g-0"}],null],"done",{"global decl":["ev",1,{"VAR=":"n"},"/ev","end",null],"#f":1}],"listDefs":{}}`
+
+func TestIntAndOperation(t *testing.T) {
+
+	story, err := NewStory(intOperationsJson)
+	require.NoError(t, err)
+
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+
+	assert.Equal(t, "3 false true\n", text)
+}
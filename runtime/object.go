@@ -93,7 +93,14 @@ func (s *ObjectImpl) Path(child Object) *Path {
 				child = container
 				container, _ = container.Parent().(*Container)
 			}
-			s._path = NewPathFromComponents(comps.items, false)
+
+			var pathComps []*PathComponent
+			for !comps.IsEmpty() {
+				comp, _ := comps.Pop()
+				pathComps = append(pathComps, comp)
+			}
+
+			s._path = NewPathFromComponents(pathComps, false)
 		}
 	}
 
@@ -200,8 +207,6 @@ func ConvertPathToRelative(target Object, globalPath *Path) *Path {
 		}
 	}
 
-	fmt.Println(globalPath)
-
 	// No shared path components, so just use global path
 	if lastSharedPathCompIndex == -1 {
 		return globalPath
@@ -104,7 +104,7 @@ func (s *Path) PathByAppendingPath(pathToAppend *Path) *Path {
 func (s *Path) PathByAppendingComponent(c *PathComponent) *Path {
 
 	p := NewPath()
-	p._components = append([]*PathComponent{}, c)
+	p._components = append(append(p._components, s._components...), c)
 
 	return p
 }
@@ -0,0 +1,37 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathByAppendingComponent(t *testing.T) {
+
+	path := NewPathFromString("knot.stitch")
+
+	assert.Equal(t, "knot.stitch.2", path.PathByAppendingComponent(NewPathComponentFromIndex(2)).ComponentsString())
+	assert.Equal(t, "knot.stitch.g-0", path.PathByAppendingComponent(NewPathComponentFromName("g-0")).ComponentsString())
+	assert.Equal(t, "knot.stitch", path.ComponentsString())
+}
+
+// TestObjectPath
+// An object's path runs from the root down to it, by name where the
+// content is named and by index where it isn't.
+func TestObjectPath(t *testing.T) {
+
+	root := NewContainer()
+	root.AddContent(NewStringValueFromString("a"))
+
+	knot := NewContainer()
+	knot.SetName("knot")
+	root.AddContent(knot)
+
+	knot.AddContent(NewStringValueFromString("b"))
+	text := NewStringValueFromString("c")
+	knot.AddContent(text)
+
+	assert.Equal(t, "knot.1", text.Path(text).ComponentsString())
+	assert.Equal(t, "knot", knot.Path(knot).ComponentsString())
+	assert.Equal(t, "", root.Path(root).ComponentsString())
+}
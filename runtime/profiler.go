@@ -0,0 +1,703 @@
+package runtime
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profiler
+// Simple ink profiler that logs every instruction in the story and counts
+// frequency and timing. To use:
+//
+//	profiler, err := story.StartProfiling()
+//
+//	// play your story for a bit
+//
+//	report := profiler.Report()
+//
+//	err = story.EndProfiling()
+type Profiler struct {
+
+	// Private
+	_stepStart   time.Time
+	_snapStart   time.Time
+	_stepElapsed time.Duration
+
+	// When each Continue that's running started. Ink can call the game,
+	// which can continue the story again before the outer one is done.
+	_continueStarts []time.Time
+
+	_continueTotal time.Duration
+	_snapTotal     time.Duration
+	_stepTotal     time.Duration
+
+	_startTime       time.Time
+	_currStepStack   []string
+	_currStepDetails *stepDetails
+	_rootNode        *ProfileNode
+	_numContinues    int
+
+	_stepDetails []*stepDetails
+}
+
+type stepDetails struct {
+	typ   string
+	obj   Object
+	stack []string
+	time  time.Duration
+}
+
+func NewProfiler() *Profiler {
+
+	newProfiler := new(Profiler)
+	newProfiler._rootNode = new(ProfileNode)
+	newProfiler._startTime = time.Now()
+
+	return newProfiler
+}
+
+// RootNode
+// The root node in the hierarchical tree of recorded ink timings.
+func (s *Profiler) RootNode() *ProfileNode {
+
+	return s._rootNode
+}
+
+// Report
+// Generate a printable report based on the data recording during profiling.
+func (s *Profiler) Report() string {
+
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("%d CONTINUES / LINES:\n", s._numContinues))
+	sb.WriteString(fmt.Sprintf("TOTAL TIME: %s\n", FormatMillisecs(millisecs(s._continueTotal))))
+	sb.WriteString(fmt.Sprintf("SNAPSHOTTING: %s\n", FormatMillisecs(millisecs(s._snapTotal))))
+	sb.WriteString(fmt.Sprintf("OTHER: %s\n", FormatMillisecs(millisecs(s._continueTotal-(s._stepTotal+s._snapTotal)))))
+	sb.WriteString(s._rootNode.String())
+
+	return sb.String()
+}
+
+// PreContinue
+// Internal method called by Story at the start of ContinueInternal.
+func (s *Profiler) PreContinue() {
+
+	s._continueStarts = append(s._continueStarts, time.Now())
+}
+
+// PostContinue
+// Internal method called by Story at the end of ContinueInternal. The
+// time of a Continue inside another is already part of the outer one's.
+func (s *Profiler) PostContinue() {
+
+	if len(s._continueStarts) == 0 {
+		return
+	}
+
+	start := s._continueStarts[len(s._continueStarts)-1]
+	s._continueStarts = s._continueStarts[:len(s._continueStarts)-1]
+
+	if len(s._continueStarts) == 0 {
+		s._continueTotal += time.Since(start)
+	}
+	s._numContinues++
+}
+
+// PreStep
+// Internal method called by Story before each Step.
+func (s *Profiler) PreStep() {
+
+	s._currStepStack = nil
+	s._currStepDetails = nil
+	s._stepElapsed = 0
+	s._stepStart = time.Now()
+}
+
+// Step
+// Internal method called by Story once the content for a Step has been
+// found. The time spent recording the call stack isn't counted.
+func (s *Profiler) Step(callStack *CallStack) {
+
+	s._stepElapsed += time.Since(s._stepStart)
+
+	elements := callStack.Elements()
+	stack := make([]string, len(elements))
+	for i, element := range elements {
+		stackElementName := ""
+		if !element.CurrentPointer.IsNull() {
+			objPath := element.CurrentPointer.Path()
+
+			for c := 0; c < objPath.Length(); c++ {
+				comp := objPath.Component(c)
+				if !comp.IsIndex() {
+					stackElementName = comp.Name()
+					break
+				}
+			}
+		}
+		stack[i] = stackElementName
+	}
+
+	s._currStepStack = stack
+
+	currObj := callStack.CurrentElement().CurrentPointer.Resolve()
+
+	var stepType string
+	if controlCommandStep, ok := currObj.(*ControlCommand); ok {
+		stepType = controlCommandStep.CommandType.String() + " CC"
+	} else {
+		stepType = objectTypeName(currObj)
+	}
+
+	s._currStepDetails = &stepDetails{
+		typ:   stepType,
+		obj:   currObj,
+		stack: stack,
+	}
+
+	s._stepStart = time.Now()
+}
+
+// PostStep
+// Internal method called by Story after each Step.
+func (s *Profiler) PostStep() {
+
+	s._stepElapsed += time.Since(s._stepStart)
+
+	duration := s._stepElapsed
+	s._stepTotal += duration
+
+	// Step can finish before it finds any content, e.g. when the
+	// flow has run out, in which case there's nothing to attribute
+	if s._currStepDetails == nil {
+		return
+	}
+
+	s._rootNode.AddSample(s._currStepStack, millisecs(duration))
+
+	s._currStepDetails.time = duration
+	s._stepDetails = append(s._stepDetails, s._currStepDetails)
+}
+
+// StepLengthReport
+// Generate a printable report specifying the average and maximum times spent
+// stepping over different internal ink instruction types.
+// This report type is primarily used to profile the ink engine itself rather
+// than your own specific ink.
+func (s *Profiler) StepLengthReport() string {
+
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("TOTAL: %dms\n", s._rootNode.TotalMillisecs()))
+
+	// Group by step type, in the order each type was first seen
+	var types []string
+	groups := make(map[string][]*stepDetails)
+	for _, step := range s._stepDetails {
+		if _, ok := groups[step.typ]; !ok {
+			types = append(types, step.typ)
+		}
+		groups[step.typ] = append(groups[step.typ], step)
+	}
+
+	sums := make(map[string]float64, len(types))
+	for _, typ := range types {
+		for _, step := range groups[typ] {
+			sums[typ] += millisecs(step.time)
+		}
+	}
+
+	averageOrder := append([]string(nil), types...)
+	sort.SliceStable(averageOrder, func(i, j int) bool {
+		return sums[averageOrder[i]]/float64(len(groups[averageOrder[i]])) > sums[averageOrder[j]]/float64(len(groups[averageOrder[j]]))
+	})
+
+	averageStepTimes := make([]string, len(averageOrder))
+	for i, typ := range averageOrder {
+		averageStepTimes[i] = typ + ": " + formatDouble(sums[typ]/float64(len(groups[typ]))) + "ms"
+	}
+
+	sb.WriteString("AVERAGE STEP TIMES: " + strings.Join(averageStepTimes, ", ") + "\n")
+
+	accumOrder := append([]string(nil), types...)
+	sort.SliceStable(accumOrder, func(i, j int) bool {
+		return sums[accumOrder[i]] > sums[accumOrder[j]]
+	})
+
+	accumStepTimes := make([]string, len(accumOrder))
+	for i, typ := range accumOrder {
+		accumStepTimes[i] = fmt.Sprintf("%s (x%d): %s", typ, len(groups[typ]), formatDouble(sums[typ]))
+	}
+
+	sb.WriteString("ACCUMULATED STEP TIMES: " + strings.Join(accumStepTimes, ", ") + "\n")
+
+	return sb.String()
+}
+
+// Megalog
+// Create a large log of all the internal instructions that were evaluated while profiling was active.
+// Log is in a tab-separated format, for easy loading into a spreadsheet application.
+func (s *Profiler) Megalog() string {
+
+	var sb strings.Builder
+
+	sb.WriteString("Step type\tDescription\tPath\tTime\n")
+
+	for _, step := range s._stepDetails {
+		sb.WriteString(step.typ)
+		sb.WriteString("\t")
+		sb.WriteString(fmt.Sprint(step.obj))
+		sb.WriteString("\t")
+		if step.obj != nil {
+			sb.WriteString(step.obj.Path(step.obj).String())
+		}
+		sb.WriteString("\t")
+		sb.WriteString(strconv.FormatFloat(millisecs(step.time), 'f', 8, 64))
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+// PreSnapshot
+// Internal method called by Story before it takes or checks a snapshot.
+func (s *Profiler) PreSnapshot() {
+
+	s._snapStart = time.Now()
+}
+
+// PostSnapshot
+// Internal method called by Story after it takes or checks a snapshot.
+func (s *Profiler) PostSnapshot() {
+
+	s._snapTotal += time.Since(s._snapStart)
+}
+
+// WritePprof
+// Writes the recorded steps as a gzipped pprof profile, so they can be
+// explored with `go tool pprof`. Each step is a sample whose stack is the
+// ink call stack (knot, stitch or function names), with its count and the
+// time spent in it as values.
+func (s *Profiler) WritePprof(w io.Writer) error {
+
+	var b protoBuffer
+
+	strs := map[string]int{"": 0}
+	stringTable := []string{""}
+	str := func(v string) int64 {
+		i, ok := strs[v]
+		if !ok {
+			i = len(stringTable)
+			strs[v] = i
+			stringTable = append(stringTable, v)
+		}
+		return int64(i)
+	}
+
+	valueType := func(field int, typ, unit string) {
+		b.message(field, func() {
+			b.int64(1, str(typ))
+			b.int64(2, str(unit))
+		})
+	}
+
+	valueType(1, "samples", "count")
+	valueType(1, "time", "nanoseconds")
+
+	// Merge steps with identical call stacks into one sample
+	type sample struct {
+		locations []uint64
+		count     int64
+		nanos     int64
+	}
+
+	var samples []*sample
+	samplesByStack := make(map[string]*sample)
+
+	var functions []string
+	locationIds := make(map[string]uint64)
+
+	for _, step := range s._stepDetails {
+		key := strings.Join(step.stack, "\x00")
+		smp, ok := samplesByStack[key]
+		if !ok {
+			smp = new(sample)
+
+			// pprof stacks start at the leaf
+			for i := len(step.stack) - 1; i >= 0; i-- {
+				name := step.stack[i]
+				if name == "" {
+					name = "(root)"
+				}
+
+				id, ok := locationIds[name]
+				if !ok {
+					functions = append(functions, name)
+					id = uint64(len(functions))
+					locationIds[name] = id
+				}
+				smp.locations = append(smp.locations, id)
+			}
+
+			samplesByStack[key] = smp
+			samples = append(samples, smp)
+		}
+
+		smp.count++
+		smp.nanos += step.time.Nanoseconds()
+	}
+
+	for _, smp := range samples {
+		b.message(2, func() {
+			b.uint64s(1, smp.locations)
+			b.int64s(2, []int64{smp.count, smp.nanos})
+		})
+	}
+
+	// One location per function, with a single line
+	for i := range functions {
+		id := uint64(i + 1)
+		b.message(4, func() {
+			b.uint64(1, id)
+			b.message(4, func() {
+				b.uint64(1, id)
+			})
+		})
+	}
+
+	for i, name := range functions {
+		id := uint64(i + 1)
+		nameIndex := str(name)
+		b.message(5, func() {
+			b.uint64(1, id)
+			b.int64(2, nameIndex)
+			b.int64(3, nameIndex)
+		})
+	}
+
+	periodType := str("nanoseconds")
+	timeType := str("time")
+
+	for _, v := range stringTable {
+		b.string(6, v)
+	}
+
+	b.int64(9, s._startTime.UnixNano())
+	b.int64(10, s._continueTotal.Nanoseconds())
+	b.message(11, func() {
+		b.int64(1, timeType)
+		b.int64(2, periodType)
+	})
+	b.int64(12, 1)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// ProfileNode
+// Node used in the hierarchical tree of timings used by the Profiler.
+// Each node corresponds to a single line viewable in a UI-based representation.
+type ProfileNode struct {
+
+	// Public
+	Key string
+
+	// Used in UI-based representations of the tree
+	OpenInUI bool
+
+	// Private
+	_nodes            map[string]*ProfileNode
+	_nodeKeys         []string
+	_selfMillisecs    float64
+	_totalMillisecs   float64
+	_selfSampleCount  int
+	_totalSampleCount int
+}
+
+func NewProfileNode(key string) *ProfileNode {
+
+	newProfileNode := new(ProfileNode)
+	newProfileNode.Key = key
+
+	return newProfileNode
+}
+
+// HasChildren
+// Whether this node contains any sub-nodes - i.e. does it call anything else
+// that has been recorded?
+func (s *ProfileNode) HasChildren() bool {
+
+	return len(s._nodes) > 0
+}
+
+// TotalMillisecs
+// Total number of milliseconds this node has been active for.
+func (s *ProfileNode) TotalMillisecs() int {
+
+	return int(s._totalMillisecs)
+}
+
+func (s *ProfileNode) AddSample(stack []string, duration float64) {
+
+	s.addSample(stack, -1, duration)
+}
+
+func (s *ProfileNode) addSample(stack []string, stackIdx int, duration float64) {
+
+	s._totalSampleCount++
+	s._totalMillisecs += duration
+
+	if stackIdx == len(stack)-1 {
+		s._selfSampleCount++
+		s._selfMillisecs += duration
+	}
+
+	if stackIdx+1 < len(stack) {
+		s.addSampleToNode(stack, stackIdx+1, duration)
+	}
+}
+
+func (s *ProfileNode) addSampleToNode(stack []string, stackIdx int, duration float64) {
+
+	nodeKey := stack[stackIdx]
+	if s._nodes == nil {
+		s._nodes = make(map[string]*ProfileNode)
+	}
+
+	node, ok := s._nodes[nodeKey]
+	if !ok {
+		node = NewProfileNode(nodeKey)
+		s._nodes[nodeKey] = node
+		s._nodeKeys = append(s._nodeKeys, nodeKey)
+	}
+
+	node.addSample(stack, stackIdx, duration)
+}
+
+// DescendingOrderedNodes
+// Returns the sub-nodes of this node, ordered by the time spent in each,
+// longest first.
+func (s *ProfileNode) DescendingOrderedNodes() []*ProfileNode {
+
+	if s._nodes == nil {
+		return nil
+	}
+
+	nodes := make([]*ProfileNode, len(s._nodeKeys))
+	for i, key := range s._nodeKeys {
+		nodes[i] = s._nodes[key]
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i]._totalMillisecs > nodes[j]._totalMillisecs
+	})
+
+	return nodes
+}
+
+func (s *ProfileNode) printHierarchy(sb *strings.Builder, indent int) {
+
+	sb.WriteString(strings.Repeat("   ", indent))
+
+	sb.WriteString(s.Key)
+	sb.WriteString(": ")
+	sb.WriteString(s.OwnReport())
+	sb.WriteString("\n")
+
+	for _, node := range s.DescendingOrderedNodes() {
+		node.printHierarchy(sb, indent+1)
+	}
+}
+
+// OwnReport
+// Generates a string giving timing information for this single node, including
+// total milliseconds spent on the piece of ink, the time spent within itself
+// (v.s. spent in children), as well as the number of samples (instruction steps)
+// recorded for both too.
+func (s *ProfileNode) OwnReport() string {
+
+	var sb strings.Builder
+
+	sb.WriteString("total ")
+	sb.WriteString(FormatMillisecs(s._totalMillisecs))
+	sb.WriteString(", self ")
+	sb.WriteString(FormatMillisecs(s._selfMillisecs))
+	sb.WriteString(" (")
+	sb.WriteString(strconv.Itoa(s._selfSampleCount))
+	sb.WriteString(" self samples, ")
+	sb.WriteString(strconv.Itoa(s._totalSampleCount))
+	sb.WriteString(" total)")
+
+	return sb.String()
+}
+
+// String
+// String is a report of the sub-tree from this node, but without any of the header information
+// that's prepended by the Profiler in its Report() method.
+func (s *ProfileNode) String() string {
+
+	var sb strings.Builder
+	s.printHierarchy(&sb, 0)
+	return sb.String()
+}
+
+// FormatMillisecs
+// Format the given number of milliseconds the same way the reports do.
+func FormatMillisecs(num float64) string {
+
+	if num > 5000 {
+		return formatNumber(num/1000.0, 1) + " secs"
+	}
+	if num > 1000 {
+		return formatNumber(num/1000.0, 2) + " secs"
+	} else if num > 100 {
+		return formatNumber(num, 0) + " ms"
+	} else if num > 1 {
+		return formatNumber(num, 1) + " ms"
+	} else if num > 0.01 {
+		return formatNumber(num, 3) + " ms"
+	}
+
+	return formatNumber(num, 2) + " ms"
+}
+
+// formatNumber
+// Formats like C#'s "N" format: fixed decimals, with thousands separators.
+func formatNumber(num float64, decimals int) string {
+
+	str := strconv.FormatFloat(math.Abs(num), 'f', decimals, 64)
+
+	intPart, fracPart := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		intPart, fracPart = str[:i], str[i:]
+	}
+
+	var sb strings.Builder
+	if num < 0 && strings.Trim(str, "0.") != "" {
+		sb.WriteByte('-')
+	}
+
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(c)
+	}
+
+	sb.WriteString(fracPart)
+	return sb.String()
+}
+
+func formatDouble(num float64) string {
+
+	return strconv.FormatFloat(num, 'f', -1, 64)
+}
+
+func millisecs(d time.Duration) float64 {
+
+	return float64(d) / float64(time.Millisecond)
+}
+
+func objectTypeName(obj Object) string {
+
+	if obj == nil {
+		return "null"
+	}
+
+	t := reflect.TypeOf(obj)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t.Name()
+}
+
+// protoBuffer
+// Just enough of the protocol buffer wire format to write a pprof profile
+// without pulling in a dependency.
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (s *protoBuffer) varint(v uint64) {
+
+	for v >= 0x80 {
+		s.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	s.WriteByte(byte(v))
+}
+
+func (s *protoBuffer) tag(field int, wireType int) {
+
+	s.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (s *protoBuffer) uint64(field int, v uint64) {
+
+	if v == 0 {
+		return
+	}
+	s.tag(field, 0)
+	s.varint(v)
+}
+
+func (s *protoBuffer) int64(field int, v int64) {
+
+	s.uint64(field, uint64(v))
+}
+
+func (s *protoBuffer) uint64s(field int, vs []uint64) {
+
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(v)
+	}
+	s.bytes(field, packed.Bytes())
+}
+
+func (s *protoBuffer) int64s(field int, vs []int64) {
+
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(uint64(v))
+	}
+	s.bytes(field, packed.Bytes())
+}
+
+func (s *protoBuffer) string(field int, v string) {
+
+	s.tag(field, 2)
+	s.varint(uint64(len(v)))
+	s.WriteString(v)
+}
+
+func (s *protoBuffer) bytes(field int, v []byte) {
+
+	s.tag(field, 2)
+	s.varint(uint64(len(v)))
+	s.Write(v)
+}
+
+func (s *protoBuffer) message(field int, write func()) {
+
+	start := s.Len()
+	write()
+
+	// Move the message body after its tag and length
+	body := append([]byte(nil), s.Bytes()[start:]...)
+	s.Truncate(start)
+	s.bytes(field, body)
+}
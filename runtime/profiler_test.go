@@ -0,0 +1,239 @@
+package runtime
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newProfiledStory
+// Plays the functions conformance story to the end while profiling it.
+func newProfiledStory(t *testing.T) (*Story, *Profiler) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/functions.ink.json")
+	profiler, err := story.StartProfiling()
+	require.NoError(t, err)
+
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+
+	return story, profiler
+}
+
+func TestProfilerReport(t *testing.T) {
+
+	story, profiler := newProfiledStory(t)
+
+	report := profiler.Report()
+	assert.True(t, strings.HasPrefix(report, "4 CONTINUES / LINES:\n"), report)
+	assert.Contains(t, report, "\nTOTAL TIME: ")
+	assert.Contains(t, report, "\nSNAPSHOTTING: ")
+	assert.Contains(t, report, "\nOTHER: ")
+
+	var keys []string
+	for _, node := range profiler.RootNode().DescendingOrderedNodes() {
+		keys = append(keys, node.Key)
+	}
+	assert.Equal(t, []string{""}, keys)
+
+	var functions []string
+	for _, node := range profiler.RootNode().DescendingOrderedNodes()[0].DescendingOrderedNodes() {
+		functions = append(functions, node.Key)
+	}
+	assert.ElementsMatch(t, []string{"add", "increment", "greet", "factorial"}, functions)
+
+	// A Continue that fails is still timed, and leaves nothing behind
+	_, err := story.Continue()
+	assert.ErrorIs(t, err, ErrCannotContinue)
+	assert.True(t, strings.HasPrefix(profiler.Report(), "5 CONTINUES / LINES:\n"))
+	assert.Empty(t, profiler._continueStarts)
+
+	require.NoError(t, story.EndProfiling())
+}
+
+func TestProfilerStepReports(t *testing.T) {
+
+	_, profiler := newProfiledStory(t)
+
+	// A row per step, though text content can run over several lines
+	megalog := profiler.Megalog()
+	assert.True(t, strings.HasPrefix(megalog, "Step type\tDescription\tPath\tTime\n"))
+	assert.Equal(t, 3*(len(profiler._stepDetails)+1), strings.Count(megalog, "\t"))
+	assert.Contains(t, megalog, "VariableAssignment\tVarAssign to n\tfactorial.0\t")
+
+	report := profiler.StepLengthReport()
+	assert.True(t, strings.HasPrefix(report, "TOTAL: "), report)
+	assert.Contains(t, report, "\nAVERAGE STEP TIMES: ")
+	assert.Contains(t, report, "\nACCUMULATED STEP TIMES: ")
+	assert.Contains(t, report, "EvalStart CC (x")
+}
+
+func TestProfilingDuringAsyncContinue(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+
+	// Too short for a whole line
+	require.NoError(t, story.ContinueAsync(0.000001))
+	require.False(t, story.AsyncContinueComplete())
+
+	profiler, err := story.StartProfiling()
+	assert.ErrorIs(t, err, ErrAsyncContinueActive)
+	assert.Nil(t, profiler)
+	assert.ErrorIs(t, story.EndProfiling(), ErrAsyncContinueActive)
+
+	_, err = story.Continue()
+	require.NoError(t, err)
+
+	profiler, err = story.StartProfiling()
+	require.NoError(t, err)
+	assert.NotNil(t, profiler)
+	assert.NoError(t, story.EndProfiling())
+}
+
+func TestFormatMillisecs(t *testing.T) {
+
+	for num, expected := range map[float64]string{
+		1234567: "1,234.6 secs",
+		6000:    "6.0 secs",
+		1234.5:  "1.23 secs",
+		250:     "250 ms",
+		12.34:   "12.3 ms",
+		0.5:     "0.500 ms",
+		0.001:   "0.00 ms",
+	} {
+		assert.Equal(t, expected, FormatMillisecs(num), num)
+	}
+}
+
+func TestProfilerWritePprof(t *testing.T) {
+
+	_, profiler := newProfiledStory(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, profiler.WritePprof(&buf))
+
+	zr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+
+	profile := decodeProto(t, data)
+
+	var stringTable []string
+	for _, s := range profile[6] {
+		stringTable = append(stringTable, string(s.([]byte)))
+	}
+	require.NotEmpty(t, stringTable)
+	assert.Equal(t, "", stringTable[0])
+
+	str := func(v interface{}) string {
+		i := v.(uint64)
+		require.Less(t, int(i), len(stringTable))
+		return stringTable[i]
+	}
+
+	var sampleTypes []string
+	for _, m := range profile[1] {
+		valueType := decodeProto(t, m.([]byte))
+		sampleTypes = append(sampleTypes, str(valueType[1][0])+"/"+str(valueType[2][0]))
+	}
+	assert.Equal(t, []string{"samples/count", "time/nanoseconds"}, sampleTypes)
+
+	periodType := decodeProto(t, profile[11][0].([]byte))
+	assert.Equal(t, "time", str(periodType[1][0]))
+	assert.Equal(t, "nanoseconds", str(periodType[2][0]))
+
+	functions := map[uint64]string{}
+	for _, m := range profile[5] {
+		function := decodeProto(t, m.([]byte))
+		functions[function[1][0].(uint64)] = str(function[2][0])
+	}
+
+	locations := map[uint64]string{}
+	for _, m := range profile[4] {
+		location := decodeProto(t, m.([]byte))
+		line := decodeProto(t, location[4][0].([]byte))
+		locations[location[1][0].(uint64)] = functions[line[1][0].(uint64)]
+	}
+
+	// Each step is counted once, in the sample for its call stack
+	var count uint64
+	stacks := map[string]bool{}
+	for _, m := range profile[2] {
+		sample := decodeProto(t, m.([]byte))
+
+		var stack []string
+		for _, id := range decodePacked(t, sample[1][0].([]byte)) {
+			name, ok := locations[id]
+			require.True(t, ok, "sample has unknown location %d", id)
+			stack = append(stack, name)
+		}
+		stacks[strings.Join(stack, " < ")] = true
+
+		values := decodePacked(t, sample[2][0].([]byte))
+		require.Len(t, values, 2)
+		count += values[0]
+	}
+
+	assert.Equal(t, uint64(len(profiler._stepDetails)), count)
+	assert.True(t, stacks["(root)"])
+	assert.True(t, stacks["add < (root)"])
+	assert.True(t, stacks["factorial < factorial < factorial < (root)"])
+}
+
+// decodeProto
+// Decodes a protocol buffer message into the values of its fields, by
+// field number: varints as uint64 and everything length-delimited as
+// []byte.
+func decodeProto(t *testing.T, data []byte) map[int][]interface{} {
+	t.Helper()
+
+	fields := map[int][]interface{}{}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		require.Positive(t, n)
+		data = data[n:]
+
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(data)
+			require.Positive(t, n)
+			data = data[n:]
+			fields[field] = append(fields[field], v)
+
+		case 2:
+			length, n := binary.Uvarint(data)
+			require.Positive(t, n)
+			data = data[n:]
+			require.LessOrEqual(t, length, uint64(len(data)))
+			fields[field] = append(fields[field], data[:length])
+			data = data[length:]
+
+		default:
+			t.Fatalf("unexpected wire type %d for field %d", key&7, field)
+		}
+	}
+
+	return fields
+}
+
+func decodePacked(t *testing.T, data []byte) []uint64 {
+	t.Helper()
+
+	var vs []uint64
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		require.Positive(t, n)
+		vs = append(vs, v)
+		data = data[n:]
+	}
+
+	return vs
+}
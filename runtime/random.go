@@ -0,0 +1,119 @@
+package runtime
+
+import "math"
+
+// RandomSource
+// Generates the numbers behind RANDOM, LIST_RANDOM and shuffle sequences.
+// Ink doesn't keep a generator running: every time it needs randomness it
+// reseeds from the story state (the story seed and the previous result),
+// which is what makes saved games replay deterministically. So Story calls
+// Seed before each run of Next calls.
+type RandomSource interface {
+
+	// Seed
+	// Resets the source so that it produces the sequence for seed.
+	Seed(seed int)
+
+	// Next
+	// Returns the next number in the sequence, in the range [0, math.MaxInt32).
+	Next() int
+}
+
+// DotNetRandom
+// A RandomSource that reproduces System.Random from .NET, which is what the
+// reference C# ink runtime uses, so that a story gives the same RANDOM and
+// shuffle results as it does in Unity. This is the default RandomSource.
+//
+// It is Knuth's subtractive generator, as implemented by .NET's seeded
+// System.Random(int) constructor and Next() method.
+type DotNetRandom struct {
+
+	// Private
+	_seedArray [56]int32
+	_inext     int
+	_inextp    int
+}
+
+const (
+	dotNetRandomMBig  = math.MaxInt32
+	dotNetRandomMSeed = 161803398
+)
+
+func NewDotNetRandom(seed int) *DotNetRandom {
+
+	newDotNetRandom := new(DotNetRandom)
+	newDotNetRandom.Seed(seed)
+
+	return newDotNetRandom
+}
+
+func (s *DotNetRandom) Seed(seed int) {
+
+	// C# ints are 32 bits, and ink's seed arithmetic wraps accordingly
+	seed32 := int32(seed)
+
+	var subtraction int32
+	if seed32 == math.MinInt32 {
+		subtraction = math.MaxInt32
+	} else if seed32 < 0 {
+		subtraction = -seed32
+	} else {
+		subtraction = seed32
+	}
+
+	mj := dotNetRandomMSeed - subtraction
+	s._seedArray[55] = mj
+
+	mk := int32(1)
+	for i := 1; i < 55; i++ {
+		ii := (21 * i) % 55
+		s._seedArray[ii] = mk
+		mk = mj - mk
+		if mk < 0 {
+			mk += dotNetRandomMBig
+		}
+		mj = s._seedArray[ii]
+	}
+
+	for k := 1; k < 5; k++ {
+		for i := 1; i < 56; i++ {
+			s._seedArray[i] -= s._seedArray[1+(i+30)%55]
+			if s._seedArray[i] < 0 {
+				s._seedArray[i] += dotNetRandomMBig
+			}
+		}
+	}
+
+	s._inext = 0
+	s._inextp = 21
+}
+
+func (s *DotNetRandom) Next() int {
+
+	locINext := s._inext + 1
+	if locINext >= 56 {
+		locINext = 1
+	}
+
+	locINextp := s._inextp + 1
+	if locINextp >= 56 {
+		locINextp = 1
+	}
+
+	retVal := s._seedArray[locINext] - s._seedArray[locINextp]
+
+	if retVal == dotNetRandomMBig {
+		retVal--
+	}
+
+	if retVal < 0 {
+		retVal += dotNetRandomMBig
+	}
+
+	s._seedArray[locINext] = retVal
+
+	s._inext = locINext
+	s._inextp = locINextp
+
+	return int(retVal)
+}
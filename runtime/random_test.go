@@ -0,0 +1,96 @@
+package runtime
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDotNetRandomMatchesSystemRandom
+// The first numbers from .NET's new Random(seed).Next().
+func TestDotNetRandomMatchesSystemRandom(t *testing.T) {
+
+	for seed, expected := range map[int][]int{
+		0:  {1559595546, 1755192844, 1649316166, 1198642031, 442452829},
+		1:  {534011718, 237820880, 1002897798, 1657007234, 1412011072},
+		42: {1434747710, 302596119, 269548474, 1122627734, 361709742},
+	} {
+		random := NewDotNetRandom(seed)
+
+		var actual []int
+		for range expected {
+			actual = append(actual, random.Next())
+		}
+		assert.Equal(t, expected, actual, "seed %d", seed)
+	}
+}
+
+func TestDotNetRandomSeed(t *testing.T) {
+
+	first := func(seed int) int {
+		return NewDotNetRandom(seed).Next()
+	}
+
+	// .NET seeds with the absolute value, and MinInt32 as MaxInt32
+	assert.Equal(t, first(1), first(-1))
+	assert.Equal(t, first(math.MaxInt32), first(math.MinInt32))
+
+	// Seeds wrap to 32 bits, as ink's C# ints do
+	assert.Equal(t, first(42), first(42+1<<32))
+
+	// Reseeding starts the sequence again
+	random := NewDotNetRandom(7)
+	random.Next()
+	random.Seed(0)
+	assert.Equal(t, 1559595546, random.Next())
+}
+
+// TestInkListDictionaryOrder
+// LIST_RANDOM counts through a list in the order C#'s Dictionary
+// enumerates it: the order items were added, with a new item taking the
+// place of the one most recently removed.
+func TestInkListDictionaryOrder(t *testing.T) {
+
+	red := NewInkListItem("colours", "red")
+	green := NewInkListItem("colours", "green")
+	blue := NewInkListItem("colours", "blue")
+	yellow := NewInkListItem("colours", "yellow")
+	cat := NewInkListItem("animals", "cat")
+
+	names := func(list *InkList) []string {
+		var names []string
+		for _, item := range list.Items() {
+			names = append(names, item.Key.ItemName())
+		}
+		return names
+	}
+
+	list := NewInkList()
+	list.Add(yellow, 4)
+	list.Add(red, 1)
+	list.Add(green, 2)
+	list.Set(blue, 3)
+	assert.Equal(t, []string{"yellow", "red", "green", "blue"}, names(list))
+
+	// Setting an item that's there doesn't move it
+	list.Set(yellow, 4)
+	assert.Equal(t, []string{"yellow", "red", "green", "blue"}, names(list))
+
+	list.Remove(red)
+	list.Remove(green)
+	list.Add(cat, 1)
+	list.Set(red, 1)
+	assert.Equal(t, []string{"yellow", "red", "cat", "blue"}, names(list))
+
+	// Copies, as every list operation makes, leave out removed entries
+	list.Remove(cat)
+	assert.Equal(t, []string{"yellow", "red", "blue"}, names(NewInkListFromInkList(list)))
+
+	greenList := NewInkListFromSingleElement(KeyValuePair[InkListItem, int]{green, 2})
+	assert.Equal(t, []string{"yellow", "red", "blue", "green"}, names(list.Union(greenList)))
+	assert.Equal(t, []string{"green", "yellow", "red", "blue"}, names(greenList.Union(list)))
+
+	redList := NewInkListFromSingleElement(KeyValuePair[InkListItem, int]{red, 1})
+	assert.Equal(t, []string{"yellow", "blue", "red"}, names(list.Without(redList).Union(redList)))
+}
@@ -0,0 +1,125 @@
+package runtime
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SaveMigration
+// Upgrades a JSON save, already parsed into a dictionary, from one save
+// version to a newer one by modifying it in place.
+type SaveMigration func(jObject map[string]interface{}) error
+
+// BinarySaveMigration
+// Upgrades a save in the binary format from one save version to a newer
+// one, returning the upgraded data.
+type BinarySaveMigration func(data []byte) ([]byte, error)
+
+type saveMigrationStep[T any] struct {
+	toVersion int
+	migrate   T
+}
+
+const saveMigrationFailed = "failed to migrate save from version %d to %d: %w"
+
+var (
+	saveMigrationsMu     sync.RWMutex
+	saveMigrations       = make(map[int]saveMigrationStep[SaveMigration])
+	binarySaveMigrations = make(map[int]saveMigrationStep[BinarySaveMigration])
+)
+
+// RegisterSaveMigration
+// Registers a function that upgrades JSON saves written with save version
+// fromVersion so that they can be loaded as toVersion. Rather than being
+// refused, saves older than KInkSaveStateVersion are passed through each
+// registered migration in turn until there are no more. Only one
+// migration can be registered from each version.
+func RegisterSaveMigration(fromVersion int, toVersion int, migrate SaveMigration) {
+
+	saveMigrationsMu.Lock()
+	defer saveMigrationsMu.Unlock()
+
+	checkSaveMigration(fromVersion, toVersion, migrate == nil)
+	if _, ok := saveMigrations[fromVersion]; ok {
+		panic(fmt.Sprintf("ink: save migration from version %d registered twice", fromVersion))
+	}
+
+	saveMigrations[fromVersion] = saveMigrationStep[SaveMigration]{toVersion, migrate}
+}
+
+// RegisterBinarySaveMigration
+// The binary format equivalent of RegisterSaveMigration.
+func RegisterBinarySaveMigration(fromVersion int, toVersion int, migrate BinarySaveMigration) {
+
+	saveMigrationsMu.Lock()
+	defer saveMigrationsMu.Unlock()
+
+	checkSaveMigration(fromVersion, toVersion, migrate == nil)
+	if _, ok := binarySaveMigrations[fromVersion]; ok {
+		panic(fmt.Sprintf("ink: binary save migration from version %d registered twice", fromVersion))
+	}
+
+	binarySaveMigrations[fromVersion] = saveMigrationStep[BinarySaveMigration]{toVersion, migrate}
+}
+
+func checkSaveMigration(fromVersion int, toVersion int, isNil bool) {
+
+	if isNil {
+		panic("ink: save migration is nil")
+	}
+
+	if toVersion <= fromVersion || toVersion > KInkSaveStateVersion {
+		panic(fmt.Sprintf("ink: can't register a save migration from version %d to %d", fromVersion, toVersion))
+	}
+}
+
+// migrateSave
+// Runs the registered migrations on a JSON save of the given version,
+// returning the version it ends up as.
+func migrateSave(jObject map[string]interface{}, version int) (int, error) {
+
+	saveMigrationsMu.RLock()
+	defer saveMigrationsMu.RUnlock()
+
+	for version < KInkSaveStateVersion {
+
+		step, ok := saveMigrations[version]
+		if !ok {
+			break
+		}
+
+		if err := step.migrate(jObject); err != nil {
+			return version, fmt.Errorf(saveMigrationFailed, version, step.toVersion, err)
+		}
+
+		version = step.toVersion
+		jObject["inkSaveVersion"] = version
+	}
+
+	return version, nil
+}
+
+// migrateBinarySave
+// The binary format equivalent of migrateSave.
+func migrateBinarySave(data []byte, version int) ([]byte, int, error) {
+
+	saveMigrationsMu.RLock()
+	defer saveMigrationsMu.RUnlock()
+
+	for version < KInkSaveStateVersion {
+
+		step, ok := binarySaveMigrations[version]
+		if !ok {
+			break
+		}
+
+		migrated, err := step.migrate(data)
+		if err != nil {
+			return data, version, fmt.Errorf(saveMigrationFailed, version, step.toVersion, err)
+		}
+
+		data, version = migrated, step.toVersion
+	}
+
+	return data, version, nil
+}
@@ -0,0 +1,520 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Save validation
+//
+// When a story is changed and exported again, saves made with the old
+// version can refer to content that no longer exists. Validate and
+// ValidateSave find those references, and RepairSave and
+// LoadJsonRepaired either remap them to where the content has moved or
+// drop them, so that the save can still be loaded.
+
+// SaveIssueKind
+// The kind of problem found in a save.
+type SaveIssueKind int
+
+const (
+	// SaveIssueMissingPath
+	// A path to content that isn't in the story.
+	SaveIssueMissingPath SaveIssueKind = iota
+
+	// SaveIssueUnknownGlobal
+	// A global variable that the story doesn't declare.
+	SaveIssueUnknownGlobal
+
+	// SaveIssueUnknownListOrigin
+	// A list value holding an item from, or naming as an origin, a list
+	// that the story doesn't define.
+	SaveIssueUnknownListOrigin
+
+	// SaveIssueUnknownListItem
+	// A list value holding an item that its list doesn't define.
+	SaveIssueUnknownListItem
+)
+
+func (s SaveIssueKind) String() string {
+	switch s {
+	case SaveIssueMissingPath:
+		return "missing path"
+	case SaveIssueUnknownGlobal:
+		return "unknown global"
+	case SaveIssueUnknownListOrigin:
+		return "unknown list origin"
+	default:
+		return "unknown list item"
+	}
+}
+
+// SaveRepair
+// What was done about a SaveIssue when repairing a save.
+type SaveRepair int
+
+const (
+	// SaveRepairNone
+	// Nothing; the save was only validated.
+	SaveRepairNone SaveRepair = iota
+
+	// SaveRepairRemapped
+	// The path was replaced using the renames given to RepairSave.
+	SaveRepairRemapped
+
+	// SaveRepairDropped
+	// The reference was removed from the save. Visit counts and globals
+	// go back to their defaults, choices are removed, and a call stack
+	// element that's dropped no longer points anywhere, so that the
+	// thread ends when it's reached.
+	SaveRepairDropped
+)
+
+// SaveIssue
+// A reference in a save to something that the story doesn't have.
+type SaveIssue struct {
+	Kind SaveIssueKind
+
+	// Where the reference is in the save JSON, such as "visitCounts" or
+	// "flows.DEFAULT_FLOW.callstack.threads[0].callstack[1]".
+	Location string
+
+	// The path, variable name or list item that couldn't be found.
+	Value string
+
+	Repair SaveRepair
+
+	// The path that Value was remapped to, for SaveRepairRemapped.
+	RemappedTo string
+}
+
+func (s SaveIssue) String() string {
+
+	str := fmt.Sprintf("%s: %s '%s'", s.Location, s.Kind, s.Value)
+
+	switch s.Repair {
+	case SaveRepairRemapped:
+		str += " (remapped to '" + s.RemappedTo + "')"
+	case SaveRepairDropped:
+		str += " (dropped)"
+	}
+
+	return str
+}
+
+// Validate
+// Checks this state against story, which is usually a newer version of
+// the story that the state belongs to, and reports anything in it that
+// story doesn't have. As with ValidateSave, the error is for a save that
+// can't be checked at all.
+func (s *StoryState) Validate(story *Story) ([]SaveIssue, error) {
+
+	save, err := s.ToJson()
+	if err != nil {
+		return nil, err
+	}
+
+	return ValidateSave(save, story)
+}
+
+// ValidateSave
+// Checks a save in JSON format against story without loading it, and
+// reports anything in it that story doesn't have.
+func ValidateSave(json string, story *Story) ([]SaveIssue, error) {
+
+	jObject, err := TextToDictionary(json)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkSave(jObject, story, nil, false)
+}
+
+// RepairSave
+// Checks a save in JSON format against story, and returns it with each
+// reference that story doesn't have either remapped or dropped. Paths are
+// remapped using renames, which maps old paths to new ones; a rename
+// of "knot" also applies to "knot.stitch" and everything else inside it.
+// The issues returned say what was done about each reference.
+func RepairSave(json string, story *Story, renames map[string]string) (string, []SaveIssue, error) {
+
+	jObject, err := TextToDictionary(json)
+	if err != nil {
+		return "", nil, err
+	}
+
+	issues, err := checkSave(jObject, story, renames, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	writer := NewWriter()
+	writeJsonToken(writer, jObject)
+
+	return writer.String(), issues, nil
+}
+
+// LoadJsonRepaired
+// Loads a save as LoadJson does, first repairing it against this state's
+// story as RepairSave does. A save that passes the checks but still can't
+// be loaded returns an error and leaves the state as it was.
+func (s *StoryState) LoadJsonRepaired(json string, renames map[string]string) ([]SaveIssue, error) {
+
+	jObject, err := TextToDictionary(json)
+	if err != nil {
+		return nil, err
+	}
+
+	issues, err := checkSave(jObject, s._story, renames, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.LoadJsonObj(jObject); err != nil {
+		return issues, err
+	}
+
+	if s.OnDidLoadState != nil {
+		s.OnDidLoadState.Emit()
+	}
+
+	return issues, nil
+}
+
+type saveChecker struct {
+	story   *Story
+	renames map[string]string
+	repair  bool
+	issues  []SaveIssue
+}
+
+// checkSave
+// Validates, or repairs in place, a save that's been parsed into a
+// dictionary. Saves from before multiple flows only have their variables
+// and counts checked.
+func checkSave(jObject map[string]interface{}, story *Story, renames map[string]string, repair bool) (issues []SaveIssue, err error) {
+
+	defer recoverUnmarshal("save", &err)
+
+	jSaveVersion, ok := jObject["inkSaveVersion"].(int)
+	if !ok {
+		return nil, fmt.Errorf("%w: save format incorrect, can't load", ErrSaveFormatIncompatible)
+	}
+
+	// Check the save as it'll be loaded
+	if jSaveVersion, err = migrateSave(jObject, jSaveVersion); err != nil {
+		return nil, err
+	}
+
+	if jSaveVersion < kMinCompatibleLoadVersion {
+		return nil, fmt.Errorf("%w: saw '%d', but minimum is %d", ErrSaveFormatIncompatible, jSaveVersion, kMinCompatibleLoadVersion)
+	}
+
+	c := &saveChecker{story: story, renames: renames, repair: repair}
+
+	if flowsObj, ok := jObject["flows"].(map[string]interface{}); ok {
+		for _, name := range SortedKeys(flowsObj) {
+			c.checkFlow("flows."+name, flowsObj[name].(map[string]interface{}))
+		}
+	}
+
+	c.checkGlobals("variablesState", jObject["variablesState"].(map[string]interface{}))
+
+	if evalStack, ok := jObject["evalStack"].([]interface{}); ok {
+		jObject["evalStack"] = c.checkValues("evalStack", evalStack)
+	}
+
+	if currentDivertTarget, ok := jObject["currentDivertTarget"].(string); ok {
+		if newPath, drop := c.checkPath("currentDivertTarget", currentDivertTarget, false); drop {
+			delete(jObject, "currentDivertTarget")
+		} else {
+			jObject["currentDivertTarget"] = newPath
+		}
+	}
+
+	c.checkCounts("visitCounts", jObject["visitCounts"].(map[string]interface{}))
+	c.checkCounts("turnIndices", jObject["turnIndices"].(map[string]interface{}))
+
+	return c.issues, nil
+}
+
+func (c *saveChecker) checkFlow(location string, flowObj map[string]interface{}) {
+
+	callStackObj := flowObj["callstack"].(map[string]interface{})
+	for i, threadObj := range callStackObj["threads"].([]interface{}) {
+		c.checkThread(location+".callstack.threads["+strconv.Itoa(i)+"]", threadObj.(map[string]interface{}))
+	}
+
+	flowObj["outputStream"] = c.checkValues(location+".outputStream", flowObj["outputStream"].([]interface{}))
+
+	if choiceThreads, ok := flowObj["choiceThreads"].(map[string]interface{}); ok {
+		for _, key := range SortedKeys(choiceThreads) {
+			c.checkThread(location+".choiceThreads."+key, choiceThreads[key].(map[string]interface{}))
+		}
+	}
+
+	choices := []interface{}{}
+	for i, choiceObj := range flowObj["currentChoices"].([]interface{}) {
+
+		choice := choiceObj.(map[string]interface{})
+		choiceLocation := location + ".currentChoices[" + strconv.Itoa(i) + "]"
+
+		newPath, drop := c.checkPath(choiceLocation, choice["targetPath"].(string), false)
+		if drop {
+			continue
+		}
+
+		choice["targetPath"] = newPath
+		choices = append(choices, choice)
+	}
+	flowObj["currentChoices"] = choices
+}
+
+func (c *saveChecker) checkThread(location string, threadObj map[string]interface{}) {
+
+	for i, elementObj := range threadObj["callstack"].([]interface{}) {
+
+		element := elementObj.(map[string]interface{})
+		elementLocation := location + ".callstack[" + strconv.Itoa(i) + "]"
+
+		if cPath, ok := element["cPath"].(string); ok {
+			if newPath, drop := c.checkPath(elementLocation, cPath, true); drop {
+				delete(element, "cPath")
+				delete(element, "idx")
+			} else {
+				element["cPath"] = newPath
+			}
+		}
+
+		if temps, ok := element["temp"].(map[string]interface{}); ok {
+			for _, name := range SortedKeys(temps) {
+				if !c.checkValue(elementLocation+".temp."+name, temps[name]) {
+					delete(temps, name)
+				}
+			}
+		}
+	}
+
+	if previous, ok := threadObj["previousContentObject"].(string); ok {
+		if newPath, drop := c.checkPath(location+".previousContentObject", previous, false); drop {
+			delete(threadObj, "previousContentObject")
+		} else {
+			threadObj["previousContentObject"] = newPath
+		}
+	}
+}
+
+func (c *saveChecker) checkGlobals(location string, globals map[string]interface{}) {
+
+	for _, name := range SortedKeys(globals) {
+
+		if !c.story.VariablesState().GlobalVariableExistsWithName(name) {
+			c.report(SaveIssue{Kind: SaveIssueUnknownGlobal, Location: location, Value: name}, true)
+			if c.repair {
+				delete(globals, name)
+			}
+			continue
+		}
+
+		if !c.checkValue(location+"."+name, globals[name]) {
+			delete(globals, name)
+		}
+	}
+}
+
+// checkValues
+// Checks each value in a list, returning the list without those that
+// were dropped.
+func (c *saveChecker) checkValues(location string, values []interface{}) []interface{} {
+
+	kept := []interface{}{}
+	for i, value := range values {
+		if c.checkValue(location+"["+strconv.Itoa(i)+"]", value) {
+			kept = append(kept, value)
+		}
+	}
+
+	return kept
+}
+
+// checkValue
+// Checks the divert targets and lists that a value refers to, returning
+// false if the value should be dropped.
+func (c *saveChecker) checkValue(location string, token interface{}) bool {
+
+	obj, ok := token.(map[string]interface{})
+	if !ok {
+		return true
+	}
+
+	if target, ok := obj["^->"].(string); ok {
+		newPath, drop := c.checkPath(location, target, false)
+		obj["^->"] = newPath
+		return !drop
+	}
+
+	if items, ok := obj["list"].(map[string]interface{}); ok {
+		c.checkList(location, obj, items)
+	}
+
+	return true
+}
+
+// checkList
+// Checks each item in a list value is still defined by the story, and
+// drops those that aren't. Lists aren't remapped.
+func (c *saveChecker) checkList(location string, listObj map[string]interface{}, items map[string]interface{}) {
+
+	listDefs := c.story.ListDefinitions()
+
+	for _, fullName := range SortedKeys(items) {
+
+		originName, itemName, _ := strings.Cut(fullName, ".")
+
+		var def *ListDefinition
+		if listDefs != nil {
+			def, _ = listDefs.TryListGetDefinition(originName)
+		}
+
+		issue := SaveIssue{Location: location, Value: fullName}
+		if def == nil {
+			issue.Kind = SaveIssueUnknownListOrigin
+		} else if !def.ContainsItemWithName(itemName) {
+			issue.Kind = SaveIssueUnknownListItem
+		} else {
+			continue
+		}
+
+		c.report(issue, true)
+		if c.repair {
+			delete(items, fullName)
+		}
+	}
+
+	origins, ok := listObj["origins"].([]interface{})
+	if !ok {
+		return
+	}
+
+	var kept []interface{}
+	for _, origin := range origins {
+
+		originName := origin.(string)
+		if listDefs != nil {
+			if _, ok := listDefs.TryListGetDefinition(originName); ok {
+				kept = append(kept, origin)
+				continue
+			}
+		}
+
+		c.report(SaveIssue{Kind: SaveIssueUnknownListOrigin, Location: location + ".origins", Value: originName}, true)
+		if !c.repair {
+			kept = append(kept, origin)
+		}
+	}
+
+	if len(kept) > 0 {
+		listObj["origins"] = kept
+	} else {
+		delete(listObj, "origins")
+	}
+}
+
+// checkCounts
+// Checks the paths of visit counts or turn indices. When a count is
+// remapped onto a path that already has one, the existing count is kept.
+func (c *saveChecker) checkCounts(location string, counts map[string]interface{}) {
+
+	for _, pathStr := range SortedKeys(counts) {
+
+		newPath, drop := c.checkPath(location, pathStr, true)
+		if newPath == pathStr {
+			continue
+		}
+
+		count := counts[pathStr]
+		delete(counts, pathStr)
+
+		if !drop {
+			AddToMap(counts, newPath, count)
+		}
+	}
+}
+
+// checkPath
+// Checks that the story has content at pathStr, reporting it if not.
+// When repairing, the path is remapped if there's a rename for it, and
+// otherwise drop is true to say that the reference should be removed.
+func (c *saveChecker) checkPath(location string, pathStr string, containerOnly bool) (newPath string, drop bool) {
+
+	if c.contentExists(pathStr, containerOnly) {
+		return pathStr, false
+	}
+
+	issue := SaveIssue{Kind: SaveIssueMissingPath, Location: location, Value: pathStr}
+
+	if !c.repair {
+		c.report(issue, false)
+		return pathStr, false
+	}
+
+	if renamed, ok := c.rename(pathStr); ok && c.contentExists(renamed, containerOnly) {
+		issue.Repair = SaveRepairRemapped
+		issue.RemappedTo = renamed
+		c.report(issue, false)
+		return renamed, false
+	}
+
+	c.report(issue, true)
+	return "", true
+}
+
+// report
+// Records an issue, which is dropped if it's being repaired and hasn't
+// been remapped.
+func (c *saveChecker) report(issue SaveIssue, dropped bool) {
+
+	if c.repair && dropped {
+		issue.Repair = SaveRepairDropped
+	}
+
+	c.issues = append(c.issues, issue)
+}
+
+// contentExists
+// Reports whether the story has content at exactly pathStr, rather than
+// only an approximation of it. The empty path is the root container.
+func (c *saveChecker) contentExists(pathStr string, containerOnly bool) bool {
+
+	result := c.story.ContentAtPath(NewPathFromString(pathStr))
+	if result.Obj == nil || result.Approximate {
+		return false
+	}
+
+	if containerOnly {
+		_, ok := result.Obj.(*Container)
+		return ok
+	}
+
+	return true
+}
+
+// rename
+// Finds the rename for the longest part of pathStr that has one, such as
+// "knot" for "knot.stitch.3".
+func (c *saveChecker) rename(pathStr string) (string, bool) {
+
+	prefix := pathStr
+	for {
+		if renamed, ok := c.renames[prefix]; ok {
+			return renamed + pathStr[len(prefix):], true
+		}
+
+		i := strings.LastIndexByte(prefix, '.')
+		if i < 0 {
+			return "", false
+		}
+		prefix = prefix[:i]
+	}
+}
@@ -0,0 +1,195 @@
+package runtime
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRenamedInterceptStory
+// TheIntercept as it would be exported again after renaming the stitch
+// start.waited to start.lingered.
+func newRenamedInterceptStory(t *testing.T) *Story {
+
+	b, err := os.ReadFile(theInterceptPath)
+	require.NoError(t, err)
+
+	json := strings.NewReplacer(`"waited"`, `"lingered"`, `.waited`, `.lingered`).Replace(string(b))
+	return newTestStory(t, json)
+}
+
+func TestValidateSaveSameStory(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 6)
+
+	issues, err := ValidateSave(stateJson(t, story.State()), story)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+
+	issues, err = story.State().Validate(story)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestValidateSaveRenamedStitch(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 6)
+
+	renamed := newRenamedInterceptStory(t)
+
+	issues, err := story.State().Validate(renamed)
+	require.NoError(t, err)
+	require.NotEmpty(t, issues)
+
+	locations := map[string]bool{}
+	for _, issue := range issues {
+		assert.Equal(t, SaveIssueMissingPath, issue.Kind, issue)
+		assert.Equal(t, SaveRepairNone, issue.Repair, issue)
+		assert.True(t, strings.HasPrefix(issue.Value, "start.waited"), issue)
+		locations[issue.Location] = true
+	}
+
+	assert.True(t, locations["visitCounts"])
+	assert.True(t, locations["flows.DEFAULT_FLOW.callstack.threads[0].callstack[0]"])
+	assert.True(t, locations["flows.DEFAULT_FLOW.callstack.threads[0].callstack[0].temp.$r"])
+	assert.True(t, locations["flows.DEFAULT_FLOW.callstack.threads[0].previousContentObject"])
+}
+
+func TestRepairSaveRemapsPaths(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 6)
+
+	renamed := newRenamedInterceptStory(t)
+
+	save, issues, err := RepairSave(stateJson(t, story.State()), renamed, map[string]string{"start.waited": "start.lingered"})
+	require.NoError(t, err)
+	require.NotEmpty(t, issues)
+
+	for _, issue := range issues {
+		assert.Equal(t, SaveRepairRemapped, issue.Repair, issue)
+		assert.Equal(t, strings.Replace(issue.Value, "waited", "lingered", 1), issue.RemappedTo)
+	}
+
+	// The repaired save is valid, and plays on just as the original does
+	remaining, err := ValidateSave(save, renamed)
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+
+	require.NoError(t, renamed.State().LoadJson(save))
+	visits, err := renamed.State().VisitCountAtPathString("start.lingered")
+	require.NoError(t, err)
+	assert.Equal(t, 1, visits)
+
+	for i := 0; i < 3; i++ {
+		expected, err := story.ContinueMaximally()
+		require.NoError(t, err)
+		text, err := renamed.ContinueMaximally()
+		require.NoError(t, err)
+		assert.Equal(t, expected, text)
+
+		require.NotEmpty(t, story.CurrentChoices())
+		require.NoError(t, story.ChooseChoiceIndex(0))
+		require.NoError(t, renamed.ChooseChoiceIndex(0))
+	}
+}
+
+func TestLoadJsonRepairedDropsPaths(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 6)
+
+	renamed := newRenamedInterceptStory(t)
+
+	issues, err := renamed.State().LoadJsonRepaired(stateJson(t, story.State()), nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, issues)
+
+	for _, issue := range issues {
+		assert.Equal(t, SaveRepairDropped, issue.Repair, issue)
+	}
+
+	visits, err := renamed.State().VisitCountAtPathString("start")
+	require.NoError(t, err)
+	assert.Equal(t, 1, visits)
+
+	_, err = renamed.ContinueMaximally()
+	assert.NoError(t, err)
+}
+
+func TestRepairSaveGlobalsAndLists(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/lists.ink.json")
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+
+	save := strings.Replace(stateJson(t, story.State()), `"variablesState":{`,
+		`"variablesState":{"ghost":1,"palette":{"list":{"colours.blue":3,"colours.teal":9,"flavours.mint":1},"origins":["flavours"]},`, 1)
+
+	issues, err := ValidateSave(save, story)
+	require.NoError(t, err)
+
+	var descriptions []string
+	for _, issue := range issues {
+		descriptions = append(descriptions, issue.String())
+	}
+	assert.Contains(t, descriptions, "variablesState: unknown global 'ghost'")
+	assert.Contains(t, descriptions, "variablesState: unknown global 'palette'")
+
+	// A list value inside a known global
+	save = strings.Replace(stateJson(t, story.State()), `"colours.blue":3`, `"colours.blue":3,"colours.teal":9,"flavours.mint":1`, 1)
+
+	issues, err = ValidateSave(save, story)
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+	assert.Equal(t, SaveIssue{Kind: SaveIssueUnknownListItem, Location: "variablesState.colours", Value: "colours.teal"}, issues[0])
+	assert.Equal(t, SaveIssue{Kind: SaveIssueUnknownListOrigin, Location: "variablesState.colours", Value: "flavours.mint"}, issues[1])
+
+	repaired, issues, err := RepairSave(save, story, nil)
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+	assert.Equal(t, SaveRepairDropped, issues[0].Repair)
+
+	loaded := newTestStoryFromFile(t, "testdata/conformance/lists.ink.json")
+	require.NoError(t, loaded.State().LoadJson(repaired))
+	assert.Equal(t, "blue", loaded.VariablesState().GetVariable("colours").(*InkList).String())
+}
+
+func TestValidateSaveInvalid(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+
+	_, err := ValidateSave(`{"inkSaveVersion":10}`, story)
+	assert.Error(t, err)
+
+	_, err = ValidateSave(`{"inkSaveVersion":7}`, story)
+	assert.ErrorIs(t, err, ErrSaveFormatIncompatible)
+
+	_, _, err = RepairSave(`{`, story, nil)
+	assert.Error(t, err)
+}
+
+func TestLoadJsonRepairedInvalid(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 2)
+	before := stateJson(t, story.State())
+
+	// Nothing in the save is missing from the story, so it validates,
+	// but it can't be loaded
+	save := strings.Replace(before, `"turnIdx":`, `"turnIdx":"2","x":`, 1)
+	issues, err := ValidateSave(save, story)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+
+	require.NotPanics(t, func() {
+		_, err = story.State().LoadJsonRepaired(save, nil)
+	})
+	assert.Error(t, err)
+	assert.Equal(t, before, stateJson(t, story.State()))
+}
@@ -1,13 +1,90 @@
 package runtime
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"math"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
 )
 
-func TextToDictionary(text string) map[string]interface{} {
-	return NewReader(text).ToMap()
+var (
+	// MaxJsonDepth
+	// How deeply objects and arrays can be nested in the JSON that's read,
+	// so that malformed input can't exhaust the stack. Compiled stories
+	// come nowhere near it.
+	MaxJsonDepth = 1000
+
+	// MaxJsonSize
+	// The most bytes that are read for a single JSON value, such as a
+	// story or a save, before it's rejected.
+	MaxJsonSize = 512 << 20
+)
+
+// JsonSyntaxError
+// Returned when JSON is malformed or goes past MaxJsonDepth or
+// MaxJsonSize. Line and Column count from 1, and Column is in bytes.
+type JsonSyntaxError struct {
+	Message string
+	Offset  int
+	Line    int
+	Column  int
+}
+
+func (s *JsonSyntaxError) Error() string {
+	return fmt.Sprintf("invalid JSON at line %d, column %d: %s", s.Line, s.Column, s.Message)
+}
+
+// jsonReadError
+// An error from the underlying io.Reader, raised as a panic in the same
+// way as a JsonSyntaxError.
+type jsonReadError struct {
+	err          error
+	line, column int
+}
+
+// TextToDictionary
+// Parses a JSON object. Malformed JSON is reported as a *JsonSyntaxError
+// rather than a panic.
+func TextToDictionary(text string) (map[string]interface{}, error) {
+	return ReaderToDictionary(strings.NewReader(text))
+}
+
+// ReaderToDictionary
+// Parses a JSON object as it's read from r, without first reading the
+// whole of r into memory. Malformed JSON is reported as a
+// *JsonSyntaxError rather than a panic, and errors from r are wrapped.
+func ReaderToDictionary(r io.Reader) (dict map[string]interface{}, err error) {
+
+	defer recoverJson(&err)
+
+	dict, ok := NewStreamReader(r).rootObject.(map[string]interface{})
+	if !ok {
+		return nil, &JsonSyntaxError{Message: "expected an object at the top level", Line: 1, Column: 1}
+	}
+
+	return dict, nil
+}
+
+// recoverJson
+// Turns the panics that the Reader raises for malformed JSON back into
+// an error.
+func recoverJson(err *error) {
+
+	if r := recover(); r != nil {
+		switch e := r.(type) {
+		case *JsonSyntaxError:
+			*err = e
+		case *jsonReadError:
+			*err = fmt.Errorf("failed to read JSON at line %d, column %d: %w", e.line, e.column, e.err)
+		default:
+			panic(r)
+		}
+	}
 }
 
 func TextToArray(text string) []interface{} {
@@ -15,27 +92,43 @@ func TextToArray(text string) []interface{} {
 }
 
 type Reader struct {
-	text       string
+	r          *bufio.Reader
 	offset     int
 	rootObject interface{}
+
+	// Private
+	numberBuffer []byte
+	line         int
+	column       int
+	depth        int
 }
 
 func NewReader(text string) *Reader {
+	return NewStreamReader(strings.NewReader(text))
+}
 
-	ByteOrderMarkAsString := string('\uFEFF')
-
-	if strings.HasPrefix(text, ByteOrderMarkAsString) {
+// NewStreamReader
+// Parses the JSON that's read from r. Like NewReader, it panics with a
+// *JsonSyntaxError if the JSON is malformed, and TextToDictionary or
+// ReaderToDictionary should be used for input that isn't trusted.
+func NewStreamReader(r io.Reader) *Reader {
 
-		fmt.Printf("Found leading Byte Order Mark sequence!\n")
+	s := &Reader{r: bufio.NewReader(r), offset: 0, line: 1, column: 1}
 
-		text = strings.TrimPrefix(text, ByteOrderMarkAsString)
+	// Skip the UTF-8 byte order mark
+	if bom, _ := s.r.Peek(3); string(bom) == "\uFEFF" {
+		s.offset, _ = s.r.Discard(3)
 	}
 
-	r := &Reader{text: text, offset: 0}
+	s.skipWhitespace()
+	s.rootObject = s.readObject()
 
-	r.skipWhitespace()
-	r.rootObject = r.readObject()
-	return r
+	s.skipWhitespace()
+	if c, ok := s.peek(); ok {
+		s.fail("unexpected %q after the end of the JSON", c)
+	}
+
+	return s
 }
 
 func (s *Reader) ToMap() map[string]interface{} {
@@ -54,12 +147,91 @@ func IsFirstNumberChar(c uint8) bool {
 	return c >= '0' && c <= '9' || c == '-' || c == '+'
 }
 
-func (s *Reader) readObject() interface{} {
+// fail
+// Panics with a JsonSyntaxError at the current position.
+func (s *Reader) fail(format string, args ...interface{}) {
+
+	panic(&JsonSyntaxError{
+		Message: fmt.Sprintf(format, args...),
+		Offset:  s.offset,
+		Line:    s.line,
+		Column:  s.column,
+	})
+}
+
+// peek
+// Returns the next byte without consuming it, or false at the end of
+// the input.
+func (s *Reader) peek() (byte, bool) {
 
-	currentChar := s.text[s.offset]
+	b, err := s.r.Peek(1)
+	if err != nil {
+		s.checkReadError(err)
+		return 0, false
+	}
+
+	return b[0], true
+}
+
+// readByte
+// Consumes the next byte, failing at the end of the input.
+func (s *Reader) readByte() byte {
+
+	c, err := s.r.ReadByte()
+	if err != nil {
+		s.checkReadError(err)
+		s.fail("unexpected end of input")
+	}
+
+	s.offset++
+	if s.offset > MaxJsonSize {
+		s.fail("larger than %d bytes", MaxJsonSize)
+	}
 
-	if rune(currentChar) == '\uFEFF' {
-		fmt.Println("BOM")
+	if c == '\n' {
+		s.line++
+		s.column = 1
+	} else {
+		s.column++
+	}
+
+	return c
+}
+
+func (s *Reader) discard(n int) {
+	for i := 0; i < n; i++ {
+		s.readByte()
+	}
+}
+
+// checkReadError
+// Panics with any error from the underlying io.Reader other than io.EOF.
+func (s *Reader) checkReadError(err error) {
+	if err != io.EOF && err != bufio.ErrBufferFull {
+		panic(&jsonReadError{err, s.line, s.column})
+	}
+}
+
+// enter
+// Called on starting an object or array, to keep track of how deeply
+// they're nested. leave must be called at the end of it.
+func (s *Reader) enter() {
+
+	s.depth++
+	if s.depth > MaxJsonDepth {
+		s.fail("nested more than %d deep", MaxJsonDepth)
+	}
+}
+
+func (s *Reader) leave() {
+	s.depth--
+}
+
+func (s *Reader) readObject() interface{} {
+
+	currentChar, ok := s.peek()
+	if !ok {
+		s.fail("unexpected end of input")
 	}
 
 	if currentChar == '{' {
@@ -90,11 +262,15 @@ func (s *Reader) readObject() interface{} {
 		return nil
 	}
 
-	panic(fmt.Sprintf("Unhandled object type in JSON: %s", s.text[s.offset:s.offset+30]))
+	s.fail("unexpected %q", currentChar)
+	return nil
 }
 
 func (s *Reader) ReadDictionary() map[string]interface{} {
 
+	s.enter()
+	defer s.leave()
+
 	dict := make(map[string]interface{}, 0)
 
 	s.expect("{")
@@ -111,7 +287,6 @@ func (s *Reader) ReadDictionary() map[string]interface{} {
 
 		// Key
 		key := s.readString()
-		s.expectCondition(key != "", "dictionary key")
 
 		s.skipWhitespace()
 
@@ -126,7 +301,7 @@ func (s *Reader) ReadDictionary() map[string]interface{} {
 
 		// Add to dictionary
 		if _, ok := dict[key]; ok {
-			panic("key already in map")
+			s.fail("duplicate key %q", key)
 		}
 		dict[key] = val
 
@@ -140,6 +315,9 @@ func (s *Reader) ReadDictionary() map[string]interface{} {
 
 func (s *Reader) ReadArray() []interface{} {
 
+	s.enter()
+	defer s.leave()
+
 	var list []interface{}
 
 	s.expect("[")
@@ -173,16 +351,25 @@ func (s *Reader) readString() string {
 
 	sb := strings.Builder{}
 
-	for ; s.offset < len(s.text); s.offset++ {
-		c := s.text[s.offset]
+	for {
+		c, ok := s.peek()
+		if !ok {
+			s.fail("unterminated string")
+		}
+
+		if c == '"' {
+			break
+		}
+
+		s.readByte()
+
+		if c < ' ' {
+			s.fail("control character %q in string", c)
+		}
 
 		if c == '\\' {
 			// Escaped character
-			s.offset++
-			if s.offset >= len(s.text) {
-				panic("Unexpected EOF while reading string")
-			}
-			c = s.text[s.offset]
+			c = s.readByte()
 			switch c {
 			case '"':
 				fallthrough
@@ -193,7 +380,7 @@ func (s *Reader) readString() string {
 			case 'n':
 				sb.WriteByte('\n')
 			case 't':
-				sb.WriteByte('t')
+				sb.WriteByte('\t')
 			case 'r':
 				fallthrough
 			case 'b':
@@ -201,26 +388,21 @@ func (s *Reader) readString() string {
 			case 'f':
 			// Ignore other control characters
 			case 'u':
-				// 4-digit Unicode
-				if s.offset+4 >= len(s.text) {
-					panic("Unexpected EOF while reading string")
-				}
-				digits := s.text[s.offset+1 : s.offset+5]
-				// remove 0x suffix if found in the input string
-				cleaned := strings.Replace(digits, "0x", "", -1)
-				// base 16 for hexadecimal
-				uchar, err := strconv.ParseUint(cleaned, 16, 64)
-				if err == nil {
-					sb.WriteByte(uint8(uchar))
-					s.offset += 4
-				} else {
-					panic(fmt.Sprintf("Invalid Unicode escape character at offset %d", s.offset-1))
+				// 4-digit Unicode, which may be a UTF-16 surrogate pair
+				r := s.readUnicodeEscape()
+				if utf16.IsSurrogate(r) && s.tryRead("\\u") {
+					low := s.readUnicodeEscape()
+					if pair := utf16.DecodeRune(r, low); pair != unicode.ReplacementChar {
+						r = pair
+					} else {
+						sb.WriteRune(unicode.ReplacementChar)
+						r = low
+					}
 				}
+				sb.WriteRune(r)
 			default:
-				panic(fmt.Sprintf("Invalid Unicode escape character at offset %d", s.offset-1))
+				s.fail("invalid escape character %q", c)
 			}
-		} else if c == '"' {
-			break
 		} else {
 			sb.WriteByte(c)
 		}
@@ -230,25 +412,42 @@ func (s *Reader) readString() string {
 	return sb.String()
 }
 
+// readUnicodeEscape
+// Reads the 4 hex digits after \u.
+func (s *Reader) readUnicodeEscape() rune {
+
+	var digits [4]byte
+	for i := range digits {
+		digits[i] = s.readByte()
+	}
+
+	// base 16 for hexadecimal
+	uchar, err := strconv.ParseUint(string(digits[:]), 16, 16)
+	if err != nil {
+		s.fail("invalid unicode escape %q", digits[:])
+	}
+
+	return rune(uchar)
+}
+
 func (s *Reader) readNumber() interface{} {
 
-	startOffset := s.offset
+	s.numberBuffer = s.numberBuffer[:0]
 
 	isFloat := false
-	for ; s.offset < len(s.text); s.offset++ {
-		var c = s.text[s.offset]
+	for {
+		c, ok := s.peek()
+		if !ok || !IsNumberChar(c) {
+			break
+		}
 		if c == '.' || c == 'e' || c == 'E' {
 			isFloat = true
 		}
-		if IsNumberChar(c) {
-			continue
-		} else {
-			break
-		}
+		s.numberBuffer = append(s.numberBuffer, c)
+		s.readByte()
 	}
 
-	numStr := s.text[startOffset:s.offset]
-
+	numStr := string(s.numberBuffer)
 	if isFloat {
 		f, err := strconv.ParseFloat(numStr, 32)
 		if err == nil {
@@ -261,22 +460,23 @@ func (s *Reader) readNumber() interface{} {
 		}
 	}
 
-	panic("Failed to parse number value: " + numStr)
+	s.fail("invalid number %q", numStr)
+	return nil
 }
 
 func (s *Reader) tryRead(textToRead string) bool {
 
-	if s.offset+len(textToRead) > len(s.text) {
+	upcoming, err := s.r.Peek(len(textToRead))
+	if err != nil {
+		s.checkReadError(err)
 		return false
 	}
 
-	for i := 0; i < len(textToRead); i++ {
-		if textToRead[i] != s.text[s.offset+i] {
-			return false
-		}
+	if string(upcoming) != textToRead {
+		return false
 	}
 
-	s.offset += len(textToRead)
+	s.discard(len(textToRead))
 
 	return true
 }
@@ -284,7 +484,7 @@ func (s *Reader) tryRead(textToRead string) bool {
 func (s *Reader) expect(expectedStr string) {
 
 	if !s.tryRead(expectedStr) {
-		s.expectCondition(false, expectedStr)
+		s.expectCondition(false, fmt.Sprintf("%q", expectedStr))
 	}
 
 }
@@ -293,22 +493,18 @@ func (s *Reader) expectCondition(condition bool, message string) {
 
 	if !condition {
 		if message == "" {
-			message = "Unexpected token"
-		} else {
-			message = "Expected " + message
+			s.fail("unexpected token")
 		}
-		message += fmt.Sprintf(" at offset %d", s.offset)
-
-		panic(message)
+		s.fail("expected %s", message)
 	}
 }
 
 func (s *Reader) skipWhitespace() {
 
-	for s.offset < len(s.text) {
-		c := s.text[s.offset]
-		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
-			s.offset++
+	for {
+		c, ok := s.peek()
+		if ok && (c == ' ' || c == '\t' || c == '\n' || c == '\r') {
+			s.readByte()
 		} else {
 			break
 		}
@@ -331,17 +527,80 @@ type StateElement struct {
 	childCount int
 }
 
+// jsonOutput
+// The methods Writer needs from strings.Builder and bufio.Writer.
+type jsonOutput interface {
+	WriteString(s string) (int, error)
+	WriteRune(r rune) (int, error)
+}
+
 type Writer struct {
 
 	// Private
 	stateStack Stack[StateElement]
 	writer     strings.Builder
+	stream     *bufio.Writer
 }
 
 func NewWriter() *Writer {
 	return new(Writer)
 }
 
+// NewStreamWriter
+// Creates a Writer that writes to w as it goes, rather than building the
+// JSON in memory. Call Flush once the JSON is complete. String always
+// returns "" for a stream writer.
+func NewStreamWriter(w io.Writer) *Writer {
+
+	newWriter := new(Writer)
+	newWriter.stream = bufio.NewWriter(w)
+
+	return newWriter
+}
+
+// streamBuffers
+// The buffers behind borrowStreamWriter.
+var streamBuffers = sync.Pool{New: func() interface{} { return bufio.NewWriter(nil) }}
+
+// borrowStreamWriter
+// Like NewStreamWriter, but with a buffer from a pool, as a new buffer
+// can be bigger than a whole save. Call release once the writer has been
+// flushed, and don't use it afterwards.
+func borrowStreamWriter(w io.Writer) (writer *Writer, release func()) {
+
+	buffer := streamBuffers.Get().(*bufio.Writer)
+	buffer.Reset(w)
+
+	writer = new(Writer)
+	writer.stream = buffer
+
+	return writer, func() {
+		buffer.Reset(nil)
+		streamBuffers.Put(buffer)
+	}
+}
+
+// Flush
+// Writes any buffered JSON to the underlying io.Writer, and returns the
+// first error that writing to it produced.
+func (s *Writer) Flush() error {
+
+	if s.stream == nil {
+		return nil
+	}
+
+	return s.stream.Flush()
+}
+
+func (s *Writer) output() jsonOutput {
+
+	if s.stream != nil {
+		return s.stream
+	}
+
+	return &s.writer
+}
+
 func (s *Writer) WriteObjectFunc(inner func(w *Writer)) {
 	s.WriteObjectStart()
 	inner(s)
@@ -351,7 +610,7 @@ func (s *Writer) WriteObjectFunc(inner func(w *Writer)) {
 func (s *Writer) WriteObjectStart() {
 	s.StartNewObject(true)
 	s.stateStack.Push(StateElement{_type: StateObject})
-	s.writer.WriteString("{")
+	s.output().WriteString("{")
 }
 
 func (s *Writer) WriteObjectEnd() {
@@ -359,7 +618,7 @@ func (s *Writer) WriteObjectEnd() {
 	if s.State() != StateObject {
 		panic("state != StateObject")
 	}
-	s.writer.WriteString("}")
+	s.output().WriteString("}")
 	s.stateStack.Pop()
 }
 
@@ -401,12 +660,12 @@ func (s *Writer) WritePropertyStart(name interface{}) {
 	}
 
 	if s.ChildCount() > 0 {
-		s.writer.WriteString(",")
+		s.output().WriteString(",")
 	}
 
-	s.writer.WriteString("\"")
-	s.writer.WriteString(fmt.Sprint(name))
-	s.writer.WriteString("\":")
+	s.output().WriteString("\"")
+	s.output().WriteString(fmt.Sprint(name))
+	s.output().WriteString("\":")
 
 	s.IncrementChildCount()
 
@@ -437,10 +696,10 @@ func (s *Writer) WritePropertyNameStart() {
 	//Assert(state == State.Object);
 
 	if s.ChildCount() > 0 {
-		s.writer.WriteString(",")
+		s.output().WriteString(",")
 	}
 
-	s.writer.WriteString("\"")
+	s.output().WriteString("\"")
 
 	s.IncrementChildCount()
 
@@ -450,31 +709,31 @@ func (s *Writer) WritePropertyNameStart() {
 
 func (s *Writer) WritePropertyNameEnd() {
 	//Assert(state == State.PropertyName);
-	s.writer.WriteString("\":")
+	s.output().WriteString("\":")
 	// Pop PropertyName, leaving Property state
 	s.stateStack.Pop()
 }
 
 func (s *Writer) WritePropertyNameInner(str string) {
 	//Assert(state == State.PropertyName);
-	s.writer.WriteString(str)
+	s.output().WriteString(str)
 }
 
 func (s *Writer) WriteArrayStart() {
 	s.StartNewObject(true)
 	s.stateStack.Push(StateElement{_type: StateArray})
-	s.writer.WriteString("[")
+	s.output().WriteString("[")
 }
 
 func (s *Writer) WriteArrayEnd() {
 	//Assert(state == State.Array);
-	s.writer.WriteString("]")
+	s.output().WriteString("]")
 	s.stateStack.Pop()
 }
 
 func (s *Writer) WriteInt(i int) {
 	s.StartNewObject(false)
-	s.writer.WriteString(fmt.Sprint(i))
+	s.output().WriteString(fmt.Sprint(i))
 }
 
 func (s *Writer) WriteFloat(f float64) {
@@ -484,30 +743,66 @@ func (s *Writer) WriteFloat(f float64) {
 	// _writer.Write(formatStr, obj (the float)) requires boxing
 	// Following implementation seems to work ok but requires creating temporary garbage string.
 	floatStr := fmt.Sprint(f)
-	//if (floatStr == "Infinity") {
-	//	_writer.Write("3.4E+38"); // JSON doesn't support, do our best alternative
-	//} else if (floatStr == "-Infinity") {
-	//	_writer.Write("-3.4E+38"); // JSON doesn't support, do our best alternative
-	//} else if (floatStr == "NaN") {
-	//	_writer.Write("0.0"); // JSON doesn't support, not much we can do
-	//} else {
-	s.writer.WriteString(floatStr)
-	//if (!floatStr.Contains(".") && !floatStr.Contains("E"))
-	//	_writer.Write(".0"); // ensure it gets read back in as a floating point value
-	//}
+	if math.IsInf(f, 1) {
+		s.output().WriteString("3.4E+38") // JSON doesn't support, do our best alternative
+	} else if math.IsInf(f, -1) {
+		s.output().WriteString("-3.4E+38") // JSON doesn't support, do our best alternative
+	} else if math.IsNaN(f) {
+		s.output().WriteString("0.0") // JSON doesn't support, not much we can do
+	} else {
+		s.output().WriteString(floatStr)
+		if !strings.ContainsAny(floatStr, ".e") {
+			s.output().WriteString(".0") // ensure it gets read back in as a floating point value
+		}
+	}
+}
+
+// writeJsonToken
+// Writes a value parsed by Reader back out as JSON, with the keys of
+// objects in order.
+func writeJsonToken(writer *Writer, token interface{}) {
+
+	switch token := token.(type) {
+	case map[string]interface{}:
+		writer.WriteObjectStart()
+		for _, key := range SortedKeys(token) {
+			writer.WritePropertyStart(key)
+			writeJsonToken(writer, token[key])
+			writer.WritePropertyEnd()
+		}
+		writer.WriteObjectEnd()
+	case []interface{}:
+		writer.WriteArrayStart()
+		for _, value := range token {
+			writeJsonToken(writer, value)
+		}
+		writer.WriteArrayEnd()
+	case string:
+		writer.WriteString(token, true)
+	case int:
+		writer.WriteInt(token)
+	case float64:
+		writer.WriteFloat(token)
+	case bool:
+		writer.WriteBool(token)
+	case nil:
+		writer.WriteNull()
+	default:
+		panic(fmt.Sprintf("can't write %T as JSON", token))
+	}
 }
 
 // (default) escape: true
 func (s *Writer) WriteString(str string, escape bool) {
 	s.StartNewObject(false)
 
-	s.writer.WriteString("\"")
+	s.output().WriteString("\"")
 	if escape {
 		s.WriteEscapedString(str)
 	} else {
-		s.writer.WriteString(str)
+		s.output().WriteString(str)
 	}
-	s.writer.WriteString("\"")
+	s.output().WriteString("\"")
 }
 
 func (s *Writer) WriteBool(b bool) {
@@ -518,23 +813,23 @@ func (s *Writer) WriteBool(b bool) {
 		str = "true"
 	}
 
-	s.writer.WriteString(str)
+	s.output().WriteString(str)
 }
 
 func (s *Writer) WriteNull() {
 	s.StartNewObject(false)
-	s.writer.WriteString("null")
+	s.output().WriteString("null")
 }
 
 func (s *Writer) WriteStringStart() {
 	s.StartNewObject(false)
 	s.stateStack.Push(StateElement{_type: StateString})
-	s.writer.WriteString("\"")
+	s.output().WriteString("\"")
 }
 
 func (s *Writer) WriteStringEnd() {
 	//Assert(state == State.String);
-	s.writer.WriteString("\"")
+	s.output().WriteString("\"")
 	s.stateStack.Pop()
 }
 
@@ -543,7 +838,7 @@ func (s *Writer) WriteStringInner(str string, escape bool) {
 	if escape {
 		s.WriteEscapedString(str)
 	} else {
-		s.writer.WriteString(str)
+		s.output().WriteString(str)
 	}
 }
 
@@ -553,19 +848,19 @@ func (s *Writer) WriteEscapedString(str string) {
 		if c < ' ' {
 			switch c {
 			case '\n':
-				s.writer.WriteString("\\n")
+				s.output().WriteString("\\n")
 			case '\t':
-				s.writer.WriteString("\\t")
+				s.output().WriteString("\\t")
 			}
 		} else {
 			switch c {
 			case '\\':
 				fallthrough
 			case '"':
-				s.writer.WriteString("\\")
-				s.writer.WriteRune(c)
+				s.output().WriteString("\\")
+				s.output().WriteRune(c)
 			default:
-				s.writer.WriteRune(c)
+				s.output().WriteRune(c)
 			}
 		}
 	}
@@ -602,7 +897,7 @@ func (s *Writer) StartNewObject(container bool) {
 	//}
 
 	if s.State() == StateArray && s.ChildCount() > 0 {
-		s.writer.WriteString(",")
+		s.output().WriteString(",")
 	}
 
 	//if (state == State.Property)
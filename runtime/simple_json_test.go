@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextToDictionaryEscapes(t *testing.T) {
+
+	dict, err := TextToDictionary(`{"":"empty key","a":"tab\there","b":"\u00e9"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "empty key", dict[""])
+	assert.Equal(t, "tab\there", dict["a"])
+	assert.Equal(t, "é", dict["b"])
+}
+
+func TestTextToDictionarySyntaxErrors(t *testing.T) {
+
+	for _, test := range []struct {
+		json         string
+		line, column int
+		message      string
+	}{
+		{"", 1, 1, "unexpected end of input"},
+		{"{\"a\":1,\n \"b\": x}", 2, 7, "unexpected 'x'"},
+		{"{\"a\":1,\"a\":2}", 1, 13, `duplicate key "a"`},
+		{"{\"a\":\"b", 1, 8, "unterminated string"},
+		{"{\"a\":\"\n\"}", 2, 1, `control character '\n' in string`},
+		{"{\"a\":\"\\x\"}", 1, 9, `invalid escape character 'x'`},
+		{"{\"a\":\"\\u12g4\"}", 1, 13, `invalid unicode escape "12g4"`},
+		{"{\"a\":1.2.3}", 1, 11, `invalid number "1.2.3"`},
+		{"{\"a\":null}", 1, 10, "expected dictionary value"},
+		{"{\"a\":1}}", 1, 8, `unexpected '}' after the end of the JSON`},
+		{"[1]", 1, 1, "expected an object at the top level"},
+		{"\r\n\r\n  {\"a\" 1}", 3, 8, `expected ":"`},
+	} {
+		_, err := TextToDictionary(test.json)
+
+		var syntaxErr *JsonSyntaxError
+		require.ErrorAs(t, err, &syntaxErr, test.json)
+		assert.Equal(t, test.message, syntaxErr.Message, test.json)
+		assert.Equal(t, test.line, syntaxErr.Line, test.json)
+		assert.Equal(t, test.column, syntaxErr.Column, test.json)
+	}
+}
+
+func TestTextToDictionaryLimits(t *testing.T) {
+
+	deep := `{"a":` + strings.Repeat("[", MaxJsonDepth) + strings.Repeat("]", MaxJsonDepth) + "}"
+	_, err := TextToDictionary(deep)
+
+	var syntaxErr *JsonSyntaxError
+	require.ErrorAs(t, err, &syntaxErr)
+	assert.Contains(t, syntaxErr.Message, "nested more than")
+
+	defer func(maxSize int) { MaxJsonSize = maxSize }(MaxJsonSize)
+	MaxJsonSize = 16
+
+	_, err = TextToDictionary(`{"a":"0123456789"}`)
+	require.ErrorAs(t, err, &syntaxErr)
+	assert.Equal(t, "larger than 16 bytes", syntaxErr.Message)
+}
+
+func TestTextToDictionaryUnicode(t *testing.T) {
+
+	dict, err := TextToDictionary(`{"a":"\u00e9\ud83d\ude00\ud83d","b":"\ud83dx"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "é😀\uFFFD", dict["a"])
+	assert.Equal(t, "\uFFFDx", dict["b"])
+}
+
+func TestNewStoryInvalidContent(t *testing.T) {
+
+	for _, json := range []string{
+		`{"inkVersion":21,"root":["",null]}`,
+		`{"inkVersion":21,"root":[{"x":1},null]}`,
+		`{"inkVersion":21,"root":[],"listDefs":{"a":{"b":"c"}}}`,
+	} {
+		_, err := NewStory(json)
+		assert.Error(t, err, json)
+	}
+}
+
+func TestWriterFloatsStayFloats(t *testing.T) {
+
+	writer := NewWriter()
+	writer.WriteArrayStart()
+	writer.WriteFloat(2.0)
+	writer.WriteFloat(1.5)
+	writer.WriteFloat(float64(1 << 70))
+	writer.WriteInt(3)
+	writer.WriteArrayEnd()
+
+	dict, err := TextToDictionary(`{"a":` + writer.String() + `}`)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{2.0, 1.5, float64(1 << 70), 3}, dict["a"])
+}
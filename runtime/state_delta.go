@@ -0,0 +1,515 @@
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// binaryDeltaIdentifier
+// The first four bytes of every binary state delta.
+const binaryDeltaIdentifier = "INKD"
+
+// kMinCompatibleDeltaVersion
+// Deltas don't have a version of their own, but are written with the JSON
+// save version, KInkSaveStateVersion. They can only be read back at the
+// save version that was current when they were added.
+const kMinCompatibleDeltaVersion = 10
+
+// StateDelta
+// The changes from one snapshot of a StoryState to a later one, as made
+// by StoryState.Diff. Like a StatePatch, it only holds what changed: the
+// globals, visit counts and turn indices with new values, the flows that
+// were added, changed or removed, and the evaluation stack if it's
+// different. Applying it to the earlier state with ApplyDelta brings that
+// state up to date, so a full save followed by a log of deltas can be
+// replayed to restore the latest state.
+type StateDelta struct {
+
+	// Private
+	_globals                map[string]Object
+	_visitCounts            map[string]int
+	_turnIndices            map[string]int
+	_flows                  map[string]*Flow
+	_removedFlows           []string
+	_currentFlowName        string
+	_evaluationStack        []Object
+	_evaluationStackChanged bool
+	_divertedPointer        Pointer
+	_currentTurnIndex       int
+	_storySeed              int
+	_previousRandom         int
+}
+
+// Globals
+// The global variables that have changed, with their new values.
+func (s *StateDelta) Globals() map[string]Object {
+	return s._globals
+}
+
+// VisitCounts
+// The visit counts that have changed, by container path. A count that's
+// gone back to 0 has been removed.
+func (s *StateDelta) VisitCounts() map[string]int {
+	return s._visitCounts
+}
+
+// TurnIndices
+// The turn indices that have changed, by container path. An index of -1
+// has been removed.
+func (s *StateDelta) TurnIndices() map[string]int {
+	return s._turnIndices
+}
+
+// ChangedFlows
+// The names of the flows that were added or changed, sorted.
+func (s *StateDelta) ChangedFlows() []string {
+	return SortedKeys(s._flows)
+}
+
+// RemovedFlows
+// The names of the flows that were removed, sorted.
+func (s *StateDelta) RemovedFlows() []string {
+	return s._removedFlows
+}
+
+func newStateDelta() *StateDelta {
+
+	delta := new(StateDelta)
+	delta._globals = make(map[string]Object)
+	delta._visitCounts = make(map[string]int)
+	delta._turnIndices = make(map[string]int)
+	delta._flows = make(map[string]*Flow)
+	delta._divertedPointer = NullPointer
+
+	return delta
+}
+
+// Diff
+// Records the changes from prev, an earlier snapshot of this state taken
+// with Copy, to this state.
+func (s *StoryState) Diff(prev *StoryState) *StateDelta {
+
+	delta := newStateDelta()
+
+	// Flows: only those that would save differently have changed
+	flows := s.flows()
+	prevFlows := prev.flows()
+
+	for name, flow := range flows {
+		prevFlow, ok := prevFlows[name]
+		if !ok || !bytes.Equal(flowFingerprint(flow), flowFingerprint(prevFlow)) {
+			delta._flows[name] = flow.Copy()
+		}
+	}
+
+	for name := range prevFlows {
+		if _, ok := flows[name]; !ok {
+			delta._removedFlows = append(delta._removedFlows, name)
+		}
+	}
+	sort.Strings(delta._removedFlows)
+
+	delta._currentFlowName = s._currentFlow.Name
+
+	// Globals, read through the variables state so that a patch that
+	// hasn't been applied yet is included
+	variablesState, prevVariablesState := s._variablesState, prev._variablesState
+	for _, name := range variablesState.GlobalVariableNames() {
+		val := variablesState.GetRawVariableWithName(name, 0)
+		if !prevVariablesState.GlobalVariableExistsWithName(name) ||
+			!variablesState.RuntimeObjectsEqual(val, prevVariablesState.GetRawVariableWithName(name, 0)) {
+			delta._globals[name] = val
+		}
+	}
+
+	// Evaluation stack
+	if !runtimeObjectListsEqual(s._variablesState, s._evaluationStack, prev._evaluationStack) {
+		delta._evaluationStack = NewSliceFromSlice(s._evaluationStack)
+		delta._evaluationStackChanged = true
+	}
+
+	delta._divertedPointer = s.DivertedPointer
+
+	// Counts. Missing counts read as 0 and missing turn indices as -1,
+	// which is what removed ones are recorded as.
+	diffCounts(delta._visitCounts, s._visitCounts, prev._visitCounts, 0)
+	diffCounts(delta._turnIndices, s._turnIndices, prev._turnIndices, -1)
+
+	delta._currentTurnIndex = s._currentTurnIndex
+	delta._storySeed = s.StorySeed
+	delta._previousRandom = s.PreviousRandom
+
+	return delta
+}
+
+// ApplyDelta
+// Applies the changes in a delta made by Diff, bringing the state it was
+// made against up to date. Globals are set as the story sets them, so
+// variable observers are told about the ones that change. The delta
+// isn't changed, so it can be applied more than once.
+func (s *StoryState) ApplyDelta(delta *StateDelta) error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	flows := s.flows()
+	for _, name := range delta._removedFlows {
+		delete(flows, name)
+	}
+
+	// The delta's flows are copied so that it can be applied again
+	for name, flow := range delta._flows {
+		flows[name] = flow.Copy()
+	}
+
+	currentFlow, ok := flows[delta._currentFlowName]
+	if !ok {
+		return fmt.Errorf("can't apply state delta: there's no flow named '%s'", delta._currentFlowName)
+	}
+
+	// As in LoadJsonObj, a single flow is kept outside of _namedFlows
+	if len(flows) == 1 {
+		s._namedFlows = nil
+	} else {
+		s._namedFlows = flows
+	}
+	s._currentFlow = currentFlow
+
+	s.OutputStreamDirty()
+	s._aliveFlowNamesDirty = true
+
+	s._variablesState.SetCallStack(s._currentFlow.CallStack)
+
+	// Set like any other change to a global, so observers are told
+	for _, name := range SortedKeys(delta._globals) {
+		s._variablesState.SetGlobal(name, delta._globals[name])
+	}
+
+	if delta._evaluationStackChanged {
+		s._evaluationStack = NewSliceFromSlice(delta._evaluationStack)
+	}
+
+	s.DivertedPointer = delta._divertedPointer
+
+	applyCounts(s._visitCounts, delta._visitCounts, 0)
+	applyCounts(s._turnIndices, delta._turnIndices, -1)
+
+	s._currentTurnIndex = delta._currentTurnIndex
+	s.StorySeed = delta._storySeed
+	s.PreviousRandom = delta._previousRandom
+
+	if s.OnDidLoadState != nil {
+		s.OnDidLoadState.Emit()
+	}
+
+	return nil
+}
+
+// flows
+// All of the state's flows by name, whether or not it's using more than
+// the default one.
+func (s *StoryState) flows() map[string]*Flow {
+
+	if s._namedFlows == nil {
+		return map[string]*Flow{s._currentFlow.Name: s._currentFlow}
+	}
+
+	return NewMapFromMap(s._namedFlows)
+}
+
+// flowFingerprint
+// The flow in the binary save format, which is the same for two flows
+// exactly when they would load as the same flow.
+func flowFingerprint(flow *Flow) []byte {
+
+	writer := newBinarySaveWriter()
+	writer.WriteFlow(flow)
+
+	return writer.Bytes(binaryDeltaIdentifier)
+}
+
+func runtimeObjectListsEqual(variablesState *VariablesState, list []Object, other []Object) bool {
+
+	if len(list) != len(other) {
+		return false
+	}
+
+	for i, obj := range list {
+
+		if obj == other[i] {
+			continue
+		}
+
+		// Anything other than a value, like Void, has to be the same object
+		if _, ok := obj.(Value); !ok {
+			return false
+		}
+		if _, ok := other[i].(Value); !ok {
+			return false
+		}
+
+		if !variablesState.RuntimeObjectsEqual(obj, other[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func diffCounts(changed map[string]int, counts map[string]int, prevCounts map[string]int, missing int) {
+
+	for path, count := range counts {
+		prevCount, ok := prevCounts[path]
+		if !ok {
+			prevCount = missing
+		}
+		if count != prevCount {
+			changed[path] = count
+		}
+	}
+
+	for path, prevCount := range prevCounts {
+		if _, ok := counts[path]; !ok && prevCount != missing {
+			changed[path] = missing
+		}
+	}
+}
+
+func applyCounts(counts map[string]int, changed map[string]int, missing int) {
+
+	for path, count := range changed {
+		if count == missing {
+			delete(counts, path)
+		} else {
+			counts[path] = count
+		}
+	}
+}
+
+// ToJson
+// The delta in JSON, in the same form as a save that only has the parts
+// that changed.
+func (s *StateDelta) ToJson() string {
+
+	writer := new(Writer)
+	s.WriteJson(writer)
+
+	return writer.String()
+}
+
+func (s *StateDelta) WriteJson(writer *Writer) {
+
+	writer.WriteObjectStart()
+
+	writer.WritePropertyStart("flows")
+	writer.WriteObjectStart()
+	for _, name := range SortedKeys(s._flows) {
+		writer.WritePropertyStart(name)
+		s._flows[name].WriteJson(writer)
+		writer.WritePropertyEnd()
+	}
+	writer.WriteObjectEnd()
+	writer.WritePropertyEnd()
+
+	if len(s._removedFlows) > 0 {
+		writer.WritePropertyStart("removedFlows")
+		writer.WriteArrayStart()
+		for _, name := range s._removedFlows {
+			writer.WriteString(name, true)
+		}
+		writer.WriteArrayEnd()
+		writer.WritePropertyEnd()
+	}
+
+	writer.WriteStringProperty("currentFlowName", s._currentFlowName)
+
+	writer.WritePropertyStart("variablesState")
+	WriteDictionaryRuntimeObjs(writer, s._globals)
+	writer.WritePropertyEnd()
+
+	// evalStack: only when it's changed
+	if s._evaluationStackChanged {
+		writer.WritePropertyStart("evalStack")
+		WriteListRuntimeObjs(writer, s._evaluationStack)
+		writer.WritePropertyEnd()
+	}
+
+	if !s._divertedPointer.IsNull() {
+		writer.WriteStringProperty("currentDivertTarget", s._divertedPointer.Path().ComponentsString())
+	}
+
+	writer.WritePropertyStart("visitCounts")
+	WriteIntDictionary(writer, s._visitCounts)
+	writer.WritePropertyEnd()
+
+	writer.WritePropertyStart("turnIndices")
+	WriteIntDictionary(writer, s._turnIndices)
+	writer.WritePropertyEnd()
+
+	writer.WriteIntProperty("turnIdx", s._currentTurnIndex)
+	writer.WriteIntProperty("storySeed", s._storySeed)
+	writer.WriteIntProperty("previousRandom", s._previousRandom)
+
+	writer.WriteIntProperty("inkSaveVersion", KInkSaveStateVersion)
+
+	writer.WriteObjectEnd()
+}
+
+// NewStateDeltaFromJson
+// Loads a delta written by ToJson. The story is needed to find the
+// content that the delta's flows point to.
+func NewStateDeltaFromJson(json string, story *Story) (_ *StateDelta, err error) {
+
+	jObject, err := TextToDictionary(json)
+	if err != nil {
+		return nil, err
+	}
+
+	jSaveVersion, ok := jObject["inkSaveVersion"].(int)
+	if !ok {
+		return nil, fmt.Errorf("%w: state delta format incorrect, can't load", ErrSaveFormatIncompatible)
+	}
+
+	if jSaveVersion < kMinCompatibleDeltaVersion {
+		return nil, fmt.Errorf("%w: saw '%d', but minimum is %d", ErrSaveFormatIncompatible, jSaveVersion, kMinCompatibleDeltaVersion)
+	}
+
+	defer recoverUnmarshal("state delta", &err)
+
+	delta := newStateDelta()
+
+	for name, flowObj := range jObject["flows"].(map[string]interface{}) {
+		delta._flows[name] = NewFlowFromJObject(name, story, flowObj.(map[string]interface{}))
+	}
+
+	if removedFlows, ok := jObject["removedFlows"].([]interface{}); ok {
+		for _, name := range removedFlows {
+			delta._removedFlows = append(delta._removedFlows, name.(string))
+		}
+	}
+
+	delta._currentFlowName = jObject["currentFlowName"].(string)
+	delta._globals = JObjectToDictionaryRuntimeObjs(jObject["variablesState"].(map[string]interface{}))
+
+	if evalStack, ok := jObject["evalStack"]; ok {
+		delta._evaluationStack = JArrayToRuntimeObjList[Object](evalStack.([]interface{}), false)
+		delta._evaluationStackChanged = true
+	}
+
+	if currentDivertTargetPath, ok := jObject["currentDivertTarget"].(string); ok {
+		delta._divertedPointer = story.PointerAtPath(NewPathFromString(currentDivertTargetPath))
+	}
+
+	delta._visitCounts = JObjectToIntDictionary(jObject["visitCounts"].(map[string]interface{}))
+	delta._turnIndices = JObjectToIntDictionary(jObject["turnIndices"].(map[string]interface{}))
+
+	delta._currentTurnIndex = jObject["turnIdx"].(int)
+	delta._storySeed = jObject["storySeed"].(int)
+	delta._previousRandom = jObject["previousRandom"].(int)
+
+	return delta, nil
+}
+
+// WriteBinary
+// Writes the delta in the same compact form as StoryState.WriteBinary.
+func (s *StateDelta) WriteBinary(w io.Writer) error {
+
+	writer := newBinarySaveWriter()
+
+	names := SortedKeys(s._flows)
+	writer.uvarint(len(names))
+	for _, name := range names {
+		writer.WriteFlow(s._flows[name])
+	}
+
+	writer.uvarint(len(s._removedFlows))
+	for _, name := range s._removedFlows {
+		writer.string(name)
+	}
+
+	writer.string(s._currentFlowName)
+	writer.WriteVariables(s._globals)
+
+	writer.bool(s._evaluationStackChanged)
+	if s._evaluationStackChanged {
+		writer.WriteObjects(s._evaluationStack)
+	}
+
+	if s._divertedPointer.IsNull() {
+		writer.optionalString("", false)
+	} else {
+		writer.optionalString(s._divertedPointer.Path().ComponentsString(), true)
+	}
+
+	writer.WriteCounts(s._visitCounts)
+	writer.WriteCounts(s._turnIndices)
+
+	writer.varint(s._currentTurnIndex)
+	writer.varint(s._storySeed)
+	writer.varint(s._previousRandom)
+
+	_, err := w.Write(writer.Bytes(binaryDeltaIdentifier))
+	return err
+}
+
+// NewStateDeltaFromBinary
+// Loads a delta written by WriteBinary. The story is needed to find the
+// content that the delta's flows point to.
+func NewStateDeltaFromBinary(data []byte, story *Story) (_ *StateDelta, err error) {
+
+	if len(data) < 4 || string(data[:4]) != binaryDeltaIdentifier {
+		return nil, fmt.Errorf("%w: binary state delta identifier not found", ErrSaveFormatIncompatible)
+	}
+
+	saveVersion, err := binarySaveVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if saveVersion < kMinCompatibleDeltaVersion {
+		return nil, fmt.Errorf("%w: saw '%d', but minimum is %d", ErrSaveFormatIncompatible, saveVersion, kMinCompatibleDeltaVersion)
+	}
+
+	defer recoverUnmarshal("state delta", &err)
+
+	r := newBinarySaveReader(data)
+	delta := newStateDelta()
+
+	flowCount := r.length()
+	for i := 0; i < flowCount; i++ {
+		flow := r.ReadFlow(story)
+		delta._flows[flow.Name] = flow
+	}
+
+	removedFlowCount := r.length()
+	for i := 0; i < removedFlowCount; i++ {
+		delta._removedFlows = append(delta._removedFlows, r.string())
+	}
+
+	delta._currentFlowName = r.string()
+	delta._globals = r.ReadVariables()
+
+	if delta._evaluationStackChanged = r.bool(); delta._evaluationStackChanged {
+		delta._evaluationStack = r.ReadObjects()
+	}
+
+	if currentDivertTarget, ok := r.optionalString(); ok {
+		delta._divertedPointer = story.PointerAtPath(NewPathFromString(currentDivertTarget))
+	}
+
+	delta._visitCounts = r.ReadCounts()
+	delta._turnIndices = r.ReadCounts()
+
+	delta._currentTurnIndex = r.varint()
+	delta._storySeed = r.varint()
+	delta._previousRandom = r.varint()
+
+	r.end()
+
+	return delta, nil
+}
@@ -0,0 +1,213 @@
+package runtime
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deltaRoundTrips
+// Each of the ways a delta can be stored, returning it as it would be
+// loaded again.
+var deltaRoundTrips = map[string]func(t *testing.T, delta *StateDelta, story *Story) *StateDelta{
+
+	"memory": func(t *testing.T, delta *StateDelta, story *Story) *StateDelta {
+		return delta
+	},
+
+	"json": func(t *testing.T, delta *StateDelta, story *Story) *StateDelta {
+		loaded, err := NewStateDeltaFromJson(delta.ToJson(), story)
+		require.NoError(t, err)
+		return loaded
+	},
+
+	"binary": func(t *testing.T, delta *StateDelta, story *Story) *StateDelta {
+		var buf bytes.Buffer
+		require.NoError(t, delta.WriteBinary(&buf))
+		loaded, err := NewStateDeltaFromBinary(buf.Bytes(), story)
+		require.NoError(t, err)
+		return loaded
+	},
+}
+
+func TestStateDeltaReplaysTheIntercept(t *testing.T) {
+
+	for name, roundTrip := range deltaRoundTrips {
+		t.Run(name, func(t *testing.T) {
+
+			story := newTestStoryFromFile(t, theInterceptPath)
+
+			// A full save to start the log, then a delta per turn
+			replica := newTestStoryFromFile(t, theInterceptPath)
+			require.NoError(t, replica.State().LoadJson(stateJson(t, story.State())))
+
+			prev := story.State().Copy()
+			for turn := 0; turn < 5; turn++ {
+
+				_, err := story.ContinueMaximally()
+				require.NoError(t, err)
+				require.NotEmpty(t, story.CurrentChoices())
+				require.NoError(t, story.ChooseChoiceIndex(0))
+
+				delta := story.State().Diff(prev)
+				assert.Less(t, len(delta.ToJson()), len(stateJson(t, story.State())))
+
+				require.NoError(t, replica.State().ApplyDelta(roundTrip(t, delta, replica)))
+				requireSameState(t, story.State(), replica.State())
+
+				prev = story.State().Copy()
+			}
+
+			expected, err := story.ContinueMaximally()
+			require.NoError(t, err)
+			text, err := replica.ContinueMaximally()
+			require.NoError(t, err)
+			assert.Equal(t, expected, text)
+		})
+	}
+}
+
+func TestStateDeltaOnlyHoldsChanges(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/save_load.ink.json")
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+
+	prev := story.State().Copy()
+
+	delta := story.State().Diff(prev)
+	assert.Empty(t, delta.Globals())
+	assert.Empty(t, delta.VisitCounts())
+	assert.Empty(t, delta.TurnIndices())
+	assert.Empty(t, delta.ChangedFlows())
+
+	require.NoError(t, story.ChooseChoiceIndex(0))
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+
+	delta = story.State().Diff(prev)
+	assert.Equal(t, []string{"score"}, keys(delta.Globals()))
+	assert.Equal(t, 2, delta.VisitCounts()["room"])
+	assert.Equal(t, []string{kDefaultFlowName}, delta.ChangedFlows())
+}
+
+func TestStateDeltaNotifiesObservers(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/save_load.ink.json")
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+
+	replica := newTestStoryFromFile(t, "testdata/conformance/save_load.ink.json")
+	require.NoError(t, replica.State().LoadJson(stateJson(t, story.State())))
+
+	var observed []interface{}
+	_, err = replica.ObserveVariable("score", func(name string, value interface{}) {
+		observed = append(observed, value)
+	})
+	require.NoError(t, err)
+
+	prev := story.State().Copy()
+	require.NoError(t, story.ChooseChoiceIndex(0))
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+
+	score := story.VariablesState().GetVariable("score")
+
+	for name, roundTrip := range deltaRoundTrips {
+		observed = nil
+
+		require.NoError(t, replica.State().ApplyDelta(roundTrip(t, story.State().Diff(prev), replica)), name)
+		assert.Equal(t, []interface{}{score}, observed, name)
+		assert.Equal(t, score, replica.VariablesState().GetVariable("score"), name)
+	}
+}
+
+func TestStateDeltaFlows(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/multi_flow.ink.json")
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+
+	replica := newTestStoryFromFile(t, "testdata/conformance/multi_flow.ink.json")
+	require.NoError(t, replica.State().LoadJson(stateJson(t, story.State())))
+
+	// Adding a flow
+	prev := story.State().Copy()
+	require.NoError(t, story.SwitchFlow("banter"))
+	require.NoError(t, story.ChoosePathString("banter", true))
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+
+	delta := story.State().Diff(prev)
+	assert.Equal(t, []string{"banter"}, delta.ChangedFlows())
+	require.NoError(t, replica.State().ApplyDelta(delta))
+	requireSameState(t, story.State(), replica.State())
+	assert.Equal(t, "banter", replica.State().CurrentFlowName())
+
+	// Removing it again
+	prev = story.State().Copy()
+	require.NoError(t, story.RemoveFlow("banter"))
+
+	delta = story.State().Diff(prev)
+	assert.Equal(t, []string{"banter"}, delta.RemovedFlows())
+	require.NoError(t, replica.State().ApplyDelta(delta))
+	requireSameState(t, story.State(), replica.State())
+	assert.Equal(t, kDefaultFlowName, replica.State().CurrentFlowName())
+
+	// A delta can't be applied to a state without its current flow
+	require.NoError(t, story.SwitchFlow("banter"))
+	delta = story.State().Diff(story.State().Copy())
+	fresh := newTestStoryFromFile(t, "testdata/conformance/multi_flow.ink.json")
+	delta._flows = map[string]*Flow{}
+	assert.Error(t, fresh.State().ApplyDelta(delta))
+}
+
+func TestStoryStateCopyIsIndependent(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 2)
+
+	snapshot := story.State().Copy()
+	save := stateJson(t, snapshot)
+
+	playTheIntercept(t, story, 2)
+
+	expected, err := TextToDictionary(save)
+	require.NoError(t, err)
+	actual, err := TextToDictionary(stateJson(t, snapshot))
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestNewStateDeltaInvalid(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+
+	delta, err := NewStateDeltaFromJson(`{"inkSaveVersion":10}`, story)
+	assert.ErrorContains(t, err, "invalid state delta: ")
+	assert.Nil(t, delta)
+
+	_, err = NewStateDeltaFromJson(`{"inkSaveVersion":9}`, story)
+	assert.ErrorIs(t, err, ErrSaveFormatIncompatible)
+
+	var buf bytes.Buffer
+	require.NoError(t, story.State().Diff(story.State().Copy()).WriteBinary(&buf))
+	delta, err = NewStateDeltaFromBinary(buf.Bytes()[:buf.Len()-1], story)
+	assert.ErrorContains(t, err, "invalid state delta: ")
+	assert.Nil(t, delta)
+
+	_, err = NewStateDeltaFromBinary([]byte("INKS"), story)
+	assert.ErrorIs(t, err, ErrSaveFormatIncompatible)
+}
+
+func keys[T any](m map[string]T) []string {
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+
+	return names
+}
@@ -1,11 +1,18 @@
 package runtime
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math"
-	"math/rand"
-	"reflect"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/SirMetathyst/go-ink/runtime/inkjet"
+	flatbuffers "github.com/google/flatbuffers/go"
 )
 
 // InkVersionCurrent
@@ -33,7 +40,13 @@ type OnChoosePathString func(path string, arguments []interface{})
 
 type OnCompleteEvaluateFunction func(functionName string, arguments []interface{}, textOutput string, result interface{})
 
-type VariableObserver func(variableName, newValue interface{})
+type OnFlowSwitched func(previousFlowName string, flowName string)
+
+// VariableObserver
+// Called when an observed global variable changes. newValue is the
+// unwrapped value: int, float64, string, bool, *InkList or, for divert
+// targets, *Path.
+type VariableObserver func(variableName string, newValue interface{})
 
 // Assumption: prevText is the snapshot where we saw a newline, and we're checking whether we're really done
 //             with that line. Therefore prevText will definitely end in a newline.
@@ -68,6 +81,12 @@ type Story struct {
 	// Callback for when ContinueInternal is complete
 	OnDidContinue *ActionEvent
 
+	// Callback for after each step of evaluation, when the current pointer
+	// has moved on to the content that will be evaluated next. A debugger
+	// can cancel the context passed to ContinueContext from it, to pause
+	// partway through a line.
+	OnDidStep *ActionEvent
+
 	// Callback for when a choice is about to be executed
 	OnMakeChoice *ActionT1Event[*Choice]
 
@@ -81,6 +100,10 @@ type Story struct {
 	// Callback for when a path string is chosen
 	OnChoosePathString *OnChoosePathStringEvent
 
+	// Callback for when the current flow changes, whether through SwitchFlow,
+	// SwitchToDefaultFlow or by removing the current flow with RemoveFlow
+	OnFlowSwitched *OnFlowSwitchedEvent
+
 	// An ink file can provide a fallback functions for when when an EXTERNAL has been left
 	// unbound by the client, and the fallback function will be called instead. Useful when
 	// testing a story in playmode, when it's not possible to write a client-side C# external
@@ -91,7 +114,7 @@ type Story struct {
 	_mainContentContainer                   *Container
 	_listDefinitions                        *ListDefinitionsOrigin
 	_externals                              map[string]*ExternalFunctionDef
-	_variableObservers                      map[string]VariableObserver
+	_variableObservers                      map[string][]*variableObserver
 	_hasValidatedExternals                  bool
 	_temporaryEvaluationContainer           *Container
 	_state                                  *StoryState
@@ -101,6 +124,10 @@ type Story struct {
 	_recursiveContinueCount                 int         // set 0 in class def
 	_asyncSaving                            bool
 	_prevContainers                         []*Container
+	_profiler                               *Profiler
+	_busy                                   int32
+	_callingGame                            int32
+	_randomSource                           RandomSource
 }
 
 func (s *Story) CurrentChoices() []*Choice {
@@ -118,16 +145,23 @@ func (s *Story) CurrentChoices() []*Choice {
 
 // CurrentText
 // The latest line of text to be generated from a Continue() call.
+// Empty while a ContinueAsync() call is still in progress, since the
+// text is a work in progress.
 func (s *Story) CurrentText() string {
-	s.IfAsyncWeCant("call currentText since it's a work in progress")
+	if s.IfAsyncWeCant("call currentText since it's a work in progress") != nil {
+		return ""
+	}
 	return s._state.CurrentText()
 }
 
 // CurrentTags
 // Gets a list of tags as defined with '#' in source that were seen
 // during the latest Continue() call.
+// Nil while a ContinueAsync() call is still in progress.
 func (s *Story) CurrentTags() []string {
-	s.IfAsyncWeCant("call currentTags since it's a work in progress")
+	if s.IfAsyncWeCant("call currentTags since it's a work in progress") != nil {
+		return nil
+	}
 	return s._state.CurrentTags()
 }
 
@@ -137,6 +171,13 @@ func (s *Story) CurrentErrors() []string {
 	return s._state.CurrentErrors()
 }
 
+// CurrentRuntimeErrors
+// Any errors generated during evaluation of the Story, including the
+// location in the source ink where they happened.
+func (s *Story) CurrentRuntimeErrors() []*RuntimeError {
+	return s._state.CurrentRuntimeErrors()
+}
+
 // HasWarning
 // Whether the currentWarnings list contains any warnings.
 func (s *Story) HasWarning() bool {
@@ -167,27 +208,59 @@ func (s *Story) State() *StoryState {
 	return s._state
 }
 
-/*
-        /// <summary>
-        /// Start recording ink profiling information during calls to Continue on Story.
-        /// Return a Profiler instance that you can request a report from when you're finished.
-        /// </summary>
-		public Profiler StartProfiling() {
-            IfAsyncWeCant ("start profiling");
-			_profiler = new Profiler();
-			return _profiler;
-		}
-*/
+// StartProfiling
+// Start recording ink profiling information during calls to Continue on Story.
+// Return a Profiler instance that you can request a report from when you're finished.
+// Returns ErrAsyncContinueActive while a ContinueAsync() call is still in progress.
+func (s *Story) StartProfiling() (*Profiler, error) {
 
-/*
-        /// <summary>
-        /// Stop recording ink profiling information during calls to Continue on Story.
-        /// To generate a report from the profiler, call
-        /// </summary>
-		public void EndProfiling() {
-			_profiler = null;
-		}
-*/
+	if err := s.IfAsyncWeCant("start profiling"); err != nil {
+		return nil, err
+	}
+
+	s._profiler = NewProfiler()
+	return s._profiler, nil
+}
+
+// EndProfiling
+// Stop recording ink profiling information during calls to Continue on Story.
+// To generate a report from the profiler, call Report on the Profiler
+// returned by StartProfiling.
+// Returns ErrAsyncContinueActive while a ContinueAsync() call is still in progress.
+func (s *Story) EndProfiling() error {
+
+	if err := s.IfAsyncWeCant("stop profiling"); err != nil {
+		return err
+	}
+
+	s._profiler = nil
+	return nil
+}
+
+// RandomSource
+// The source of the numbers behind RANDOM, LIST_RANDOM and shuffle sequences.
+func (s *Story) RandomSource() RandomSource {
+
+	return s._randomSource
+}
+
+// SetRandomSource
+// Replace the source of the numbers behind RANDOM, LIST_RANDOM and shuffle
+// sequences. Passing nil restores the default DotNetRandom, which matches
+// the reference C# runtime.
+func (s *Story) SetRandomSource(source RandomSource) error {
+
+	if err := s.IfAsyncWeCant("set the random source"); err != nil {
+		return err
+	}
+
+	if source == nil {
+		source = NewDotNetRandom(0)
+	}
+
+	s._randomSource = source
+	return nil
+}
 
 // NewStoryFrom
 // Warning: When creating a Story using this constructor, you need to
@@ -205,40 +278,56 @@ func NewStoryFrom(contentContainer *Container, lists []*ListDefinition) *Story {
 	}
 
 	newStory._externals = make(map[string]*ExternalFunctionDef)
+	newStory._randomSource = NewDotNetRandom(0)
 
 	return newStory
 }
 
 // NewStory
 // Construct a Story object using a JSON string compiled through inklecate.
-func NewStory(jsonString string) *Story {
-
-	newStory := new(Story)
-	newStory._prevContainers = []*Container{}
+func NewStory(jsonString string) (*Story, error) {
+	return LoadStory(strings.NewReader(jsonString))
+}
 
-	rootObject := TextToDictionary(jsonString)
+// LoadStory
+// Construct a Story object using the compiled JSON read from r. The JSON
+// is parsed as it's read, so unlike NewStory, a large story never has to
+// be held in memory as a string.
+func LoadStory(r io.Reader) (newStory *Story, err error) {
 
-	versionObj := rootObject["inkVersion"]
-	if versionObj == nil {
-		panic("ink version number not found. Are you sure it's a valid .ink.json file?")
+	rootObject, err := ReaderToDictionary(r)
+	if err != nil {
+		return nil, err
 	}
 
-	formatFromFile := versionObj.(int)
-	if formatFromFile > InkVersionCurrent {
-		panic("Version of ink used to build story was newer than the current version of the engine")
-	}
+	// JSON that's well formed but isn't a valid story panics part way
+	// through being converted
+	defer func() {
+		if r := recover(); r != nil {
+			newStory = nil
+			if e, ok := r.(*StoryException); ok {
+				err = e
+				return
+			}
+			err = fmt.Errorf("invalid story: %v", r)
+		}
+	}()
 
-	if formatFromFile < inkVersionMinimumCompatible {
-		panic("Version of ink used to build story is too old to be loaded by this version of the engine")
+	newStory = new(Story)
+	newStory._prevContainers = []*Container{}
+
+	formatFromFile, ok := rootObject["inkVersion"].(int)
+	if !ok {
+		return nil, errors.New("ink version number not found. Are you sure it's a valid .ink.json file?")
 	}
 
-	if formatFromFile != InkVersionCurrent {
-		fmt.Println("WARNING: Version of ink used to build story doesn't match current version of engine. Non-critical, but recommend synchronising.")
+	if err := checkInkVersion(formatFromFile); err != nil {
+		return nil, err
 	}
 
 	rootToken := rootObject["root"]
 	if rootToken == nil {
-		panic("Root node for ink not found. Are you sure it's a valid .ink.json file?")
+		return nil, errors.New("root node for ink not found. Are you sure it's a valid .ink.json file?")
 	}
 
 	if listDefsObj, ok := rootObject["listDefs"]; ok {
@@ -246,13 +335,111 @@ func NewStory(jsonString string) *Story {
 	}
 
 	newStory._mainContentContainer, _ = JTokenToRuntimeObject(rootToken).(*Container)
-	newStory.ResetState()
+	if newStory._mainContentContainer == nil {
+		return nil, errors.New("root node for ink is not a container. Are you sure it's a valid .ink.json file?")
+	}
 
-	return newStory
+	newStory._externals = make(map[string]*ExternalFunctionDef)
+	newStory._randomSource = NewDotNetRandom(0)
+
+	if err := newStory.ResetState(); err != nil {
+		return nil, err
+	}
+
+	return newStory, nil
+}
+
+// LoadStoryBinary
+// Construct a Story object from the binary format written by WriteBinary.
+// It holds the same content as the JSON form, but loads much faster as
+// there's no text to parse.
+func LoadStoryBinary(r io.Reader) (*Story, error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStoryFromBinary(data)
+}
+
+// NewStoryFromBinary
+// Construct a Story object from a story written by WriteBinary that's
+// already in memory, such as one embedded with go:embed.
+func NewStoryFromBinary(data []byte) (newStory *Story, err error) {
+
+	if !IsBinaryStory(data) {
+		return nil, errors.New("binary story identifier not found. Are you sure it's a valid .inkb file?")
+	}
+
+	// Corrupt data makes the flatbuffers accessors panic
+	defer func() {
+		if r := recover(); r != nil {
+			newStory = nil
+			err = fmt.Errorf("invalid binary story: %v", r)
+		}
+	}()
+
+	// Nothing is read until every offset and length is known to be good
+	verifyBinaryStory(data)
+
+	binaryStory := inkjet.GetRootAsStory(data, 0)
+
+	if err := checkInkVersion(int(binaryStory.InkVersion())); err != nil {
+		return nil, err
+	}
+
+	binaryRoot := binaryStory.Root(nil)
+	if binaryRoot == nil {
+		return nil, errors.New("root node for ink not found. Are you sure it's a valid .inkb file?")
+	}
+
+	newStory = new(Story)
+	newStory._prevContainers = []*Container{}
+	newStory._listDefinitions = BinaryToListDefinitions(binaryStory)
+	newStory._mainContentContainer = BinaryToContainer(binaryRoot)
+	newStory._externals = make(map[string]*ExternalFunctionDef)
+	newStory._randomSource = NewDotNetRandom(0)
+
+	if err := newStory.ResetState(); err != nil {
+		return nil, err
+	}
+
+	return newStory, nil
+}
+
+// checkInkVersion
+// Checks that a story compiled for formatFromFile can be loaded.
+func checkInkVersion(formatFromFile int) error {
+
+	if formatFromFile > InkVersionCurrent {
+		return fmt.Errorf("%w: version of ink used to build story (%d) was newer than the current version of the engine (%d)", ErrIncompatibleInkVersion, formatFromFile, InkVersionCurrent)
+	}
+
+	if formatFromFile < inkVersionMinimumCompatible {
+		return fmt.Errorf("%w: version of ink used to build story (%d) is too old to be loaded by this version of the engine (minimum %d)", ErrIncompatibleInkVersion, formatFromFile, inkVersionMinimumCompatible)
+	}
+
+	// A version that doesn't match InkVersionCurrent exactly is
+	// non-critical, but it's recommended to synchronise them.
+
+	return nil
+}
+
+// WriteBinary
+// Writes the Story in the binary format that LoadStoryBinary reads.
+func (s *Story) WriteBinary(w io.Writer) error {
+
+	builder := flatbuffers.NewBuilder(0)
+	builder.FinishWithFileIdentifier(WriteBinaryStory(builder, s), []byte(binaryStoryIdentifier))
+
+	_, err := w.Write(builder.FinishedBytes())
+	return err
 }
 
 // ToJson
-// The Story itself in JSON representation.
+// The Story itself in JSON representation. The output is canonical, with
+// keys in a fixed order, so the same story always gives the same JSON.
 func (s *Story) ToJson() string {
 	writer := NewWriter()
 	s.ToJsonWriter(writer)
@@ -276,13 +463,13 @@ func (s *Story) ToJsonWriter(writer *Writer) {
 		writer.WritePropertyStart("listDefs")
 		writer.WriteObjectStart()
 
-		for _, def := range s._listDefinitions.Lists() {
+		for _, def := range s._listDefinitions.OrderedLists() {
 
 			writer.WritePropertyStart(def.Name())
 			writer.WriteObjectStart()
 
-			for item, val := range def.Items() {
-				writer.WriteIntProperty(item.ItemName(), val)
+			for _, item := range def.OrderedItems() {
+				writer.WriteIntProperty(item.Key.ItemName(), item.Value)
 			}
 
 			writer.WriteObjectEnd()
@@ -296,16 +483,24 @@ func (s *Story) ToJsonWriter(writer *Writer) {
 	writer.WriteObjectEnd()
 }
 
-func (s *Story) ResetState() {
+func (s *Story) ResetState() error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
 
 	// TODO: Could make this possible
-	s.IfAsyncWeCant("ResetState")
+	if err := s.IfAsyncWeCant("ResetState"); err != nil {
+		return err
+	}
 
 	s._state = NewStoryState(s)
 	s._state.VariablesState().VariableChangedEvent = new(VariableChangedEvent)
 	s._state.VariablesState().VariableChangedEvent.Register(s.VariableStateDidChangeEvent)
 
-	s.ResetGlobals()
+	return s.resetGlobals()
 }
 
 func (s *Story) ResetErrors() {
@@ -319,13 +514,39 @@ func (s *Story) ResetErrors() {
 // go elsewhere with a call to ChoosePathString(...).
 // Doing so without calling ResetCallstack() could cause unexpected
 // issues if, for example, the Story was in a tunnel already.
-func (s *Story) ResetCallstack() {
+func (s *Story) ResetCallstack() error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("ResetCallstack"); err != nil {
+		return err
+	}
 
-	s.IfAsyncWeCant("ResetCallstack")
 	s._state.ForceEnd()
+	return nil
+}
+
+func (s *Story) ResetGlobals() error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	return s.resetGlobals()
 }
 
-func (s *Story) ResetGlobals() {
+// resetGlobals
+// Runs the global declarations again, in a story that's already been
+// entered.
+func (s *Story) resetGlobals() (err error) {
+
+	defer recoverStoryException(&err)
 
 	if _, ok := s._mainContentContainer.NamedContent()["global decl"]; ok {
 		originalPointer := s._state.CurrentPointer()
@@ -334,33 +555,139 @@ func (s *Story) ResetGlobals() {
 
 		// Continue, but without validating external bindings,
 		// since we may be doing this reset at initialisation time.
-		s.ContinueInternal(0)
+		if err := s.ContinueInternal(0); err != nil {
+			return err
+		}
 
 		s._state.SetCurrentPointer(originalPointer)
 	}
 
 	s._state.VariablesState().SnapshotDefaultGlobals()
+	return nil
 }
 
-func (s *Story) SwitchFlow(flowName string) {
+func (s *Story) SwitchFlow(flowName string) error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("switch flow"); err != nil {
+		return err
+	}
 
-	s.IfAsyncWeCant("switch flow")
 	if s._asyncSaving {
-		panic("Story is already in background saving mode, can't switch flow to " + flowName)
+		return fmt.Errorf("%w, can't switch flow to %s", ErrBackgroundSaveActive, flowName)
+	}
+
+	previousFlowName := s._state.CurrentFlowName()
+	if err := s._state.switchFlow_Internal(flowName); err != nil {
+		return err
 	}
 
-	s._state.switchFlow_Internal(flowName)
+	s.flowSwitched(previousFlowName)
+	return nil
+}
+
+// SwitchToDefaultFlow
+// Switch back to the flow the story started in.
+func (s *Story) SwitchToDefaultFlow() error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("switch to default flow"); err != nil {
+		return err
+	}
+
+	if s._asyncSaving {
+		return fmt.Errorf("%w, can't switch to default flow", ErrBackgroundSaveActive)
+	}
+
+	previousFlowName := s._state.CurrentFlowName()
+	s._state.switchToDefaultFlow_Internal()
+
+	s.flowSwitched(previousFlowName)
+	return nil
+}
+
+// RemoveFlow
+// Remove a flow and all of its state. If it's the current flow, the story
+// switches back to the default flow first. The default flow can't be removed.
+func (s *Story) RemoveFlow(flowName string) error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("remove flow"); err != nil {
+		return err
+	}
+
+	if s._asyncSaving {
+		return fmt.Errorf("%w, can't remove flow %s", ErrBackgroundSaveActive, flowName)
+	}
+
+	previousFlowName := s._state.CurrentFlowName()
+	if err := s._state.removeFlow_Internal(flowName); err != nil {
+		return err
+	}
+
+	s.flowSwitched(previousFlowName)
+	return nil
+}
+
+// CurrentFlowName
+// The name of the flow that's currently being played.
+func (s *Story) CurrentFlowName() string {
+
+	return s._state.CurrentFlowName()
+}
+
+// CurrentFlowIsDefaultFlow
+// Whether the current flow is the one the story started in.
+func (s *Story) CurrentFlowIsDefaultFlow() bool {
+
+	return s._state.CurrentFlowIsDefaultFlow()
+}
+
+// AliveFlowNames
+// The names of all the flows that have been created with SwitchFlow and not
+// removed, other than the default flow, in alphabetical order.
+func (s *Story) AliveFlowNames() []string {
+
+	return append([]string(nil), s._state.AliveFlowNames()...)
+}
+
+func (s *Story) flowSwitched(previousFlowName string) {
+
+	if flowName := s._state.CurrentFlowName(); flowName != previousFlowName && s.OnFlowSwitched != nil {
+		s.callGame(func() { s.OnFlowSwitched.Emit(previousFlowName, flowName) })
+	}
 }
 
 // Continue
 // Continue the story for one line of content, if possible.
 // If you're not sure if there's more content available, for example if you
 // want to check whether you're at a choice point or at the end of the story,
-// you should call <c>canContinue</c> before calling this function.
-func (s *Story) Continue() string {
+// you should call CanContinue before calling this function, otherwise
+// ErrCannotContinue is returned.
+// If the ink reports errors and no OnError handler has been registered,
+// they're returned as a *StoryErrors.
+func (s *Story) Continue() (string, error) {
 
-	s.ContinueAsync(0)
-	return s.CurrentText()
+	if err := s.ContinueAsync(0); err != nil {
+		return "", err
+	}
+
+	return s.CurrentText(), nil
 }
 
 // CanContinue
@@ -389,21 +716,78 @@ func (s *Story) AsyncContinueComplete() bool {
 // it over multiple game frames for smoother animation.
 // If you pass a limit of zero, then it will fully evaluate the ink in the same
 // way as calling Continue (and in fact, this exactly what Continue does internally).
-func (s *Story) ContinueAsync(millisecsLimitAsync float64) {
+func (s *Story) ContinueAsync(millisecsLimitAsync float64) error {
+
+	return s.continueAsync(millisecsLimitAsync, nil)
+}
+
+// ContinueContext
+// Continue the story for one line of content, like Continue, but stop early
+// at a safe point between steps if ctx is cancelled or its deadline passes.
+// In that case ctx.Err() is returned and the line is left part-evaluated,
+// just as it would be by ContinueAsync running out of time: call
+// ContinueContext, ContinueAsync or Continue again to finish it, and the
+// output will be the same as if it had never been interrupted.
+func (s *Story) ContinueContext(ctx context.Context) (string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if err := s.continueAsync(0, ctx); err != nil {
+		return "", err
+	}
+
+	if s._asyncContinueActive {
+		return "", ctx.Err()
+	}
+
+	return s.CurrentText(), nil
+}
+
+func (s *Story) continueAsync(millisecsLimitAsync float64, ctx context.Context) error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	return s.continueEntered(millisecsLimitAsync, ctx)
+}
+
+// continueEntered
+// Continues a story that's already been entered, as EvaluateFunction
+// does to evaluate its function.
+func (s *Story) continueEntered(millisecsLimitAsync float64, ctx context.Context) error {
 
 	if !s._hasValidatedExternals {
-		s.ValidateExternalBindings()
+		if err := s.ValidateExternalBindings(); err != nil {
+			return err
+		}
 	}
 
-	s.ContinueInternal(millisecsLimitAsync)
+	return s.continueInternal(millisecsLimitAsync, ctx)
 }
 
-func (s *Story) ContinueInternal(millisecsLimitAsync float64) {
+func (s *Story) ContinueInternal(millisecsLimitAsync float64) error {
 
-	// if( _profiler != null )
-	// 	 _profiler.PreContinue(); C#
+	return s.continueInternal(millisecsLimitAsync, nil)
+}
+
+// continueInternal
+// Evaluates the ink up to the end of the next line. If there's a time limit
+// or a context that can be cancelled, evaluation is asynchronous and may
+// stop before the line is complete.
+func (s *Story) continueInternal(millisecsLimitAsync float64, ctx context.Context) error {
+
+	// Every return is timed, including those for errors
+	if profiler := s._profiler; profiler != nil {
+		profiler.PreContinue()
+		defer profiler.PostContinue()
+	}
 
-	isAsyncTimeLimited := millisecsLimitAsync > 0
+	isAsyncTimeLimited := millisecsLimitAsync > 0 || (ctx != nil && ctx.Done() != nil)
 
 	s._recursiveContinueCount++
 
@@ -414,11 +798,14 @@ func (s *Story) ContinueInternal(millisecsLimitAsync float64) {
 		s._asyncContinueActive = isAsyncTimeLimited
 
 		if !s.CanContinue() {
-			panic("Can't continue - should check canContinue before calling Continue")
+			s._asyncContinueActive = false
+			s._recursiveContinueCount--
+			return ErrCannotContinue
 		}
 
 		s._state.DidSafeExit = false
 		s._state.ResetOutput(nil)
+		s._sawLookaheadUnsafeFunctionAfterNewline = false
 
 		// It's possible for ink to call game to call ink to call game etc
 		// In this case, we only want to batch observe variable changes
@@ -429,38 +816,38 @@ func (s *Story) ContinueInternal(millisecsLimitAsync float64) {
 	}
 
 	// Start timing
-	// var durationStopwatch = new Stopwatch ();
-	// durationStopwatch.Start (); C#
-	//durationStopwatch := time.Now()
+	durationStopwatch := time.Now()
 
 	outputStreamEndsInNewline := false
-	s._sawLookaheadUnsafeFunctionAfterNewline = false
 
 	for do := true; do; do = s.CanContinue() {
 
-		/*
-		   try {
-		       outputStreamEndsInNewline = ContinueSingleStep ();
-		   } catch(StoryException e) {
-		       AddError (e.Message, useEndLineNumber:e.useEndLineNumber);
-		       break;
-		   }
-		*/
-		outputStreamEndsInNewline = s.ContinueSingleStep()
+		var err error
+		outputStreamEndsInNewline, err = s.tryContinueSingleStep()
+		if e, ok := err.(*StoryException); ok {
+			s.AddError(e.Message, false, e.UseEndLineNumber)
+			break
+		}
+
+		if s.OnDidStep != nil {
+			s.OnDidStep.Emit()
+		}
 
 		if outputStreamEndsInNewline {
 			break
 		}
 
-		/*
-		   // Run out of async time?
-		              if (_asyncContinueActive && durationStopwatch.ElapsedMilliseconds > millisecsLimitAsync) {
-		                  break;
-		              }
-		*/
-	}
+		// Run out of async time, or been cancelled?
+		if s._asyncContinueActive && isAsyncTimeLimited {
+			if millisecsLimitAsync > 0 && millisecs(time.Since(durationStopwatch)) > millisecsLimitAsync {
+				break
+			}
 
-	//  durationStopwatch.Stop ();
+			if ctx != nil && ctx.Err() != nil {
+				break
+			}
+		}
+	}
 
 	// 4 outcomes:
 	//  - got newline (so finished this line of text)
@@ -487,7 +874,7 @@ func (s *Story) ContinueInternal(millisecsLimitAsync float64) {
 					s.AddError("unexpectedly reached end of content. Do you need a '->->' to return from a tunnel?", false, false)
 				} else if s.State().CallStack().CanPopWith(Function) {
 					s.AddError("unexpectedly reached end of content. Do you need a '~ return'?", false, false)
-				} else if s.State().CallStack().CanPop() {
+				} else if !s.State().CallStack().CanPop() {
 					s.AddError("ran out of content. Do you need a '-> DONE' or '-> END'?", false, false)
 				} else {
 					s.AddError("unexpectedly reached end of content for unknown reason. Please debug compiler!", false, false)
@@ -510,92 +897,71 @@ func (s *Story) ContinueInternal(millisecsLimitAsync float64) {
 
 	s._recursiveContinueCount--
 
-	// if( _profiler != null )
-	//     _profiler.PostContinue(); C#
-
 	// Report any errors that occured during evaluation.
-	// This may either have been StoryExceptions that were thrown
-	// and caught during evaluation, or directly added with AddError.
+	// This may either have been StoryExceptions that were raised
+	// and recovered during evaluation, or directly added with AddError.
 	if s.State().HasError() || s.State().HasWarning() {
-		// if onError != null C#
 		if s.OnError != nil {
 			if s._state.HasError() {
-				for _, err := range s.State().CurrentErrors() {
-					s.OnError.Emit(err, ErrorTypeError)
+				for _, err := range s.State().CurrentRuntimeErrors() {
+					s.OnError.Emit(err.Error(), err.Type)
 				}
 			}
 			if s.State().HasWarning() {
-				for _, err := range s._state.CurrentWarnings() {
-					s.OnError.Emit(err, ErrorTypeWarning)
+				for _, err := range s._state.CurrentRuntimeWarnings() {
+					s.OnError.Emit(err.Error(), err.Type)
 				}
 			}
 			s.ResetErrors()
 		} else {
-			// Throw an exception since there's no error handler
-			var sb strings.Builder
-			sb.WriteString("Ink had ")
-			if s.State().HasError() {
-				sb.WriteString(fmt.Sprint(len(s.State().CurrentErrors())))
-				if len(s.State().CurrentErrors()) == 1 {
-					sb.WriteString(" error")
-				} else {
-					sb.WriteString(" errors")
-				}
-				if s.State().HasWarning() {
-					sb.WriteString(" and ")
-				}
-			}
-			if s.State().HasWarning() {
-				sb.WriteString(fmt.Sprint(len(s.State().CurrentWarnings())))
-				if len(s.State().CurrentWarnings()) == 1 {
-					sb.WriteString(" warning")
-				} else {
-					sb.WriteString(" warnings")
-				}
-			}
-			sb.WriteString(". It is strongly suggested that you assign an error handler to story.onError. The first issue was: ")
-			if s.State().HasError() {
-				sb.WriteString(s.State().CurrentErrors()[0])
-			} else {
-				sb.WriteString(s.State().CurrentWarnings()[0])
-			}
-
-			// If you get this exception, please assign an error handler to your story.
-			// If you're using Unity, you can do something like this when you create
-			// your story:
+			// Return the errors since there's no error handler.
+			// It's strongly suggested that you register one when you
+			// create your story:
 			//
-			// var story = new Ink.Runtime.Story(jsonTxt);
-			// story.onError = (errorMessage, errorType) => {
-			//     if( errorType == ErrorType.Warning )
-			//         Debug.LogWarning(errorMessage);
-			//     else
-			//         Debug.LogError(errorMessage);
-			// };
-			//
-			//
-			panic(sb.String())
+			// story.OnError = new(runtime.ErrorHandlerEvent)
+			// story.OnError.Register(func(message string, typ runtime.ErrorType) {
+			//     log.Println(message)
+			// })
+			return &StoryErrors{
+				Errors:   NewSliceFromSlice(s.State().CurrentRuntimeErrors()),
+				Warnings: NewSliceFromSlice(s.State().CurrentRuntimeWarnings()),
+			}
 		}
 	}
+
+	return nil
+}
+
+// tryContinueSingleStep
+// Runs ContinueSingleStep, recovering any StoryException raised during
+// the step so that it can be reported as an ink error.
+func (s *Story) tryContinueSingleStep() (outputStreamEndsInNewline bool, err error) {
+
+	defer recoverStoryException(&err)
+	return s.ContinueSingleStep(), nil
 }
 
 func (s *Story) ContinueSingleStep() bool {
 
-	//if (_profiler != null)
-	//	_profiler.PreStep (); C#
+	if s._profiler != nil {
+		s._profiler.PreStep()
+	}
 
 	// Run main step function (walks through content)
 	s.Step()
 
-	//if (_profiler != null)
-	//	_profiler.PostStep ();
+	if s._profiler != nil {
+		s._profiler.PostStep()
+	}
 
 	// Run out of content and we have a default invisible choice that we can follow?
 	if !s.CanContinue() && !s.State().CallStack().ElementIsEvaluateFromGame() {
 		s.TryFollowDefaultInvisibleChoice()
 	}
 
-	//if (_profiler != null)
-	//	_profiler.PreSnapshot ();
+	if s._profiler != nil {
+		s._profiler.PreSnapshot()
+	}
 
 	// Don't save/rewind during string evaluation, which is e.g. used for choices
 	if !s.State().InStringEvaluation() {
@@ -650,8 +1016,9 @@ func (s *Story) ContinueSingleStep() bool {
 		}
 	}
 
-	//if (_profiler != null)
-	//	_profiler.PostSnapshot ();
+	if s._profiler != nil {
+		s._profiler.PostSnapshot()
+	}
 
 	// outputStreamEndsInNewline = false C# (commented out in original source)
 	return false
@@ -694,17 +1061,23 @@ func (s *Story) CalculateNewlineOutputStateChange(prevText string, currText stri
 // Continue the story until the next choice point or until it runs out of content.
 // This is as opposed to the Continue() method which only evaluates one line of
 // output at a time.
-func (s *Story) ContinueMaximally() string {
+func (s *Story) ContinueMaximally() (string, error) {
 
-	s.IfAsyncWeCant("ContinueMaximally")
+	if err := s.IfAsyncWeCant("ContinueMaximally"); err != nil {
+		return "", err
+	}
 
 	var sb strings.Builder
 
 	for s.CanContinue() {
-		sb.WriteString(s.Continue())
+		text, err := s.Continue()
+		sb.WriteString(text)
+		if err != nil {
+			return sb.String(), err
+		}
 	}
 
-	return sb.String()
+	return sb.String(), nil
 }
 
 func (s *Story) ContentAtPath(path *Path) SearchResult {
@@ -806,22 +1179,41 @@ func (s *Story) DiscardSnapshot() {
 // When you've finished saving your state, call BackgroundSaveComplete()
 // and that diff patch will be applied, allowing the story to continue
 // in its usual mode.
-func (s *Story) CopyStateForBackgroundThreadSave() *StoryState {
+func (s *Story) CopyStateForBackgroundThreadSave() (*StoryState, error) {
+
+	exit, err := s.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("start saving on a background thread"); err != nil {
+		return nil, err
+	}
 
-	s.IfAsyncWeCant("start saving on a background thread")
 	if s._asyncSaving {
-		panic("Story is already in background saving mode, can't call CopyStateForBackgroundThreadSave again!")
+		return nil, fmt.Errorf("%w, can't call CopyStateForBackgroundThreadSave again", ErrBackgroundSaveActive)
 	}
+
 	stateToSave := s._state
+	stateToSave._detached = true
 	s._state = s._state.CopyAndStartPatching()
 	s._asyncSaving = true
-	return stateToSave
+	return stateToSave, nil
 }
 
 // BackgroundSaveComplete
 // See CopyStateForBackgroundThreadSave. This method releases the
 // "frozen" save state, applying its patch that it was using internally.
-func (s *Story) BackgroundSaveComplete() {
+// As with CopyStateForBackgroundThreadSave, it fails with
+// ErrConcurrentContinue while the story is being used elsewhere.
+func (s *Story) BackgroundSaveComplete() error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
 
 	// CopyStateForBackgroundThreadSave must be called outside
 	// of any async ink evaluation, since otherwise you'd be saving
@@ -838,6 +1230,7 @@ func (s *Story) BackgroundSaveComplete() {
 	}
 
 	s._asyncSaving = false
+	return nil
 }
 
 func (s *Story) Step() {
@@ -868,9 +1261,9 @@ func (s *Story) Step() {
 
 	s.State().SetCurrentPointer(pointer)
 
-	//if( _profiler != null ) {
-	//	_profiler.Step(state.callStack);
-	//}
+	if s._profiler != nil {
+		s._profiler.Step(s.State().CallStack())
+	}
 
 	// Is the current content object:
 	//  - Normal content
@@ -990,7 +1383,7 @@ func (s *Story) VisitChangedContainersDueToDivert() {
 		return
 	}
 
-	currentContainerAncestor := currentChildOfContainer.Parent().(*Container)
+	currentContainerAncestor, _ := currentChildOfContainer.Parent().(*Container)
 
 	allChildrenEnteredAtStart := true
 
@@ -1122,8 +1515,6 @@ func (s *Story) IsTruthy(obj Object) bool {
 			return false
 		}
 
-		fmt.Println(val)
-
 		return val.IsTruthy()
 	}
 	return truthy
@@ -1263,8 +1654,9 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 				popped := s.State().PopEvaluationStack()
 				overrideTunnelReturnTarget, _ = popped.(*DivertTargetValue)
 				if overrideTunnelReturnTarget == nil {
-					panic("Expected void if ->-> doesn't override target")
-					//Assert (popped is Void, "Expected void if ->-> doesn't override target");
+					if _, isVoid := popped.(*Void); !isVoid {
+						s.Error("Expected void if ->-> doesn't override target")
+					}
 				}
 			}
 
@@ -1301,10 +1693,9 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 			s.State().PushToOutputStream(evalCommand)
 
 			if s.State().InExpressionEvaluation() == false {
-				panic("Expected to be in an expression when evaluating a string")
+				s.Error("Expected to be in an expression when evaluating a string")
 			}
 
-			//Assert (state.inExpressionEvaluation == true, "Expected to be in an expression when evaluating a string");
 			s.State().SetInExpressionEvaluation(false)
 
 		case CommandTypeBeginTag:
@@ -1369,7 +1760,8 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 
 				var sb strings.Builder
 
-				for _, val := range contentStackForTag.items {
+				for !contentStackForTag.IsEmpty() {
+					val, _ := contentStackForTag.Pop()
 					strVal := val.(*StringValue)
 					sb.WriteString(strVal.Value())
 				}
@@ -1422,14 +1814,16 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 			// rather than consume as part of the string we're building.
 			// At the time of writing, this only applies to Tag objects generated
 			// by choices, which are pushed to the stack during string generation.
-			for _, rescuedTag := range contentToRetain.items {
+			for !contentToRetain.IsEmpty() {
+				rescuedTag, _ := contentToRetain.Pop()
 				s.State().PushToOutputStream(rescuedTag)
 			}
 
 			// Build string out of the content we collected
 			var sb strings.Builder
-			for _, c := range contentStackForString.items {
-				sb.WriteString(c.(fmt.Stringer).String())
+			for !contentStackForString.IsEmpty() {
+				c, _ := contentStackForString.Pop()
+				sb.WriteString(c.(*StringValue).Value())
 			}
 
 			// Return to expression evaluation (from content mode)
@@ -1460,7 +1854,7 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 			}
 
 			divertTarget, _ := target.(*DivertTargetValue)
-			container := s.ContentAtPath(divertTarget.TargetPath()).CorrectObj().(*Container)
+			container, _ := s.ContentAtPath(divertTarget.TargetPath()).CorrectObj().(*Container)
 
 			eitherCount := 0
 			if container != nil {
@@ -1509,9 +1903,9 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 			}
 
 			resultSeed := s.State().StorySeed + s.State().PreviousRandom
-			random := rand.New(rand.NewSource(int64(resultSeed)))
+			s._randomSource.Seed(resultSeed)
 
-			nextRandom := random.Int()
+			nextRandom := s._randomSource.Next()
 			chosenValue := nextRandom%randomRange + minInt.Value()
 			s.State().PushEvaluationStack(NewIntValueFromInt(chosenValue))
 
@@ -1571,7 +1965,11 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 			listNameVal, _ := s.State().PopEvaluationStack().(*StringValue)
 
 			if intVal == nil {
-				panic("Passed non-integer when creating a list element from a numerical value.")
+				s.Error("Passed non-integer when creating a list element from a numerical value.")
+			}
+
+			if listNameVal == nil {
+				s.Error("Expected the name of a list when creating a list element from a numerical value.")
 			}
 
 			var generatedListValue *ListValue
@@ -1581,7 +1979,7 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 					generatedListValue = NewListValueFromInkListItem(foundItem, intVal.Value())
 				}
 			} else {
-				panic("Failed to find LIST called " + listNameVal.Value())
+				s.Error("Failed to find LIST called " + listNameVal.Value())
 			}
 
 			if generatedListValue == nil {
@@ -1592,13 +1990,13 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 
 		case CommandTypeListRange:
 
-			min, _ := s.State().PopEvaluationStack().(Value)
 			max, _ := s.State().PopEvaluationStack().(Value)
+			min, _ := s.State().PopEvaluationStack().(Value)
 
 			targetList, _ := s.State().PopEvaluationStack().(*ListValue)
 
 			if targetList == nil || min == nil || max == nil {
-				panic("Expected list, minimum and maximum for LIST_RANGE")
+				s.Error("Expected list, minimum and maximum for LIST_RANGE")
 			}
 
 			result := targetList.Value().ListWithSubRange(min.ValueObject(), max.ValueObject())
@@ -1609,7 +2007,7 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 
 			listVal, _ := s.State().PopEvaluationStack().(*ListValue)
 			if listVal == nil {
-				panic("Expected list for LIST_RANDOM")
+				s.Error("Expected list for LIST_RANDOM")
 			}
 
 			list := listVal.Value()
@@ -1623,22 +2021,14 @@ func (s *Story) PerformLogicAndFlowControl(contentObj Object) bool {
 
 				// Generate a random index for the element to take
 				resultSeed := s.State().StorySeed + s.State().PreviousRandom
-				random := rand.New(rand.NewSource(int64(resultSeed)))
+				s._randomSource.Seed(resultSeed)
 
-				nextRandom := random.Int()
+				nextRandom := s._randomSource.Next()
 				listItemIndex := nextRandom % list.Count()
 
-				// Iterate through to get the random element
-				randomItem := KeyValuePair[InkListItem, int]{}
-				i := 0
-				for key, value := range list._items {
-					if listItemIndex == i {
-						randomItem.Key = key
-						randomItem.Value = value
-						break
-					}
-					i++
-				}
+				// Get the random element, counting through the list in the
+				// order C# enumerates its Dictionary, as ink does
+				randomItem := list.Items()[listItemIndex]
 
 				// Origin list is simply the origin of the one element
 				newList = NewInkListFromOriginStory(randomItem.Key.OriginName(), s)
@@ -1747,9 +2137,19 @@ type ExternalFunctionDef struct {
 // will throw an exception.
 //
 // (default) resetCallstack: true
-func (s *Story) ChoosePathString(path string, resetCallstack bool, arguments ...interface{}) {
+func (s *Story) ChoosePathString(path string, resetCallstack bool, arguments ...interface{}) (err error) {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("call ChoosePathString right now"); err != nil {
+		return err
+	}
 
-	s.IfAsyncWeCant("call ChoosePathString right now")
+	defer recoverStoryException(&err)
 
 	//if(onChoosePathString != null) onChoosePathString(path, arguments);
 	if s.OnChoosePathString != nil {
@@ -1757,7 +2157,7 @@ func (s *Story) ChoosePathString(path string, resetCallstack bool, arguments ...
 	}
 
 	if resetCallstack {
-		s.ResetCallstack()
+		s._state.ForceEnd()
 	} else {
 		// ChoosePathString is potentially dangerous since you can call it when the stack is
 		// pretty much in any state. Let's catch one of the worst offenders.
@@ -1767,19 +2167,54 @@ func (s *Story) ChoosePathString(path string, resetCallstack bool, arguments ...
 			if container != nil {
 				funcDetail = "(" + container.Path(container).String() + ") "
 			}
-			panic("Story was running a function " + funcDetail + "when you called ChoosePathString(" + path + ") - this is almost certainly not not what you want! Full stack trace: \n" + s.State().CallStack().CallStackTrace())
+			return errors.New("Story was running a function " + funcDetail + "when you called ChoosePathString(" + path + ") - this is almost certainly not not what you want! Full stack trace: \n" + s.State().CallStack().CallStackTrace())
 		}
 	}
 
-	s.State().PassArgumentsToEvaluationStack(arguments)
+	if err := s.State().PassArgumentsToEvaluationStack(arguments...); err != nil {
+		return err
+	}
+
 	s.ChoosePath(NewPathFromString(path), true)
+	return nil
 }
 
-func (s *Story) IfAsyncWeCant(activityStr string) {
+func (s *Story) IfAsyncWeCant(activityStr string) error {
 
 	if s._asyncContinueActive {
-		panic("Can't " + activityStr + ". Story is in the middle of a ContinueAsync(). Make more ContinueAsync() calls or a single Continue() call beforehand.")
+		return fmt.Errorf("%w: can't %s. Make more ContinueAsync() calls or a single Continue() call beforehand", ErrAsyncContinueActive, activityStr)
 	}
+
+	return nil
+}
+
+// enter
+// Marks the story as busy with a call that changes it, returning the
+// function that ends the call. A Story isn't safe for concurrent use, so
+// rather than corrupting its state, a second call while it's busy fails
+// with ErrConcurrentContinue. The exception is a call made while the story
+// is running an external function, variable observer or event handler, as
+// ink can call the game to call ink. Each callback lets one call at a time
+// back into the story.
+func (s *Story) enter() (exit func(), err error) {
+
+	level := atomic.LoadInt32(&s._callingGame)
+	if atomic.CompareAndSwapInt32(&s._busy, level, level+1) {
+		return func() { atomic.StoreInt32(&s._busy, level) }, nil
+	}
+
+	return nil, ErrConcurrentContinue
+}
+
+// callGame
+// Runs an external function, variable observer or event handler, which
+// may call back into the story.
+func (s *Story) callGame(call func()) {
+
+	atomic.AddInt32(&s._callingGame, 1)
+	defer atomic.AddInt32(&s._callingGame, -1)
+
+	call()
 }
 
 // ChoosePath
@@ -1796,10 +2231,20 @@ func (s *Story) ChoosePath(p *Path, incrementingTurnIndex bool) {
 // Chooses the Choice from the currentChoices list with the given
 // index. Internally, this sets the current content path to that
 // pointed to by the Choice, ready to continue story evaluation.
-func (s *Story) ChooseChoiceIndex(choiceIdx int) {
+func (s *Story) ChooseChoiceIndex(choiceIdx int) (err error) {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	defer recoverStoryException(&err)
 
 	choices := s.CurrentChoices()
-	//Assert (choiceIdx >= 0 && choiceIdx < choices.Count, "choice out of range");
+	if choiceIdx < 0 || choiceIdx >= len(choices) {
+		return fmt.Errorf("choice out of range: %d (%d choices available)", choiceIdx, len(choices))
+	}
 
 	// Replace callstack with the one from the thread at the choosing point,
 	// so that we can jump into the right place in the flow.
@@ -1814,6 +2259,7 @@ func (s *Story) ChooseChoiceIndex(choiceIdx int) {
 	s.State().CallStack().SetCurrentThread(choiceToChoose.ThreadAtGeneration)
 
 	s.ChoosePath(choiceToChoose.TargetPath, true)
+	return nil
 }
 
 // HasFunction
@@ -1844,44 +2290,61 @@ func (s *Story) HasFunction(functionName string) bool {
 // EvaluateFunction
 // Evaluates a function defined in ink, and gathers the possibly multi-line text as generated by the function.
 // This text output is any text written as normal content within the function, as opposed to the return value, as returned with `~ return`.
-func (s *Story) EvaluateFunction(functionName string, arguments ...interface{}) (string, interface{}) {
+func (s *Story) EvaluateFunction(functionName string, arguments ...interface{}) (textOutput string, result interface{}, err error) {
+
+	exit, err := s.enter()
+	if err != nil {
+		return "", nil, err
+	}
+	defer exit()
 
-	textOutput := ""
+	if err := s.IfAsyncWeCant("evaluate a function"); err != nil {
+		return "", nil, err
+	}
 
 	//if(onEvaluateFunction != null) onEvaluateFunction(functionName, arguments);
 	if s.OnEvaluateFunction != nil {
 		s.OnEvaluateFunction.Emit(functionName, arguments)
 	}
 
-	s.IfAsyncWeCant("evaluate a function")
-
-	//if(functionName == null) {
-	//	throw new System.Exception ("Function is null");
-	//} else if(functionName == string.Empty || functionName.Trim() == string.Empty) {
-	//	throw new System.Exception ("Function is empty or white space.");
-	//}
-
 	if strings.TrimSpace(functionName) == "" {
-		panic("Function is empty or white space.")
+		return "", nil, errors.New("function is empty or white space")
 	}
 
 	// Get the content that we need to run
 	funcContainer := s.KnotContainerWithName(functionName)
 	if funcContainer == nil {
-		panic("Function doesn't exist: '" + functionName + "'")
+		return "", nil, errors.New("function doesn't exist: '" + functionName + "'")
 	}
 
 	// Snapshot the output stream
 	outputStreamBefore := NewSliceFromSlice(s._state.OutputStream())
 	s._state.ResetOutput(nil)
 
+	// A function that fails part way through can leave the story ended,
+	// so put it back where it was, ready to carry on
+	snapshot := s._state.snapshotGameEvaluation()
+	defer func() {
+		if err != nil {
+			s._state.ResetOutput(outputStreamBefore)
+			s._state.abandonGameEvaluation(snapshot)
+		}
+	}()
+
+	defer recoverStoryException(&err)
+
 	// State will temporarily replace the callstack in order to evaluate
-	s.State().StartFunctionEvaluationFromGame(funcContainer, arguments)
+	if err := s.State().StartFunctionEvaluationFromGame(funcContainer, arguments...); err != nil {
+		return "", nil, err
+	}
 
 	// Evaluate the function, and collect the string output
 	var stringOutput strings.Builder
 	for s.CanContinue() {
-		stringOutput.WriteString(s.Continue())
+		if err := s.continueEntered(0, nil); err != nil {
+			return "", nil, err
+		}
+		stringOutput.WriteString(s.CurrentText())
 	}
 	textOutput = stringOutput.String()
 
@@ -1889,13 +2352,17 @@ func (s *Story) EvaluateFunction(functionName string, arguments ...interface{})
 	// during main story evaluation.
 	s._state.ResetOutput(outputStreamBefore)
 
-	result := s.State().CompleteFunctionEvaluationFromGame()
+	result, err = s.State().CompleteFunctionEvaluationFromGame()
+	if err != nil {
+		return "", nil, err
+	}
+
 	//if(onCompleteEvaluateFunction != null) onCompleteEvaluateFunction(functionName, arguments, textOutput, result);
 	if s.OnCompleteEvaluateFunction != nil {
 		s.OnCompleteEvaluateFunction.Emit(functionName, arguments, textOutput, result)
 	}
 
-	return textOutput, result
+	return textOutput, result, nil
 }
 
 func (s *Story) EvaluateExpression(exprContainer *Container) Object {
@@ -1910,7 +2377,9 @@ func (s *Story) EvaluateExpression(exprContainer *Container) Object {
 
 	evalStackHeight := len(s.State().EvaluationStack())
 
-	s.Continue()
+	if _, err := s.Continue(); err != nil {
+		s.Error(err.Error())
+	}
 
 	s._temporaryEvaluationContainer = nil
 
@@ -1945,7 +2414,9 @@ func (s *Story) CallExternalFunction(funcName string, numberOfArguments int) {
 	if !foundExternal {
 		if s.AllowExternalFunctionFallbacks {
 			fallbackFunctionContainer = s.KnotContainerWithName(funcName)
-			//Assert (fallbackFunctionContainer != null, "Trying to call EXTERNAL function '" + funcName + "' which has not been bound, and fallback ink function could not be found.");
+			if fallbackFunctionContainer == nil {
+				s.Error("Trying to call EXTERNAL function '" + funcName + "' which has not been bound, and fallback ink function could not be found.")
+			}
 
 			s.State().CallStack().Push(
 				Function,
@@ -1954,7 +2425,7 @@ func (s *Story) CallExternalFunction(funcName string, numberOfArguments int) {
 			s.State().DivertedPointer = StartOfPointer(fallbackFunctionContainer)
 			return
 		} else {
-			//Assert (false, "Trying to call EXTERNAL function '" + funcName + "' which has not been bound (and ink fallbacks disabled).");
+			s.Error("Trying to call EXTERNAL function '" + funcName + "' which has not been bound (and ink fallbacks disabled).")
 		}
 	}
 
@@ -1962,6 +2433,9 @@ func (s *Story) CallExternalFunction(funcName string, numberOfArguments int) {
 	var arguments []interface{}
 	for i := 0; i < numberOfArguments; i++ {
 		poppedObj, _ := s.State().PopEvaluationStack().(Value)
+		if poppedObj == nil {
+			s.Error("Expected a value to pass as an argument to EXTERNAL function '" + funcName + "'")
+		}
 		valueObj := poppedObj.ValueObject()
 		arguments = append(arguments, valueObj)
 	}
@@ -1969,19 +2443,21 @@ func (s *Story) CallExternalFunction(funcName string, numberOfArguments int) {
 	// Reverse arguments from the order they were popped,
 	// so they're the right way round again.
 	//arguments.Reverse ();
-	var argumentsReordered []interface{}
-	for i := len(argumentsReordered); i >= 0; i-- {
-		argumentsReordered = append(argumentsReordered, arguments[i])
+	for i, j := 0, len(arguments)-1; i < j; i, j = i+1, j-1 {
+		arguments[i], arguments[j] = arguments[j], arguments[i]
 	}
 
 	// Run the function!
-	funcResult := funcDef.function(argumentsReordered)
+	var funcResult interface{}
+	s.callGame(func() { funcResult = funcDef.function(arguments) })
 
 	// Convert return value (if any) to the a type that the ink engine can use
 	var returnObj Object
 	if funcResult != nil {
 		returnObj = CreateValue(funcResult)
-		//Assert (returnObj != null, "Could not create ink value from returned object of type " + funcResult.GetType());
+		if returnObj == nil {
+			s.Error(fmt.Sprintf("Could not create ink value from returned object of type %T", funcResult))
+		}
 	} else {
 		returnObj = NewVoid()
 	}
@@ -1992,398 +2468,330 @@ func (s *Story) CallExternalFunction(funcName string, numberOfArguments int) {
 // BindExternalFunctionalGeneral
 // Most general form of function binding that returns an object
 // and takes an array of object parameters.
-// The only way to bind a function with more than 3 arguments.
-// </summary>
-// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-// <param name="func">The C# function to bind.</param>
-// <param name="lookaheadSafe">The ink engine often evaluates further
-// than you might expect beyond the current line just in case it sees
-// glue that will cause the two lines to become one. In this case it's
-// possible that a function can appear to be called twice instead of
-// just once, and earlier than you expect. If it's safe for your
-// function to be called in this way (since the result and side effect
-// of the function will not change), then you can pass 'true'.
-// Usually, you want to pass 'false', especially if you want some action
+// The only way to bind a function with more than 4 arguments.
+// See BindExternalFunction0 for the meaning of lookaheadSafe.
 // (default) lookaheadSafe: true
-func (s *Story) BindExternalFunctionalGeneral(funcName string, gfunc func(args []interface{}) interface{}, lookaheadSafe bool) {
+func (s *Story) BindExternalFunctionalGeneral(funcName string, gfunc func(args []interface{}) interface{}, lookaheadSafe bool) error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("bind an external function"); err != nil {
+		return err
+	}
+
+	return s.bindExternalFunction(funcName, gfunc, lookaheadSafe)
+}
+
+// bindExternalFunction
+// Binds an external function in a story that's already been entered.
+func (s *Story) bindExternalFunction(funcName string, gfunc func(args []interface{}) interface{}, lookaheadSafe bool) error {
+
+	if _, ok := s._externals[funcName]; ok {
+		return errors.New("function '" + funcName + "' has already been bound")
+	}
 
-	s.IfAsyncWeCant("bind an external function")
-	//Assert(!_externals.ContainsKey(funcName), "Function '"+funcName+"' has already been bound.")
 	s._externals[funcName] = &ExternalFunctionDef{
 		function:      gfunc,
 		lookaheadSafe: lookaheadSafe,
 	}
+
+	return nil
 }
 
-func TryCoerce[T any](value interface{}) interface{} {
+// TryCoerce
+// Converts a value passed from ink into the type that a bound Go function
+// expects, following the same rules as the reference engine: floats are
+// rounded to ints, ints become floats or bools, bools become ints and
+// anything with a String method can be passed as a string. A nil value
+// gives the zero value of T.
+func TryCoerce[T any](value interface{}) (T, error) {
+
+	var t T
 
 	if value == nil {
-		return nil
+		return t, nil
 	}
 
-	if _, isT := value.(T); isT {
-		return value
+	if v, isT := value.(T); isT {
+		return v, nil
 	}
 
-	var t T
+	var result interface{}
 
-	if v, isFloat64 := value.(float64); isFloat64 && reflect.TypeOf(t) == reflect.TypeOf(int(0)) {
-		intVal := int(math.Round(v))
-		return intVal
+	switch any(t).(type) {
+	case int:
+		switch v := value.(type) {
+		case float64:
+			result = int(math.Round(v))
+		case bool:
+			if v {
+				result = 1
+			} else {
+				result = 0
+			}
+		}
+	case float64:
+		if v, isInt := value.(int); isInt {
+			result = float64(v)
+		}
+	case bool:
+		if v, isInt := value.(int); isInt {
+			result = v != 0
+		}
+	case string:
+		if v, isStringer := value.(fmt.Stringer); isStringer {
+			result = v.String()
+		}
 	}
 
-	if v, isInt := value.(int); isInt && reflect.TypeOf(t) == reflect.TypeOf(float64(0)) {
-		floatVal := float64(v)
-		return floatVal
+	if v, ok := result.(T); ok {
+		return v, nil
 	}
 
-	if v, isInt := value.(int); isInt && reflect.TypeOf(t) == reflect.TypeOf(bool(true)) {
-		if v == 0 {
-			return false
-		}
-		return true
+	return t, fmt.Errorf("failed to cast %T to %T", value, t)
+}
+
+// Convenience overloads for standard functions and actions of various arities.
+// Go methods can't have type parameters, so these are package functions
+// that take the Story to bind to.
+//
+// The ink engine often evaluates further than you might expect beyond the
+// current line just in case it sees glue that will cause the two lines to
+// become one. In this case it's possible that a function can appear to be
+// called twice instead of just once, and earlier than you expect. If it's
+// safe for your function to be called in this way (since the result and
+// side effect of the function will not change), then you can pass true
+// for lookaheadSafe. Usually, you want to pass false, especially if you
+// want some action to be performed in game code when this function is called.
+
+// coerceExternalArg
+// Converts argument i for the named EXTERNAL function, reporting an ink
+// error if it isn't of a compatible type.
+func coerceExternalArg[T any](s *Story, funcName string, args []interface{}, i int) T {
+
+	v, err := TryCoerce[T](args[i])
+	if err != nil {
+		s.Error(fmt.Sprintf("EXTERNAL function '%s' argument %d: %s", funcName, i+1, err))
 	}
 
-	if v, isBool := value.(bool); isBool && reflect.TypeOf(t) == reflect.TypeOf(int(0)) {
-		if v == true {
-			return 1
-		} else {
-			return 0
-		}
+	return v
+}
+
+// checkExternalArgs
+// Reports an ink error if an EXTERNAL function was called with the wrong
+// number of arguments.
+func checkExternalArgs(s *Story, funcName string, args []interface{}, expected int) {
+
+	if len(args) != expected {
+		s.Error(fmt.Sprintf("EXTERNAL function '%s' expected %d argument(s) but got %d", funcName, expected, len(args)))
 	}
+}
 
-	if reflect.TypeOf(t) == reflect.TypeOf(string("")) {
-		if v, isString := value.(string); isString {
-			return v
-		} else {
-			return value.(fmt.Stringer).String()
-		}
+func bindExternal(s *Story, funcName string, isNil bool, gfunc func(args []interface{}) interface{}, lookaheadSafe bool) error {
+
+	if isNil {
+		return errors.New("can't bind a nil function to '" + funcName + "'")
 	}
 
-	//Assert (false, "Failed to cast " + value.GetType ().Name + " to " + typeof(T).Name);
-	panic("Failed to cast " + reflect.TypeOf(value).Name() + " to " + reflect.TypeOf(t).Name())
+	return s.BindExternalFunctionalGeneral(funcName, gfunc, lookaheadSafe)
+}
+
+// BindExternalAction0
+// Bind a Go function with no arguments and no return value to an ink
+// EXTERNAL function declaration.
+func BindExternalAction0(s *Story, funcName string, act func(), lookaheadSafe bool) error {
 
-	return nil
+	return bindExternal(s, funcName, act == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 0)
+		act()
+		return nil
+	}, lookaheadSafe)
 }
 
-// Convenience overloads for standard functions and actions of various arities
-// Is there a better way of doing this?!
+// BindExternalFunction0
+// Bind a Go function with no arguments to an ink EXTERNAL function declaration.
+func BindExternalFunction0[R any](s *Story, funcName string, fn func() R, lookaheadSafe bool) error {
 
-/*
-        /// <summary>
-        /// Bind a C# Action to an ink EXTERNAL function declaration.
-        /// </summary>
-        /// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-        /// <param name="act">The C# action to bind.</param>
-        /// <param name="lookaheadSafe">The ink engine often evaluates further
-        /// than you might expect beyond the current line just in case it sees
-        /// glue that will cause the two lines to become one. In this case it's
-        /// possible that a function can appear to be called twice instead of
-        /// just once, and earlier than you expect. If it's safe for your
-        /// function to be called in this way (since the result and side effect
-        /// of the function will not change), then you can pass 'true'.
-        /// Usually, you want to pass 'false', especially if you want some action
-        /// to be performed in game code when this function is called.</param>
-        public void BindExternalFunction(string funcName, Action act, bool lookaheadSafe=false)
-        {
-			Assert(act != null, "Can't bind a null function");
-
-            BindExternalFunctionGeneral (funcName, (object[] args) => {
-                Assert(args.Length == 0, "External function expected no arguments");
-                act();
-                return null;
-            }, lookaheadSafe);
-        }
-*/
+	return bindExternal(s, funcName, fn == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 0)
+		return fn()
+	}, lookaheadSafe)
+}
 
-/*
-  /// <summary>
-        /// Bind a C# function to an ink EXTERNAL function declaration.
-        /// </summary>
-        /// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-        /// <param name="func">The C# function to bind.</param>
-        /// <param name="lookaheadSafe">The ink engine often evaluates further
-        /// than you might expect beyond the current line just in case it sees
-        /// glue that will cause the two lines to become one. In this case it's
-        /// possible that a function can appear to be called twice instead of
-        /// just once, and earlier than you expect. If it's safe for your
-        /// function to be called in this way (since the result and side effect
-        /// of the function will not change), then you can pass 'true'.
-        /// Usually, you want to pass 'false', especially if you want some action
-        /// to be performed in game code when this function is called.</param>
-        public void BindExternalFunction<T>(string funcName, Func<T, object> func, bool lookaheadSafe=false)
-        {
-			Assert(func != null, "Can't bind a null function");
-
-            BindExternalFunctionGeneral (funcName, (object[] args) => {
-                Assert(args.Length == 1, "External function expected one argument");
-                return func( (T)TryCoerce<T>(args[0]) );
-            }, lookaheadSafe);
-        }
-*/
+// BindExternalAction1
+// Bind a Go function with one argument and no return value to an ink
+// EXTERNAL function declaration.
+func BindExternalAction1[T1 any](s *Story, funcName string, act func(T1), lookaheadSafe bool) error {
 
-/*
-  /// <summary>
-        /// Bind a C# action to an ink EXTERNAL function declaration.
-        /// </summary>
-        /// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-        /// <param name="act">The C# action to bind.</param>
-        /// <param name="lookaheadSafe">The ink engine often evaluates further
-        /// than you might expect beyond the current line just in case it sees
-        /// glue that will cause the two lines to become one. In this case it's
-        /// possible that a function can appear to be called twice instead of
-        /// just once, and earlier than you expect. If it's safe for your
-        /// function to be called in this way (since the result and side effect
-        /// of the function will not change), then you can pass 'true'.
-        /// Usually, you want to pass 'false', especially if you want some action
-        /// to be performed in game code when this function is called.</param>
-        public void BindExternalFunction<T>(string funcName, Action<T> act, bool lookaheadSafe=false)
-        {
-			Assert(act != null, "Can't bind a null function");
-
-            BindExternalFunctionGeneral (funcName, (object[] args) => {
-                Assert(args.Length == 1, "External function expected one argument");
-                act( (T)TryCoerce<T>(args[0]) );
-                return null;
-            }, lookaheadSafe);
-        }
-*/
+	return bindExternal(s, funcName, act == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 1)
+		act(coerceExternalArg[T1](s, funcName, args, 0))
+		return nil
+	}, lookaheadSafe)
+}
 
-/*
- /// <summary>
-        /// Bind a C# function to an ink EXTERNAL function declaration.
-        /// </summary>
-        /// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-        /// <param name="func">The C# function to bind.</param>
-        /// <param name="lookaheadSafe">The ink engine often evaluates further
-        /// than you might expect beyond the current line just in case it sees
-        /// glue that will cause the two lines to become one. In this case it's
-        /// possible that a function can appear to be called twice instead of
-        /// just once, and earlier than you expect. If it's safe for your
-        /// function to be called in this way (since the result and side effect
-        /// of the function will not change), then you can pass 'true'.
-        /// Usually, you want to pass 'false', especially if you want some action
-        /// to be performed in game code when this function is called.</param>
-        public void BindExternalFunction<T1, T2>(string funcName, Func<T1, T2, object> func, bool lookaheadSafe = false)
-        {
-			Assert(func != null, "Can't bind a null function");
-
-            BindExternalFunctionGeneral (funcName, (object[] args) => {
-                Assert(args.Length == 2, "External function expected two arguments");
-                return func(
-                    (T1)TryCoerce<T1>(args[0]),
-                    (T2)TryCoerce<T2>(args[1])
-                );
-            }, lookaheadSafe);
-        }
-*/
+// BindExternalFunction1
+// Bind a Go function with one argument to an ink EXTERNAL function declaration.
+func BindExternalFunction1[T1 any, R any](s *Story, funcName string, fn func(T1) R, lookaheadSafe bool) error {
 
-/*
-/// <summary>
-        /// Bind a C# action to an ink EXTERNAL function declaration.
-        /// </summary>
-        /// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-        /// <param name="act">The C# action to bind.</param>
-        /// <param name="lookaheadSafe">The ink engine often evaluates further
-        /// than you might expect beyond the current line just in case it sees
-        /// glue that will cause the two lines to become one. In this case it's
-        /// possible that a function can appear to be called twice instead of
-        /// just once, and earlier than you expect. If it's safe for your
-        /// function to be called in this way (since the result and side effect
-        /// of the function will not change), then you can pass 'true'.
-        /// Usually, you want to pass 'false', especially if you want some action
-        /// to be performed in game code when this function is called.</param>
-        public void BindExternalFunction<T1, T2>(string funcName, Action<T1, T2> act, bool lookaheadSafe=false)
-        {
-			Assert(act != null, "Can't bind a null function");
-
-            BindExternalFunctionGeneral (funcName, (object[] args) => {
-                Assert(args.Length == 2, "External function expected two arguments");
-                act(
-                    (T1)TryCoerce<T1>(args[0]),
-                    (T2)TryCoerce<T2>(args[1])
-                );
-                return null;
-            }, lookaheadSafe);
-        }
-*/
+	return bindExternal(s, funcName, fn == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 1)
+		return fn(coerceExternalArg[T1](s, funcName, args, 0))
+	}, lookaheadSafe)
+}
 
-/*
-  /// <summary>
-        /// Bind a C# function to an ink EXTERNAL function declaration.
-        /// </summary>
-        /// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-        /// <param name="func">The C# function to bind.</param>
-        /// <param name="lookaheadSafe">The ink engine often evaluates further
-        /// than you might expect beyond the current line just in case it sees
-        /// glue that will cause the two lines to become one. In this case it's
-        /// possible that a function can appear to be called twice instead of
-        /// just once, and earlier than you expect. If it's safe for your
-        /// function to be called in this way (since the result and side effect
-        /// of the function will not change), then you can pass 'true'.
-        /// Usually, you want to pass 'false', especially if you want some action
-        /// to be performed in game code when this function is called.</param>
-        public void BindExternalFunction<T1, T2, T3>(string funcName, Func<T1, T2, T3, object> func, bool lookaheadSafe=false)
-        {
-			Assert(func != null, "Can't bind a null function");
-
-            BindExternalFunctionGeneral (funcName, (object[] args) => {
-                Assert(args.Length == 3, "External function expected three arguments");
-                return func(
-                    (T1)TryCoerce<T1>(args[0]),
-                    (T2)TryCoerce<T2>(args[1]),
-                    (T3)TryCoerce<T3>(args[2])
-                );
-            }, lookaheadSafe);
-        }
-*/
+// BindExternalAction2
+// Bind a Go function with two arguments and no return value to an ink
+// EXTERNAL function declaration.
+func BindExternalAction2[T1, T2 any](s *Story, funcName string, act func(T1, T2), lookaheadSafe bool) error {
 
-/*
-/// <summary>
-        /// Bind a C# action to an ink EXTERNAL function declaration.
-        /// </summary>
-        /// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-        /// <param name="act">The C# action to bind.</param>
-        /// <param name="lookaheadSafe">The ink engine often evaluates further
-        /// than you might expect beyond the current line just in case it sees
-        /// glue that will cause the two lines to become one. In this case it's
-        /// possible that a function can appear to be called twice instead of
-        /// just once, and earlier than you expect. If it's safe for your
-        /// function to be called in this way (since the result and side effect
-        /// of the function will not change), then you can pass 'true'.
-        /// Usually, you want to pass 'false', especially if you want some action
-        /// to be performed in game code when this function is called.</param>
-        public void BindExternalFunction<T1, T2, T3>(string funcName, Action<T1, T2, T3> act, bool lookaheadSafe=false)
-        {
-			Assert(act != null, "Can't bind a null function");
-
-            BindExternalFunctionGeneral (funcName, (object[] args) => {
-                Assert(args.Length == 3, "External function expected three arguments");
-                act(
-                    (T1)TryCoerce<T1>(args[0]),
-                    (T2)TryCoerce<T2>(args[1]),
-                    (T3)TryCoerce<T3>(args[2])
-                );
-                return null;
-            }, lookaheadSafe);
-        }
-*/
+	return bindExternal(s, funcName, act == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 2)
+		act(
+			coerceExternalArg[T1](s, funcName, args, 0),
+			coerceExternalArg[T2](s, funcName, args, 1),
+		)
+		return nil
+	}, lookaheadSafe)
+}
 
-/*
- /// <summary>
-        /// Bind a C# function to an ink EXTERNAL function declaration.
-        /// </summary>
-        /// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-        /// <param name="func">The C# function to bind.</param>
-        /// <param name="lookaheadSafe">The ink engine often evaluates further
-        /// than you might expect beyond the current line just in case it sees
-        /// glue that will cause the two lines to become one. In this case it's
-        /// possible that a function can appear to be called twice instead of
-        /// just once, and earlier than you expect. If it's safe for your
-        /// function to be called in this way (since the result and side effect
-        /// of the function will not change), then you can pass 'true'.
-        /// Usually, you want to pass 'false', especially if you want some action
-        /// to be performed in game code when this function is called.</param>
-        public void BindExternalFunction<T1, T2, T3, T4>(string funcName, Func<T1, T2, T3, T4, object> func, bool lookaheadSafe=false)
-        {
-			Assert(func != null, "Can't bind a null function");
-
-            BindExternalFunctionGeneral (funcName, (object[] args) => {
-                Assert(args.Length == 4, "External function expected four arguments");
-                return func(
-                    (T1)TryCoerce<T1>(args[0]),
-                    (T2)TryCoerce<T2>(args[1]),
-                    (T3)TryCoerce<T3>(args[2]),
-                    (T4)TryCoerce<T4>(args[3])
-                );
-            }, lookaheadSafe);
-        }
-*/
+// BindExternalFunction2
+// Bind a Go function with two arguments to an ink EXTERNAL function declaration.
+func BindExternalFunction2[T1, T2 any, R any](s *Story, funcName string, fn func(T1, T2) R, lookaheadSafe bool) error {
 
-/*
-   /// <summary>
-        /// Bind a C# action to an ink EXTERNAL function declaration.
-        /// </summary>
-        /// <param name="funcName">EXTERNAL ink function name to bind to.</param>
-        /// <param name="act">The C# action to bind.</param>
-        /// <param name="lookaheadSafe">The ink engine often evaluates further
-        /// than you might expect beyond the current line just in case it sees
-        /// glue that will cause the two lines to become one. In this case it's
-        /// possible that a function can appear to be called twice instead of
-        /// just once, and earlier than you expect. If it's safe for your
-        /// function to be called in this way (since the result and side effect
-        /// of the function will not change), then you can pass 'true'.
-        /// Usually, you want to pass 'false', especially if you want some action
-        /// to be performed in game code when this function is called.</param>
-        public void BindExternalFunction<T1, T2, T3, T4>(string funcName, Action<T1, T2, T3, T4> act, bool lookaheadSafe=false)
-        {
-			Assert(act != null, "Can't bind a null function");
-
-            BindExternalFunctionGeneral (funcName, (object[] args) => {
-                Assert(args.Length == 4, "External function expected four arguments");
-                act(
-                    (T1)TryCoerce<T1>(args[0]),
-                    (T2)TryCoerce<T2>(args[1]),
-                    (T3)TryCoerce<T3>(args[2]),
-                    (T4)TryCoerce<T4>(args[3])
-                );
-                return null;
-            }, lookaheadSafe);
-        }
-*/
+	return bindExternal(s, funcName, fn == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 2)
+		return fn(
+			coerceExternalArg[T1](s, funcName, args, 0),
+			coerceExternalArg[T2](s, funcName, args, 1),
+		)
+	}, lookaheadSafe)
+}
 
-/*
-  /// <summary>
-        /// Remove a binding for a named EXTERNAL ink function.
-        /// </summary>
-        public void UnbindExternalFunction(string funcName)
-        {
-            IfAsyncWeCant ("unbind an external a function");
-            Assert (_externals.ContainsKey (funcName), "Function '" + funcName + "' has not been bound.");
-            _externals.Remove (funcName);
-        }
-*/
+// BindExternalAction3
+// Bind a Go function with three arguments and no return value to an ink
+// EXTERNAL function declaration.
+func BindExternalAction3[T1, T2, T3 any](s *Story, funcName string, act func(T1, T2, T3), lookaheadSafe bool) error {
 
-// Check that all EXTERNAL ink functions have a valid bound C# function.
-// Note that this is automatically called on the first call to Continue().
-func (s *Story) ValidateExternalBindings() {
+	return bindExternal(s, funcName, act == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 3)
+		act(
+			coerceExternalArg[T1](s, funcName, args, 0),
+			coerceExternalArg[T2](s, funcName, args, 1),
+			coerceExternalArg[T3](s, funcName, args, 2),
+		)
+		return nil
+	}, lookaheadSafe)
+}
 
-	missingExternals := make(map[string]struct{}, 0)
+// BindExternalFunction3
+// Bind a Go function with three arguments to an ink EXTERNAL function declaration.
+func BindExternalFunction3[T1, T2, T3 any, R any](s *Story, funcName string, fn func(T1, T2, T3) R, lookaheadSafe bool) error {
+
+	return bindExternal(s, funcName, fn == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 3)
+		return fn(
+			coerceExternalArg[T1](s, funcName, args, 0),
+			coerceExternalArg[T2](s, funcName, args, 1),
+			coerceExternalArg[T3](s, funcName, args, 2),
+		)
+	}, lookaheadSafe)
+}
+
+// BindExternalAction4
+// Bind a Go function with four arguments and no return value to an ink
+// EXTERNAL function declaration.
+func BindExternalAction4[T1, T2, T3, T4 any](s *Story, funcName string, act func(T1, T2, T3, T4), lookaheadSafe bool) error {
+
+	return bindExternal(s, funcName, act == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 4)
+		act(
+			coerceExternalArg[T1](s, funcName, args, 0),
+			coerceExternalArg[T2](s, funcName, args, 1),
+			coerceExternalArg[T3](s, funcName, args, 2),
+			coerceExternalArg[T4](s, funcName, args, 3),
+		)
+		return nil
+	}, lookaheadSafe)
+}
+
+// BindExternalFunction4
+// Bind a Go function with four arguments to an ink EXTERNAL function declaration.
+func BindExternalFunction4[T1, T2, T3, T4 any, R any](s *Story, funcName string, fn func(T1, T2, T3, T4) R, lookaheadSafe bool) error {
+
+	return bindExternal(s, funcName, fn == nil, func(args []interface{}) interface{} {
+		checkExternalArgs(s, funcName, args, 4)
+		return fn(
+			coerceExternalArg[T1](s, funcName, args, 0),
+			coerceExternalArg[T2](s, funcName, args, 1),
+			coerceExternalArg[T3](s, funcName, args, 2),
+			coerceExternalArg[T4](s, funcName, args, 3),
+		)
+	}, lookaheadSafe)
+}
+
+// UnbindExternalFunction
+// Remove a binding for a named EXTERNAL ink function.
+func (s *Story) UnbindExternalFunction(funcName string) error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("unbind an external function"); err != nil {
+		return err
+	}
+
+	if _, ok := s._externals[funcName]; !ok {
+		return errors.New("function '" + funcName + "' has not been bound")
+	}
+
+	delete(s._externals, funcName)
+	return nil
+}
+
+// ValidateExternalBindings
+// Check that all EXTERNAL ink functions have a valid bound Go function.
+// Note that this is automatically called on the first call to Continue().
+// If any are missing, an *UnboundExternalsError listing them is returned.
+func (s *Story) ValidateExternalBindings() error {
 
-	s.ValidateExternalBindingsEx(s._mainContentContainer, missingExternals)
 	s._hasValidatedExternals = true
 
 	// No problem! Validation complete
+	missingExternals := s.UnboundExternals()
 	if len(missingExternals) == 0 {
-		s._hasValidatedExternals = true
-	} else {
-
-		firstStr := ""
-		if len(missingExternals) > 1 {
-			firstStr = "s"
-		}
+		return nil
+	}
 
-		var missingExternalsArr []string
-		for itemKey, _ := range missingExternals {
-			missingExternalsArr = append(missingExternalsArr, itemKey)
-		}
+	return &UnboundExternalsError{
+		Names:                          missingExternals,
+		AllowExternalFunctionFallbacks: s.AllowExternalFunctionFallbacks,
+	}
+}
 
-		thirdStr := ""
-		if s.AllowExternalFunctionFallbacks {
-			thirdStr = ", and no fallback ink function found."
-		} else {
-			thirdStr = " (ink fallbacks disabled)"
-		}
+// UnboundExternals
+// The names of the EXTERNAL functions declared in the ink that have neither
+// a bound Go function nor (when fallbacks are allowed) a fallback ink
+// function, in sorted order.
+func (s *Story) UnboundExternals() []string {
 
-		// Error for all missing externals
-		var message = fmt.Sprintf("ERROR: Missing function binding for external%s: '%s' %s",
-			firstStr,
-			strings.Join(missingExternalsArr, "', '"),
-			thirdStr,
-		)
+	missingExternals := make(map[string]struct{}, 0)
+	s.ValidateExternalBindingsEx(s._mainContentContainer, missingExternals)
 
-		s.Error(message)
+	names := make([]string, 0, len(missingExternals))
+	for name := range missingExternals {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+
+	return names
 }
 
 func (s *Story) ValidateExternalBindingsEx(c *Container, missingExternals map[string]struct{}) {
@@ -2410,7 +2818,7 @@ func (s *Story) ValidateExternalBindingsObject(o Object, missingExternals map[st
 	if divert, isDivert := o.(*Divert); isDivert && divert.IsExternal {
 		name := divert.TargetPathString()
 
-		if _, contains := s._externals[name]; contains {
+		if _, contains := s._externals[name]; !contains {
 			if s.AllowExternalFunctionFallbacks {
 				_, fallbackFound := s.MainContentContainer().NamedContent()[name]
 				if !fallbackFound {
@@ -2431,88 +2839,116 @@ func (s *Story) ValidateExternalBindingsObject(o Object, missingExternals map[st
 // changes back again to its original value, it will still be called.
 // Note that the observer will also be fired if the value of the variable
 // is changed externally to the ink, by directly setting a value in
-// story.variablesState.
-func (s *Story) ObserveVariable(variableName string, observer func(variableName, newValue interface{})) {
+// story.VariablesState().
+//
+// Go functions can't be compared, so rather than being removed by passing
+// the observer again, it's removed by calling the function returned.
+func (s *Story) ObserveVariable(variableName string, observer VariableObserver) (remove func(), err error) {
 
-	s.IfAsyncWeCant("observe a new variable")
+	exit, err := s.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer exit()
 
-	if s._variableObservers == nil {
-		s._variableObservers = make(map[string]VariableObserver)
+	if err := s.IfAsyncWeCant("observe a new variable"); err != nil {
+		return nil, err
+	}
+
+	if observer == nil {
+		return nil, errors.New("observer for variable '" + variableName + "' is nil")
 	}
 
 	if !s.State().VariablesState().GlobalVariableExistsWithName(variableName) {
-		panic("Cannot observe variable '" + variableName + "' because it wasn't declared in the ink story.")
+		return nil, NewStoryException("Cannot observe variable '" + variableName + "' because it wasn't declared in the ink story.")
 	}
 
-	// TODO
-	//
-	//if (_variableObservers.ContainsKey (variableName)) {
-	//	_variableObservers[variableName] += observer;
-	//} else {
-	//	_variableObservers[variableName] = observer;
-	//}
+	if s._variableObservers == nil {
+		s._variableObservers = make(map[string][]*variableObserver)
+	}
+
+	registration := &variableObserver{observer: observer}
+	s._variableObservers[variableName] = append(s._variableObservers[variableName], registration)
+
+	return func() { s.removeVariableObserver(variableName, registration) }, nil
 }
 
-/*
-   /// <summary>
-   /// Convenience function to allow multiple variables to be observed with the same
-   /// observer delegate function. See the singular ObserveVariable for details.
-   /// The observer will get one call for every variable that has changed.
-   /// </summary>
-   /// <param name="variableNames">The set of variables to observe.</param>
-   /// <param name="observer">The delegate function to call when any of the named variables change.</param>
-   public void ObserveVariables(IList<string> variableNames, VariableObserver observer)
-   {
-       foreach (var varName in variableNames) {
-           ObserveVariable (varName, observer);
-       }
-   }
-*/
+// ObserveVariables
+// Convenience function to allow multiple variables to be observed with the same
+// observer function. See the singular ObserveVariable for details.
+// The observer will get one call for every variable that has changed, and
+// calling the function returned stops it observing all of them.
+func (s *Story) ObserveVariables(variableNames []string, observer VariableObserver) (remove func(), err error) {
 
-/*
- /// <summary>
-        /// Removes the variable observer, to stop getting variable change notifications.
-        /// If you pass a specific variable name, it will stop observing that particular one. If you
-        /// pass null (or leave it blank, since it's optional), then the observer will be removed
-        /// from all variables that it's subscribed to. If you pass in a specific variable name and
-        /// null for the the observer, all observers for that variable will be removed.
-        /// </summary>
-        /// <param name="observer">(Optional) The observer to stop observing.</param>
-        /// <param name="specificVariableName">(Optional) Specific variable name to stop observing.</param>
-        public void RemoveVariableObserver(VariableObserver observer = null, string specificVariableName = null)
-        {
-            IfAsyncWeCant ("remove a variable observer");
-
-            if (_variableObservers == null)
-                return;
-
-            // Remove observer for this specific variable
-            if (specificVariableName != null) {
-                if (_variableObservers.ContainsKey (specificVariableName)) {
-                    if( observer != null) {
-                        _variableObservers [specificVariableName] -= observer;
-                        if (_variableObservers[specificVariableName] == null) {
-                            _variableObservers.Remove(specificVariableName);
-                        }
-                    }
-                    else {
-                        _variableObservers.Remove(specificVariableName);
-                    }
-                }
-            }
-
-            // Remove observer for all variables
-            else if( observer != null) {
-                var keys = new List<string>(_variableObservers.Keys);
-                foreach (var varName in keys) {
-                    _variableObservers[varName] -= observer;
-                    if (_variableObservers[varName] == null) {
-                        _variableObservers.Remove(varName);
-                    }
-                }
-            }
-        }
-*/
+	var removes []func()
+	remove = func() {
+		for _, remove := range removes {
+			remove()
+		}
+	}
+
+	for _, varName := range variableNames {
+		removeOne, err := s.ObserveVariable(varName, observer)
+		if err != nil {
+			remove()
+			return nil, err
+		}
+		removes = append(removes, removeOne)
+	}
+
+	return remove, nil
+}
+
+// RemoveVariableObservers
+// Removes every observer of the named variable, or of all variables if
+// the name is empty, to stop getting variable change notifications. To
+// remove a single observer, call the function that ObserveVariable
+// returned for it.
+func (s *Story) RemoveVariableObservers(specificVariableName string) error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if err := s.IfAsyncWeCant("remove a variable observer"); err != nil {
+		return err
+	}
+
+	if specificVariableName == "" {
+		s._variableObservers = nil
+		return nil
+	}
+
+	delete(s._variableObservers, specificVariableName)
+
+	return nil
+}
+
+// variableObserver
+// An observer as it was registered, so that it can be told apart from
+// the same function registered again.
+type variableObserver struct {
+	observer VariableObserver
+}
+
+func (s *Story) removeVariableObserver(variableName string, registration *variableObserver) {
+
+	observers := s._variableObservers[variableName]
+	for i, o := range observers {
+		if o == registration {
+			observers = append(NewSliceFromSlice(observers[:i]), observers[i+1:]...)
+			break
+		}
+	}
+
+	if len(observers) == 0 {
+		delete(s._variableObservers, variableName)
+	} else {
+		s._variableObservers[variableName] = observers
+	}
+}
 
 func (s *Story) VariableStateDidChangeEvent(variableName string, newValueObj Object) {
 
@@ -2520,54 +2956,54 @@ func (s *Story) VariableStateDidChangeEvent(variableName string, newValueObj Obj
 		return
 	}
 
-	// TODO
+	observers, ok := s._variableObservers[variableName]
+	if !ok {
+		return
+	}
 
-	/*
-	 VariableObserver observers = null;
-	            if (_variableObservers.TryGetValue (variableName, out observers)) {
+	// Only standard value types can be observed
+	val, ok := newValueObj.(Value)
+	if !ok {
+		return
+	}
 
-	                if (!(newValueObj is Value)) {
-	                    throw new System.Exception ("Tried to get the value of a variable that isn't a standard type");
-	                }
-	                var val = newValueObj as Value;
+	// Copy so that observers may safely remove themselves
+	s.callGame(func() {
+		for _, o := range NewSliceFromSlice(observers) {
+			o.observer(variableName, val.ValueObject())
+		}
+	})
+}
 
-	                observers (variableName, val.valueObject);
-	            }
-	*/
+// GlobalTags
+// Get any global tags associated with the story. These are defined as
+// hash tags defined at the very top of the story.
+func (s *Story) GlobalTags() ([]string, error) {
+	return s.TagsAtStartOfFlowContainerWithPathString("")
 }
 
-/*
- /// <summary>
-        /// Get any global tags associated with the story. These are defined as
-        /// hash tags defined at the very top of the story.
-        /// </summary>
-        public List<string> globalTags {
-            get {
-                return TagsAtStartOfFlowContainerWithPathString ("");
-            }
-        }
-*/
+// TagsForContentAtPath
+// Gets any tags associated with a particular knot or knot.stitch.
+// These are defined as hash tags defined at the very top of a
+// knot or stitch. The path is in the form "knot" or "knot.stitch".
+func (s *Story) TagsForContentAtPath(path string) ([]string, error) {
+	return s.TagsAtStartOfFlowContainerWithPathString(path)
+}
 
-/*
-   /// <summary>
-        /// Gets any tags associated with a particular knot or knot.stitch.
-        /// These are defined as hash tags defined at the very top of a
-        /// knot or stitch.
-        /// </summary>
-        /// <param name="path">The path of the knot or stitch, in the form "knot" or "knot.stitch".</param>
-        public List<string> TagsForContentAtPath (string path)
-        {
-            return TagsAtStartOfFlowContainerWithPathString (path);
-        }
-*/
+func (s *Story) TagsAtStartOfFlowContainerWithPathString(pathString string) (tags []string, err error) {
 
-func (s *Story) TagsAtStartOfFlowContainerWithPathString(pathString string) []string {
+	defer recoverStoryException(&err)
 
 	path := NewPathFromString(pathString)
 
 	// Expected to be global story, knot or stitch
-	flowContainer := s.ContentAtPath(path).Container()
-	for true {
+	result := s.ContentAtPath(path)
+	flowContainer := result.Container()
+	if flowContainer == nil || result.Approximate {
+		return nil, NewStoryException("Content at path not found: " + pathString)
+	}
+
+	for len(flowContainer.Content()) > 0 {
 		firstContent := flowContainer.Content()[0]
 		if flowC, isContainer := firstContent.(*Container); isContainer {
 			flowContainer = flowC
@@ -2578,7 +3014,6 @@ func (s *Story) TagsAtStartOfFlowContainerWithPathString(pathString string) []st
 
 	// Any initial tag objects count as the "main tags" associated with that story/knot/stitch
 	inTag := false
-	var tags []string
 
 	for _, c := range flowContainer.Content() {
 
@@ -2594,8 +3029,11 @@ func (s *Story) TagsAtStartOfFlowContainerWithPathString(pathString string) []st
 			if str != nil {
 				tags = append(tags, str.Value())
 			} else {
-				s.Error("Tag contained non-text content. Only plain text is allowed when using globalTags or TagsAtContentPath. If you want to evaluate dynamic content, you need to use story.Continue().")
+				s.Error("Tag contained non-text content. Only plain text is allowed when using GlobalTags or TagsForContentAtPath. If you want to evaluate dynamic content, you need to use story.Continue().")
 			}
+		} else if tag, isTag := c.(*Tag); isTag {
+			// Legacy tags, from stories built before ink version 21
+			tags = append(tags, tag.Text())
 		} else {
 			// Any other content - we're done
 			// We only recognise initial text-only tags
@@ -2603,7 +3041,7 @@ func (s *Story) TagsAtStartOfFlowContainerWithPathString(pathString string) []st
 		}
 	}
 
-	return tags
+	return tags, nil
 }
 
 /*
@@ -2802,14 +3240,14 @@ func (s *Story) NextSequenceShuffleIndex() int {
 		sequenceHash += int(c)
 	}
 	randomSeed := sequenceHash + loopIndex + s.State().StorySeed
-	random := rand.New(rand.NewSource(int64(randomSeed)))
+	s._randomSource.Seed(randomSeed)
 	var unpickedIndices []int
 	for i := 0; i < numElements; i++ {
 		unpickedIndices = append(unpickedIndices, i)
 	}
 
 	for i := 0; i <= iterationIndex; i++ {
-		chosen := random.Int() % len(unpickedIndices)
+		chosen := s._randomSource.Next() % len(unpickedIndices)
 		chosenIndex := unpickedIndices[chosen]
 		unpickedIndices = append(unpickedIndices[:chosen], unpickedIndices[chosen+1:]...)
 
@@ -2821,42 +3259,39 @@ func (s *Story) NextSequenceShuffleIndex() int {
 	panic("Should never reach here")
 }
 
+// Error
+// Raises a StoryException, which is recovered by the public entry point
+// that is currently running and reported as an ink error.
 // (default) useEndLineNumber: false
 func (s *Story) Error(message string) {
-	panic(message)
+	panic(NewStoryException(message))
 }
 
 func (s *Story) Warning(message string) {
 
-	s.AddError(message, false, false)
+	s.AddError(message, true, false)
 }
 
 // (default) isWarning: false
 // (default) useEndLineNumber: false
 func (s *Story) AddError(message string, isWarning bool, useEndLineNumber bool) {
 
-	dm := s.CurrentDebugMetadata()
+	rtErr := &RuntimeError{
+		Message:          message,
+		Type:             ErrorTypeError,
+		DebugMetadata:    s.CurrentDebugMetadata(),
+		UseEndLineNumber: useEndLineNumber,
+	}
 
-	errorTypeStr := "ERROR"
 	if isWarning {
-		errorTypeStr = "WARNING"
+		rtErr.Type = ErrorTypeWarning
 	}
 
-	if dm != nil {
-		lineNum := 0
-		if useEndLineNumber {
-			lineNum = dm.EndLineNumber
-		} else {
-			lineNum = dm.StartLineNumber
-		}
-		message = fmt.Sprintf("RUNTIME %s: '%s' line %d: %s", errorTypeStr, dm.FileName, lineNum, message)
-	} else if !s.State().CurrentPointer().IsNull() {
-		message = fmt.Sprintf("RUNTIME %s: (%s): %s", errorTypeStr, s.State().CurrentPointer().Path().String(), message)
-	} else {
-		message = "RUNTIME " + errorTypeStr + ": " + message
+	if rtErr.DebugMetadata == nil && !s.State().CurrentPointer().IsNull() {
+		rtErr.Path = s.State().CurrentPointer().Path().String()
 	}
 
-	s.State().AddError(message, isWarning)
+	s.State().AddError(rtErr)
 
 	// In a broken state don't need to know about any other errors.
 	if !isWarning {
@@ -2870,7 +3305,7 @@ func (s *Story) CurrentDebugMetadata() *DebugMetadata {
 
 	// Try to get from the current path first
 	pointer := s.State().CurrentPointer()
-	if !pointer.IsNull() {
+	if !pointer.IsNull() && pointer.Resolve() != nil {
 		dm = pointer.Resolve().DebugMetadata()
 		if dm != nil {
 			return dm
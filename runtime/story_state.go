@@ -1,10 +1,12 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
-	"reflect"
+	"sort"
 	"strings"
 	"time"
 )
@@ -63,7 +65,8 @@ func (s *ActionT1Event[T]) Emit(v T) {
 // including global variables, read counts, the pointer to the current
 // point in the story, the call stack (for tunnels, functions, etc),
 // and a few other smaller bits and pieces. You can save the current
-// state using the json serialisation functions ToJson and LoadJson.
+// state using the json serialisation functions ToJson and LoadJson, or
+// in a more compact form with WriteBinary and LoadBinary.
 type StoryState struct {
 
 	// Private
@@ -76,14 +79,15 @@ type StoryState struct {
 	_currentFlow           *Flow
 	_namedFlows            map[string]*Flow
 	_aliveFlowNamesDirty   bool // must be set true in constructor
-	_currentErrors         []string
-	_currentWarnings       []string
+	_currentErrors         []*RuntimeError
+	_currentWarnings       []*RuntimeError
 	_variablesState        *VariablesState
 	_aliveFlowNames        []string
 	_evaluationStack       []Object
 	_story                 *Story
 	_currentTags           []string
 	_currentTurnIndex      int
+	_detached              bool // a copy, or frozen for a background save
 
 	// Public
 	OnDidLoadState  *ActionEvent
@@ -121,14 +125,41 @@ func (s *StoryState) GeneratedChoices() []*Choice {
 // errors before throwing/exiting?
 func (s *StoryState) CurrentErrors() []string {
 
-	return s._currentErrors
+	return runtimeErrorMessages(s._currentErrors)
 }
 
 func (s *StoryState) CurrentWarnings() []string {
 
+	return runtimeErrorMessages(s._currentWarnings)
+}
+
+// CurrentRuntimeErrors
+// The errors behind CurrentErrors, including where in the ink they happened.
+func (s *StoryState) CurrentRuntimeErrors() []*RuntimeError {
+
+	return s._currentErrors
+}
+
+// CurrentRuntimeWarnings
+// The warnings behind CurrentWarnings, including where in the ink they happened.
+func (s *StoryState) CurrentRuntimeWarnings() []*RuntimeError {
+
 	return s._currentWarnings
 }
 
+func runtimeErrorMessages(errs []*RuntimeError) []string {
+
+	if errs == nil {
+		return nil
+	}
+
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return messages
+}
+
 func (s *StoryState) VariablesState() *VariablesState {
 
 	return s._variablesState
@@ -187,12 +218,12 @@ func (s *StoryState) CanContinue() bool {
 
 func (s *StoryState) HasError() bool {
 
-	return s.CurrentErrors() != nil && len(s.CurrentErrors()) > 0
+	return len(s._currentErrors) > 0
 }
 
 func (s *StoryState) HasWarning() bool {
 
-	return s.CurrentWarnings() != nil && len(s.CurrentWarnings()) > 0
+	return len(s._currentWarnings) > 0
 }
 
 func (s *StoryState) CurrentText() string {
@@ -229,26 +260,177 @@ func (s *StoryState) CurrentText() string {
 	return s._currentText
 }
 
+// enter
+// Marks the state's story as busy while the state is changed, as
+// Story.enter does.
+func (s *StoryState) enter() (exit func(), err error) {
+
+	if s._story == nil {
+		return func() {}, nil
+	}
+
+	return s._story.enter()
+}
+
+// enterToSave
+// Marks the state's story as busy while the state is saved, as enter
+// does. A copy of the state, or one frozen by
+// Story.CopyStateForBackgroundThreadSave, isn't changed by the story, so
+// it can be saved while the story is busy.
+func (s *StoryState) enterToSave() (exit func(), err error) {
+
+	if s._detached {
+		return func() {}, nil
+	}
+
+	return s.enter()
+}
+
 // ToJson
 // exports the current state to json format, in order to save the game.
-func (s *StoryState) ToJson() string {
+// As with Story.ToJson, the same state always gives the same JSON.
+func (s *StoryState) ToJson() (string, error) {
+
+	exit, err := s.enterToSave()
+	if err != nil {
+		return "", err
+	}
+	defer exit()
 
 	writer := new(Writer)
 	s.WriteJson(writer)
 
-	return writer.String()
+	return writer.String(), nil
+}
+
+// WriteTo
+// Writes the current state to w in the same JSON format as ToJson,
+// without building the whole save in memory first. It implements
+// io.WriterTo.
+func (s *StoryState) WriteTo(w io.Writer) (int64, error) {
+
+	exit, err := s.enterToSave()
+	if err != nil {
+		return 0, err
+	}
+	defer exit()
+
+	counter := &countingWriter{w: w}
+
+	writer, release := borrowStreamWriter(counter)
+	defer release()
+
+	s.WriteJson(writer)
+
+	err = writer.Flush()
+	return counter.n, err
 }
 
 // LoadJson
 // loads a previously saved state in JSON format.
-func (s *StoryState) LoadJson(json string) {
+func (s *StoryState) LoadJson(json string) error {
+	_, err := s.ReadFrom(strings.NewReader(json))
+	return err
+}
+
+// ReadFrom
+// Loads a previously saved state from the JSON read from r, parsing it
+// as it's read. It implements io.ReaderFrom.
+func (s *StoryState) ReadFrom(r io.Reader) (int64, error) {
+
+	counter := &countingReader{r: r}
+
+	jObject, err := ReaderToDictionary(counter)
+	if err != nil {
+		return counter.n, err
+	}
+
+	if err := s.LoadJsonObj(jObject); err != nil {
+		return counter.n, err
+	}
+
+	if s.OnDidLoadState != nil {
+		s.OnDidLoadState.Emit()
+	}
+
+	return counter.n, nil
+}
+
+// WriteBinary
+// Writes the current state in a compact binary format that LoadBinary
+// reads. It holds the same state as the JSON save, in a fraction of the
+// space.
+func (s *StoryState) WriteBinary(w io.Writer) error {
+
+	exit, err := s.enterToSave()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	writer := newBinarySaveWriter()
+	writer.WriteStoryState(s)
+
+	_, err = w.Write(writer.Bytes(binarySaveIdentifier))
+	return err
+}
+
+// LoadBinary
+// Loads a previously saved state in the binary format written by
+// WriteBinary, first upgrading it with any registered binary save
+// migrations if it's from an older version.
+func (s *StoryState) LoadBinary(data []byte) (err error) {
 
-	jObject := TextToDictionary(json)
-	s.LoadJsonObj(jObject)
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
+	if !IsBinarySave(data) {
+		return fmt.Errorf("%w: binary save identifier not found", ErrSaveFormatIncompatible)
+	}
+
+	saveVersion, err := binarySaveVersion(data)
+	if err != nil {
+		return err
+	}
+
+	if data, saveVersion, err = migrateBinarySave(data, saveVersion); err != nil {
+		return err
+	}
+
+	if saveVersion < kMinCompatibleBinaryLoadVersion {
+		return fmt.Errorf("%w: saw '%d', but minimum is %d", ErrSaveFormatIncompatible, saveVersion, kMinCompatibleBinaryLoadVersion)
+	}
+
+	if err := s.loadBinarySave(data); err != nil {
+		return err
+	}
 
 	if s.OnDidLoadState != nil {
 		s.OnDidLoadState.Emit()
 	}
+
+	return nil
+}
+
+func (s *StoryState) loadBinarySave(data []byte) (err error) {
+
+	// Malformed data panics part way through reading
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*StoryException); ok {
+				err = e
+				return
+			}
+			err = fmt.Errorf("invalid binary save: %v", r)
+		}
+	}()
+
+	newBinarySaveReader(data).ReadStoryState(s)
+
+	return nil
 }
 
 // VisitCountAtPathString
@@ -257,25 +439,25 @@ func (s *StoryState) LoadJson(json string) {
 //
 //     knot
 //     knot.stitch
-func (s *StoryState) VisitCountAtPathString(pathString string) int {
+func (s *StoryState) VisitCountAtPathString(pathString string) (int, error) {
 
 	if s._patch != nil {
 
 		container := s._story.ContentAtPath(NewPathFromString(pathString)).Container()
 		if container == nil {
-			panic("Content at path not found: " + pathString)
+			return 0, NewStoryException("Content at path not found: " + pathString)
 		}
 
 		if visitCount, ok := s._patch.TryGetVisitCount(container); ok {
-			return visitCount
+			return visitCount, nil
 		}
 	}
 
 	if visitCount, ok := s._visitCounts[pathString]; ok {
-		return visitCount
+		return visitCount, nil
 	}
 
-	return 0
+	return 0, nil
 }
 
 func (s *StoryState) VisitCountForContainer(container *Container) int {
@@ -385,6 +567,8 @@ func (s *StoryState) CurrentTags() []string {
 
 	if s._outputStreamTagsDirty {
 
+		s._currentTags = nil
+
 		inTag := false
 		var sb strings.Builder
 
@@ -442,6 +626,9 @@ func (s *StoryState) CurrentFlowIsDefaultFlow() bool {
 	return s._currentFlow.Name == kDefaultFlowName
 }
 
+// AliveFlowNames
+// The names of all the flows that have been created, other than the
+// default flow, in alphabetical order.
 func (s *StoryState) AliveFlowNames() []string {
 
 	if s._aliveFlowNamesDirty {
@@ -456,6 +643,8 @@ func (s *StoryState) AliveFlowNames() []string {
 			}
 		}
 
+		sort.Strings(s._aliveFlowNames)
+
 		s._aliveFlowNamesDirty = false
 	}
 
@@ -483,6 +672,7 @@ func NewStoryState(story *Story) *StoryState {
 	newStoryState._aliveFlowNamesDirty = true // set true in c# class def
 	newStoryState._evaluationStack = []Object{}
 	newStoryState._variablesState = NewVariablesState(newStoryState.CallStack(), story.ListDefinitions())
+	newStoryState._variablesState._story = story
 	newStoryState._visitCounts = make(map[string]int)
 	newStoryState._turnIndices = make(map[string]int)
 	newStoryState._currentTurnIndex = -1
@@ -502,10 +692,10 @@ func (s *StoryState) GoToStart() {
 	s.CallStack().CurrentElement().CurrentPointer = StartOfPointer(s._story.MainContentContainer())
 }
 
-func (s *StoryState) switchFlow_Internal(flowName string) {
+func (s *StoryState) switchFlow_Internal(flowName string) error {
 
 	if flowName == "" {
-		panic("Must pass a non-null string to Story.SwitchFlow")
+		return errors.New("must pass a non-empty string to Story.SwitchFlow")
 	}
 
 	if s._namedFlows == nil {
@@ -514,7 +704,7 @@ func (s *StoryState) switchFlow_Internal(flowName string) {
 	}
 
 	if flowName == s._currentFlow.Name {
-		return
+		return nil
 	}
 
 	var flow *Flow
@@ -530,6 +720,7 @@ func (s *StoryState) switchFlow_Internal(flowName string) {
 
 	// Cause text to be regenerated from output stream if necessary
 	s.OutputStreamDirty()
+	return nil
 }
 
 func (s *StoryState) switchToDefaultFlow_Internal() {
@@ -538,17 +729,17 @@ func (s *StoryState) switchToDefaultFlow_Internal() {
 		return
 	}
 
-	s.switchFlow_Internal(kDefaultFlowName)
+	_ = s.switchFlow_Internal(kDefaultFlowName)
 }
 
-func (s *StoryState) removeFlow_Internal(flowName string) {
+func (s *StoryState) removeFlow_Internal(flowName string) error {
 
 	if flowName == "" {
-		panic("Must pass a non-null string to Story.DestroyFlow")
+		return errors.New("must pass a non-empty string to Story.RemoveFlow")
 	}
 
 	if flowName == kDefaultFlowName {
-		panic("Cannot destroy default flow")
+		return errors.New("cannot destroy default flow")
 	}
 
 	// If we're currently in the flow that's being removed, switch back to default
@@ -557,6 +748,7 @@ func (s *StoryState) removeFlow_Internal(flowName string) {
 	}
 	delete(s._namedFlows, flowName)
 	s._aliveFlowNamesDirty = true
+	return nil
 }
 
 // Warning: Any Runtime.Object content referenced within the StoryState will
@@ -630,6 +822,54 @@ func (s *StoryState) CopyAndStartPatching() *StoryState {
 	return storyStateCopy
 }
 
+// Copy
+// Makes an independent snapshot of the state, such as one for Diff to
+// compare against later. As with CopyAndStartPatching, runtime objects
+// are shared rather than cloned.
+func (s *StoryState) Copy() *StoryState {
+
+	storyStateCopy := new(StoryState)
+	storyStateCopy._story = s._story
+	storyStateCopy._detached = true
+	storyStateCopy.OutputStreamDirty()
+	storyStateCopy._aliveFlowNamesDirty = true
+
+	if s._namedFlows != nil {
+		storyStateCopy._namedFlows = make(map[string]*Flow, len(s._namedFlows))
+		for namedFlowKey, namedFlowValue := range s._namedFlows {
+			storyStateCopy._namedFlows[namedFlowKey] = namedFlowValue.Copy()
+		}
+		storyStateCopy._currentFlow = storyStateCopy._namedFlows[s._currentFlow.Name]
+	} else {
+		storyStateCopy._currentFlow = s._currentFlow.Copy()
+	}
+
+	if s._patch != nil {
+		storyStateCopy._patch = NewStatePatchFromStatePatch(s._patch)
+	}
+
+	variablesState := NewVariablesState(storyStateCopy.CallStack(), s._variablesState._listDefsOrigin)
+	variablesState._globalVariables = NewMapFromMap(s._variablesState._globalVariables)
+	variablesState._defaultGlobalVariables = s._variablesState._defaultGlobalVariables
+	variablesState.Patch = storyStateCopy._patch
+	variablesState._story = s._variablesState._story
+	storyStateCopy._variablesState = variablesState
+
+	storyStateCopy._currentErrors = NewSliceFromSlice(s._currentErrors)
+	storyStateCopy._currentWarnings = NewSliceFromSlice(s._currentWarnings)
+	storyStateCopy._evaluationStack = NewSliceFromSlice(s._evaluationStack)
+	storyStateCopy._visitCounts = NewMapFromMap(s._visitCounts)
+	storyStateCopy._turnIndices = NewMapFromMap(s._turnIndices)
+
+	storyStateCopy.DivertedPointer = s.DivertedPointer
+	storyStateCopy._currentTurnIndex = s._currentTurnIndex
+	storyStateCopy.StorySeed = s.StorySeed
+	storyStateCopy.PreviousRandom = s.PreviousRandom
+	storyStateCopy.DidSafeExit = s.DidSafeExit
+
+	return storyStateCopy
+}
+
 func (s *StoryState) RestoreAfterPatch() {
 
 	// VariablesState was being borrowed by the patched
@@ -680,9 +920,9 @@ func (s *StoryState) WriteJson(writer *Writer) {
 
 	// Multi-flow
 	if s._namedFlows != nil {
-		for namedFlowKey, namedFlowValue := range s._namedFlows {
+		for _, namedFlowKey := range SortedKeys(s._namedFlows) {
 			writer.WritePropertyStart(namedFlowKey)
-			namedFlowValue.WriteJson(writer)
+			s._namedFlows[namedFlowKey].WriteJson(writer)
 			writer.WritePropertyEnd()
 		}
 	} else {
@@ -729,98 +969,181 @@ func (s *StoryState) WriteJson(writer *Writer) {
 	writer.WriteObjectEnd()
 }
 
-func (s *StoryState) LoadJsonObj(jObject map[string]interface{}) {
+// LoadJsonObj
+// Loads a save that's been parsed into a dictionary. The whole save is
+// read before anything in the state is changed, so a save that's
+// malformed part way through leaves the state as it was.
+func (s *StoryState) LoadJsonObj(jObject map[string]interface{}) (err error) {
 
-	var jSaveVersion interface{}
-	ok := false
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
 
-	if jSaveVersion, ok = jObject["inkSaveVersion"]; !ok {
-		panic("ink save format incorrect, can't load.")
+	jSaveVersion, ok := jObject["inkSaveVersion"].(int)
+	if !ok {
+		return fmt.Errorf("%w: save format incorrect, can't load", ErrSaveFormatIncompatible)
 	}
 
-	if jSaveVersion.(int) < KInkSaveStateVersion {
-		panic("Ink save format isn't compatible with the current version (saw '" + fmt.Sprint(jSaveVersion) + "', but minimum is " + fmt.Sprint(kMinCompatibleLoadVersion) + "), so can't load.")
+	// Upgrade older saves where there's a registered migration
+	if jSaveVersion, err = migrateSave(jObject, jSaveVersion); err != nil {
+		return err
 	}
 
+	if jSaveVersion < kMinCompatibleLoadVersion {
+		return fmt.Errorf("%w: saw '%d', but minimum is %d", ErrSaveFormatIncompatible, jSaveVersion, kMinCompatibleLoadVersion)
+	}
+
+	return s.loadJsonSave(jObject)
+}
+
+func (s *StoryState) loadJsonSave(jObject map[string]interface{}) (err error) {
+
+	// Malformed data further down panics part way through reading
+	defer recoverUnmarshal("save", &err)
+
+	var namedFlows map[string]*Flow
+	var currentFlow *Flow
+
 	// Flows: Always exists in latest format (even if there's just one default)
 	// but this dictionary doesn't exist in prev format
 	if flowsObj, ok := jObject["flows"]; ok {
 
-		flowsObjDict := flowsObj.(map[string]interface{})
-
-		// Single default flow
-		if len(flowsObjDict) == 1 {
-			s._namedFlows = nil
-		} else if s._namedFlows == nil {
-			// Multi-flow, need to create flows dict
-			s._namedFlows = make(map[string]*Flow)
-		} else {
-			// Multi-flow, already have a flows dict
-			for key, _ := range s._namedFlows {
-				delete(s._namedFlows, key)
-			}
+		flowsObjDict, ok := flowsObj.(map[string]interface{})
+		if !ok || len(flowsObjDict) == 0 {
+			return errors.New("invalid save: 'flows' has no flows")
 		}
 
 		// Load up each flow (there may only be one)
+		flows := make(map[string]*Flow, len(flowsObjDict))
 		for name, namedFlowObjValue := range flowsObjDict {
-			flowObj := namedFlowObjValue.(map[string]interface{})
+			flowObj, ok := namedFlowObjValue.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid save: flow '%s' isn't an object", name)
+			}
 
 			// Load up this flow using JSON data
-			flow := NewFlowFromJObject(name, s._story, flowObj)
+			flows[name] = NewFlowFromJObject(name, s._story, flowObj)
+		}
 
-			if len(flowsObjDict) == 1 {
-				s._currentFlow = NewFlowFromJObject(name, s._story, flowObj)
-			} else {
-				s._namedFlows[name] = flow
+		if len(flows) == 1 {
+			// Single default flow
+			for _, flow := range flows {
+				currentFlow = flow
 			}
-		}
+		} else {
+			namedFlows = flows
 
-		if s._namedFlows != nil && len(s._namedFlows) > 1 {
-			currFlowName := jObject["currentFlowName"].(string)
-			s._currentFlow = s._namedFlows[currFlowName]
+			currFlowName, _ := jObject["currentFlowName"].(string)
+			if currentFlow, ok = namedFlows[currFlowName]; !ok {
+				return fmt.Errorf("invalid save: no flow named '%s'", currFlowName)
+			}
 		}
 	} else {
 		// Old format: individually load up callstack, output stream, choices in current/default flow
 
-		s._namedFlows = nil
-		s._currentFlow.Name = kDefaultFlowName
-		s._currentFlow.CallStack.SetJsonToken(jObject["callstackThreads"].(map[string]interface{}), s._story)
-		s._currentFlow.OutputStream = JArrayToRuntimeObjList[Object](jObject["outputStream"].([]interface{}), false)
-		s._currentFlow.CurrentChoices = JArrayToRuntimeObjList[*Choice](jObject["currentChoices"].([]interface{}), false)
+		jCallstackThreads, err := saveProperty[map[string]interface{}](jObject, "callstackThreads")
+		if err != nil {
+			return err
+		}
+		jOutputStream, err := saveProperty[[]interface{}](jObject, "outputStream")
+		if err != nil {
+			return err
+		}
+		jCurrentChoices, err := saveProperty[[]interface{}](jObject, "currentChoices")
+		if err != nil {
+			return err
+		}
+		jChoiceThreads, err := saveProperty[map[string]interface{}](jObject, "choiceThreads")
+		if err != nil {
+			return err
+		}
+
+		currentFlow = NewFlow(kDefaultFlowName, s._story)
+		currentFlow.CallStack.SetJsonToken(jCallstackThreads, s._story)
+		currentFlow.OutputStream = JArrayToRuntimeObjList[Object](jOutputStream, false)
+		currentFlow.CurrentChoices = JArrayToRuntimeObjList[*Choice](jCurrentChoices, false)
+		currentFlow.LoadFlowChoiceThreads(jChoiceThreads, s._story)
+	}
+
+	jVariablesState, err := saveProperty[map[string]interface{}](jObject, "variablesState")
+	if err != nil {
+		return err
+	}
+	loadedGlobals := make(map[string]Object, len(jVariablesState))
+	for name, loadedToken := range jVariablesState {
+		loadedGlobals[name] = JTokenToRuntimeObject(loadedToken)
+	}
+
+	jEvalStack, err := saveProperty[[]interface{}](jObject, "evalStack")
+	if err != nil {
+		return err
+	}
+	evaluationStack := JArrayToRuntimeObjList[Object](jEvalStack, false)
+
+	divertedPointer := NullPointer
+	if currentDivertTargetPath, ok := jObject["currentDivertTarget"].(string); ok {
+		divertPath := NewPathFromString(currentDivertTargetPath)
+		divertedPointer = s._story.PointerAtPath(divertPath)
+	}
+
+	jVisitCounts, err := saveProperty[map[string]interface{}](jObject, "visitCounts")
+	if err != nil {
+		return err
+	}
+	jTurnIndices, err := saveProperty[map[string]interface{}](jObject, "turnIndices")
+	if err != nil {
+		return err
+	}
+	visitCounts := JObjectToIntDictionary(jVisitCounts)
+	turnIndices := JObjectToIntDictionary(jTurnIndices)
 
-		jChoiceThreadsObj := jObject["choiceThreads"]
-		s._currentFlow.LoadFlowChoiceThreads(jChoiceThreadsObj.(map[string]interface{}), s._story)
+	currentTurnIndex, err := saveProperty[int](jObject, "turnIdx")
+	if err != nil {
+		return err
+	}
+	storySeed, err := saveProperty[int](jObject, "storySeed")
+	if err != nil {
+		return err
 	}
 
+	// Not optional, but bug in inkjs means it's actually missing in inkjs saves
+	previousRandom, _ := jObject["previousRandom"].(int)
+
+	s._namedFlows = namedFlows
+	s._currentFlow = currentFlow
+
 	s.OutputStreamDirty()
 	s._aliveFlowNamesDirty = true
 
-	s._variablesState.SetJsonToken(jObject["variablesState"].(map[string]interface{}))
+	s._variablesState.setLoadedGlobals(loadedGlobals)
 	s._variablesState.SetCallStack(s._currentFlow.CallStack)
 
-	s._evaluationStack = JArrayToRuntimeObjList[Object](jObject["evalStack"].([]interface{}), false)
+	s._evaluationStack = evaluationStack
+	s.DivertedPointer = divertedPointer
 
-	var currentDivertTargetPath interface{}
-	ok = false
-	if currentDivertTargetPath, ok = jObject["currentDivertTarget"]; ok {
-		divertPath := NewPathFromString(currentDivertTargetPath.(fmt.Stringer).String())
-		s.DivertedPointer = s._story.PointerAtPath(divertPath)
-	}
+	s._visitCounts = visitCounts
+	s._turnIndices = turnIndices
 
-	s._visitCounts = JObjectToIntDictionary(jObject["visitCounts"].(map[string]interface{}))
-	s._turnIndices = JObjectToIntDictionary(jObject["turnIndices"].(map[string]interface{}))
+	s._currentTurnIndex = currentTurnIndex
+	s.StorySeed = storySeed
+	s.PreviousRandom = previousRandom
 
-	s._currentTurnIndex = jObject["turnIdx"].(int)
-	s.StorySeed = jObject["storySeed"].(int)
+	return nil
+}
 
-	// Not optional, but bug in inkjs means it's actually missing in inkjs saves
-	var previousRandomObj interface{}
-	ok = false
-	if previousRandomObj, ok = jObject["previousRandom"]; ok {
-		s.PreviousRandom = previousRandomObj.(int)
-	} else {
-		s.PreviousRandom = 0
+// saveProperty
+// Gets a property of a save that has to be there, with the type it's
+// saved as.
+func saveProperty[T any](jObject map[string]interface{}, name string) (T, error) {
+
+	value, ok := jObject[name].(T)
+	if !ok {
+		return value, fmt.Errorf("invalid save: '%s' is missing or has the wrong type", name)
 	}
+
+	return value, nil
 }
 
 func (s *StoryState) ResetErrors() {
@@ -944,7 +1267,7 @@ func (s *StoryState) TrySplittingHeadTailWhitespace(single *StringValue) []*Stri
 	}
 
 	if innerStrEnd > innerStrStart {
-		innerStrText := str[innerStrStart : innerStrEnd-innerStrStart]
+		innerStrText := str[innerStrStart:innerStrEnd]
 		//listTexts = append(listTexts, NewStringValueFromString(innerStrText))
 		listTexts = append(listTexts, NewStringValueFromString(innerStrText))
 	}
@@ -953,8 +1276,7 @@ func (s *StoryState) TrySplittingHeadTailWhitespace(single *StringValue) []*Stri
 		//listTexts = append(listTexts, NewStringValueFromString("\n"))
 		listTexts = append(listTexts, NewStringValueFromString("\n"))
 		if tailLastNewlineIdx < len(str)-1 {
-			numSpaces := (len(str) - tailLastNewlineIdx) - 1
-			trailingSpaces := NewStringValueFromString(str[tailLastNewlineIdx+1 : numSpaces])
+			trailingSpaces := NewStringValueFromString(str[tailLastNewlineIdx+1:])
 			//listTexts = append(listTexts, trailingSpaces)
 			listTexts = append(listTexts, trailingSpaces)
 		}
@@ -1041,7 +1363,7 @@ func (s *StoryState) PushToOutputStreamIndividual(obj Object) {
 				// so trimming whitespace at the start is done.
 				if functionTrimIndex > -1 {
 					callstackElements := s.CallStack().Elements()
-					for i := len(callstackElements); i >= 0; i-- {
+					for i := len(callstackElements) - 1; i >= 0; i-- {
 						el := callstackElements[i]
 						if el.PushPopType() == Function {
 							el.FunctionStartInOutputStream = -1
@@ -1082,7 +1404,7 @@ func (s *StoryState) TrimNewlinesFromOutputStream() {
 		cmd, _ := obj.(*ControlCommand) // C# as
 		txt, _ := obj.(*StringValue)    // C# as
 
-		if cmd != nil && (txt != nil && txt.IsNonWhitespace()) {
+		if cmd != nil || (txt != nil && txt.IsNonWhitespace()) {
 			break
 		} else if txt != nil && txt.IsNewline() {
 			removeWhitespaceFrom = i
@@ -1211,6 +1533,10 @@ func (s *StoryState) PushEvaluationStack(obj Object) {
 
 func (s *StoryState) PopEvaluationStack() Object {
 
+	if len(s._evaluationStack) == 0 {
+		panic(NewStoryException("Tried to pop from an empty evaluation stack"))
+	}
+
 	obj := s._evaluationStack[len(s._evaluationStack)-1]
 	//    evaluationStack.RemoveAt (evaluationStack.Count - 1); C#
 	//s._evaluationStack.RemoveAt(s._evaluationStack.Count() - 1)
@@ -1226,7 +1552,7 @@ func (s *StoryState) PeekEvaluationStack() Object {
 func (s *StoryState) PopEvaluationStackEx(numberOfObjects int) []Object {
 
 	if numberOfObjects > len(s._evaluationStack) {
-		panic("trying to pop too many objects")
+		panic(NewStoryException("Trying to pop too many objects from the evaluation stack"))
 	}
 
 	popped := s._evaluationStack[len(s._evaluationStack)-numberOfObjects:]
@@ -1328,31 +1654,45 @@ func (s *StoryState) SetChosenPath(path *Path, incrementingTurnIndex bool) {
 	}
 }
 
-func (s *StoryState) StartFunctionEvaluationFromGame(funcContainer *Container, arguments ...interface{}) {
+func (s *StoryState) StartFunctionEvaluationFromGame(funcContainer *Container, arguments ...interface{}) error {
+
+	// Check the arguments before pushing, so that a bad argument
+	// doesn't leave the callstack in a half-pushed state.
+	if err := checkEvaluationArguments(arguments); err != nil {
+		return err
+	}
 
 	s.CallStack().Push(FunctionEvaluationFromGame, len(s._evaluationStack), 0)
 	s.CallStack().CurrentElement().CurrentPointer = StartOfPointer(funcContainer)
 
-	s.PassArgumentsToEvaluationStack(arguments...)
+	return s.PassArgumentsToEvaluationStack(arguments...)
 }
 
-func (s *StoryState) PassArgumentsToEvaluationStack(arguments ...interface{}) {
+func (s *StoryState) PassArgumentsToEvaluationStack(arguments ...interface{}) error {
+
+	if err := checkEvaluationArguments(arguments); err != nil {
+		return err
+	}
 
 	// Pass arguments onto the evaluation stack
-	if arguments != nil {
-		for i := 0; i < len(arguments); i++ {
-			_, isInt := arguments[i].(int)
-			_, isFloat := arguments[i].(float64)
-			_, isString := arguments[i].(string)
-			_, isBool := arguments[i].(bool)
-			_, isInkList := arguments[i].(*InkList)
-			if !(isInt || isFloat || isString || isBool || isInkList) {
-				panic("ink arguments when calling EvaluateFunction / ChoosePathStringWithParameters must be int, float, string, bool or InkList. Argument was " + reflect.TypeOf(arguments[i]).Name())
-			}
+	for i := 0; i < len(arguments); i++ {
+		s.PushEvaluationStack(CreateValue(arguments[i]))
+	}
+
+	return nil
+}
+
+func checkEvaluationArguments(arguments []interface{}) error {
 
-			s.PushEvaluationStack(CreateValue(arguments[i]))
+	for i := 0; i < len(arguments); i++ {
+		switch arguments[i].(type) {
+		case int, float64, string, bool, *InkList:
+		default:
+			return fmt.Errorf("ink arguments when calling EvaluateFunction / ChoosePathString must be int, float, string, bool or InkList. Argument was %T", arguments[i])
 		}
 	}
+
+	return nil
 }
 
 func (s *StoryState) TryExitFunctionEvaluationFromGame() bool {
@@ -1366,10 +1706,54 @@ func (s *StoryState) TryExitFunctionEvaluationFromGame() bool {
 	return false
 }
 
-func (s *StoryState) CompleteFunctionEvaluationFromGame() interface{} {
+// gameEvaluation
+// The parts of the state that evaluating a function from the game can
+// break if the function fails: an error ends the story, resetting the
+// callstack and clearing the choices.
+type gameEvaluation struct {
+	threads         []*Thread
+	elements        []*Element
+	previousPointer Pointer
+	evaluationStack []Object
+	choices         []*Choice
+}
 
-	if s.CallStack().CurrentElement().PushPopType() == FunctionEvaluationFromGame {
-		panic("Expected external function evaluation to be complete. Stack trace: " + s.CallStack().CallStackTrace())
+func (s *StoryState) snapshotGameEvaluation() gameEvaluation {
+
+	thread := s.CallStack().CurrentThread()
+
+	return gameEvaluation{
+		threads:         NewSliceFromSlice(s.CallStack()._threads),
+		elements:        NewSliceFromSlice(thread._elements),
+		previousPointer: thread.PreviousPointer,
+		evaluationStack: NewSliceFromSlice(s._evaluationStack),
+		choices:         NewSliceFromSlice(s._currentFlow.CurrentChoices),
+	}
+}
+
+// abandonGameEvaluation
+// Puts the state back as it was before a function evaluation from the
+// game that failed. Its errors have been returned to the game, so they're
+// cleared rather than reported again by the next Continue.
+func (s *StoryState) abandonGameEvaluation(snapshot gameEvaluation) {
+
+	s.CallStack()._threads = snapshot.threads
+
+	thread := s.CallStack().CurrentThread()
+	thread._elements = snapshot.elements
+	thread.PreviousPointer = snapshot.previousPointer
+
+	s._evaluationStack = snapshot.evaluationStack
+	s._currentFlow.CurrentChoices = snapshot.choices
+	s.DidSafeExit = false
+
+	s.ResetErrors()
+}
+
+func (s *StoryState) CompleteFunctionEvaluationFromGame() (interface{}, error) {
+
+	if s.CallStack().CurrentElement().PushPopType() != FunctionEvaluationFromGame {
+		return nil, NewStoryException("Expected external function evaluation to be complete. Stack trace: " + s.CallStack().CallStackTrace())
 	}
 
 	originalEvaluationStackHeight := s.CallStack().CurrentElement().EvaluationStackHeightWhenPushed
@@ -1391,7 +1775,7 @@ func (s *StoryState) CompleteFunctionEvaluationFromGame() interface{} {
 
 	if returnedObj != nil {
 		if _, isVoid := returnedObj.(*Void); isVoid {
-			return nil
+			return nil, nil
 		}
 
 		// Some kind of value, if not void
@@ -1400,22 +1784,22 @@ func (s *StoryState) CompleteFunctionEvaluationFromGame() interface{} {
 		// DivertTargets get returned as the string of components
 		// (rather than a Path, which isn't public)
 		if returnVal.ValueType() == ValueTypeDivertTarget {
-			return returnVal.ValueObject().(fmt.Stringer).String()
+			return returnVal.ValueObject().(fmt.Stringer).String(), nil
 		}
 
 		// Other types can just have their exact object type:
 		// int, float, string. VariablePointers get returned as strings.
-		return returnVal.ValueObject()
+		return returnVal.ValueObject(), nil
 	}
 
-	return nil
+	return nil, nil
 }
 
-func (s *StoryState) AddError(message string, isWarning bool) {
-	if !isWarning {
-		s._currentErrors = append(s._currentErrors, message)
+func (s *StoryState) AddError(err *RuntimeError) {
+	if err.Type == ErrorTypeWarning {
+		s._currentWarnings = append(s._currentWarnings, err)
 	} else {
-		s._currentWarnings = append(s._currentWarnings, message)
+		s._currentErrors = append(s._currentErrors, err)
 	}
 }
 
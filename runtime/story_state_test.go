@@ -0,0 +1,465 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrySplittingHeadTailWhitespace(t *testing.T) {
+
+	split := new(StoryState).TrySplittingHeadTailWhitespace(NewStringValueFromString("\n  Hello  \n  "))
+
+	var texts []string
+	for _, v := range split {
+		texts = append(texts, v.Value())
+	}
+
+	assert.Equal(t, []string{"\n", "  Hello  ", "\n", "  "}, texts)
+}
+
+// Hello {f()}.
+// == function f ==
+// world
+const functionOutputJson = `{"inkVersion":21,"root":[["^Hello ","ev",{"f()":"f"},"out","/ev","^.","\n",["done",{"#n":"g-0"}],null],"done",{"f":["^world","\n",{"#f":1}],"#f":1}],"listDefs":{}}`
+
+// A.
+// B <>
+// C.
+const glueJson = `{"inkVersion":21,"root":[["^A.","\n","^B ","<>","\n","^C.","\n",["done",{"#n":"g-0"}],null],"done",{"#f":1}],"listDefs":{}}`
+
+func TestOutputStreamWhitespace(t *testing.T) {
+
+	for _, test := range []struct {
+		json string
+		text string
+	}{
+		{functionOutputJson, "Hello world.\n"},
+		{glueJson, "A.\nB C.\n"},
+	} {
+		story, err := NewStory(test.json)
+		require.NoError(t, err)
+
+		text, err := story.ContinueMaximally()
+		require.NoError(t, err)
+		assert.Equal(t, test.text, text)
+	}
+}
+
+// Hello # a
+// World # b
+const lineTagsJson = `{"inkVersion":21,"root":[["^Hello ","#","^a","/#","\n","^World ","#","^b","/#","\n","end",["done",{"#n":"g-0"}],null],"done",{"#f":1}],"listDefs":{}}`
+
+func TestCurrentTags(t *testing.T) {
+
+	story, err := NewStory(lineTagsJson)
+	require.NoError(t, err)
+
+	_, err = story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, story.CurrentTags())
+
+	// Rebuilding the tags mustn't keep the ones from before
+	story.State().OutputStreamDirty()
+	assert.Equal(t, []string{"a"}, story.CurrentTags())
+
+	_, err = story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, story.CurrentTags())
+}
+
+func TestCallStackAndChoicesJsonRoundTrip(t *testing.T) {
+
+	b, err := os.ReadFile("../cmd/ink-player/TheIntercept.json")
+	require.NoError(t, err)
+
+	story, err := NewStory(string(b))
+	require.NoError(t, err)
+
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+	require.NotEmpty(t, story.CurrentChoices())
+
+	writer := NewWriter()
+	story.State().CallStack().WriteJson(writer)
+	saved := writer.String()
+
+	jObject, err := TextToDictionary(saved)
+	require.NoError(t, err)
+
+	callStack := NewCallStack(story)
+	callStack.SetJsonToken(jObject, story)
+
+	writer = NewWriter()
+	callStack.WriteJson(writer)
+	assert.Equal(t, saved, writer.String())
+
+	for _, choice := range story.CurrentChoices() {
+
+		writer = NewWriter()
+		WriteChoice(writer, choice)
+
+		jObject, err = TextToDictionary(writer.String())
+		require.NoError(t, err)
+
+		loaded := JObjectToChoice(jObject)
+		assert.Equal(t, choice.Text, loaded.Text)
+		assert.Equal(t, choice.SourcePath, loaded.SourcePath)
+		assert.Equal(t, choice.PathStringOnChoice(), loaded.PathStringOnChoice())
+	}
+}
+
+// playTheIntercept
+// Plays TheIntercept for a few turns, always taking the first choice,
+// so that the state has some history to save.
+func playTheIntercept(t testing.TB, story *Story, turns int) {
+
+	for i := 0; i < turns; i++ {
+		_, err := story.ContinueMaximally()
+		require.NoError(t, err)
+		require.NotEmpty(t, story.CurrentChoices())
+		require.NoError(t, story.ChooseChoiceIndex(0))
+	}
+}
+
+// stateJson
+// Saves the state to JSON, failing the test if it can't be saved.
+func stateJson(t testing.TB, state *StoryState) string {
+	t.Helper()
+
+	json, err := state.ToJson()
+	require.NoError(t, err)
+
+	return json
+}
+
+func TestStoryStateWriteToReadFrom(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 3)
+
+	var buf bytes.Buffer
+	n, err := story.State().WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	loaded := newTestStoryFromFile(t, theInterceptPath)
+	read, err := loaded.State().ReadFrom(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, n, read)
+
+	expected, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	text, err := loaded.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, expected, text)
+	assert.Equal(t, len(story.CurrentChoices()), len(loaded.CurrentChoices()))
+}
+
+func TestStoryStateReadFromToJson(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 2)
+
+	// A save made with ToJson can be streamed back in
+	loaded := newTestStoryFromFile(t, theInterceptPath)
+	_, err := loaded.State().ReadFrom(strings.NewReader(stateJson(t, story.State())))
+	require.NoError(t, err)
+	assert.Equal(t, story.State().CurrentTurnIndex(), loaded.State().CurrentTurnIndex())
+}
+
+func TestStoryStateReadFromInvalid(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+
+	_, err := story.State().ReadFrom(strings.NewReader(`{"flows":`))
+	assert.Error(t, err)
+
+	_, err = story.State().ReadFrom(strings.NewReader(`{}`))
+	assert.ErrorIs(t, err, ErrSaveFormatIncompatible)
+}
+
+func BenchmarkStoryStateToJson(b *testing.B) {
+
+	story := newTestStoryFromFile(b, theInterceptPath)
+	playTheIntercept(b, story, 3)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := story.State().ToJson(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStoryStateWriteTo(b *testing.B) {
+
+	story := newTestStoryFromFile(b, theInterceptPath)
+	playTheIntercept(b, story, 3)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := story.State().WriteTo(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// requireSameState
+// Checks that two states save to the same JSON.
+func requireSameState(t *testing.T, expected *StoryState, actual *StoryState) {
+
+	expectedSave, err := TextToDictionary(stateJson(t, expected))
+	require.NoError(t, err)
+	actualSave, err := TextToDictionary(stateJson(t, actual))
+	require.NoError(t, err)
+
+	require.Equal(t, expectedSave, actualSave)
+}
+
+func TestStoryStateWriteBinaryLoadBinary(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 3)
+
+	var buf bytes.Buffer
+	require.NoError(t, story.State().WriteBinary(&buf))
+	assert.True(t, IsBinarySave(buf.Bytes()))
+	assert.Less(t, buf.Len(), len(stateJson(t, story.State())))
+
+	loaded := newTestStoryFromFile(t, theInterceptPath)
+	require.NoError(t, loaded.State().LoadBinary(buf.Bytes()))
+	requireSameState(t, story.State(), loaded.State())
+
+	expected, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	text, err := loaded.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, expected, text)
+	assert.Equal(t, len(story.CurrentChoices()), len(loaded.CurrentChoices()))
+}
+
+func TestStoryStateWriteBinaryMultiFlow(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/multi_flow.ink.json")
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	require.NoError(t, story.SwitchFlow("banter"))
+	require.NoError(t, story.ChoosePathString("banter", true))
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, story.State().WriteBinary(&buf))
+
+	loaded := newTestStoryFromFile(t, "testdata/conformance/multi_flow.ink.json")
+	require.NoError(t, loaded.State().LoadBinary(buf.Bytes()))
+	requireSameState(t, story.State(), loaded.State())
+	assert.Equal(t, "banter", loaded.State().CurrentFlowName())
+}
+
+func TestStoryStateLoadBinaryInvalid(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 2)
+
+	var buf bytes.Buffer
+	require.NoError(t, story.State().WriteBinary(&buf))
+	save := buf.Bytes()
+
+	// Move on, so that loading any part of the save would show
+	playTheIntercept(t, story, 1)
+	before := stateJson(t, story.State())
+
+	err := story.State().LoadBinary([]byte(before))
+	assert.ErrorIs(t, err, ErrSaveFormatIncompatible)
+
+	// However much of the save is there, a failed load changes nothing
+	for n := len(save) - 1; n > 0; n -= 7 {
+		require.Error(t, story.State().LoadBinary(save[:n]), n)
+		require.Equal(t, before, stateJson(t, story.State()), n)
+	}
+
+	require.NoError(t, story.State().LoadBinary(save))
+	assert.NotEqual(t, before, stateJson(t, story.State()))
+}
+
+func TestStoryStateLoadJsonInvalid(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 2)
+	save := stateJson(t, story.State())
+
+	// Move on, so that loading any part of a save would show
+	playTheIntercept(t, story, 1)
+	before := stateJson(t, story.State())
+
+	// Each field the save can't do without, either missing or mistyped
+	invalid := []string{
+		`{"inkSaveVersion":10}`,
+		`{"inkSaveVersion":10,"flows":{}}`,
+		`{"inkSaveVersion":10,"flows":3}`,
+		`{"inkSaveVersion":10,"flows":{"DEFAULT_FLOW":[]}}`,
+		`{"inkSaveVersion":10,"flows":{"DEFAULT_FLOW":{}}}`,
+		`{"inkSaveVersion":10,"callstackThreads":{}}`,
+		strings.Replace(save, `"variablesState":{`, `"variablesState":[],"x":{`, 1),
+		strings.Replace(save, `"evalStack":[]`, `"evalStack":{}`, 1),
+		strings.Replace(save, `"evalStack":[]`, `"evalStack":[{"bad":1}]`, 1),
+		strings.Replace(save, `"visitCounts":{`, `"visitCounts":{"x":"y",`, 1),
+		strings.Replace(save, `"turnIndices":{`, `"turnIndices":3,"x":{`, 1),
+		strings.Replace(save, `"turnIdx":`, `"turnIdx":"1","x":`, 1),
+		strings.Replace(save, `"storySeed":`, `"x":`, 1),
+		strings.Replace(save, `"threadCounter":`, `"threadCounter":"0","x":`, 1),
+	}
+
+	for _, json := range invalid {
+		require.NotEqual(t, save, json)
+		require.NotPanics(t, func() {
+			assert.Error(t, story.State().LoadJson(json), json)
+		}, json)
+		require.Equal(t, before, stateJson(t, story.State()), json)
+	}
+
+	// More than one flow, but none of them current
+	jObject, err := TextToDictionary(save)
+	require.NoError(t, err)
+	flows := jObject["flows"].(map[string]interface{})
+	flows["other"] = flows[kDefaultFlowName]
+	jObject["currentFlowName"] = "missing"
+	assert.Error(t, story.State().LoadJsonObj(jObject))
+	require.Equal(t, before, stateJson(t, story.State()))
+
+	require.NoError(t, story.State().LoadJson(save))
+	assert.NotEqual(t, before, stateJson(t, story.State()))
+}
+
+func TestSaveMigration(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 2)
+
+	// An imaginary version 7 save, which called turnIdx turnIndex
+	oldSave := stateJson(t, story.State())
+	oldSave = strings.Replace(oldSave, `"inkSaveVersion":10`, `"inkSaveVersion":7`, 1)
+	oldSave = strings.Replace(oldSave, `"turnIdx":`, `"turnIndex":`, 1)
+
+	loaded := newTestStoryFromFile(t, theInterceptPath)
+	err := loaded.State().LoadJson(oldSave)
+	require.ErrorIs(t, err, ErrSaveFormatIncompatible)
+
+	RegisterSaveMigration(7, 8, func(jObject map[string]interface{}) error {
+		jObject["turnIdx"] = jObject["turnIndex"]
+		delete(jObject, "turnIndex")
+		return nil
+	})
+	defer delete(saveMigrations, 7)
+
+	require.NoError(t, loaded.State().LoadJson(oldSave))
+	requireSameState(t, story.State(), loaded.State())
+}
+
+func TestBinarySaveMigration(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+	playTheIntercept(t, story, 2)
+
+	var buf bytes.Buffer
+	require.NoError(t, story.State().WriteBinary(&buf))
+	save := buf.Bytes()
+	save[len(binarySaveIdentifier)] = 9
+
+	loaded := newTestStoryFromFile(t, theInterceptPath)
+	err := loaded.State().LoadBinary(save)
+	require.ErrorIs(t, err, ErrSaveFormatIncompatible)
+
+	migrated := false
+	RegisterBinarySaveMigration(9, 10, func(data []byte) ([]byte, error) {
+		migrated = true
+		return data, nil
+	})
+	defer delete(binarySaveMigrations, 9)
+
+	require.NoError(t, loaded.State().LoadBinary(save))
+	assert.True(t, migrated)
+	requireSameState(t, story.State(), loaded.State())
+
+	failing := errors.New("failed")
+	RegisterBinarySaveMigration(8, 9, func(data []byte) ([]byte, error) {
+		return nil, failing
+	})
+	defer delete(binarySaveMigrations, 8)
+
+	save[len(binarySaveIdentifier)] = 8
+	assert.ErrorIs(t, loaded.State().LoadBinary(save), failing)
+}
+
+func TestRegisterSaveMigrationPanics(t *testing.T) {
+
+	noop := func(map[string]interface{}) error { return nil }
+
+	assert.Panics(t, func() { RegisterSaveMigration(5, 5, noop) })
+	assert.Panics(t, func() { RegisterSaveMigration(5, KInkSaveStateVersion+1, noop) })
+	assert.Panics(t, func() { RegisterSaveMigration(5, 6, nil) })
+
+	RegisterSaveMigration(5, 6, noop)
+	defer delete(saveMigrations, 5)
+	assert.Panics(t, func() { RegisterSaveMigration(5, 7, noop) })
+}
+
+func BenchmarkStoryStateWriteBinary(b *testing.B) {
+
+	story := newTestStoryFromFile(b, theInterceptPath)
+	playTheIntercept(b, story, 3)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := story.State().WriteBinary(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStoryStateSavesChangedListGlobal(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/lists.ink.json")
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+
+	// Only (green) is the default, so the change has to be saved
+	loaded := newTestStoryFromFile(t, "testdata/conformance/lists.ink.json")
+	require.NoError(t, loaded.State().LoadJson(stateJson(t, story.State())))
+
+	colours, ok := loaded.VariablesState().GetVariable("colours").(*InkList)
+	require.True(t, ok)
+	assert.Equal(t, "blue", colours.String())
+}
+
+func TestStoryStateToJsonCanonical(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/multi_flow.ink.json")
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	require.NoError(t, story.SwitchFlow("banter"))
+	require.NoError(t, story.ChoosePathString("banter", true))
+	_, err = story.ContinueMaximally()
+	require.NoError(t, err)
+
+	save := stateJson(t, story.State())
+	for i := 0; i < 5; i++ {
+		require.Equal(t, save, stateJson(t, story.State()))
+	}
+
+	// Loading and saving again gives back exactly the same save
+	loaded := newTestStoryFromFile(t, "testdata/conformance/multi_flow.ink.json")
+	require.NoError(t, loaded.State().LoadJson(save))
+	assert.Equal(t, save, stateJson(t, loaded.State()))
+}
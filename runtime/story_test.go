@@ -0,0 +1,960 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// VAR x = 1
+// ~ temp s = "a{x}b"
+// {s}
+// Hello #t{x}c
+const stringAndTagEvaluationJson = `{"inkVersion":21,"root":[["ev","str","^a","ev",{"VAR?":"x"},"out","/ev","^b","/str","/ev",{"temp=":"s"},"ev",{"VAR?":"s"},"out","/ev","\n","^Hello ","#","^t","ev",{"VAR?":"x"},"out","/ev","^c","/#","\n","end",["done",{"#n":"g-0"}],null],"done",{"global decl":["ev",1,{"VAR=":"x"},"/ev","end",null],"#f":1}],"listDefs":{}}`
+
+func TestStringAndTagEvaluationOrder(t *testing.T) {
+
+	story, err := NewStory(stringAndTagEvaluationJson)
+	require.NoError(t, err)
+
+	text, err := story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, "a1b\n", text)
+
+	text, err = story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello\n", text)
+	assert.Equal(t, []string{"t1c"}, story.CurrentTags())
+}
+
+// == function add(a, b) ==
+// ~ return a + b
+const addFunctionJson = `{"inkVersion":21,"root":[[["done",{"#n":"g-0"}],null],"done",{"add":[{"temp=":"b"},{"temp=":"a"},"ev",{"VAR?":"a"},{"VAR?":"b"},"+","/ev","~ret",{"#f":1}],"#f":1}],"listDefs":{}}`
+
+func TestEvaluateFunction(t *testing.T) {
+
+	story, err := NewStory(addFunctionJson)
+	require.NoError(t, err)
+
+	_, result, err := story.EvaluateFunction("add", 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result)
+}
+
+// EXTERNAL sub(a, b)
+// {sub(5, 2)}
+const externalSubJson = `{"inkVersion":21,"root":[["ev",5,2,{"x()":"sub","exArgs":2},"out","/ev","\n","end",["done",{"#n":"g-0"}],null],"done",{"#f":1}],"listDefs":{}}`
+
+func TestCallExternalFunction(t *testing.T) {
+
+	story, err := NewStory(externalSubJson)
+	require.NoError(t, err)
+
+	// Missing bindings are reported before the story runs
+	assert.Error(t, story.ValidateExternalBindings())
+
+	err = story.BindExternalFunctionalGeneral("sub", func(args []interface{}) interface{} {
+		return args[0].(int) - args[1].(int)
+	}, true)
+	require.NoError(t, err)
+	require.NoError(t, story.ValidateExternalBindings())
+
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "3\n", text)
+}
+
+const theInterceptPath = "../cmd/ink-player/TheIntercept.json"
+
+// taggedStoryJson
+// Compiled from:
+//
+//	# author: Joe
+//	# title: Tags
+//	-> knot
+//	== knot ==
+//	# bg: forest
+//	# music: calm
+//	Hello
+//	-> knot.stitch
+//	= stitch
+//	# stitch_tag
+//	World
+//	-> END
+const taggedStoryJson = `{"inkVersion":21,"root":[["#","^author: Joe","/#","#","^title: Tags","/#",{"->":"knot"},["done",{"#n":"g-0"}],null],"done",{"knot":[["#","^bg: forest","/#","#","^music: calm","/#","^Hello","\n",{"->":".^.^.stitch"},null],{"stitch":[["#","^stitch_tag","/#","^World","\n","end",null],null],"#f":1}]}],"listDefs":{}}`
+
+func newTestStory(t testing.TB, jsonString string) *Story {
+	t.Helper()
+
+	story, err := NewStory(jsonString)
+	require.NoError(t, err)
+
+	return story
+}
+
+func newTestStoryFromFile(t testing.TB, path string) *Story {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return newTestStory(t, string(b))
+}
+
+func TestGlobalTags(t *testing.T) {
+
+	story := newTestStory(t, taggedStoryJson)
+
+	tags, err := story.GlobalTags()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"author: Joe", "title: Tags"}, tags)
+}
+
+func TestTagsForContentAtPath(t *testing.T) {
+
+	story := newTestStory(t, taggedStoryJson)
+
+	tests := []struct {
+		path string
+		tags []string
+	}{
+		{"knot", []string{"bg: forest", "music: calm"}},
+		{"knot.stitch", []string{"stitch_tag"}},
+	}
+
+	for _, test := range tests {
+		tags, err := story.TagsForContentAtPath(test.path)
+		require.NoError(t, err, test.path)
+		assert.Equal(t, test.tags, tags, test.path)
+	}
+}
+
+func TestTagsDoNotChangeStoryOutput(t *testing.T) {
+
+	story := newTestStory(t, taggedStoryJson)
+
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello\nWorld\n", text)
+	assert.Equal(t, []string{"stitch_tag"}, story.CurrentTags())
+}
+
+func TestTagsForContentAtPathTheIntercept(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+
+	// TheIntercept doesn't declare any tags, so every knot and stitch
+	// resolves, but has none.
+	globalTags, err := story.GlobalTags()
+	require.NoError(t, err)
+	assert.Empty(t, globalTags)
+
+	for _, path := range []string{"start", "harris_demands_component", "slam_door_shut_and_gone.time_to_move_now"} {
+		tags, err := story.TagsForContentAtPath(path)
+		require.NoError(t, err, path)
+		assert.Empty(t, tags, path)
+	}
+}
+
+func TestTagsForContentAtPathNotFound(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+
+	for _, path := range []string{"no_such_knot", "start.no_such_stitch"} {
+		_, err := story.TagsForContentAtPath(path)
+		var storyException *StoryException
+		assert.ErrorAs(t, err, &storyException, path)
+	}
+}
+
+func TestLoadStory(t *testing.T) {
+
+	f, err := os.Open(theInterceptPath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	streamed, err := LoadStory(f)
+	require.NoError(t, err)
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+
+	// Both stories should play out identically
+	for turn := 0; turn < 5; turn++ {
+		expected, err := story.ContinueMaximally()
+		require.NoError(t, err)
+		text, err := streamed.ContinueMaximally()
+		require.NoError(t, err)
+		assert.Equal(t, expected, text)
+
+		require.Equal(t, len(story.CurrentChoices()), len(streamed.CurrentChoices()))
+		require.NotEmpty(t, story.CurrentChoices())
+		require.NoError(t, story.ChooseChoiceIndex(0))
+		require.NoError(t, streamed.ChooseChoiceIndex(0))
+	}
+}
+
+func TestLoadStoryInvalidJson(t *testing.T) {
+
+	for _, json := range []string{"", "{", `{"inkVersion":21,"root":[`, "[]"} {
+		_, err := LoadStory(strings.NewReader(json))
+		assert.Error(t, err, json)
+	}
+}
+
+func TestLoadStoryByteOrderMark(t *testing.T) {
+
+	story, err := LoadStory(strings.NewReader("\uFEFF" + taggedStoryJson))
+	require.NoError(t, err)
+
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello\nWorld\n", text)
+}
+
+func TestLoadStoryReadError(t *testing.T) {
+
+	readErr := errors.New("disk on fire")
+	r := io.MultiReader(strings.NewReader(taggedStoryJson[:40]), iotest.ErrReader(readErr))
+
+	_, err := LoadStory(r)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), readErr.Error())
+}
+
+func TestStoryToJsonRoundTrip(t *testing.T) {
+
+	b, err := os.ReadFile(theInterceptPath)
+	require.NoError(t, err)
+
+	story := newTestStory(t, string(b))
+	json := story.ToJson()
+
+	// Other than the version it was written with, the story comes out as
+	// it was compiled
+	expected, err := TextToDictionary(string(b))
+	require.NoError(t, err)
+	actual, err := TextToDictionary(json)
+	require.NoError(t, err)
+
+	delete(expected, "inkVersion")
+	delete(actual, "inkVersion")
+	assert.Equal(t, expected, actual)
+
+	assert.Equal(t, json, newTestStory(t, json).ToJson())
+
+	// Whole floats are written so that they're read back as floats
+	floats := `{"inkVersion":21,"root":[["ev",2.0,1.5,"+","out","/ev","\n",["done",{"#n":"g-0"}],null],"done",{"global decl":["ev",3.0,{"VAR=":"f"},"/ev","end",null]}],"listDefs":{}}`
+	story = newTestStory(t, floats)
+	json = story.ToJson()
+
+	expected, err = TextToDictionary(floats)
+	require.NoError(t, err)
+	actual, err = TextToDictionary(json)
+	require.NoError(t, err)
+
+	delete(expected, "inkVersion")
+	delete(actual, "inkVersion")
+	assert.Equal(t, expected, actual)
+
+	text, err := newTestStory(t, json).ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "3.5\n", text)
+}
+
+func TestStoryToJsonCanonical(t *testing.T) {
+
+	paths, err := filepath.Glob("testdata/conformance/*.ink.json")
+	require.NoError(t, err)
+
+	for _, path := range append(paths, theInterceptPath) {
+
+		story := newTestStoryFromFile(t, path)
+		json := story.ToJson()
+
+		// Writing is deterministic, and is a fixed point once loaded
+		for i := 0; i < 5; i++ {
+			require.Equal(t, json, story.ToJson(), path)
+		}
+		assert.Equal(t, json, newTestStory(t, json).ToJson(), path)
+	}
+}
+
+// TestOnDidStepPausesContinueContext
+// Checks that a line paused from OnDidStep carries on where it left off.
+func TestOnDidStepPausesContinueContext(t *testing.T) {
+
+	story := newTestStory(t, taggedStoryJson)
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	require.NoError(t, story.ChoosePathString("knot", true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	steps := 0
+	story.OnDidStep = new(ActionEvent)
+	story.OnDidStep.Register(func() {
+		steps++
+		if steps == 3 {
+			cancel()
+		}
+	})
+
+	_, err = story.ContinueContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 3, steps)
+	assert.True(t, story.CanContinue())
+
+	text, err := story.ContinueContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "Hello\n", text)
+	assert.Equal(t, []string{"bg: forest", "music: calm"}, story.CurrentTags())
+	assert.Greater(t, steps, 3)
+}
+
+func TestContinueContextCancelledBeforeStart(t *testing.T) {
+
+	story := newTestStory(t, taggedStoryJson)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := story.ContinueContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, story.CanContinue())
+
+	text, err := story.ContinueContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "Hello\n", text)
+}
+
+// blockInStep
+// Starts call on another goroutine and waits until it's stepping through
+// the story, where it's held until the function returned is called. That
+// function then returns the call's error.
+func blockInStep(story *Story, call func() error) (finish func() error) {
+
+	stepping := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	story.OnDidStep = new(ActionEvent)
+	story.OnDidStep.Register(func() {
+		once.Do(func() {
+			close(stepping)
+			<-release
+		})
+	})
+
+	done := make(chan error)
+	go func() { done <- call() }()
+	<-stepping
+
+	return func() error {
+		close(release)
+		return <-done
+	}
+}
+
+// TestConcurrentUseFails
+// Checks that whatever changes a story fails while another goroutine is
+// continuing it, and leaves that Continue unharmed.
+func TestConcurrentUseFails(t *testing.T) {
+
+	story := newTestStory(t, taggedStoryJson)
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	require.NoError(t, story.ChoosePathString("knot", true))
+
+	jsonSave := stateJson(t, story.State())
+	binarySave, err := story.State().MarshalBinary()
+	require.NoError(t, err)
+
+	var text string
+	finish := blockInStep(story, func() (err error) {
+		text, err = story.Continue()
+		return err
+	})
+
+	_, err = story.Continue()
+	assert.ErrorIs(t, err, ErrConcurrentContinue)
+	_, err = story.ContinueContext(context.Background())
+	assert.ErrorIs(t, err, ErrConcurrentContinue)
+	assert.ErrorIs(t, story.ChooseChoiceIndex(0), ErrConcurrentContinue)
+	assert.ErrorIs(t, story.ChoosePathString("knot.stitch", true), ErrConcurrentContinue)
+	evaluated := false
+	story.OnEvaluateFunction = new(OnEvaluateFunctionEvent)
+	story.OnEvaluateFunction.Register(func(string, []interface{}) { evaluated = true })
+	_, _, err = story.EvaluateFunction("knot")
+	assert.ErrorIs(t, err, ErrConcurrentContinue)
+	assert.False(t, evaluated, "OnEvaluateFunction fired for a call that failed")
+	assert.ErrorIs(t, story.SwitchFlow("other"), ErrConcurrentContinue)
+	_, err = story.CopyStateForBackgroundThreadSave()
+	assert.ErrorIs(t, err, ErrConcurrentContinue)
+	assert.ErrorIs(t, story.BackgroundSaveComplete(), ErrConcurrentContinue)
+	assert.ErrorIs(t, story.ResetState(), ErrConcurrentContinue)
+	assert.ErrorIs(t, story.ResetGlobals(), ErrConcurrentContinue)
+	assert.ErrorIs(t, story.State().LoadJson(jsonSave), ErrConcurrentContinue)
+	assert.ErrorIs(t, story.State().LoadBinary(binarySave), ErrConcurrentContinue)
+	assert.ErrorIs(t, story.VariablesState().Set("x", 1), ErrConcurrentContinue)
+
+	assert.ErrorIs(t, BindExternalFunction0(story, "roll", func() int { return 4 }, true), ErrConcurrentContinue)
+	assert.ErrorIs(t, story.BindExternals(&struct{ Roll func() int }{}), ErrConcurrentContinue)
+	assert.ErrorIs(t, story.UnbindExternalFunction("roll"), ErrConcurrentContinue)
+	_, err = story.ObserveVariable("x", func(string, interface{}) {})
+	assert.ErrorIs(t, err, ErrConcurrentContinue)
+	assert.ErrorIs(t, story.RemoveVariableObservers(""), ErrConcurrentContinue)
+
+	_, err = story.State().ToJson()
+	assert.ErrorIs(t, err, ErrConcurrentContinue)
+	_, err = story.State().WriteTo(io.Discard)
+	assert.ErrorIs(t, err, ErrConcurrentContinue)
+	assert.ErrorIs(t, story.State().WriteBinary(io.Discard), ErrConcurrentContinue)
+
+	require.NoError(t, finish())
+	assert.Equal(t, "Hello\n", text)
+
+	// Once it's returned, the story can be used again
+	text, err = story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, "World\n", text)
+	require.NoError(t, story.State().LoadJson(jsonSave))
+}
+
+// TestBackgroundSaveWhileBusy
+// The state frozen by CopyStateForBackgroundThreadSave can be saved while
+// the story goes on, which is what it's for.
+func TestBackgroundSaveWhileBusy(t *testing.T) {
+
+	story := newTestStory(t, taggedStoryJson)
+	before := stateJson(t, story.State())
+
+	stateToSave, err := story.CopyStateForBackgroundThreadSave()
+	require.NoError(t, err)
+
+	finish := blockInStep(story, func() error {
+		_, err := story.Continue()
+		return err
+	})
+
+	assert.Equal(t, before, stateJson(t, stateToSave))
+	var buf bytes.Buffer
+	assert.NoError(t, stateToSave.WriteBinary(&buf))
+
+	_, err = story.State().ToJson()
+	assert.ErrorIs(t, err, ErrConcurrentContinue)
+
+	require.NoError(t, finish())
+	require.NoError(t, story.BackgroundSaveComplete())
+	assert.NotEqual(t, before, stateJson(t, story.State()))
+}
+
+// TestExternalFunctionCallsStory
+// An external function may call back into the story that's running it.
+func TestExternalFunctionCallsStory(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/externals.ink.json")
+	story.AllowExternalFunctionFallbacks = true
+	require.NoError(t, BindExternalFunction2(story, "multiply", func(a, b int) int { return a * b }, true))
+	require.NoError(t, BindExternalFunction0(story, "greeting", func() string {
+
+		_, result, err := story.EvaluateFunction("missing", 1)
+		require.NoError(t, err)
+		return fmt.Sprint("Hello from ", result)
+	}, false))
+
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Product is 42.\nHello from fallback\nFallback gives fallback.\n", text)
+}
+
+// TestExternalFunctionCallsBackOneAtATime
+// While an external function is running, one call at a time may be made
+// back into the story. A second call while the first is still running
+// fails, as it would outside the function.
+func TestExternalFunctionCallsBackOneAtATime(t *testing.T) {
+
+	story := newTestStoryFromFile(t, "testdata/conformance/externals.ink.json")
+	story.AllowExternalFunctionFallbacks = true
+	require.NoError(t, BindExternalFunction2(story, "multiply", func(a, b int) int { return a * b }, true))
+
+	var whileBusy, fromOther error
+	require.NoError(t, BindExternalFunction0(story, "greeting", func() string {
+
+		finish := blockInStep(story, func() error {
+			_, _, err := story.EvaluateFunction("missing", 1)
+			return err
+		})
+		_, _, whileBusy = story.EvaluateFunction("missing", 1)
+		fromOther = finish()
+		story.OnDidStep = nil
+
+		_, result, err := story.EvaluateFunction("missing", 1)
+		require.NoError(t, err)
+		return fmt.Sprint("Hello from ", result)
+	}, false))
+
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.ErrorIs(t, whileBusy, ErrConcurrentContinue)
+	assert.NoError(t, fromOther)
+	assert.Equal(t, "Product is 42.\nHello from fallback\nFallback gives fallback.\n", text)
+}
+
+// callExternal
+// Calls a bound EXTERNAL function as ink would, returning the ink error
+// it reports, if any.
+func callExternal(story *Story, funcName string, args ...interface{}) (result interface{}, err error) {
+
+	defer recoverStoryException(&err)
+
+	return story._externals[funcName].function(args), nil
+}
+
+func TestBindExternalFunctionArities(t *testing.T) {
+
+	story := newTestStory(t, taggedStoryJson)
+
+	var acted []interface{}
+	require.NoError(t, BindExternalAction0(story, "a0", func() { acted = []interface{}{} }, false))
+	require.NoError(t, BindExternalAction1(story, "a1", func(a int) { acted = []interface{}{a} }, false))
+	require.NoError(t, BindExternalAction2(story, "a2", func(a int, b string) { acted = []interface{}{a, b} }, false))
+	require.NoError(t, BindExternalAction3(story, "a3", func(a int, b string, c bool) { acted = []interface{}{a, b, c} }, false))
+	require.NoError(t, BindExternalAction4(story, "a4", func(a int, b string, c bool, d float64) { acted = []interface{}{a, b, c, d} }, false))
+	require.NoError(t, BindExternalFunction0(story, "f0", func() int { return 0 }, false))
+	require.NoError(t, BindExternalFunction1(story, "f1", func(a int) int { return a }, false))
+	require.NoError(t, BindExternalFunction2(story, "f2", func(a, b int) int { return a + b }, false))
+	require.NoError(t, BindExternalFunction3(story, "f3", func(a, b, c int) int { return a + b + c }, false))
+	require.NoError(t, BindExternalFunction4(story, "f4", func(a, b, c, d int) int { return a + b + c + d }, true))
+
+	tests := []struct {
+		name   string
+		args   []interface{}
+		result interface{}
+		acted  []interface{}
+		err    string
+	}{
+		{name: "a0", acted: []interface{}{}},
+		{name: "a1", args: []interface{}{1.6}, acted: []interface{}{2}},
+		{name: "a2", args: []interface{}{true, "b"}, acted: []interface{}{1, "b"}},
+		{name: "a3", args: []interface{}{1, "b", 0}, acted: []interface{}{1, "b", false}},
+		{name: "a4", args: []interface{}{1, "b", 2, 3}, acted: []interface{}{1, "b", true, 3.0}},
+		{name: "f0", result: 0},
+		{name: "f1", args: []interface{}{1}, result: 1},
+		{name: "f2", args: []interface{}{1, 2}, result: 3},
+		{name: "f3", args: []interface{}{1, 2, 3}, result: 6},
+		{name: "f4", args: []interface{}{1, 2, 3, 4}, result: 10},
+
+		// The wrong number of arguments
+		{name: "a0", args: []interface{}{1}, err: "EXTERNAL function 'a0' expected 0 argument(s) but got 1"},
+		{name: "a2", args: []interface{}{1}, err: "EXTERNAL function 'a2' expected 2 argument(s) but got 1"},
+		{name: "f1", args: []interface{}{}, err: "EXTERNAL function 'f1' expected 1 argument(s) but got 0"},
+		{name: "f4", args: []interface{}{1, 2, 3, 4, 5}, err: "EXTERNAL function 'f4' expected 4 argument(s) but got 5"},
+
+		// Arguments that can't be converted
+		{name: "a1", args: []interface{}{"one"}, err: "EXTERNAL function 'a1' argument 1: failed to cast string to int"},
+		{name: "a2", args: []interface{}{1, 2}, err: "EXTERNAL function 'a2' argument 2: failed to cast int to string"},
+		{name: "a3", args: []interface{}{1, "b", "c"}, err: "EXTERNAL function 'a3' argument 3: failed to cast string to bool"},
+		{name: "a4", args: []interface{}{1, "b", true, "d"}, err: "EXTERNAL function 'a4' argument 4: failed to cast string to float64"},
+		{name: "f3", args: []interface{}{1, 2, NewInkList()}, err: "EXTERNAL function 'f3' argument 3: failed to cast *runtime.InkList to int"},
+	}
+
+	for _, test := range tests {
+		acted = nil
+
+		result, err := callExternal(story, test.name, test.args...)
+		if test.err != "" {
+			require.Error(t, err, test.name)
+			assert.Contains(t, err.Error(), test.err)
+			continue
+		}
+
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.result, result, test.name)
+		assert.Equal(t, test.acted, acted, test.name)
+	}
+
+	assert.True(t, story._externals["f4"].lookaheadSafe)
+	assert.False(t, story._externals["f3"].lookaheadSafe)
+}
+
+func TestBindExternalFunctionTwice(t *testing.T) {
+
+	story := newTestStory(t, taggedStoryJson)
+	require.NoError(t, BindExternalFunction1(story, "double", func(a int) int { return a * 2 }, false))
+
+	err := BindExternalFunction1(story, "double", func(a int) int { return a + a }, false)
+	assert.EqualError(t, err, "function 'double' has already been bound")
+	err = BindExternalAction0(story, "double", func() {}, false)
+	assert.EqualError(t, err, "function 'double' has already been bound")
+
+	// The first binding is kept
+	result, err := callExternal(story, "double", 3)
+	require.NoError(t, err)
+	assert.Equal(t, 6, result)
+
+	err = BindExternalFunction0[int](story, "nothing", nil, false)
+	assert.EqualError(t, err, "can't bind a nil function to 'nothing'")
+}
+
+func TestUnbindExternalFunction(t *testing.T) {
+
+	assert.EqualError(t, newTestStory(t, taggedStoryJson).UnbindExternalFunction("multiply"), "function 'multiply' has not been bound")
+
+	bind := func(t *testing.T, fallbacks bool) *Story {
+
+		story := newTestStoryFromFile(t, "testdata/conformance/externals.ink.json")
+		story.AllowExternalFunctionFallbacks = fallbacks
+		require.NoError(t, BindExternalFunction2(story, "multiply", func(a, b int) int { return a * b }, false))
+		require.NoError(t, BindExternalFunction0(story, "greeting", func() string { return "Hi" }, false))
+		require.NoError(t, BindExternalFunction1(story, "missing", func(x int) string { return "bound" }, false))
+
+		text, err := story.Continue()
+		require.NoError(t, err)
+		require.Equal(t, "Product is 42.\n", text)
+
+		return story
+	}
+
+	// Without fallbacks, calling it after it's unbound is an error
+	story := bind(t, false)
+	require.NoError(t, story.UnbindExternalFunction("missing"))
+	_, err := story.ContinueMaximally()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Trying to call EXTERNAL function 'missing' which has not been bound (and ink fallbacks disabled).")
+
+	// With them, the ink fallback is used
+	story = bind(t, true)
+	require.NoError(t, story.UnbindExternalFunction("missing"))
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Hi\nFallback gives fallback.\n", text)
+
+	// It can then be bound again
+	require.NoError(t, BindExternalFunction1(story, "missing", func(x int) string { return "bound" }, false))
+}
+
+const multiFlowPath = "testdata/conformance/multi_flow.ink.json"
+
+// recordFlowSwitches
+// Records each OnFlowSwitched event as "previous -> current".
+func recordFlowSwitches(story *Story) *[]string {
+
+	var switches []string
+	story.OnFlowSwitched = new(OnFlowSwitchedEvent)
+	story.OnFlowSwitched.Register(func(previousFlowName string, flowName string) {
+		switches = append(switches, previousFlowName+" -> "+flowName)
+	})
+
+	return &switches
+}
+
+func TestOnFlowSwitched(t *testing.T) {
+
+	story := newTestStoryFromFile(t, multiFlowPath)
+	switches := recordFlowSwitches(story)
+
+	require.NoError(t, story.SwitchFlow("banter"))
+	require.NoError(t, story.SwitchFlow("banter"))
+	require.NoError(t, story.SwitchFlow("aside"))
+	require.NoError(t, story.SwitchToDefaultFlow())
+	require.NoError(t, story.SwitchToDefaultFlow())
+	require.NoError(t, story.SwitchFlow("aside"))
+
+	// Removing a flow that isn't current doesn't switch
+	require.NoError(t, story.RemoveFlow("banter"))
+	require.NoError(t, story.RemoveFlow("aside"))
+
+	assert.Equal(t, []string{
+		"DEFAULT_FLOW -> banter",
+		"banter -> aside",
+		"aside -> DEFAULT_FLOW",
+		"DEFAULT_FLOW -> aside",
+		"aside -> DEFAULT_FLOW",
+	}, *switches)
+}
+
+// TestOnFlowSwitchedCallsStory
+// An OnFlowSwitched handler may call back into the story, such as to
+// start the flow it's switched to.
+func TestOnFlowSwitchedCallsStory(t *testing.T) {
+
+	story := newTestStoryFromFile(t, multiFlowPath)
+
+	var saves []string
+	story.OnFlowSwitched = new(OnFlowSwitchedEvent)
+	story.OnFlowSwitched.Register(func(previousFlowName string, flowName string) {
+		if flowName == "banter" {
+			require.NoError(t, story.ChoosePathString("banter", true))
+		}
+		saves = append(saves, stateJson(t, story.State()))
+	})
+
+	require.NoError(t, story.SwitchFlow("banter"))
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Banter flow.\n", text)
+
+	require.NoError(t, story.SwitchToDefaultFlow())
+	assert.Len(t, saves, 2)
+}
+
+func TestRemoveFlow(t *testing.T) {
+
+	story := newTestStoryFromFile(t, multiFlowPath)
+	switches := recordFlowSwitches(story)
+
+	// The default flow can never be removed, whether or not it's current
+	assert.EqualError(t, story.RemoveFlow(kDefaultFlowName), "cannot destroy default flow")
+	assert.Error(t, story.RemoveFlow(""))
+
+	require.NoError(t, story.SwitchFlow("banter"))
+	require.NoError(t, story.ChoosePathString("banter", true))
+	text, err := story.ContinueMaximally()
+	require.NoError(t, err)
+	require.Equal(t, "Banter flow.\n", text)
+
+	assert.EqualError(t, story.RemoveFlow(kDefaultFlowName), "cannot destroy default flow")
+	assert.Equal(t, "banter", story.CurrentFlowName())
+
+	// Removing the current flow goes back to the default one
+	require.NoError(t, story.RemoveFlow("banter"))
+	assert.Equal(t, kDefaultFlowName, story.CurrentFlowName())
+	assert.True(t, story.CurrentFlowIsDefaultFlow())
+	assert.Empty(t, story.AliveFlowNames())
+	assert.Equal(t, []string{"DEFAULT_FLOW -> banter", "banter -> DEFAULT_FLOW"}, *switches)
+
+	// Which is where it was left
+	text, err = story.ContinueMaximally()
+	require.NoError(t, err)
+	assert.Equal(t, "Main flow.\n", text)
+
+	// Nothing of the removed flow is kept
+	require.NoError(t, story.SwitchFlow("banter"))
+	assert.False(t, story.CanContinue())
+	assert.Empty(t, story.CurrentChoices())
+}
+
+func TestSwitchToDefaultFlowFromRemovedFlow(t *testing.T) {
+
+	story := newTestStoryFromFile(t, multiFlowPath)
+	_, err := story.ContinueMaximally()
+	require.NoError(t, err)
+
+	require.NoError(t, story.SwitchFlow("banter"))
+	require.NoError(t, story.RemoveFlow("banter"))
+	switches := recordFlowSwitches(story)
+
+	// Already back in the default flow, so there's nothing to switch
+	require.NoError(t, story.SwitchToDefaultFlow())
+	assert.Equal(t, kDefaultFlowName, story.CurrentFlowName())
+	assert.Empty(t, *switches)
+
+	require.Len(t, story.CurrentChoices(), 1)
+	assert.Equal(t, "Main choice", story.CurrentChoices()[0].Text)
+}
+
+func TestAliveFlowNames(t *testing.T) {
+
+	story := newTestStoryFromFile(t, multiFlowPath)
+	assert.Empty(t, story.AliveFlowNames())
+
+	for _, name := range []string{"zebra", "apple", "mango", "banana"} {
+		require.NoError(t, story.SwitchFlow(name))
+	}
+	require.NoError(t, story.SwitchToDefaultFlow())
+
+	// Sorted, whatever order they were made in, and without the default
+	assert.Equal(t, []string{"apple", "banana", "mango", "zebra"}, story.AliveFlowNames())
+
+	require.NoError(t, story.RemoveFlow("mango"))
+	names := story.AliveFlowNames()
+	assert.Equal(t, []string{"apple", "banana", "zebra"}, names)
+
+	// The names returned are the caller's to change
+	names[0] = "changed"
+	assert.Equal(t, []string{"apple", "banana", "zebra"}, story.AliveFlowNames())
+}
+
+func TestGlobalVariableNames(t *testing.T) {
+
+	story := newTestStoryFromFile(t, theInterceptPath)
+
+	names := story.State().VariablesState().GlobalVariableNames()
+	assert.Contains(t, names, "forceful")
+	assert.Contains(t, names, "teacup")
+	assert.IsIncreasing(t, names)
+}
+
+// BenchmarkLoadStory
+// Loads TheIntercept from its file both ways: reading the whole file into
+// a string for NewStory, as was needed before LoadStory, and streaming it
+// with LoadStory. The difference is the memory the string takes.
+func BenchmarkLoadStory(b *testing.B) {
+
+	b.Run("string", func(b *testing.B) {
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			jsonBytes, err := os.ReadFile(theInterceptPath)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := NewStory(string(jsonBytes)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reader", func(b *testing.B) {
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			f, err := os.Open(theInterceptPath)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = LoadStory(f)
+			f.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// FuzzNewStory
+// Malformed stories must be rejected with an error, never a panic.
+//
+//	go test -run '^$' -fuzz FuzzNewStory ./runtime
+func FuzzNewStory(f *testing.F) {
+
+	f.Add(taggedStoryJson)
+	for _, path := range []string{"testdata/conformance/glue.ink.json", "testdata/conformance/tunnels.ink.json"} {
+		b, err := os.ReadFile(path)
+		require.NoError(f, err)
+		f.Add(string(b))
+	}
+	f.Add(`{"inkVersion":21,"root":[{"->":""}],"listDefs":{"a":{"b":"c"}}}`)
+	f.Add(`{"inkVersion":21,"root":["\ud83d\ude00",{"VAR=":"x","re":true}]}`)
+
+	f.Fuzz(func(t *testing.T, json string) {
+		_, _ = NewStory(json)
+	})
+}
+
+// failingFunctionStoryJson
+// Compiled from:
+//
+//	Before.
+//	Middle.
+//	-> END
+//	== function broken ==
+//	Partial
+//	~ temp r = RANDOM(5, 1)
+//	~ return r
+const failingFunctionStoryJson = `{"inkVersion":21,"root":[["^Before.","\n","^Middle.","\n","end",["done",{"#n":"g-0"}],null],"done",{"broken":["^Partial","\n","ev",5,1,"rnd","/ev",{"temp=":"r"},"ev",{"VAR?":"r"},"/ev","~ret",{"#f":1}],"#f":1}],"listDefs":{}}`
+
+func TestEvaluateFunctionErrorRestoresStory(t *testing.T) {
+
+	story := newTestStory(t, failingFunctionStoryJson)
+
+	text, err := story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, "Before.\n", text)
+
+	_, _, err = story.EvaluateFunction("broken")
+	assert.ErrorContains(t, err, "RANDOM was called with minimum as 5 and maximum as 1")
+
+	// The story is where it was, with its output, and carries on
+	assert.Equal(t, "Before.\n", story.CurrentText())
+	assert.Empty(t, story.CurrentErrors())
+	require.True(t, story.CanContinue())
+
+	text, err = story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, "Middle.\n", text)
+	assert.False(t, story.CanContinue())
+}
+
+// observedStoryJson
+// Compiled from:
+//
+//	VAR x = 0
+//	VAR y = 0
+//	~ x = 1
+//	~ x = 2
+//	~ y = 5
+//	Line.
+//	~ x = 0
+//	Done.
+//	-> END
+const observedStoryJson = `{"inkVersion":21,"root":[["ev",1,"/ev",{"VAR=":"x","re":true},"ev",2,"/ev",{"VAR=":"x","re":true},"ev",5,"/ev",{"VAR=":"y","re":true},"^Line.","\n","ev",0,"/ev",{"VAR=":"x","re":true},"^Done.","\n","end",["done",{"#n":"g-0"}],null],"done",{"global decl":["ev",0,{"VAR=":"x"},0,{"VAR=":"y"},"/ev","end",null],"#f":1}],"listDefs":{}}`
+
+func TestObserveVariablesBatched(t *testing.T) {
+
+	story := newTestStory(t, observedStoryJson)
+
+	var changes []string
+	_, err := story.ObserveVariables([]string{"x", "y"}, func(variableName string, newValue interface{}) {
+		changes = append(changes, fmt.Sprint(variableName, "=", newValue))
+	})
+	require.NoError(t, err)
+
+	// Each variable is reported once, with its value at the end of the line
+	_, err = story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x=2", "y=5"}, changes)
+
+	changes = nil
+	_, err = story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x=0"}, changes)
+
+	// Outside of Continue, changes are reported straight away
+	changes = nil
+	require.NoError(t, story.VariablesState().Set("y", 7))
+	assert.Equal(t, []string{"y=7"}, changes)
+
+	_, err = story.ObserveVariable("z", func(string, interface{}) {})
+	assert.Error(t, err)
+}
+
+func TestRemoveVariableObserver(t *testing.T) {
+
+	story := newTestStory(t, observedStoryJson)
+
+	// Closures from the same function literal are told apart
+	var calls []int
+	observe := func(id int) func() {
+		remove, err := story.ObserveVariable("x", func(string, interface{}) { calls = append(calls, id) })
+		require.NoError(t, err)
+		return remove
+	}
+
+	removeFirst := observe(1)
+	observe(2)
+	observe(3)
+
+	removeFirst()
+	removeFirst()
+
+	_, err := story.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, calls)
+
+	calls = nil
+	require.NoError(t, story.RemoveVariableObservers("x"))
+	_, err = story.Continue()
+	require.NoError(t, err)
+	assert.Empty(t, calls)
+}
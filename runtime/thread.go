@@ -43,19 +43,7 @@ func NewThreadFromJObject(jThreadObj map[string]interface{}, storyContext *Story
 		pointer := NullPointer
 
 		if currentContainerPathStrToken, ok := jElementObj["cPath"]; ok {
-
-			currentContainerPathStr := currentContainerPathStrToken.(string)
-			threadPointerResult := storyContext.ContentAtPath(NewPathFromString(currentContainerPathStr))
-			pointer.Container = threadPointerResult.Container()
-			pointer.Index = jElementObj["idx"].(int)
-
-			if threadPointerResult.Obj == nil {
-				panic("When loading state, internal story location couldn't be found: " + currentContainerPathStr + ". Has the story changed since this save data was created?")
-			}
-
-			if threadPointerResult.Approximate {
-				storyContext.Warning("When loading state, exact internal story location couldn't be found: '" + currentContainerPathStr + "', so it was approximated to '" + pointer.Container.Path(pointer.Container).String() + "' to recover. Has the story changed since this save data was created?")
-			}
+			pointer = loadedElementPointer(storyContext, currentContainerPathStrToken.(string), jElementObj["idx"].(int))
 		}
 
 		inExpressionEvaluation := jElementObj["exp"].(bool)
@@ -78,6 +66,28 @@ func NewThreadFromJObject(jThreadObj map[string]interface{}, storyContext *Story
 	return newThread
 }
 
+// loadedElementPointer
+// Finds the pointer for a call stack element loaded from a save, warning
+// if the story has changed so that it can only be found approximately.
+func loadedElementPointer(storyContext *Story, currentContainerPathStr string, index int) Pointer {
+
+	pointer := NullPointer
+
+	threadPointerResult := storyContext.ContentAtPath(NewPathFromString(currentContainerPathStr))
+	pointer.Container = threadPointerResult.Container()
+	pointer.Index = index
+
+	if threadPointerResult.Obj == nil {
+		panic(NewStoryException("When loading state, internal story location couldn't be found: " + currentContainerPathStr + ". Has the story changed since this save data was created?"))
+	}
+
+	if threadPointerResult.Approximate {
+		storyContext.Warning("When loading state, exact internal story location couldn't be found: '" + currentContainerPathStr + "', so it was approximated to '" + pointer.Container.Path(pointer.Container).String() + "' to recover. Has the story changed since this save data was created?")
+	}
+
+	return pointer
+}
+
 func (s *Thread) Copy() *Thread {
 
 	threadCopy := NewThread()
@@ -91,4 +101,41 @@ func (s *Thread) Copy() *Thread {
 	return threadCopy
 }
 
-// TODO: Thread.WriteJson
+func (s *Thread) WriteJson(writer *Writer) {
+
+	writer.WriteObjectStart()
+
+	// callstack
+	writer.WritePropertyStart("callstack")
+	writer.WriteArrayStart()
+	for _, el := range s._elements {
+		writer.WriteObjectStart()
+		if !el.CurrentPointer.IsNull() {
+			writer.WriteStringProperty("cPath", el.CurrentPointer.Container.Path(el.CurrentPointer.Container).ComponentsString())
+			writer.WriteIntProperty("idx", el.CurrentPointer.Index)
+		}
+
+		writer.WriteBoolProperty("exp", el.InExpressionEvaluation)
+		writer.WriteIntProperty("type", int(el.PushPopType()))
+
+		if len(el.TemporaryVariables) > 0 {
+			writer.WritePropertyStart("temp")
+			WriteDictionaryRuntimeObjs(writer, el.TemporaryVariables)
+			writer.WritePropertyEnd()
+		}
+
+		writer.WriteObjectEnd()
+	}
+	writer.WriteArrayEnd()
+	writer.WritePropertyEnd()
+
+	// threadIndex
+	writer.WriteIntProperty("threadIndex", s.ThreadIndex)
+
+	if !s.PreviousPointer.IsNull() {
+		resolvedPointer := s.PreviousPointer.Resolve()
+		writer.WriteStringProperty("previousContentObject", resolvedPointer.Path(resolvedPointer).String())
+	}
+
+	writer.WriteObjectEnd()
+}
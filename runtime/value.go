@@ -89,9 +89,9 @@ func CreateValue(val interface{}) Value {
 	return nil
 }
 
-func BadCastException(this Value, targetType ValueType) string {
+func BadCastException(this Value, targetType ValueType) *StoryException {
 
-	return fmt.Sprintf("Can't cast %v from %v to %v", fmt.Sprint(this.ValueObject()), fmt.Sprint(this.ValueType()), targetType)
+	return NewStoryException(fmt.Sprintf("Can't cast %v from %v to %v", fmt.Sprint(this.ValueObject()), fmt.Sprint(this.ValueType()), targetType))
 }
 
 var _ ValueT[bool] = (*BoolValue)(nil)
@@ -420,7 +420,7 @@ func (s *DivertTargetValue) ValueType() ValueType {
 }
 
 func (s *DivertTargetValue) IsTruthy() bool {
-	panic("Shouldn't be checking the truthiness of a divert target")
+	panic(NewStoryException("Shouldn't be checking the truthiness of a divert target"))
 }
 
 func (s *DivertTargetValue) ValueObject() interface{} {
@@ -481,7 +481,7 @@ func (s *VariablePointerValue) ValueType() ValueType {
 }
 
 func (s *VariablePointerValue) IsTruthy() bool {
-	panic("Shouldn't be checking the truthiness of a variable pointer")
+	panic(NewStoryException("Shouldn't be checking the truthiness of a variable pointer"))
 }
 
 func (s *VariablePointerValue) ValueObject() interface{} {
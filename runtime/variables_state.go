@@ -1,8 +1,10 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 )
 
 type VariableChanged func(variableName string, newValue Object)
@@ -34,6 +36,7 @@ type VariablesState struct {
 	_callStack                     *CallStack
 	_changedVariablesForBatchObs   map[string]struct{}
 	_listDefsOrigin                *ListDefinitionsOrigin
+	_story                         *Story
 }
 
 func (s *VariablesState) SetBatchObservingVariableChanges(value bool) {
@@ -45,9 +48,16 @@ func (s *VariablesState) SetBatchObservingVariableChanges(value bool) {
 	} else {
 
 		// Finished observing variables in a batch - now send
-		// notifications for changed variables all in one go.
+		// notifications for changed variables all in one go,
+		// in a stable order.
 		if s._changedVariablesForBatchObs != nil {
-			for variableName, _ := range s._changedVariablesForBatchObs {
+			changedVariables := make([]string, 0, len(s._changedVariablesForBatchObs))
+			for variableName := range s._changedVariablesForBatchObs {
+				changedVariables = append(changedVariables, variableName)
+			}
+			sort.Strings(changedVariables)
+
+			for _, variableName := range changedVariables {
 				currentValue := s._globalVariables[variableName]
 				if s.VariableChangedEvent != nil {
 					s.VariableChangedEvent.Emit(variableName, currentValue)
@@ -98,24 +108,45 @@ func (s *VariablesState) GetVariable(variableName string) interface{} {
 	return nil
 }
 
-func (s *VariablesState) Set(variableName string, value interface{}) {
+// enter
+// Marks the story the variables belong to as busy while they're set, as
+// Story.enter does.
+func (s *VariablesState) enter() (exit func(), err error) {
+
+	if s._story == nil {
+		return func() {}, nil
+	}
+
+	return s._story.enter()
+}
+
+// Set
+// Sets the value of a global ink variable. The variable must have been
+// declared in the story, and the value must be an int, float, string,
+// bool or InkList.
+func (s *VariablesState) Set(variableName string, value interface{}) error {
+
+	exit, err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer exit()
 
 	if _, ok := s._defaultGlobalVariables[variableName]; !ok {
-		// StoryException
-		// TODO: probably shouldn't panic, return error instead
-		panic("Cannot assign to a variable (" + variableName + ") that hasn't been declared in the story")
+		return NewStoryException("Cannot assign to a variable (" + variableName + ") that hasn't been declared in the story")
 	}
 
 	val := CreateValue(value)
 	if val == nil {
 		if value == nil {
-			panic("Cannot pass null to VariableState")
+			return errors.New("cannot pass nil to VariablesState")
 		}
 
-		panic(fmt.Sprintf("Invalid value passed to VariableState: %v", value))
+		return fmt.Errorf("invalid value passed to VariablesState: %v", value)
 	}
 
 	s.SetGlobal(variableName, val)
+	return nil
 }
 
 func NewVariablesState(callStack *CallStack, listDefsOrigin *ListDefinitionsOrigin) *VariablesState {
@@ -145,11 +176,25 @@ func (s *VariablesState) ApplyPatch() {
 
 func (s *VariablesState) SetJsonToken(jToken map[string]interface{}) {
 
+	loaded := make(map[string]Object, len(jToken))
+	for varValKey, loadedToken := range jToken {
+		loaded[varValKey] = JTokenToRuntimeObject(loadedToken)
+	}
+
+	s.setLoadedGlobals(loaded)
+}
+
+// setLoadedGlobals
+// Replaces the globals with those read from a save. Only globals the
+// story declares are loaded, and any the save doesn't have are reset to
+// their defaults.
+func (s *VariablesState) setLoadedGlobals(loaded map[string]Object) {
+
 	ClearMap(s._globalVariables)
 
 	for varValKey, varValValue := range s._defaultGlobalVariables {
-		if loadedToken, ok := jToken[varValKey]; ok {
-			s._globalVariables[varValKey] = JTokenToRuntimeObject(loadedToken)
+		if loadedValue, ok := loaded[varValKey]; ok {
+			s._globalVariables[varValKey] = loadedValue
 		} else {
 			s._globalVariables[varValKey] = varValValue
 		}
@@ -170,7 +215,9 @@ var DontSaveDefaultValues = true
 func (s *VariablesState) WriteJson(writer *Writer) {
 
 	writer.WriteObjectStart()
-	for name, val := range s._globalVariables {
+	for _, name := range SortedKeys(s._globalVariables) {
+
+		val := s._globalVariables[name]
 
 		if DontSaveDefaultValues {
 			// Don't write out values that are the same as the default global values
@@ -190,7 +237,7 @@ func (s *VariablesState) WriteJson(writer *Writer) {
 
 func (s *VariablesState) RuntimeObjectsEqual(obj1 Object, obj2 Object) bool {
 
-	if reflect.TypeOf(obj1).Name() != reflect.TypeOf(obj2).Name() {
+	if reflect.TypeOf(obj1) != reflect.TypeOf(obj2) {
 		return false
 	}
 
@@ -209,24 +256,28 @@ func (s *VariablesState) RuntimeObjectsEqual(obj1 Object, obj2 Object) bool {
 		return floatVal.Value() == obj2.(*FloatValue).Value()
 	}
 
-	// TODO: clean this up
-	// e.g. InkList does not implement correct equals
-	val1, _ := obj1.(Value)
-	val2, _ := obj2.(Value)
+	// The value objects are compared as C#'s Equals would
+	stringVal, ok := obj1.(*StringValue)
+	if ok {
+		return stringVal.Value() == obj2.(*StringValue).Value()
+	}
 
-	if val1 != nil {
+	listVal, ok := obj1.(*ListValue)
+	if ok {
+		return listVal.Value().Equals(obj2.(*ListValue).Value())
+	}
 
-		equalsObj1, ok1 := val1.ValueObject().(Equals)
-		equalsObj2, ok2 := val2.ValueObject().(Equals)
+	divertTargetVal, ok := obj1.(*DivertTargetValue)
+	if ok {
+		return divertTargetVal.Value().Equals(obj2.(*DivertTargetValue).Value())
+	}
 
-		if ok1 && ok2 {
-			return equalsObj1.Equals(equalsObj2)
-		} else {
-			return equalsObj1 == equalsObj2
-		}
+	varPointerVal, ok := obj1.(*VariablePointerValue)
+	if ok {
+		return varPointerVal.Value() == obj2.(*VariablePointerValue).Value()
 	}
 
-	panic("FastRoughDefinitelyEquals: Unsupported runtime object type: " + reflect.TypeOf(obj1).Name())
+	panic("FastRoughDefinitelyEquals: Unsupported runtime object type: " + reflect.TypeOf(obj1).String())
 }
 
 func (s *VariablesState) GlobalVariableExistsWithName(name string) bool {
@@ -244,6 +295,25 @@ func (s *VariablesState) GlobalVariableExistsWithName(name string) bool {
 	return false
 }
 
+// GlobalVariableNames
+// The names of all the global variables, in alphabetical order.
+func (s *VariablesState) GlobalVariableNames() []string {
+
+	names := make([]string, 0, len(s._globalVariables))
+	for name := range s._globalVariables {
+		names = append(names, name)
+	}
+
+	for name := range s._defaultGlobalVariables {
+		if _, ok := s._globalVariables[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
 // GetVariableWithName
 // (default) contextIndex: -1
 func (s *VariablesState) GetVariableWithName(name string, contextIndex int) Object {
@@ -362,9 +432,11 @@ func (s *VariablesState) SetGlobal(variableName string, value Object) {
 	var oldValue Object
 	ok := false
 	if s.Patch != nil {
-		if oldValue, ok = s.Patch.TryGetGlobal(variableName); !ok {
-			oldValue, ok = s._globalVariables[variableName]
-		}
+		oldValue, ok = s.Patch.TryGetGlobal(variableName)
+	}
+
+	if !ok {
+		oldValue = s._globalVariables[variableName]
 	}
 
 	RetainListOriginsForAssignment(oldValue, value)
@@ -0,0 +1,22 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetGlobalRetainsListOrigins(t *testing.T) {
+
+	variablesState := NewVariablesState(nil, nil)
+
+	list := NewInkList()
+	list.SetInitialOriginName("letters")
+	variablesState._globalVariables["x"] = NewListValueFromList(list)
+
+	// Assigning an empty list keeps the origin of the one it replaces
+	emptyList := NewListValue()
+	variablesState.SetGlobal("x", emptyList)
+
+	assert.Equal(t, []string{"letters"}, emptyList.Value().OriginNames())
+}